@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+)
+
+// readActivity decodes and validates an activity and checks that it lies
+// within the project's timeline. It responds itself if that fails.
+func (s *Server) readActivity(w http.ResponseWriter, r *http.Request) (Activity, bool) {
+	project, err := s.store.GetProject(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return Activity{}, false
+	}
+	var input Activity
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Activity{}, false
+	}
+	input.ProjectId = project.ProjectId
+	if input.Kind == "" {
+		input.Kind = ACTIVITY
+	}
+	if input.Status == "" {
+		input.Status = STATUS_PLANNED
+	}
+	if input.EndsOn == "" && input.Kind != ACTIVITY {
+		input.EndsOn = input.StartsOn
+	}
+	errs := input.Validate()
+	if errs == nil {
+		errs = withinTimeline(input, project)
+	}
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Activity{}, false
+	}
+	return input, true
+}
+
+func (s *Server) getActivities(w http.ResponseWriter, r *http.Request) {
+	activities, err := s.store.GetActivities(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{activities, "success"})
+}
+
+func (s *Server) getActivity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	activity, err := s.store.GetActivity(tenantOf(r), vars["projectId"], vars["activityId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{activity, "success"})
+}
+
+func (s *Server) addActivity(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := s.readActivity(w, r)
+	if !ok {
+		return
+	}
+	activityId, err := s.store.AddActivity(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	activity, err := s.store.GetActivity(tenantOf(r), input.ProjectId, activityId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	s.activityAssigned(r, activity, "")
+	JSON(w, http.StatusOK, Response{activity, "success"})
+}
+
+func (s *Server) updateActivity(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	activityId := mux.Vars(r)["activityId"]
+	input, ok := s.readActivity(w, r)
+	if !ok {
+		return
+	}
+	previous, err := s.store.GetActivity(tenantOf(r), input.ProjectId, activityId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	err = s.store.UpdateActivity(tenantOf(r), input.ProjectId, activityId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	activity, err := s.store.GetActivity(tenantOf(r), input.ProjectId, activityId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.activityAssigned(r, activity, previous.OwnerId)
+
+	JSON(w, http.StatusOK, Response{activity, "success"})
+}
+
+// activityAssigned tells the owner of an activity about it, unless they
+// owned it before.
+func (s *Server) activityAssigned(r *http.Request, a Activity, previousOwner string) {
+	if a.OwnerId == "" || a.OwnerId == previousOwner {
+		return
+	}
+	user := context.Get(r, USER).(User)
+	when := a.StartsOn
+	if a.EndsOn != a.StartsOn {
+		when += " to " + a.EndsOn
+	}
+	s.notify(r, Event{
+		Kind:       NOTIFY_ACTIVITY_ASSIGNED,
+		ProjectId:  a.ProjectId,
+		Recipients: []string{a.OwnerId},
+		Title:      "You own the " + a.Kind + " " + a.Title,
+		Body:       fmt.Sprintf("%s made you the owner of the %s %q, %s.", user.FullName, a.Kind, a.Title, when),
+		Link:       "/projects/" + a.ProjectId + "/activities/" + a.ActivityId,
+	})
+}
+
+func (s *Server) deleteActivity(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.DeleteActivity(tenantOf(r), vars["projectId"], vars["activityId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+// gantt lays out the activities of a project for a Gantt chart.
+func gantt(project Project, activities []Activity) Gantt {
+	g := Gantt{ProjectId: project.ProjectId, ProjectName: project.ProjectName, Items: []GanttItem{}}
+	from, to := timelineDay(project.TimelineFrom), timelineDay(project.TimelineTo)
+	g.Start, g.End = from, to
+	for i, a := range activities {
+		if from == "" && (i == 0 || a.StartsOn < g.Start) {
+			g.Start = a.StartsOn
+		}
+		if to == "" && (i == 0 || a.EndsOn > g.End) {
+			g.End = a.EndsOn
+		}
+		start, _ := time.Parse("2006-01-02", a.StartsOn)
+		end, _ := time.Parse("2006-01-02", a.EndsOn)
+		item := GanttItem{
+			Id:               a.ActivityId,
+			Name:             a.Title,
+			Kind:             a.Kind,
+			Start:            a.StartsOn,
+			End:              a.EndsOn,
+			Days:             int(end.Sub(start).Hours()/24) + 1,
+			Milestone:        a.Kind != ACTIVITY,
+			Status:           a.Status,
+			OwnerName:        a.OwnerName,
+			StrategyId:       a.StrategyId,
+			ProgressMarkerId: a.ProgressMarkerId,
+		}
+		if a.Status == STATUS_DONE {
+			item.Progress = 100
+		}
+		g.Items = append(g.Items, item)
+	}
+	return g
+}
+
+// getGantt returns the schedule of a project for drawing a Gantt chart.
+func (s *Server) getGantt(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+	project, err := s.store.GetProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	activities, err := s.store.GetActivities(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{gantt(project, activities), "success"})
+}
+
+type CalendarLink struct {
+	Url string `json:"url"`
+}
+
+// getCalendarLink returns the URL of the project's calendar feed, which
+// calendar clients can subscribe to without an API key. The first request
+// makes the key of the link.
+func (s *Server) getCalendarLink(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	projectId := mux.Vars(r)["projectId"]
+	key, err := s.store.GetCalendarKey(tenantOf(r), projectId)
+	if err == ErrNotFound {
+		key = uuid.NewV4().String()
+		err = s.store.SetCalendarKey(tenantOf(r), user.UserId, projectId, key)
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.respondCalendarLink(w, user.OrganizationId, projectId, key)
+}
+
+// rotateCalendarLink replaces the key of the calendar feed, so that the
+// links handed out so far stop working, and returns the new link.
+func (s *Server) rotateCalendarLink(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	projectId := mux.Vars(r)["projectId"]
+	key := uuid.NewV4().String()
+	err := s.store.SetCalendarKey(tenantOf(r), user.UserId, projectId, key)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.respondCalendarLink(w, user.OrganizationId, projectId, key)
+}
+
+// revokeCalendarLink stops the calendar feed links of the project from
+// working until a new one is requested.
+func (s *Server) revokeCalendarLink(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	err := s.store.SetCalendarKey(tenantOf(r), user.UserId, mux.Vars(r)["projectId"], "")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) respondCalendarLink(w http.ResponseWriter, organizationId, projectId, key string) {
+	token := createCalendarToken(s.config.AuthSecret, CalendarFeed{organizationId, projectId, key})
+	JSON(w, http.StatusOK, Response{CalendarLink{"/shared/calendars/" + token + ".ics"}, "success"})
+}
+
+func (s *Server) getCalendar(w http.ResponseWriter, r *http.Request) {
+	s.serveCalendar(w, tenantOf(r), mux.Vars(r)["projectId"])
+}
+
+// getSharedCalendar serves the calendar feed to anyone holding its link.
+func (s *Server) getSharedCalendar(w http.ResponseWriter, r *http.Request) {
+	feed, err := parseCalendarToken(s.config.AuthSecret, mux.Vars(r)["token"], s.store.GetCalendarKey)
+	if err == invalidCalendarToken {
+		JSON(w, http.StatusForbidden, Response{nil, err.Error()})
+		return
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.serveCalendar(w, Tenant{feed.OrganizationId}, feed.ProjectId)
+}
+
+// serveCalendar sends the milestones and monitoring dates of a project as an
+// iCalendar feed.
+func (s *Server) serveCalendar(w http.ResponseWriter, t Tenant, projectId string) {
+	project, err := s.store.GetProject(t, projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	activities, err := s.store.GetActivities(t, projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/calendar; charset=utf-8")
+	h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": "project.ics"}))
+	h.Set("Cache-Control", "private, no-cache")
+	w.Write(calendar(project, activities, time.Now()))
+}
+
+// calendar writes the milestones and monitoring dates of a project as
+// all-day events of an iCalendar (RFC 5545) document.
+func calendar(project Project, activities []Activity, now time.Time) []byte {
+	var buf bytes.Buffer
+	line := func(name, value string) {
+		foldLine(&buf, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//Lucid//Project calendar//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", icalText(project.ProjectName))
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, a := range activities {
+		if a.Kind == ACTIVITY {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", a.StartsOn)
+		if err != nil {
+			continue
+		}
+		summary := a.Title
+		if a.Kind == ACTIVITY_MONITORING {
+			summary = "Monitoring: " + a.Title
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", a.ActivityId+"@lucid")
+		line("DTSTAMP", stamp)
+		line("DTSTART;VALUE=DATE", day.Format("20060102"))
+		line("DTEND;VALUE=DATE", day.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY", icalText(summary))
+		if a.Description != "" {
+			line("DESCRIPTION", icalText(a.Description))
+		}
+		line("CATEGORIES", strings.ToUpper(a.Kind))
+		switch a.Status {
+		case STATUS_CANCELLED:
+			line("STATUS", "CANCELLED")
+		default:
+			line("STATUS", "CONFIRMED")
+		}
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return buf.Bytes()
+}
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// icalText escapes a TEXT value.
+func icalText(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+// foldLine writes a content line, folded so that no line is longer than 75
+// octets without splitting a UTF-8 sequence.
+func foldLine(buf *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		fmt.Fprintf(buf, "%s\r\n ", s[:cut])
+		s = s[cut:]
+		// the leading space of a continuation counts towards its length
+		limit = 74
+	}
+	buf.WriteString(s + "\r\n")
+}
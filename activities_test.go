@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestCalendar(t *testing.T) {
+	project := Project{ProjectName: "Radio; Water"}
+	activities := []Activity{
+		{ActivityId: "a1", Kind: ACTIVITY_MILESTONE, Title: "Launch, phase 1", Description: "first\nsecond",
+			StartsOn: "2017-06-01", EndsOn: "2017-06-01", Status: STATUS_PLANNED},
+		{ActivityId: "a2", Kind: ACTIVITY, Title: "Training", StartsOn: "2017-06-01", EndsOn: "2017-06-20", Status: STATUS_DONE},
+		{ActivityId: "a3", Kind: ACTIVITY_MONITORING, Title: "Q2", StartsOn: "2017-06-30", EndsOn: "2017-06-30",
+			Status: STATUS_CANCELLED},
+	}
+	now := time.Date(2017, 5, 2, 10, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Lucid//Project calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		`X-WR-CALNAME:Radio\; Water`,
+		"BEGIN:VEVENT",
+		"UID:a1@lucid",
+		"DTSTAMP:20170502T083000Z",
+		"DTSTART;VALUE=DATE:20170601",
+		"DTEND;VALUE=DATE:20170602",
+		`SUMMARY:Launch\, phase 1`,
+		`DESCRIPTION:first\nsecond`,
+		"CATEGORIES:MILESTONE",
+		"STATUS:CONFIRMED",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:a3@lucid",
+		"DTSTAMP:20170502T083000Z",
+		"DTSTART;VALUE=DATE:20170630",
+		"DTEND;VALUE=DATE:20170701",
+		"SUMMARY:Monitoring: Q2",
+		"CATEGORIES:MONITORING",
+		"STATUS:CANCELLED",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n") + "\r\n"
+	if got := string(calendar(project, activities, now)); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFoldLine(t *testing.T) {
+	for _, s := range []string{
+		"SUMMARY:short",
+		"SUMMARY:" + strings.Repeat("x", 67),
+		"SUMMARY:" + strings.Repeat("x", 200),
+		"SUMMARY:" + strings.Repeat("é", 40),
+		"SUMMARY:" + strings.Repeat("日本", 50),
+	} {
+		var buf bytes.Buffer
+		foldLine(&buf, s)
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > 75 || !utf8.ValidString(line) || (i > 0 && line[0] != ' ') {
+				t.Errorf("%q: line %d %q", s, i, line)
+			}
+		}
+		if unfolded := strings.Replace(buf.String(), "\r\n ", "", -1); unfolded != s+"\r\n" {
+			t.Errorf("%q: unfolds to %q", s, unfolded)
+		}
+		if len(s) <= 75 && len(lines) != 1 {
+			t.Errorf("%q: folded %q", s, lines)
+		}
+	}
+}
+
+// TestCalendarLink subscribes to the calendar feed of a project and checks
+// that rotating or revoking its key stops the links handed out before.
+func TestCalendarLink(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	_, err = ts.store.AddActivity(a, ADMIN_A, Activity{ProjectId: projectId, Kind: ACTIVITY_MILESTONE, Title: "Launch",
+		StartsOn: "2017-06-01", EndsOn: "2017-06-01", Status: STATUS_PLANNED})
+	check(t, err)
+	linkPath := "/projects/" + projectId + "/calendar/link"
+
+	link := func(method string) string {
+		t.Helper()
+		code, out := ts.do(key, method, linkPath, "", "")
+		if code != http.StatusOK {
+			t.Fatal(code, out)
+		}
+		return out["data"].(map[string]interface{})["url"].(string)
+	}
+	subscribe := func(url string, code int) {
+		t.Helper()
+		resp, body := ts.fetch("", url, nil)
+		if resp.StatusCode != code {
+			t.Fatalf("%s: %d %s", url, resp.StatusCode, body)
+		}
+		if code == http.StatusOK && (resp.Header.Get("Content-Type") != "text/calendar; charset=utf-8" ||
+			!strings.Contains(body, "SUMMARY:Launch\r\n")) {
+			t.Fatalf("%s: %v %q", url, resp.Header, body)
+		}
+	}
+
+	first := link("GET")
+	if link("GET") != first {
+		t.Fatal("the link changed without being rotated")
+	}
+	subscribe(first, http.StatusOK)
+
+	rotated := link("POST")
+	if rotated == first {
+		t.Fatal("the link was not rotated")
+	}
+	subscribe(first, http.StatusForbidden)
+	subscribe(rotated, http.StatusOK)
+
+	if code, out := ts.do(key, "DELETE", linkPath, "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	subscribe(rotated, http.StatusForbidden)
+	renewed := link("GET")
+	if renewed == first || renewed == rotated {
+		t.Fatal("a revoked link was handed out again")
+	}
+	subscribe(renewed, http.StatusOK)
+
+	// a project of organization B with a link of its own
+	b := Tenant{ORG_B}
+	projectB, err := ts.store.AddProject(b, Project{ProjectName: "B"})
+	check(t, err)
+	check(t, ts.store.SetCalendarKey(b, ADMIN_B, projectB, "key-b"))
+	keyA, err := ts.store.GetCalendarKey(a, projectId)
+	check(t, err)
+
+	secret := ts.config.AuthSecret
+	token := createCalendarToken(secret, CalendarFeed{ORG_A, projectId, keyA})
+	payload, signature := token[:strings.Index(token, ".")], token[strings.Index(token, ".")+1:]
+	tokenB := createCalendarToken(secret, CalendarFeed{ORG_B, projectB, "key-b"})
+	payloadB := tokenB[:strings.Index(tokenB, ".")]
+	for _, test := range []struct {
+		name  string
+		token string
+	}{
+		{"wrong secret", createCalendarToken("other", CalendarFeed{ORG_A, projectId, keyA})},
+		{"key of another project", createCalendarToken(secret, CalendarFeed{ORG_A, projectId, "key-b"})},
+		{"project of another organization", createCalendarToken(secret, CalendarFeed{ORG_A, projectB, "key-b"})},
+		{"project of organization A as B", createCalendarToken(secret, CalendarFeed{ORG_B, projectId, keyA})},
+		{"payload without signature", payload},
+		{"signature of another payload", payloadB + "." + signature},
+		{"garbage", "not.a-token"},
+	} {
+		if resp, body := ts.fetch("", "/shared/calendars/"+test.token+".ics", nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: %d %s", test.name, resp.StatusCode, body)
+		}
+	}
+	if resp, body := ts.fetch("", "/shared/calendars/"+tokenB+".ics", nil); resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var invalidBlobKey = errors.New("invalid blob key")
+
+// advisory lock space of blobs, each blob is locked with the hash of its key
+// as the second key
+const BLOB_LOCK_ID = 7321402
+
+// how long an upload may take to reference the blob it stored before the
+// blob can be deleted
+const BLOB_PIN_TTL = time.Hour
+
+// blob keys are "<organization id>/<first two hex digits>/<sha256 hex>"
+var blobKeyPattern = regexp.MustCompile(`^[0-9a-fA-F-]{36}/[0-9a-f]{2}/[0-9a-f]{64}$`)
+
+// BlobStore stores uploaded files under content-addressed keys, so the same
+// file uploaded twice is stored once and a new upload can never overwrite a
+// different file.
+type BlobStore interface {
+	// Put stores the content of r in the namespace of an organization.
+	Put(organizationId string, r io.Reader) (BlobInfo, error)
+	// Get opens a blob. The reader is seekable so that byte ranges can be
+	// served without reading the whole blob.
+	Get(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+}
+
+type BlobInfo struct {
+	Key    string
+	Size   int64
+	SHA256 string
+}
+
+func blobKey(organizationId, sum string) string {
+	return organizationId + "/" + sum[:2] + "/" + sum
+}
+
+// blobSHA256 returns the content hash a blob key ends in.
+func blobSHA256(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
+
+func checkBlobKey(key string) error {
+	if !blobKeyPattern.MatchString(key) {
+		return invalidBlobKey
+	}
+	return nil
+}
+
+// spool copies r into a temporary file in dir while hashing it. The file is
+// positioned at its start; the caller removes it.
+func spool(dir string, r io.Reader) (*os.File, string, int64, error) {
+	f, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return nil, "", 0, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", 0, err
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// hashFile returns the SHA-256 of a file in hex and rewinds it.
+func hashFile(file io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sanitizeFilename reduces a client supplied file name to a plain base name
+// that is safe to show and to use in a Content-Disposition header.
+func sanitizeFilename(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	name = filepath.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if len([]rune(name)) > MAX_NAME_LENGTH {
+		name = string([]rune(name)[:MAX_NAME_LENGTH])
+	}
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// localBlobStore keeps blobs in a directory on the local file system.
+type localBlobStore struct {
+	root string
+}
+
+func NewLocalBlobStore(root string) (*localBlobStore, error) {
+	if err := os.MkdirAll(root, 0750); err != nil {
+		return nil, err
+	}
+	return &localBlobStore{root}, nil
+}
+
+func (s *localBlobStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *localBlobStore) Put(organizationId string, r io.Reader) (BlobInfo, error) {
+	if !isUUID(organizationId) {
+		return BlobInfo{}, invalidBlobKey
+	}
+	f, sum, size, err := spool(s.root, r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	info := BlobInfo{blobKey(organizationId, sum), size, sum}
+	target := s.path(info.Key)
+	if err = os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return BlobInfo{}, err
+	}
+	if err = os.Chmod(f.Name(), 0640); err != nil {
+		return BlobInfo{}, err
+	}
+	// the rename is atomic, readers see either no file or the complete one
+	if err = os.Rename(f.Name(), target); err != nil {
+		return BlobInfo{}, err
+	}
+	return info, nil
+}
+
+func (s *localBlobStore) Get(key string) (io.ReadSeekCloser, error) {
+	if err := checkBlobKey(key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// newBlobStore creates the blob store selected in the config.
+func newBlobStore(config *Config) (BlobStore, error) {
+	switch config.Storage.Backend {
+	case "local":
+		return NewLocalBlobStore(config.UploadDir)
+	case "s3":
+		return NewS3BlobStore(config.Storage.S3, config.UploadDir)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", config.Storage.Backend)
+}
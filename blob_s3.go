@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sha256 of an empty payload, used for requests without a body
+const EMPTY_PAYLOAD_SHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3BlobStore keeps blobs in a bucket of an S3 compatible object store such
+// as AWS S3 or MinIO. Requests are signed with AWS Signature Version 4.
+type s3BlobStore struct {
+	endpoint  *url.URL
+	config    S3Config
+	scratch   string
+	client    *http.Client
+	timestamp func() time.Time
+}
+
+func NewS3BlobStore(config S3Config, scratchDir string) (*s3BlobStore, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if err = os.MkdirAll(scratchDir, 0750); err != nil {
+		return nil, err
+	}
+	return &s3BlobStore{endpoint, config, scratchDir, &http.Client{Timeout: 5 * time.Minute}, time.Now}, nil
+}
+
+func (s *s3BlobStore) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return &u
+}
+
+func (s *s3BlobStore) Put(organizationId string, r io.Reader) (BlobInfo, error) {
+	if !isUUID(organizationId) {
+		return BlobInfo{}, invalidBlobKey
+	}
+	f, sum, size, err := spool(s.scratch, r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	info := BlobInfo{blobKey(organizationId, sum), size, sum}
+	req, err := http.NewRequest("PUT", s.objectURL(info.Key).String(), ioutil.NopCloser(f))
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, sum)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp.Body.Close()
+	return info, nil
+}
+
+func (s *s3BlobStore) Get(key string) (io.ReadSeekCloser, error) {
+	if err := checkBlobKey(key); err != nil {
+		return nil, err
+	}
+	o := &s3Object{store: s, key: key}
+	resp, err := o.get(0)
+	if err != nil {
+		return nil, err
+	}
+	o.size = resp.ContentLength
+	o.body = resp.Body
+	// a chunked response does not tell the size, which Read and Seek need
+	if o.size < 0 {
+		if o.size, err = s.size(key); err != nil {
+			o.Close()
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// size returns the size of an object from a HEAD request.
+func (s *s3BlobStore) size(key string) (int64, error) {
+	req, err := http.NewRequest("HEAD", s.objectURL(key).String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(req, EMPTY_PAYLOAD_SHA256)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("s3 HEAD %s: no Content-Length", req.URL.Path)
+	}
+	return resp.ContentLength, nil
+}
+
+// s3Object reads an object sequentially from a single response and starts a
+// ranged GET when it is read from another offset after a Seek.
+type s3Object struct {
+	store   *s3BlobStore
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+	bodyPos int64
+}
+
+func (o *s3Object) get(offset int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", o.store.objectURL(o.key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	return o.store.do(req, EMPTY_PAYLOAD_SHA256)
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body != nil && o.bodyPos != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		resp, err := o.get(o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyPos = resp.Body, o.offset
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyPos += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of object")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+func (s *s3BlobStore) Delete(key string) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("DELETE", s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, EMPTY_PAYLOAD_SHA256)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do signs and sends a request. Responses other than 2xx are turned into
+// errors, a missing object into ErrNotFound.
+func (s *s3BlobStore) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (s *s3BlobStore) sign(req *http.Request, payloadHash string) {
+	now := s.timestamp().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
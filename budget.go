@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// total fills in the totals of a report from its lines and unallocated
+// expenditures.
+func (report *BudgetReport) total() {
+	report.Spent = report.Unallocated
+	for i := range report.Lines {
+		line := &report.Lines[i]
+		line.Remaining = line.Budget - line.Spent
+		line.Consumed = line.Spent.Percent(line.Budget)
+		report.Spent += line.Spent
+	}
+	report.Remaining = report.Budget - report.Spent
+	report.Consumed = report.Spent.Percent(report.Budget)
+}
+
+// toProjectCurrency converts an amount into the currency of a project at the
+// rate of the given day.
+func (s *Server) toProjectCurrency(amount Amount, currency, projectCurrency string, on time.Time) (Amount, error) {
+	rate, err := s.rates.Rate(currency, projectCurrency, on)
+	if err == ErrNoRate {
+		return 0, FieldErrors{"currency": fmt.Sprintf("can not be converted into %s, no exchange rate is known", projectCurrency)}
+	}
+	if err != nil {
+		return 0, err
+	}
+	converted, err := convert(amount, rate, currencies[projectCurrency])
+	if err != nil {
+		return 0, FieldErrors{"amount": err.Error()}
+	}
+	return converted, nil
+}
+
+// readBudgetLine decodes and validates a budget line and converts its amount
+// at today's rate. It responds itself if that fails.
+func (s *Server) readBudgetLine(w http.ResponseWriter, r *http.Request) (BudgetLine, bool) {
+	project, err := s.store.GetProject(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return BudgetLine{}, false
+	}
+	var input BudgetLine
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return BudgetLine{}, false
+	}
+	input.ProjectId = project.ProjectId
+	input.Currency = strings.ToUpper(input.Currency)
+	if input.Currency == "" {
+		input.Currency = project.Currency
+	}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return BudgetLine{}, false
+	}
+	input.ProjectAmount, err = s.toProjectCurrency(input.Amount, input.Currency, project.Currency, time.Now())
+	if err != nil {
+		respondError(w, err)
+		return BudgetLine{}, false
+	}
+	return input, true
+}
+
+// readExpenditure decodes and validates an expenditure and converts its
+// amount at the rate of the day it was spent. It responds itself if that
+// fails.
+func (s *Server) readExpenditure(w http.ResponseWriter, r *http.Request) (Expenditure, bool) {
+	project, err := s.store.GetProject(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return Expenditure{}, false
+	}
+	var input Expenditure
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Expenditure{}, false
+	}
+	input.ProjectId = project.ProjectId
+	input.Currency = strings.ToUpper(input.Currency)
+	if input.Currency == "" {
+		input.Currency = project.Currency
+	}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Expenditure{}, false
+	}
+	spentOn, _ := time.Parse("2006-01-02", input.SpentOn)
+	input.ProjectAmount, err = s.toProjectCurrency(input.Amount, input.Currency, project.Currency, spentOn)
+	if err != nil {
+		respondError(w, err)
+		return Expenditure{}, false
+	}
+	return input, true
+}
+
+func (s *Server) getBudgetLines(w http.ResponseWriter, r *http.Request) {
+	lines, err := s.store.GetBudgetLines(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{lines, "success"})
+}
+
+func (s *Server) addBudgetLine(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := s.readBudgetLine(w, r)
+	if !ok {
+		return
+	}
+	lineId, err := s.store.AddBudgetLine(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	line, err := s.store.GetBudgetLine(tenantOf(r), input.ProjectId, lineId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{line, "success"})
+}
+
+func (s *Server) updateBudgetLine(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	lineId := mux.Vars(r)["budgetLineId"]
+	input, ok := s.readBudgetLine(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.UpdateBudgetLine(tenantOf(r), input.ProjectId, lineId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	line, err := s.store.GetBudgetLine(tenantOf(r), input.ProjectId, lineId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{line, "success"})
+}
+
+func (s *Server) deleteBudgetLine(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.DeleteBudgetLine(tenantOf(r), vars["projectId"], vars["budgetLineId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) getExpenditures(w http.ResponseWriter, r *http.Request) {
+	expenditures, err := s.store.GetExpenditures(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{expenditures, "success"})
+}
+
+func (s *Server) addExpenditure(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := s.readExpenditure(w, r)
+	if !ok {
+		return
+	}
+	expenditureId, err := s.store.AddExpenditure(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	e, err := s.store.GetExpenditure(tenantOf(r), input.ProjectId, expenditureId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{e, "success"})
+}
+
+func (s *Server) updateExpenditure(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	expenditureId := mux.Vars(r)["expenditureId"]
+	input, ok := s.readExpenditure(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.UpdateExpenditure(tenantOf(r), input.ProjectId, expenditureId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	e, err := s.store.GetExpenditure(tenantOf(r), input.ProjectId, expenditureId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{e, "success"})
+}
+
+func (s *Server) deleteExpenditure(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.DeleteExpenditure(tenantOf(r), vars["projectId"], vars["expenditureId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+// getBudgetReport compares the budget lines of a project with the
+// expenditures on them.
+func (s *Server) getBudgetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := s.store.GetBudgetReport(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{report, "success"})
+}
+
+func (s *Server) updateProjectCurrency(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	projectId := mux.Vars(r)["projectId"]
+	currency := strings.ToUpper(r.FormValue("project_currency"))
+	if errs := validate(
+		required("project_currency", currency),
+		currencyCode("project_currency", currency),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+
+	err := s.store.SetProjectCurrency(tenantOf(r), projectId, currency)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// mentionPattern matches a mention in a comment body, written by clients as
+// @[Full Name](user_id).
+var mentionPattern = regexp.MustCompile(`@\[([^\]]+)\]\(([^)\s]+)\)`)
+
+// parseMentions returns the IDs of the users mentioned in a comment body,
+// each once.
+func parseMentions(body string) []string {
+	mentions := []string{}
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[m[2]] {
+			seen[m[2]] = true
+			mentions = append(mentions, m[2])
+		}
+	}
+	return mentions
+}
+
+// readComment decodes and validates a comment. It responds itself if that
+// fails.
+func readComment(w http.ResponseWriter, r *http.Request) (Comment, bool) {
+	var input Comment
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Comment{}, false
+	}
+	input.ProjectId = mux.Vars(r)["projectId"]
+	if input.EntityType == ENTITY_PROJECT && input.EntityId == "" {
+		input.EntityId = input.ProjectId
+	}
+	input.Mentions = parseMentions(input.Body)
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Comment{}, false
+	}
+	return input, true
+}
+
+// threads nests the replies in a list of comments, oldest first, under the
+// comments that start their threads.
+func threads(comments []Comment) []Comment {
+	replies := make(map[string][]Comment)
+	for _, c := range comments {
+		if c.ParentId != "" {
+			replies[c.ParentId] = append(replies[c.ParentId], c)
+		}
+	}
+	threads := []Comment{}
+	for _, c := range comments {
+		if c.ParentId == "" {
+			c.Replies = replies[c.CommentId]
+			threads = append(threads, c)
+		}
+	}
+	return threads
+}
+
+// getComments returns the comment threads of a project, or only those on the
+// entity given by the entity_type and entity_id query parameters.
+func (s *Server) getComments(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+	query := r.URL.Query()
+	entityType, entityId := query.Get("entity_type"), query.Get("entity_id")
+	if entityType == ENTITY_PROJECT && entityId == "" {
+		entityId = projectId
+	}
+	if entityType != "" {
+		filter := Comment{EntityType: entityType, EntityId: entityId, Body: "-"}
+		if errs := filter.Validate(); errs != nil {
+			JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+			return
+		}
+	}
+	comments, err := s.store.GetComments(tenantOf(r), projectId, entityType, entityId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{threads(comments), "success"})
+}
+
+// addComment starts a thread or, given a parent_id, replies to one.
+func (s *Server) addComment(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	input, ok := readComment(w, r)
+	if !ok {
+		return
+	}
+	commentId, err := s.store.AddComment(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	comment, err := s.store.GetComment(tenantOf(r), input.ProjectId, commentId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.commentMentions(r, comment, comment.Mentions)
+	if comment.ParentId != "" {
+		s.commentReplied(r, comment)
+	}
+
+	JSON(w, http.StatusOK, Response{comment, "success"})
+}
+
+// commentText returns the body of a comment with mentions as @Name.
+func commentText(c Comment) string {
+	return mentionPattern.ReplaceAllString(c.Body, "@$1")
+}
+
+func commentLink(c Comment) string {
+	query := url.Values{"entity_type": {c.EntityType}, "entity_id": {c.EntityId}}
+	return "/projects/" + c.ProjectId + "/comments?" + query.Encode()
+}
+
+// commentMentions tells the users mentioned in a comment about it.
+func (s *Server) commentMentions(r *http.Request, c Comment, mentions []string) {
+	user := context.Get(r, USER).(User)
+	s.notify(r, Event{
+		Kind:       NOTIFY_MENTION,
+		ProjectId:  c.ProjectId,
+		Recipients: mentions,
+		Title:      user.FullName + " mentioned you in a comment",
+		Body:       commentText(c),
+		Link:       commentLink(c),
+	})
+}
+
+// commentReplied tells the others in a thread about a reply to it. Those
+// mentioned in the reply have been told already.
+func (s *Server) commentReplied(r *http.Request, reply Comment) {
+	comments, err := s.store.GetComments(tenantOf(r), reply.ProjectId, reply.EntityType, reply.EntityId)
+	if err != nil {
+		errorf("notify reply %s: %v", reply.CommentId, err)
+		return
+	}
+	mentioned := make(map[string]bool)
+	for _, userId := range reply.Mentions {
+		mentioned[userId] = true
+	}
+	var recipients []string
+	for _, c := range comments {
+		if (c.CommentId == reply.ParentId || c.ParentId == reply.ParentId) && !mentioned[c.AuthorId] {
+			recipients = append(recipients, c.AuthorId)
+		}
+	}
+	user := context.Get(r, USER).(User)
+	s.notify(r, Event{
+		Kind:       NOTIFY_REPLY,
+		ProjectId:  reply.ProjectId,
+		Recipients: recipients,
+		Title:      user.FullName + " replied in a discussion",
+		Body:       commentText(reply),
+		Link:       commentLink(reply),
+	})
+}
+
+// ownComment returns the comment in the URL if the caller wrote it. It
+// responds itself otherwise.
+func (s *Server) ownComment(w http.ResponseWriter, r *http.Request) (Comment, bool) {
+	user := context.Get(r, USER).(User)
+	vars := mux.Vars(r)
+	comment, err := s.store.GetComment(tenantOf(r), vars["projectId"], vars["commentId"])
+	if err != nil {
+		respondError(w, err)
+		return Comment{}, false
+	}
+	if comment.AuthorId != user.UserId {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return Comment{}, false
+	}
+	return comment, true
+}
+
+// updateComment changes the body of a comment. Only its author can.
+func (s *Server) updateComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := s.ownComment(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Body string `json:"body"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return
+	}
+	if errs := validate(
+		required("body", input.Body),
+		maxLength("body", input.Body, MAX_TEXT_LENGTH),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	err := s.store.UpdateComment(tenantOf(r), comment.ProjectId, comment.CommentId, input.Body, parseMentions(input.Body))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	previous := comment
+	comment, err = s.store.GetComment(tenantOf(r), comment.ProjectId, comment.CommentId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	// only those newly mentioned are told
+	mentioned := make(map[string]bool)
+	for _, userId := range previous.Mentions {
+		mentioned[userId] = true
+	}
+	var added []string
+	for _, userId := range comment.Mentions {
+		if !mentioned[userId] {
+			added = append(added, userId)
+		}
+	}
+	s.commentMentions(r, comment, added)
+
+	JSON(w, http.StatusOK, Response{comment, "success"})
+}
+
+// deleteComment deletes a comment with its replies. Only its author can.
+func (s *Server) deleteComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := s.ownComment(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.DeleteComment(tenantOf(r), comment.ProjectId, comment.CommentId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) resolveComment(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	s.setCommentResolved(w, r, user.UserId)
+}
+
+func (s *Server) unresolveComment(w http.ResponseWriter, r *http.Request) {
+	s.setCommentResolved(w, r, "")
+}
+
+// setCommentResolved resolves a thread on behalf of userId, or reopens it if
+// userId is empty.
+func (s *Server) setCommentResolved(w http.ResponseWriter, r *http.Request, userId string) {
+	vars := mux.Vars(r)
+	comment, err := s.store.GetComment(tenantOf(r), vars["projectId"], vars["commentId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if comment.ParentId != "" {
+		JSON(w, http.StatusBadRequest, Response{nil, "Only the comment that starts a thread can be resolved"})
+		return
+	}
+	err = s.store.SetCommentResolved(tenantOf(r), comment.ProjectId, comment.CommentId, userId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	comment, err = s.store.GetComment(tenantOf(r), comment.ProjectId, comment.CommentId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{comment, "success"})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no mentions", []string{}},
+		{"@[Ada](" + ADMIN_A + ") and @[Ann](" + MEMBER_A + ")", []string{ADMIN_A, MEMBER_A}},
+		{"@[Ann](" + MEMBER_A + ") twice @[Ann](" + MEMBER_A + ")", []string{MEMBER_A}},
+		{"@Ann, @[Ann] and [Ann](" + MEMBER_A + ")", []string{}},
+		{"@[Ann]( " + MEMBER_A + ")", []string{}},
+		{"mail ann@a.org", []string{}},
+	}
+	for _, test := range tests {
+		if got := parseMentions(test.body); !equalStrings(got, test.want) {
+			t.Errorf("%q: %q", test.body, got)
+		}
+	}
+}
+
+// TestComments discusses a progress marker in a thread with mentions, edits,
+// resolves and deletes it.
+func TestComments(t *testing.T) {
+	ts := newTestServer(t)
+	admin, member := ts.login("ada@a.org"), ts.login("ann@a.org")
+	a := Tenant{ORG_A}
+	projectId, markerId, _, _ := addTestMarker(t, ts.store)
+	commentsPath := "/projects/" + projectId + "/comments"
+	otherProject, err := ts.store.AddProject(a, Project{ProjectName: "Other"})
+	check(t, err)
+
+	add := func(key, body string) (int, map[string]interface{}) {
+		t.Helper()
+		code, out := ts.do(key, "POST", commentsPath, "application/json", body)
+		data, _ := out["data"].(map[string]interface{})
+		return code, data
+	}
+	onMarker := `"entity_type":"progress_marker","entity_id":"` + markerId + `"`
+
+	for _, test := range []struct {
+		name, body, field string
+	}{
+		{"unknown entity", `{"entity_type":"journal","entity_id":"` + markerId + `","body":"x"}`, "entity_type"},
+		{"no body", `{` + onMarker + `}`, "body"},
+		{"entity of another project", `{"entity_type":"project","entity_id":"` + otherProject + `","body":"x"}`, "entity_id"},
+		{"mention of another organization", `{` + onMarker + `,"body":"@[Bo](` + ADMIN_B + `)"}`, "mentions"},
+	} {
+		if code, errs := add(admin, test.body); code != http.StatusBadRequest || errs[test.field] == nil {
+			t.Errorf("%s: %d %v", test.name, code, errs)
+		}
+	}
+
+	code, thread := add(member, `{`+onMarker+`,"body":"@[Ada](`+ADMIN_A+`) is this marker too ambitious?"}`)
+	if code != http.StatusOK || thread["author_name"] != "Ann" || thread["resolved"] != false ||
+		len(thread["mentions"].([]interface{})) != 1 || thread["mentions"].([]interface{})[0] != ADMIN_A {
+		t.Fatal(code, thread)
+	}
+	threadId := thread["comment_id"].(string)
+	threadPath := commentsPath + "/" + threadId
+	code, reply := add(admin, `{`+onMarker+`,"parent_id":"`+threadId+`","body":"No, keep it"}`)
+	if code != http.StatusOK || reply["parent_id"] != threadId {
+		t.Fatal(code, reply)
+	}
+	replyPath := commentsPath + "/" + reply["comment_id"].(string)
+	// replies are not nested and stay on the entity of their thread
+	if code, errs := add(member, `{`+onMarker+`,"parent_id":"`+reply["comment_id"].(string)+`","body":"x"}`); code != http.StatusBadRequest || errs["parent_id"] == nil {
+		t.Fatal(code, errs)
+	}
+	if code, errs := add(member, `{"entity_type":"project","parent_id":"`+threadId+`","body":"x"}`); code != http.StatusBadRequest || errs["parent_id"] == nil {
+		t.Fatal(code, errs)
+	}
+	if code, project := add(member, `{"entity_type":"project","body":"Kick-off on Monday"}`); code != http.StatusOK || project["entity_id"] != projectId {
+		t.Fatal(code, project)
+	}
+
+	// only the author edits or deletes a comment
+	if code, out := ts.do(admin, "POST", threadPath, "application/json", `{"body":"hijacked"}`); code != http.StatusForbidden {
+		t.Fatal(code, out)
+	}
+	if code, out := ts.do(admin, "DELETE", threadPath, "", ""); code != http.StatusForbidden {
+		t.Fatal(code, out)
+	}
+	code, out := ts.do(member, "POST", threadPath, "application/json", `{"body":"@[Ann](`+MEMBER_A+`) note to self"}`)
+	if edited := out["data"].(map[string]interface{}); code != http.StatusOK || edited["ts_edited"] == nil ||
+		len(edited["mentions"].([]interface{})) != 1 || edited["mentions"].([]interface{})[0] != MEMBER_A {
+		t.Fatal(code, out)
+	}
+
+	// any member resolves and reopens a thread, but not a reply
+	if code, out := ts.do(member, "POST", replyPath+"/resolve", "", ""); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(member, "POST", threadPath+"/resolve", "", "")
+	if resolved := out["data"].(map[string]interface{}); code != http.StatusOK || resolved["resolved"] != true || resolved["resolved_by"] != MEMBER_A {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(admin, "POST", threadPath+"/unresolve", "", "")
+	if reopened := out["data"].(map[string]interface{}); code != http.StatusOK || reopened["resolved"] != false {
+		t.Fatal(code, out)
+	}
+
+	// the threads with their replies, on the marker or everywhere
+	code, out = ts.do(admin, "GET", commentsPath+"?entity_type=progress_marker&entity_id="+markerId, "", "")
+	threads := out["data"].([]interface{})
+	if code != http.StatusOK || len(threads) != 1 || len(threads[0].(map[string]interface{})["replies"].([]interface{})) != 1 {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(admin, "GET", commentsPath, "", ""); code != http.StatusOK || len(out["data"].([]interface{})) != 2 {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(admin, "GET", commentsPath+"?entity_type=outcome", "", ""); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+
+	// the entities carry their comment counts
+	project, err := ts.store.GetProject(a, projectId)
+	check(t, err)
+	partner, err := ts.store.GetBoundaryPartner(a, projectId, project.BoundaryPartnerIds[0])
+	check(t, err)
+	if project.Comments != 1 || partner.Comments != 0 || len(partner.ProgressMarkers) != 1 || partner.ProgressMarkers[0].Comments != 2 {
+		t.Fatal(project.Comments, partner)
+	}
+
+	// deleting a thread deletes its replies
+	if code, out = ts.do(member, "DELETE", threadPath, "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	if _, err := ts.store.GetComment(a, projectId, reply["comment_id"].(string)); err != ErrNotFound {
+		t.Fatal("the reply outlived its thread", err)
+	}
+}
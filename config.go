@@ -0,0 +1,552 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// prefix of the environment variables that override the config file
+const ENV_PREFIX = "LUCID_"
+
+// config file read when neither -config nor LUCID_CONFIG is given
+const DEFAULT_CONFIG_FILE = "lucid.yaml"
+
+// auth secrets that are known publicly and must never be used
+var defaultAuthSecrets = []string{
+	"",
+	"change-me",
+	"twmF3478cOXpb1B47Dz76AJtqb2fe3tJluPcTUGW",
+}
+
+type Config struct {
+	ListenAddr string        `yaml:"listen_addr"`
+	AuthSecret string        `yaml:"auth_secret"`
+	UploadDir  string        `yaml:"upload_dir"`
+	LogLevel   string        `yaml:"log_level"`
+	TLS        TLSConfig     `yaml:"tls"`
+	Database   DBConfig      `yaml:"database"`
+	Session    SessionConfig `yaml:"session"`
+	Storage    StorageConfig `yaml:"storage"`
+	Uploads    UploadConfig  `yaml:"uploads"`
+	// LinkPreview controls fetching the title and favicon of linked pages.
+	LinkPreview LinkPreviewConfig `yaml:"link_preview"`
+	Currency    CurrencyConfig    `yaml:"currency"`
+	// SMTP is the server notifications are mailed through.
+	SMTP          SMTPConfig          `yaml:"smtp"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Jobs          JobsConfig          `yaml:"jobs"`
+	// Webhooks controls posting events to other systems.
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type DBConfig struct {
+	DSN          string `yaml:"dsn"`
+	MaxOpenConns int    `yaml:"max_open_conns"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
+	// AutoMigrate applies pending migrations when the server starts.
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+type SessionConfig struct {
+	// TTL is the maximum lifetime of an API key after login.
+	TTL Duration `yaml:"ttl"`
+	// IdleTTL expires an API key that has not been used for this long.
+	IdleTTL Duration `yaml:"idle_ttl"`
+}
+
+type StorageConfig struct {
+	// Backend is "local" to keep files in upload_dir or "s3".
+	Backend string   `yaml:"backend"`
+	S3      S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// as required by MinIO and most other S3 compatible servers.
+	PathStyle bool `yaml:"path_style"`
+}
+
+type UploadConfig struct {
+	// MaxFileSize is the largest file accepted, in bytes.
+	MaxFileSize int64 `yaml:"max_file_size"`
+	// OrgQuota is the storage in bytes an organization may use for
+	// resource files, unless organizations.storage_quota overrides it.
+	OrgQuota int64 `yaml:"org_quota"`
+	// AllowedTypes lists the media types accepted, as detected from the
+	// content of a file rather than from its name.
+	AllowedTypes []string `yaml:"allowed_types"`
+	// Scanner is "none" or "clamav".
+	Scanner string `yaml:"scanner"`
+	// ClamAVAddress is "unix:/path/to/clamd.sock" or "host:port".
+	ClamAVAddress string   `yaml:"clamav_address"`
+	ScanTimeout   Duration `yaml:"scan_timeout"`
+}
+
+type LinkPreviewConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Timeout Duration `yaml:"timeout"`
+}
+
+type CurrencyConfig struct {
+	// Default is the currency of projects created without one and of the
+	// organization dashboard.
+	Default string `yaml:"default"`
+	// Provider is the source of exchange rates, "static" for the Rates table.
+	Provider string `yaml:"provider"`
+	// Rates maps currency codes to the units of them one unit of Base buys.
+	Base  string            `yaml:"base"`
+	Rates map[string]string `yaml:"rates"`
+}
+
+type SMTPConfig struct {
+	// Host is the SMTP server; without one, mail is only logged.
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Username and Password authenticate with PLAIN auth, which is only
+	// used over TLS or to localhost.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender, like "Lucid <noreply@example.org>".
+	From    string   `yaml:"from"`
+	Timeout Duration `yaml:"timeout"`
+}
+
+type NotificationsConfig struct {
+	// BaseURL is prefixed to the links in notification emails.
+	BaseURL string `yaml:"base_url"`
+	// DigestTime is the time of day, in UTC, the daily digest is sent at.
+	DigestTime string `yaml:"digest_time"`
+}
+
+type JobsConfig struct {
+	// Enabled runs the background jobs in this instance. Each job runs in
+	// one instance at a time however many have them enabled.
+	Enabled bool `yaml:"enabled"`
+	// PollInterval is how often the runner looks for jobs that are due.
+	PollInterval Duration `yaml:"poll_interval"`
+	// ReminderDays is how many days before a monitoring date its owner is
+	// reminded of it.
+	ReminderDays int `yaml:"reminder_days"`
+	// TimelineWarningDays is how many days before the end of a project's
+	// timeline its team and the admins are told.
+	TimelineWarningDays int `yaml:"timeline_warning_days"`
+	// Retention is how long read notifications and finished job runs are
+	// kept.
+	Retention Duration `yaml:"retention"`
+}
+
+type WebhooksConfig struct {
+	// Timeout is the time a receiver has to respond to a delivery.
+	Timeout Duration `yaml:"timeout"`
+	// MaxAttempts is how often a delivery is tried before it fails.
+	MaxAttempts int `yaml:"max_attempts"`
+	// AllowPrivate allows webhooks to loopback, private and link-local
+	// addresses, for receivers in the same network as the server.
+	AllowPrivate bool `yaml:"allow_private"`
+}
+
+// Duration is a time.Duration written as "90m" or "24h" in the config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddr: "0.0.0.0:8000",
+		UploadDir:  "./temp_file",
+		LogLevel:   "info",
+		Database: DBConfig{
+			DSN:          "dbname=lucid user=lucid host=localhost sslmode=disable search_path=public",
+			MaxOpenConns: 8,
+			MaxIdleConns: 1,
+			AutoMigrate:  true,
+		},
+		Session: SessionConfig{
+			TTL:     Duration(24 * time.Hour),
+			IdleTTL: Duration(2 * time.Hour),
+		},
+		Storage: StorageConfig{
+			Backend: "local",
+			S3:      S3Config{Region: "us-east-1"},
+		},
+		Uploads: UploadConfig{
+			MaxFileSize: 10 << 20,
+			OrgQuota:    1 << 30,
+			AllowedTypes: []string{
+				"application/pdf",
+				"application/zip", // docx, xlsx, pptx, odt
+				"image/gif",
+				"image/jpeg",
+				"image/png",
+				"image/webp",
+				"text/plain",
+			},
+			Scanner:       "none",
+			ClamAVAddress: "unix:/var/run/clamav/clamd.ctl",
+			ScanTimeout:   Duration(time.Minute),
+		},
+		LinkPreview: LinkPreviewConfig{
+			Timeout: Duration(5 * time.Second),
+		},
+		Currency: CurrencyConfig{
+			Default:  "USD",
+			Provider: "static",
+			Base:     "USD",
+			Rates:    map[string]string{},
+		},
+		SMTP: SMTPConfig{
+			Port:    587,
+			From:    "Lucid <noreply@localhost>",
+			Timeout: Duration(30 * time.Second),
+		},
+		Notifications: NotificationsConfig{
+			DigestTime: "07:00",
+		},
+		Jobs: JobsConfig{
+			Enabled:             true,
+			PollInterval:        Duration(time.Minute),
+			ReminderDays:        7,
+			TimelineWarningDays: 30,
+			Retention:           Duration(90 * 24 * time.Hour),
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:     Duration(10 * time.Second),
+			MaxAttempts: 8,
+		},
+	}
+}
+
+// setting binds a config value to its environment variable and command-line
+// flag. The name is the dotted path of the value in the config file.
+type setting struct {
+	name  string
+	usage string
+	set   func(string) error
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"listen_addr", "address to listen on", setString(&c.ListenAddr)},
+		{"auth_secret", "secret used to sign API keys", setString(&c.AuthSecret)},
+		{"upload_dir", "directory for uploaded files", setString(&c.UploadDir)},
+		{"log_level", "one of debug, info, warn, error", setString(&c.LogLevel)},
+		{"tls.cert_file", "TLS certificate file, enables HTTPS", setString(&c.TLS.CertFile)},
+		{"tls.key_file", "TLS private key file", setString(&c.TLS.KeyFile)},
+		{"database.dsn", "PostgreSQL connection string", setString(&c.Database.DSN)},
+		{"database.max_open_conns", "maximum open database connections", setInt(&c.Database.MaxOpenConns)},
+		{"database.max_idle_conns", "maximum idle database connections", setInt(&c.Database.MaxIdleConns)},
+		{"database.auto_migrate", "apply pending migrations at startup", setBool(&c.Database.AutoMigrate)},
+		{"session.ttl", "maximum lifetime of an API key", setDuration(&c.Session.TTL)},
+		{"session.idle_ttl", "lifetime of an unused API key", setDuration(&c.Session.IdleTTL)},
+		{"storage.backend", "file storage backend, local or s3", setString(&c.Storage.Backend)},
+		{"storage.s3.endpoint", "S3 endpoint URL", setString(&c.Storage.S3.Endpoint)},
+		{"storage.s3.region", "S3 region", setString(&c.Storage.S3.Region)},
+		{"storage.s3.bucket", "S3 bucket", setString(&c.Storage.S3.Bucket)},
+		{"storage.s3.access_key", "S3 access key", setString(&c.Storage.S3.AccessKey)},
+		{"storage.s3.secret_key", "S3 secret key", setString(&c.Storage.S3.SecretKey)},
+		{"storage.s3.path_style", "use path style S3 requests", setBool(&c.Storage.S3.PathStyle)},
+		{"uploads.max_file_size", "largest accepted upload in bytes", setInt64(&c.Uploads.MaxFileSize)},
+		{"uploads.org_quota", "default storage quota per organization in bytes", setInt64(&c.Uploads.OrgQuota)},
+		{"uploads.allowed_types", "comma separated media types accepted for upload", setStringList(&c.Uploads.AllowedTypes)},
+		{"uploads.scanner", "virus scanner, none or clamav", setString(&c.Uploads.Scanner)},
+		{"uploads.clamav_address", "clamd address, unix:/path or host:port", setString(&c.Uploads.ClamAVAddress)},
+		{"uploads.scan_timeout", "timeout for scanning one upload", setDuration(&c.Uploads.ScanTimeout)},
+		{"link_preview.enabled", "fetch title and favicon of linked pages", setBool(&c.LinkPreview.Enabled)},
+		{"link_preview.timeout", "timeout for fetching a linked page", setDuration(&c.LinkPreview.Timeout)},
+		{"currency.default", "ISO 4217 code of the default currency", setString(&c.Currency.Default)},
+		{"currency.provider", "exchange rate provider, static", setString(&c.Currency.Provider)},
+		{"currency.base", "currency the static rates are quoted against", setString(&c.Currency.Base)},
+		{"currency.rates", "static rates as comma separated CODE=rate pairs", setRates(&c.Currency.Rates)},
+		{"smtp.host", "SMTP server for notification mail, empty to only log it", setString(&c.SMTP.Host)},
+		{"smtp.port", "SMTP server port", setInt(&c.SMTP.Port)},
+		{"smtp.username", "SMTP user name", setString(&c.SMTP.Username)},
+		{"smtp.password", "SMTP password", setString(&c.SMTP.Password)},
+		{"smtp.from", "sender of notification mail", setString(&c.SMTP.From)},
+		{"smtp.timeout", "timeout for sending one mail", setDuration(&c.SMTP.Timeout)},
+		{"notifications.base_url", "URL prefixed to links in notification mail", setString(&c.Notifications.BaseURL)},
+		{"notifications.digest_time", "time of day (UTC) the daily digest is sent, like 07:00", setString(&c.Notifications.DigestTime)},
+		{"jobs.enabled", "run background jobs in this instance", setBool(&c.Jobs.Enabled)},
+		{"jobs.poll_interval", "how often due background jobs are looked for", setDuration(&c.Jobs.PollInterval)},
+		{"jobs.reminder_days", "days before a monitoring date its owner is reminded", setInt(&c.Jobs.ReminderDays)},
+		{"jobs.timeline_warning_days", "days before the end of a project's timeline it is announced", setInt(&c.Jobs.TimelineWarningDays)},
+		{"jobs.retention", "how long read notifications and finished job runs are kept", setDuration(&c.Jobs.Retention)},
+		{"webhooks.timeout", "time a webhook receiver has to respond", setDuration(&c.Webhooks.Timeout)},
+		{"webhooks.max_attempts", "attempts made at a webhook delivery before it fails", setInt(&c.Webhooks.MaxAttempts)},
+		{"webhooks.allow_private", "allow webhooks to private and loopback addresses", setBool(&c.Webhooks.AllowPrivate)},
+	}
+}
+
+func setString(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setInt64(p *int64) func(string) error {
+	return func(s string) error {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setStringList(p *[]string) func(string) error {
+	return func(s string) error {
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+func setRates(p *map[string]string) func(string) error {
+	return func(s string) error {
+		rates := make(map[string]string)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%q is not a CODE=rate pair", item)
+			}
+			rates[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		*p = rates
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(s string) error {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+func setDuration(p *Duration) func(string) error {
+	return func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*p = Duration(d)
+		return nil
+	}
+}
+
+func envName(name string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.Replace(name, ".", "_", -1))
+}
+
+func flagName(name string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(name)
+}
+
+// loadConfig builds the configuration from the defaults, the config file,
+// the environment and the command-line flags, each overriding the former.
+// The remaining command-line arguments are returned.
+func loadConfig(args []string) (*Config, []string, error) {
+	c := defaultConfig()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet("lucid", flag.ContinueOnError)
+	configFile := fs.String("config", "", "config file (default "+DEFAULT_CONFIG_FILE+" if present, or "+ENV_PREFIX+"CONFIG)")
+	flagValues := make(map[string]*string)
+	for _, s := range settings {
+		flagValues[s.name] = fs.String(flagName(s.name), "", s.usage+" ("+envName(s.name)+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(ENV_PREFIX + "CONFIG")
+	}
+	if path == "" {
+		if _, err := os.Stat(DEFAULT_CONFIG_FILE); err == nil {
+			path = DEFAULT_CONFIG_FILE
+		}
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envName(s.name)); ok {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", envName(s.name), err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagName(s.name) == f.Name && flagErr == nil {
+				if err := s.set(*flagValues[s.name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	return c, fs.Args(), c.Validate()
+}
+
+// Validate rejects configurations the server must not start with.
+func (c *Config) Validate() error {
+	for _, secret := range defaultAuthSecrets {
+		if c.AuthSecret == secret {
+			return errors.New("auth_secret is not set or uses a published default; set " + envName("auth_secret"))
+		}
+	}
+	if len(c.AuthSecret) < 32 {
+		return errors.New("auth_secret must be at least 32 characters")
+	}
+	if c.ListenAddr == "" {
+		return errors.New("listen_addr must not be empty")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.Database.DSN == "" {
+		return errors.New("database.dsn must not be empty")
+	}
+	if c.Database.MaxOpenConns < 1 {
+		return errors.New("database.max_open_conns must be at least 1")
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return errors.New("database.max_idle_conns must be between 0 and database.max_open_conns")
+	}
+	if c.UploadDir == "" {
+		return errors.New("upload_dir must not be empty")
+	}
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("unknown log_level %q", c.LogLevel)
+	}
+	switch c.Storage.Backend {
+	case "local":
+	case "s3":
+		s3 := c.Storage.S3
+		if s3.Endpoint == "" || s3.Region == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return errors.New("storage.s3 needs endpoint, region, bucket, access_key and secret_key")
+		}
+	default:
+		return fmt.Errorf("unknown storage.backend %q", c.Storage.Backend)
+	}
+	if c.Session.TTL <= 0 || c.Session.IdleTTL <= 0 {
+		return errors.New("session.ttl and session.idle_ttl must be positive")
+	}
+	if c.Uploads.MaxFileSize < 1 || c.Uploads.OrgQuota < 0 {
+		return errors.New("uploads.max_file_size must be positive and uploads.org_quota not negative")
+	}
+	if len(c.Uploads.AllowedTypes) == 0 {
+		return errors.New("uploads.allowed_types must not be empty")
+	}
+	switch c.Uploads.Scanner {
+	case "none":
+	case "clamav":
+		if c.Uploads.ClamAVAddress == "" || c.Uploads.ScanTimeout <= 0 {
+			return errors.New("uploads.clamav_address and uploads.scan_timeout are required for the clamav scanner")
+		}
+	default:
+		return fmt.Errorf("unknown uploads.scanner %q", c.Uploads.Scanner)
+	}
+	if c.LinkPreview.Enabled && c.LinkPreview.Timeout <= 0 {
+		return errors.New("link_preview.timeout must be positive")
+	}
+	if !isCurrency(c.Currency.Default) || !isCurrency(c.Currency.Base) {
+		return errors.New("currency.default and currency.base must be ISO 4217 codes")
+	}
+	if c.Currency.Provider != "static" {
+		return fmt.Errorf("unknown currency.provider %q", c.Currency.Provider)
+	}
+	if err := validRates(c.Currency); err != nil {
+		return err
+	}
+	if c.SMTP.Host != "" {
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 || c.SMTP.Timeout <= 0 {
+			return errors.New("smtp.port must be a port number and smtp.timeout positive")
+		}
+		if _, err := mail.ParseAddress(c.SMTP.From); err != nil {
+			return fmt.Errorf("smtp.from: %v", err)
+		}
+	}
+	if _, err := time.Parse("15:04", c.Notifications.DigestTime); err != nil {
+		return errors.New("notifications.digest_time must be a time of day like 07:00")
+	}
+	if c.Jobs.PollInterval <= 0 || c.Jobs.Retention <= 0 {
+		return errors.New("jobs.poll_interval and jobs.retention must be positive")
+	}
+	if c.Jobs.ReminderDays < 0 || c.Jobs.TimelineWarningDays < 0 {
+		return errors.New("jobs.reminder_days and jobs.timeline_warning_days must not be negative")
+	}
+	if c.Webhooks.Timeout <= 0 || c.Webhooks.MaxAttempts < 1 {
+		return errors.New("webhooks.timeout must be positive and webhooks.max_attempts at least 1")
+	}
+	return nil
+}
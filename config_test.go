@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSecret is long enough and not one of the published defaults.
+var testSecret = testConfig().AuthSecret
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lucid.yaml")
+	check(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// TestConfigPrecedence sets values in the file, the environment and the
+// flags, and the later ones win.
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+listen_addr: file:1
+log_level: warn
+database:
+  max_open_conns: 3
+  max_idle_conns: 2
+session:
+  ttl: 1h
+`)
+	t.Setenv("LUCID_AUTH_SECRET", testSecret)
+	t.Setenv("LUCID_LOG_LEVEL", "debug")
+	t.Setenv("LUCID_DATABASE_MAX_OPEN_CONNS", "5")
+	c, rest, err := loadConfig([]string{"-config", path, "-database-max-open-conns", "7", "migrate", "up"})
+	check(t, err)
+	if c.ListenAddr != "file:1" || c.LogLevel != "debug" || c.Database.MaxOpenConns != 7 || c.Database.MaxIdleConns != 2 {
+		t.Fatalf("%+v", c)
+	}
+	if c.Session.TTL != Duration(time.Hour) || c.Session.IdleTTL != defaultConfig().Session.IdleTTL || c.AuthSecret != testSecret {
+		t.Fatalf("%+v", c)
+	}
+	if !equalStrings(rest, []string{"migrate", "up"}) {
+		t.Fatal(rest)
+	}
+
+	// LUCID_CONFIG names the file if -config does not
+	t.Setenv("LUCID_CONFIG", path)
+	c, _, err = loadConfig(nil)
+	check(t, err)
+	if c.ListenAddr != "file:1" || c.Database.MaxOpenConns != 5 {
+		t.Fatalf("%+v", c)
+	}
+}
+
+func TestConfigSecret(t *testing.T) {
+	for _, secret := range append(defaultAuthSecrets, "too-short-for-hmac") {
+		_, _, err := loadConfig([]string{"-config", writeConfig(t, "auth_secret: "+secret+"\n")})
+		if err == nil {
+			t.Errorf("%q accepted", secret)
+		}
+	}
+	t.Setenv("LUCID_AUTH_SECRET", "change-me")
+	if _, _, err := loadConfig([]string{"-auth-secret", testSecret}); err != nil {
+		t.Fatal("flag did not replace the published secret:", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	t.Setenv("LUCID_AUTH_SECRET", testSecret)
+	for _, test := range []struct {
+		file, message string
+		args          []string
+	}{
+		{"listen_adr: :80\n", "field listen_adr not found", nil},
+		{"database:\n  max_conns: 3\n", "field max_conns not found", nil},
+		{"session:\n  ttl: forever\n", "invalid duration", nil},
+		{"", "database.max_idle_conns must be between", []string{"-database-max-idle-conns", "9"}},
+		{"", "-database-max-open-conns", []string{"-database-max-open-conns", "many"}},
+		{"tls:\n  cert_file: cert.pem\n", "must be set together", nil},
+		{"log_level: loud\n", `unknown log_level "loud"`, nil},
+	} {
+		_, _, err := loadConfig(append([]string{"-config", writeConfig(t, test.file)}, test.args...))
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%q %v: %v", test.file, test.args, err)
+		}
+	}
+	t.Setenv("LUCID_SESSION_IDLE_TTL", "soon")
+	if _, _, err := loadConfig(nil); err == nil || !strings.Contains(err.Error(), "LUCID_SESSION_IDLE_TTL") {
+		t.Fatal(err)
+	}
+}
@@ -1,13 +0,0 @@
-package main
-
-const LISTEN_ADDR = "0.0.0.0:8000"
-const AUTH_SECRET = "twmF3478cOXpb1B47Dz76AJtqb2fe3tJluPcTUGW"
-
-// database
-const (
-	DB_HOST   = "localhost"
-	DB_NAME   = "lucid"
-	DB_USER   = "lucid"
-	DB_PASS   = "J3tUyDlOgg51xSz"
-	DB_SCHEMA = "public"
-)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// lifetime of a share link when the request does not ask for one
+const DEFAULT_SHARE_TTL = 7 * 24 * time.Hour
+
+// share links can not be made to last longer than this
+const MAX_SHARE_TTL = 30 * 24 * time.Hour
+
+type SharedResource struct {
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *Server) getResourceContent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s.serveResource(w, r, tenantOf(r), vars["projectId"], vars["resourceId"])
+}
+
+// getSharedResource serves a file to anyone holding a valid share link.
+func (s *Server) getSharedResource(w http.ResponseWriter, r *http.Request) {
+	link, err := parseShareToken(s.config.AuthSecret, mux.Vars(r)["token"], time.Now())
+	if err != nil {
+		JSON(w, http.StatusForbidden, Response{nil, err.Error()})
+		return
+	}
+	s.serveResource(w, r, Tenant{link.OrganizationId}, link.ProjectId, link.ResourceId)
+}
+
+// serveResource streams the stored file of a resource. Range requests and
+// conditional requests are handled by http.ServeContent; the ETag is the
+// content hash, so it changes exactly when the file does.
+func (s *Server) serveResource(w http.ResponseWriter, r *http.Request, t Tenant, projectId, resourceId string) {
+	exr, err := s.store.GetExternalResource(t, projectId, resourceId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if exr.StorageKey == "" {
+		JSON(w, http.StatusNotFound, Response{nil, "resource has no stored file"})
+		return
+	}
+	s.serveBlob(w, r, exr.StorageKey, exr.ResourceName, exr.ContentType)
+}
+
+// serveBlob sends a stored file as a download named name. The media type is
+// the one detected on upload, the name only decides it for files stored
+// without one.
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, key, name, mediaType string) {
+	blob, err := s.blobs.Get(key)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer blob.Close()
+
+	if mediaType == "" {
+		mediaType = contentType(name)
+	}
+	h := w.Header()
+	h.Set("Content-Type", mediaType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	h.Set("ETag", `"`+blobSHA256(key)+`"`)
+	h.Set("Cache-Control", "private, no-cache")
+	// uploaded files are never rendered as part of the API's origin
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(w, r, "", time.Time{}, blob)
+}
+
+// contentType guesses the media type of a file from its name.
+func contentType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func (s *Server) shareResource(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	projectId := mux.Vars(r)["projectId"]
+	resourceId := mux.Vars(r)["resourceId"]
+
+	ttl := DEFAULT_SHARE_TTL
+	if value := r.FormValue("expires_in"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > MAX_SHARE_TTL {
+			JSON(w, http.StatusBadRequest, Response{FieldErrors{"expires_in": "must be a duration up to " + MAX_SHARE_TTL.String()}, "validation failed"})
+			return
+		}
+		ttl = parsed
+	}
+
+	exr, err := s.store.GetExternalResource(tenantOf(r), projectId, resourceId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if exr.StorageKey == "" {
+		JSON(w, http.StatusBadRequest, Response{nil, "only stored files can be shared"})
+		return
+	}
+
+	link := ShareLink{user.OrganizationId, projectId, resourceId, time.Now().Add(ttl).Truncate(time.Second)}
+	token := createShareToken(s.config.AuthSecret, link)
+	JSON(w, http.StatusOK, Response{SharedResource{"/shared/resources/" + token, link.Expires.UTC()}, "success"})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fetch sends a GET request with the given API key and headers and returns
+// the response with its body read.
+func (ts *testServer) fetch(key, path string, header map[string]string) (*http.Response, string) {
+	ts.t.Helper()
+	req, err := http.NewRequest("GET", ts.srv.URL+path, nil)
+	check(ts.t, err)
+	if key != "" {
+		req.Header.Set("X-Api-Key", key)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	check(ts.t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	check(ts.t, err)
+	return resp, string(body)
+}
+
+// uploadResource stores a file as a resource of a new project of
+// organization A and returns the project and the resource.
+func (ts *testServer) uploadResource(key, content string) (string, ExternalResources) {
+	ts.t.Helper()
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "A"})
+	check(ts.t, err)
+	if code, out := ts.upload(key, "/projects/"+projectId+"/resource_uploadfile", "resource_file", "notes.txt", content); code != 200 {
+		ts.t.Fatal(code, out)
+	}
+	resources, err := ts.store.GetExternalResources(a, projectId)
+	check(ts.t, err)
+	if len(resources) != 1 {
+		ts.t.Fatalf("%+v", resources)
+	}
+	return projectId, resources[0]
+}
+
+// TestResourceContent downloads a stored file whole, as a byte range and
+// conditionally on its ETag.
+func TestResourceContent(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	content := "hello, world"
+	_, exr := ts.uploadResource(key, content)
+
+	resp, body := ts.fetch(key, exr.ResourceUrl, nil)
+	sum := sha256.Sum256([]byte(content))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if resp.StatusCode != http.StatusOK || body != content || resp.Header.Get("ETag") != etag {
+		t.Fatalf("%d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=notes.txt` {
+		t.Fatal(got)
+	}
+
+	resp, body = ts.fetch(key, exr.ResourceUrl, map[string]string{"Range": "bytes=7-"})
+	if resp.StatusCode != http.StatusPartialContent || body != "world" || resp.Header.Get("Content-Range") != "bytes 7-11/12" {
+		t.Fatalf("%d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	resp, body = ts.fetch(key, exr.ResourceUrl, map[string]string{"Range": "bytes=0-4"})
+	if resp.StatusCode != http.StatusPartialContent || body != "hello" {
+		t.Fatalf("%d %q", resp.StatusCode, body)
+	}
+
+	resp, body = ts.fetch(key, exr.ResourceUrl, map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Fatalf("%d %q", resp.StatusCode, body)
+	}
+	resp, body = ts.fetch(key, exr.ResourceUrl, map[string]string{"If-None-Match": `"stale"`})
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("%d %q", resp.StatusCode, body)
+	}
+
+	if resp, _ = ts.fetch(ts.login("bo@b.org"), exr.ResourceUrl, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+// TestSharedResource serves a file to a share link without an API key and
+// refuses links that expired, were tampered with, or name a resource the
+// organization in them does not have.
+func TestSharedResource(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	projectId, exr := ts.uploadResource(key, "hello, world")
+	sharePath := "/projects/" + projectId + "/resources/" + exr.ResourceId + "/share"
+
+	code, out := ts.do(key, "POST", sharePath, form, "expires_in=1h")
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	url := out["data"].(map[string]interface{})["url"].(string)
+	if resp, body := ts.fetch("", url, map[string]string{"Range": "bytes=7-"}); resp.StatusCode != http.StatusPartialContent || body != "world" {
+		t.Fatalf("%d %q", resp.StatusCode, body)
+	}
+	if code, out = ts.do(key, "POST", sharePath, form, "expires_in=9999h"); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+
+	// another resource of organization A that was not shared
+	other, err := ts.store.AddExternalResource(Tenant{ORG_A}, ADMIN_A, ExternalResources{ProjectId: projectId,
+		ResourceName: "other.txt", StorageKey: exr.StorageKey})
+	check(t, err)
+	// a project of organization B
+	projectB, err := ts.store.AddProject(Tenant{ORG_B}, Project{ProjectName: "B"})
+	check(t, err)
+
+	secret := ts.config.AuthSecret
+	valid := ShareLink{ORG_A, projectId, exr.ResourceId, time.Now().Add(time.Hour)}
+	token := createShareToken(secret, valid)
+	payload, signature := token[:strings.Index(token, ".")], token[strings.Index(token, ".")+1:]
+	forged := createShareToken(secret, ShareLink{ORG_A, projectId, other, valid.Expires})
+	otherPayload := forged[:strings.Index(forged, ".")]
+
+	for _, test := range []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"valid", token, http.StatusOK},
+		{"expired", createShareToken(secret, ShareLink{ORG_A, projectId, exr.ResourceId, time.Now().Add(-time.Second)}), http.StatusForbidden},
+		{"other resource's payload", otherPayload + "." + signature, http.StatusForbidden},
+		{"truncated signature", payload + "." + signature[:len(signature)-2], http.StatusForbidden},
+		{"no signature", payload, http.StatusForbidden},
+		{"other secret", createShareToken(strings.Repeat("x", 40), valid), http.StatusForbidden},
+		{"other organization", createShareToken(secret, ShareLink{ORG_B, projectId, exr.ResourceId, valid.Expires}), http.StatusNotFound},
+		{"other project", createShareToken(secret, ShareLink{ORG_B, projectB, exr.ResourceId, valid.Expires}), http.StatusNotFound},
+		{"unknown resource", createShareToken(secret, ShareLink{ORG_A, projectId, ORG_B, valid.Expires}), http.StatusNotFound},
+	} {
+		if resp, body := ts.fetch("", "/shared/resources/"+test.token, nil); resp.StatusCode != test.code {
+			t.Errorf("%s: %d %s", test.name, resp.StatusCode, body)
+		}
+	}
+}
+
+// TestResourceContentType downloads a file with the media type detected on
+// upload, not the one its name suggests.
+func TestResourceContentType(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	if code, out := ts.upload(key, "/projects/"+projectId+"/resource_uploadfile", "resource_file", "notes.html", "meeting notes"); code != 200 {
+		t.Fatal(code, out)
+	}
+	resources, err := ts.store.GetExternalResources(a, projectId)
+	check(t, err)
+
+	if resp, _ := ts.fetch(key, resources[0].ResourceUrl, nil); resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatal(resp.Header)
+	}
+
+	// files imported without a media type fall back to their name
+	ts.store.(*memStore).resources[resources[0].ResourceId].ContentType = ""
+	if resp, _ := ts.fetch(key, resources[0].ResourceUrl, nil); resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatal(resp.Header)
+	}
+}
@@ -2,14 +2,166 @@ package main
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func createApiKey(userId string) string {
-	key := []byte(AUTH_SECRET)
+var invalidShareToken = errors.New("invalid or expired share link")
+
+func createApiKey(secret, userId string) string {
+	key := []byte(secret)
 	mac := hmac.New(sha256.New, key)
 	mac.Write([]byte(userId))
 	hash := mac.Sum(nil)
 	return base64.StdEncoding.EncodeToString(hash)
 }
+
+// ShareLink grants access to the file of a single resource until it expires.
+type ShareLink struct {
+	OrganizationId string
+	ProjectId      string
+	ResourceId     string
+	Expires        time.Time
+}
+
+// shareKey derives the key share links are signed with, so that a share
+// token can never be mistaken for an API key.
+func shareKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("share-link"))
+	return mac.Sum(nil)
+}
+
+func createShareToken(secret string, link ShareLink) string {
+	payload := strings.Join([]string{
+		link.OrganizationId, link.ProjectId, link.ResourceId, strconv.FormatInt(link.Expires.Unix(), 10),
+	}, ":")
+	mac := hmac.New(sha256.New, shareKey(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseShareToken verifies the signature and expiry of a share token.
+func parseShareToken(secret, token string, now time.Time) (ShareLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ShareLink{}, invalidShareToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ShareLink{}, invalidShareToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ShareLink{}, invalidShareToken
+	}
+	mac := hmac.New(sha256.New, shareKey(secret))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ShareLink{}, invalidShareToken
+	}
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 4 {
+		return ShareLink{}, invalidShareToken
+	}
+	expires, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return ShareLink{}, invalidShareToken
+	}
+	return ShareLink{fields[0], fields[1], fields[2], time.Unix(expires, 0)}, nil
+}
+
+var invalidCalendarToken = errors.New("invalid or revoked calendar link")
+
+// CalendarFeed is what a calendar feed link is made of. The key is stored
+// with the project and replaced to revoke the links made with it.
+type CalendarFeed struct {
+	OrganizationId string
+	ProjectId      string
+	Key            string
+}
+
+// calendarKey derives the key calendar feed links are signed with.
+func calendarKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("calendar-feed"))
+	return mac.Sum(nil)
+}
+
+// createCalendarToken signs a calendar feed. Calendar clients can not log
+// in, so the token does not expire; it stays valid until the key of the
+// project is rotated or revoked.
+func createCalendarToken(secret string, feed CalendarFeed) string {
+	payload := feed.OrganizationId + ":" + feed.ProjectId + ":" + feed.Key
+	mac := hmac.New(sha256.New, calendarKey(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseCalendarToken verifies a calendar token and that it was made with the
+// key the project has now, which is looked up with keys.
+func parseCalendarToken(secret, token string, keys func(t Tenant, projectId string) (string, error)) (CalendarFeed, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	mac := hmac.New(sha256.New, calendarKey(secret))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	feed := CalendarFeed{fields[0], fields[1], fields[2]}
+	key, err := keys(Tenant{feed.OrganizationId}, feed.ProjectId)
+	if err == ErrNotFound {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	if err != nil {
+		return CalendarFeed{}, err
+	}
+	if !hmac.Equal([]byte(key), []byte(feed.Key)) {
+		return CalendarFeed{}, invalidCalendarToken
+	}
+	return feed, nil
+}
+
+// newWebhookSecret returns a random secret for signing the deliveries of a
+// webhook.
+func newWebhookSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(secret)
+}
+
+// signWebhookPayload signs the body of a webhook delivery and the time it is
+// sent with the webhook's secret, as "sha256=" followed by the hex encoded
+// HMAC-SHA256 of timestamp + "." + body. Receivers verify it by computing the
+// same over X-Lucid-Timestamp and the raw body, and reject old timestamps so
+// a captured request can not be replayed.
+func signWebhookPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// add counts a progress marker of the given level.
+func (c *MarkerCounts) add(level int) {
+	switch level {
+	case MARKER_EXPECT:
+		c.Expect++
+	case MARKER_LIKE:
+		c.Like++
+	case MARKER_LOVE:
+		c.Love++
+	}
+}
+
+// add counts a rating of a progress marker.
+func (c *RatingCounts) add(rating string) {
+	switch rating {
+	case RATING_LOW:
+		c.Low++
+	case RATING_MEDIUM:
+		c.Medium++
+	case RATING_HIGH:
+		c.High++
+	}
+}
+
+// achieved returns the share of the ratings that are high, in percent, or
+// nil if there are none.
+func (c RatingCounts) achieved() *float64 {
+	total := c.Low + c.Medium + c.High
+	if total == 0 {
+		return nil
+	}
+	share := math.Round(float64(c.High)/float64(total)*1000) / 10
+	return &share
+}
+
+// setAchieved computes the share of markers rated high of the project and of
+// every journal in the trends of its partners.
+func (st *ProjectStats) setAchieved() {
+	st.Achieved = st.LatestRatings.achieved()
+	for i := range st.Partners {
+		for k := range st.Partners[i].Trend {
+			period := &st.Partners[i].Trend[k]
+			period.Achieved = period.Ratings.achieved()
+		}
+	}
+}
+
+// setTimeline computes how much of the timeline has elapsed at now, in
+// percent, and how many days are left of it. Both stay nil unless the
+// timeline has both ends.
+func (st *ProjectStats) setTimeline(now time.Time) {
+	from, err1 := time.Parse(time.RFC3339, st.TimelineFrom)
+	to, err2 := time.Parse(time.RFC3339, st.TimelineTo)
+	if err1 != nil || err2 != nil {
+		return
+	}
+
+	elapsed := 100.0
+	if total := to.Sub(from); total > 0 {
+		elapsed = float64(now.Sub(from)) / float64(total) * 100
+	} else if now.Before(from) {
+		elapsed = 0
+	}
+	elapsed = math.Round(math.Max(0, math.Min(100, elapsed))*10) / 10
+	st.TimelineElapsed = &elapsed
+
+	days := 0
+	if now.Before(to) {
+		days = int(math.Ceil(to.Sub(now).Hours() / 24))
+	}
+	st.DaysRemaining = &days
+}
+
+// active reports whether the timeline of a project includes now.
+func (st *ProjectStats) active(now time.Time) bool {
+	from, err1 := time.Parse(time.RFC3339, st.TimelineFrom)
+	to, err2 := time.Parse(time.RFC3339, st.TimelineTo)
+	return err1 == nil && err2 == nil && !now.Before(from) && now.Before(to)
+}
+
+// rollup sums the stats of the projects of an organization. Budgets are
+// converted into currency at the rates of now.
+func rollup(projects []ProjectStats, now time.Time, currency string, rates RateProvider) (OrganizationStats, error) {
+	org := OrganizationStats{Projects: len(projects), Currency: currency, ProjectStats: projects}
+	for i := range projects {
+		st := &projects[i]
+		st.setTimeline(now)
+		st.setAchieved()
+		st.BudgetConsumed = st.Spent.Percent(st.Budget)
+		if st.active(now) {
+			org.ActiveProjects++
+		}
+		org.BoundaryPartners += st.BoundaryPartners
+		org.ProgressMarkers += st.ProgressMarkers
+		org.MarkersByLevel.Expect += st.MarkersByLevel.Expect
+		org.MarkersByLevel.Like += st.MarkersByLevel.Like
+		org.MarkersByLevel.Love += st.MarkersByLevel.Love
+		org.Challenges += st.Challenges
+		org.Strategies += st.Strategies
+		org.Resources += st.Resources
+		org.LatestRatings.Low += st.LatestRatings.Low
+		org.LatestRatings.Medium += st.LatestRatings.Medium
+		org.LatestRatings.High += st.LatestRatings.High
+
+		rate, err := rates.Rate(st.Currency, currency, now)
+		if err != nil {
+			return OrganizationStats{}, fmt.Errorf("%s to %s: %v", st.Currency, currency, err)
+		}
+		budget, err := convert(st.Budget, rate, currencies[currency])
+		if err != nil {
+			return OrganizationStats{}, err
+		}
+		spent, err := convert(st.Spent, rate, currencies[currency])
+		if err != nil {
+			return OrganizationStats{}, err
+		}
+		org.Budget += budget
+		org.Spent += spent
+	}
+	org.Achieved = org.LatestRatings.achieved()
+	return org, nil
+}
+
+// getProjectDashboard returns the stats of a project, broken down by
+// boundary partner.
+func (s *Server) getProjectDashboard(w http.ResponseWriter, r *http.Request) {
+	st, err := s.store.GetProjectStats(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	st.setTimeline(time.Now())
+	st.setAchieved()
+	st.BudgetConsumed = st.Spent.Percent(st.Budget)
+	JSON(w, http.StatusOK, Response{st, "success"})
+}
+
+// getOrganizationDashboard returns the stats of all projects of the caller's
+// organization and their totals.
+func (s *Server) getOrganizationDashboard(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.store.GetOrganizationStats(tenantOf(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	org, err := rollup(projects, time.Now(), s.config.Currency.Default, s.rates)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, "can not convert the budgets: " + err.Error()})
+		return
+	}
+	JSON(w, http.StatusOK, Response{org, "success"})
+}
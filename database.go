@@ -1,25 +1,3702 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
 )
 
-var pg *sqlx.DB
+// pgStore implements Store on top of PostgreSQL.
+type pgStore struct {
+	db *sqlx.DB
+}
+
+func connectPostgres(config DBConfig) *sqlx.DB {
+	infof("Connect to PostgreSQL")
+	db := sqlx.MustConnect("postgres", config.DSN)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	infof("... Connected to PostgreSQL")
+	return db
+}
+
+func NewPostgresStore(db *sqlx.DB) Store {
+	return &pgStore{db}
+}
+
+// tenantTx runs fn in a transaction scoped to the tenant. The organization is
+// also exposed to the row level security policies via the
+// lucid.organization_id setting, so a query that forgets the organization
+// filter still cannot reach another tenant's rows.
+func (s *pgStore) tenantTx(t Tenant, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT set_config('lucid.organization_id', $1, TRUE)", t.OrganizationId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// expectRow turns an update or delete that matched no row into ErrNotFound.
+func expectRow(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *pgStore) GetUser(userId string) (User, error) {
+	var user User
+	err := s.db.QueryRow("SELECT organization_id, full_name, is_admin, coalesce(donor_id::TEXT, '') FROM users WHERE user_id = $1", userId).Scan(
+		&user.OrganizationId, &user.FullName, &user.IsAdmin, &user.DonorId)
+	if err == sql.ErrNoRows {
+		return user, ErrNotFound
+	}
+	if err != nil {
+		return user, err
+	}
+	user.UserId = userId
+	return user, err
+}
+
+func (s *pgStore) GetUserEmail(userId string) (string, error) {
+	var email string
+	err := s.db.QueryRow("SELECT coalesce(email, '') FROM users WHERE user_id = $1", userId).Scan(&email)
+	if err == sql.ErrNoRows {
+		return email, ErrNotFound
+	}
+	return email, err
+}
+
+func (s *pgStore) GetOrganizationIds() ([]string, error) {
+	organizationIds := []string{}
+	rows, err := s.db.Query("SELECT organization_id FROM organizations ORDER BY ts_created")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var organizationId string
+		if err = rows.Scan(&organizationId); err != nil {
+			return nil, err
+		}
+		organizationIds = append(organizationIds, organizationId)
+	}
+	return organizationIds, rows.Err()
+}
+
+func (s *pgStore) GetAdminIds(t Tenant) ([]string, error) {
+	adminIds := []string{}
+	rows, err := s.db.Query("SELECT user_id FROM users WHERE organization_id = $1 AND is_admin ORDER BY user_id", t.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userId string
+		if err = rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		adminIds = append(adminIds, userId)
+	}
+	return adminIds, rows.Err()
+}
+
+func (s *pgStore) GetLogin(email string) (string, []byte, error) {
+	var userId string
+	var hashedPassword []byte
+	err := s.db.QueryRow("SELECT user_id, password FROM users WHERE lower(email) = lower($1)", email).Scan(&userId, &hashedPassword)
+	if err == sql.ErrNoRows {
+		return userId, hashedPassword, ErrNotFound
+	}
+	return userId, hashedPassword, err
+}
+
+// projectBudget is the budget of the project p, derived from its budget lines
+// once it has any.
+const projectBudget = `coalesce((SELECT sum(project_amount) FROM budget_lines bl WHERE bl.project_id = p.project_id), p.budget, 0)`
+
+// projectDonors joins the names of the donors of the project p's grants.
+const projectDonors = `coalesce((
+	  SELECT string_agg(donor_name, ', ' ORDER BY first_grant) FROM (
+	    SELECT d.donor_name, min(g.ts_created) AS first_grant
+	    FROM grants g JOIN donors d USING (donor_id)
+	    WHERE g.project_id = p.project_id
+	    GROUP BY d.donor_id, d.donor_name
+	  ) project_donors
+	), '')`
+
+// selectProjects is completed with a WHERE clause by queryProjects.
+const selectProjects = `
+	SELECT
+	  project_id, coalesce(project_name, ''), coalesce(description, ''),
+	  coalesce(logo_key, ''), coalesce(logo_medium_key, ''), coalesce(logo_thumbnail_key, ''),
+	  coalesce(logo_content_type, ''), coalesce(logo_width, 0), coalesce(logo_height, 0),
+	  ` + projectBudget + `, currency, ` + projectDonors + `, coalesce(mission, ''), coalesce(vision, ''),
+	  coalesce(to_char(timeline_from, 'YYYY-MM-DD HH:MI:SS TZ'), ''),
+	  coalesce(to_char(timeline_to, 'YYYY-MM-DD HH:MI:SS TZ'), ''),
+	  array(SELECT boundary_partner_id FROM boundary_partners bp WHERE bp.project_id = p.project_id ORDER BY ts_created),
+	  array(SELECT partner_name FROM boundary_partners bp WHERE bp.project_id = p.project_id ORDER BY ts_created),
+	  array(SELECT resource_id FROM external_resources er WHERE er.project_id = p.project_id AND scan_status = 'clean' ORDER BY ts_created),
+	  array(SELECT coalesce(resource_url, '') FROM external_resources er WHERE er.project_id = p.project_id AND scan_status = 'clean' ORDER BY ts_created),
+	  array(SELECT coalesce(storage_key, '') FROM external_resources er WHERE er.project_id = p.project_id AND scan_status = 'clean' ORDER BY ts_created),
+	  (SELECT count(*) FROM comments c WHERE c.project_id = p.project_id AND c.entity_type = 'project')
+	FROM projects p
+`
+
+func queryProjects(tx *sqlx.Tx, where string, args ...interface{}) ([]Project, error) {
+	projects := []Project{}
+	rows, err := tx.Query(selectProjects+where+" ORDER BY ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Project{}
+		var storageKeys pq.StringArray
+		var logo ProjectLogo
+		err = rows.Scan(&p.ProjectId, &p.ProjectName, &p.Description,
+			&logo.OriginalKey, &logo.MediumKey, &logo.ThumbnailKey, &logo.ContentType, &logo.Width, &logo.Height,
+			&p.Budget, &p.Currency, &p.Donor, &p.Mission, &p.Vision,
+			&p.TimelineFrom, &p.TimelineTo, &p.BoundaryPartnerIds, &p.BoundaryPartnerNames, &p.ResourceIds, &p.ResourceUrls,
+			&storageKeys, &p.Comments)
+		if err != nil {
+			return nil, err
+		}
+		p.Logo = projectLogo(p.ProjectId, logo)
+		for i := range p.ResourceUrls {
+			p.ResourceUrls[i] = resourceUrl(p.ProjectId, p.ResourceIds[i], p.ResourceUrls[i], storageKeys[i])
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+func (s *pgStore) GetProjects(t Tenant) ([]Project, error) {
+	var projects []Project
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		projects, err = queryProjects(tx, "WHERE organization_id = $1", t.OrganizationId)
+		return err
+	})
+	return projects, err
+}
+
+func (s *pgStore) GetProject(t Tenant, projectId string) (Project, error) {
+	var projects []Project
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		projects, err = queryProjects(tx, "WHERE organization_id = $1 AND project_id = $2", t.OrganizationId, projectId)
+		return err
+	})
+	if err != nil {
+		return Project{}, err
+	}
+	if len(projects) == 0 {
+		return Project{}, ErrNotFound
+	}
+	return projects[0], nil
+}
+
+func (s *pgStore) AddProject(t Tenant, p Project) (string, error) {
+	projectId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("INSERT INTO projects (project_id, organization_id, project_name, description, budget, currency, vision, mission) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			projectId, t.OrganizationId, p.ProjectName, p.Description, p.Budget, p.Currency, p.Vision, p.Mission)
+		if err != nil {
+			return err
+		}
+		// a donor given by name is registered with a grant without amount
+		if donor := strings.TrimSpace(p.Donor); donor != "" {
+			_, err = insertGrant(tx, t, "", Grant{ProjectId: projectId, DonorName: donor, Currency: p.Currency})
+		}
+		return err
+	})
+	return projectId, err
+}
+
+func (s *pgStore) SetProjectField(t Tenant, projectId, column string, value interface{}) error {
+	if !projectColumns[column] {
+		return fmt.Errorf("unknown project column %q", column)
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("UPDATE projects SET "+column+" = $1 WHERE project_id = $2 AND organization_id = $3",
+			value, projectId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) SetProjectCurrency(t Tenant, projectId, currency string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		// lock the project so no budget line is added while the currency changes
+		var entries int
+		err := tx.QueryRow(`
+			SELECT
+			  (SELECT count(*) FROM budget_lines bl WHERE bl.project_id = p.project_id) +
+			  (SELECT count(*) FROM expenditures e WHERE e.project_id = p.project_id)
+			FROM projects p WHERE project_id = $1 AND organization_id = $2 FOR UPDATE`,
+			projectId, t.OrganizationId).Scan(&entries)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if entries > 0 {
+			return FieldErrors{"project_currency": "can not be changed once the project has budget lines or expenditures"}
+		}
+		_, err = tx.Exec("UPDATE projects SET currency = $1 WHERE project_id = $2", currency, projectId)
+		return err
+	})
+}
+
+func (s *pgStore) SetProjectTimeline(t Tenant, projectId string, from, to interface{}) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("UPDATE projects SET timeline_from = $1, timeline_to = $2 WHERE project_id = $3 AND organization_id = $4",
+			from, to, projectId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) SetProjectLogo(t Tenant, projectId string, logo *ProjectLogo) (*ProjectLogo, error) {
+	var old ProjectLogo
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(`
+			SELECT
+			  coalesce(logo_key, ''), coalesce(logo_medium_key, ''), coalesce(logo_thumbnail_key, ''),
+			  coalesce(logo_content_type, ''), coalesce(logo_width, 0), coalesce(logo_height, 0)
+			FROM projects WHERE project_id = $1 AND organization_id = $2 FOR UPDATE`,
+			projectId, t.OrganizationId).Scan(&old.OriginalKey, &old.MediumKey, &old.ThumbnailKey, &old.ContentType, &old.Width, &old.Height)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if logo == nil {
+			logo = &ProjectLogo{}
+		}
+		_, err = tx.Exec(`
+			UPDATE projects SET
+			  logo_key = nullif($1, ''), logo_medium_key = nullif($2, ''), logo_thumbnail_key = nullif($3, ''),
+			  logo_content_type = nullif($4, ''), logo_width = nullif($5, 0), logo_height = nullif($6, 0)
+			WHERE project_id = $7`,
+			logo.OriginalKey, logo.MediumKey, logo.ThumbnailKey, logo.ContentType, logo.Width, logo.Height, projectId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return projectLogo(projectId, old), nil
+}
+
+func (s *pgStore) DeleteProject(t Tenant, projectId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM projects WHERE project_id = $1 AND organization_id = $2", projectId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) AddBoundaryPartner(t Tenant, projectId string, bp BoundaryPartner) (string, error) {
+	partnerId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			INSERT INTO boundary_partners (boundary_partner_id, project_id, partner_name)
+			SELECT $1, project_id, $2 FROM projects WHERE project_id = $3 AND organization_id = $4`,
+			partnerId, bp.PartnerName, projectId, t.OrganizationId))
+	})
+	return partnerId, err
+}
+
+func (s *pgStore) GetBoundaryPartner(t Tenant, projectId, partnerId string) (BoundaryPartner, error) {
+	var bp BoundaryPartner
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return queryPartner(tx, t, projectId, partnerId, &bp)
+	})
+	return bp, err
+}
+
+// queryPartner loads a boundary partner into bp with its progress markers and
+// their challenges and strategies.
+func queryPartner(tx *sqlx.Tx, t Tenant, projectId, partnerId string, bp *BoundaryPartner) error {
+	err := tx.QueryRow(`
+		SELECT
+		  boundary_partner_id, project_id, coalesce(partner_name, ''), coalesce(outcome_statement, ''),
+		  (SELECT count(*) FROM comments c WHERE c.boundary_partner_id = bp.boundary_partner_id AND c.entity_type = 'boundary_partner'),
+		  (SELECT count(*) FROM comments c WHERE c.boundary_partner_id = bp.boundary_partner_id AND c.entity_type = 'outcome_statement')
+		FROM boundary_partners bp
+		JOIN projects USING (project_id)
+		WHERE boundary_partner_id = $1 AND project_id = $2 AND organization_id = $3
+	`, partnerId, projectId, t.OrganizationId).Scan(&bp.BoundaryPartnerId, &bp.ProjectId, &bp.PartnerName, &bp.OutcomeStatement,
+		&bp.Comments, &bp.OutcomeStatementComments)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	markerDict := make(map[string]*ProgressMarker)
+	rows, err := tx.Query(`
+		SELECT
+		  progress_marker_id, coalesce(title, ''), coalesce(type, 0), coalesce(order_number, 0),
+		  (SELECT count(*) FROM comments c WHERE c.progress_marker_id = pm.progress_marker_id)
+		FROM progress_markers pm
+		WHERE boundary_partner_id = $1
+		ORDER BY order_number, ts_created
+	`, bp.BoundaryPartnerId)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		pm := ProgressMarker{BoundaryPartnerId: bp.BoundaryPartnerId}
+		err = rows.Scan(&pm.ProgressMarkerId, &pm.Title, &pm.Type, &pm.OrderNumber, &pm.Comments)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		bp.ProgressMarkers = append(bp.ProgressMarkers, &pm)
+		markerDict[pm.ProgressMarkerId] = &pm
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT
+		  challenge_id, progress_marker_id, coalesce(challenge_name, ''),
+		  (SELECT count(*) FROM comments c WHERE c.challenge_id = challenges.challenge_id)
+		FROM challenges
+		JOIN progress_markers USING (progress_marker_id)
+		WHERE boundary_partner_id = $1
+		ORDER BY challenges.ts_created
+	`, bp.BoundaryPartnerId)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		c := Challenge{}
+		err = rows.Scan(&c.ChallengeId, &c.ProgressMarkerId, &c.ChallengeName, &c.Comments)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		pm := markerDict[c.ProgressMarkerId]
+		pm.Challenges = append(pm.Challenges, &c)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT
+		  strategy_id, progress_marker_id, coalesce(strategy_name, ''),
+		  (SELECT count(*) FROM comments c WHERE c.strategy_id = strategies.strategy_id)
+		FROM strategies
+		JOIN progress_markers USING (progress_marker_id)
+		WHERE boundary_partner_id = $1
+		ORDER BY strategies.ts_created
+	`, bp.BoundaryPartnerId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		strat := Strategy{}
+		err = rows.Scan(&strat.StrategyId, &strat.ProgressMarkerId, &strat.StrategyName, &strat.Comments)
+		if err != nil {
+			return err
+		}
+		pm := markerDict[strat.ProgressMarkerId]
+		pm.Strategies = append(pm.Strategies, &strat)
+	}
+	return rows.Err()
+}
+
+func (s *pgStore) SetPartnerField(t Tenant, projectId, partnerId, column string, value interface{}) error {
+	if !partnerColumns[column] {
+		return fmt.Errorf("unknown boundary partner column %q", column)
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			UPDATE boundary_partners SET `+column+` = $1
+			WHERE boundary_partner_id = $2 AND project_id IN (
+			  SELECT project_id FROM projects WHERE project_id = $3 AND organization_id = $4)`,
+			value, partnerId, projectId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) DeleteBoundaryPartner(t Tenant, projectId, partnerId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			DELETE FROM boundary_partners
+			WHERE boundary_partner_id = $1 AND project_id IN (
+			  SELECT project_id FROM projects WHERE project_id = $2 AND organization_id = $3)`,
+			partnerId, projectId, t.OrganizationId))
+	})
+}
+
+// scopedPartnerIds selects the boundary partners of project $2 in organization $3.
+const scopedPartnerIds = `
+	SELECT boundary_partner_id FROM boundary_partners
+	JOIN projects USING (project_id)
+	WHERE project_id = $2 AND organization_id = $3`
+
+// scopedMarkerIds selects the progress markers of project $2 in organization $3.
+const scopedMarkerIds = `
+	SELECT progress_marker_id FROM progress_markers
+	JOIN boundary_partners USING (boundary_partner_id)
+	JOIN projects USING (project_id)
+	WHERE project_id = $2 AND organization_id = $3`
+
+func (s *pgStore) AddProgressMarker(t Tenant, projectId, partnerId string, pm ProgressMarker) (string, error) {
+	markerId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return insertMarker(tx, t, projectId, partnerId, markerId, pm)
+	})
+	return markerId, err
+}
+
+// insertMarker appends a progress marker to the end of the partner's order.
+func insertMarker(tx *sqlx.Tx, t Tenant, projectId, partnerId, markerId string, pm ProgressMarker) error {
+	// lock the partner so concurrent inserts get distinct order numbers
+	var locked string
+	err := tx.QueryRow("SELECT boundary_partner_id FROM boundary_partners WHERE boundary_partner_id = $1 AND boundary_partner_id IN ("+
+		scopedPartnerIds+") FOR UPDATE", partnerId, projectId, t.OrganizationId).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var orderNumber int
+	err = tx.QueryRow("SELECT count(*) FROM progress_markers WHERE boundary_partner_id = $1", partnerId).Scan(&orderNumber)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO progress_markers (progress_marker_id, boundary_partner_id, title, type, order_number, ts_created) VALUES ($1, $2, $3, $4, $5, clock_timestamp())",
+		markerId, partnerId, pm.Title, pm.Type, orderNumber+1)
+	return err
+}
+
+func (s *pgStore) UpdateProgressMarker(t Tenant, projectId, markerId string, pm ProgressMarker) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var oldOrderNumber int
+		var boundaryPartnerId string
+		err := tx.QueryRow("SELECT order_number, boundary_partner_id FROM progress_markers WHERE progress_marker_id = $1 AND progress_marker_id IN ("+
+			scopedMarkerIds+") FOR UPDATE", markerId, projectId, t.OrganizationId).Scan(&oldOrderNumber, &boundaryPartnerId)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var markerCount int
+		err = tx.QueryRow("SELECT count(*) FROM progress_markers WHERE boundary_partner_id = $1", boundaryPartnerId).Scan(&markerCount)
+		if err != nil {
+			return err
+		}
+		if errs := validate(intRange("order_number", pm.OrderNumber, 1, markerCount)); errs != nil {
+			return errs
+		}
+
+		if oldOrderNumber > pm.OrderNumber {
+			// increase the order number of the markers between the new and the old position by one.
+			_, err = tx.Exec("UPDATE progress_markers SET order_number = order_number + 1 "+
+				"WHERE boundary_partner_id = $1 and order_number >= $2 and order_number <= $3",
+				boundaryPartnerId, pm.OrderNumber, oldOrderNumber-1)
+		} else if oldOrderNumber < pm.OrderNumber {
+			// decrease the order number of the markers between the old and the new position by one.
+			_, err = tx.Exec("UPDATE progress_markers SET order_number = order_number - 1 "+
+				"WHERE boundary_partner_id = $1 and order_number >= $2 and order_number <= $3",
+				boundaryPartnerId, oldOrderNumber+1, pm.OrderNumber)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE progress_markers SET title = $1, type = $2, order_number = $3 WHERE progress_marker_id = $4",
+			pm.Title, pm.Type, pm.OrderNumber, markerId)
+		return err
+	})
+}
+
+func (s *pgStore) DeleteProgressMarker(t Tenant, projectId, markerId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var orderNumber int
+		var boundaryPartnerId string
+		err := tx.QueryRow("DELETE FROM progress_markers WHERE progress_marker_id = $1 AND progress_marker_id IN ("+
+			scopedMarkerIds+") RETURNING order_number, boundary_partner_id", markerId, projectId, t.OrganizationId).Scan(&orderNumber, &boundaryPartnerId)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// close the gap left in the order
+		_, err = tx.Exec("UPDATE progress_markers SET order_number = order_number - 1 WHERE boundary_partner_id = $1 AND order_number > $2",
+			boundaryPartnerId, orderNumber)
+		return err
+	})
+}
+
+func (s *pgStore) AddChallenge(t Tenant, projectId, markerId string, c Challenge) (string, error) {
+	challengeId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return insertChallenge(tx, t, projectId, markerId, challengeId, c.ChallengeName)
+	})
+	return challengeId, err
+}
+
+func insertChallenge(tx *sqlx.Tx, t Tenant, projectId, markerId, challengeId, name string) error {
+	return expectRow(tx.Exec("INSERT INTO challenges (challenge_id, progress_marker_id, challenge_name, ts_created) SELECT $1, progress_marker_id, $4, clock_timestamp() FROM ("+
+		scopedMarkerIds+") m WHERE progress_marker_id = $5", challengeId, projectId, t.OrganizationId, name, markerId))
+}
+
+func (s *pgStore) UpdateChallenge(t Tenant, projectId, challengeId string, c Challenge) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return updateChallenge(tx, t, projectId, challengeId, c.ChallengeName)
+	})
+}
+
+func updateChallenge(tx *sqlx.Tx, t Tenant, projectId, challengeId, name string) error {
+	return expectRow(tx.Exec("UPDATE challenges SET challenge_name = $4 WHERE challenge_id = $1 AND progress_marker_id IN ("+
+		scopedMarkerIds+")", challengeId, projectId, t.OrganizationId, name))
+}
+
+func (s *pgStore) DeleteChallenge(t Tenant, projectId, challengeId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM challenges WHERE challenge_id = $1 AND progress_marker_id IN ("+
+			scopedMarkerIds+")", challengeId, projectId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) AddStrategy(t Tenant, projectId, markerId string, strat Strategy) (string, error) {
+	strategyId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return insertStrategy(tx, t, projectId, markerId, strategyId, strat.StrategyName)
+	})
+	return strategyId, err
+}
+
+func insertStrategy(tx *sqlx.Tx, t Tenant, projectId, markerId, strategyId, name string) error {
+	return expectRow(tx.Exec("INSERT INTO strategies (strategy_id, progress_marker_id, strategy_name, ts_created) SELECT $1, progress_marker_id, $4, clock_timestamp() FROM ("+
+		scopedMarkerIds+") m WHERE progress_marker_id = $5", strategyId, projectId, t.OrganizationId, name, markerId))
+}
+
+func (s *pgStore) UpdateStrategy(t Tenant, projectId, strategyId string, strat Strategy) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return updateStrategy(tx, t, projectId, strategyId, strat.StrategyName)
+	})
+}
+
+func updateStrategy(tx *sqlx.Tx, t Tenant, projectId, strategyId, name string) error {
+	return expectRow(tx.Exec("UPDATE strategies SET strategy_name = $4 WHERE strategy_id = $1 AND progress_marker_id IN ("+
+		scopedMarkerIds+")", strategyId, projectId, t.OrganizationId, name))
+}
+
+func (s *pgStore) ApplyMarkerChanges(t Tenant, projectId string, changes []MarkerChange) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		for i := range changes {
+			c := &changes[i]
+			if c.Action == CHANGE_UNCHANGED {
+				continue
+			}
+			if c.NewMarker >= 0 {
+				c.ProgressMarkerId = changes[c.NewMarker].Id
+			}
+			if c.Action == CHANGE_CREATE {
+				c.Id = uuid.NewV4().String()
+			}
+			var err error
+			switch {
+			case c.Kind == KIND_MARKER && c.Action == CHANGE_CREATE:
+				err = insertMarker(tx, t, projectId, c.BoundaryPartnerId, c.Id, ProgressMarker{Title: c.Name, Type: c.Type})
+			case c.Kind == KIND_MARKER:
+				err = expectRow(tx.Exec("UPDATE progress_markers SET title = $4, type = $5 WHERE progress_marker_id = $1 AND progress_marker_id IN ("+
+					scopedMarkerIds+")", c.Id, projectId, t.OrganizationId, c.Name, c.Type))
+			case c.Kind == KIND_CHALLENGE && c.Action == CHANGE_CREATE:
+				err = insertChallenge(tx, t, projectId, c.ProgressMarkerId, c.Id, c.Name)
+			case c.Kind == KIND_CHALLENGE:
+				err = updateChallenge(tx, t, projectId, c.Id, c.Name)
+			case c.Kind == KIND_STRATEGY && c.Action == CHANGE_CREATE:
+				err = insertStrategy(tx, t, projectId, c.ProgressMarkerId, c.Id, c.Name)
+			case c.Kind == KIND_STRATEGY:
+				err = updateStrategy(tx, t, projectId, c.Id, c.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *pgStore) DeleteStrategy(t Tenant, projectId, strategyId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM strategies WHERE strategy_id = $1 AND progress_marker_id IN ("+
+			scopedMarkerIds+")", strategyId, projectId, t.OrganizationId))
+	})
+}
+
+// selectResources is completed with a WHERE clause by queryResources.
+const selectResources = `
+	SELECT
+	  resource_id, project_id, resource_type, coalesce(resource_url, ''), coalesce(resource_name, ''),
+	  coalesce(title, ''), coalesce(description, ''), tags, coalesce(favicon_url, ''),
+	  coalesce(boundary_partner_id::text, ''), coalesce(progress_marker_id::text, ''), coalesce(journal_id::text, ''),
+	  coalesce(size, 0), coalesce(sha256, ''), coalesce(content_type, ''), scan_status,
+	  coalesce(current_version, 0), coalesce(storage_key, '')
+	FROM external_resources
+	JOIN projects USING (project_id)
+`
+
+func queryResources(tx *sqlx.Tx, where string, args ...interface{}) ([]ExternalResources, error) {
+	resources := []ExternalResources{}
+	rows, err := tx.Query(selectResources+where+" ORDER BY external_resources.ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		exr := ExternalResources{}
+		err = rows.Scan(&exr.ResourceId, &exr.ProjectId, &exr.ResourceType, &exr.ResourceUrl, &exr.ResourceName,
+			&exr.Title, &exr.Description, &exr.Tags, &exr.FaviconUrl,
+			&exr.BoundaryPartnerId, &exr.ProgressMarkerId, &exr.JournalId,
+			&exr.Size, &exr.SHA256, &exr.ContentType, &exr.ScanStatus, &exr.Version, &exr.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		exr.ResourceUrl = resourceUrl(exr.ProjectId, exr.ResourceId, exr.ResourceUrl, exr.StorageKey)
+		resources = append(resources, exr)
+	}
+	return resources, rows.Err()
+}
+
+func (s *pgStore) GetExternalResources(t Tenant, projectId string) ([]ExternalResources, error) {
+	var resources []ExternalResources
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		resources, err = queryResources(tx, "WHERE project_id = $1 AND organization_id = $2 AND scan_status = 'clean'",
+			projectId, t.OrganizationId)
+		return err
+	})
+	return resources, err
+}
+
+func (s *pgStore) GetQuarantinedResources(t Tenant, projectId string) ([]ExternalResources, error) {
+	var resources []ExternalResources
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		resources, err = queryResources(tx, "WHERE project_id = $1 AND organization_id = $2 AND scan_status <> 'clean'",
+			projectId, t.OrganizationId)
+		return err
+	})
+	return resources, err
+}
+
+func (s *pgStore) GetPendingScans(t Tenant) ([]PendingScan, error) {
+	scans := []PendingScan{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		rows, err := tx.Query(`
+			SELECT r.project_id, v.resource_id, v.version_number, coalesce(v.resource_name, ''), v.storage_key
+			FROM resource_versions v
+			JOIN external_resources r ON r.resource_id = v.resource_id
+			JOIN projects p ON p.project_id = r.project_id
+			WHERE p.organization_id = $1 AND v.scan_status = 'pending'
+			ORDER BY v.ts_created, v.resource_id, v.version_number`,
+			t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p PendingScan
+			if err = rows.Scan(&p.ProjectId, &p.ResourceId, &p.VersionNumber, &p.ResourceName, &p.StorageKey); err != nil {
+				return err
+			}
+			scans = append(scans, p)
+		}
+		return rows.Err()
+	})
+	return scans, err
+}
+
+func (s *pgStore) SetScanStatus(t Tenant, resourceId string, number int, status string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.Exec(`
+			UPDATE resource_versions SET scan_status = $1
+			WHERE resource_id = $2 AND version_number = $3 AND scan_status = 'pending' AND resource_id IN (
+			  SELECT resource_id FROM external_resources JOIN projects USING (project_id) WHERE organization_id = $4)`,
+			status, resourceId, number, t.OrganizationId))
+		if err != nil {
+			return err
+		}
+		if status == SCAN_QUARANTINED {
+			_, err = tx.Exec(`
+				UPDATE external_resources SET scan_status = $1
+				WHERE resource_id = $2 AND scan_status = 'pending' AND current_version = $3`,
+				status, resourceId, number)
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE external_resources r SET
+			  resource_name = v.resource_name, storage_key = v.storage_key, size = v.size, sha256 = v.sha256,
+			  content_type = v.content_type, scan_status = v.scan_status, current_version = v.version_number
+			FROM resource_versions v
+			WHERE r.resource_id = $1 AND v.resource_id = r.resource_id AND v.version_number = $2
+			  AND (r.scan_status <> 'clean' OR r.current_version < $2)`,
+			resourceId, number)
+		return err
+	})
+}
+
+func (s *pgStore) GetExternalResource(t Tenant, projectId, resourceId string) (ExternalResources, error) {
+	var resources []ExternalResources
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		resources, err = queryResources(tx, "WHERE resource_id = $1 AND project_id = $2 AND organization_id = $3 AND scan_status = 'clean'",
+			resourceId, projectId, t.OrganizationId)
+		return err
+	})
+	if err != nil {
+		return ExternalResources{}, err
+	}
+	if len(resources) == 0 {
+		return ExternalResources{}, ErrNotFound
+	}
+	return resources[0], nil
+}
+
+func (s *pgStore) AddExternalResource(t Tenant, userId string, exr ExternalResources) (string, error) {
+	resourceId := uuid.NewV1().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return insertResource(tx, t, resourceId, userId, exr)
+	})
+	return resourceId, err
+}
+
+// insertResource adds a resource to the tenant's project exr.ProjectId. A
+// stored file becomes the first version of the resource.
+func insertResource(tx *sqlx.Tx, t Tenant, resourceId, userId string, exr ExternalResources) error {
+	version := 0
+	if exr.StorageKey != "" {
+		version = 1
+	}
+	err := expectRow(tx.Exec(`
+		INSERT INTO external_resources (
+		  resource_id, project_id, resource_type, resource_url, resource_name, title, description, tags,
+		  favicon_url, boundary_partner_id, progress_marker_id, journal_id, size, sha256, content_type, scan_status,
+		  storage_key, current_version, created_by, ts_created
+		)
+		SELECT
+		  $1, project_id, $2, nullif($3, ''), $4, nullif($5, ''), nullif($6, ''), $7,
+		  nullif($8, ''), nullif($9, '')::UUID, nullif($10, '')::UUID, nullif($11, '')::UUID, nullif($12, 0), nullif($13, ''),
+		  nullif($14, ''), $15, nullif($16, ''), nullif($17, 0), $18, clock_timestamp()
+		FROM projects WHERE project_id = $19 AND organization_id = $20`,
+		resourceId, exr.ResourceType, exr.ResourceUrl, exr.ResourceName, exr.Title, exr.Description, normalizeTags(exr.Tags),
+		exr.FaviconUrl, exr.BoundaryPartnerId, exr.ProgressMarkerId, exr.JournalId, exr.Size, exr.SHA256, exr.ContentType,
+		scanStatus(exr), exr.StorageKey, version, userId, exr.ProjectId, t.OrganizationId))
+	if err != nil || version == 0 {
+		return err
+	}
+	return insertVersion(tx, resourceId, version, userId, versionOf(exr))
+}
+
+func insertVersion(tx *sqlx.Tx, resourceId string, number int, userId string, v ResourceVersion) error {
+	_, err := tx.Exec(`
+		INSERT INTO resource_versions (
+		  resource_id, version_number, resource_name, storage_key, size, sha256, content_type, scan_status, created_by
+		) VALUES ($1, $2, $3, $4, nullif($5, 0), nullif($6, ''), nullif($7, ''), $8, $9)`,
+		resourceId, number, v.ResourceName, v.StorageKey, v.Size, v.SHA256, v.ContentType, v.ScanStatus, userId)
+	return err
+}
+
+func (s *pgStore) AddResourceVersion(t Tenant, projectId, resourceId, userId string, v ResourceVersion) (int, error) {
+	var number int
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		// locking the resource serializes concurrent uploads of new versions
+		var resourceType string
+		err := tx.QueryRow(`
+			SELECT resource_type FROM external_resources JOIN projects USING (project_id)
+			WHERE resource_id = $1 AND project_id = $2 AND organization_id = $3
+			FOR UPDATE OF external_resources`,
+			resourceId, projectId, t.OrganizationId).Scan(&resourceType)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if resourceType != RESOURCE_FILE {
+			return FieldErrors{"resource_id": "only files have versions"}
+		}
+		err = tx.QueryRow("SELECT coalesce(max(version_number), 0) + 1 FROM resource_versions WHERE resource_id = $1",
+			resourceId).Scan(&number)
+		if err != nil {
+			return err
+		}
+		if err = insertVersion(tx, resourceId, number, userId, v); err != nil {
+			return err
+		}
+		if v.ScanStatus != SCAN_CLEAN {
+			return nil
+		}
+		_, err = tx.Exec(`
+			UPDATE external_resources SET
+			  resource_name = $1, storage_key = $2, size = nullif($3, 0), sha256 = nullif($4, ''),
+			  content_type = nullif($5, ''), scan_status = $6, current_version = $7
+			WHERE resource_id = $8`,
+			v.ResourceName, v.StorageKey, v.Size, v.SHA256, v.ContentType, v.ScanStatus, number, resourceId)
+		return err
+	})
+	return number, err
+}
+
+// selectVersions is completed with a WHERE clause by queryVersions.
+const selectVersions = `
+	SELECT
+	  v.resource_id, v.version_number, coalesce(v.resource_name, ''), coalesce(v.size, 0), coalesce(v.sha256, ''),
+	  coalesce(v.content_type, ''), v.scan_status, coalesce(v.created_by::text, ''), coalesce(u.full_name, ''),
+	  v.ts_created, v.version_number = coalesce(r.current_version, 0), v.storage_key
+	FROM resource_versions v
+	JOIN external_resources r ON r.resource_id = v.resource_id
+	JOIN projects p ON p.project_id = r.project_id
+	LEFT JOIN users u ON u.user_id = v.created_by
+`
+
+func queryVersions(tx *sqlx.Tx, where string, args ...interface{}) ([]ResourceVersion, error) {
+	versions := []ResourceVersion{}
+	rows, err := tx.Query(selectVersions+where+" ORDER BY v.version_number DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := ResourceVersion{}
+		err = rows.Scan(&v.ResourceId, &v.VersionNumber, &v.ResourceName, &v.Size, &v.SHA256,
+			&v.ContentType, &v.ScanStatus, &v.CreatedBy, &v.CreatedByName,
+			&v.TsCreated, &v.Current, &v.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func (s *pgStore) GetResourceVersions(t Tenant, projectId, resourceId string) ([]ResourceVersion, error) {
+	var versions []ResourceVersion
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		versions, err = queryVersions(tx, "WHERE v.resource_id = $1 AND r.project_id = $2 AND p.organization_id = $3",
+			resourceId, projectId, t.OrganizationId)
+		return err
+	})
+	return versions, err
+}
+
+func (s *pgStore) GetResourceVersion(t Tenant, projectId, resourceId string, number int) (ResourceVersion, error) {
+	var versions []ResourceVersion
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		versions, err = queryVersions(tx,
+			"WHERE v.resource_id = $1 AND r.project_id = $2 AND p.organization_id = $3 AND v.version_number = $4",
+			resourceId, projectId, t.OrganizationId, number)
+		return err
+	})
+	if err != nil {
+		return ResourceVersion{}, err
+	}
+	if len(versions) == 0 {
+		return ResourceVersion{}, ErrNotFound
+	}
+	return versions[0], nil
+}
+
+func (s *pgStore) DeleteExternalResource(t Tenant, projectId, resourceId string) (ExternalResources, error) {
+	exr := ExternalResources{ResourceId: resourceId, ProjectId: projectId}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(`
+			DELETE FROM external_resources
+			WHERE resource_id = $1 AND project_id IN (
+			  SELECT project_id FROM projects WHERE project_id = $2 AND organization_id = $3)
+			RETURNING coalesce(resource_url, ''), coalesce(resource_name, ''), coalesce(storage_key, '')`,
+			resourceId, projectId, t.OrganizationId).Scan(&exr.ResourceUrl, &exr.ResourceName, &exr.StorageKey)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	return exr, err
+}
+
+func (s *pgStore) StorageUsage(t Tenant) (int64, int64, error) {
+	var used, quota int64
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		// a file uploaded twice is stored once and counted once
+		return tx.QueryRow(`
+			SELECT
+			  (SELECT coalesce(sum(size), 0) FROM (
+			    SELECT storage_key, size FROM external_resources JOIN projects USING (project_id)
+			    WHERE organization_id = $1 AND storage_key IS NOT NULL
+			    UNION
+			    SELECT v.storage_key, v.size FROM resource_versions v
+			    JOIN external_resources USING (resource_id) JOIN projects USING (project_id)
+			    WHERE organization_id = $1
+			  ) blobs),
+			  coalesce((SELECT storage_quota FROM organizations WHERE organization_id = $1), -1)`,
+			t.OrganizationId).Scan(&used, &quota)
+	})
+	return used, quota, err
+}
+
+func (s *pgStore) PinBlob(t Tenant, key string, until time.Time) (func(), error) {
+	pinId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		// waits for a deletion of the blob in progress
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", BLOB_LOCK_ID, key); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM blob_pins WHERE storage_key = $1 AND organization_id = $2 AND pinned_until <= now()",
+			key, t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO blob_pins (pin_id, organization_id, storage_key, pinned_until) VALUES ($1, $2, $3, $4)",
+			pinId, t.OrganizationId, key, until)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	unpin := func() {
+		err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec("DELETE FROM blob_pins WHERE pin_id = $1 AND organization_id = $2", pinId, t.OrganizationId)
+			return err
+		})
+		if err != nil {
+			warnf("unpin blob %s: %v", key, err)
+		}
+	}
+	return unpin, nil
+}
+
+// DeleteUnusedBlob holds a transaction level advisory lock on the key while
+// it checks the references and deletes the blob, PinBlob takes the same lock.
+func (s *pgStore) DeleteUnusedBlob(t Tenant, key string, del func() error) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", BLOB_LOCK_ID, key); err != nil {
+			return err
+		}
+		var inUse bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (
+			  SELECT 1 FROM external_resources JOIN projects USING (project_id)
+			  WHERE storage_key = $1 AND organization_id = $2
+			) OR EXISTS (
+			  SELECT 1 FROM resource_versions v
+			  JOIN external_resources USING (resource_id) JOIN projects USING (project_id)
+			  WHERE v.storage_key = $1 AND organization_id = $2
+			) OR EXISTS (
+			  SELECT 1 FROM projects
+			  WHERE $1 IN (logo_key, logo_medium_key, logo_thumbnail_key) AND organization_id = $2
+			) OR EXISTS (
+			  SELECT 1 FROM organizations
+			  WHERE $1 IN (logo_key, logo_medium_key, logo_thumbnail_key) AND organization_id = $2
+			) OR EXISTS (
+			  SELECT 1 FROM blob_pins
+			  WHERE storage_key = $1 AND organization_id = $2 AND pinned_until > now()
+			)`, key, t.OrganizationId).Scan(&inUse)
+		if err != nil || inUse {
+			return err
+		}
+		return del()
+	})
+}
+
+// selectJournals is completed with a WHERE clause by queryJournals.
+const selectJournals = `
+	SELECT
+	  j.journal_id, j.project_id, j.boundary_partner_id, to_char(j.monitoring_date, 'YYYY-MM-DD'),
+	  coalesce(j.description_of_change, ''), coalesce(j.contributing_factors, ''), coalesce(j.sources_of_evidence, ''),
+	  coalesce(j.unanticipated_change, ''), coalesce(j.lessons, ''), j.status, j.submitted_at, coalesce(j.submitted_by::TEXT, '')
+	FROM outcome_journals j
+	JOIN projects p ON p.project_id = j.project_id
+`
+
+// queryJournals loads journals and their ratings.
+func queryJournals(tx *sqlx.Tx, where string, args ...interface{}) ([]OutcomeJournal, error) {
+	journals := []OutcomeJournal{}
+	rows, err := tx.Query(selectJournals+where+" ORDER BY j.monitoring_date DESC, j.ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byId := make(map[string]*OutcomeJournal)
+	var ids []string
+	for rows.Next() {
+		j := OutcomeJournal{Ratings: []JournalRating{}}
+		err = rows.Scan(&j.JournalId, &j.ProjectId, &j.BoundaryPartnerId, &j.MonitoringDate,
+			&j.DescriptionOfChange, &j.ContributingFactors, &j.SourcesOfEvidence, &j.UnanticipatedChange, &j.Lessons,
+			&j.Status, &j.SubmittedAt, &j.SubmittedBy)
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, j)
+		ids = append(ids, j.JournalId)
+	}
+	if err = rows.Err(); err != nil || len(journals) == 0 {
+		return journals, err
+	}
+	rows.Close()
+	for i := range journals {
+		byId[journals[i].JournalId] = &journals[i]
+	}
+
+	rows, err = tx.Query(`
+		SELECT r.journal_id, r.progress_marker_id, r.rating
+		FROM journal_ratings r
+		JOIN progress_markers pm USING (progress_marker_id)
+		WHERE r.journal_id = ANY($1)
+		ORDER BY pm.order_number`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var journalId string
+		var r JournalRating
+		if err = rows.Scan(&journalId, &r.ProgressMarkerId, &r.Rating); err != nil {
+			return nil, err
+		}
+		j := byId[journalId]
+		j.Ratings = append(j.Ratings, r)
+	}
+	return journals, rows.Err()
+}
+
+func (s *pgStore) GetJournals(t Tenant, projectId, partnerId string) ([]OutcomeJournal, error) {
+	var journals []OutcomeJournal
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		journals, err = queryJournals(tx, `
+			WHERE j.project_id = $1 AND p.organization_id = $2
+			  AND (nullif($3, '') IS NULL OR j.boundary_partner_id = nullif($3, '')::UUID)`,
+			projectId, t.OrganizationId, partnerId)
+		return err
+	})
+	return journals, err
+}
+
+func (s *pgStore) GetJournal(t Tenant, projectId, journalId string) (OutcomeJournal, error) {
+	var journals []OutcomeJournal
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		journals, err = queryJournals(tx, "WHERE j.project_id = $1 AND p.organization_id = $2 AND j.journal_id = $3",
+			projectId, t.OrganizationId, journalId)
+		return err
+	})
+	if err != nil {
+		return OutcomeJournal{}, err
+	}
+	if len(journals) == 0 {
+		return OutcomeJournal{}, ErrNotFound
+	}
+	return journals[0], nil
+}
+
+// checkJournal checks that no other journal of the boundary partner has the
+// monitoring date and that the rated markers are the partner's. The partner
+// is locked first, so that two journals for the same date can not be added
+// at once.
+func checkJournal(tx *sqlx.Tx, t Tenant, projectId, journalId string, j OutcomeJournal) error {
+	var locked string
+	err := tx.QueryRow("SELECT boundary_partner_id FROM boundary_partners WHERE boundary_partner_id = $1 AND boundary_partner_id IN ("+
+		scopedPartnerIds+") FOR UPDATE", j.BoundaryPartnerId, projectId, t.OrganizationId).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return errJournalPartner
+	}
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.QueryRow(`
+		SELECT count(*) FROM outcome_journals
+		WHERE boundary_partner_id = $1 AND monitoring_date = $2::DATE AND journal_id <> $3`,
+		j.BoundaryPartnerId, j.MonitoringDate, journalId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errJournalDate
+	}
+
+	var markerIds []string
+	for _, r := range j.Ratings {
+		markerIds = append(markerIds, r.ProgressMarkerId)
+	}
+	err = tx.QueryRow("SELECT count(*) FROM progress_markers WHERE boundary_partner_id = $1 AND progress_marker_id = ANY($2::UUID[])",
+		j.BoundaryPartnerId, pq.Array(markerIds)).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(markerIds) {
+		return errJournalMarkers
+	}
+	return nil
+}
+
+// setJournalRatings replaces the ratings of a journal.
+func setJournalRatings(tx *sqlx.Tx, journalId string, ratings []JournalRating) error {
+	if _, err := tx.Exec("DELETE FROM journal_ratings WHERE journal_id = $1", journalId); err != nil {
+		return err
+	}
+	for _, r := range ratings {
+		_, err := tx.Exec("INSERT INTO journal_ratings (journal_id, progress_marker_id, rating) VALUES ($1, $2, $3)",
+			journalId, r.ProgressMarkerId, r.Rating)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pgStore) AddJournal(t Tenant, userId string, j OutcomeJournal) (string, error) {
+	journalId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkJournal(tx, t, j.ProjectId, journalId, j); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO outcome_journals (
+			  journal_id, project_id, boundary_partner_id, monitoring_date, description_of_change,
+			  contributing_factors, sources_of_evidence, unanticipated_change, lessons, created_by
+			)
+			VALUES ($1, $2, $3, $4::DATE, nullif($5, ''), nullif($6, ''), nullif($7, ''), nullif($8, ''), nullif($9, ''), $10)`,
+			journalId, j.ProjectId, j.BoundaryPartnerId, j.MonitoringDate, j.DescriptionOfChange,
+			j.ContributingFactors, j.SourcesOfEvidence, j.UnanticipatedChange, j.Lessons, userId)
+		if err != nil {
+			return err
+		}
+		return setJournalRatings(tx, journalId, j.Ratings)
+	})
+	return journalId, err
+}
+
+// lockDraftJournal locks a journal of the tenant's project and fails unless
+// it is a draft. It returns the journal's boundary partner.
+func lockDraftJournal(tx *sqlx.Tx, t Tenant, projectId, journalId string) (string, error) {
+	var partnerId, status string
+	err := tx.QueryRow(`
+		SELECT boundary_partner_id, status FROM outcome_journals
+		WHERE journal_id = $1 AND project_id IN (
+		  SELECT project_id FROM projects WHERE project_id = $2 AND organization_id = $3)
+		FOR UPDATE`, journalId, projectId, t.OrganizationId).Scan(&partnerId, &status)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if status != JOURNAL_DRAFT {
+		return "", errJournalSubmitted
+	}
+	return partnerId, nil
+}
+
+func (s *pgStore) UpdateJournal(t Tenant, projectId, journalId string, j OutcomeJournal) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		partnerId, err := lockDraftJournal(tx, t, projectId, journalId)
+		if err != nil {
+			return err
+		}
+		// the boundary partner of a journal does not change
+		j.BoundaryPartnerId = partnerId
+		if err = checkJournal(tx, t, projectId, journalId, j); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE outcome_journals SET
+			  monitoring_date = $1::DATE, description_of_change = nullif($2, ''), contributing_factors = nullif($3, ''),
+			  sources_of_evidence = nullif($4, ''), unanticipated_change = nullif($5, ''), lessons = nullif($6, '')
+			WHERE journal_id = $7`,
+			j.MonitoringDate, j.DescriptionOfChange, j.ContributingFactors, j.SourcesOfEvidence,
+			j.UnanticipatedChange, j.Lessons, journalId)
+		if err != nil {
+			return err
+		}
+		return setJournalRatings(tx, journalId, j.Ratings)
+	})
+}
+
+func (s *pgStore) SubmitJournal(t Tenant, userId, projectId, journalId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if _, err := lockDraftJournal(tx, t, projectId, journalId); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE outcome_journals SET status = $1, submitted_at = now(), submitted_by = $2 WHERE journal_id = $3",
+			JOURNAL_SUBMITTED, userId, journalId)
+		return err
+	})
+}
+
+func (s *pgStore) DeleteJournal(t Tenant, projectId, journalId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			DELETE FROM outcome_journals
+			WHERE journal_id = $1 AND project_id IN (
+			  SELECT project_id FROM projects WHERE project_id = $2 AND organization_id = $3)`,
+			journalId, projectId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) GetOverdueJournals(t Tenant, before string) ([]OverdueJournal, error) {
+	journals := []OverdueJournal{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		rows, err := tx.Query(`
+			SELECT j.journal_id, j.project_id, j.boundary_partner_id, coalesce(bp.partner_name, ''),
+			  to_char(j.monitoring_date, 'YYYY-MM-DD'), coalesce(j.created_by::TEXT, '')
+			FROM outcome_journals j
+			JOIN projects p ON p.project_id = j.project_id
+			JOIN boundary_partners bp ON bp.boundary_partner_id = j.boundary_partner_id
+			WHERE p.organization_id = $1 AND j.status = 'draft' AND j.monitoring_date < $2::DATE
+			ORDER BY j.monitoring_date, j.ts_created`, t.OrganizationId, before)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var j OverdueJournal
+			err = rows.Scan(&j.JournalId, &j.ProjectId, &j.BoundaryPartnerId, &j.PartnerName, &j.MonitoringDate, &j.CreatedBy)
+			if err != nil {
+				return err
+			}
+			journals = append(journals, j)
+		}
+		return rows.Err()
+	})
+	return journals, err
+}
+
+func (s *pgStore) ExportProject(t Tenant, projectId string) (ProjectExport, error) {
+	doc := ProjectExport{BoundaryPartners: []*BoundaryPartner{}, Journals: []*ExportedJournal{}, Resources: []ExportedResource{}}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		p := &doc.Project
+		var logo ProjectLogo
+		err := tx.QueryRow(`
+			SELECT
+			  coalesce(project_name, ''), coalesce(description, ''), `+projectBudget+`, currency,
+			  `+projectDonors+`, coalesce(vision, ''), coalesce(mission, ''),
+			  coalesce(to_char(timeline_from AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+			  coalesce(to_char(timeline_to AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+			  coalesce(logo_key, ''), coalesce(logo_medium_key, ''), coalesce(logo_thumbnail_key, ''),
+			  coalesce(logo_content_type, ''), coalesce(logo_width, 0), coalesce(logo_height, 0)
+			FROM projects p WHERE project_id = $1 AND organization_id = $2`,
+			projectId, t.OrganizationId).Scan(&p.ProjectName, &p.Description, &p.Budget, &p.Currency, &p.Donor, &p.Vision, &p.Mission,
+			&p.TimelineFrom, &p.TimelineTo,
+			&logo.OriginalKey, &logo.MediumKey, &logo.ThumbnailKey, &logo.ContentType, &logo.Width, &logo.Height)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		p.StoredLogo = projectLogo(projectId, logo)
+
+		var partnerIds []string
+		err = tx.Select(&partnerIds, "SELECT boundary_partner_id FROM boundary_partners WHERE project_id = $1 ORDER BY ts_created", projectId)
+		if err != nil {
+			return err
+		}
+		for _, partnerId := range partnerIds {
+			bp := &BoundaryPartner{}
+			if err = queryPartner(tx, t, projectId, partnerId, bp); err != nil {
+				return err
+			}
+			doc.BoundaryPartners = append(doc.BoundaryPartners, bp)
+		}
+
+		journals, err := queryJournals(tx, "WHERE j.project_id = $1 AND p.organization_id = $2", projectId, t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		for _, j := range journals {
+			doc.Journals = append(doc.Journals, exportedJournal(j))
+		}
+
+		resources, err := queryResources(tx, "WHERE project_id = $1 AND organization_id = $2 AND scan_status = 'clean'",
+			projectId, t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		for _, exr := range resources {
+			doc.Resources = append(doc.Resources, exportedResource(exr))
+		}
+
+		doc.Grants, err = queryGrants(tx, "WHERE g.project_id = $1 AND d.organization_id = $2", projectId, t.OrganizationId)
+		return err
+	})
+	return doc, err
+}
+
+func (s *pgStore) ImportProject(t Tenant, userId string, doc ProjectExport) (string, error) {
+	projectId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		p := doc.Project
+		logo := p.StoredLogo
+		if logo == nil {
+			logo = &ProjectLogo{}
+		}
+		_, err := tx.Exec(`
+			INSERT INTO projects (
+			  project_id, organization_id, project_name, description, budget, vision, mission,
+			  timeline_from, timeline_to, logo_key, logo_medium_key, logo_thumbnail_key,
+			  logo_content_type, logo_width, logo_height, currency
+			) VALUES (
+			  $1, $2, $3, $4, $5, $6, $7,
+			  nullif($8, '')::TIMESTAMPTZ, nullif($9, '')::TIMESTAMPTZ, nullif($10, ''), nullif($11, ''), nullif($12, ''),
+			  nullif($13, ''), nullif($14, 0), nullif($15, 0), $16
+			)`,
+			projectId, t.OrganizationId, p.ProjectName, p.Description, p.Budget, p.Vision, p.Mission,
+			p.TimelineFrom, p.TimelineTo, logo.OriginalKey, logo.MediumKey, logo.ThumbnailKey,
+			logo.ContentType, logo.Width, logo.Height, p.Currency)
+		if err != nil {
+			return err
+		}
+
+		// new IDs by the ID in the export
+		ids := make(map[string]string)
+		for _, bp := range doc.BoundaryPartners {
+			partnerId := uuid.NewV4().String()
+			ids[bp.BoundaryPartnerId] = partnerId
+			_, err = tx.Exec(`
+				INSERT INTO boundary_partners (boundary_partner_id, project_id, partner_name, outcome_statement, ts_created)
+				VALUES ($1, $2, $3, nullif($4, ''), clock_timestamp())`,
+				partnerId, projectId, bp.PartnerName, bp.OutcomeStatement)
+			if err != nil {
+				return err
+			}
+			for i, pm := range bp.ProgressMarkers {
+				markerId := uuid.NewV4().String()
+				ids[pm.ProgressMarkerId] = markerId
+				_, err = tx.Exec(`
+					INSERT INTO progress_markers (progress_marker_id, boundary_partner_id, title, type, order_number, ts_created)
+					VALUES ($1, $2, $3, $4, $5, clock_timestamp())`,
+					markerId, partnerId, pm.Title, pm.Type, i+1)
+				if err != nil {
+					return err
+				}
+				for _, c := range pm.Challenges {
+					_, err = tx.Exec(`
+						INSERT INTO challenges (challenge_id, progress_marker_id, challenge_name, ts_created)
+						VALUES ($1, $2, $3, clock_timestamp())`,
+						uuid.NewV4().String(), markerId, c.ChallengeName)
+					if err != nil {
+						return err
+					}
+				}
+				for _, strat := range pm.Strategies {
+					_, err = tx.Exec(`
+						INSERT INTO strategies (strategy_id, progress_marker_id, strategy_name, ts_created)
+						VALUES ($1, $2, $3, clock_timestamp())`,
+						uuid.NewV4().String(), markerId, strat.StrategyName)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, exported := range doc.Journals {
+			journalId := uuid.NewV4().String()
+			ids[exported.JournalId] = journalId
+			j := exported.journal(projectId)
+			_, err = tx.Exec(`
+				INSERT INTO outcome_journals (
+				  journal_id, project_id, boundary_partner_id, monitoring_date, description_of_change,
+				  contributing_factors, sources_of_evidence, unanticipated_change, lessons, status, submitted_at,
+				  created_by, ts_created
+				)
+				VALUES (
+				  $1, $2, $3, $4::DATE, nullif($5, ''),
+				  nullif($6, ''), nullif($7, ''), nullif($8, ''), nullif($9, ''), $10, $11,
+				  $12, clock_timestamp()
+				)`,
+				journalId, projectId, ids[j.BoundaryPartnerId], j.MonitoringDate, j.DescriptionOfChange,
+				j.ContributingFactors, j.SourcesOfEvidence, j.UnanticipatedChange, j.Lessons, j.Status, j.SubmittedAt,
+				userId)
+			if err != nil {
+				return err
+			}
+			for i := range j.Ratings {
+				j.Ratings[i].ProgressMarkerId = ids[j.Ratings[i].ProgressMarkerId]
+			}
+			if err = setJournalRatings(tx, journalId, j.Ratings); err != nil {
+				return err
+			}
+		}
+
+		for _, r := range doc.Resources {
+			exr := r.resource(projectId)
+			exr.BoundaryPartnerId = ids[r.BoundaryPartnerId]
+			exr.ProgressMarkerId = ids[r.ProgressMarkerId]
+			exr.JournalId = ids[r.JournalId]
+			if err = insertResource(tx, t, uuid.NewV1().String(), userId, exr); err != nil {
+				return err
+			}
+		}
+
+		for _, g := range importedGrants(doc) {
+			g.ProjectId = projectId
+			if _, err = insertGrant(tx, t, userId, g); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return projectId, err
+}
+
+// reference is an ID a budget line or expenditure refers to, checked with
+// the ownership check of its route variable.
+type reference struct {
+	routeVar string
+	field    string
+	id       string
+}
+
+// checkReferences returns FieldErrors for the non-empty references that are
+// not part of the project.
+func checkReferences(tx *sqlx.Tx, t Tenant, projectId string, refs ...reference) error {
+	var errs FieldErrors
+	for _, ref := range refs {
+		if ref.id == "" {
+			continue
+		}
+		count := 0
+		if isUUID(ref.id) {
+			err := tx.QueryRow(ownershipChecks[ref.routeVar].query, ref.id, projectId, t.OrganizationId).Scan(&count)
+			if err != nil {
+				return err
+			}
+		}
+		if count == 0 {
+			errs = nestErrors(errs, "", FieldErrors{ref.field: "must be part of the project"})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// selectBudgetLines is completed with a WHERE clause by queryBudgetLines.
+const selectBudgetLines = `
+	SELECT
+	  budget_line_id, project_id, title, coalesce(description, ''), amount, currency, project_amount,
+	  coalesce(boundary_partner_id::TEXT, ''), coalesce(strategy_id::TEXT, ''), ts_created
+	FROM budget_lines
+`
+
+func queryBudgetLines(tx *sqlx.Tx, where string, args ...interface{}) ([]BudgetLine, error) {
+	lines := []BudgetLine{}
+	rows, err := tx.Query(selectBudgetLines+where+" ORDER BY ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line BudgetLine
+		err = rows.Scan(&line.BudgetLineId, &line.ProjectId, &line.Title, &line.Description, &line.Amount, &line.Currency,
+			&line.ProjectAmount, &line.BoundaryPartnerId, &line.StrategyId, &line.TsCreated)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// scopedProjectIds restricts a project_id to the projects of the organization in $2.
+const scopedProjectIds = "project_id IN (SELECT project_id FROM projects WHERE organization_id = $2)"
+
+func (s *pgStore) GetBudgetLines(t Tenant, projectId string) ([]BudgetLine, error) {
+	var lines []BudgetLine
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		lines, err = queryBudgetLines(tx, "WHERE project_id = $1 AND "+scopedProjectIds, projectId, t.OrganizationId)
+		return err
+	})
+	return lines, err
+}
+
+func (s *pgStore) GetBudgetLine(t Tenant, projectId, lineId string) (BudgetLine, error) {
+	var lines []BudgetLine
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		lines, err = queryBudgetLines(tx, "WHERE project_id = $1 AND "+scopedProjectIds+" AND budget_line_id = $3",
+			projectId, t.OrganizationId, lineId)
+		return err
+	})
+	if err != nil {
+		return BudgetLine{}, err
+	}
+	if len(lines) == 0 {
+		return BudgetLine{}, ErrNotFound
+	}
+	return lines[0], nil
+}
+
+func (s *pgStore) AddBudgetLine(t Tenant, userId string, line BudgetLine) (string, error) {
+	lineId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := checkReferences(tx, t, line.ProjectId,
+			reference{"partnerId", "boundary_partner_id", line.BoundaryPartnerId},
+			reference{"strategyId", "strategy_id", line.StrategyId})
+		if err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			INSERT INTO budget_lines (
+			  budget_line_id, project_id, title, description, amount, currency, project_amount,
+			  boundary_partner_id, strategy_id, created_by, ts_created
+			)
+			SELECT $1, project_id, $3, nullif($4, ''), $5, $6, $7, nullif($8, '')::UUID, nullif($9, '')::UUID, $10, clock_timestamp()
+			FROM projects WHERE project_id = $11 AND organization_id = $2`,
+			lineId, t.OrganizationId, line.Title, line.Description, line.Amount, line.Currency, line.ProjectAmount,
+			line.BoundaryPartnerId, line.StrategyId, userId, line.ProjectId))
+	})
+	return lineId, err
+}
+
+func (s *pgStore) UpdateBudgetLine(t Tenant, projectId, lineId string, line BudgetLine) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := checkReferences(tx, t, projectId,
+			reference{"partnerId", "boundary_partner_id", line.BoundaryPartnerId},
+			reference{"strategyId", "strategy_id", line.StrategyId})
+		if err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			UPDATE budget_lines SET
+			  title = $3, description = nullif($4, ''), amount = $5, currency = $6, project_amount = $7,
+			  boundary_partner_id = nullif($8, '')::UUID, strategy_id = nullif($9, '')::UUID
+			WHERE project_id = $1 AND `+scopedProjectIds+` AND budget_line_id = $10`,
+			projectId, t.OrganizationId, line.Title, line.Description, line.Amount, line.Currency, line.ProjectAmount,
+			line.BoundaryPartnerId, line.StrategyId, lineId))
+	})
+}
+
+func (s *pgStore) DeleteBudgetLine(t Tenant, projectId, lineId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM budget_lines WHERE project_id = $1 AND "+scopedProjectIds+" AND budget_line_id = $3",
+			projectId, t.OrganizationId, lineId))
+	})
+}
+
+// selectExpenditures is completed with a WHERE clause by queryExpenditures.
+const selectExpenditures = `
+	SELECT
+	  expenditure_id, project_id, coalesce(budget_line_id::TEXT, ''), description, amount, currency, project_amount,
+	  to_char(spent_on, 'YYYY-MM-DD'), coalesce(receipt_resource_id::TEXT, ''), coalesce(created_by::TEXT, ''), ts_created
+	FROM expenditures
+`
+
+func queryExpenditures(tx *sqlx.Tx, where string, args ...interface{}) ([]Expenditure, error) {
+	expenditures := []Expenditure{}
+	rows, err := tx.Query(selectExpenditures+where+" ORDER BY spent_on DESC, ts_created DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Expenditure
+		err = rows.Scan(&e.ExpenditureId, &e.ProjectId, &e.BudgetLineId, &e.Description, &e.Amount, &e.Currency,
+			&e.ProjectAmount, &e.SpentOn, &e.ReceiptResourceId, &e.CreatedBy, &e.TsCreated)
+		if err != nil {
+			return nil, err
+		}
+		expenditures = append(expenditures, e)
+	}
+	return expenditures, rows.Err()
+}
+
+func (s *pgStore) GetExpenditures(t Tenant, projectId string) ([]Expenditure, error) {
+	var expenditures []Expenditure
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		expenditures, err = queryExpenditures(tx, "WHERE project_id = $1 AND "+scopedProjectIds, projectId, t.OrganizationId)
+		return err
+	})
+	return expenditures, err
+}
+
+func (s *pgStore) GetExpenditure(t Tenant, projectId, expenditureId string) (Expenditure, error) {
+	var expenditures []Expenditure
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		expenditures, err = queryExpenditures(tx, "WHERE project_id = $1 AND "+scopedProjectIds+" AND expenditure_id = $3",
+			projectId, t.OrganizationId, expenditureId)
+		return err
+	})
+	if err != nil {
+		return Expenditure{}, err
+	}
+	if len(expenditures) == 0 {
+		return Expenditure{}, ErrNotFound
+	}
+	return expenditures[0], nil
+}
+
+func (s *pgStore) AddExpenditure(t Tenant, userId string, e Expenditure) (string, error) {
+	expenditureId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := checkReferences(tx, t, e.ProjectId,
+			reference{"budgetLineId", "budget_line_id", e.BudgetLineId},
+			reference{"resourceId", "receipt_resource_id", e.ReceiptResourceId})
+		if err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			INSERT INTO expenditures (
+			  expenditure_id, project_id, budget_line_id, description, amount, currency, project_amount,
+			  spent_on, receipt_resource_id, created_by, ts_created
+			)
+			SELECT $1, project_id, nullif($3, '')::UUID, $4, $5, $6, $7, $8::DATE, nullif($9, '')::UUID, $10, clock_timestamp()
+			FROM projects WHERE project_id = $11 AND organization_id = $2`,
+			expenditureId, t.OrganizationId, e.BudgetLineId, e.Description, e.Amount, e.Currency, e.ProjectAmount,
+			e.SpentOn, e.ReceiptResourceId, userId, e.ProjectId))
+	})
+	return expenditureId, err
+}
+
+func (s *pgStore) UpdateExpenditure(t Tenant, projectId, expenditureId string, e Expenditure) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := checkReferences(tx, t, projectId,
+			reference{"budgetLineId", "budget_line_id", e.BudgetLineId},
+			reference{"resourceId", "receipt_resource_id", e.ReceiptResourceId})
+		if err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			UPDATE expenditures SET
+			  budget_line_id = nullif($3, '')::UUID, description = $4, amount = $5, currency = $6, project_amount = $7,
+			  spent_on = $8::DATE, receipt_resource_id = nullif($9, '')::UUID
+			WHERE project_id = $1 AND `+scopedProjectIds+` AND expenditure_id = $10`,
+			projectId, t.OrganizationId, e.BudgetLineId, e.Description, e.Amount, e.Currency, e.ProjectAmount,
+			e.SpentOn, e.ReceiptResourceId, expenditureId))
+	})
+}
+
+func (s *pgStore) DeleteExpenditure(t Tenant, projectId, expenditureId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM expenditures WHERE project_id = $1 AND "+scopedProjectIds+" AND expenditure_id = $3",
+			projectId, t.OrganizationId, expenditureId))
+	})
+}
+
+func (s *pgStore) GetBudgetReport(t Tenant, projectId string) (BudgetReport, error) {
+	report := BudgetReport{Lines: []BudgetReportLine{}}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(`
+			SELECT
+			  currency, `+projectBudget+`,
+			  (SELECT coalesce(sum(project_amount), 0) FROM expenditures e WHERE e.project_id = p.project_id AND budget_line_id IS NULL)
+			FROM projects p WHERE project_id = $1 AND organization_id = $2`,
+			projectId, t.OrganizationId).Scan(&report.Currency, &report.Budget, &report.Unallocated)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(`
+			SELECT
+			  bl.budget_line_id, bl.title, coalesce(bl.boundary_partner_id::TEXT, ''), coalesce(bl.strategy_id::TEXT, ''),
+			  bl.project_amount, coalesce(sum(e.project_amount), 0), count(e.expenditure_id)
+			FROM budget_lines bl
+			LEFT JOIN expenditures e USING (budget_line_id)
+			WHERE bl.project_id = $1
+			GROUP BY bl.budget_line_id
+			ORDER BY min(bl.ts_created)`, projectId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var line BudgetReportLine
+			err = rows.Scan(&line.BudgetLineId, &line.Title, &line.BoundaryPartnerId, &line.StrategyId,
+				&line.Budget, &line.Spent, &line.Expenditures)
+			if err != nil {
+				return err
+			}
+			report.Lines = append(report.Lines, line)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return BudgetReport{}, err
+	}
+	report.total()
+	return report, nil
+}
+
+// checkDonor returns FieldErrors unless the donor is one of the tenant's.
+func checkDonor(tx *sqlx.Tx, t Tenant, donorId string) error {
+	count := 0
+	if isUUID(donorId) {
+		err := tx.QueryRow("SELECT count(*) FROM donors WHERE donor_id = $1 AND organization_id = $2", donorId, t.OrganizationId).Scan(&count)
+		if err != nil {
+			return err
+		}
+	}
+	if count == 0 {
+		return FieldErrors{"donor_id": "must be a donor of the organization"}
+	}
+	return nil
+}
+
+// checkDonorName returns FieldErrors if another donor of the tenant has the name.
+func checkDonorName(tx *sqlx.Tx, t Tenant, donorId, name string) error {
+	count := 0
+	err := tx.QueryRow(`
+		SELECT count(*) FROM donors
+		WHERE organization_id = $1 AND lower(donor_name) = lower($2) AND donor_id::TEXT <> $3`,
+		t.OrganizationId, name, donorId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return FieldErrors{"donor_name": "is the name of another donor"}
+	}
+	return nil
+}
+
+// findOrAddDonor returns the ID of the tenant's donor with the given name,
+// adding the donor if there is none.
+func findOrAddDonor(tx *sqlx.Tx, t Tenant, name string) (string, error) {
+	var donorId string
+	err := tx.QueryRow("SELECT donor_id FROM donors WHERE organization_id = $1 AND lower(donor_name) = lower($2)",
+		t.OrganizationId, name).Scan(&donorId)
+	if err != sql.ErrNoRows {
+		return donorId, err
+	}
+	donorId = uuid.NewV4().String()
+	_, err = tx.Exec("INSERT INTO donors (donor_id, organization_id, donor_name) VALUES ($1, $2, $3)", donorId, t.OrganizationId, name)
+	return donorId, err
+}
+
+// selectDonors is completed with a WHERE clause by queryDonors.
+const selectDonors = `
+	SELECT
+	  donor_id, donor_name, coalesce(contact_name, ''), coalesce(contact_email, ''),
+	  coalesce(website, ''), coalesce(notes, '')
+	FROM donors
+`
+
+func queryDonors(tx *sqlx.Tx, where string, args ...interface{}) ([]Donor, error) {
+	donors := []Donor{}
+	rows, err := tx.Query(selectDonors+where+" ORDER BY lower(donor_name)", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Donor
+		if err = rows.Scan(&d.DonorId, &d.DonorName, &d.ContactName, &d.ContactEmail, &d.Website, &d.Notes); err != nil {
+			return nil, err
+		}
+		donors = append(donors, d)
+	}
+	return donors, rows.Err()
+}
+
+func (s *pgStore) GetDonors(t Tenant) ([]Donor, error) {
+	var donors []Donor
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		donors, err = queryDonors(tx, "WHERE organization_id = $1", t.OrganizationId)
+		return err
+	})
+	return donors, err
+}
+
+func (s *pgStore) GetDonor(t Tenant, donorId string) (Donor, error) {
+	if !isUUID(donorId) {
+		return Donor{}, ErrNotFound
+	}
+	var donors []Donor
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		donors, err = queryDonors(tx, "WHERE organization_id = $1 AND donor_id = $2", t.OrganizationId, donorId)
+		return err
+	})
+	if err != nil {
+		return Donor{}, err
+	}
+	if len(donors) == 0 {
+		return Donor{}, ErrNotFound
+	}
+	return donors[0], nil
+}
+
+func (s *pgStore) AddDonor(t Tenant, d Donor) (string, error) {
+	donorId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkDonorName(tx, t, donorId, d.DonorName); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO donors (donor_id, organization_id, donor_name, contact_name, contact_email, website, notes)
+			VALUES ($1, $2, $3, nullif($4, ''), nullif($5, ''), nullif($6, ''), nullif($7, ''))`,
+			donorId, t.OrganizationId, d.DonorName, d.ContactName, d.ContactEmail, d.Website, d.Notes)
+		return err
+	})
+	return donorId, err
+}
+
+func (s *pgStore) UpdateDonor(t Tenant, donorId string, d Donor) error {
+	if !isUUID(donorId) {
+		return ErrNotFound
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkDonorName(tx, t, donorId, d.DonorName); err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			UPDATE donors SET
+			  donor_name = $3, contact_name = nullif($4, ''), contact_email = nullif($5, ''),
+			  website = nullif($6, ''), notes = nullif($7, '')
+			WHERE donor_id = $1 AND organization_id = $2`,
+			donorId, t.OrganizationId, d.DonorName, d.ContactName, d.ContactEmail, d.Website, d.Notes))
+	})
+}
+
+func (s *pgStore) DeleteDonor(t Tenant, donorId string) error {
+	if !isUUID(donorId) {
+		return ErrNotFound
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var grants, officers int
+		err := tx.QueryRow(`
+			SELECT
+			  (SELECT count(*) FROM grants WHERE donor_id = d.donor_id),
+			  (SELECT count(*) FROM users WHERE donor_id = d.donor_id)
+			FROM donors d WHERE donor_id = $1 AND organization_id = $2 FOR UPDATE`,
+			donorId, t.OrganizationId).Scan(&grants, &officers)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if grants > 0 || officers > 0 {
+			return FieldErrors{"donor_id": "can not be deleted while it has grants or grant officers"}
+		}
+		return expectRow(tx.Exec("DELETE FROM donors WHERE donor_id = $1 AND organization_id = $2", donorId, t.OrganizationId))
+	})
+}
+
+// selectGrants is completed with a WHERE clause by queryGrants.
+const selectGrants = `
+	SELECT
+	  g.grant_id, g.project_id, g.donor_id, d.donor_name, coalesce(g.reference, ''), g.amount, g.currency,
+	  coalesce(to_char(g.period_from, 'YYYY-MM-DD'), ''), coalesce(to_char(g.period_to, 'YYYY-MM-DD'), ''),
+	  coalesce(g.reporting_requirements, ''),
+	  array(SELECT to_char(due_on, 'YYYY-MM-DD') FROM grant_deadlines gd WHERE gd.grant_id = g.grant_id ORDER BY position),
+	  array(SELECT coalesce(description, '') FROM grant_deadlines gd WHERE gd.grant_id = g.grant_id ORDER BY position)
+	FROM grants g
+	JOIN donors d USING (donor_id)
+`
+
+func queryGrants(tx *sqlx.Tx, where string, args ...interface{}) ([]Grant, error) {
+	grants := []Grant{}
+	rows, err := tx.Query(selectGrants+where+" ORDER BY g.ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g Grant
+		var dueOn, descriptions pq.StringArray
+		err = rows.Scan(&g.GrantId, &g.ProjectId, &g.DonorId, &g.DonorName, &g.Reference, &g.Amount, &g.Currency,
+			&g.PeriodFrom, &g.PeriodTo, &g.ReportingRequirements, &dueOn, &descriptions)
+		if err != nil {
+			return nil, err
+		}
+		g.Deadlines = []GrantDeadline{}
+		for i := range dueOn {
+			g.Deadlines = append(g.Deadlines, GrantDeadline{dueOn[i], descriptions[i]})
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// insertGrant adds a grant to a project. A grant without a DonorId names its
+// donor by DonorName, which is added if the tenant does not have it yet.
+func insertGrant(tx *sqlx.Tx, t Tenant, userId string, g Grant) (string, error) {
+	var err error
+	if g.DonorId == "" {
+		g.DonorId, err = findOrAddDonor(tx, t, g.DonorName)
+	} else {
+		err = checkDonor(tx, t, g.DonorId)
+	}
+	if err != nil {
+		return "", err
+	}
+	grantId := uuid.NewV4().String()
+	err = expectRow(tx.Exec(`
+		INSERT INTO grants (
+		  grant_id, project_id, donor_id, reference, amount, currency, period_from, period_to,
+		  reporting_requirements, created_by, ts_created
+		)
+		SELECT
+		  $1, project_id, $3, nullif($4, ''), $5, $6, nullif($7, '')::DATE, nullif($8, '')::DATE,
+		  nullif($9, ''), nullif($10, '')::UUID, clock_timestamp()
+		FROM projects WHERE project_id = $11 AND organization_id = $2`,
+		grantId, t.OrganizationId, g.DonorId, g.Reference, g.Amount, g.Currency, g.PeriodFrom, g.PeriodTo,
+		g.ReportingRequirements, userId, g.ProjectId))
+	if err != nil {
+		return "", err
+	}
+	return grantId, insertDeadlines(tx, grantId, g.Deadlines)
+}
+
+func insertDeadlines(tx *sqlx.Tx, grantId string, deadlines []GrantDeadline) error {
+	for i, deadline := range deadlines {
+		_, err := tx.Exec("INSERT INTO grant_deadlines (grant_id, position, due_on, description) VALUES ($1, $2, $3::DATE, nullif($4, ''))",
+			grantId, i+1, deadline.DueOn, deadline.Description)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pgStore) SetProjectDonor(t Tenant, projectId, donor string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var currency string
+		err := tx.QueryRow("SELECT currency FROM projects WHERE project_id = $1 AND organization_id = $2",
+			projectId, t.OrganizationId).Scan(&currency)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var grants int
+		err = tx.QueryRow(`
+			SELECT count(*) FROM grants JOIN donors USING (donor_id)
+			WHERE project_id = $1 AND lower(donor_name) = lower($2)`, projectId, donor).Scan(&grants)
+		if err != nil || grants > 0 {
+			return err
+		}
+		_, err = insertGrant(tx, t, "", Grant{ProjectId: projectId, DonorName: donor, Currency: currency})
+		return err
+	})
+}
+
+func (s *pgStore) ResetProjectDonor(t Tenant, projectId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var projects int
+		err := tx.QueryRow("SELECT count(*) FROM projects WHERE project_id = $1 AND organization_id = $2",
+			projectId, t.OrganizationId).Scan(&projects)
+		if err != nil {
+			return err
+		}
+		if projects == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.Exec(`
+			DELETE FROM grants g
+			WHERE project_id = $1 AND amount = 0 AND reference IS NULL AND period_from IS NULL AND period_to IS NULL
+			  AND reporting_requirements IS NULL
+			  AND NOT EXISTS (SELECT 1 FROM grant_deadlines gd WHERE gd.grant_id = g.grant_id)`, projectId)
+		return err
+	})
+}
+
+func (s *pgStore) GetGrants(t Tenant, projectId string) ([]Grant, error) {
+	var grants []Grant
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		grants, err = queryGrants(tx, "WHERE g.project_id = $1 AND d.organization_id = $2", projectId, t.OrganizationId)
+		return err
+	})
+	return grants, err
+}
+
+func (s *pgStore) GetGrant(t Tenant, projectId, grantId string) (Grant, error) {
+	var grants []Grant
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		grants, err = queryGrants(tx, "WHERE g.project_id = $1 AND d.organization_id = $2 AND g.grant_id = $3",
+			projectId, t.OrganizationId, grantId)
+		return err
+	})
+	if err != nil {
+		return Grant{}, err
+	}
+	if len(grants) == 0 {
+		return Grant{}, ErrNotFound
+	}
+	return grants[0], nil
+}
+
+func (s *pgStore) AddGrant(t Tenant, userId string, g Grant) (string, error) {
+	var grantId string
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		grantId, err = insertGrant(tx, t, userId, g)
+		return err
+	})
+	return grantId, err
+}
+
+func (s *pgStore) UpdateGrant(t Tenant, projectId, grantId string, g Grant) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkDonor(tx, t, g.DonorId); err != nil {
+			return err
+		}
+		err := expectRow(tx.Exec(`
+			UPDATE grants SET
+			  donor_id = $3, reference = nullif($4, ''), amount = $5, currency = $6,
+			  period_from = nullif($7, '')::DATE, period_to = nullif($8, '')::DATE, reporting_requirements = nullif($9, '')
+			WHERE project_id = $1 AND `+scopedProjectIds+` AND grant_id = $10`,
+			projectId, t.OrganizationId, g.DonorId, g.Reference, g.Amount, g.Currency, g.PeriodFrom, g.PeriodTo,
+			g.ReportingRequirements, grantId))
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM grant_deadlines WHERE grant_id = $1", grantId); err != nil {
+			return err
+		}
+		return insertDeadlines(tx, grantId, g.Deadlines)
+	})
+}
+
+func (s *pgStore) DeleteGrant(t Tenant, projectId, grantId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM grants WHERE project_id = $1 AND "+scopedProjectIds+" AND grant_id = $3",
+			projectId, t.OrganizationId, grantId))
+	})
+}
+
+func (s *pgStore) GetDonorProjects(t Tenant, donorId string) ([]DonorProject, error) {
+	if !isUUID(donorId) {
+		return nil, ErrNotFound
+	}
+	donorProjects := []DonorProject{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkDonor(tx, t, donorId); err != nil {
+			return ErrNotFound
+		}
+		projects, err := queryProjects(tx, "WHERE organization_id = $1 AND project_id IN (SELECT project_id FROM grants WHERE donor_id = $2)",
+			t.OrganizationId, donorId)
+		if err != nil {
+			return err
+		}
+		grants, err := queryGrants(tx, "WHERE g.donor_id = $1 AND d.organization_id = $2", donorId, t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		for _, p := range projects {
+			dp := DonorProject{Project: p, Grants: []Grant{}}
+			for _, g := range grants {
+				if g.ProjectId == p.ProjectId {
+					dp.Grants = append(dp.Grants, g)
+				}
+			}
+			donorProjects = append(donorProjects, dp)
+		}
+		return nil
+	})
+	return donorProjects, err
+}
+
+func (s *pgStore) GetGrantOfficers(t Tenant, donorId string) ([]User, error) {
+	officers := []User{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		rows, err := tx.Query(`
+			SELECT user_id, organization_id, full_name, is_admin, donor_id
+			FROM users WHERE organization_id = $1 AND donor_id::TEXT = $2
+			ORDER BY full_name`, t.OrganizationId, donorId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var user User
+			if err = rows.Scan(&user.UserId, &user.OrganizationId, &user.FullName, &user.IsAdmin, &user.DonorId); err != nil {
+				return err
+			}
+			officers = append(officers, user)
+		}
+		return rows.Err()
+	})
+	return officers, err
+}
+
+func (s *pgStore) SetGrantOfficer(t Tenant, userId, donorId string) error {
+	if !isUUID(userId) {
+		return ErrNotFound
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var isAdmin bool
+		err := tx.QueryRow("SELECT is_admin FROM users WHERE user_id = $1 AND organization_id = $2 FOR UPDATE",
+			userId, t.OrganizationId).Scan(&isAdmin)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if isAdmin {
+			return FieldErrors{"user_id": "an admin can not be a grant officer"}
+		}
+		if donorId != "" {
+			if err = checkDonor(tx, t, donorId); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE users SET donor_id = nullif($1, '')::UUID WHERE user_id = $2", donorId, userId)
+		return err
+	})
+}
+
+// checkMember returns FieldErrors unless the user is a member of the
+// tenant's organization. Grant officers are not members in this sense.
+func checkMember(tx *sqlx.Tx, t Tenant, field, userId string) error {
+	count := 0
+	if isUUID(userId) {
+		err := tx.QueryRow("SELECT count(*) FROM users WHERE user_id = $1 AND organization_id = $2 AND donor_id IS NULL",
+			userId, t.OrganizationId).Scan(&count)
+		if err != nil {
+			return err
+		}
+	}
+	if count == 0 {
+		return FieldErrors{field: "must be a member of the organization"}
+	}
+	return nil
+}
+
+// checkActivity checks the references of an activity.
+func checkActivity(tx *sqlx.Tx, t Tenant, projectId string, a Activity) error {
+	err := checkReferences(tx, t, projectId,
+		reference{"strategyId", "strategy_id", a.StrategyId},
+		reference{"progressMarkerId", "progress_marker_id", a.ProgressMarkerId})
+	if err != nil || a.OwnerId == "" {
+		return err
+	}
+	return checkMember(tx, t, "owner_id", a.OwnerId)
+}
+
+// selectActivities is completed with a WHERE clause by queryActivities.
+const selectActivities = `
+	SELECT
+	  a.activity_id, a.project_id, a.kind, a.title, coalesce(a.description, ''),
+	  to_char(a.starts_on, 'YYYY-MM-DD'), to_char(a.ends_on, 'YYYY-MM-DD'), a.status,
+	  coalesce(a.owner_id::TEXT, ''), coalesce(u.full_name, ''),
+	  coalesce(a.strategy_id::TEXT, ''), coalesce(a.progress_marker_id::TEXT, ''), a.ts_created
+	FROM activities a
+	LEFT JOIN users u ON u.user_id = a.owner_id
+`
+
+func queryActivities(tx *sqlx.Tx, where string, args ...interface{}) ([]Activity, error) {
+	activities := []Activity{}
+	rows, err := tx.Query(selectActivities+where+" ORDER BY a.starts_on, a.ends_on, a.ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Activity
+		err = rows.Scan(&a.ActivityId, &a.ProjectId, &a.Kind, &a.Title, &a.Description, &a.StartsOn, &a.EndsOn, &a.Status,
+			&a.OwnerId, &a.OwnerName, &a.StrategyId, &a.ProgressMarkerId, &a.TsCreated)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+func (s *pgStore) GetActivities(t Tenant, projectId string) ([]Activity, error) {
+	var activities []Activity
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		activities, err = queryActivities(tx, "WHERE a."+scopedProjectIds+" AND a.project_id = $1", projectId, t.OrganizationId)
+		return err
+	})
+	return activities, err
+}
+
+func (s *pgStore) GetActivity(t Tenant, projectId, activityId string) (Activity, error) {
+	var activities []Activity
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		activities, err = queryActivities(tx, "WHERE a."+scopedProjectIds+" AND a.project_id = $1 AND a.activity_id = $3",
+			projectId, t.OrganizationId, activityId)
+		return err
+	})
+	if err != nil {
+		return Activity{}, err
+	}
+	if len(activities) == 0 {
+		return Activity{}, ErrNotFound
+	}
+	return activities[0], nil
+}
+
+func (s *pgStore) AddActivity(t Tenant, userId string, a Activity) (string, error) {
+	activityId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkActivity(tx, t, a.ProjectId, a); err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			INSERT INTO activities (
+			  activity_id, project_id, kind, title, description, starts_on, ends_on, status,
+			  owner_id, strategy_id, progress_marker_id, created_by, ts_created
+			)
+			SELECT $1, project_id, $3, $4, nullif($5, ''), $6::DATE, $7::DATE, $8,
+			  nullif($9, '')::UUID, nullif($10, '')::UUID, nullif($11, '')::UUID, $12, clock_timestamp()
+			FROM projects WHERE project_id = $13 AND organization_id = $2`,
+			activityId, t.OrganizationId, a.Kind, a.Title, a.Description, a.StartsOn, a.EndsOn, a.Status,
+			a.OwnerId, a.StrategyId, a.ProgressMarkerId, userId, a.ProjectId))
+	})
+	return activityId, err
+}
+
+func (s *pgStore) UpdateActivity(t Tenant, projectId, activityId string, a Activity) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkActivity(tx, t, projectId, a); err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			UPDATE activities SET
+			  kind = $3, title = $4, description = nullif($5, ''), starts_on = $6::DATE, ends_on = $7::DATE, status = $8,
+			  owner_id = nullif($9, '')::UUID, strategy_id = nullif($10, '')::UUID, progress_marker_id = nullif($11, '')::UUID
+			WHERE project_id = $1 AND `+scopedProjectIds+` AND activity_id = $12`,
+			projectId, t.OrganizationId, a.Kind, a.Title, a.Description, a.StartsOn, a.EndsOn, a.Status,
+			a.OwnerId, a.StrategyId, a.ProgressMarkerId, activityId))
+	})
+}
+
+func (s *pgStore) DeleteActivity(t Tenant, projectId, activityId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM activities WHERE project_id = $1 AND "+scopedProjectIds+" AND activity_id = $3",
+			projectId, t.OrganizationId, activityId))
+	})
+}
+
+func (s *pgStore) GetCalendarKey(t Tenant, projectId string) (string, error) {
+	var key string
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow("SELECT link_key FROM calendar_links WHERE project_id = $1 AND "+scopedProjectIds,
+			projectId, t.OrganizationId).Scan(&key)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	return key, err
+}
+
+func (s *pgStore) SetCalendarKey(t Tenant, userId, projectId, key string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if key == "" {
+			_, err := tx.Exec("DELETE FROM calendar_links WHERE project_id = $1 AND "+scopedProjectIds, projectId, t.OrganizationId)
+			return err
+		}
+		return expectRow(tx.Exec(`
+			INSERT INTO calendar_links (project_id, link_key, created_by)
+			SELECT project_id, $3, $4 FROM projects WHERE project_id = $1 AND organization_id = $2
+			ON CONFLICT (project_id) DO UPDATE SET link_key = $3, created_by = $4, ts_created = now()`,
+			projectId, t.OrganizationId, key, userId))
+	})
+}
+
+func (s *pgStore) GetDueActivities(t Tenant, kind, from, to string) ([]Activity, error) {
+	var activities []Activity
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		activities, err = queryActivities(tx, `
+			WHERE a.kind = $1 AND a.`+scopedProjectIds+` AND a.status NOT IN ('done', 'cancelled')
+			  AND a.starts_on >= coalesce(nullif($3, '')::DATE, '-infinity') AND a.starts_on <= $4::DATE`,
+			kind, t.OrganizationId, from, to)
+		return err
+	})
+	return activities, err
+}
+
+// checkTask checks what a task is on and who it is assigned to.
+func checkTask(tx *sqlx.Tx, t Tenant, projectId string, task Task) error {
+	err := checkReferences(tx, t, projectId,
+		reference{"progressMarkerId", "progress_marker_id", task.ProgressMarkerId},
+		reference{"challengeId", "challenge_id", task.ChallengeId},
+		reference{"strategyId", "strategy_id", task.StrategyId})
+	if err != nil || task.AssigneeId == "" {
+		return err
+	}
+	return checkMember(tx, t, "assignee_id", task.AssigneeId)
+}
+
+// openTasks matches the tasks that are neither done nor cancelled.
+const openTasks = "tk.status NOT IN ('done', 'cancelled')"
+
+// selectTasks is completed with a WHERE clause and an order by queryTasks.
+const selectTasks = `
+	SELECT
+	  tk.task_id, tk.project_id, p.project_name, coalesce(tk.progress_marker_id::TEXT, ''),
+	  coalesce(tk.challenge_id::TEXT, ''), coalesce(tk.strategy_id::TEXT, ''), tk.title, coalesce(tk.description, ''),
+	  coalesce(tk.assignee_id::TEXT, ''), coalesce(u.full_name, ''), coalesce(to_char(tk.due_on, 'YYYY-MM-DD'), ''),
+	  tk.priority, tk.status, (SELECT count(*) FROM task_comments c WHERE c.task_id = tk.task_id),
+	  coalesce(tk.created_by::TEXT, ''), tk.ts_created, tk.ts_updated
+	FROM tasks tk
+	JOIN projects p USING (project_id)
+	LEFT JOIN users u ON u.user_id = tk.assignee_id
+`
+
+// byDueDate orders tasks by due date, the most urgent first on the same day.
+const byDueDate = `
+	ORDER BY tk.due_on NULLS LAST,
+	  array_position(ARRAY['urgent', 'high', 'normal', 'low']::VARCHAR[], tk.priority), tk.ts_created`
+
+func queryTasks(tx *sqlx.Tx, where string, args ...interface{}) ([]Task, error) {
+	tasks := []Task{}
+	rows, err := tx.Query(selectTasks+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var task Task
+		err = rows.Scan(&task.TaskId, &task.ProjectId, &task.ProjectName, &task.ProgressMarkerId, &task.ChallengeId,
+			&task.StrategyId, &task.Title, &task.Description, &task.AssigneeId, &task.AssigneeName, &task.DueOn,
+			&task.Priority, &task.Status, &task.Comments, &task.CreatedBy, &task.TsCreated, &task.TsUpdated)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *pgStore) GetTasks(t Tenant, projectId string) ([]Task, error) {
+	var tasks []Task
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		tasks, err = queryTasks(tx, "WHERE tk.project_id = $1 AND p.organization_id = $2 ORDER BY tk.ts_created",
+			projectId, t.OrganizationId)
+		return err
+	})
+	return tasks, err
+}
+
+func (s *pgStore) GetTask(t Tenant, projectId, taskId string) (Task, error) {
+	var tasks []Task
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		tasks, err = queryTasks(tx, "WHERE tk.project_id = $1 AND p.organization_id = $2 AND tk.task_id = $3",
+			projectId, t.OrganizationId, taskId)
+		return err
+	})
+	if err != nil {
+		return Task{}, err
+	}
+	if len(tasks) == 0 {
+		return Task{}, ErrNotFound
+	}
+	return tasks[0], nil
+}
+
+func (s *pgStore) AddTask(t Tenant, userId string, task Task) (string, error) {
+	taskId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkTask(tx, t, task.ProjectId, task); err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			INSERT INTO tasks (
+			  task_id, project_id, progress_marker_id, challenge_id, strategy_id, title, description,
+			  assignee_id, due_on, priority, status, created_by, ts_created, ts_updated
+			)
+			SELECT $1, project_id, nullif($3, '')::UUID, nullif($4, '')::UUID, nullif($5, '')::UUID, $6, nullif($7, ''),
+			  nullif($8, '')::UUID, nullif($9, '')::DATE, $10, $11, $12, clock_timestamp(), clock_timestamp()
+			FROM projects WHERE project_id = $13 AND organization_id = $2`,
+			taskId, t.OrganizationId, task.ProgressMarkerId, task.ChallengeId, task.StrategyId, task.Title, task.Description,
+			task.AssigneeId, task.DueOn, task.Priority, task.Status, userId, task.ProjectId))
+	})
+	return taskId, err
+}
+
+func (s *pgStore) UpdateTask(t Tenant, projectId, taskId string, task Task) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkTask(tx, t, projectId, task); err != nil {
+			return err
+		}
+		return expectRow(tx.Exec(`
+			UPDATE tasks SET
+			  progress_marker_id = nullif($3, '')::UUID, challenge_id = nullif($4, '')::UUID, strategy_id = nullif($5, '')::UUID,
+			  title = $6, description = nullif($7, ''), assignee_id = nullif($8, '')::UUID, due_on = nullif($9, '')::DATE,
+			  priority = $10, ts_updated = now()
+			WHERE project_id = $1 AND `+scopedProjectIds+` AND task_id = $11`,
+			projectId, t.OrganizationId, task.ProgressMarkerId, task.ChallengeId, task.StrategyId, task.Title, task.Description,
+			task.AssigneeId, task.DueOn, task.Priority, taskId))
+	})
+}
+
+func (s *pgStore) SetTaskStatus(t Tenant, projectId, taskId, from, to string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var status string
+		err := tx.QueryRow("SELECT status FROM tasks WHERE project_id = $1 AND "+scopedProjectIds+" AND task_id = $3 FOR UPDATE",
+			projectId, t.OrganizationId, taskId).Scan(&status)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != from {
+			return FieldErrors{"status": "the task is " + status + " by now"}
+		}
+		_, err = tx.Exec("UPDATE tasks SET status = $1, ts_updated = now() WHERE task_id = $2", to, taskId)
+		return err
+	})
+}
+
+func (s *pgStore) DeleteTask(t Tenant, projectId, taskId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM tasks WHERE project_id = $1 AND "+scopedProjectIds+" AND task_id = $3",
+			projectId, t.OrganizationId, taskId))
+	})
+}
+
+func (s *pgStore) GetAssignedTasks(t Tenant, userId string) ([]Task, error) {
+	var tasks []Task
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		tasks, err = queryTasks(tx, "WHERE tk.assignee_id::TEXT = $1 AND p.organization_id = $2 AND "+openTasks+byDueDate,
+			userId, t.OrganizationId)
+		return err
+	})
+	return tasks, err
+}
+
+func (s *pgStore) GetOverdueTasks(t Tenant, today string) ([]Task, error) {
+	var tasks []Task
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		tasks, err = queryTasks(tx, "WHERE tk.due_on < $1::DATE AND p.organization_id = $2 AND "+openTasks+byDueDate,
+			today, t.OrganizationId)
+		return err
+	})
+	return tasks, err
+}
+
+func (s *pgStore) GetTaskComments(t Tenant, projectId, taskId string) ([]TaskComment, error) {
+	comments := []TaskComment{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		rows, err := tx.Query(`
+			SELECT c.comment_id, c.task_id, coalesce(c.author_id::TEXT, ''), coalesce(u.full_name, ''), c.body, c.ts_created
+			FROM task_comments c
+			JOIN tasks tk USING (task_id)
+			LEFT JOIN users u ON u.user_id = c.author_id
+			WHERE tk.project_id = $1 AND tk.`+scopedProjectIds+` AND c.task_id = $3
+			ORDER BY c.ts_created`, projectId, t.OrganizationId, taskId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c TaskComment
+			if err = rows.Scan(&c.CommentId, &c.TaskId, &c.AuthorId, &c.AuthorName, &c.Body, &c.TsCreated); err != nil {
+				return err
+			}
+			comments = append(comments, c)
+		}
+		return rows.Err()
+	})
+	return comments, err
+}
+
+func (s *pgStore) AddTaskComment(t Tenant, userId, projectId, taskId, body string) (string, error) {
+	commentId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			INSERT INTO task_comments (comment_id, task_id, author_id, body, ts_created)
+			SELECT $1, task_id, $3, $4, clock_timestamp()
+			FROM tasks WHERE project_id = $5 AND `+scopedProjectIds+` AND task_id = $6`,
+			commentId, t.OrganizationId, userId, body, projectId, taskId))
+	})
+	return commentId, err
+}
+
+// checkComment checks what a comment is on, the comment it replies to and
+// the users it mentions.
+func checkComment(tx *sqlx.Tx, t Tenant, c Comment) error {
+	if routeVar, ok := commentEntities[c.EntityType]; ok {
+		if err := checkReferences(tx, t, c.ProjectId, reference{routeVar, "entity_id", c.EntityId}); err != nil {
+			return err
+		}
+	} else if c.EntityId != c.ProjectId {
+		return FieldErrors{"entity_id": "must be the project"}
+	}
+	if c.ParentId != "" {
+		count := 0
+		if isUUID(c.ParentId) {
+			err := tx.QueryRow(`
+				SELECT count(*) FROM comments c
+				WHERE comment_id = $1 AND project_id = $2 AND parent_id IS NULL AND entity_type = $3 AND `+commentEntityId+` = $4`,
+				c.ParentId, c.ProjectId, c.EntityType, c.EntityId).Scan(&count)
+			if err != nil {
+				return err
+			}
+		}
+		if count == 0 {
+			return FieldErrors{"parent_id": "must be a comment that starts a thread on the same entity"}
+		}
+	}
+	return checkMentions(tx, t, c.Mentions)
+}
+
+func checkMentions(tx *sqlx.Tx, t Tenant, mentions []string) error {
+	for _, userId := range mentions {
+		if err := checkMember(tx, t, "mentions", userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertMentions(tx *sqlx.Tx, commentId string, mentions []string) error {
+	for _, userId := range mentions {
+		if _, err := tx.Exec("INSERT INTO comment_mentions (comment_id, user_id) VALUES ($1, $2)", commentId, userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commentEntityId is the ID of what the comment c is on.
+const commentEntityId = "coalesce(c.boundary_partner_id, c.progress_marker_id, c.challenge_id, c.strategy_id, c.project_id)::TEXT"
+
+// selectComments is completed with a WHERE clause by queryComments.
+const selectComments = `
+	SELECT
+	  c.comment_id, c.project_id, c.entity_type, ` + commentEntityId + `, coalesce(c.parent_id::TEXT, ''),
+	  coalesce(c.author_id::TEXT, ''), coalesce(u.full_name, ''), c.body,
+	  array(SELECT m.user_id::TEXT FROM comment_mentions m WHERE m.comment_id = c.comment_id ORDER BY m.user_id),
+	  c.ts_resolved IS NOT NULL, coalesce(c.resolved_by::TEXT, ''), c.ts_resolved, c.ts_created, c.ts_edited
+	FROM comments c
+	LEFT JOIN users u ON u.user_id = c.author_id
+`
+
+func queryComments(tx *sqlx.Tx, where string, args ...interface{}) ([]Comment, error) {
+	comments := []Comment{}
+	rows, err := tx.Query(selectComments+where+" ORDER BY c.ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Comment
+		err = rows.Scan(&c.CommentId, &c.ProjectId, &c.EntityType, &c.EntityId, &c.ParentId, &c.AuthorId, &c.AuthorName,
+			&c.Body, &c.Mentions, &c.Resolved, &c.ResolvedBy, &c.TsResolved, &c.TsCreated, &c.TsEdited)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *pgStore) GetComments(t Tenant, projectId, entityType, entityId string) ([]Comment, error) {
+	var comments []Comment
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		comments, err = queryComments(tx, `
+			WHERE c.project_id = $1 AND c.`+scopedProjectIds+`
+			  AND ($3 = '' OR c.entity_type = $3 AND `+commentEntityId+` = $4)`,
+			projectId, t.OrganizationId, entityType, entityId)
+		return err
+	})
+	return comments, err
+}
+
+func (s *pgStore) GetComment(t Tenant, projectId, commentId string) (Comment, error) {
+	var comments []Comment
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		comments, err = queryComments(tx, "WHERE c.project_id = $1 AND c."+scopedProjectIds+" AND c.comment_id = $3",
+			projectId, t.OrganizationId, commentId)
+		return err
+	})
+	if err != nil {
+		return Comment{}, err
+	}
+	if len(comments) == 0 {
+		return Comment{}, ErrNotFound
+	}
+	return comments[0], nil
+}
+
+func (s *pgStore) AddComment(t Tenant, userId string, c Comment) (string, error) {
+	commentId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkComment(tx, t, c); err != nil {
+			return err
+		}
+		err := expectRow(tx.Exec(`
+			INSERT INTO comments (
+			  comment_id, project_id, entity_type, boundary_partner_id, progress_marker_id, challenge_id, strategy_id,
+			  parent_id, author_id, body, ts_created)
+			SELECT
+			  $1, project_id, $3,
+			  CASE WHEN $3 IN ('boundary_partner', 'outcome_statement') THEN $4::UUID END,
+			  CASE WHEN $3 = 'progress_marker' THEN $4::UUID END,
+			  CASE WHEN $3 = 'challenge' THEN $4::UUID END,
+			  CASE WHEN $3 = 'strategy' THEN $4::UUID END,
+			  nullif($5, '')::UUID, $6, $7, clock_timestamp()
+			FROM projects WHERE project_id = $8 AND organization_id = $2`,
+			commentId, t.OrganizationId, c.EntityType, c.EntityId, c.ParentId, userId, c.Body, c.ProjectId))
+		if err != nil {
+			return err
+		}
+		return insertMentions(tx, commentId, c.Mentions)
+	})
+	return commentId, err
+}
+
+func (s *pgStore) UpdateComment(t Tenant, projectId, commentId, body string, mentions []string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if err := checkMentions(tx, t, mentions); err != nil {
+			return err
+		}
+		err := expectRow(tx.Exec("UPDATE comments SET body = $3, ts_edited = now() WHERE project_id = $1 AND "+scopedProjectIds+" AND comment_id = $4",
+			projectId, t.OrganizationId, body, commentId))
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM comment_mentions WHERE comment_id = $1", commentId); err != nil {
+			return err
+		}
+		return insertMentions(tx, commentId, mentions)
+	})
+}
+
+func (s *pgStore) DeleteComment(t Tenant, projectId, commentId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM comments WHERE project_id = $1 AND "+scopedProjectIds+" AND comment_id = $3",
+			projectId, t.OrganizationId, commentId))
+	})
+}
+
+func (s *pgStore) SetCommentResolved(t Tenant, projectId, commentId, userId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			UPDATE comments SET
+			  resolved_by = nullif($3, '')::UUID, ts_resolved = CASE WHEN $3 = '' THEN NULL ELSE now() END
+			WHERE project_id = $1 AND `+scopedProjectIds+` AND comment_id = $4`,
+			projectId, t.OrganizationId, userId, commentId))
+	})
+}
+
+// selectNotifications is completed with a WHERE clause and an order by
+// queryNotifications.
+const selectNotifications = `
+	SELECT
+	  n.notification_id, n.user_id, n.kind, coalesce(n.project_id::TEXT, ''), coalesce(n.actor_id::TEXT, ''),
+	  coalesce(u.full_name, ''), n.title, coalesce(n.body, ''), coalesce(n.link, ''),
+	  n.ts_read IS NOT NULL, n.ts_read, n.ts_created, n.in_app, n.email
+	FROM notifications n
+	LEFT JOIN users u ON u.user_id = n.actor_id
+`
+
+func queryNotifications(tx *sqlx.Tx, where string, args ...interface{}) ([]Notification, error) {
+	notifications := []Notification{}
+	rows, err := tx.Query(selectNotifications+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n Notification
+		err = rows.Scan(&n.NotificationId, &n.UserId, &n.Kind, &n.ProjectId, &n.ActorId, &n.ActorName, &n.Title, &n.Body,
+			&n.Link, &n.Read, &n.TsRead, &n.TsCreated, &n.InApp, &n.Email)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *pgStore) AddNotification(t Tenant, n Notification) (string, error) {
+	notificationId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO notifications (
+			  notification_id, organization_id, user_id, kind, project_id, actor_id, title, body, link, in_app, email, ts_created)
+			VALUES ($1, $2, $3, $4, nullif($5, '')::UUID, nullif($6, '')::UUID, $7, $8, $9, $10, $11, clock_timestamp())`,
+			notificationId, t.OrganizationId, n.UserId, n.Kind, n.ProjectId, n.ActorId, n.Title, n.Body, n.Link, n.InApp, n.Email)
+		return err
+	})
+	return notificationId, err
+}
+
+func (s *pgStore) GetNotifications(t Tenant, userId string, unreadOnly bool, limit int) ([]Notification, error) {
+	var notifications []Notification
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		notifications, err = queryNotifications(tx, `
+			WHERE n.user_id = $1 AND n.organization_id = $2 AND n.in_app AND (NOT $3 OR n.ts_read IS NULL)
+			ORDER BY n.ts_created DESC LIMIT $4`,
+			userId, t.OrganizationId, unreadOnly, limit)
+		return err
+	})
+	return notifications, err
+}
+
+func (s *pgStore) CountUnreadNotifications(t Tenant, userId string) (int, error) {
+	count := 0
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return tx.QueryRow(`
+			SELECT count(*) FROM notifications
+			WHERE user_id = $1 AND organization_id = $2 AND in_app AND ts_read IS NULL`,
+			userId, t.OrganizationId).Scan(&count)
+	})
+	return count, err
+}
+
+func (s *pgStore) MarkNotificationsRead(t Tenant, userId, notificationId string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		if notificationId == "" {
+			_, err := tx.Exec(`
+				UPDATE notifications SET ts_read = now()
+				WHERE user_id = $1 AND organization_id = $2 AND in_app AND ts_read IS NULL`,
+				userId, t.OrganizationId)
+			return err
+		}
+		if !isUUID(notificationId) {
+			return ErrNotFound
+		}
+		return expectRow(tx.Exec(`
+			UPDATE notifications SET ts_read = coalesce(ts_read, now())
+			WHERE user_id = $1 AND organization_id = $2 AND in_app AND notification_id = $3`,
+			userId, t.OrganizationId, notificationId))
+	})
+}
+
+func (s *pgStore) GetNotificationPreferences(t Tenant, userId string) ([]NotificationPreference, error) {
+	preferences := []NotificationPreference{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		rows, err := tx.Query(`
+			SELECT kind, in_app, email FROM notification_preferences
+			WHERE user_id = $1 AND organization_id = $2
+			ORDER BY kind`,
+			userId, t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p NotificationPreference
+			if err = rows.Scan(&p.Kind, &p.InApp, &p.Email); err != nil {
+				return err
+			}
+			preferences = append(preferences, p)
+		}
+		return rows.Err()
+	})
+	return preferences, err
+}
+
+func (s *pgStore) SetNotificationPreference(t Tenant, userId string, p NotificationPreference) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (organization_id, user_id, kind, in_app, email)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, kind) DO UPDATE SET in_app = excluded.in_app, email = excluded.email`,
+			t.OrganizationId, userId, p.Kind, p.InApp, p.Email)
+		return err
+	})
+}
+
+func (s *pgStore) GetUnmailedNotifications(t Tenant) ([]Notification, error) {
+	var notifications []Notification
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		notifications, err = queryNotifications(tx, `
+			WHERE n.organization_id = $1 AND n.email <> 'never' AND n.ts_emailed IS NULL
+			ORDER BY n.user_id, n.ts_created`,
+			t.OrganizationId)
+		return err
+	})
+	return notifications, err
+}
+
+func (s *pgStore) SetNotificationsMailed(t Tenant, notificationIds []string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE notifications SET ts_emailed = now()
+			WHERE organization_id = $1 AND notification_id::TEXT = ANY($2)`,
+			t.OrganizationId, pq.StringArray(notificationIds))
+		return err
+	})
+}
+
+func (s *pgStore) GetProjectTeam(t Tenant, projectId string) ([]string, error) {
+	team := []string{}
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		rows, err := tx.Query(`
+			SELECT a.owner_id::TEXT FROM activities a
+			WHERE a.project_id = $1 AND a.`+scopedProjectIds+` AND a.owner_id IS NOT NULL
+			UNION
+			SELECT tk.assignee_id::TEXT FROM tasks tk
+			WHERE tk.project_id = $1 AND tk.`+scopedProjectIds+` AND tk.assignee_id IS NOT NULL AND `+openTasks+`
+			ORDER BY 1`,
+			projectId, t.OrganizationId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var userId string
+			if err = rows.Scan(&userId); err != nil {
+				return err
+			}
+			team = append(team, userId)
+		}
+		return rows.Err()
+	})
+	return team, err
+}
+
+func (s *pgStore) MarkReminderSent(t Tenant, key string) (bool, error) {
+	sent := false
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`
+			INSERT INTO sent_reminders (organization_id, reminder_key) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`,
+			t.OrganizationId, key)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		sent = n == 1
+		return err
+	})
+	return sent, err
+}
+
+func (s *pgStore) PurgeNotifications(t Tenant, before time.Time) (int, error) {
+	purged := 0
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`
+			DELETE FROM notifications
+			WHERE organization_id = $1 AND ts_created < $2
+			  AND (ts_read IS NOT NULL OR NOT in_app) AND (ts_emailed IS NOT NULL OR email = 'never')`,
+			t.OrganizationId, before)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		purged = int(n)
+		_, err = tx.Exec("DELETE FROM sent_reminders WHERE organization_id = $1 AND ts_sent < $2", t.OrganizationId, before)
+		return err
+	})
+	return purged, err
+}
+
+// selectWebhooks is completed with a WHERE clause by queryWebhooks.
+const selectWebhooks = `
+	SELECT webhook_id, url, coalesce(description, ''), events, active, secret, coalesce(created_by::TEXT, ''), ts_created
+	FROM webhooks
+`
+
+func queryWebhooks(tx *sqlx.Tx, where string, args ...interface{}) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	rows, err := tx.Query(selectWebhooks+where+" ORDER BY ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var wh Webhook
+		err = rows.Scan(&wh.WebhookId, &wh.Url, &wh.Description, &wh.Events, &wh.Active, &wh.Secret, &wh.CreatedBy, &wh.TsCreated)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, wh)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *pgStore) GetWebhooks(t Tenant) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		webhooks, err = queryWebhooks(tx, "WHERE organization_id = $1", t.OrganizationId)
+		return err
+	})
+	return webhooks, err
+}
+
+func (s *pgStore) GetWebhook(t Tenant, webhookId string) (Webhook, error) {
+	if !isUUID(webhookId) {
+		return Webhook{}, ErrNotFound
+	}
+	var webhooks []Webhook
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		webhooks, err = queryWebhooks(tx, "WHERE organization_id = $1 AND webhook_id = $2", t.OrganizationId, webhookId)
+		return err
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(webhooks) == 0 {
+		return Webhook{}, ErrNotFound
+	}
+	return webhooks[0], nil
+}
+
+func (s *pgStore) AddWebhook(t Tenant, userId string, wh Webhook) (string, error) {
+	webhookId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO webhooks (webhook_id, organization_id, url, description, events, active, secret, created_by)
+			VALUES ($1, $2, $3, nullif($4, ''), $5, $6, $7, $8)`,
+			webhookId, t.OrganizationId, wh.Url, wh.Description, wh.Events, wh.Active, wh.Secret, userId)
+		return err
+	})
+	return webhookId, err
+}
+
+func (s *pgStore) UpdateWebhook(t Tenant, webhookId string, wh Webhook) error {
+	if !isUUID(webhookId) {
+		return ErrNotFound
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			UPDATE webhooks SET url = $3, description = nullif($4, ''), events = $5, active = $6
+			WHERE webhook_id = $1 AND organization_id = $2`,
+			webhookId, t.OrganizationId, wh.Url, wh.Description, wh.Events, wh.Active))
+	})
+}
+
+func (s *pgStore) DeleteWebhook(t Tenant, webhookId string) error {
+	if !isUUID(webhookId) {
+		return ErrNotFound
+	}
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM webhooks WHERE webhook_id = $1 AND organization_id = $2", webhookId, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) GetSubscribedWebhooks(t Tenant, event string) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		webhooks, err = queryWebhooks(tx, "WHERE organization_id = $1 AND active AND $2 = ANY(events)", t.OrganizationId, event)
+		return err
+	})
+	return webhooks, err
+}
+
+// selectDeliveries is completed with a WHERE clause and an order by
+// queryDeliveries.
+const selectDeliveries = `
+	SELECT
+	  d.delivery_id, d.webhook_id, d.event, d.payload::TEXT, d.status, d.attempts, d.next_attempt_at,
+	  coalesce(d.response_status, 0), coalesce(d.response_body, ''), coalesce(d.last_error, ''),
+	  coalesce(d.redelivery_of::TEXT, ''), d.ts_delivered, d.ts_created
+	FROM webhook_deliveries d
+`
+
+func queryDeliveries(tx *sqlx.Tx, where string, args ...interface{}) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	rows, err := tx.Query(selectDeliveries+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload []byte
+		err = rows.Scan(&d.DeliveryId, &d.WebhookId, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.ResponseStatus, &d.ResponseBody, &d.LastError, &d.RedeliveryOf, &d.TsDelivered, &d.TsCreated)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *pgStore) AddDelivery(t Tenant, d WebhookDelivery) (string, error) {
+	deliveryId := uuid.NewV4().String()
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			INSERT INTO webhook_deliveries (
+			  delivery_id, organization_id, webhook_id, event, payload, next_attempt_at, redelivery_of, ts_created)
+			SELECT $1, organization_id, webhook_id, $4, $5::JSONB, $6, nullif($7, '')::UUID, clock_timestamp()
+			FROM webhooks WHERE organization_id = $2 AND webhook_id = $3`,
+			deliveryId, t.OrganizationId, d.WebhookId, d.Event, string(d.Payload), d.NextAttemptAt, d.RedeliveryOf))
+	})
+	return deliveryId, err
+}
+
+func (s *pgStore) GetDeliveries(t Tenant, webhookId string, limit int) ([]WebhookDelivery, error) {
+	if !isUUID(webhookId) {
+		return []WebhookDelivery{}, nil
+	}
+	var deliveries []WebhookDelivery
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		deliveries, err = queryDeliveries(tx, `
+			WHERE d.organization_id = $1 AND d.webhook_id = $2
+			ORDER BY d.ts_created DESC LIMIT $3`,
+			t.OrganizationId, webhookId, limit)
+		return err
+	})
+	return deliveries, err
+}
+
+func (s *pgStore) GetDelivery(t Tenant, webhookId, deliveryId string) (WebhookDelivery, error) {
+	if !isUUID(webhookId) || !isUUID(deliveryId) {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	var deliveries []WebhookDelivery
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		deliveries, err = queryDeliveries(tx, "WHERE d.organization_id = $1 AND d.webhook_id = $2 AND d.delivery_id = $3",
+			t.OrganizationId, webhookId, deliveryId)
+		return err
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if len(deliveries) == 0 {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	return deliveries[0], nil
+}
+
+func (s *pgStore) GetDueDeliveries(t Tenant, now time.Time) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		deliveries, err = queryDeliveries(tx, `
+			JOIN webhooks wh USING (webhook_id)
+			WHERE d.organization_id = $1 AND d.status = 'pending' AND d.next_attempt_at <= $2 AND wh.active
+			ORDER BY d.next_attempt_at`,
+			t.OrganizationId, now)
+		return err
+	})
+	return deliveries, err
+}
+
+func (s *pgStore) ClaimDelivery(t Tenant, deliveryId string, now, until time.Time) (bool, error) {
+	claimed := false
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := expectRow(tx.Exec(`
+			UPDATE webhook_deliveries SET next_attempt_at = $4
+			WHERE organization_id = $1 AND delivery_id = $2 AND status = 'pending' AND next_attempt_at <= $3`,
+			t.OrganizationId, deliveryId, now, until))
+		if err == ErrNotFound {
+			return nil
+		}
+		claimed = err == nil
+		return err
+	})
+	return claimed, err
+}
+
+func (s *pgStore) SetDeliveryResult(t Tenant, d WebhookDelivery) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec(`
+			UPDATE webhook_deliveries SET
+			  status = $3, attempts = $4, next_attempt_at = $5, response_status = nullif($6, 0),
+			  response_body = nullif($7, ''), last_error = nullif($8, ''), ts_delivered = $9
+			WHERE organization_id = $1 AND delivery_id = $2`,
+			t.OrganizationId, d.DeliveryId, d.Status, d.Attempts, d.NextAttemptAt, d.ResponseStatus,
+			d.ResponseBody, d.LastError, d.TsDelivered))
+	})
+}
+
+// the document of each kind of entity, as in the search indexes
+const (
+	projectDocument = `setweight(to_tsvector('english', coalesce(p.project_name, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(p.description, '')), 'B')`
+	partnerDocument = `setweight(to_tsvector('english', coalesce(bp.partner_name, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(bp.outcome_statement, '')), 'B')`
+	markerDocument    = `to_tsvector('english', coalesce(pm.title, ''))`
+	challengeDocument = `to_tsvector('english', coalesce(c.challenge_name, ''))`
+	strategyDocument  = `to_tsvector('english', coalesce(st.strategy_name, ''))`
+	journalDocument   = `setweight(to_tsvector('english', coalesce(j.description_of_change, '')), 'A') ||
+		setweight(to_tsvector('english', ` + journalNarrative + `), 'B')`
+)
+
+// journalNarrative is the text of an outcome journal under its description
+// of change.
+const journalNarrative = `coalesce(j.contributing_factors, '') || ' ' || coalesce(j.sources_of_evidence, '') || ' ' ||
+		coalesce(j.unanticipated_change, '') || ' ' || coalesce(j.lessons, '')`
+
+// searchSources select the entities of each kind matching the query q.query
+// as type, id, project, partner and marker IDs, project name, title, body and
+// rank. The projects are p, Search adds the conditions on them.
+var searchSources = map[string]string{
+	SEARCH_PROJECT: `
+		SELECT 'project', p.project_id::TEXT, p.project_id::TEXT, '', '', p.project_name,
+		  coalesce(p.project_name, ''), coalesce(p.description, ''), ts_rank(` + projectDocument + `, q.query)
+		FROM projects p
+		CROSS JOIN q
+		WHERE (` + projectDocument + `) @@ q.query`,
+	SEARCH_PARTNER: `
+		SELECT 'boundary_partner', bp.boundary_partner_id::TEXT, p.project_id::TEXT, bp.boundary_partner_id::TEXT, '',
+		  p.project_name, coalesce(bp.partner_name, ''), coalesce(bp.outcome_statement, ''), ts_rank(` + partnerDocument + `, q.query)
+		FROM boundary_partners bp
+		JOIN projects p ON p.project_id = bp.project_id
+		CROSS JOIN q
+		WHERE (` + partnerDocument + `) @@ q.query`,
+	SEARCH_MARKER: `
+		SELECT 'progress_marker', pm.progress_marker_id::TEXT, p.project_id::TEXT, bp.boundary_partner_id::TEXT,
+		  pm.progress_marker_id::TEXT, p.project_name, coalesce(pm.title, ''), '', ts_rank(` + markerDocument + `, q.query)
+		FROM progress_markers pm
+		JOIN boundary_partners bp ON bp.boundary_partner_id = pm.boundary_partner_id
+		JOIN projects p ON p.project_id = bp.project_id
+		CROSS JOIN q
+		WHERE ` + markerDocument + ` @@ q.query`,
+	SEARCH_CHALLENGE: `
+		SELECT 'challenge', c.challenge_id::TEXT, p.project_id::TEXT, bp.boundary_partner_id::TEXT,
+		  pm.progress_marker_id::TEXT, p.project_name, coalesce(c.challenge_name, ''), '', ts_rank(` + challengeDocument + `, q.query)
+		FROM challenges c
+		JOIN progress_markers pm ON pm.progress_marker_id = c.progress_marker_id
+		JOIN boundary_partners bp ON bp.boundary_partner_id = pm.boundary_partner_id
+		JOIN projects p ON p.project_id = bp.project_id
+		CROSS JOIN q
+		WHERE ` + challengeDocument + ` @@ q.query`,
+	SEARCH_STRATEGY: `
+		SELECT 'strategy', st.strategy_id::TEXT, p.project_id::TEXT, bp.boundary_partner_id::TEXT,
+		  pm.progress_marker_id::TEXT, p.project_name, coalesce(st.strategy_name, ''), '', ts_rank(` + strategyDocument + `, q.query)
+		FROM strategies st
+		JOIN progress_markers pm ON pm.progress_marker_id = st.progress_marker_id
+		JOIN boundary_partners bp ON bp.boundary_partner_id = pm.boundary_partner_id
+		JOIN projects p ON p.project_id = bp.project_id
+		CROSS JOIN q
+		WHERE ` + strategyDocument + ` @@ q.query`,
+	SEARCH_JOURNAL: `
+		SELECT 'outcome_journal', j.journal_id::TEXT, p.project_id::TEXT, j.boundary_partner_id::TEXT, '',
+		  p.project_name, coalesce(j.description_of_change, ''), trim(` + journalNarrative + `), ts_rank(` + journalDocument + `, q.query)
+		FROM outcome_journals j
+		JOIN projects p ON p.project_id = j.project_id
+		CROSS JOIN q
+		WHERE (` + journalDocument + `) @@ q.query`,
+}
+
+// options of ts_headline for titles, which are highlighted whole, and for
+// the snippets of longer text
+var (
+	titleHeadline   = `HighlightAll=true, StartSel="` + HIGHLIGHT_START + `", StopSel="` + HIGHLIGHT_STOP + `"`
+	snippetHeadline = `MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=" … ", ` +
+		`StartSel="` + HIGHLIGHT_START + `", StopSel="` + HIGHLIGHT_STOP + `"`
+)
+
+func (s *pgStore) Search(t Tenant, q SearchQuery) ([]SearchResult, int, error) {
+	wanted := make(map[string]bool)
+	for _, kind := range q.Types {
+		wanted[kind] = true
+	}
+	var selects []string
+	for _, kind := range searchTypes {
+		if len(wanted) == 0 || wanted[kind] {
+			selects = append(selects, searchSources[kind]+`
+		  AND p.organization_id = $2 AND ($3::UUID[] IS NULL OR p.project_id = ANY($3::UUID[]))`)
+		}
+	}
+	hits := `
+		WITH q AS (SELECT websearch_to_tsquery('english', $1) AS query),
+		hits (type, id, project_id, boundary_partner_id, progress_marker_id, project_name, title, body, rank) AS (` +
+		strings.Join(selects, "\n\t\tUNION ALL") + `)
+	`
+	projectIds := pq.StringArray(q.ProjectIds)
+	results := []SearchResult{}
+	total := 0
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(hits+"SELECT count(*) FROM hits", q.Query, t.OrganizationId, projectIds).Scan(&total)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(hits+`
+			SELECT
+			  h.type, h.id, h.project_id, h.boundary_partner_id, h.progress_marker_id, coalesce(h.project_name, ''),
+			  ts_headline('english', h.title, q.query, $4), ts_headline('english', h.body, q.query, $5), h.rank
+			FROM (SELECT * FROM hits ORDER BY rank DESC, title, id LIMIT $6 OFFSET $7) h, q
+			ORDER BY h.rank DESC, h.title, h.id`,
+			q.Query, t.OrganizationId, projectIds, titleHeadline, snippetHeadline, q.Limit, q.Offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r SearchResult
+			err = rows.Scan(&r.Type, &r.Id, &r.ProjectId, &r.BoundaryPartnerId, &r.ProgressMarkerId, &r.ProjectName,
+				&r.Title, &r.Snippet, &r.Rank)
+			if err != nil {
+				return err
+			}
+			results = append(results, r)
+		}
+		return rows.Err()
+	})
+	return results, total, err
+}
+
+// selectJobs is completed with a WHERE clause by queryJobs.
+const selectJobs = `
+	SELECT job_id, name, run_at, status, attempts, max_attempts, coalesce(last_error, ''), ts_started, ts_finished
+	FROM jobs
+`
+
+func queryJobs(q sqlx.Queryer, where string, args ...interface{}) ([]Job, error) {
+	jobs := []Job{}
+	rows, err := q.Query(selectJobs+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var j Job
+		err = rows.Scan(&j.JobId, &j.Name, &j.RunAt, &j.Status, &j.Attempts, &j.MaxAttempts, &j.LastError,
+			&j.TsStarted, &j.TsFinished)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *pgStore) ScheduleJob(name string, runAt time.Time, maxAttempts int) error {
+	_, err := s.db.Exec(`
+		INSERT INTO jobs (job_id, name, run_at, max_attempts) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) WHERE status IN ('pending', 'running') DO NOTHING`,
+		uuid.NewV4().String(), name, runAt, maxAttempts)
+	return err
+}
+
+func (s *pgStore) GetDueJobs(now time.Time) ([]Job, error) {
+	return queryJobs(s.db, "WHERE status = 'pending' AND run_at <= $1 ORDER BY run_at", now)
+}
+
+// LockJob holds a session level advisory lock on a connection of its own
+// until unlock is called, so the lock is released if the instance dies.
+func (s *pgStore) LockJob(name string) (func(), bool, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	ok := false
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", JOB_LOCK_ID, name).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+	unlock := func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1, hashtext($2))", JOB_LOCK_ID, name); err != nil {
+			warnf("unlock job %s: %v", name, err)
+		}
+		conn.Close()
+	}
+	return unlock, true, nil
+}
+
+func (s *pgStore) StartJob(jobId string) (Job, error) {
+	err := expectRow(s.db.Exec(`
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, ts_started = now(), ts_finished = NULL
+		WHERE job_id = $1 AND status = 'pending'`,
+		jobId))
+	if err != nil {
+		return Job{}, err
+	}
+	jobs, err := queryJobs(s.db, "WHERE job_id = $1", jobId)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(jobs) == 0 {
+		return Job{}, ErrNotFound
+	}
+	return jobs[0], nil
+}
+
+func (s *pgStore) FinishJob(jobId string, runErr error, retryAt time.Time) error {
+	message := ""
+	if runErr != nil {
+		message = runErr.Error()
+	}
+	return expectRow(s.db.Exec(`
+		UPDATE jobs SET
+		  status = CASE WHEN $2 = '' THEN 'done' WHEN attempts < max_attempts THEN 'pending' ELSE 'failed' END,
+		  run_at = CASE WHEN $2 <> '' AND attempts < max_attempts THEN $3 ELSE run_at END,
+		  last_error = nullif($2, ''), ts_finished = now()
+		WHERE job_id = $1`,
+		jobId, message, retryAt))
+}
+
+func (s *pgStore) RequeueStaleJobs(before time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE jobs SET
+		  status = CASE WHEN attempts < max_attempts THEN 'pending' ELSE 'failed' END,
+		  last_error = 'abandoned while running', ts_finished = now()
+		WHERE status = 'running' AND ts_started < $1`,
+		before)
+	return err
+}
+
+func (s *pgStore) PurgeJobs(before time.Time) (int, error) {
+	res, err := s.db.Exec("DELETE FROM jobs WHERE status IN ('done', 'failed') AND ts_finished < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// selectProjectStats is completed with a WHERE clause by queryProjectStats.
+const selectProjectStats = `
+	SELECT
+	  p.project_id, coalesce(p.project_name, ''), p.currency, ` + projectBudget + `,
+	  (SELECT coalesce(sum(project_amount), 0) FROM expenditures e WHERE e.project_id = p.project_id),
+	  coalesce(to_char(p.timeline_from AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+	  coalesce(to_char(p.timeline_to AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+	  (SELECT count(*) FROM boundary_partners bp WHERE bp.project_id = p.project_id),
+	  m.total, m.expect_count, m.like_count, m.love_count,
+	  (SELECT count(*) FROM challenges JOIN progress_markers USING (progress_marker_id)
+	   JOIN boundary_partners bp USING (boundary_partner_id) WHERE bp.project_id = p.project_id),
+	  (SELECT count(*) FROM strategies JOIN progress_markers USING (progress_marker_id)
+	   JOIN boundary_partners bp USING (boundary_partner_id) WHERE bp.project_id = p.project_id),
+	  (SELECT count(*) FROM external_resources er WHERE er.project_id = p.project_id AND scan_status = 'clean'),
+	  r.low_count, r.medium_count, r.high_count
+	FROM projects p
+	CROSS JOIN LATERAL (
+	  SELECT
+	    count(*) AS total,
+	    count(*) FILTER (WHERE type = 1) AS expect_count,
+	    count(*) FILTER (WHERE type = 2) AS like_count,
+	    count(*) FILTER (WHERE type = 3) AS love_count
+	  FROM progress_markers JOIN boundary_partners bp USING (boundary_partner_id)
+	  WHERE bp.project_id = p.project_id
+	) m
+	CROSS JOIN LATERAL (
+	  SELECT
+	    count(*) FILTER (WHERE jr.rating = 'low') AS low_count,
+	    count(*) FILTER (WHERE jr.rating = 'medium') AS medium_count,
+	    count(*) FILTER (WHERE jr.rating = 'high') AS high_count
+	  FROM journal_ratings jr
+	  JOIN (
+	    SELECT DISTINCT ON (j.boundary_partner_id) j.journal_id
+	    FROM outcome_journals j
+	    WHERE j.project_id = p.project_id AND j.status = 'submitted'
+	    ORDER BY j.boundary_partner_id, j.monitoring_date DESC
+	  ) latest USING (journal_id)
+	) r
+`
+
+func queryProjectStats(tx *sqlx.Tx, where string, args ...interface{}) ([]ProjectStats, error) {
+	projects := []ProjectStats{}
+	rows, err := tx.Query(selectProjectStats+where+" ORDER BY p.ts_created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st ProjectStats
+		err = rows.Scan(&st.ProjectId, &st.ProjectName, &st.Currency, &st.Budget, &st.Spent, &st.TimelineFrom, &st.TimelineTo,
+			&st.BoundaryPartners, &st.ProgressMarkers, &st.MarkersByLevel.Expect, &st.MarkersByLevel.Like, &st.MarkersByLevel.Love,
+			&st.Challenges, &st.Strategies, &st.Resources,
+			&st.LatestRatings.Low, &st.LatestRatings.Medium, &st.LatestRatings.High)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, st)
+	}
+	return projects, rows.Err()
+}
+
+func (s *pgStore) GetProjectStats(t Tenant, projectId string) (ProjectStats, error) {
+	var st ProjectStats
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		projects, err := queryProjectStats(tx, "WHERE p.organization_id = $1 AND p.project_id = $2", t.OrganizationId, projectId)
+		if err != nil {
+			return err
+		}
+		if len(projects) == 0 {
+			return ErrNotFound
+		}
+		st = projects[0]
+
+		rows, err := tx.Query(`
+			SELECT
+			  bp.boundary_partner_id, bp.partner_name,
+			  count(pm.progress_marker_id),
+			  count(*) FILTER (WHERE pm.type = 1),
+			  count(*) FILTER (WHERE pm.type = 2),
+			  count(*) FILTER (WHERE pm.type = 3),
+			  coalesce(sum(c.n), 0),
+			  coalesce(sum(s.n), 0)
+			FROM boundary_partners bp
+			LEFT JOIN progress_markers pm USING (boundary_partner_id)
+			LEFT JOIN LATERAL (SELECT count(*) AS n FROM challenges c WHERE c.progress_marker_id = pm.progress_marker_id) c ON TRUE
+			LEFT JOIN LATERAL (SELECT count(*) AS n FROM strategies s WHERE s.progress_marker_id = pm.progress_marker_id) s ON TRUE
+			WHERE bp.project_id = $1
+			GROUP BY bp.boundary_partner_id
+			ORDER BY min(bp.ts_created)`, projectId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		st.Partners = []PartnerStats{}
+		partners := make(map[string]int)
+		for rows.Next() {
+			ps := PartnerStats{Trend: []RatingPeriod{}}
+			err = rows.Scan(&ps.BoundaryPartnerId, &ps.PartnerName, &ps.ProgressMarkers,
+				&ps.MarkersByLevel.Expect, &ps.MarkersByLevel.Like, &ps.MarkersByLevel.Love, &ps.Challenges, &ps.Strategies)
+			if err != nil {
+				return err
+			}
+			partners[ps.BoundaryPartnerId] = len(st.Partners)
+			st.Partners = append(st.Partners, ps)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.Query(`
+			SELECT
+			  j.boundary_partner_id, to_char(j.monitoring_date, 'YYYY-MM-DD'),
+			  count(*) FILTER (WHERE jr.rating = 'low'),
+			  count(*) FILTER (WHERE jr.rating = 'medium'),
+			  count(*) FILTER (WHERE jr.rating = 'high')
+			FROM outcome_journals j
+			LEFT JOIN journal_ratings jr USING (journal_id)
+			WHERE j.project_id = $1 AND j.status = 'submitted'
+			GROUP BY j.journal_id
+			ORDER BY j.monitoring_date`, projectId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var partnerId string
+			var period RatingPeriod
+			err = rows.Scan(&partnerId, &period.MonitoringDate, &period.Ratings.Low, &period.Ratings.Medium, &period.Ratings.High)
+			if err != nil {
+				return err
+			}
+			ps := &st.Partners[partners[partnerId]]
+			ps.Trend = append(ps.Trend, period)
+		}
+		return rows.Err()
+	})
+	return st, err
+}
+
+func (s *pgStore) GetOrganizationStats(t Tenant) ([]ProjectStats, error) {
+	var projects []ProjectStats
+	err := s.tenantTx(t, func(tx *sqlx.Tx) (err error) {
+		projects, err = queryProjectStats(tx, "WHERE p.organization_id = $1", t.OrganizationId)
+		return err
+	})
+	return projects, err
+}
+
+func (s *pgStore) GetOrganization(t Tenant) (Organization, error) {
+	org := Organization{OrganizationId: t.OrganizationId}
+	var logo ProjectLogo
+	err := s.db.QueryRow(`
+		SELECT
+		  coalesce(organization_name, ''), coalesce(brand_color, ''),
+		  coalesce(logo_key, ''), coalesce(logo_medium_key, ''), coalesce(logo_thumbnail_key, ''),
+		  coalesce(logo_content_type, ''), coalesce(logo_width, 0), coalesce(logo_height, 0)
+		FROM organizations WHERE organization_id = $1`,
+		t.OrganizationId).Scan(&org.OrganizationName, &org.BrandColor,
+		&logo.OriginalKey, &logo.MediumKey, &logo.ThumbnailKey, &logo.ContentType, &logo.Width, &logo.Height)
+	if err == sql.ErrNoRows {
+		return org, ErrNotFound
+	}
+	org.Logo = organizationLogo(logo)
+	return org, err
+}
+
+func (s *pgStore) SetOrganizationLogo(t Tenant, logo *ProjectLogo) (*ProjectLogo, error) {
+	var old ProjectLogo
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(`
+			SELECT
+			  coalesce(logo_key, ''), coalesce(logo_medium_key, ''), coalesce(logo_thumbnail_key, ''),
+			  coalesce(logo_content_type, ''), coalesce(logo_width, 0), coalesce(logo_height, 0)
+			FROM organizations WHERE organization_id = $1 FOR UPDATE`,
+			t.OrganizationId).Scan(&old.OriginalKey, &old.MediumKey, &old.ThumbnailKey, &old.ContentType, &old.Width, &old.Height)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if logo == nil {
+			logo = &ProjectLogo{}
+		}
+		_, err = tx.Exec(`
+			UPDATE organizations SET
+			  logo_key = nullif($1, ''), logo_medium_key = nullif($2, ''), logo_thumbnail_key = nullif($3, ''),
+			  logo_content_type = nullif($4, ''), logo_width = nullif($5, 0), logo_height = nullif($6, 0)
+			WHERE organization_id = $7`,
+			logo.OriginalKey, logo.MediumKey, logo.ThumbnailKey, logo.ContentType, logo.Width, logo.Height, t.OrganizationId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organizationLogo(old), nil
+}
+
+func (s *pgStore) SetBrandColor(t Tenant, color string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("UPDATE organizations SET brand_color = nullif($1, '') WHERE organization_id = $2",
+			color, t.OrganizationId))
+	})
+}
+
+func (s *pgStore) GetReportTemplate(t Tenant, format string) (string, error) {
+	var body string
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		err := tx.QueryRow("SELECT body FROM report_templates WHERE organization_id = $1 AND format = $2",
+			t.OrganizationId, format).Scan(&body)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	return body, err
+}
+
+func (s *pgStore) SetReportTemplate(t Tenant, userId, format, body string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO report_templates (organization_id, format, body, updated_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (organization_id, format) DO UPDATE SET body = $3, updated_by = $4, ts_updated = now()`,
+			t.OrganizationId, format, body, userId)
+		return err
+	})
+}
+
+func (s *pgStore) DeleteReportTemplate(t Tenant, format string) error {
+	return s.tenantTx(t, func(tx *sqlx.Tx) error {
+		return expectRow(tx.Exec("DELETE FROM report_templates WHERE organization_id = $1 AND format = $2", t.OrganizationId, format))
+	})
+}
+
+// ownershipChecks verify that a nested route ID belongs to the project in the URL
+// and, where the route also names a boundary partner, to that partner. Every
+// check is scoped to the tenant's organization.
+var ownershipChecks = map[string]struct {
+	query            string
+	belongsToPartner bool
+}{
+	"partnerId": {`
+		SELECT count(*) FROM boundary_partners
+		JOIN projects USING (project_id)
+		WHERE boundary_partner_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"progressMarkerId": {`
+		SELECT count(*) FROM progress_markers
+		JOIN boundary_partners USING (boundary_partner_id)
+		JOIN projects USING (project_id)
+		WHERE progress_marker_id = $1 AND project_id = $2 AND organization_id = $3`, true},
+	"challengeId": {`
+		SELECT count(*) FROM challenges
+		JOIN progress_markers USING (progress_marker_id)
+		JOIN boundary_partners USING (boundary_partner_id)
+		JOIN projects USING (project_id)
+		WHERE challenge_id = $1 AND project_id = $2 AND organization_id = $3`, true},
+	"strategyId": {`
+		SELECT count(*) FROM strategies
+		JOIN progress_markers USING (progress_marker_id)
+		JOIN boundary_partners USING (boundary_partner_id)
+		JOIN projects USING (project_id)
+		WHERE strategy_id = $1 AND project_id = $2 AND organization_id = $3`, true},
+	"resourceId": {`
+		SELECT count(*) FROM external_resources
+		JOIN projects USING (project_id)
+		WHERE resource_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"journalId": {`
+		SELECT count(*) FROM outcome_journals
+		JOIN projects USING (project_id)
+		WHERE journal_id = $1 AND project_id = $2 AND organization_id = $3`, true},
+	"budgetLineId": {`
+		SELECT count(*) FROM budget_lines
+		JOIN projects USING (project_id)
+		WHERE budget_line_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"expenditureId": {`
+		SELECT count(*) FROM expenditures
+		JOIN projects USING (project_id)
+		WHERE expenditure_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"grantId": {`
+		SELECT count(*) FROM grants
+		JOIN projects USING (project_id)
+		WHERE grant_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"activityId": {`
+		SELECT count(*) FROM activities
+		JOIN projects USING (project_id)
+		WHERE activity_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"taskId": {`
+		SELECT count(*) FROM tasks
+		JOIN projects USING (project_id)
+		WHERE task_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+	"commentId": {`
+		SELECT count(*) FROM comments
+		JOIN projects USING (project_id)
+		WHERE comment_id = $1 AND project_id = $2 AND organization_id = $3`, false},
+}
 
-func connectPostgres() {
-	if pg != nil {
-		// already connected
-		return
+func (s *pgStore) CheckOwnership(t Tenant, vars map[string]string) (string, error) {
+	projectId := vars["projectId"]
+	if !isUUID(projectId) {
+		return "project_id", nil
 	}
-	log.Println("Connect to PostgreSQL")
-	connString := fmt.Sprintf("dbname=%s user=%s password=%s host=%s sslmode=disable search_path=%s",
-		DB_NAME, DB_USER, DB_PASS, DB_HOST, DB_SCHEMA)
-	pg = sqlx.MustConnect("postgres", connString)
-	pg.SetMaxIdleConns(1)
-	pg.SetMaxOpenConns(8)
-	log.Println("... Connected to PostgreSQL")
+	partnerId := vars["partnerId"]
+	field := ""
+	err := s.tenantTx(t, func(tx *sqlx.Tx) error {
+		var count int
+		err := tx.QueryRow("SELECT count(*) FROM projects WHERE project_id = $1 AND organization_id = $2",
+			projectId, t.OrganizationId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			field = "project_id"
+			return nil
+		}
+		for _, nested := range ownershipOrder {
+			id, exists := vars[nested.routeVar]
+			if !exists {
+				continue
+			}
+			if !isUUID(id) {
+				field = nested.field
+				return nil
+			}
+			check := ownershipChecks[nested.routeVar]
+			query := check.query
+			args := []interface{}{id, projectId, t.OrganizationId}
+			if check.belongsToPartner && partnerId != "" {
+				query += " AND boundary_partner_id = $4"
+				args = append(args, partnerId)
+			}
+			err = tx.QueryRow(query, args...).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				field = nested.field
+				return nil
+			}
+		}
+		return nil
+	})
+	return field, err
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// importedGrants returns the grants of an export without their donor IDs, so
+// that they are matched by name. An export from before grants gets one
+// without amount from the donor named by the project.
+func importedGrants(doc ProjectExport) []Grant {
+	if len(doc.Grants) == 0 {
+		if donor := strings.TrimSpace(doc.Project.Donor); donor != "" {
+			return []Grant{{DonorName: donor, Currency: doc.Project.Currency}}
+		}
+	}
+	grants := make([]Grant, len(doc.Grants))
+	for i, g := range doc.Grants {
+		g.DonorId = ""
+		g.DonorName = strings.TrimSpace(g.DonorName)
+		grants[i] = g
+	}
+	return grants
+}
+
+func (s *Server) getDonors(w http.ResponseWriter, r *http.Request) {
+	donors, err := s.store.GetDonors(tenantOf(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{donors, "success"})
+}
+
+func (s *Server) getDonor(w http.ResponseWriter, r *http.Request) {
+	donor, err := s.store.GetDonor(tenantOf(r), mux.Vars(r)["donorId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{donor, "success"})
+}
+
+// readDonor decodes and validates a donor. It responds itself if that fails.
+func readDonor(w http.ResponseWriter, r *http.Request) (Donor, bool) {
+	var input Donor
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Donor{}, false
+	}
+	input.DonorName = strings.TrimSpace(input.DonorName)
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Donor{}, false
+	}
+	return input, true
+}
+
+func (s *Server) addDonor(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := readDonor(w, r)
+	if !ok {
+		return
+	}
+	donorId, err := s.store.AddDonor(tenantOf(r), input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	input.DonorId = donorId
+
+	JSON(w, http.StatusOK, Response{input, "success"})
+}
+
+func (s *Server) updateDonor(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	// a donor of another organization is not found, whatever the body
+	donorId := mux.Vars(r)["donorId"]
+	if _, err := s.store.GetDonor(tenantOf(r), donorId); err != nil {
+		respondError(w, err)
+		return
+	}
+	input, ok := readDonor(w, r)
+	if !ok {
+		return
+	}
+	input.DonorId = donorId
+	err := s.store.UpdateDonor(tenantOf(r), donorId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{input, "success"})
+}
+
+func (s *Server) deleteDonor(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	err := s.store.DeleteDonor(tenantOf(r), mux.Vars(r)["donorId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) getGrantOfficers(w http.ResponseWriter, r *http.Request) {
+	donorId := mux.Vars(r)["donorId"]
+	if _, err := s.store.GetDonor(tenantOf(r), donorId); err != nil {
+		respondError(w, err)
+		return
+	}
+	officers, err := s.store.GetGrantOfficers(tenantOf(r), donorId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{officers, "success"})
+}
+
+// addGrantOfficer makes a member of the organization a grant officer of the
+// donor. From then on the user can only use the donor view.
+func (s *Server) addGrantOfficer(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	donorId := mux.Vars(r)["donorId"]
+	if _, err := s.store.GetDonor(tenantOf(r), donorId); err != nil {
+		respondError(w, err)
+		return
+	}
+	userId := r.FormValue("user_id")
+	if errs := validate(required("user_id", userId)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	err := s.store.SetGrantOfficer(tenantOf(r), userId, donorId)
+	if err == ErrNotFound {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"user_id": "must be a user of the organization"}, "validation failed"})
+		return
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+// removeGrantOfficer makes a grant officer an ordinary member of the
+// organization again.
+func (s *Server) removeGrantOfficer(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	officers, err := s.store.GetGrantOfficers(tenantOf(r), vars["donorId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	for _, officer := range officers {
+		if officer.UserId == vars["userId"] {
+			if err = s.store.SetGrantOfficer(tenantOf(r), officer.UserId, ""); err != nil {
+				respondError(w, err)
+				return
+			}
+			JSON(w, http.StatusOK, Response{nil, "success"})
+			return
+		}
+	}
+	respondError(w, ErrNotFound)
+}
+
+// donorProjects returns the projects a donor funds. A grant officer only
+// gets those of their own donor, others are reported as missing.
+func (s *Server) donorProjects(r *http.Request) ([]DonorProject, error) {
+	user := context.Get(r, USER).(User)
+	donorId := mux.Vars(r)["donorId"]
+	if user.DonorId != "" && user.DonorId != donorId {
+		return nil, ErrNotFound
+	}
+	return s.store.GetDonorProjects(tenantOf(r), donorId)
+}
+
+// getDonorProjects is the read-only view of the projects a donor funds, with
+// the donor's grants.
+func (s *Server) getDonorProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.donorProjects(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{projects, "success"})
+}
+
+func (s *Server) getDonorProject(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.donorProjects(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	for _, p := range projects {
+		if p.Project.ProjectId == mux.Vars(r)["projectId"] {
+			JSON(w, http.StatusOK, Response{p, "success"})
+			return
+		}
+	}
+	respondError(w, ErrNotFound)
+}
+
+func (s *Server) getGrants(w http.ResponseWriter, r *http.Request) {
+	grants, err := s.store.GetGrants(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{grants, "success"})
+}
+
+// readGrant decodes and validates a grant. It responds itself if that fails.
+func (s *Server) readGrant(w http.ResponseWriter, r *http.Request) (Grant, bool) {
+	project, err := s.store.GetProject(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return Grant{}, false
+	}
+	var input Grant
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Grant{}, false
+	}
+	input.ProjectId = project.ProjectId
+	input.Currency = strings.ToUpper(input.Currency)
+	if input.Currency == "" {
+		input.Currency = project.Currency
+	}
+	errs := input.Validate()
+	errs = nestErrors(errs, "", validate(required("donor_id", input.DonorId)))
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Grant{}, false
+	}
+	return input, true
+}
+
+func (s *Server) addGrant(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := s.readGrant(w, r)
+	if !ok {
+		return
+	}
+	grantId, err := s.store.AddGrant(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	grant, err := s.store.GetGrant(tenantOf(r), input.ProjectId, grantId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{grant, "success"})
+}
+
+func (s *Server) updateGrant(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	grantId := mux.Vars(r)["grantId"]
+	input, ok := s.readGrant(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.UpdateGrant(tenantOf(r), input.ProjectId, grantId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	grant, err := s.store.GetGrant(tenantOf(r), input.ProjectId, grantId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{grant, "success"})
+}
+
+func (s *Server) deleteGrant(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.DeleteGrant(tenantOf(r), vars["projectId"], vars["grantId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
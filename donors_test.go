@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestProjectDonorRoutes sets and resets the donor of a project through the
+// routes kept from before donors had grants.
+func TestProjectDonorRoutes(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "Radio", Currency: "EUR"})
+	check(t, err)
+	form := "application/x-www-form-urlencoded"
+
+	if code, out := ts.do(key, "POST", "/projects/"+projectId+"/update/project_donor", form, "project_donor=+"); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+	for _, name := range []string{"Fund+A", "fund+a", "Fund+B"} {
+		if code, out := ts.do(key, "POST", "/projects/"+projectId+"/update/project_donor", form, "project_donor="+name); code != http.StatusOK {
+			t.Fatal(code, out)
+		}
+	}
+	grants, err := ts.store.GetGrants(a, projectId)
+	check(t, err)
+	if len(grants) != 2 || grants[0].DonorName != "Fund A" || grants[1].DonorName != "Fund B" || grants[0].Currency != "EUR" {
+		t.Fatalf("%+v", grants)
+	}
+	project, err := ts.store.GetProject(a, projectId)
+	check(t, err)
+	if project.Donor != "Fund A, Fund B" {
+		t.Fatal(project.Donor)
+	}
+
+	// a grant with an amount is more than a donor and stays
+	funded := grants[1]
+	funded.Amount = 1000000
+	check(t, ts.store.UpdateGrant(a, projectId, funded.GrantId, funded))
+	if code, out := ts.do(key, "POST", "/projects/"+projectId+"/reset/project_donor", "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	grants, err = ts.store.GetGrants(a, projectId)
+	check(t, err)
+	if len(grants) != 1 || grants[0].GrantId != funded.GrantId {
+		t.Fatalf("%+v", grants)
+	}
+	if donors, _ := ts.store.GetDonors(a); len(donors) != 2 {
+		t.Fatalf("%+v", donors)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// name of the document in a zip export, the files are stored next to it
+// under files/<sha256>
+const EXPORT_DOCUMENT = "project.json"
+
+// largest import accepted, a zip export included
+const MAX_IMPORT_SIZE = 1 << 30
+
+// largest document accepted in a zip export
+const MAX_DOCUMENT_SIZE = 16 << 20
+
+// exportProject downloads a project as a JSON document, or with ?files=true
+// as a zip file that also holds the resource files and the logo.
+func (s *Server) exportProject(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+
+	withFiles := false
+	if value := r.FormValue("files"); value != "" {
+		var err error
+		if withFiles, err = strconv.ParseBool(value); err != nil {
+			JSON(w, http.StatusBadRequest, Response{FieldErrors{"files": "must be true or false"}, "validation failed"})
+			return
+		}
+	}
+
+	doc, err := s.store.ExportProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	doc.Format = EXPORT_FORMAT
+	doc.Version = EXPORT_VERSION
+	doc.ExportedAt = time.Now().UTC()
+
+	name := sanitizeFilename(doc.Project.ProjectName)
+	if name == "" {
+		name = "project"
+	}
+	if !withFiles {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".json"}))
+		JSON(w, http.StatusOK, doc)
+		return
+	}
+
+	// the files by their path in the zip file
+	files := make(map[string]string)
+	addFile := func(key string) string {
+		path := "files/" + blobSHA256(key)
+		files[path] = key
+		return path
+	}
+	if doc.Project.StoredLogo != nil {
+		doc.Project.Logo = addFile(doc.Project.StoredLogo.OriginalKey)
+	}
+	for i := range doc.Resources {
+		if doc.Resources[i].StorageKey != "" {
+			doc.Resources[i].File = addFile(doc.Resources[i].StorageKey)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	if err = writeExport(w, s.blobs, doc, files); err != nil {
+		// the response has started, all that is left is to cut it short
+		errorf("exporting project %s: %v", projectId, err)
+	}
+}
+
+// writeExport writes a zip export with the document and the files.
+func writeExport(w io.Writer, blobs BlobStore, doc ProjectExport, files map[string]string) error {
+	zw := zip.NewWriter(w)
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: EXPORT_DOCUMENT, Method: zip.Deflate, Modified: doc.ExportedAt})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(doc); err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Deflate, Modified: doc.ExportedAt})
+		if err != nil {
+			return err
+		}
+		blob, err := blobs.Get(files[path])
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// importProject creates a new project from an export. The body is the JSON
+// document, or a zip export as application/zip to import the files as well.
+func (s *Server) importProject(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var doc ProjectExport
+	var archive *zip.Reader
+	switch mediaType {
+	case "application/json":
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_DOCUMENT_SIZE)
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			respondBadImport(w, err, MAX_DOCUMENT_SIZE)
+			return
+		}
+	case "application/zip":
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_IMPORT_SIZE)
+		body, _, size, err := spool("", r.Body)
+		if err != nil {
+			respondBadImport(w, err, MAX_IMPORT_SIZE)
+			return
+		}
+		defer os.Remove(body.Name())
+		defer body.Close()
+		if archive, err = zip.NewReader(body, size); err != nil {
+			JSON(w, http.StatusBadRequest, Response{nil, "the request body is not a valid zip file"})
+			return
+		}
+		if err = readExportDocument(archive, &doc); err != nil {
+			respondBadImport(w, err, MAX_DOCUMENT_SIZE)
+			return
+		}
+	default:
+		JSON(w, http.StatusUnsupportedMediaType, Response{nil, "the export must be sent as application/json or application/zip"})
+		return
+	}
+
+	errs := doc.Validate()
+	if archive == nil {
+		// a plain document has no files to refer to
+		if doc.Project.Logo != "" {
+			errs = nestErrors(errs, "", FieldErrors{"project.logo": "can only be imported from a zip export"})
+		}
+		for i, res := range doc.Resources {
+			if res.File != "" {
+				errs = nestErrors(errs, "", FieldErrors{fmt.Sprintf("resources[%d].file", i): "can only be imported from a zip export"})
+			}
+		}
+	}
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+
+	// exports from before projects had a currency
+	if doc.Project.Currency == "" {
+		doc.Project.Currency = s.config.Currency.Default
+	}
+
+	var keys []string
+	unpin := func() {}
+	if archive != nil {
+		var ok bool
+		if keys, unpin, ok = s.importFiles(w, tenantOf(r), archive, &doc); !ok {
+			return
+		}
+	}
+
+	projectId, err := s.store.ImportProject(tenantOf(r), user.UserId, doc)
+	unpin()
+	if err != nil {
+		for _, key := range keys {
+			s.releaseBlob(tenantOf(r), key)
+		}
+		respondError(w, err)
+		return
+	}
+	project, err := s.store.GetProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{project, "success"})
+}
+
+// respondBadImport answers an import whose body could not be read.
+func respondBadImport(w http.ResponseWriter, err error, maxSize int64) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		JSON(w, http.StatusRequestEntityTooLarge, Response{nil, "the export must be at most " + formatBytes(maxSize)})
+		return
+	}
+	JSON(w, http.StatusBadRequest, Response{nil, "the export is not valid: " + err.Error()})
+}
+
+func readExportDocument(archive *zip.Reader, doc *ProjectExport) error {
+	f := zipEntry(archive, EXPORT_DOCUMENT)
+	if f == nil {
+		return errors.New("the zip file has no " + EXPORT_DOCUMENT)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(io.LimitReader(rc, MAX_DOCUMENT_SIZE)).Decode(doc)
+}
+
+func zipEntry(archive *zip.Reader, path string) *zip.File {
+	for _, f := range archive.File {
+		if f.Name == path {
+			return f
+		}
+	}
+	return nil
+}
+
+// importFiles stores the logo and the resource files of a zip export and
+// points the document at them. They go through the same checks as uploads.
+// It returns the keys of the stored blobs and the function that unpins them,
+// or writes the error response and returns false after deleting the blobs
+// stored so far.
+func (s *Server) importFiles(w http.ResponseWriter, t Tenant, archive *zip.Reader, doc *ProjectExport) ([]string, func(), bool) {
+	var keys []string
+	var unpins []func()
+	unpin := func() {
+		for _, fn := range unpins {
+			fn()
+		}
+	}
+	fail := func() ([]string, func(), bool) {
+		unpin()
+		for _, key := range keys {
+			s.releaseBlob(t, key)
+		}
+		return nil, nil, false
+	}
+
+	// the quota is checked for all files up front, a file used twice is
+	// stored once
+	var total int64
+	counted := make(map[string]bool)
+	for _, res := range doc.Resources {
+		if f := zipEntry(archive, res.File); f != nil && !counted[res.File] {
+			counted[res.File] = true
+			total += int64(f.UncompressedSize64)
+		}
+	}
+	ok, err := s.checkQuota(t, total)
+	if err != nil {
+		respondError(w, err)
+		return fail()
+	}
+	if !ok {
+		JSON(w, http.StatusRequestEntityTooLarge, Response{nil, "the organization's storage quota is used up"})
+		return fail()
+	}
+
+	if doc.Project.Logo != "" {
+		file, ok := s.openImportFile(w, archive, doc.Project.Logo, "project.logo")
+		if !ok {
+			return fail()
+		}
+		logo, unpinLogo, ok := s.importLogo(w, t, file)
+		file.Close()
+		os.Remove(file.Name())
+		if !ok {
+			return fail()
+		}
+		unpins = append(unpins, unpinLogo)
+		doc.Project.StoredLogo = logo
+		for _, variant := range logoVariants {
+			keys = append(keys, logo.key(variant))
+		}
+	}
+
+	for i := range doc.Resources {
+		res := &doc.Resources[i]
+		if res.File == "" {
+			continue
+		}
+		field := fmt.Sprintf("resources[%d].file", i)
+		file, ok := s.openImportFile(w, archive, res.File, field)
+		if !ok {
+			return fail()
+		}
+		size, _ := file.Seek(0, io.SeekEnd)
+		file.Seek(0, io.SeekStart)
+		stored, ok := s.storeUpload(w, t, file, sanitizeFilename(res.ResourceName), size, field)
+		file.Close()
+		os.Remove(file.Name())
+		if !ok {
+			return fail()
+		}
+		unpins = append(unpins, stored.unpin)
+		keys = append(keys, stored.Key)
+		if stored.ScanStatus == SCAN_QUARANTINED {
+			JSON(w, http.StatusUnprocessableEntity, Response{FieldErrors{field: "was quarantined by the virus scanner"}, "validation failed"})
+			return fail()
+		}
+		res.StorageKey = stored.Key
+		res.Size = stored.Size
+		res.SHA256 = stored.SHA256
+		res.ContentType = stored.ContentType
+		res.ScanStatus = stored.ScanStatus
+	}
+	return keys, unpin, true
+}
+
+// openImportFile copies a file of a zip export to a temporary file, which
+// the caller closes and removes. Files larger than an upload may be are
+// rejected while they are read, whatever the zip file claims their size is.
+func (s *Server) openImportFile(w http.ResponseWriter, archive *zip.Reader, path, field string) (*os.File, bool) {
+	entry := zipEntry(archive, path)
+	if entry == nil {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{field: "is not in the zip file"}, "validation failed"})
+		return nil, false
+	}
+	maxSize := s.config.Uploads.MaxFileSize
+	if entry.UncompressedSize64 > uint64(maxSize) {
+		JSON(w, http.StatusRequestEntityTooLarge, Response{FieldErrors{field: fileTooLarge(maxSize)}, "validation failed"})
+		return nil, false
+	}
+	rc, err := entry.Open()
+	if err != nil {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{field: err.Error()}, "validation failed"})
+		return nil, false
+	}
+	defer rc.Close()
+	file, _, size, err := spool("", io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{field: err.Error()}, "validation failed"})
+		return nil, false
+	}
+	if size > maxSize {
+		file.Close()
+		os.Remove(file.Name())
+		JSON(w, http.StatusRequestEntityTooLarge, Response{FieldErrors{field: fileTooLarge(maxSize)}, "validation failed"})
+		return nil, false
+	}
+	return file, true
+}
+
+// importLogo scans and processes the original logo of an export like an
+// uploaded logo. Its blobs are pinned until the returned function is called.
+func (s *Server) importLogo(w http.ResponseWriter, t Tenant, file *os.File) (*ProjectLogo, func(), bool) {
+	status, err := s.scanFile(file, "imported logo")
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return nil, nil, false
+	}
+	switch status {
+	case SCAN_QUARANTINED:
+		JSON(w, http.StatusUnprocessableEntity, Response{FieldErrors{"project.logo": "was rejected by the virus scanner"}, "validation failed"})
+		return nil, nil, false
+	case SCAN_PENDING:
+		JSON(w, http.StatusServiceUnavailable, Response{nil, "the virus scanner is unavailable"})
+		return nil, nil, false
+	}
+
+	processed, err := processLogo(file, "project.logo")
+	if err != nil {
+		respondError(w, err)
+		return nil, nil, false
+	}
+	logo, unpin, err := s.storeLogo(t, processed)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return nil, nil, false
+	}
+	return logo, unpin, true
+}
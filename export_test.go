@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// TestExportJournals exports a project with a submitted journal and a link
+// attached to it, and imports it again with new IDs.
+func TestExportJournals(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	partnerId, err := ts.store.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	markerId, err := ts.store.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: "listen", Type: MARKER_EXPECT})
+	check(t, err)
+	journalId, err := ts.store.AddJournal(a, ADMIN_A, OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId,
+		MonitoringDate: "2017-03-31", Lessons: "Ask earlier.",
+		Ratings: []JournalRating{{ProgressMarkerId: markerId, Rating: RATING_HIGH}}})
+	check(t, err)
+	check(t, ts.store.SubmitJournal(a, ADMIN_A, projectId, journalId))
+	code, out := ts.do(key, "POST", "/projects/"+projectId+"/resources/links", "application/json",
+		`{"resource_url":"https://example.org/minutes","title":"Minutes","journal_id":"`+journalId+`"}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+
+	code, out = ts.do(key, "GET", "/projects/"+projectId+"/export", "", "")
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	body, err := json.Marshal(out)
+	check(t, err)
+	var doc ProjectExport
+	check(t, json.Unmarshal(body, &doc))
+	if len(doc.Journals) != 1 || doc.Journals[0].Status != JOURNAL_SUBMITTED || doc.Journals[0].SubmittedAt == nil ||
+		len(doc.Journals[0].Ratings) != 1 || len(doc.Resources) != 1 || doc.Resources[0].JournalId != journalId {
+		t.Fatal(string(body))
+	}
+
+	code, out = ts.do(key, "POST", "/projects/import", "application/json", string(body))
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	imported := out["data"].(map[string]interface{})["project_id"].(string)
+	copied, err := ts.store.ExportProject(a, imported)
+	check(t, err)
+	if len(copied.Journals) != 1 || len(copied.Resources) != 1 {
+		t.Fatal(copied)
+	}
+	j, partner := copied.Journals[0], copied.BoundaryPartners[0]
+	if j.JournalId == journalId || j.BoundaryPartnerId != partner.BoundaryPartnerId || j.Status != JOURNAL_SUBMITTED ||
+		j.SubmittedAt == nil || j.Lessons != "Ask earlier." || len(j.Ratings) != 1 ||
+		j.Ratings[0].ProgressMarkerId != partner.ProgressMarkers[0].ProgressMarkerId || j.Ratings[0].Rating != RATING_HIGH {
+		t.Fatal(j)
+	}
+	if copied.Resources[0].JournalId != j.JournalId {
+		t.Fatal(copied.Resources[0])
+	}
+	journal, err := ts.store.GetJournal(a, imported, j.JournalId)
+	check(t, err)
+	if journal.SubmittedBy != "" {
+		t.Fatal(journal)
+	}
+}
+
+// TestExportJournalsValidate rejects journals that do not fit the rest of the
+// export.
+func TestExportJournalsValidate(t *testing.T) {
+	const (
+		bp = "b0a7e5a8-2f43-4c8e-9d3a-6c3a1f0e2b11"
+		pm = "c1d2e3f4-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+	)
+	journal := func() *ExportedJournal {
+		return &ExportedJournal{JournalId: "j", BoundaryPartnerId: bp, MonitoringDate: "2017-03-31", Status: JOURNAL_DRAFT,
+			Ratings: []JournalRating{{ProgressMarkerId: pm, Rating: RATING_LOW}}}
+	}
+	for _, test := range []struct {
+		change func(doc *ProjectExport)
+		field  string
+	}{
+		{func(doc *ProjectExport) { doc.Journals[0] = nil }, "journals[0]"},
+		{func(doc *ProjectExport) { doc.Journals[0].BoundaryPartnerId = pm }, "journals[0].boundary_partner_id"},
+		{func(doc *ProjectExport) { doc.Journals[0].Status = "done" }, "journals[0].status"},
+		{func(doc *ProjectExport) { doc.Journals[0].MonitoringDate = "31.03.2017" }, "journals[0].monitoring_date"},
+		{func(doc *ProjectExport) {
+			doc.Journals[0].Ratings[0].ProgressMarkerId = "d9c8b7a6-5f4e-4d3c-8b2a-1f0e9d8c7b6a"
+		}, "journals[0].ratings"},
+		{func(doc *ProjectExport) { doc.Journals[0].JournalId = bp }, "journals[0].journal_id"},
+		{func(doc *ProjectExport) {
+			second := journal()
+			second.JournalId = "j2"
+			doc.Journals = append(doc.Journals, second)
+		}, "journals[1].monitoring_date"},
+		{func(doc *ProjectExport) { doc.Resources[0].JournalId = bp }, "resources[0].journal_id"},
+	} {
+		doc := ProjectExport{
+			Format:  EXPORT_FORMAT,
+			Version: EXPORT_VERSION,
+			Project: ExportedProject{ProjectName: "Radio"},
+			BoundaryPartners: []*BoundaryPartner{{BoundaryPartnerId: bp, PartnerName: "Councils",
+				ProgressMarkers: []*ProgressMarker{{ProgressMarkerId: pm, Title: "listen", Type: MARKER_EXPECT}}}},
+			Journals:  []*ExportedJournal{journal()},
+			Resources: []ExportedResource{{ResourceId: "r", ResourceType: RESOURCE_LINK, ResourceUrl: "https://example.org", JournalId: "j"}},
+		}
+		if errs := doc.Validate(); errs != nil {
+			t.Fatal(errs)
+		}
+		test.change(&doc)
+		if errs := doc.Validate(); errs[test.field] == "" {
+			t.Errorf("%s: %v", test.field, errs)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// bounds of the images accepted as logos, in pixels
+const (
+	MIN_LOGO_SIZE = 16
+	MAX_LOGO_SIZE = 4096
+)
+
+// longest side of the generated logo variants, in pixels
+const (
+	LOGO_THUMBNAIL_SIZE = 128
+	LOGO_MEDIUM_SIZE    = 512
+)
+
+// quality of re-encoded JPEG logos
+const JPEG_QUALITY = 90
+
+// logo variants, in the order they are generated
+var logoVariants = []string{"original", "medium", "thumbnail"}
+
+// processedLogo is an uploaded logo with its metadata removed and its
+// variants rendered, ready to be stored.
+type processedLogo struct {
+	ContentType string
+	Width       int
+	Height      int
+	// Variants maps a name in logoVariants to the encoded image.
+	Variants map[string][]byte
+}
+
+// processLogo validates an uploaded logo and renders its variants. PNG, JPEG
+// and WebP images are decoded and encoded again, which drops EXIF and every
+// other kind of metadata; the EXIF orientation of a JPEG is applied first.
+// WebP logos are stored as PNG since there is no WebP encoder. SVG logos are
+// checked for active content and stored as they are, a vector image needs no
+// smaller variants. Problems with the image are returned as FieldErrors.
+func processLogo(r io.Reader, field string) (*processedLogo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	contentType, err := sniffContentType(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var decode func(io.Reader) (image.Image, error)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch contentType {
+	case "image/png":
+		decode, decodeConfig = png.Decode, png.DecodeConfig
+	case "image/jpeg":
+		decode, decodeConfig = jpeg.Decode, jpeg.DecodeConfig
+	case "image/webp":
+		decode, decodeConfig = webp.Decode, webp.DecodeConfig
+	default:
+		if isSVG(data) {
+			return processSVG(data, field)
+		}
+		return nil, FieldErrors{field: "must be a PNG, JPEG, WebP or SVG image"}
+	}
+
+	// check the dimensions before decoding, so a small file can not make
+	// the server allocate a huge image
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, FieldErrors{field: "is not a valid image"}
+	}
+	if errs := checkLogoSize(field, config.Width, config.Height); errs != nil {
+		return nil, errs
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, FieldErrors{field: "is not a valid image"}
+	}
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	encode := func(w io.Writer, img image.Image) error { return png.Encode(w, img) }
+	if contentType == "image/jpeg" {
+		encode = func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEG_QUALITY})
+		}
+	} else {
+		contentType = "image/png"
+	}
+
+	bounds := img.Bounds()
+	logo := &processedLogo{contentType, bounds.Dx(), bounds.Dy(), make(map[string][]byte)}
+	sizes := map[string]int{"original": 0, "medium": LOGO_MEDIUM_SIZE, "thumbnail": LOGO_THUMBNAIL_SIZE}
+	for _, variant := range logoVariants {
+		var buf bytes.Buffer
+		if err = encode(&buf, fit(img, sizes[variant])); err != nil {
+			return nil, err
+		}
+		logo.Variants[variant] = buf.Bytes()
+	}
+	return logo, nil
+}
+
+func checkLogoSize(field string, width, height int) FieldErrors {
+	if width < MIN_LOGO_SIZE || height < MIN_LOGO_SIZE || width > MAX_LOGO_SIZE || height > MAX_LOGO_SIZE {
+		return FieldErrors{field: "must be between " + strconv.Itoa(MIN_LOGO_SIZE) + " and " +
+			strconv.Itoa(MAX_LOGO_SIZE) + " pixels wide and high"}
+	}
+	return nil
+}
+
+// fit scales img down to fit in a square of the given size, keeping its
+// aspect ratio. Smaller images and a size of 0 return img unchanged.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if size == 0 || (b.Dx() <= size && b.Dy() <= size) {
+		return img
+	}
+	width, height := size, b.Dy()*size/b.Dx()
+	if b.Dy() > b.Dx() {
+		width, height = b.Dx()*size/b.Dy(), size
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// exifOrientation returns the orientation tag (1 to 8) of a JPEG file, or 1
+// if it has none.
+func exifOrientation(data []byte) int {
+	// walk the JPEG segments up to the start of the image data
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and mirrors img so it is shown upright once the
+// EXIF orientation tag is gone.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// the source pixel shown at x, y
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// isSVG reports whether data is an XML document with an svg root element.
+func isSVG(data []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "svg"
+		}
+	}
+}
+
+// elements that can run script or pull in other documents
+var svgForbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+}
+
+// processSVG accepts an SVG image without scripts, event handlers, entity
+// declarations or references to other documents, style sheets included. Its
+// size is taken from the width and height, or the viewBox, which have to be
+// given in plain numbers or pixels; each of them is held to the logo size
+// bounds.
+func processSVG(data []byte, field string) (*processedLogo, error) {
+	logo := &processedLogo{ContentType: "image/svg+xml", Variants: make(map[string][]byte)}
+	unsafe := FieldErrors{field: "must not contain scripts or external references"}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := true
+	inStyle := false
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, FieldErrors{field: "is not a valid image"}
+		}
+		switch t := token.(type) {
+		case xml.Directive:
+			return nil, unsafe
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				return nil, unsafe
+			}
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if svgForbiddenElements[name] {
+				return nil, unsafe
+			}
+			inStyle = name == "style"
+			for _, attr := range t.Attr {
+				name := strings.ToLower(attr.Name.Local)
+				value := strings.TrimSpace(attr.Value)
+				if strings.HasPrefix(name, "on") {
+					return nil, unsafe
+				}
+				if name == "href" && !strings.HasPrefix(value, "#") && !strings.HasPrefix(value, "data:image/") {
+					return nil, unsafe
+				}
+				if strings.Contains(strings.ToLower(value), "javascript:") {
+					return nil, unsafe
+				}
+				if name == "style" && unsafeCSS(value) {
+					return nil, unsafe
+				}
+			}
+			if root {
+				if logo.Width, logo.Height, err = svgSize(t, field); err != nil {
+					return nil, err
+				}
+				root = false
+			}
+		case xml.EndElement:
+			inStyle = false
+		case xml.CharData:
+			if inStyle && unsafeCSS(string(t)) {
+				return nil, unsafe
+			}
+		}
+	}
+	for _, variant := range logoVariants {
+		logo.Variants[variant] = data
+	}
+	return logo, nil
+}
+
+// unsafeCSS reports whether a style sheet loads anything, which includes
+// fonts and images.
+func unsafeCSS(css string) bool {
+	css = strings.ToLower(css)
+	return strings.Contains(css, "url(") || strings.Contains(css, "@import")
+}
+
+// svgSize returns the size of an SVG image from the attributes of its root
+// element: the width and height if both are given in pixels, the size of the
+// viewBox otherwise. Each of them that is given must be within the logo size
+// bounds.
+func svgSize(svg xml.StartElement, field string) (int, int, error) {
+	unusable := FieldErrors{field: "must have a width and height or a viewBox in pixels"}
+	var width, height, boxWidth, boxHeight int
+	for _, attr := range svg.Attr {
+		switch attr.Name.Local {
+		case "viewBox":
+			fields := strings.Fields(strings.Replace(attr.Value, ",", " ", -1))
+			ok := len(fields) == 4
+			if ok {
+				boxWidth, ok = svgLength(fields[2])
+			}
+			if ok {
+				boxHeight, ok = svgLength(fields[3])
+			}
+			if !ok {
+				return 0, 0, unusable
+			}
+		case "width":
+			// relative widths like 100% leave the size to the viewBox
+			width, _ = svgLength(attr.Value)
+		case "height":
+			height, _ = svgLength(attr.Value)
+		}
+	}
+	for _, n := range []int{width, height, boxWidth, boxHeight} {
+		if n != 0 && (n < MIN_LOGO_SIZE || n > MAX_LOGO_SIZE) {
+			return 0, 0, checkLogoSize(field, n, n)
+		}
+	}
+	switch {
+	case width != 0 && height != 0:
+		return width, height, nil
+	case boxWidth != 0:
+		return boxWidth, boxHeight, nil
+	}
+	return 0, 0, unusable
+}
+
+// svgLength parses a positive length in user units or pixels. Fractions are
+// rounded up, lengths beyond any logo size are cut to just above it.
+func svgLength(value string) (int, bool) {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+	if err != nil || !(n > 0) {
+		return 0, false
+	}
+	if n > MAX_LOGO_SIZE {
+		return MAX_LOGO_SIZE + 1, true
+	}
+	return int(math.Ceil(n)), true
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestProcessSVG(t *testing.T) {
+	const ns = `xmlns="http://www.w3.org/2000/svg"`
+	tests := []struct {
+		svg           string
+		width, height int
+		err           string
+	}{
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><rect width="10" height="10"/></svg>`, 100, 50, ""},
+		{`<svg ` + ns + ` width="64px" height="32" viewBox="0,0,640,320"/>`, 64, 32, ""},
+		{`<svg ` + ns + ` width="100%" height="100%" viewBox="0 0 20.5 20"/>`, 21, 20, ""},
+		{`<svg ` + ns + ` viewBox="0 0 100000 100000"/>`, 0, 0, "must be between 16 and 4096 pixels wide and high"},
+		{`<svg ` + ns + ` width="64" height="64" viewBox="0 0 1e9 1e9"/>`, 0, 0, "must be between 16 and 4096 pixels wide and high"},
+		{`<svg ` + ns + ` width="5000" height="64"/>`, 0, 0, "must be between 16 and 4096 pixels wide and high"},
+		{`<svg ` + ns + ` viewBox="0 0 8 8"/>`, 0, 0, "must be between 16 and 4096 pixels wide and high"},
+		{`<svg ` + ns + `><rect width="10" height="10"/></svg>`, 0, 0, "must have a width and height or a viewBox in pixels"},
+		{`<svg ` + ns + ` width="100%" height="100%"/>`, 0, 0, "must have a width and height or a viewBox in pixels"},
+		{`<svg ` + ns + ` width="64"/>`, 0, 0, "must have a width and height or a viewBox in pixels"},
+		{`<svg ` + ns + ` viewBox="0 0 100"/>`, 0, 0, "must have a width and height or a viewBox in pixels"},
+		{`<svg ` + ns + ` viewBox="0 0 -100 100"/>`, 0, 0, "must have a width and height or a viewBox in pixels"},
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><style>rect { fill: red }</style></svg>`, 100, 50, ""},
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><style>@import "https://example.org/x.css";</style></svg>`, 0, 0, "must not contain scripts or external references"},
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><style><![CDATA[rect { fill: URL(https://example.org/x.svg#p) }]]></style></svg>`, 0, 0, "must not contain scripts or external references"},
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><rect style="background: url(https://example.org/t.png)"/></svg>`, 0, 0, "must not contain scripts or external references"},
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><script>alert(1)</script></svg>`, 0, 0, "must not contain scripts or external references"},
+		{`<svg ` + ns + ` viewBox="0 0 100 50"><rect onload="x()"/></svg>`, 0, 0, "must not contain scripts or external references"},
+	}
+	for _, test := range tests {
+		logo, err := processSVG([]byte(test.svg), "logo")
+		if test.err != "" {
+			if errs, ok := err.(FieldErrors); !ok || errs["logo"] != test.err {
+				t.Errorf("%s: %v", test.svg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.svg, err)
+			continue
+		}
+		if logo.Width != test.width || logo.Height != test.height {
+			t.Errorf("%s: %dx%d", test.svg, logo.Width, logo.Height)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// advisory lock space of the background jobs, each job is locked with the
+// hash of its name as the second key
+const JOB_LOCK_ID = 7321401
+
+// attempts made at a job run before it is given up
+const JOB_MAX_ATTEMPTS = 5
+
+// delay before the first retry of a failed run, doubled for every attempt
+// after that up to JOB_MAX_RETRY_DELAY
+const (
+	JOB_RETRY_DELAY     = time.Minute
+	JOB_MAX_RETRY_DELAY = time.Hour
+)
+
+// runs still running after this long are taken to be abandoned by an
+// instance that went away
+const JOB_TIMEOUT = time.Hour
+
+// jobSpec is a background job. Local jobs work on the state of the instance
+// itself, like its sessions, so every instance runs them and their runs are
+// not stored.
+type jobSpec struct {
+	name string
+	// next returns when the job runs next after now
+	next  func(now time.Time) time.Time
+	run   func(now time.Time) error
+	local bool
+}
+
+// every runs a job at every multiple of d.
+func every(d time.Duration) func(time.Time) time.Time {
+	return func(now time.Time) time.Time {
+		return now.Truncate(d).Add(d)
+	}
+}
+
+// daily runs a job every day at the time of day at, in UTC.
+func daily(at string) func(time.Time) time.Time {
+	return func(now time.Time) time.Time {
+		return nextTimeOfDay(now, at)
+	}
+}
+
+// JobRunner runs background jobs in the server process. The runs of jobs are
+// stored, so all instances of the server share them, and an instance only
+// starts a run while it holds the job's lock.
+type JobRunner struct {
+	store    Store
+	jobs     []jobSpec
+	interval time.Duration
+	// when each local job runs next
+	localRuns map[string]time.Time
+}
+
+func NewJobRunner(store Store, jobs []jobSpec, interval time.Duration) *JobRunner {
+	return &JobRunner{store, jobs, interval, make(map[string]time.Time)}
+}
+
+// Run looks for due jobs every interval and runs them, forever.
+func (r *JobRunner) Run() {
+	for {
+		if err := r.RunDue(time.Now()); err != nil {
+			errorf("jobs: %v", err)
+		}
+		time.Sleep(r.interval)
+	}
+}
+
+// RunDue schedules the jobs that have no run yet and runs those due at now.
+func (r *JobRunner) RunDue(now time.Time) error {
+	for _, job := range r.jobs {
+		if job.local {
+			r.runLocal(job, now)
+		} else if err := r.store.ScheduleJob(job.name, job.next(now), JOB_MAX_ATTEMPTS); err != nil {
+			return err
+		}
+	}
+	if err := r.store.RequeueStaleJobs(now.Add(-JOB_TIMEOUT)); err != nil {
+		return err
+	}
+	due, err := r.store.GetDueJobs(now)
+	if err != nil {
+		return err
+	}
+	for _, run := range due {
+		job, ok := r.job(run.Name)
+		if !ok {
+			// left behind by a version of the server that had this job
+			continue
+		}
+		r.runJob(job, run, now)
+	}
+	return nil
+}
+
+func (r *JobRunner) job(name string) (jobSpec, bool) {
+	for _, job := range r.jobs {
+		if job.name == name && !job.local {
+			return job, true
+		}
+	}
+	return jobSpec{}, false
+}
+
+// runLocal runs a local job if it is due, without retries.
+func (r *JobRunner) runLocal(job jobSpec, now time.Time) {
+	next, ok := r.localRuns[job.name]
+	if !ok {
+		r.localRuns[job.name] = job.next(now)
+		return
+	}
+	if now.Before(next) {
+		return
+	}
+	r.localRuns[job.name] = job.next(now)
+	if err := call(job, now); err != nil {
+		warnf("job %s: %v", job.name, err)
+	}
+}
+
+// runJob runs a stored run of a job unless another instance holds the job's
+// lock or got to the run first, and schedules the next run.
+func (r *JobRunner) runJob(job jobSpec, run Job, now time.Time) {
+	unlock, ok, err := r.store.LockJob(job.name)
+	if err != nil {
+		errorf("job %s: %v", job.name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+	run, err = r.store.StartJob(run.JobId)
+	if err == ErrNotFound {
+		return
+	}
+	if err != nil {
+		errorf("job %s: %v", job.name, err)
+		return
+	}
+	debugf("job %s: attempt %d", job.name, run.Attempts)
+	runErr := call(job, now)
+	if runErr != nil {
+		warnf("job %s: attempt %d of %d failed: %v", job.name, run.Attempts, run.MaxAttempts, runErr)
+	}
+	retryAt := time.Now().Add(backoff(run.Attempts, JOB_RETRY_DELAY, JOB_MAX_RETRY_DELAY))
+	if err = r.store.FinishJob(run.JobId, runErr, retryAt); err != nil {
+		errorf("job %s: %v", job.name, err)
+		return
+	}
+	if err = r.store.ScheduleJob(job.name, job.next(now), JOB_MAX_ATTEMPTS); err != nil {
+		errorf("job %s: %v", job.name, err)
+	}
+}
+
+// call runs a job, turning a panic into an error so that it is retried like
+// any other failure.
+func call(job jobSpec, now time.Time) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return job.run(now)
+}
+
+// backoff returns how long to wait after the given number of failed
+// attempts: delay after the first, doubled for every one after that, but
+// never longer than max.
+func backoff(attempts int, delay, max time.Duration) time.Duration {
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
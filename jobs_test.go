@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		6:  32 * time.Minute,
+		7:  time.Hour,
+		40: time.Hour,
+	} {
+		if got := backoff(attempts, JOB_RETRY_DELAY, JOB_MAX_RETRY_DELAY); got != want {
+			t.Errorf("attempt %d: %v", attempts, got)
+		}
+	}
+}
+
+// TestJobRunner runs jobs that succeed, fail for a while, fail for good and
+// panic, and checks when they are run and retried.
+func TestJobRunner(t *testing.T) {
+	store := newTestMemoryStore(t)
+	runs := make(map[string]int)
+	job := func(name string, fail func(run int) error) jobSpec {
+		return jobSpec{
+			name: name,
+			next: func(now time.Time) time.Time { return now.Add(time.Minute) },
+			run: func(now time.Time) error {
+				runs[name]++
+				return fail(runs[name])
+			},
+		}
+	}
+	jobs := []jobSpec{
+		job("ok", func(int) error { return nil }),
+		job("flaky", func(run int) error {
+			if run <= 2 {
+				return errors.New("not yet")
+			}
+			return nil
+		}),
+		job("broken", func(int) error { return errors.New("broken") }),
+		job("panics", func(int) error { panic("oops") }),
+		job("local", func(int) error { return nil }),
+	}
+	jobs[4].local = true
+	runner := NewJobRunner(store, jobs, time.Minute)
+
+	// retries are due a delay after the wall clock time of the failure, so
+	// the runner is moved on by more than the longest delay to reach them
+	now := time.Now()
+	later := func(d time.Duration) {
+		t.Helper()
+		now = now.Add(d)
+		check(t, runner.RunDue(now))
+	}
+	later(0)
+	if len(runs) != 0 {
+		t.Fatal("ran before the first run was due", runs)
+	}
+
+	// a job whose lock is held elsewhere is left alone
+	unlock, ok, err := store.LockJob("ok")
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	later(time.Minute)
+	if runs["ok"] != 0 || runs["flaky"] != 1 || runs["broken"] != 1 || runs["panics"] != 1 || runs["local"] != 1 {
+		t.Fatal(runs)
+	}
+	unlock()
+	later(time.Minute)
+	if runs["ok"] != 1 {
+		t.Fatal("not run after the lock was released", runs)
+	}
+
+	for i := 0; i < JOB_MAX_ATTEMPTS+2; i++ {
+		later(2 * JOB_MAX_RETRY_DELAY)
+	}
+	// the flaky job succeeded on its third attempt and then ran on schedule,
+	// the others gave up after their last attempt
+	if runs["flaky"] < 3 || runs["broken"] < JOB_MAX_ATTEMPTS || runs["panics"] < JOB_MAX_ATTEMPTS {
+		t.Fatal(runs)
+	}
+	failed := make(map[string]int)
+	for _, j := range store.(*memStore).jobs {
+		if j.Status == JOB_FAILED {
+			failed[j.Name]++
+			if j.Attempts != JOB_MAX_ATTEMPTS {
+				t.Errorf("%s failed after %d attempts", j.Name, j.Attempts)
+			}
+			if j.Name == "panics" && j.LastError != "panic: oops" {
+				t.Errorf("%s: %q", j.Name, j.LastError)
+			}
+		}
+	}
+	if failed["ok"] != 0 || failed["flaky"] != 0 || failed["broken"] == 0 || failed["panics"] == 0 {
+		t.Fatal(failed)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// readJournal decodes and validates an outcome journal of the project in the
+// URL. An update passes the boundary partner of the journal, which does not
+// change. It responds itself if that fails.
+func readJournal(w http.ResponseWriter, r *http.Request, partnerId string) (OutcomeJournal, bool) {
+	var input OutcomeJournal
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return OutcomeJournal{}, false
+	}
+	input.ProjectId = mux.Vars(r)["projectId"]
+	if partnerId != "" {
+		input.BoundaryPartnerId = partnerId
+	}
+	errs := input.Validate()
+	if errs == nil && !isUUID(input.BoundaryPartnerId) {
+		errs = FieldErrors{"boundary_partner_id": "not found"}
+	}
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return OutcomeJournal{}, false
+	}
+	return input, true
+}
+
+// getJournals lists the outcome journals of a project, optionally only those
+// of ?boundary_partner_id=.
+func (s *Server) getJournals(w http.ResponseWriter, r *http.Request) {
+	partnerId := r.URL.Query().Get("boundary_partner_id")
+	if partnerId != "" && !isUUID(partnerId) {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"boundary_partner_id": "must be a UUID"}, "validation failed"})
+		return
+	}
+	journals, err := s.store.GetJournals(tenantOf(r), mux.Vars(r)["projectId"], partnerId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{journals, "success"})
+}
+
+func (s *Server) getJournal(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	journal, err := s.store.GetJournal(tenantOf(r), vars["projectId"], vars["journalId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{journal, "success"})
+}
+
+func (s *Server) addJournal(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := readJournal(w, r, "")
+	if !ok {
+		return
+	}
+	journalId, err := s.store.AddJournal(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.respondJournal(w, r, input.ProjectId, journalId)
+}
+
+func (s *Server) updateJournal(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	previous, err := s.store.GetJournal(tenantOf(r), vars["projectId"], vars["journalId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	input, ok := readJournal(w, r, previous.BoundaryPartnerId)
+	if !ok {
+		return
+	}
+	err = s.store.UpdateJournal(tenantOf(r), input.ProjectId, previous.JournalId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.respondJournal(w, r, input.ProjectId, previous.JournalId)
+}
+
+// submitJournal closes a draft journal. A submitted journal can no longer be
+// changed.
+func (s *Server) submitJournal(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.SubmitJournal(tenantOf(r), user.UserId, vars["projectId"], vars["journalId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	journal, err := s.store.GetJournal(tenantOf(r), vars["projectId"], vars["journalId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.publish(r, EVENT_JOURNAL_SUBMITTED, map[string]interface{}{"journal": journal})
+
+	JSON(w, http.StatusOK, Response{journal, "success"})
+}
+
+func (s *Server) deleteJournal(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.DeleteJournal(tenantOf(r), vars["projectId"], vars["journalId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+// respondJournal answers with a journal as it was stored.
+func (s *Server) respondJournal(w http.ResponseWriter, r *http.Request, projectId, journalId string) {
+	journal, err := s.store.GetJournal(tenantOf(r), projectId, journalId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{journal, "success"})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestJournalRoutes records an outcome journal through the API, attaches
+// evidence to it and submits it, after which it can not be changed.
+func TestJournalRoutes(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	partnerId, err := ts.store.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	markerId, err := ts.store.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: "listen", Type: MARKER_EXPECT})
+	check(t, err)
+	journals := "/projects/" + projectId + "/journals"
+
+	for _, test := range []struct {
+		body  string
+		field string
+	}{
+		{`{"monitoring_date":"2017-03-31"}`, "boundary_partner_id"},
+		{`{"boundary_partner_id":"` + partnerId + `","monitoring_date":"31.03.2017"}`, "monitoring_date"},
+		{`{"boundary_partner_id":"` + partnerId + `","monitoring_date":"2017-03-31","ratings":[{"progress_marker_id":"` + markerId + `","rating":"done"}]}`, "ratings"},
+		{`{"boundary_partner_id":"` + projectId + `","monitoring_date":"2017-03-31"}`, "boundary_partner_id"},
+		{`{"boundary_partner_id":"nope","monitoring_date":"2017-03-31"}`, "boundary_partner_id"},
+	} {
+		code, out := ts.do(key, "POST", journals, "application/json", test.body)
+		if data, _ := out["data"].(map[string]interface{}); code != http.StatusBadRequest || data[test.field] == nil {
+			t.Errorf("%s: %d %v", test.body, code, out)
+		}
+	}
+
+	code, out := ts.do(key, "POST", journals, "application/json", `{"boundary_partner_id":"`+partnerId+`","monitoring_date":"2017-03-31",
+		"description_of_change":"The council listens.","ratings":[{"progress_marker_id":"`+markerId+`","rating":"medium"}]}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	journal := out["data"].(map[string]interface{})
+	journalId := journal["journal_id"].(string)
+	if journal["status"] != JOURNAL_DRAFT || journal["submitted_at"] != nil || len(journal["ratings"].([]interface{})) != 1 {
+		t.Fatal(journal)
+	}
+	if code, out = ts.do(ts.login("bo@b.org"), "GET", journals+"/"+journalId, "", ""); code != http.StatusNotFound {
+		t.Fatal(code, out)
+	}
+
+	// the partner in an update is ignored
+	code, out = ts.do(key, "POST", journals+"/"+journalId, "application/json", `{"boundary_partner_id":"`+projectId+`",
+		"monitoring_date":"2017-04-01","lessons":"Ask earlier.","ratings":[{"progress_marker_id":"`+markerId+`","rating":"high"}]}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	journal = out["data"].(map[string]interface{})
+	rating := journal["ratings"].([]interface{})[0].(map[string]interface{})
+	if journal["boundary_partner_id"] != partnerId || journal["monitoring_date"] != "2017-04-01" || rating["rating"] != RATING_HIGH {
+		t.Fatal(journal)
+	}
+
+	code, out = ts.do(key, "POST", "/projects/"+projectId+"/resources/links", "application/json",
+		`{"resource_url":"https://example.org/minutes","title":"Minutes","journal_id":"`+journalId+`"}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(key, "GET", "/projects/"+projectId+"/resource?journal_id="+journalId, "", "")
+	if resources, _ := out["data"].([]interface{}); code != http.StatusOK || len(resources) != 1 {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(key, "POST", "/projects/"+projectId+"/resources/links", "application/json",
+		`{"resource_url":"https://example.org/minutes","journal_id":"`+markerId+`"}`)
+	if data, _ := out["data"].(map[string]interface{}); code != http.StatusBadRequest || data["journal_id"] == nil {
+		t.Fatal(code, out)
+	}
+
+	code, out = ts.do(key, "POST", journals+"/"+journalId+"/submit", "", "")
+	if journal, _ = out["data"].(map[string]interface{}); code != http.StatusOK || journal["status"] != JOURNAL_SUBMITTED ||
+		journal["submitted_by"] != ADMIN_A || journal["submitted_at"] == nil {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(key, "POST", journals+"/"+journalId, "application/json", `{"monitoring_date":"2017-04-02"}`)
+	if data, _ := out["data"].(map[string]interface{}); code != http.StatusBadRequest || data["status"] == nil {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(key, "POST", journals+"/"+journalId+"/submit", "", ""); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+
+	code, out = ts.do(key, "GET", journals+"?boundary_partner_id="+partnerId, "", "")
+	if list, _ := out["data"].([]interface{}); code != http.StatusOK || len(list) != 1 {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(key, "DELETE", journals+"/"+journalId, "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(key, "GET", journals+"/"+journalId, "", ""); code != http.StatusNotFound {
+		t.Fatal(code, out)
+	}
+}
+
+// TestOverdueJournals flags the drafts left unsubmitted after their
+// monitoring date to whoever started them, once.
+func TestOverdueJournals(t *testing.T) {
+	ts := newTestServer(t)
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	partnerId, err := ts.store.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	add := func(userId, date string) string {
+		t.Helper()
+		journalId, err := ts.store.AddJournal(a, userId, OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId,
+			MonitoringDate: date})
+		check(t, err)
+		return journalId
+	}
+	overdueId := add(MEMBER_A, "2017-03-31")
+	add(MEMBER_A, "2017-06-30")
+	check(t, ts.store.SubmitJournal(a, ADMIN_A, projectId, add(ADMIN_A, "2016-12-31")))
+
+	now := time.Date(2017, 5, 2, 8, 0, 0, 0, time.UTC)
+	check(t, ts.flagOverdueJournals(now))
+	check(t, ts.flagOverdueJournals(now.Add(time.Hour)))
+	notifications, err := ts.store.GetNotifications(a, MEMBER_A, false, 10)
+	check(t, err)
+	if len(notifications) != 1 || notifications[0].Kind != NOTIFY_JOURNAL_OVERDUE || notifications[0].ProjectId != projectId ||
+		notifications[0].Link != "/projects/"+projectId+"/journals/"+overdueId {
+		t.Fatalf("%+v", notifications)
+	}
+	if notifications, err = ts.store.GetNotifications(a, ADMIN_A, false, 10); err != nil || len(notifications) != 0 {
+		t.Fatal("an admin was told about a journal they did not start", notifications, err)
+	}
+}
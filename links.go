@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// only the start of a page is read when looking for its title
+const MAX_PREVIEW_BYTES = 512 * 1024
+
+var blockedAddress = errors.New("address is not publicly routable")
+
+var (
+	titlePattern   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	linkTagPattern = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	relPattern     = regexp.MustCompile(`(?is)\srel\s*=\s*["']?([^"'>]*)`)
+	hrefPattern    = regexp.MustCompile(`(?is)\shref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// LinkPreview is the metadata shown for an external link.
+type LinkPreview struct {
+	Title      string
+	FaviconUrl string
+}
+
+// LinkPreviewer fetches the metadata of a web page.
+type LinkPreviewer interface {
+	Preview(pageUrl string) (LinkPreview, error)
+}
+
+// noLinkPreviews is used when fetching pages is disabled.
+type noLinkPreviews struct{}
+
+func (noLinkPreviews) Preview(pageUrl string) (LinkPreview, error) {
+	return LinkPreview{}, nil
+}
+
+// httpLinkPreviewer fetches pages from the internet. It refuses to connect to
+// loopback, private and link-local addresses, so links can not be used to
+// probe the network the server runs in.
+type httpLinkPreviewer struct {
+	client *http.Client
+}
+
+func NewHTTPLinkPreviewer(timeout time.Duration) *httpLinkPreviewer {
+	dialer := &net.Dialer{Timeout: timeout, Control: publicAddressesOnly}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	return &httpLinkPreviewer{client}
+}
+
+func newLinkPreviewer(config LinkPreviewConfig) LinkPreviewer {
+	if !config.Enabled {
+		return noLinkPreviews{}
+	}
+	return NewHTTPLinkPreviewer(time.Duration(config.Timeout))
+}
+
+// publicAddressesOnly is a net.Dialer Control function. It runs after name
+// resolution, so a host name that resolves to an internal address is refused
+// as well.
+func publicAddressesOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return blockedAddress
+	}
+	return nil
+}
+
+func (p *httpLinkPreviewer) Preview(pageUrl string) (LinkPreview, error) {
+	req, err := http.NewRequest("GET", pageUrl, nil)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return LinkPreview{}, errors.New("fetching " + pageUrl + ": " + resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return LinkPreview{}, nil
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_PREVIEW_BYTES))
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	// resolve relative links against the final URL after redirects
+	return parsePreview(resp.Request.URL, string(page)), nil
+}
+
+// parsePreview extracts the title and favicon of an HTML page. A page
+// without an icon link gets the conventional /favicon.ico.
+func parsePreview(base *url.URL, page string) LinkPreview {
+	var preview LinkPreview
+	if match := titlePattern.FindStringSubmatch(page); match != nil {
+		title := strings.Join(strings.Fields(html.UnescapeString(match[1])), " ")
+		if len([]rune(title)) > MAX_NAME_LENGTH {
+			title = string([]rune(title)[:MAX_NAME_LENGTH])
+		}
+		preview.Title = title
+	}
+
+	favicon := &url.URL{Path: "/favicon.ico"}
+	for _, tag := range linkTagPattern.FindAllString(page, -1) {
+		rel := relPattern.FindStringSubmatch(tag)
+		if rel == nil || !strings.Contains(strings.ToLower(rel[1]), "icon") {
+			continue
+		}
+		href := hrefPattern.FindStringSubmatch(tag)
+		if href == nil {
+			continue
+		}
+		if u, err := url.Parse(html.UnescapeString(href[1] + href[2] + href[3])); err == nil {
+			favicon = u
+			break
+		}
+	}
+	resolved := base.ResolveReference(favicon)
+	if (resolved.Scheme == "http" || resolved.Scheme == "https") && len(resolved.String()) <= MAX_URL_LENGTH {
+		preview.FaviconUrl = resolved.String()
+	}
+	return preview
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopbackPreviewer is an httpLinkPreviewer that may connect to the test
+// server on the loopback interface.
+func loopbackPreviewer(timeout time.Duration) *httpLinkPreviewer {
+	p := NewHTTPLinkPreviewer(timeout)
+	p.client.Transport.(*http.Transport).DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	return p
+}
+
+// testPages serves the pages the link preview tests fetch.
+func testPages(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>
+			Annual &amp; financial   report</title>
+			<link rel="stylesheet" href="/style.css">
+			<link href='/static/icon.png' rel="shortcut icon"></head></html>`))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Plain</title>`))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/page", http.StatusFound)
+	})
+	mux.HandleFunc("/docs/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Docs</title><link rel=icon href=icon.svg>`))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(strings.Repeat(" ", MAX_PREVIEW_BYTES) + `<title>Too far</title>`))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Slow</title>`))
+	})
+	mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte(`%PDF-1.4 <title>Not a page</title>`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLinkPreview(t *testing.T) {
+	srv := testPages(t)
+	p := loopbackPreviewer(200 * time.Millisecond)
+
+	tests := []struct {
+		path, title, favicon string
+	}{
+		{"/page", "Annual & financial report", "/static/icon.png"},
+		{"/plain", "Plain", "/favicon.ico"},
+		{"/moved", "Docs", "/docs/icon.svg"},
+		{"/large", "", "/favicon.ico"},
+		{"/report.pdf", "", ""},
+	}
+	for _, test := range tests {
+		preview, err := p.Preview(srv.URL + test.path)
+		check(t, err)
+		favicon := test.favicon
+		if favicon != "" {
+			favicon = srv.URL + favicon
+		}
+		if preview.Title != test.title || preview.FaviconUrl != favicon {
+			t.Errorf("%s: %+v", test.path, preview)
+		}
+	}
+
+	for _, path := range []string{"/slow", "/missing"} {
+		if preview, err := p.Preview(srv.URL + path); err == nil {
+			t.Errorf("%s: %+v", path, preview)
+		}
+	}
+
+	// the server itself refuses to fetch from the loopback interface
+	if _, err := NewHTTPLinkPreviewer(time.Second).Preview(srv.URL + "/page"); !errors.Is(err, blockedAddress) {
+		t.Fatal(err)
+	}
+}
+
+// TestAddResourceLink adds links with and without a page that can be
+// previewed; a failed fetch still adds the link.
+func TestAddResourceLink(t *testing.T) {
+	srv := testPages(t)
+	ts := newTestServer(t)
+	ts.previews = loopbackPreviewer(200 * time.Millisecond)
+	key := ts.login("ada@a.org")
+	projectId, err := ts.store.AddProject(Tenant{ORG_A}, Project{ProjectName: "A"})
+	check(t, err)
+
+	add := func(pageUrl string) map[string]interface{} {
+		t.Helper()
+		code, out := ts.do(key, "POST", "/projects/"+projectId+"/resources/links", "application/json", `{"resource_url":"`+pageUrl+`"}`)
+		if code != 200 {
+			t.Fatal(code, out)
+		}
+		return out["data"].(map[string]interface{})
+	}
+	link := add(srv.URL + "/page")
+	if link["title"] != "Annual & financial report" || link["resource_name"] != "Annual & financial report" ||
+		link["favicon_url"] != srv.URL+"/static/icon.png" {
+		t.Fatal(link)
+	}
+	link = add(srv.URL + "/missing")
+	if link["title"] != "" || link["resource_name"] != srv.URL+"/missing" || link["favicon_url"] != "" {
+		t.Fatal(link)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "log"
+
+const (
+	LOG_DEBUG = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+)
+
+var logLevels = map[string]int{
+	"debug": LOG_DEBUG,
+	"info":  LOG_INFO,
+	"warn":  LOG_WARN,
+	"error": LOG_ERROR,
+}
+
+// logLevel is the minimum level written to the log, set from the config at startup.
+var logLevel = LOG_INFO
+
+func setLogLevel(name string) {
+	logLevel = logLevels[name]
+}
+
+func logAt(level int, prefix, format string, v ...interface{}) {
+	if level < logLevel {
+		return
+	}
+	log.Printf(prefix+format, v...)
+}
+
+func debugf(format string, v ...interface{}) { logAt(LOG_DEBUG, "DEBUG ", format, v...) }
+func infof(format string, v ...interface{})  { logAt(LOG_INFO, "INFO ", format, v...) }
+func warnf(format string, v ...interface{})  { logAt(LOG_WARN, "WARN ", format, v...) }
+func errorf(format string, v ...interface{}) { logAt(LOG_ERROR, "ERROR ", format, v...) }
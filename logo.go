@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// receiveLogo scans, processes and stores an uploaded logo. Its blobs are
+// pinned until unpin is called. It writes the error response and returns
+// false if the logo is not accepted.
+func (s *Server) receiveLogo(w http.ResponseWriter, r *http.Request, field, name string) (*ProjectLogo, func(), bool) {
+	file, _, ok := s.receiveFile(w, r, field)
+	if !ok {
+		return nil, nil, false
+	}
+	defer file.Close()
+
+	// logos are shown right away, so unlike resources they are never kept
+	// for a later scan
+	status, err := s.scanFile(file, name)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return nil, nil, false
+	}
+	switch status {
+	case SCAN_QUARANTINED:
+		JSON(w, http.StatusUnprocessableEntity, Response{nil, "the file was rejected by the virus scanner"})
+		return nil, nil, false
+	case SCAN_PENDING:
+		JSON(w, http.StatusServiceUnavailable, Response{nil, "the virus scanner is unavailable"})
+		return nil, nil, false
+	}
+
+	processed, err := processLogo(file, field)
+	if err != nil {
+		respondError(w, err)
+		return nil, nil, false
+	}
+	logo, unpin, err := s.storeLogo(tenantOf(r), processed)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return nil, nil, false
+	}
+	return logo, unpin, true
+}
+
+// storeLogo stores the variants of a processed logo. Variants that are
+// identical, like the sizes of an SVG logo, share a blob. The blobs are
+// pinned until unpin is called, like those of putPinned.
+func (s *Server) storeLogo(t Tenant, processed *processedLogo) (*ProjectLogo, func(), error) {
+	logo := &ProjectLogo{ContentType: processed.ContentType, Width: processed.Width, Height: processed.Height}
+	keys := map[string]*string{"original": &logo.OriginalKey, "medium": &logo.MediumKey, "thumbnail": &logo.ThumbnailKey}
+	var unpins []func()
+	unpin := func() {
+		for _, fn := range unpins {
+			fn()
+		}
+	}
+	for _, variant := range logoVariants {
+		blob, unpinVariant, err := s.putPinned(t, bytes.NewReader(processed.Variants[variant]))
+		if err != nil {
+			unpin()
+			s.releaseLogo(t, logo)
+			return nil, nil, err
+		}
+		unpins = append(unpins, unpinVariant)
+		*keys[variant] = blob.Key
+	}
+	return logo, unpin, nil
+}
+
+// releaseLogo deletes the blobs of a logo that are no longer referenced.
+func (s *Server) releaseLogo(t Tenant, logo *ProjectLogo) {
+	if logo == nil {
+		return
+	}
+	for _, variant := range logoVariants {
+		s.releaseBlob(t, logo.key(variant))
+	}
+}
+
+func (s *Server) getProjectLogo(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+	project, err := s.store.GetProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if project.Logo == nil {
+		JSON(w, http.StatusNotFound, Response{nil, "project has no logo"})
+		return
+	}
+	s.serveLogo(w, r, project.Logo)
+}
+
+// serveLogo sends the variant of a logo named in the URL.
+func (s *Server) serveLogo(w http.ResponseWriter, r *http.Request, logo *ProjectLogo) {
+	key := logo.key(mux.Vars(r)["variant"])
+	if key == "" {
+		JSON(w, http.StatusNotFound, Response{nil, "unknown logo variant"})
+		return
+	}
+	blob, err := s.blobs.Get(key)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	defer blob.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", logo.ContentType)
+	h.Set("ETag", `"`+blobSHA256(key)+`"`)
+	// the logo URLs carry a version, a changed logo gets a new URL
+	h.Set("Cache-Control", "private, max-age=86400")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	http.ServeContent(w, r, "", time.Time{}, blob)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Mail is a plain text message to a single recipient.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends mail.
+type Mailer interface {
+	Send(m Mail) error
+}
+
+// logMailer only logs mail, for servers without an SMTP server.
+type logMailer struct{}
+
+func (logMailer) Send(m Mail) error {
+	debugf("mail to %s: %s", m.To, m.Subject)
+	return nil
+}
+
+// smtpMailer delivers mail to an SMTP server, using STARTTLS when the
+// server offers it.
+type smtpMailer struct {
+	config SMTPConfig
+}
+
+func newMailer(config SMTPConfig) Mailer {
+	if config.Host != "" {
+		return &smtpMailer{config}
+	}
+	return logMailer{}
+}
+
+func (m *smtpMailer) Send(msg Mail) error {
+	from, err := mail.ParseAddress(m.config.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	data, err := formatMail(from, to, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(m.config.Timeout)
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port)), timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return err
+		}
+	}
+	if m.config.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// formatMail writes a message with a quoted-printable UTF-8 body.
+func formatMail(from, to *mail.Address, msg Mail, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+uuid.NewV4().String()+"@"+domain+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(msg.Body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an SMTP server that keeps the messages it is given.
+type fakeSMTP struct {
+	sync.Mutex
+	listener net.Listener
+	messages []smtpMessage
+}
+
+type smtpMessage struct {
+	from, to string
+	data     []byte
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	check(t, err)
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeSMTP{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(textproto.NewConn(conn))
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(c *textproto.Conn) {
+	defer c.Close()
+	var msg smtpMessage
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO", "RSET", "NOOP":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.from = strings.TrimPrefix(line, "MAIL FROM:")
+			c.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = strings.TrimPrefix(line, "RCPT TO:")
+			c.PrintfLine("250 ok")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			if msg.data, err = c.ReadDotBytes(); err != nil {
+				return
+			}
+			f.Lock()
+			f.messages = append(f.messages, msg)
+			f.Unlock()
+			msg = smtpMessage{}
+			c.PrintfLine("250 queued")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// mailer returns an smtpMailer that delivers to the fake server.
+func (f *fakeSMTP) mailer() Mailer {
+	addr := f.listener.Addr().(*net.TCPAddr)
+	return newMailer(SMTPConfig{Host: addr.IP.String(), Port: addr.Port,
+		From: "Lucid <noreply@lucid.example>", Timeout: Duration(5 * time.Second)})
+}
+
+// received returns the messages delivered so far, by recipient.
+func (f *fakeSMTP) received(t *testing.T) map[string]*mail.Message {
+	t.Helper()
+	f.Lock()
+	defer f.Unlock()
+	messages := make(map[string]*mail.Message)
+	for _, m := range f.messages {
+		msg, err := mail.ReadMessage(strings.NewReader(string(m.data)))
+		check(t, err)
+		messages[strings.Trim(m.to, "<>")] = msg
+	}
+	return messages
+}
+
+func mailBodyOf(t *testing.T, msg *mail.Message) string {
+	t.Helper()
+	body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+	check(t, err)
+	return string(body)
+}
+
+// TestSMTPMailer sends a message with a subject and a body that need
+// encoding through the fake server.
+func TestSMTPMailer(t *testing.T) {
+	f := newFakeSMTP(t)
+	body := "Grüße aus Łódź. " + strings.Repeat("A long line that has to be wrapped. ", 5) + "\n= done\n"
+	check(t, f.mailer().Send(Mail{"Zoë <zoe@a.org>", "Bericht fällig", body}))
+
+	f.Lock()
+	if len(f.messages) != 1 || f.messages[0].from != "<noreply@lucid.example>" || f.messages[0].to != "<zoe@a.org>" {
+		t.Fatalf("%+v", f.messages)
+	}
+	for _, line := range strings.Split(string(f.messages[0].data), "\n") {
+		if len(line) > 76 {
+			t.Errorf("line longer than 76 characters: %q", line)
+		}
+	}
+	f.Unlock()
+
+	msg := f.received(t)["zoe@a.org"]
+	if subject := msg.Header.Get("Subject"); subject != "=?utf-8?q?Bericht_f=C3=A4llig?=" {
+		t.Error(subject)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Bericht fällig" {
+		t.Error(subject)
+	}
+	if to, err := msg.Header.AddressList("To"); err != nil || to[0].Name != "Zoë" {
+		t.Error(to, err)
+	}
+	if msg.Header.Get("Content-Transfer-Encoding") != "quoted-printable" ||
+		!strings.HasSuffix(msg.Header.Get("Message-ID"), "@lucid.example>") {
+		t.Error(msg.Header)
+	}
+	if got := mailBodyOf(t, msg); got != body {
+		t.Errorf("%q", got)
+	}
+}
+
+// TestSendDigests publishes events to users with different preferences and
+// mails the digests of both organizations.
+func TestSendDigests(t *testing.T) {
+	f := newFakeSMTP(t)
+	store := newTestMemoryStore(t)
+	mem := store.(*memStore)
+	const cy, dee = "cccccccc-0000-4000-8000-000000000005", "dddddddd-0000-4000-8000-000000000006"
+	mem.AddUser(User{UserId: cy, OrganizationId: ORG_A, FullName: "Cy"}, "cy@a.org", nil)
+	mem.AddUser(User{UserId: dee, OrganizationId: ORG_A, FullName: "Dee"}, "dee@a.org", nil)
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	check(t, store.SetNotificationPreference(a, cy, NotificationPreference{NOTIFY_PROJECT_UPDATED, true, EMAIL_NEVER}))
+
+	n := NewNotifier(store, f.mailer(), "https://lucid.example/")
+	n.Publish(a, Event{Kind: NOTIFY_PROJECT_UPDATED, ActorId: ADMIN_A, Recipients: []string{cy, dee, ADMIN_A},
+		Title: "The vision of Radio changed", Body: "Ada changed the vision.", Link: "/projects/p/dashboard"})
+	n.Publish(a, Event{Kind: NOTIFY_REPLY, ActorId: ADMIN_A, Recipients: []string{dee},
+		Title: "Ada replied", Body: "Sounds good."})
+	n.Publish(a, Event{Kind: NOTIFY_TASK_ASSIGNED, ActorId: ADMIN_A, Recipients: []string{cy},
+		Title: "Ada assigned you a task", Body: "Call the station."})
+	n.Publish(b, Event{Kind: NOTIFY_REPLY, Recipients: []string{ADMIN_B}, Title: "Someone replied", Body: "Hi."})
+
+	// the task assignment is mailed right away
+	immediate := func() bool {
+		pending, err := store.GetUnmailedNotifications(a)
+		check(t, err)
+		for _, notification := range pending {
+			if notification.Email == EMAIL_IMMEDIATE {
+				return true
+			}
+		}
+		return false
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for immediate() {
+		if time.Now().After(deadline) {
+			t.Fatal("task assignment not mailed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if subject := f.received(t)["cy@a.org"].Header.Get("Subject"); subject != "Ada assigned you a task" {
+		t.Fatal(subject)
+	}
+
+	f.Lock()
+	f.messages = nil
+	f.Unlock()
+	check(t, n.SendDigests(time.Now()))
+	received := f.received(t)
+	if len(received) != 2 || received["dee@a.org"] == nil || received["bo@b.org"] == nil {
+		t.Fatal(received)
+	}
+	if subject := received["dee@a.org"].Header.Get("Subject"); subject != "2 new notifications" {
+		t.Error(subject)
+	}
+	want := "* The vision of Radio changed\n\n  Ada changed the vision.\n\n  https://lucid.example/projects/p/dashboard\n\n" +
+		"* Ada replied\n\n  Sounds good.\n\n"
+	if body := mailBodyOf(t, received["dee@a.org"]); body != want {
+		t.Errorf("%q", body)
+	}
+	if subject := received["bo@b.org"].Header.Get("Subject"); subject != "1 new notification" {
+		t.Error(subject)
+	}
+
+	// everything has been mailed
+	f.Lock()
+	f.messages = nil
+	f.Unlock()
+	check(t, n.SendDigests(time.Now().Add(time.Hour)))
+	if received := f.received(t); len(received) != 0 {
+		t.Fatal(received)
+	}
+}
@@ -3,17 +3,17 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
-	"github.com/satori/go.uuid"
-
-	"database/sql"
 
-	"strconv"
+	"sort"
+	"strings"
 
-	"io"
+	"log"
 	"os"
 
 	"time"
@@ -31,9 +31,38 @@ const (
 
 const USER = "user"
 
-var userSessions *SessionStorage
 var invalidApiKey = errors.New("invalid API key")
 
+// Server holds the dependencies of the HTTP handlers.
+type Server struct {
+	config   *Config
+	store    Store
+	blobs    BlobStore
+	previews LinkPreviewer
+	scanner  Scanner
+	rates    RateProvider
+	sessions *SessionStorage
+	notifier *Notifier
+	webhooks *WebhookSender
+	streams  *StreamHub
+}
+
+func NewServer(config *Config, store Store, blobs BlobStore) *Server {
+	sessions := NewSessionStorage(time.Duration(config.Session.TTL), time.Duration(config.Session.IdleTTL))
+	return &Server{
+		config:   config,
+		store:    store,
+		blobs:    blobs,
+		previews: newLinkPreviewer(config.LinkPreview),
+		scanner:  newScanner(config.Uploads),
+		rates:    newRateProvider(config.Currency),
+		sessions: sessions,
+		notifier: NewNotifier(store, newMailer(config.SMTP), config.Notifications.BaseURL),
+		webhooks: NewWebhookSender(store, config.Webhooks),
+		streams:  NewStreamHub(newLocalBus()),
+	}
+}
+
 type Response struct {
 	Data    interface{} `json:"data"`
 	Message string      `json:"message"`
@@ -48,31 +77,33 @@ func index(w http.ResponseWriter, r *http.Request) {
 }
 
 func JSON(w http.ResponseWriter, code int, obj interface{}) {
-	w.WriteHeader(code)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	enc := json.NewEncoder(w)
 	enc.Encode(obj)
 }
 
-func verifyApiKey(r *http.Request) (string, error) {
+func (s *Server) verifyApiKey(r *http.Request) (string, error) {
 	apiKey := r.Header.Get("X-Api-Key")
 	if len(apiKey) == 0 {
 		return "", invalidApiKey
 	}
-	id, ok := userSessions.Get(apiKey)
+	id, ok := s.sessions.Get(apiKey)
 	if ok {
 		return id, nil
 	}
 	return "", invalidApiKey
 }
 
-func login(w http.ResponseWriter, r *http.Request) {
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 
-	var userId string
-	var hashedPassword []byte
-	err := pg.QueryRow("SELECT user_id, password FROM users WHERE lower(email) = $1", email).Scan(&userId, &hashedPassword)
+	userId, hashedPassword, err := s.store.GetLogin(email)
+	if err == ErrNotFound {
+		JSON(w, http.StatusUnauthorized, Response{nil, "login failed"})
+		return
+	}
 	if err != nil {
 		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
 		return
@@ -84,60 +115,22 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	apiKey := createApiKey(userId)
-	userSessions.Set(apiKey, userId)
+	apiKey := createApiKey(s.config.AuthSecret, userId)
+	s.sessions.Set(apiKey, userId)
 	JSON(w, http.StatusOK, Response{apiKey, "login success"})
 }
 
-func dbGetUser(userId string) (User, error) {
-	var user User
-	err := pg.QueryRow("SELECT organization_id, full_name, is_admin FROM users WHERE user_id = $1", userId).Scan(&user.OrganizationId, &user.FullName, &user.IsAdmin)
-	if err != nil {
-		return user, err
-	}
-	user.UserId = userId
-	return user, err
-}
-
-func getProjects(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, USER).(User)
-
-	rows, err := pg.Query(`
-		SELECT
-		  project_id, project_name, coalesce(logo_url, ''), description, budget, donor,
-		  coalesce(mission, ''), coalesce(vision, ''), to_char(timeline_from, 'YYYY-MM-DD HH:MI:SS TZ'),
-		  to_char(timeline_to, 'YYYY-MM-DD HH:MI:SS TZ'),
-		  array_remove(array_agg(boundary_partner_id), NULL), array_remove(array_agg(partner_name), NULL),
-		  array_remove(array_agg(resource_id), NULL), array_remove(array_agg(resource_url), NULL)
-		FROM projects
-		LEFT JOIN boundary_partners USING (project_id)
-		LEFT JOIN external_resources USING (project_id)
-		WHERE organization_id = $1
-		GROUP BY project_id
-	`, user.OrganizationId)
+func (s *Server) getProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.store.GetProjects(tenantOf(r))
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
-	projects := []Project{}
-
-	for rows.Next() {
-		p := Project{}
-		err = rows.Scan(&p.ProjectId, &p.ProjectName, &p.LogoUrl, &p.Description, &p.Budget, &p.Donor, &p.Mission, &p.Vision,
-			&p.TimelineFrom, &p.TimelineTo, &p.BoundaryPartnerIds, &p.BoundaryPartnerNames, &p.ResourceIds, &p.ResourceUrls)
-		if err != nil {
-			rows.Close()
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
-		}
-		projects = append(projects, p)
-	}
-
 	JSON(w, http.StatusOK, Response{projects, "success"})
 }
 
-func addProject(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addProject(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 
 	// only add project if current user is an admin
@@ -152,27 +145,24 @@ func addProject(w http.ResponseWriter, r *http.Request) {
 		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
 		return
 	}
+	if input.Currency == "" {
+		input.Currency = s.config.Currency.Default
+	}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
 
-	_, err := pg.Exec("INSERT INTO projects (project_id, organization_id, project_name, description, budget, donor, vision, mission) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
-		uuid.NewV4().String(), user.OrganizationId, input.ProjectName, input.Description, input.Budget, input.Donor, input.Vision, input.Mission)
+	_, err := s.store.AddProject(tenantOf(r), input)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func dbGetProjectOrganization(projectId string) (string, error) {
-	var organizationId string
-	err := pg.QueryRow("SELECT organization_id FROM projects WHERE project_id = $1", projectId).Scan(&organizationId)
-	if err != nil {
-		return organizationId, err
-	}
-	return organizationId, err
-}
-
-func addBoundaryPartner(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addBoundaryPartner(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 
 	// only add boundary partner if current user is an admin
@@ -182,233 +172,37 @@ func addBoundaryPartner(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+	input := BoundaryPartner{PartnerName: r.FormValue("partner_name")}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	partnerName := r.FormValue("partner_name")
 
-	_, err = pg.Exec("INSERT INTO boundary_partners (boundary_partner_id, project_id, partner_name) VALUES ($1, $2, $3)",
-		uuid.NewV4().String(), projectId, partnerName)
+	partnerId, err := s.store.AddBoundaryPartner(tenantOf(r), projectId, input)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	if bp, err := s.store.GetBoundaryPartner(tenantOf(r), projectId, partnerId); err == nil {
+		s.stream(r, projectId, STREAM_PARTNER_CREATED, bp)
+		s.publish(r, EVENT_PARTNER_CREATED, map[string]interface{}{"project_id": projectId, "boundary_partner": bp})
+	}
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func getBoundaryPartner(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, USER).(User)
-
+func (s *Server) getBoundaryPartner(w http.ResponseWriter, r *http.Request) {
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	// brute force queries
-	// TODO: optimize?
-	var bp BoundaryPartner
-	row := pg.QueryRow(`
-		SELECT boundary_partner_id, project_id, partner_name, coalesce(outcome_statement, '')
-		FROM boundary_partners
-		JOIN projects USING (project_id)
-		LEFT JOIN progress_markers USING (boundary_partner_id)
-		WHERE organization_id = $1
-		GROUP BY boundary_partner_id
-	`, user.OrganizationId)
-
-	err = row.Scan(&bp.BoundaryPartnerId, &bp.ProjectId, &bp.PartnerName, &bp.OutcomeStatement)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
-	}
-
-	// get progress markers for boundary partner
-	var markers []*ProgressMarker
-	rows, err := pg.Query("SELECT progress_marker_id, title, type, order_number FROM progress_markers WHERE boundary_partner_id = $1 ORDER BY ts_created", bp.BoundaryPartnerId)
+	partnerId := mux.Vars(r)["partnerId"]
+	bp, err := s.store.GetBoundaryPartner(tenantOf(r), projectId, partnerId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
-	for rows.Next() {
-		pm := ProgressMarker{}
-		pm.BoundaryPartnerId = bp.BoundaryPartnerId
-		err = rows.Scan(&pm.ProgressMarkerId, &pm.Title, &pm.Type, &pm.OrderNumber)
-		if err != nil {
-			rows.Close()
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
-		}
-		markers = append(markers, &pm)
-	}
-	rows.Close()
-
-	// get challenges for each progress marker
-	for i, pm := range markers {
-		var challenges []*Challenge
-		rows, err = pg.Query("SELECT challenge_id, challenge_name FROM challenges WHERE progress_marker_id = $1 ORDER BY ts_created", pm.ProgressMarkerId)
-		if err != nil {
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
-		}
-		for rows.Next() {
-			c := Challenge{}
-			c.ProgressMarkerId = pm.ProgressMarkerId
-			err = rows.Scan(&c.ChallengeId, &c.ChallengeName)
-			if err != nil {
-				rows.Close()
-				JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-				return
-			}
-			challenges = append(challenges, &c)
-		}
-		rows.Close()
-		markers[i].Challenges = challenges
-	}
 
-	// get strategies for each progress marker
-	for i, pm := range markers {
-		var strategies []*Strategy
-		rows, err = pg.Query("SELECT strategy_id, strategy_name FROM strategies WHERE progress_marker_id = $1 ORDER BY ts_created", pm.ProgressMarkerId)
-		if err != nil {
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
-		}
-		for rows.Next() {
-			s := Strategy{}
-			s.ProgressMarkerId = pm.ProgressMarkerId
-			err = rows.Scan(&s.StrategyId, &s.StrategyName)
-			if err != nil {
-				rows.Close()
-				JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-				return
-			}
-			strategies = append(strategies, &s)
-		}
-		rows.Close()
-		markers[i].Strategies = strategies
-	}
-
-	bp.ProgressMarkers = markers
 	JSON(w, http.StatusOK, Response{bp, "success"})
 }
 
-func getBoundaryPartner1(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, USER).(User)
-
-	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	rows, err := pg.Queryx(`
-		SELECT boundary_partner_id, project_id, partner_name, coalesce(outcome_statement, '') AS outcome_statement,
-		  progress_marker_id, title, type, order_number, challenge_id, challenge_name, strategy_id, strategy_name
-		FROM boundary_partners
-		JOIN projects USING (project_id)
-		LEFT JOIN progress_markers pm USING (boundary_partner_id)
-		LEFT JOIN challenges ch USING (progress_marker_id)
-		LEFT JOIN strategies strats USING (progress_marker_id)
-		WHERE organization_id = $1
-		ORDER BY pm.ts_created, ch.ts_created
-	`, projectOrganizationId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
-	}
-
-	type ChallengeQuery struct {
-		BoundaryPartnerId string         `db:"boundary_partner_id"`
-		ProjectId         string         `db:"project_id"`
-		PartnerName       string         `db:"partner_name"`
-		OutcomeStatement  string         `db:"outcome_statement"`
-		ProgressMarkerId  string         `db:"progress_marker_id"`
-		Title             string         `db:"title"`
-		Type              int            `db:"type"`
-		OrderNumber       int            `db:"order_number"`
-		ChallengeId       sql.NullString `db:"challenge_id"`
-		ChallengeName     sql.NullString `db:"challenge_name"`
-		StrategyId        sql.NullString `db:"strategy_id"`
-		StrategyName      sql.NullString `db:"strategy_name"`
-	}
-	var bp *BoundaryPartner
-	pmDict := make(map[string]*ProgressMarker)
-	challengeDict := make(map[string]bool)
-	strategyDict := make(map[string]bool)
-	for rows.Next() {
-		var cq ChallengeQuery
-		err = rows.StructScan(&cq)
-		if err != nil {
-			rows.Close()
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
-		}
-		// set boundary partner if it does not exist (only one)
-		if bp == nil {
-			bp = &BoundaryPartner{}
-			bp.ProjectId = cq.ProjectId
-			bp.BoundaryPartnerId = cq.BoundaryPartnerId
-			bp.PartnerName = cq.PartnerName
-			bp.OutcomeStatement = cq.OutcomeStatement
-		}
-		// add a progress marker to the dictionary if it hasn't been added
-		if _, exists := pmDict[cq.ProgressMarkerId]; !exists {
-			tmp := ProgressMarker{}
-			tmp.ProgressMarkerId = cq.ProgressMarkerId
-			tmp.BoundaryPartnerId = bp.BoundaryPartnerId
-			tmp.Title = cq.Title
-			tmp.OrderNumber = cq.OrderNumber
-			tmp.Type = cq.Type
-			pmDict[cq.ProgressMarkerId] = &tmp
-		}
-		// add the challenge to the dictionary if it hasn't been added and link it to the progress marker
-		if _, exists := challengeDict[cq.ChallengeId.String]; cq.ChallengeId.Valid && !exists {
-			ch := Challenge{}
-			ch.ChallengeId = cq.ChallengeId.String
-			ch.ProgressMarkerId = cq.ProgressMarkerId
-			ch.ChallengeName = cq.ChallengeName.String
-			challengeDict[cq.ChallengeId.String] = true
-			pmDict[cq.ProgressMarkerId].Challenges = append(pmDict[cq.ProgressMarkerId].Challenges, &ch)
-		}
-		// add the strategy to the dictionary if it hasn't been added and link it to the progress marker
-		if _, exists := strategyDict[cq.StrategyId.String]; cq.StrategyId.Valid && !exists {
-			strat := Strategy{}
-			strat.StrategyId = cq.StrategyId.String
-			strat.ProgressMarkerId = cq.ProgressMarkerId
-			strat.StrategyName = cq.StrategyName.String
-			strategyDict[cq.StrategyId.String] = true
-			pmDict[cq.ProgressMarkerId].Strategies = append(pmDict[cq.ProgressMarkerId].Strategies, &strat)
-		}
-	}
-	for _, v := range pmDict {
-		bp.ProgressMarkers = append(bp.ProgressMarkers, v)
-	}
-
-	JSON(w, http.StatusOK, Response{*bp, "success"})
-}
-
-func addProgressMarker(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addProgressMarker(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 
 	// only add progress marker if current user is an admin
@@ -418,17 +212,6 @@ func addProgressMarker(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
 	partnerId := mux.Vars(r)["partnerId"]
 	var input ProgressMarker
 	dec := json.NewDecoder(r.Body)
@@ -436,24 +219,25 @@ func addProgressMarker(w http.ResponseWriter, r *http.Request) {
 		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
 		return
 	}
-
-	var orderNumber int
-	err = pg.QueryRow("SELECT count(*) FROM progress_markers WHERE boundary_partner_id = $1", partnerId).Scan(&orderNumber)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	_, err = pg.Exec("INSERT INTO progress_markers (progress_marker_id, boundary_partner_id, title, type, order_number) VALUES ($1, $2, $3, $4, $5)",
-		uuid.NewV4().String(), partnerId, &input.Title, &input.Type, orderNumber+1)
+	markerId, err := s.store.AddProgressMarker(tenantOf(r), projectId, partnerId, input)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	if bp, err := s.store.GetBoundaryPartner(tenantOf(r), projectId, partnerId); err == nil {
+		if pm := findMarker(bp, markerId); pm != nil {
+			s.stream(r, projectId, STREAM_MARKER_CREATED, pm)
+		}
+	}
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func addChallenge(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addChallenge(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 
 	// only add challenge if current user is an admin
@@ -463,30 +247,24 @@ func addChallenge(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	markerId := mux.Vars(r)["progressMarkerId"]
+	input := Challenge{ChallengeName: r.FormValue("challenge")}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	markerId := mux.Vars(r)["progressMarkerId"]
-	challenge := r.FormValue("challenge")
-
-	_, err = pg.Exec("INSERT INTO challenges (challenge_id, progress_marker_id, challenge_name) VALUES ($1, $2, $3)",
-		uuid.NewV4().String(), markerId, challenge)
+	id, err := s.store.AddChallenge(tenantOf(r), projectId, markerId, input)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	input.ChallengeId, input.ProgressMarkerId = id, markerId
+	s.stream(r, projectId, STREAM_CHALLENGE_CREATED, input)
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func addStrategy(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addStrategy(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -494,48 +272,58 @@ func addStrategy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	markerId := mux.Vars(r)["progressMarkerId"]
+	input := Strategy{StrategyName: r.FormValue("strategy")}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	markerId := mux.Vars(r)["progressMarkerId"]
-	strategy := r.FormValue("strategy")
-
-	_, err = pg.Exec("INSERT INTO strategies (strategy_id, progress_marker_id, strategy_name) VALUES ($1, $2, $3)",
-		uuid.NewV4().String(), markerId, strategy)
+	id, err := s.store.AddStrategy(tenantOf(r), projectId, markerId, input)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	input.StrategyId, input.ProgressMarkerId = id, markerId
+	s.stream(r, projectId, STREAM_STRATEGY_CREATED, input)
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func authenticate(fn http.HandlerFunc) http.HandlerFunc {
+// authenticate serves the members of an organization. Grant officers are
+// turned away, they only get the routes wrapped by authenticateDonorView.
+func (s *Server) authenticate(fn http.HandlerFunc) http.HandlerFunc {
+	return s.authenticateAs(false, fn)
+}
+
+// authenticateDonorView serves the members of an organization and grant
+// officers.
+func (s *Server) authenticateDonorView(fn http.HandlerFunc) http.HandlerFunc {
+	return s.authenticateAs(true, fn)
+}
+
+func (s *Server) authenticateAs(donorView bool, fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userId, err := verifyApiKey(r)
+		userId, err := s.verifyApiKey(r)
 		if err != nil {
 			JSON(w, http.StatusUnauthorized, Response{nil, err.Error()})
 			return
 		}
-		user, err := dbGetUser(userId)
+		user, err := s.store.GetUser(userId)
 		if err != nil {
 			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
 			return
 		}
+		if user.DonorId != "" && !donorView {
+			JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+			return
+		}
 		context.Set(r, USER, user)
 		fn(w, r)
 		context.Clear(r)
 	}
 }
 
-func updateProjectName(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectName(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -543,29 +331,26 @@ func updateProjectName(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	projectName := r.FormValue("project_name")
+	if errs := validate(
+		required("project_name", projectName),
+		maxLength("project_name", projectName, MAX_NAME_LENGTH),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	projectName := r.FormValue("project_name")
-
-	_, err = pg.Exec("UPDATE projects SET project_name = $1 WHERE project_id = $2", projectName, projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "project_name", projectName)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.projectUpdated(r, projectId, "name")
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProjectLogo(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectLogo(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -573,48 +358,24 @@ func updateProjectLogo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	r.ParseMultipartForm(10 * int64(1e6)) // grab the multipart form
-	if r.MultipartForm == nil {
-		JSON(w, http.StatusBadRequest, Response{nil, "request must be multipart/form-data"})
-		return
-	}
-
-	file, handler, err := r.FormFile("project_logo") // grab the filenames
-
-	if err != nil {
-		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
-		return
-	}
-	defer file.Close()
-
-	//TODO	url, err := uploadFile(file, handler.Filename, projectId)
-	url := handler.Filename
-	if err != nil {
-		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+	logo, unpin, ok := s.receiveLogo(w, r, "project_logo", "logo of project "+projectId)
+	if !ok {
 		return
 	}
 
-	_, err = pg.Exec("UPDATE projects SET logo_url = $1 WHERE project_id = $2", url, projectId)
+	old, err := s.store.SetProjectLogo(tenantOf(r), projectId, logo)
+	unpin()
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		s.releaseLogo(tenantOf(r), logo)
+		respondError(w, err)
 		return
 	}
+	s.releaseLogo(tenantOf(r), old)
 
-	JSON(w, http.StatusOK, Response{nil, "success"})
+	JSON(w, http.StatusOK, Response{projectLogo(projectId, *logo), "success"})
 }
 
-func updateProjectBudget(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectBudget(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -622,33 +383,37 @@ func updateProjectBudget(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	budget, err := ParseAmount(r.FormValue("project_budget"))
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"project_budget": err.Error()}, "validation failed"})
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	if errs := validate(nonNegative("project_budget", budget), maxAmount("project_budget", budget)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	budget, err := strconv.ParseFloat(r.FormValue("project_budget"), 64)
+	// once a project has budget lines its budget is their sum
+	lines, err := s.store.GetBudgetLines(tenantOf(r), projectId)
 	if err != nil {
-		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		respondError(w, err)
+		return
+	}
+	if len(lines) > 0 {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"project_budget": "is derived from the budget lines"}, "validation failed"})
 		return
 	}
 
-	_, err = pg.Exec("UPDATE projects SET budget = $1 WHERE project_id = $2", budget, projectId)
+	err = s.store.SetProjectField(tenantOf(r), projectId, "budget", budget)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProjectTimeline(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectTimeline(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -656,17 +421,6 @@ func updateProjectTimeline(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
 	var input Project
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&input); err != nil {
@@ -674,28 +428,53 @@ func updateProjectTimeline(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t_f, err1 := time.Parse(time.RFC3339, input.TimelineFrom)
-	t_t, err2 := time.Parse(time.RFC3339, input.TimelineTo)
+	errs := validate(
+		required("timeline_from", input.TimelineFrom),
+		required("timeline_to", input.TimelineTo),
+		timestamp("timeline_from", input.TimelineFrom),
+		timestamp("timeline_to", input.TimelineTo),
+		dateOrder("timeline_from", input.TimelineFrom, "timeline_to", input.TimelineTo),
+	)
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
 
-	if err1 != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err1.Error()})
+	// the activities of the project have to stay within the timeline
+	activities, err := s.store.GetActivities(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
-	if err2 != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err2.Error()})
+	for _, a := range activities {
+		outside := withinTimeline(a, input)
+		if _, early := outside["starts_on"]; early && errs["timeline_from"] == "" {
+			errs = nestErrors(errs, "", FieldErrors{"timeline_from": fmt.Sprintf("must not be after the start of the %s %q", a.Kind, a.Title)})
+		}
+		if _, late := outside["ends_on"]; late && errs["timeline_to"] == "" {
+			errs = nestErrors(errs, "", FieldErrors{"timeline_to": fmt.Sprintf("must not be before the end of the %s %q", a.Kind, a.Title)})
+		}
+	}
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	_, err = pg.Exec("UPDATE projects SET timeline_from = $1, timeline_to = $2 WHERE project_id = $3", t_f, t_t, projectId)
+	// both values were validated above
+	t_f, _ := time.Parse(time.RFC3339, input.TimelineFrom)
+	t_t, _ := time.Parse(time.RFC3339, input.TimelineTo)
+
+	err = s.store.SetProjectTimeline(tenantOf(r), projectId, t_f, t_t)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.projectUpdated(r, projectId, "timeline")
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProjectDescription(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectDescription(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -703,29 +482,26 @@ func updateProjectDescription(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	description := r.FormValue("project_description")
+	if errs := validate(maxLength("project_description", description, MAX_TEXT_LENGTH)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	description := r.FormValue("project_description")
-
-	_, err = pg.Exec("UPDATE projects SET description = $1 WHERE project_id = $2", description, projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "description", description)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.projectUpdated(r, projectId, "description")
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProjectDonor(w http.ResponseWriter, r *http.Request) {
+// updateProjectDonor is kept from before donors had grants. It registers a
+// grant without amount from the named donor, unless the project has one
+// from it already.
+func (s *Server) updateProjectDonor(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -733,29 +509,22 @@ func updateProjectDonor(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+	donor := strings.TrimSpace(r.FormValue("project_donor"))
+	if errs := validate(required("project_donor", donor), maxLength("project_donor", donor, MAX_NAME_LENGTH)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	donor := r.FormValue("project_donor")
 
-	_, err = pg.Exec("UPDATE projects SET donor = $1 WHERE project_id = $2", donor, projectId)
+	err := s.store.SetProjectDonor(tenantOf(r), projectId, donor)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProjectMission(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectMission(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -763,29 +532,23 @@ func updateProjectMission(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	mission := r.FormValue("project_mission")
+	if errs := validate(maxLength("project_mission", mission, MAX_TEXT_LENGTH)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	mission := r.FormValue("project_mission")
-
-	_, err = pg.Exec("UPDATE projects SET mission = $1 WHERE project_id = $2", mission, projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "mission", mission)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.projectUpdated(r, projectId, "mission")
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProjectVision(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProjectVision(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -793,29 +556,23 @@ func updateProjectVision(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	vision := r.FormValue("project_vision")
+	if errs := validate(maxLength("project_vision", vision, MAX_TEXT_LENGTH)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	vision := r.FormValue("project_vision")
-
-	_, err = pg.Exec("UPDATE projects SET vision = $1 WHERE project_id = $2", vision, projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "vision", vision)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.projectUpdated(r, projectId, "vision")
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func deleteProject(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteProject(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -823,27 +580,49 @@ func deleteProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	project, err := s.store.GetProject(tenantOf(r), projectId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		respondError(w, err)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	resources, err := s.store.GetExternalResources(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
-
-	_, err = pg.Exec("DELETE FROM projects WHERE project_id = $1", projectId)
+	quarantined, err := s.store.GetQuarantinedResources(tenantOf(r), projectId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
+		return
+	}
+	var keys []string
+	for _, exr := range append(resources, quarantined...) {
+		keys = append(keys, exr.StorageKey)
+		versions, err := s.store.GetResourceVersions(tenantOf(r), projectId, exr.ResourceId)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		for _, v := range versions {
+			keys = append(keys, v.StorageKey)
+		}
+	}
+	err = s.store.DeleteProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
 
+	// the rows are gone, now their files can go as well
+	s.releaseLogo(tenantOf(r), project.Logo)
+	for _, key := range keys {
+		s.releaseBlob(tenantOf(r), key)
+	}
+
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectName(w http.ResponseWriter, r *http.Request) {
+func (s *Server) resetProjectName(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -851,27 +630,34 @@ func resetProjectName(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "project_name", nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		respondError(w, err)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) resetProjectLogo(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
 		return
 	}
 
-	_, err = pg.Exec("UPDATE projects SET project_name = NULL WHERE project_id = $1", projectId)
+	projectId := mux.Vars(r)["projectId"]
+	old, err := s.store.SetProjectLogo(tenantOf(r), projectId, nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, "update failed"})
+		respondError(w, err)
 		return
 	}
+	s.releaseLogo(tenantOf(r), old)
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectLogo(w http.ResponseWriter, r *http.Request) {
+func (s *Server) resetProjectDescription(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -879,27 +665,33 @@ func resetProjectLogo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "description", nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		respondError(w, err)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) resetProjectBudget(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
 		return
 	}
-	// TODO delete logo file in S3
-	_, err = pg.Exec("UPDATE projects SET logo_url = NULL WHERE project_id = $1", projectId)
+
+	projectId := mux.Vars(r)["projectId"]
+	err := s.store.SetProjectField(tenantOf(r), projectId, "budget", nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectDescription(w http.ResponseWriter, r *http.Request) {
+func (s *Server) resetProjectTimeline(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -907,27 +699,18 @@ func resetProjectDescription(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	err := s.store.SetProjectTimeline(tenantOf(r), projectId, nil, nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	_, err = pg.Exec("UPDATE projects SET description = NULL WHERE project_id = $1", projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectBudget(w http.ResponseWriter, r *http.Request) {
+// resetProjectDonor is kept from before donors had grants. It deletes the
+// grants of the project that have nothing but a donor, the others stay.
+func (s *Server) resetProjectDonor(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -935,27 +718,16 @@ func resetProjectBudget(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	_, err = pg.Exec("UPDATE projects SET budget = NULL WHERE project_id = $1", projectId)
+	err := s.store.ResetProjectDonor(tenantOf(r), projectId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectTimeline(w http.ResponseWriter, r *http.Request) {
+func (s *Server) resetProjectMission(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -963,27 +735,16 @@ func resetProjectTimeline(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "mission", nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	_, err = pg.Exec("UPDATE projects SET timeline_from = NULL, timeline_to = NULL WHERE project_id = $1", projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectDonor(w http.ResponseWriter, r *http.Request) {
+func (s *Server) resetProjectVision(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -991,27 +752,16 @@ func resetProjectDonor(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	err := s.store.SetProjectField(tenantOf(r), projectId, "vision", nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	_, err = pg.Exec("UPDATE projects SET donor = NULL WHERE project_id = $1", projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectMission(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updatePartnerName(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1019,27 +769,27 @@ func resetProjectMission(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	partnerId := mux.Vars(r)["partnerId"]
+	partnerName := r.FormValue("partner_name")
+	if errs := validate(
+		required("partner_name", partnerName),
+		maxLength("partner_name", partnerName, MAX_NAME_LENGTH),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	_, err = pg.Exec("UPDATE projects SET mission = NULL WHERE project_id = $1", projectId)
+	err := s.store.SetPartnerField(tenantOf(r), projectId, partnerId, "partner_name", partnerName)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.stream(r, projectId, STREAM_PARTNER_UPDATED, map[string]interface{}{"boundary_partner_id": partnerId, "partner_name": partnerName})
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetProjectVision(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateOutcomeStatement(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1047,27 +797,24 @@ func resetProjectVision(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	partnerId := mux.Vars(r)["partnerId"]
+	partnerOutcomeStatement := r.FormValue("outcome_statement")
+	if errs := validate(maxLength("outcome_statement", partnerOutcomeStatement, MAX_TEXT_LENGTH)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	_, err = pg.Exec("UPDATE projects SET vision = NULL WHERE project_id = $1", projectId)
+	err := s.store.SetPartnerField(tenantOf(r), projectId, partnerId, "outcome_statement", partnerOutcomeStatement)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.stream(r, projectId, STREAM_PARTNER_UPDATED, map[string]interface{}{"boundary_partner_id": partnerId, "outcome_statement": partnerOutcomeStatement})
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updatePartnerName(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateProgressMarker(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1075,30 +822,69 @@ func updatePartnerName(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+	progressMarkerId := mux.Vars(r)["progressMarkerId"]
+	var input ProgressMarker
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
 	partnerId := mux.Vars(r)["partnerId"]
-	partnerName := r.FormValue("partner_name")
-
-	_, err = pg.Exec("UPDATE boundary_partners SET partner_name = $1 WHERE boundary_partner_id = $2", partnerName, partnerId)
+	before, _ := s.store.GetBoundaryPartner(tenantOf(r), projectId, partnerId)
+	err := s.store.UpdateProgressMarker(tenantOf(r), projectId, progressMarkerId, input)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	if after, err := s.store.GetBoundaryPartner(tenantOf(r), projectId, partnerId); err == nil {
+		if pm := findMarker(after, progressMarkerId); pm != nil {
+			s.stream(r, projectId, STREAM_MARKER_UPDATED, pm)
+		}
+		if order := markerOrder(after); strings.Join(order, ",") != strings.Join(markerOrder(before), ",") {
+			s.stream(r, projectId, STREAM_MARKERS_REORDERED, map[string]interface{}{
+				"boundary_partner_id": partnerId,
+				"progress_marker_ids": order,
+			})
+			s.publish(r, EVENT_MARKERS_REORDERED, map[string]interface{}{
+				"project_id":          projectId,
+				"boundary_partner_id": partnerId,
+				"progress_marker_ids": order,
+			})
+		}
+	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateOutcomeStatement(w http.ResponseWriter, r *http.Request) {
+// findMarker returns the progress marker of a boundary partner with the given
+// ID, or nil.
+func findMarker(bp BoundaryPartner, markerId string) *ProgressMarker {
+	for _, pm := range bp.ProgressMarkers {
+		if pm.ProgressMarkerId == markerId {
+			return pm
+		}
+	}
+	return nil
+}
+
+// markerOrder returns the IDs of the progress markers of a boundary partner
+// in their order.
+func markerOrder(bp BoundaryPartner) []string {
+	markers := append([]*ProgressMarker(nil), bp.ProgressMarkers...)
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].OrderNumber < markers[j].OrderNumber })
+	ids := []string{}
+	for _, pm := range markers {
+		ids = append(ids, pm.ProgressMarkerId)
+	}
+	return ids
+}
+
+func (s *Server) updateChallenge(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1106,30 +892,26 @@ func updateOutcomeStatement(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	challengeId := mux.Vars(r)["challengeId"]
+	challengeName := r.FormValue("challenge")
+	if errs := validate(
+		required("challenge", challengeName),
+		maxLength("challenge", challengeName, MAX_NAME_LENGTH),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	partnerId := mux.Vars(r)["partnerId"]
-	partnerOutcomeStatement := r.FormValue("outcome_statement")
-
-	_, err = pg.Exec("UPDATE boundary_partners SET outcome_statement = $1 WHERE boundary_partner_id = $2", partnerOutcomeStatement, partnerId)
+	err := s.store.UpdateChallenge(tenantOf(r), projectId, challengeId, Challenge{ChallengeName: challengeName})
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
-
+	s.stream(r, projectId, STREAM_CHALLENGE_UPDATED, map[string]interface{}{"challenge_id": challengeId, "challenge_name": challengeName})
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateProgressMarker(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateStrategy(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1137,63 +919,26 @@ func updateProgressMarker(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	progressMarkerId := mux.Vars(r)["progressMarkerId"]
-	var input ProgressMarker
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&input); err != nil {
-		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
-		return
-	}
-
-	var oldOrderNumber int
-	var boundaryPartnerId string
-	err = pg.QueryRow("SELECT order_number, boundary_partner_id from progress_markers where progress_marker_id = $1", progressMarkerId).Scan(&oldOrderNumber, &boundaryPartnerId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+	strategyId := mux.Vars(r)["strategyId"]
+	strategyName := r.FormValue("strategy")
+	if errs := validate(
+		required("strategy", strategyName),
+		maxLength("strategy", strategyName, MAX_NAME_LENGTH),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
 
-	if oldOrderNumber != input.OrderNumber {
-		if oldOrderNumber > input.OrderNumber {
-			// increase other progress markers, of which the order number is larger than oldOrderNumber, by one.
-			_, err = pg.Exec("UPDATE progress_markers SET order_number = order_number + 1 "+
-				"WHERE boundary_partner_id = $1 and order_number >= $2 and order_number <= $3",
-				boundaryPartnerId, input.OrderNumber, oldOrderNumber-1)
-		} else {
-			// decrease other progress markers, of which the order number is smaller than input.OrderNumber, by one.
-			JSON(w, http.StatusOK, Response{nil, "-1"})
-			_, err = pg.Exec("UPDATE progress_markers SET order_number = order_number - 1 "+
-				"WHERE boundary_partner_id = $1 and order_number >= $2 and order_number <= $3",
-				boundaryPartnerId, oldOrderNumber+1, input.OrderNumber)
-		}
-		if err != nil {
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
-		}
-	}
-
-	_, err = pg.Exec("UPDATE progress_markers set title = $1, type = $2, order_number = $3 WHERE progress_marker_id = $4",
-		&input.Title, &input.Type, &input.OrderNumber, progressMarkerId)
+	err := s.store.UpdateStrategy(tenantOf(r), projectId, strategyId, Strategy{StrategyName: strategyName})
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
-
+	s.stream(r, projectId, STREAM_STRATEGY_UPDATED, map[string]interface{}{"strategy_id": strategyId, "strategy_name": strategyName})
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateChallenge(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteBoundaryPartner(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1201,30 +946,18 @@ func updateChallenge(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	boundaryPartnerId := mux.Vars(r)["partnerId"]
+	err := s.store.DeleteBoundaryPartner(tenantOf(r), projectId, boundaryPartnerId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		respondError(w, err)
 		return
 	}
+	s.stream(r, projectId, STREAM_PARTNER_DELETED, map[string]interface{}{"boundary_partner_id": boundaryPartnerId})
 
-	challengeId := mux.Vars(r)["challengeId"]
-	challengeName := r.FormValue("challenge")
-
-	_, err = pg.Exec("UPDATE challenges SET challenge_name = $1 WHERE challenge_id = $2",
-		challengeName, challengeId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
-	}
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func updateStrategy(w http.ResponseWriter, r *http.Request) {
+func (s *Server) resetOutcomeStatement(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1232,30 +965,18 @@ func updateStrategy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	boundaryPartnerId := mux.Vars(r)["partnerId"]
+	err := s.store.SetPartnerField(tenantOf(r), projectId, boundaryPartnerId, "outcome_statement", nil)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		respondError(w, err)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	strategyId := mux.Vars(r)["strategyId"]
-	strategyName := r.FormValue("strategy")
+	s.stream(r, projectId, STREAM_PARTNER_UPDATED, map[string]interface{}{"boundary_partner_id": boundaryPartnerId, "outcome_statement": ""})
 
-	_, err = pg.Exec("UPDATE strategies SET strategy_name = $1 WHERE strategy_id = $2",
-		strategyName, strategyId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
-	}
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func deleteBoundaryPartner(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteProgressMarker(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1263,28 +984,18 @@ func deleteBoundaryPartner(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	boundaryPartnerId := mux.Vars(r)["partnerId"]
-	_, err = pg.Exec("DELETE FROM boundary_partners WHERE boundary_partner_id = $1", boundaryPartnerId)
+	progressMarkerId := mux.Vars(r)["progressMarkerId"]
+	err := s.store.DeleteProgressMarker(tenantOf(r), projectId, progressMarkerId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.stream(r, projectId, STREAM_MARKER_DELETED, map[string]interface{}{"progress_marker_id": progressMarkerId})
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func resetOutcomeStatement(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteChallenge(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1292,28 +1003,37 @@ func resetOutcomeStatement(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	challengeId := mux.Vars(r)["challengeId"]
+	err := s.store.DeleteChallenge(tenantOf(r), projectId, challengeId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		respondError(w, err)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
+	s.stream(r, projectId, STREAM_CHALLENGE_DELETED, map[string]interface{}{"challenge_id": challengeId})
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) deleteStrategy(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
 		return
 	}
 
-	boundaryPartnerId := mux.Vars(r)["partnerId"]
-	_, err = pg.Exec("UPDATE boundary_partners SET outcome_statement = NULL WHERE boundary_partner_id = $1", boundaryPartnerId)
+	projectId := mux.Vars(r)["projectId"]
+	strategyId := mux.Vars(r)["strategyId"]
+	err := s.store.DeleteStrategy(tenantOf(r), projectId, strategyId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
+	s.stream(r, projectId, STREAM_STRATEGY_DELETED, map[string]interface{}{"strategy_id": strategyId})
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func deleteProgressMarker(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getExternalResource(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1321,57 +1041,126 @@ func deleteProgressMarker(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	resources, err := s.store.GetExternalResources(tenantOf(r), projectId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+		respondError(w, err)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
+
+	// optional filters: ?boundary_partner_id=, ?progress_marker_id=, ?journal_id=, ?tag=
+	query := r.URL.Query()
+	filtered := []ExternalResources{}
+	for _, exr := range resources {
+		if v := query.Get("boundary_partner_id"); v != "" && exr.BoundaryPartnerId != v {
+			continue
+		}
+		if v := query.Get("progress_marker_id"); v != "" && exr.ProgressMarkerId != v {
+			continue
+		}
+		if v := query.Get("journal_id"); v != "" && exr.JournalId != v {
+			continue
+		}
+		if v := query.Get("tag"); v != "" && !hasTag(exr.Tags, v) {
+			continue
+		}
+		filtered = append(filtered, exr)
 	}
 
-	progressMarkerId := mux.Vars(r)["progressMarkerId"]
-	_, err = pg.Exec("DELETE FROM progress_markers WHERE progress_marker_id = $1", progressMarkerId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
+	JSON(w, http.StatusOK, Response{filtered, "success"})
+}
+
+func hasTag(tags []string, tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
 	}
+	return false
+}
 
-	JSON(w, http.StatusOK, Response{nil, "success"})
+// checkAttachment verifies that the boundary partner, progress marker and
+// outcome journal a resource is attached to belong to its project, and to
+// the boundary partner if it names one. It writes the error
+// response and returns false if they do not.
+func (s *Server) checkAttachment(w http.ResponseWriter, r *http.Request, exr ExternalResources) bool {
+	vars := map[string]string{"projectId": exr.ProjectId}
+	if exr.BoundaryPartnerId != "" {
+		vars["partnerId"] = exr.BoundaryPartnerId
+	}
+	if exr.ProgressMarkerId != "" {
+		vars["progressMarkerId"] = exr.ProgressMarkerId
+	}
+	if exr.JournalId != "" {
+		vars["journalId"] = exr.JournalId
+	}
+	field, err := s.store.CheckOwnership(tenantOf(r), vars)
+	if err != nil {
+		respondError(w, err)
+		return false
+	}
+	if field != "" {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{field: "not found"}, "validation failed"})
+		return false
+	}
+	return true
 }
 
-func deleteChallenge(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addResourceLink(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
 		return
 	}
 
-	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+	var exr ExternalResources
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&exr); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	exr.ProjectId = mux.Vars(r)["projectId"]
+	exr.ResourceType = RESOURCE_LINK
+	exr.ResourceUrl = strings.TrimSpace(exr.ResourceUrl)
+	exr.Tags = normalizeTags(exr.Tags)
+	exr.FaviconUrl = ""
+	exr.StorageKey = ""
+	if errs := exr.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	if !s.checkAttachment(w, r, exr) {
 		return
 	}
 
-	challengeId := mux.Vars(r)["challengeId"]
-	_, err = pg.Exec("DELETE FROM challenges WHERE challenge_id = $1", challengeId)
+	preview, err := s.previews.Preview(exr.ResourceUrl)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		// the link is still added, just without title and favicon
+		debugf("link preview %s: %v", exr.ResourceUrl, err)
+	}
+	if exr.Title == "" {
+		exr.Title = preview.Title
+	}
+	exr.FaviconUrl = preview.FaviconUrl
+	exr.ResourceName = exr.Title
+	if exr.ResourceName == "" {
+		exr.ResourceName = exr.ResourceUrl
+	}
+
+	exr.ResourceId, err = s.store.AddExternalResource(tenantOf(r), user.UserId, exr)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
 
-	JSON(w, http.StatusOK, Response{nil, "success"})
+	JSON(w, http.StatusOK, Response{exr, "success"})
 }
 
-func deleteStrategy(w http.ResponseWriter, r *http.Request) {
+// uploadResourceFile adds a file to a project. A file named like one that is
+// already attached to the same boundary partner, progress marker and journal
+// becomes a new version of it instead; its title, description and tags are
+// kept.
+func (s *Server) uploadResourceFile(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1379,70 +1168,89 @@ func deleteStrategy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+
+	file, handler, ok := s.receiveFile(w, r, "resource_file")
+	if !ok {
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	defer file.Close()
+
+	var tags []string
+	for _, value := range r.MultipartForm.Value["tags"] {
+		tags = append(tags, strings.Split(value, ",")...)
+	}
+	exr := ExternalResources{
+		ProjectId:         projectId,
+		ResourceType:      RESOURCE_FILE,
+		ResourceName:      sanitizeFilename(handler.Filename),
+		Title:             r.FormValue("title"),
+		Description:       r.FormValue("description"),
+		Tags:              normalizeTags(tags),
+		BoundaryPartnerId: r.FormValue("boundary_partner_id"),
+		ProgressMarkerId:  r.FormValue("progress_marker_id"),
+		JournalId:         r.FormValue("journal_id"),
+	}
+	if errs := exr.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
 		return
 	}
-
-	strategyId := mux.Vars(r)["strategyId"]
-	_, err = pg.Exec("DELETE FROM strategies WHERE strategy_id = $1", strategyId)
+	if !s.checkAttachment(w, r, exr) {
+		return
+	}
+	existing, found, err := s.sameNameResource(tenantOf(r), exr)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
-	JSON(w, http.StatusOK, Response{nil, "success"})
-}
-
-func getExternalResource(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, USER).(User)
-	if user.IsAdmin == false {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	stored, ok := s.storeUpload(w, tenantOf(r), file, exr.ResourceName, handler.Size, "resource_file")
+	if !ok {
 		return
 	}
-
-	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
+	if found {
+		s.addVersion(w, r, user, projectId, existing.ResourceId, uploadedVersion(exr.ResourceName, stored), stored.unpin)
 		return
 	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+	exr.StorageKey = stored.Key
+	exr.Size = stored.Size
+	exr.SHA256 = stored.SHA256
+	exr.ContentType = stored.ContentType
+	exr.ScanStatus = stored.ScanStatus
+	resourceId, err := s.store.AddExternalResource(tenantOf(r), user.UserId, exr)
+	stored.unpin()
+	if err != nil {
+		s.releaseBlob(tenantOf(r), stored.Key)
+		respondError(w, err)
 		return
 	}
+	if stored.ScanStatus == SCAN_CLEAN {
+		if added, err := s.store.GetExternalResource(tenantOf(r), projectId, resourceId); err == nil {
+			s.publish(r, EVENT_RESOURCE_UPLOADED, map[string]interface{}{"resource": added})
+		}
+	}
 
-	resources := []ExternalResources{}
+	respondScanStatus(w, stored.ScanStatus, nil)
+}
 
-	rows, err := pg.Query("SELECT resource_id, resource_url, resource_name from external_resources where project_id = $1", projectId)
+// sameNameResource finds the file of a project with the name and attachment
+// of exr.
+func (s *Server) sameNameResource(t Tenant, exr ExternalResources) (ExternalResources, bool, error) {
+	resources, err := s.store.GetExternalResources(t, exr.ProjectId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
+		return ExternalResources{}, false, err
 	}
-
-	for rows.Next() {
-		exr := ExternalResources{}
-		err = rows.Scan(&exr.ResourceId, &exr.ResourceUrl, &exr.ResourceName)
-		if err != nil {
-			rows.Close()
-			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-			return
+	for _, res := range resources {
+		if res.ResourceType == RESOURCE_FILE && res.ResourceName == exr.ResourceName && res.BoundaryPartnerId == exr.BoundaryPartnerId &&
+			res.ProgressMarkerId == exr.ProgressMarkerId && res.JournalId == exr.JournalId {
+			return res, true, nil
 		}
-		resources = append(resources, exr)
 	}
-
-	JSON(w, http.StatusOK, Response{resources, "success"})
+	return ExternalResources{}, false, nil
 }
 
-// TODO how to handle an uploaded file that share the same name with an existing file.
-func uploadResourceFile(w http.ResponseWriter, r *http.Request) {
+// getQuarantinedResources lists the uploads held back by the virus scanner,
+// so an admin can review and delete them.
+func (s *Server) getQuarantinedResources(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1450,62 +1258,53 @@ func uploadResourceFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
+	resources, err := s.store.GetQuarantinedResources(tenantOf(r), projectId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	r.ParseMultipartForm(10 * int64(1e6)) // grab the multipart form
-	if r.MultipartForm == nil {
-		JSON(w, http.StatusBadRequest, Response{nil, "request must be multipart/form-data"})
+		respondError(w, err)
 		return
 	}
 
-	file, handler, err := r.FormFile("resource_file") // grab the filenames
+	JSON(w, http.StatusOK, Response{resources, "success"})
+}
 
-	if err != nil {
-		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+// releaseBlob deletes a blob once no resource or logo of the tenant refers to
+// it any more. Uploads are deduplicated, so the same key can be shared. Errors
+// are only logged, the database is already consistent at this point. A
+// caller that pinned the blob has to unpin it first.
+func (s *Server) releaseBlob(t Tenant, key string) {
+	if key == "" {
 		return
 	}
-	defer file.Close()
-
-	url, err := uploadFile(file, handler.Filename, projectId)
+	err := s.store.DeleteUnusedBlob(t, key, func() error {
+		return s.blobs.Delete(key)
+	})
 	if err != nil {
-		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
-		return
+		warnf("deleting blob %s: %v", key, err)
 	}
+}
 
-	_, err = pg.Exec("INSERT INTO external_resources (resource_id, project_id, resource_url, resource_name, created_by) VALUES ($1, $2, $3, $4, $5)",
-		uuid.NewV1().String(), projectId, url, handler.Filename, user.UserId)
+// putPinned puts a file in the blob store with its blob pinned. It has to be
+// pinned before it is put, or a concurrent releaseBlob that found it
+// unreferenced could delete it right after. The caller unpins the blob once
+// it is referenced, or before it releases it.
+func (s *Server) putPinned(t Tenant, file io.ReadSeeker) (BlobInfo, func(), error) {
+	sum, err := hashFile(file)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
+		return BlobInfo{}, nil, err
 	}
-
-	JSON(w, http.StatusOK, Response{nil, "success"})
-}
-
-func uploadFile(file io.ReadSeeker, name string, project_id string) (string, error) {
-	if _, err := os.Stat("./temp_file/" + project_id); os.IsNotExist(err) {
-		os.Mkdir("./temp_file/"+project_id, 0777)
+	unpin, err := s.store.PinBlob(t, blobKey(t.OrganizationId, sum), time.Now().Add(BLOB_PIN_TTL))
+	if err != nil {
+		return BlobInfo{}, nil, err
 	}
-
-	f, err := os.OpenFile("./temp_file/"+project_id+"/"+name, os.O_WRONLY|os.O_CREATE, 0666)
+	blob, err := s.blobs.Put(t.OrganizationId, file)
 	if err != nil {
-		return "", err
+		unpin()
+		return BlobInfo{}, nil, err
 	}
-	defer f.Close()
-	io.Copy(f, file)
-	return "./temp_file/" + project_id + "/" + name, nil
+	return blob, unpin, nil
 }
 
-func deleteReasourceFile(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteReasourceFile(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, USER).(User)
 	if user.IsAdmin == false {
 		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
@@ -1513,103 +1312,266 @@ func deleteReasourceFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	projectId := mux.Vars(r)["projectId"]
-	projectOrganizationId, err := dbGetProjectOrganization(projectId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	// check that the current user and the project is for the same organization
-	if projectOrganizationId != user.OrganizationId {
-		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
-		return
-	}
-
-	var file_path string
-
 	resourceId := mux.Vars(r)["resourceId"]
 
-	err = pg.QueryRow("SELECT resource_url FROM external_resources WHERE resource_id = $1", resourceId).Scan(&file_path)
+	versions, err := s.store.GetResourceVersions(tenantOf(r), projectId, resourceId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
-
-	err = os.Remove(file_path)
+	exr, err := s.store.DeleteExternalResource(tenantOf(r), projectId, resourceId)
 	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		respondError(w, err)
 		return
 	}
 
-	_, err = pg.Exec("DELETE FROM external_resources WHERE resource_id = $1", resourceId)
-	if err != nil {
-		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
-		return
+	s.releaseBlob(tenantOf(r), exr.StorageKey)
+	for _, v := range versions {
+		s.releaseBlob(tenantOf(r), v.StorageKey)
 	}
 
 	JSON(w, http.StatusOK, Response{nil, "success"})
 }
 
-func main() {
-	userSessions = NewSessionStorage()
+// Router returns the HTTP routes of the API.
+func (s *Server) Router() *mux.Router {
 	router := mux.NewRouter()
 	router.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
 	router.HandleFunc("/", index).Methods(GET)
 
 	// login
-	router.HandleFunc("/login", login).Methods(POST)
+	router.HandleFunc("/login", s.login).Methods(POST)
 
 	// projects
-	router.HandleFunc("/projects", authenticate(getProjects)).Methods(GET)
-	router.HandleFunc("/projects/add", authenticate(addProject)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_name", authenticate(updateProjectName)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_logo", authenticate(updateProjectLogo)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_description", authenticate(updateProjectDescription)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_budget", authenticate(updateProjectBudget)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_timeline", authenticate(updateProjectTimeline)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_donor", authenticate(updateProjectDonor)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_mission", authenticate(updateProjectMission)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/update/project_vision", authenticate(updateProjectVision)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/delete/project", authenticate(deleteProject)).Methods(DELETE)
-	router.HandleFunc("/projects/{projectId}/reset/project_name", authenticate(resetProjectName)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_logo", authenticate(resetProjectLogo)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_description", authenticate(resetProjectDescription)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_budget", authenticate(resetProjectBudget)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_timeline", authenticate(resetProjectTimeline)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_donor", authenticate(resetProjectDonor)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_mission", authenticate(resetProjectMission)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/reset/project_vision", authenticate(resetProjectVision)).Methods(POST)
+	router.HandleFunc("/projects", s.authenticate(s.getProjects)).Methods(GET)
+	router.HandleFunc("/projects/add", s.authenticate(s.addProject)).Methods(POST)
+	router.HandleFunc("/projects/import", s.authenticate(s.importProject)).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/dashboard", s.authenticate(s.checkOwnership(s.getProjectDashboard))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/report", s.authenticate(s.checkOwnership(s.getProjectReport))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/export", s.authenticate(s.checkOwnership(s.exportProject))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/spreadsheet", s.authenticate(s.checkOwnership(s.exportSpreadsheet))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/spreadsheet", s.authenticate(s.checkOwnership(s.importSpreadsheet))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_name", s.authenticate(s.checkOwnership(s.updateProjectName))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_logo", s.authenticate(s.checkOwnership(s.updateProjectLogo))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_description", s.authenticate(s.checkOwnership(s.updateProjectDescription))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_budget", s.authenticate(s.checkOwnership(s.updateProjectBudget))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_currency", s.authenticate(s.checkOwnership(s.updateProjectCurrency))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_timeline", s.authenticate(s.checkOwnership(s.updateProjectTimeline))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_donor", s.authenticate(s.checkOwnership(s.updateProjectDonor))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_mission", s.authenticate(s.checkOwnership(s.updateProjectMission))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/update/project_vision", s.authenticate(s.checkOwnership(s.updateProjectVision))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/delete/project", s.authenticate(s.checkOwnership(s.deleteProject))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/reset/project_name", s.authenticate(s.checkOwnership(s.resetProjectName))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/logo/{variant}", s.authenticate(s.checkOwnership(s.getProjectLogo))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/reset/project_logo", s.authenticate(s.checkOwnership(s.resetProjectLogo))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/reset/project_description", s.authenticate(s.checkOwnership(s.resetProjectDescription))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/reset/project_budget", s.authenticate(s.checkOwnership(s.resetProjectBudget))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/reset/project_timeline", s.authenticate(s.checkOwnership(s.resetProjectTimeline))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/reset/project_donor", s.authenticate(s.checkOwnership(s.resetProjectDonor))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/reset/project_mission", s.authenticate(s.checkOwnership(s.resetProjectMission))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/reset/project_vision", s.authenticate(s.checkOwnership(s.resetProjectVision))).Methods(POST)
+
+	// changes to the parts of a project and who views it, as server-sent events
+	router.HandleFunc("/projects/{projectId}/stream", s.authenticate(s.checkOwnership(s.getProjectStream))).Methods(GET)
 
 	// project boundary partners
-	router.HandleFunc("/projects/{projectId}/add_boundary_partner", authenticate(addBoundaryPartner)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/get", authenticate(getBoundaryPartner)).Methods(GET)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/get2", authenticate(getBoundaryPartner1)).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/add_boundary_partner", s.authenticate(s.checkOwnership(s.addBoundaryPartner))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/get", s.authenticate(s.checkOwnership(s.getBoundaryPartner))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/get2", s.authenticate(s.checkOwnership(s.getBoundaryPartner))).Methods(GET)
 
 	// boundary partner's progress markers
-	router.HandleFunc("/projects/{projectId}/{partnerId}/add_progress_marker", authenticate(addProgressMarker)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/{progressMarkerId}/add_challenge", authenticate(addChallenge)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/{progressMarkerId}/add_strategy", authenticate(addStrategy)).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/add_progress_marker", s.authenticate(s.checkOwnership(s.addProgressMarker))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/{progressMarkerId}/add_challenge", s.authenticate(s.checkOwnership(s.addChallenge))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/{progressMarkerId}/add_strategy", s.authenticate(s.checkOwnership(s.addStrategy))).Methods(POST)
 
 	// update boundary partner
-	router.HandleFunc("/projects/{projectId}/{partnerId}/update/partner_name", authenticate(updatePartnerName)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/update/outcome_statement", authenticate(updateOutcomeStatement)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/{progressMarkerId}/update/progressMarker", authenticate(updateProgressMarker)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/{challengeId}/update/challenge", authenticate(updateChallenge)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/{strategyId}/update/strategy", authenticate(updateStrategy)).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/update/partner_name", s.authenticate(s.checkOwnership(s.updatePartnerName))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/update/outcome_statement", s.authenticate(s.checkOwnership(s.updateOutcomeStatement))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/{progressMarkerId}/update/progressMarker", s.authenticate(s.checkOwnership(s.updateProgressMarker))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/{challengeId}/update/challenge", s.authenticate(s.checkOwnership(s.updateChallenge))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/{strategyId}/update/strategy", s.authenticate(s.checkOwnership(s.updateStrategy))).Methods(POST)
 
 	// delete boundary partner
-	router.HandleFunc("/projects/{projectId}/{partnerId}/delete/partner", authenticate(deleteBoundaryPartner)).Methods(DELETE)
-	router.HandleFunc("/projects/{projectId}/{partnerId}/reset/outcome_statement", authenticate(resetOutcomeStatement)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{progressMarkerId}/delete/progress_marker", authenticate(deleteProgressMarker)).Methods(DELETE)
-	router.HandleFunc("/projects/{projectId}/{challengeId}/delete/challenge", authenticate(deleteChallenge)).Methods(DELETE)
-	router.HandleFunc("/projects/{projectId}/{strategyId}/delete/strategy", authenticate(deleteStrategy)).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/delete/partner", s.authenticate(s.checkOwnership(s.deleteBoundaryPartner))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/{partnerId}/reset/outcome_statement", s.authenticate(s.checkOwnership(s.resetOutcomeStatement))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{progressMarkerId}/delete/progress_marker", s.authenticate(s.checkOwnership(s.deleteProgressMarker))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/{challengeId}/delete/challenge", s.authenticate(s.checkOwnership(s.deleteChallenge))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/{strategyId}/delete/strategy", s.authenticate(s.checkOwnership(s.deleteStrategy))).Methods(DELETE)
 
 	// external resources
-	router.HandleFunc("/projects/{projectId}/resource", authenticate(getExternalResource)).Methods(GET)
-	router.HandleFunc("/projects/{projectId}/resource_uploadfile", authenticate(uploadResourceFile)).Methods(POST)
-	router.HandleFunc("/projects/{projectId}/{resourceId}/delete/resource_file", authenticate(deleteReasourceFile)).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/resource", s.authenticate(s.checkOwnership(s.getExternalResource))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/resources/quarantined", s.authenticate(s.checkOwnership(s.getQuarantinedResources))).Methods(GET)
+	router.HandleFunc("/resources/rescan", s.authenticate(s.rescanResources)).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/resources/links", s.authenticate(s.checkOwnership(s.addResourceLink))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/resource_uploadfile", s.authenticate(s.checkOwnership(s.uploadResourceFile))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/{resourceId}/delete/resource_file", s.authenticate(s.checkOwnership(s.deleteReasourceFile))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/resources/{resourceId}/content", s.authenticate(s.checkOwnership(s.getResourceContent))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/resources/{resourceId}/versions", s.authenticate(s.checkOwnership(s.getResourceVersions))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/resources/{resourceId}/versions", s.authenticate(s.checkOwnership(s.uploadResourceVersion))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/resources/{resourceId}/versions/{version:[0-9]+}/content", s.authenticate(s.checkOwnership(s.getResourceVersionContent))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/resources/{resourceId}/versions/{version:[0-9]+}/rollback", s.authenticate(s.checkOwnership(s.rollbackResource))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/resources/{resourceId}/share", s.authenticate(s.checkOwnership(s.shareResource))).Methods(POST)
+
+	// outcome journals
+	router.HandleFunc("/projects/{projectId}/journals", s.authenticate(s.checkOwnership(s.getJournals))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/journals", s.authenticate(s.checkOwnership(s.addJournal))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/journals/{journalId}", s.authenticate(s.checkOwnership(s.getJournal))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/journals/{journalId}", s.authenticate(s.checkOwnership(s.updateJournal))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/journals/{journalId}", s.authenticate(s.checkOwnership(s.deleteJournal))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/journals/{journalId}/submit", s.authenticate(s.checkOwnership(s.submitJournal))).Methods(POST)
+
+	// project grants
+	router.HandleFunc("/projects/{projectId}/grants", s.authenticate(s.checkOwnership(s.getGrants))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/grants", s.authenticate(s.checkOwnership(s.addGrant))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/grants/{grantId}", s.authenticate(s.checkOwnership(s.updateGrant))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/grants/{grantId}", s.authenticate(s.checkOwnership(s.deleteGrant))).Methods(DELETE)
+
+	// project budgets
+	router.HandleFunc("/projects/{projectId}/budget_lines", s.authenticate(s.checkOwnership(s.getBudgetLines))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/budget_lines", s.authenticate(s.checkOwnership(s.addBudgetLine))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/budget_lines/{budgetLineId}", s.authenticate(s.checkOwnership(s.updateBudgetLine))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/budget_lines/{budgetLineId}", s.authenticate(s.checkOwnership(s.deleteBudgetLine))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/expenditures", s.authenticate(s.checkOwnership(s.getExpenditures))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/expenditures", s.authenticate(s.checkOwnership(s.addExpenditure))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/expenditures/{expenditureId}", s.authenticate(s.checkOwnership(s.updateExpenditure))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/expenditures/{expenditureId}", s.authenticate(s.checkOwnership(s.deleteExpenditure))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/budget_report", s.authenticate(s.checkOwnership(s.getBudgetReport))).Methods(GET)
+
+	// project activities, milestones and monitoring dates
+	router.HandleFunc("/projects/{projectId}/activities", s.authenticate(s.checkOwnership(s.getActivities))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/activities", s.authenticate(s.checkOwnership(s.addActivity))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/activities/{activityId}", s.authenticate(s.checkOwnership(s.getActivity))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/activities/{activityId}", s.authenticate(s.checkOwnership(s.updateActivity))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/activities/{activityId}", s.authenticate(s.checkOwnership(s.deleteActivity))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/gantt", s.authenticate(s.checkOwnership(s.getGantt))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/calendar.ics", s.authenticate(s.checkOwnership(s.getCalendar))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/calendar/link", s.authenticate(s.checkOwnership(s.getCalendarLink))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/calendar/link", s.authenticate(s.checkOwnership(s.rotateCalendarLink))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/calendar/link", s.authenticate(s.checkOwnership(s.revokeCalendarLink))).Methods(DELETE)
+
+	// tasks on progress markers, challenges and strategies
+	router.HandleFunc("/projects/{projectId}/tasks", s.authenticate(s.checkOwnership(s.getTasks))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/tasks", s.authenticate(s.checkOwnership(s.addTask))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}", s.authenticate(s.checkOwnership(s.getTask))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}", s.authenticate(s.checkOwnership(s.updateTask))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}", s.authenticate(s.checkOwnership(s.deleteTask))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/status", s.authenticate(s.checkOwnership(s.updateTaskStatus))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/comments", s.authenticate(s.checkOwnership(s.getTaskComments))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/tasks/{taskId}/comments", s.authenticate(s.checkOwnership(s.addTaskComment))).Methods(POST)
+	router.HandleFunc("/tasks/mine", s.authenticate(s.getMyTasks)).Methods(GET)
+	router.HandleFunc("/tasks/overdue", s.authenticate(s.getOverdueTasks)).Methods(GET)
+
+	// comment threads on projects and their parts
+	router.HandleFunc("/projects/{projectId}/comments", s.authenticate(s.checkOwnership(s.getComments))).Methods(GET)
+	router.HandleFunc("/projects/{projectId}/comments", s.authenticate(s.checkOwnership(s.addComment))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/comments/{commentId}", s.authenticate(s.checkOwnership(s.updateComment))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/comments/{commentId}", s.authenticate(s.checkOwnership(s.deleteComment))).Methods(DELETE)
+	router.HandleFunc("/projects/{projectId}/comments/{commentId}/resolve", s.authenticate(s.checkOwnership(s.resolveComment))).Methods(POST)
+	router.HandleFunc("/projects/{projectId}/comments/{commentId}/unresolve", s.authenticate(s.checkOwnership(s.unresolveComment))).Methods(POST)
+
+	// the caller's notifications and how they want to be told
+	router.HandleFunc("/notifications", s.authenticate(s.getNotifications)).Methods(GET)
+	router.HandleFunc("/notifications/read", s.authenticate(s.markAllNotificationsRead)).Methods(POST)
+	router.HandleFunc("/notifications/preferences", s.authenticate(s.getNotificationPreferences)).Methods(GET)
+	router.HandleFunc("/notifications/preferences", s.authenticate(s.updateNotificationPreferences)).Methods(POST)
+	router.HandleFunc("/notifications/{notificationId}/read", s.authenticate(s.markNotificationRead)).Methods(POST)
+
+	// full text search over the organization's projects, grant officers
+	// search the projects of their donor
+	router.HandleFunc("/search", s.authenticateDonorView(s.search)).Methods(GET)
+
+	// donors and their grant officers, who see the projects the donor funds
+	router.HandleFunc("/donors", s.authenticate(s.getDonors)).Methods(GET)
+	router.HandleFunc("/donors", s.authenticate(s.addDonor)).Methods(POST)
+	router.HandleFunc("/donors/{donorId}", s.authenticate(s.getDonor)).Methods(GET)
+	router.HandleFunc("/donors/{donorId}", s.authenticate(s.updateDonor)).Methods(POST)
+	router.HandleFunc("/donors/{donorId}", s.authenticate(s.deleteDonor)).Methods(DELETE)
+	router.HandleFunc("/donors/{donorId}/grant_officers", s.authenticate(s.getGrantOfficers)).Methods(GET)
+	router.HandleFunc("/donors/{donorId}/grant_officers", s.authenticate(s.addGrantOfficer)).Methods(POST)
+	router.HandleFunc("/donors/{donorId}/grant_officers/{userId}", s.authenticate(s.removeGrantOfficer)).Methods(DELETE)
+	router.HandleFunc("/donors/{donorId}/projects", s.authenticateDonorView(s.getDonorProjects)).Methods(GET)
+	router.HandleFunc("/donors/{donorId}/projects/{projectId}", s.authenticateDonorView(s.getDonorProject)).Methods(GET)
+
+	// the organization's branding on reports
+	router.HandleFunc("/organization", s.authenticate(s.getOrganization)).Methods(GET)
+	router.HandleFunc("/organization", s.authenticate(s.updateOrganization)).Methods(POST)
+	router.HandleFunc("/organization/logo", s.authenticate(s.updateOrganizationLogo)).Methods(POST)
+	router.HandleFunc("/organization/logo", s.authenticate(s.resetOrganizationLogo)).Methods(DELETE)
+	router.HandleFunc("/organization/logo/{variant}", s.authenticate(s.getOrganizationLogo)).Methods(GET)
+	router.HandleFunc("/organization/dashboard", s.authenticate(s.getOrganizationDashboard)).Methods(GET)
+	router.HandleFunc("/organization/report_templates/{format}", s.authenticate(s.getReportTemplate)).Methods(GET)
+	router.HandleFunc("/organization/report_templates/{format}", s.authenticate(s.setReportTemplate)).Methods(POST)
+	router.HandleFunc("/organization/report_templates/{format}", s.authenticate(s.resetReportTemplate)).Methods(DELETE)
+
+	// webhooks the organization's events are posted to and their deliveries
+	router.HandleFunc("/webhooks", s.authenticate(s.getWebhooks)).Methods(GET)
+	router.HandleFunc("/webhooks", s.authenticate(s.addWebhook)).Methods(POST)
+	router.HandleFunc("/webhooks/{webhookId}", s.authenticate(s.getWebhook)).Methods(GET)
+	router.HandleFunc("/webhooks/{webhookId}", s.authenticate(s.updateWebhook)).Methods(POST)
+	router.HandleFunc("/webhooks/{webhookId}", s.authenticate(s.deleteWebhook)).Methods(DELETE)
+	router.HandleFunc("/webhooks/{webhookId}/deliveries", s.authenticate(s.getWebhookDeliveries)).Methods(GET)
+	router.HandleFunc("/webhooks/{webhookId}/deliveries/{deliveryId}/redeliver", s.authenticate(s.redeliverWebhook)).Methods(POST)
+
+	// share links and calendar feeds, authorized by the signed token instead of an API key
+	router.HandleFunc("/shared/resources/{token}", s.getSharedResource).Methods(GET)
+	router.HandleFunc("/shared/calendars/{token}.ics", s.getSharedCalendar).Methods(GET)
+
+	return router
+}
+
+func main() {
+	config, args, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalln("config:", err)
+	}
+	setLogLevel(config.LogLevel)
+	db := connectPostgres(config.Database)
 
-	connectPostgres()
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = runMigrateCommand(db, args[1:])
+		case "seed":
+			err = seed(db)
+		default:
+			err = errors.New("unknown command " + args[0] + ", expected migrate or seed")
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if config.Database.AutoMigrate {
+		done, err := migrateUp(db)
+		if err != nil {
+			log.Fatalln("migrate:", err)
+		}
+		for _, version := range done {
+			infof("applied migration %d", version)
+		}
+	}
+
+	blobs, err := newBlobStore(config)
+	if err != nil {
+		log.Fatalln("storage:", err)
+	}
+
+	server := NewServer(config, NewPostgresStore(db), blobs)
+	// the streams of a project reach the clients connected to any instance
+	server.streams = NewStreamHub(NewPostgresBus(db, config.Database.DSN))
+	go server.streams.Heartbeat()
+	if config.Jobs.Enabled {
+		go NewJobRunner(server.store, server.jobs(), time.Duration(config.Jobs.PollInterval)).Run()
+	}
 	n := negroni.New()
-	n.UseHandler(router)
-	n.Run(LISTEN_ADDR)
+	n.UseHandler(server.Router())
+
+	infof("listening on %s", config.ListenAddr)
+	if config.TLS.CertFile != "" {
+		err = http.ListenAndServeTLS(config.ListenAddr, config.TLS.CertFile, config.TLS.KeyFile, n)
+	} else {
+		err = http.ListenAndServe(config.ListenAddr, n)
+	}
+	log.Fatal(err)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const form = "application/x-www-form-urlencoded"
+
+func testConfig() *Config {
+	config := defaultConfig()
+	config.AuthSecret = strings.Repeat("s", 40)
+	return config
+}
+
+func testBlobs(t *testing.T) BlobStore {
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs
+}
+
+// testServer serves the API on a test store.
+type testServer struct {
+	*Server
+	t   *testing.T
+	srv *httptest.Server
+}
+
+// newTestServer serves the API on a memory store with the test users.
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerOn(t, newTestMemoryStore(t))
+}
+
+func newTestServerOn(t *testing.T, store Store) *testServer {
+	server := NewServer(testConfig(), store, testBlobs(t))
+	srv := httptest.NewServer(server.Router())
+	t.Cleanup(srv.Close)
+	return &testServer{server, t, srv}
+}
+
+// login returns the API key of a test user.
+func (ts *testServer) login(email string) string {
+	resp, err := http.PostForm(ts.srv.URL+"/login", url.Values{"email": {email}, "password": {TEST_PASSWORD}})
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r struct{ Data string }
+	json.NewDecoder(resp.Body).Decode(&r)
+	if r.Data == "" {
+		ts.t.Fatal("login failed for " + email)
+	}
+	return r.Data
+}
+
+// do sends a request with an API key and returns the status and the decoded
+// JSON response.
+func (ts *testServer) do(key, method, path, contentType, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, ts.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", key)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// upload posts a file as a multipart form.
+func (ts *testServer) upload(key, path, field, name, content string) (int, map[string]interface{}) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile(field, name)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	mw.Close()
+	return ts.do(key, "POST", path, mw.FormDataContentType(), buf.String())
+}
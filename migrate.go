@@ -0,0 +1,274 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// advisory lock held while migrations are applied, so that instances
+// starting at the same time do not race each other
+const MIGRATION_LOCK_ID = 7321400
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+//go:embed seeds/*.sql
+var seedFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// loadMigrations reads the embedded migrations ordered by version. Every
+// migration needs both an up and a down script.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down script", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrationTx runs fn in a transaction holding the migration lock. The
+// schema_migrations table is created on first use.
+func migrationTx(db *sqlx.DB, fn func(tx *sqlx.Tx, applied map[int]time.Time) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", MIGRATION_LOCK_ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		  version    INTEGER PRIMARY KEY,
+		  name       VARCHAR NOT NULL,
+		  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := tx.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = appliedAt
+	}
+	rows.Close()
+
+	if err = fn(tx, applied); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateUp applies all pending migrations in one transaction and returns
+// the versions that were applied.
+func migrateUp(db *sqlx.DB) ([]int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []int
+	err = migrationTx(db, func(tx *sqlx.Tx, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			if _, exists := applied[m.version]; exists {
+				continue
+			}
+			if _, err := tx.Exec(m.up); err != nil {
+				return fmt.Errorf("migration %d_%s: %v", m.version, m.name, err)
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+			if err != nil {
+				return err
+			}
+			done = append(done, m.version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// migrateDown rolls back the most recently applied migration and returns its
+// version, or 0 if there was nothing to roll back.
+func migrateDown(db *sqlx.DB) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	err = migrationTx(db, func(tx *sqlx.Tx, applied map[int]time.Time) error {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, exists := applied[m.version]; !exists {
+				continue
+			}
+			if _, err := tx.Exec(m.down); err != nil {
+				return fmt.Errorf("migration %d_%s: %v", m.version, m.name, err)
+			}
+			if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.version); err != nil {
+				return err
+			}
+			version = m.version
+			return nil
+		}
+		return nil
+	})
+	return version, err
+}
+
+func migrationStatus(db *sqlx.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var status []MigrationStatus
+	err = migrationTx(db, func(tx *sqlx.Tx, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			s := MigrationStatus{Version: m.version, Name: m.name}
+			if appliedAt, exists := applied[m.version]; exists {
+				s.AppliedAt = &appliedAt
+			}
+			status = append(status, s)
+		}
+		return nil
+	})
+	return status, err
+}
+
+// seed loads the embedded seed data. Seeding is opt-in and never part of a
+// migration, so production databases only get data that was asked for.
+func seed(db *sqlx.DB) error {
+	entries, err := seedFiles.ReadDir("seeds")
+	if err != nil {
+		return err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, entry := range entries {
+		data, err := seedFiles.ReadFile(path.Join("seeds", entry.Name()))
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(string(data)); err != nil {
+			return fmt.Errorf("seed %s: %v", entry.Name(), err)
+		}
+		infof("seeded %s", entry.Name())
+	}
+	return tx.Commit()
+}
+
+// runMigrateCommand implements `lucid migrate [up|down|status]`.
+func runMigrateCommand(db *sqlx.DB, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		done, err := migrateUp(db)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("database is up to date")
+		}
+		for _, version := range done {
+			fmt.Printf("applied migration %d\n", version)
+		}
+	case "down":
+		version, err := migrateDown(db)
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			fmt.Println("no migration to roll back")
+		} else {
+			fmt.Printf("rolled back migration %d\n", version)
+		}
+	case "status":
+		status, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/satori/go.uuid"
+)
+
+// TestLoadMigrations checks that the embedded migrations are numbered
+// without gaps and can all be rolled back.
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	check(t, err)
+	if len(migrations) == 0 {
+		t.Fatal("no migrations")
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d_%s: expected version %d", m.version, m.name, i+1)
+		}
+	}
+}
+
+// publicTables returns the tables in the public schema.
+func publicTables(t *testing.T, db *sqlx.DB) []string {
+	t.Helper()
+	var tables []string
+	check(t, db.Select(&tables, "SELECT tablename FROM pg_tables WHERE schemaname = 'public' ORDER BY tablename"))
+	return tables
+}
+
+// TestMigrateUpDown applies every migration from two instances at once, then
+// rolls them all back one by one and applies them again.
+func TestMigrateUpDown(t *testing.T) {
+	db := newTestDatabase(t)
+	migrations, err := loadMigrations()
+	check(t, err)
+
+	var wg sync.WaitGroup
+	applied := make([][]int, 2)
+	errs := make([]error, 2)
+	for i := range applied {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			applied[i], errs[i] = migrateUp(db)
+		}(i)
+	}
+	wg.Wait()
+	check(t, errs[0])
+	check(t, errs[1])
+	if len(applied[0])+len(applied[1]) != len(migrations) || (len(applied[0]) != 0 && len(applied[1]) != 0) {
+		t.Fatal("both instances migrated:", applied)
+	}
+	status, err := migrationStatus(db)
+	check(t, err)
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d_%s not applied", s.Version, s.Name)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		version, err := migrateDown(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != migrations[i].version {
+			t.Fatalf("rolled back %d instead of %d", version, migrations[i].version)
+		}
+	}
+	if version, err := migrateDown(db); version != 0 || err != nil {
+		t.Fatal(version, err)
+	}
+	if tables := publicTables(t, db); !equalStrings(tables, []string{"schema_migrations"}) {
+		t.Fatal("left behind by the down migrations:", tables)
+	}
+
+	done, err := migrateUp(db)
+	check(t, err)
+	if len(done) != len(migrations) {
+		t.Fatal(done)
+	}
+	if done, err = migrateUp(db); len(done) != 0 || err != nil {
+		t.Fatal(done, err)
+	}
+}
+
+// migrateDownTo rolls back migrations until version is rolled back.
+func migrateDownTo(t *testing.T, db *sqlx.DB, version int) {
+	t.Helper()
+	for {
+		rolledBack, err := migrateDown(db)
+		check(t, err)
+		if rolledBack == version {
+			return
+		}
+		if rolledBack == 0 {
+			t.Fatalf("migration %d was not applied", version)
+		}
+	}
+}
+
+// TestMigrateDonors moves the donor column of projects into donors and
+// grants and back again. Donor names are matched by tenant, without regard to
+// case and surrounding spaces.
+func TestMigrateDonors(t *testing.T) {
+	db := newTestDatabase(t)
+	_, err := migrateUp(db)
+	check(t, err)
+	migrateDownTo(t, db, 9)
+	db.MustExec("INSERT INTO organizations (organization_id, organization_name) VALUES ($1, 'Org A'), ($2, 'Org B')", ORG_A, ORG_B)
+
+	// projects by tenant, with the donor column they start with
+	projects := map[string][][2]string{
+		ORG_A: {{uuid.NewV4().String(), "Fund A"}, {uuid.NewV4().String(), " FUND A "}, {uuid.NewV4().String(), " "}},
+		ORG_B: {{uuid.NewV4().String(), "Fund A"}},
+	}
+	inTenant := func(organizationId string, fn func(tx *sqlx.Tx)) {
+		t.Helper()
+		tx, err := db.Beginx()
+		check(t, err)
+		defer tx.Rollback()
+		tx.MustExec("SELECT set_config('lucid.organization_id', $1, TRUE)", organizationId)
+		fn(tx)
+		check(t, tx.Commit())
+	}
+	for organizationId, rows := range projects {
+		inTenant(organizationId, func(tx *sqlx.Tx) {
+			for _, p := range rows {
+				tx.MustExec("INSERT INTO projects (project_id, organization_id, project_name, donor) VALUES ($1, $2, 'P', $3)",
+					p[0], organizationId, p[1])
+			}
+		})
+	}
+
+	_, err = migrateUp(db)
+	check(t, err)
+	s := NewPostgresStore(db)
+	for organizationId, rows := range projects {
+		tenant := Tenant{organizationId}
+		donors, err := s.GetDonors(tenant)
+		check(t, err)
+		if len(donors) != 1 || !strings.EqualFold(donors[0].DonorName, "fund a") {
+			t.Fatalf("%s: %+v", organizationId, donors)
+		}
+		for _, p := range rows {
+			grants, err := s.GetGrants(tenant, p[0])
+			check(t, err)
+			want := 1
+			if strings.TrimSpace(p[1]) == "" {
+				want = 0
+			}
+			if len(grants) != want || want == 1 && (grants[0].DonorId != donors[0].DonorId || grants[0].Amount != 0 || grants[0].Currency != "USD") {
+				t.Errorf("%q: %+v", p[1], grants)
+			}
+		}
+	}
+
+	migrateDownTo(t, db, 9)
+	for organizationId, rows := range projects {
+		inTenant(organizationId, func(tx *sqlx.Tx) {
+			for _, p := range rows {
+				var donor sql.NullString
+				check(t, tx.QueryRow("SELECT donor FROM projects WHERE project_id = $1", p[0]).Scan(&donor))
+				if strings.TrimSpace(p[1]) == "" && donor.Valid || strings.TrimSpace(p[1]) != "" && !strings.EqualFold(donor.String, "fund a") {
+					t.Errorf("%q: %v", p[1], donor)
+				}
+			}
+		})
+	}
+	_, err = migrateUp(db)
+	check(t, err)
+}
@@ -0,0 +1,8 @@
+DROP TABLE strategies;
+DROP TABLE challenges;
+DROP TABLE progress_markers;
+DROP TABLE boundary_partners;
+DROP TABLE external_resources;
+DROP TABLE projects;
+DROP TABLE users;
+DROP TABLE organizations;
@@ -1,6 +1,3 @@
-DROP SCHEMA public CASCADE;
-CREATE SCHEMA public;
-
 CREATE TABLE organizations (
   organization_id   UUID PRIMARY KEY,
   organization_name VARCHAR,
@@ -79,4 +76,38 @@ CREATE TABLE strategies (
   progress_marker_id UUID REFERENCES progress_markers (progress_marker_id) ON DELETE CASCADE,
   strategy_name      VARCHAR,
   ts_created         TIMESTAMPTZ DEFAULT now()
-);
\ No newline at end of file
+);
+
+-- Row level security as a backstop for the organization scoping done by the
+-- backend: every transaction sets lucid.organization_id (see pgStore.tenantTx), and
+-- rows of other organizations are invisible to it. FORCE applies the policies
+-- to the table owner, which is the role the backend connects as.
+ALTER TABLE projects ENABLE ROW LEVEL SECURITY;
+ALTER TABLE projects FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON projects
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
+
+ALTER TABLE external_resources ENABLE ROW LEVEL SECURITY;
+ALTER TABLE external_resources FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON external_resources
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE boundary_partners ENABLE ROW LEVEL SECURITY;
+ALTER TABLE boundary_partners FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON boundary_partners
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE progress_markers ENABLE ROW LEVEL SECURITY;
+ALTER TABLE progress_markers FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON progress_markers
+  USING (boundary_partner_id IN (SELECT boundary_partner_id FROM boundary_partners));
+
+ALTER TABLE challenges ENABLE ROW LEVEL SECURITY;
+ALTER TABLE challenges FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON challenges
+  USING (progress_marker_id IN (SELECT progress_marker_id FROM progress_markers));
+
+ALTER TABLE strategies ENABLE ROW LEVEL SECURITY;
+ALTER TABLE strategies FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON strategies
+  USING (progress_marker_id IN (SELECT progress_marker_id FROM progress_markers));
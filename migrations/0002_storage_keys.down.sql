@@ -0,0 +1,3 @@
+DROP TABLE blob_pins;
+ALTER TABLE projects DROP COLUMN logo_key;
+ALTER TABLE external_resources DROP COLUMN storage_key;
@@ -0,0 +1,20 @@
+-- keys of the files kept in the blob store
+ALTER TABLE external_resources ADD COLUMN storage_key VARCHAR;
+ALTER TABLE projects ADD COLUMN logo_key VARCHAR;
+
+-- blobs an upload has stored but not referenced yet, which must not be
+-- deleted meanwhile. A pin left by an instance that went away lapses at
+-- pinned_until.
+CREATE TABLE blob_pins (
+  pin_id          UUID PRIMARY KEY,
+  organization_id UUID        NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  storage_key     VARCHAR     NOT NULL,
+  pinned_until    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX ON blob_pins (storage_key);
+
+ALTER TABLE blob_pins ENABLE ROW LEVEL SECURITY;
+ALTER TABLE blob_pins FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON blob_pins
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
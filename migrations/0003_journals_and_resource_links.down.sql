@@ -0,0 +1,13 @@
+DROP INDEX external_resources_tags;
+ALTER TABLE external_resources
+  DROP COLUMN resource_type,
+  DROP COLUMN title,
+  DROP COLUMN description,
+  DROP COLUMN tags,
+  DROP COLUMN favicon_url,
+  DROP COLUMN boundary_partner_id,
+  DROP COLUMN progress_marker_id,
+  DROP COLUMN journal_id;
+
+DROP TABLE journal_ratings;
+DROP TABLE outcome_journals;
@@ -0,0 +1,53 @@
+-- outcome journals: the changes observed in a boundary partner up to a
+-- monitoring date, with a rating of its progress markers
+CREATE TABLE outcome_journals (
+  journal_id            UUID PRIMARY KEY,
+  project_id            UUID        NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  boundary_partner_id   UUID        NOT NULL REFERENCES boundary_partners (boundary_partner_id) ON DELETE CASCADE,
+  monitoring_date       DATE        NOT NULL,
+  description_of_change TEXT,
+  contributing_factors  TEXT,
+  sources_of_evidence   TEXT,
+  unanticipated_change  TEXT,
+  lessons               TEXT,
+  status                VARCHAR     NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'submitted')),
+  submitted_at          TIMESTAMPTZ,
+  submitted_by          UUID REFERENCES users (user_id),
+  created_by            UUID REFERENCES users (user_id),
+  ts_created            TIMESTAMPTZ DEFAULT now(),
+  UNIQUE (boundary_partner_id, monitoring_date)
+);
+
+CREATE INDEX ON outcome_journals (project_id, monitoring_date);
+
+CREATE TABLE journal_ratings (
+  journal_id         UUID    NOT NULL REFERENCES outcome_journals (journal_id) ON DELETE CASCADE,
+  progress_marker_id UUID    NOT NULL REFERENCES progress_markers (progress_marker_id) ON DELETE CASCADE,
+  rating             VARCHAR NOT NULL CHECK (rating IN ('low', 'medium', 'high')),
+  PRIMARY KEY (journal_id, progress_marker_id)
+);
+
+ALTER TABLE outcome_journals ENABLE ROW LEVEL SECURITY;
+ALTER TABLE outcome_journals FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON outcome_journals
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE journal_ratings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE journal_ratings FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON journal_ratings
+  USING (journal_id IN (SELECT journal_id FROM outcome_journals));
+
+-- resources can be external links as well as uploaded files, and can be
+-- attached to a boundary partner, progress marker or outcome journal of their
+-- project
+ALTER TABLE external_resources
+  ADD COLUMN resource_type       VARCHAR NOT NULL DEFAULT 'file' CHECK (resource_type IN ('file', 'link')),
+  ADD COLUMN title               VARCHAR,
+  ADD COLUMN description         TEXT,
+  ADD COLUMN tags                VARCHAR[] NOT NULL DEFAULT '{}',
+  ADD COLUMN favicon_url         VARCHAR,
+  ADD COLUMN boundary_partner_id UUID REFERENCES boundary_partners (boundary_partner_id) ON DELETE SET NULL,
+  ADD COLUMN progress_marker_id  UUID REFERENCES progress_markers (progress_marker_id) ON DELETE SET NULL,
+  ADD COLUMN journal_id          UUID REFERENCES outcome_journals (journal_id) ON DELETE SET NULL;
+
+CREATE INDEX external_resources_tags ON external_resources USING gin (tags);
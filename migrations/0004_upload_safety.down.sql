@@ -0,0 +1,9 @@
+DROP INDEX external_resources_pending;
+
+ALTER TABLE external_resources
+  DROP COLUMN size,
+  DROP COLUMN sha256,
+  DROP COLUMN content_type,
+  DROP COLUMN scan_status;
+
+ALTER TABLE organizations DROP COLUMN storage_quota;
@@ -0,0 +1,11 @@
+-- storage quota in bytes, NULL uses the configured default
+ALTER TABLE organizations ADD COLUMN storage_quota BIGINT CHECK (storage_quota >= 0);
+
+ALTER TABLE external_resources
+  ADD COLUMN size         BIGINT,
+  ADD COLUMN sha256       VARCHAR(64),
+  ADD COLUMN content_type VARCHAR,
+  ADD COLUMN scan_status  VARCHAR NOT NULL DEFAULT 'clean' CHECK (scan_status IN ('pending', 'clean', 'quarantined'));
+
+-- files the virus scanner could not check on upload are scanned again
+CREATE INDEX external_resources_pending ON external_resources (ts_created) WHERE scan_status = 'pending';
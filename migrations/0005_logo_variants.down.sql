@@ -0,0 +1,7 @@
+ALTER TABLE projects
+  DROP COLUMN logo_medium_key,
+  DROP COLUMN logo_thumbnail_key,
+  DROP COLUMN logo_content_type,
+  DROP COLUMN logo_width,
+  DROP COLUMN logo_height,
+  ADD COLUMN logo_url VARCHAR;
@@ -0,0 +1,8 @@
+-- logos are stored as blobs in three sizes; logo_key keeps the original
+ALTER TABLE projects
+  DROP COLUMN logo_url,
+  ADD COLUMN logo_medium_key    VARCHAR,
+  ADD COLUMN logo_thumbnail_key VARCHAR,
+  ADD COLUMN logo_content_type  VARCHAR,
+  ADD COLUMN logo_width         INTEGER,
+  ADD COLUMN logo_height        INTEGER;
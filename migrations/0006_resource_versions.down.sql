@@ -0,0 +1,5 @@
+DROP INDEX resource_versions_pending;
+CREATE INDEX external_resources_pending ON external_resources (ts_created) WHERE scan_status = 'pending';
+
+ALTER TABLE external_resources DROP COLUMN current_version;
+DROP TABLE resource_versions;
@@ -0,0 +1,41 @@
+-- every upload of a file resource is kept as a version; the file columns of
+-- external_resources describe the current one
+CREATE TABLE resource_versions (
+  resource_id    UUID        NOT NULL REFERENCES external_resources (resource_id) ON DELETE CASCADE,
+  version_number INTEGER     NOT NULL,
+  resource_name  VARCHAR,
+  storage_key    VARCHAR     NOT NULL,
+  size           BIGINT,
+  sha256         VARCHAR(64),
+  content_type   VARCHAR,
+  scan_status    VARCHAR     NOT NULL CHECK (scan_status IN ('pending', 'clean', 'quarantined')),
+  created_by     UUID REFERENCES users (user_id),
+  ts_created     TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (resource_id, version_number)
+);
+
+ALTER TABLE external_resources ADD COLUMN current_version INTEGER;
+
+-- existing files become version 1; the owner is subject to the tenant
+-- policies as well, so they are lifted while the rows are copied
+ALTER TABLE projects NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE external_resources NO FORCE ROW LEVEL SECURITY;
+
+INSERT INTO resource_versions (resource_id, version_number, resource_name, storage_key, size, sha256, content_type, scan_status, created_by, ts_created)
+SELECT resource_id, 1, resource_name, storage_key, size, sha256, content_type, scan_status, created_by, coalesce(ts_created, now())
+FROM external_resources
+WHERE storage_key IS NOT NULL;
+
+UPDATE external_resources SET current_version = 1 WHERE storage_key IS NOT NULL;
+
+ALTER TABLE projects FORCE ROW LEVEL SECURITY;
+ALTER TABLE external_resources FORCE ROW LEVEL SECURITY;
+
+ALTER TABLE resource_versions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE resource_versions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON resource_versions
+  USING (resource_id IN (SELECT resource_id FROM external_resources));
+
+-- pending scans are looked up per version now
+DROP INDEX external_resources_pending;
+CREATE INDEX resource_versions_pending ON resource_versions (ts_created) WHERE scan_status = 'pending';
@@ -0,0 +1,10 @@
+ALTER TABLE organizations
+  DROP COLUMN logo_key,
+  DROP COLUMN logo_medium_key,
+  DROP COLUMN logo_thumbnail_key,
+  DROP COLUMN logo_content_type,
+  DROP COLUMN logo_width,
+  DROP COLUMN logo_height,
+  DROP COLUMN brand_color;
+
+DROP TABLE report_templates;
@@ -0,0 +1,25 @@
+-- templates an organization replaced the built-in report templates with
+CREATE TABLE report_templates (
+  organization_id UUID        NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  format          VARCHAR     NOT NULL CHECK (format IN ('html', 'pdf')),
+  body            TEXT        NOT NULL,
+  updated_by      UUID REFERENCES users (user_id),
+  ts_updated      TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (organization_id, format)
+);
+
+ALTER TABLE report_templates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE report_templates FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON report_templates
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
+
+-- the organization's logo is stored like the project logos and shown on the
+-- reports next to the project logo, in the organization's color
+ALTER TABLE organizations
+  ADD COLUMN logo_key           VARCHAR,
+  ADD COLUMN logo_medium_key    VARCHAR,
+  ADD COLUMN logo_thumbnail_key VARCHAR,
+  ADD COLUMN logo_content_type  VARCHAR,
+  ADD COLUMN logo_width         INTEGER,
+  ADD COLUMN logo_height        INTEGER,
+  ADD COLUMN brand_color        VARCHAR(7) CHECK (brand_color ~ '^#[0-9a-f]{6}$');
@@ -0,0 +1,4 @@
+DROP TABLE expenditures;
+DROP TABLE budget_lines;
+ALTER TABLE projects DROP COLUMN currency;
+ALTER TABLE projects ALTER COLUMN budget TYPE NUMERIC(15, 2);
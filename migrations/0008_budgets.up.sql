@@ -0,0 +1,51 @@
+-- amounts get four decimal places for currencies with three, and projects a
+-- currency their budget lines and expenditures are converted into
+ALTER TABLE projects ALTER COLUMN budget TYPE NUMERIC(19, 4);
+ALTER TABLE projects ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+-- what the budget of a project is planned for; project_amount is the amount
+-- in the project's currency at the rate of the day the line was entered
+CREATE TABLE budget_lines (
+  budget_line_id      UUID PRIMARY KEY,
+  project_id          UUID           NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  title               VARCHAR        NOT NULL,
+  description         TEXT,
+  amount              NUMERIC(19, 4) NOT NULL CHECK (amount >= 0),
+  currency            CHAR(3)        NOT NULL,
+  project_amount      NUMERIC(19, 4) NOT NULL CHECK (project_amount >= 0),
+  boundary_partner_id UUID REFERENCES boundary_partners (boundary_partner_id) ON DELETE SET NULL,
+  strategy_id         UUID REFERENCES strategies (strategy_id) ON DELETE SET NULL,
+  created_by          UUID REFERENCES users (user_id),
+  ts_created          TIMESTAMPTZ    NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON budget_lines (project_id);
+
+-- money spent, converted at the rate of the day it was spent; expenditures
+-- of a deleted budget line stay as unallocated
+CREATE TABLE expenditures (
+  expenditure_id      UUID PRIMARY KEY,
+  project_id          UUID           NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  budget_line_id      UUID REFERENCES budget_lines (budget_line_id) ON DELETE SET NULL,
+  description         TEXT           NOT NULL,
+  amount              NUMERIC(19, 4) NOT NULL CHECK (amount > 0),
+  currency            CHAR(3)        NOT NULL,
+  project_amount      NUMERIC(19, 4) NOT NULL CHECK (project_amount >= 0),
+  spent_on            DATE           NOT NULL,
+  receipt_resource_id UUID REFERENCES external_resources (resource_id) ON DELETE SET NULL,
+  created_by          UUID REFERENCES users (user_id),
+  ts_created          TIMESTAMPTZ    NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON expenditures (project_id, spent_on);
+CREATE INDEX ON expenditures (budget_line_id);
+
+ALTER TABLE budget_lines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE budget_lines FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON budget_lines
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE expenditures ENABLE ROW LEVEL SECURITY;
+ALTER TABLE expenditures FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON expenditures
+  USING (project_id IN (SELECT project_id FROM projects));
@@ -0,0 +1,19 @@
+ALTER TABLE projects ADD COLUMN donor VARCHAR;
+
+-- the donors of a project are joined into its donor column again
+ALTER TABLE projects NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE grants NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE donors NO FORCE ROW LEVEL SECURITY;
+
+UPDATE projects p SET donor = (
+  SELECT string_agg(DISTINCT d.donor_name, ', ')
+  FROM grants g JOIN donors d USING (donor_id)
+  WHERE g.project_id = p.project_id
+);
+
+ALTER TABLE projects FORCE ROW LEVEL SECURITY;
+
+ALTER TABLE users DROP COLUMN donor_id;
+DROP TABLE grant_deadlines;
+DROP TABLE grants;
+DROP TABLE donors;
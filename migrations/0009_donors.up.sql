@@ -0,0 +1,81 @@
+-- the donors an organization receives grants from
+CREATE TABLE donors (
+  donor_id        UUID PRIMARY KEY,
+  organization_id UUID        NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  donor_name      VARCHAR     NOT NULL,
+  contact_name    VARCHAR,
+  contact_email   VARCHAR,
+  website         VARCHAR,
+  notes           TEXT,
+  ts_created      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX ON donors (organization_id, lower(donor_name));
+
+-- a grant funds a project; grants without an amount were migrated from the
+-- donor column of projects
+CREATE TABLE grants (
+  grant_id               UUID PRIMARY KEY,
+  project_id             UUID           NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  donor_id               UUID           NOT NULL REFERENCES donors (donor_id),
+  reference              VARCHAR,
+  amount                 NUMERIC(19, 4) NOT NULL DEFAULT 0 CHECK (amount >= 0),
+  currency               CHAR(3)        NOT NULL,
+  period_from            DATE,
+  period_to              DATE,
+  reporting_requirements TEXT,
+  created_by             UUID REFERENCES users (user_id),
+  ts_created             TIMESTAMPTZ    NOT NULL DEFAULT now(),
+  CHECK (period_to >= period_from)
+);
+
+CREATE INDEX ON grants (project_id);
+CREATE INDEX ON grants (donor_id);
+
+-- the reports a grant requires and when they are due
+CREATE TABLE grant_deadlines (
+  grant_id    UUID    NOT NULL REFERENCES grants (grant_id) ON DELETE CASCADE,
+  position    INTEGER NOT NULL,
+  due_on      DATE    NOT NULL,
+  description VARCHAR,
+  PRIMARY KEY (grant_id, position)
+);
+
+CREATE INDEX ON grant_deadlines (due_on);
+
+-- grant officers are users who only see the projects their donor funds
+ALTER TABLE users ADD COLUMN donor_id UUID REFERENCES donors (donor_id);
+
+-- the donor names of projects become donors and grants; the owner is subject
+-- to the tenant policies as well, so they are lifted while the rows are copied
+ALTER TABLE projects NO FORCE ROW LEVEL SECURITY;
+
+INSERT INTO donors (donor_id, organization_id, donor_name)
+SELECT md5(organization_id || lower(trim(donor))) :: UUID, organization_id, min(trim(donor))
+FROM projects
+WHERE organization_id IS NOT NULL AND trim(donor) <> ''
+GROUP BY organization_id, lower(trim(donor));
+
+INSERT INTO grants (grant_id, project_id, donor_id, currency)
+SELECT md5(project_id :: TEXT) :: UUID, project_id, md5(organization_id || lower(trim(donor))) :: UUID, currency
+FROM projects
+WHERE organization_id IS NOT NULL AND trim(donor) <> '';
+
+ALTER TABLE projects FORCE ROW LEVEL SECURITY;
+
+ALTER TABLE projects DROP COLUMN donor;
+
+ALTER TABLE donors ENABLE ROW LEVEL SECURITY;
+ALTER TABLE donors FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON donors
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
+
+ALTER TABLE grants ENABLE ROW LEVEL SECURITY;
+ALTER TABLE grants FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON grants
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE grant_deadlines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE grant_deadlines FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON grant_deadlines
+  USING (grant_id IN (SELECT grant_id FROM grants));
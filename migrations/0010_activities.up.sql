@@ -0,0 +1,38 @@
+-- what a project plans to do and when, inside the project's timeline;
+-- milestones and monitoring dates are single days with starts_on = ends_on
+CREATE TABLE activities (
+  activity_id        UUID PRIMARY KEY,
+  project_id         UUID    NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  kind               VARCHAR NOT NULL CHECK (kind IN ('activity', 'milestone', 'monitoring')),
+  title              VARCHAR NOT NULL,
+  description        TEXT,
+  starts_on          DATE    NOT NULL,
+  ends_on            DATE    NOT NULL CHECK (ends_on >= starts_on),
+  status             VARCHAR NOT NULL CHECK (status IN ('planned', 'in_progress', 'done', 'cancelled')),
+  owner_id           UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  strategy_id        UUID REFERENCES strategies (strategy_id) ON DELETE SET NULL,
+  progress_marker_id UUID REFERENCES progress_markers (progress_marker_id) ON DELETE SET NULL,
+  created_by         UUID REFERENCES users (user_id),
+  ts_created         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON activities (project_id, starts_on);
+
+ALTER TABLE activities ENABLE ROW LEVEL SECURITY;
+ALTER TABLE activities FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON activities
+  USING (project_id IN (SELECT project_id FROM projects));
+
+-- the key calendar feed links of a project are signed with; replacing or
+-- deleting it revokes the links made with it
+CREATE TABLE calendar_links (
+  project_id UUID PRIMARY KEY REFERENCES projects (project_id) ON DELETE CASCADE,
+  link_key   VARCHAR NOT NULL,
+  created_by UUID REFERENCES users (user_id),
+  ts_created TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE calendar_links ENABLE ROW LEVEL SECURITY;
+ALTER TABLE calendar_links FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON calendar_links
+  USING (project_id IN (SELECT project_id FROM projects));
@@ -0,0 +1,42 @@
+-- follow-up work on exactly one progress marker, challenge or strategy
+CREATE TABLE tasks (
+  task_id            UUID PRIMARY KEY,
+  project_id         UUID    NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  progress_marker_id UUID REFERENCES progress_markers (progress_marker_id) ON DELETE CASCADE,
+  challenge_id       UUID REFERENCES challenges (challenge_id) ON DELETE CASCADE,
+  strategy_id        UUID REFERENCES strategies (strategy_id) ON DELETE CASCADE,
+  title              VARCHAR NOT NULL,
+  description        TEXT,
+  assignee_id        UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  due_on             DATE,
+  priority           VARCHAR NOT NULL CHECK (priority IN ('low', 'normal', 'high', 'urgent')),
+  status             VARCHAR NOT NULL CHECK (status IN ('open', 'in_progress', 'blocked', 'done', 'cancelled')),
+  created_by         UUID REFERENCES users (user_id),
+  ts_created         TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ts_updated         TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (num_nonnulls(progress_marker_id, challenge_id, strategy_id) = 1)
+);
+
+CREATE INDEX ON tasks (project_id);
+CREATE INDEX ON tasks (assignee_id) WHERE status NOT IN ('done', 'cancelled');
+CREATE INDEX ON tasks (due_on) WHERE status NOT IN ('done', 'cancelled');
+
+CREATE TABLE task_comments (
+  comment_id UUID PRIMARY KEY,
+  task_id    UUID NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+  author_id  UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  body       TEXT NOT NULL,
+  ts_created TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON task_comments (task_id, ts_created);
+
+ALTER TABLE tasks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tasks FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON tasks
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE task_comments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE task_comments FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON task_comments
+  USING (task_id IN (SELECT task_id FROM tasks));
@@ -0,0 +1,42 @@
+-- discussions on a project or one of its parts; outcome statements are
+-- commented on through their boundary partner. Replies have a parent and are
+-- on the same thing as the parent.
+CREATE TABLE comments (
+  comment_id          UUID PRIMARY KEY,
+  project_id          UUID    NOT NULL REFERENCES projects (project_id) ON DELETE CASCADE,
+  entity_type         VARCHAR NOT NULL CHECK (entity_type IN (
+                        'project', 'boundary_partner', 'outcome_statement', 'progress_marker', 'challenge', 'strategy')),
+  boundary_partner_id UUID REFERENCES boundary_partners (boundary_partner_id) ON DELETE CASCADE,
+  progress_marker_id  UUID REFERENCES progress_markers (progress_marker_id) ON DELETE CASCADE,
+  challenge_id        UUID REFERENCES challenges (challenge_id) ON DELETE CASCADE,
+  strategy_id         UUID REFERENCES strategies (strategy_id) ON DELETE CASCADE,
+  parent_id           UUID REFERENCES comments (comment_id) ON DELETE CASCADE,
+  author_id           UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  body                TEXT    NOT NULL,
+  resolved_by         UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  ts_resolved         TIMESTAMPTZ,
+  ts_created          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ts_edited           TIMESTAMPTZ,
+  CHECK (num_nonnulls(boundary_partner_id, progress_marker_id, challenge_id, strategy_id) =
+         CASE WHEN entity_type = 'project' THEN 0 ELSE 1 END)
+);
+
+CREATE INDEX ON comments (project_id, ts_created);
+CREATE INDEX ON comments (parent_id);
+
+-- users mentioned in a comment
+CREATE TABLE comment_mentions (
+  comment_id UUID NOT NULL REFERENCES comments (comment_id) ON DELETE CASCADE,
+  user_id    UUID NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+  PRIMARY KEY (comment_id, user_id)
+);
+
+ALTER TABLE comments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE comments FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON comments
+  USING (project_id IN (SELECT project_id FROM projects));
+
+ALTER TABLE comment_mentions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE comment_mentions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON comment_mentions
+  USING (comment_id IN (SELECT comment_id FROM comments));
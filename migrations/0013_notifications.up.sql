@@ -0,0 +1,43 @@
+-- what users are told about. A notification is shown in the inbox if
+-- in_app is set and mailed immediately or with the daily digest as email
+-- says; ts_emailed is set once it has been mailed.
+CREATE TABLE notifications (
+  notification_id UUID PRIMARY KEY,
+  organization_id UUID    NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  user_id         UUID    NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+  kind            VARCHAR NOT NULL,
+  project_id      UUID REFERENCES projects (project_id) ON DELETE CASCADE,
+  actor_id        UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  title           VARCHAR NOT NULL,
+  body            TEXT,
+  link            VARCHAR,
+  in_app          BOOL    NOT NULL,
+  email           VARCHAR NOT NULL CHECK (email IN ('never', 'immediate', 'daily')),
+  ts_read         TIMESTAMPTZ,
+  ts_emailed      TIMESTAMPTZ,
+  ts_created      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON notifications (user_id, ts_created) WHERE in_app;
+CREATE INDEX ON notifications (organization_id) WHERE email <> 'never' AND ts_emailed IS NULL;
+
+-- how a user wants to be told about a kind of notification; kinds without
+-- a row use the defaults of the backend
+CREATE TABLE notification_preferences (
+  organization_id UUID    NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  user_id         UUID    NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+  kind            VARCHAR NOT NULL,
+  in_app          BOOL    NOT NULL,
+  email           VARCHAR NOT NULL CHECK (email IN ('never', 'immediate', 'daily')),
+  PRIMARY KEY (user_id, kind)
+);
+
+ALTER TABLE notifications ENABLE ROW LEVEL SECURITY;
+ALTER TABLE notifications FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON notifications
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
+
+ALTER TABLE notification_preferences ENABLE ROW LEVEL SECURITY;
+ALTER TABLE notification_preferences FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON notification_preferences
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
@@ -0,0 +1,2 @@
+DROP TABLE sent_reminders;
+DROP TABLE jobs;
@@ -0,0 +1,33 @@
+-- runs of background jobs, shared by all instances of the server. A job
+-- has at most one pending or running run; a failed run is pending again
+-- until it has made max_attempts attempts. Jobs work on behalf of every
+-- organization, so the table has no row level security.
+CREATE TABLE jobs (
+  job_id       UUID PRIMARY KEY,
+  name         VARCHAR     NOT NULL,
+  run_at       TIMESTAMPTZ NOT NULL,
+  status       VARCHAR     NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+  attempts     INT         NOT NULL DEFAULT 0,
+  max_attempts INT         NOT NULL CHECK (max_attempts > 0),
+  last_error   TEXT,
+  ts_started   TIMESTAMPTZ,
+  ts_finished  TIMESTAMPTZ,
+  ts_created   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX jobs_scheduled ON jobs (name) WHERE status IN ('pending', 'running');
+CREATE INDEX ON jobs (run_at) WHERE status = 'pending';
+
+-- reminders that have been sent, so each goes out once; the key names what
+-- it was about, like monitoring_due:<activity_id>:<date>
+CREATE TABLE sent_reminders (
+  organization_id UUID    NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  reminder_key    VARCHAR NOT NULL,
+  ts_sent         TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (organization_id, reminder_key)
+);
+
+ALTER TABLE sent_reminders ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sent_reminders FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON sent_reminders
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
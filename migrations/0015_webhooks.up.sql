@@ -0,0 +1,48 @@
+-- endpoints of other systems that events of an organization are posted to,
+-- signed with the secret
+CREATE TABLE webhooks (
+  webhook_id      UUID PRIMARY KEY,
+  organization_id UUID      NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  url             VARCHAR   NOT NULL,
+  description     TEXT,
+  events          VARCHAR[] NOT NULL,
+  active          BOOL      NOT NULL DEFAULT TRUE,
+  secret          VARCHAR   NOT NULL,
+  created_by      UUID REFERENCES users (user_id) ON DELETE SET NULL,
+  ts_created      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON webhooks (organization_id);
+
+-- events posted, or to be posted, to a webhook. A pending delivery is tried
+-- again at next_attempt_at until it has made the configured number of
+-- attempts; the response of the last attempt is kept.
+CREATE TABLE webhook_deliveries (
+  delivery_id     UUID PRIMARY KEY,
+  organization_id UUID    NOT NULL REFERENCES organizations (organization_id) ON DELETE CASCADE,
+  webhook_id      UUID    NOT NULL REFERENCES webhooks (webhook_id) ON DELETE CASCADE,
+  event           VARCHAR NOT NULL,
+  payload         JSONB   NOT NULL,
+  status          VARCHAR NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts        INT     NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ,
+  response_status INT,
+  response_body   TEXT,
+  last_error      TEXT,
+  redelivery_of   UUID REFERENCES webhook_deliveries (delivery_id) ON DELETE SET NULL,
+  ts_delivered    TIMESTAMPTZ,
+  ts_created      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON webhook_deliveries (webhook_id, ts_created);
+CREATE INDEX ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+ALTER TABLE webhooks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhooks FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON webhooks
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
+
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_deliveries FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON webhook_deliveries
+  USING (organization_id = nullif(current_setting('lucid.organization_id', TRUE), '') :: UUID);
@@ -0,0 +1,6 @@
+DROP INDEX outcome_journals_search;
+DROP INDEX strategies_search;
+DROP INDEX challenges_search;
+DROP INDEX progress_markers_search;
+DROP INDEX boundary_partners_search;
+DROP INDEX projects_search;
@@ -0,0 +1,24 @@
+-- full text search over the parts of projects. The indexes are on the same
+-- expressions the search queries use, names and titles weigh more than the
+-- text under them.
+CREATE INDEX projects_search ON projects USING GIN ((
+  setweight(to_tsvector('english', coalesce(project_name, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(description, '')), 'B')));
+
+CREATE INDEX boundary_partners_search ON boundary_partners USING GIN ((
+  setweight(to_tsvector('english', coalesce(partner_name, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(outcome_statement, '')), 'B')));
+
+CREATE INDEX progress_markers_search ON progress_markers USING GIN ((
+  to_tsvector('english', coalesce(title, ''))));
+
+CREATE INDEX challenges_search ON challenges USING GIN ((
+  to_tsvector('english', coalesce(challenge_name, ''))));
+
+CREATE INDEX strategies_search ON strategies USING GIN ((
+  to_tsvector('english', coalesce(strategy_name, ''))));
+
+CREATE INDEX outcome_journals_search ON outcome_journals USING GIN ((
+  setweight(to_tsvector('english', coalesce(description_of_change, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(contributing_factors, '') || ' ' || coalesce(sources_of_evidence, '') || ' ' ||
+    coalesce(unanticipated_change, '') || ' ' || coalesce(lessons, '')), 'B')));
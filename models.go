@@ -1,20 +1,39 @@
 package main
 
-import "github.com/lib/pq"
+import (
+	"encoding/json"
+	"time"
 
+	"github.com/lib/pq"
+)
+
+// User is a member of an organization. A user with a DonorId is a grant
+// officer of that donor, who only sees the projects the donor funds.
 type User struct {
 	UserId         string `json:"user_id"`
 	OrganizationId string `json:"organization_id"`
 	FullName       string `json:"full_name"`
 	IsAdmin        bool   `json:"is_admin"`
+	DonorId        string `json:"donor_id"`
+}
+
+type Organization struct {
+	OrganizationId   string       `json:"organization_id"`
+	OrganizationName string       `json:"organization_name"`
+	Logo             *ProjectLogo `json:"logo"`
+	// BrandColor is the color of the reports as #rrggbb, empty for the
+	// default.
+	BrandColor string `json:"brand_color"`
 }
 
 type Project struct {
-	ProjectId            string         `json:"project_id"`
-	ProjectName          string         `json:"project_name"`
-	LogoUrl              string         `json:"logo_url"`
-	Description          string         `json:"description"`
-	Budget               float64        `json:"budget"`
+	ProjectId   string       `json:"project_id"`
+	ProjectName string       `json:"project_name"`
+	Logo        *ProjectLogo `json:"logo"`
+	Description string       `json:"description"`
+	Budget      Amount       `json:"budget"`
+	Currency    string       `json:"currency"`
+	// Donor holds the names of the donors of the project's grants.
 	Donor                string         `json:"donor"`
 	Vision               string         `json:"vision"`
 	Mission              string         `json:"mission"`
@@ -24,21 +43,76 @@ type Project struct {
 	BoundaryPartnerNames pq.StringArray `json:"boundary_partner_names"`
 	ResourceIds          pq.StringArray `json:"resource_ids"`
 	ResourceUrls         pq.StringArray `json:"resource_urls"`
+	// Comments counts the comments on the project itself.
+	Comments int `json:"comments"`
 }
 
+// ProjectLogo holds the URLs of the variants of a project logo. The keys of
+// the stored variants are not part of the JSON.
+type ProjectLogo struct {
+	Original     string `json:"original"`
+	Medium       string `json:"medium"`
+	Thumbnail    string `json:"thumbnail"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	OriginalKey  string `json:"-"`
+	MediumKey    string `json:"-"`
+	ThumbnailKey string `json:"-"`
+}
+
+// resource types
+const (
+	RESOURCE_FILE = "file"
+	RESOURCE_LINK = "link"
+)
+
 type ExternalResources struct {
-	ResourceId   string `json:"resource_id"`
-	ProjectId    string `json:"project_id"`
-	ResourceUrl  string `json:"resource_url"`
-	ResourceName string `json:"resource_name"`
+	ResourceId        string         `json:"resource_id"`
+	ProjectId         string         `json:"project_id"`
+	ResourceType      string         `json:"resource_type"`
+	ResourceUrl       string         `json:"resource_url"`
+	ResourceName      string         `json:"resource_name"`
+	Title             string         `json:"title"`
+	Description       string         `json:"description"`
+	Tags              pq.StringArray `json:"tags"`
+	FaviconUrl        string         `json:"favicon_url"`
+	BoundaryPartnerId string         `json:"boundary_partner_id"`
+	ProgressMarkerId  string         `json:"progress_marker_id"`
+	JournalId         string         `json:"journal_id"`
+	Size              int64          `json:"size"`
+	SHA256            string         `json:"sha256"`
+	ContentType       string         `json:"content_type"`
+	ScanStatus        string         `json:"scan_status"`
+	// Version is the number of the current version of a file.
+	Version    int    `json:"version"`
+	StorageKey string `json:"-"`
+}
+
+// ResourceVersion is one upload of a file resource.
+type ResourceVersion struct {
+	ResourceId    string    `json:"resource_id"`
+	VersionNumber int       `json:"version_number"`
+	ResourceName  string    `json:"resource_name"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	ContentType   string    `json:"content_type"`
+	ScanStatus    string    `json:"scan_status"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedByName string    `json:"created_by_name"`
+	TsCreated     time.Time `json:"ts_created"`
+	Current       bool      `json:"current"`
+	StorageKey    string    `json:"-"`
 }
 
 type BoundaryPartner struct {
-	BoundaryPartnerId string            `json:"boundary_partner_id"`
-	ProjectId         string            `json:"project_id"`
-	PartnerName       string            `json:"partner_name"`
-	OutcomeStatement  string            `json:"outcome_statement"`
-	ProgressMarkers   []*ProgressMarker `json:"progress_markers"`
+	BoundaryPartnerId        string            `json:"boundary_partner_id"`
+	ProjectId                string            `json:"project_id"`
+	PartnerName              string            `json:"partner_name"`
+	OutcomeStatement         string            `json:"outcome_statement"`
+	ProgressMarkers          []*ProgressMarker `json:"progress_markers"`
+	Comments                 int               `json:"comments"`
+	OutcomeStatementComments int               `json:"outcome_statement_comments"`
 }
 
 type ProgressMarker struct {
@@ -49,16 +123,682 @@ type ProgressMarker struct {
 	OrderNumber       int          `json:"order_number"`
 	Challenges        []*Challenge `json:"challenges"`
 	Strategies        []*Strategy  `json:"strategies"`
+	Comments          int          `json:"comments"`
 }
 
 type Challenge struct {
 	ChallengeId      string `json:"challenge_id"`
 	ProgressMarkerId string `json:"progress_marker_id"`
 	ChallengeName    string `json:"challenge_name"`
+	Comments         int    `json:"comments"`
 }
 
 type Strategy struct {
 	StrategyId       string `json:"strategy_id"`
 	ProgressMarkerId string `json:"progress_marker_id"`
 	StrategyName     string `json:"strategy_name"`
+	Comments         int    `json:"comments"`
+}
+
+// outcome journal statuses
+const (
+	JOURNAL_DRAFT     = "draft"
+	JOURNAL_SUBMITTED = "submitted"
+)
+
+// ratings of a progress marker in an outcome journal. A marker rated high
+// counts as achieved.
+const (
+	RATING_LOW    = "low"
+	RATING_MEDIUM = "medium"
+	RATING_HIGH   = "high"
+)
+
+// OutcomeJournal records the changes observed in a boundary partner up to a
+// monitoring date, with a rating of its progress markers. A draft can be
+// changed until it is submitted.
+type OutcomeJournal struct {
+	JournalId           string          `json:"journal_id"`
+	ProjectId           string          `json:"project_id"`
+	BoundaryPartnerId   string          `json:"boundary_partner_id"`
+	MonitoringDate      string          `json:"monitoring_date"`
+	DescriptionOfChange string          `json:"description_of_change"`
+	ContributingFactors string          `json:"contributing_factors"`
+	SourcesOfEvidence   string          `json:"sources_of_evidence"`
+	UnanticipatedChange string          `json:"unanticipated_change"`
+	Lessons             string          `json:"lessons"`
+	Status              string          `json:"status"`
+	SubmittedAt         *time.Time      `json:"submitted_at"`
+	SubmittedBy         string          `json:"submitted_by"`
+	Ratings             []JournalRating `json:"ratings"`
+}
+
+// OverdueJournal is a draft outcome journal whose monitoring date has
+// passed, with the user who started it.
+type OverdueJournal struct {
+	JournalId         string
+	ProjectId         string
+	BoundaryPartnerId string
+	PartnerName       string
+	MonitoringDate    string
+	CreatedBy         string
+}
+
+type JournalRating struct {
+	ProgressMarkerId string `json:"progress_marker_id"`
+	Rating           string `json:"rating"`
+}
+
+// format and latest version of project exports
+const (
+	EXPORT_FORMAT  = "lucid-project"
+	EXPORT_VERSION = 1
+)
+
+// ProjectExport is a self-contained copy of a project. The IDs in it are only
+// used to link its parts, an import assigns new ones.
+type ProjectExport struct {
+	Format           string             `json:"format"`
+	Version          int                `json:"version"`
+	ExportedAt       time.Time          `json:"exported_at"`
+	Project          ExportedProject    `json:"project"`
+	BoundaryPartners []*BoundaryPartner `json:"boundary_partners"`
+	Journals         []*ExportedJournal `json:"journals"`
+	Resources        []ExportedResource `json:"resources"`
+	// Grants name their donors by DonorName, an import adds the donors the
+	// organization does not have yet.
+	Grants []Grant `json:"grants,omitempty"`
+}
+
+type ExportedProject struct {
+	ProjectName string `json:"project_name"`
+	Description string `json:"description"`
+	Budget      Amount `json:"budget"`
+	Currency    string `json:"currency,omitempty"`
+	Donor       string `json:"donor"`
+	Vision      string `json:"vision"`
+	Mission     string `json:"mission"`
+	// TimelineFrom and TimelineTo are RFC 3339 timestamps.
+	TimelineFrom string `json:"timeline_from"`
+	TimelineTo   string `json:"timeline_to"`
+	// Logo is the path of the original logo in a zip export.
+	Logo       string       `json:"logo,omitempty"`
+	StoredLogo *ProjectLogo `json:"-"`
+}
+
+// ExportedJournal is an outcome journal with the ratings of its boundary
+// partner's progress markers. Who submitted it is not exported, users are
+// not part of a project.
+type ExportedJournal struct {
+	JournalId           string          `json:"journal_id"`
+	BoundaryPartnerId   string          `json:"boundary_partner_id"`
+	MonitoringDate      string          `json:"monitoring_date"`
+	DescriptionOfChange string          `json:"description_of_change"`
+	ContributingFactors string          `json:"contributing_factors"`
+	SourcesOfEvidence   string          `json:"sources_of_evidence"`
+	UnanticipatedChange string          `json:"unanticipated_change"`
+	Lessons             string          `json:"lessons"`
+	Status              string          `json:"status"`
+	SubmittedAt         *time.Time      `json:"submitted_at"`
+	Ratings             []JournalRating `json:"ratings"`
+}
+
+// ExportedResource is a resource without its version history. File is the
+// path of the current file in a zip export.
+type ExportedResource struct {
+	ResourceId        string         `json:"resource_id"`
+	ResourceType      string         `json:"resource_type"`
+	ResourceUrl       string         `json:"resource_url,omitempty"`
+	ResourceName      string         `json:"resource_name"`
+	Title             string         `json:"title"`
+	Description       string         `json:"description"`
+	Tags              pq.StringArray `json:"tags"`
+	FaviconUrl        string         `json:"favicon_url"`
+	BoundaryPartnerId string         `json:"boundary_partner_id"`
+	ProgressMarkerId  string         `json:"progress_marker_id"`
+	JournalId         string         `json:"journal_id"`
+	Size              int64          `json:"size"`
+	SHA256            string         `json:"sha256"`
+	ContentType       string         `json:"content_type"`
+	File              string         `json:"file,omitempty"`
+	ScanStatus        string         `json:"-"`
+	StorageKey        string         `json:"-"`
+}
+
+// ReportTemplate is the template a report format is rendered with. Custom is
+// false for the built-in template.
+type ReportTemplate struct {
+	Format string `json:"format"`
+	Body   string `json:"body"`
+	Custom bool   `json:"custom"`
+}
+
+// kinds of items a spreadsheet import changes
+const (
+	KIND_MARKER    = "progress_marker"
+	KIND_CHALLENGE = "challenge"
+	KIND_STRATEGY  = "strategy"
+)
+
+// what a spreadsheet import does with a row
+const (
+	CHANGE_CREATE    = "create"
+	CHANGE_UPDATE    = "update"
+	CHANGE_UNCHANGED = "unchanged"
+)
+
+// MarkerChange is what an imported spreadsheet row does to a progress
+// marker, challenge or strategy. Name is the title of a progress marker.
+type MarkerChange struct {
+	Sheet             string `json:"sheet"`
+	Row               int    `json:"row"`
+	Action            string `json:"action"`
+	Kind              string `json:"kind"`
+	Id                string `json:"id"`
+	BoundaryPartnerId string `json:"boundary_partner_id"`
+	ProgressMarkerId  string `json:"progress_marker_id"`
+	Name              string `json:"name"`
+	Type              int    `json:"type,omitempty"`
+	// NewMarker is the index of the change that creates the progress marker
+	// of a new challenge or strategy, or -1.
+	NewMarker int `json:"-"`
+}
+
+// RowError is a problem with a cell of an imported spreadsheet. Rows are
+// numbered from 1, the header included.
+type RowError struct {
+	Sheet   string `json:"sheet"`
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Message string `json:"message"`
+}
+
+// SpreadsheetImport is the outcome of a spreadsheet import, or the preview of
+// one if DryRun is set.
+type SpreadsheetImport struct {
+	DryRun  bool           `json:"dry_run"`
+	Changes []MarkerChange `json:"changes"`
+	Errors  []RowError     `json:"errors"`
+}
+
+// MarkerCounts counts progress markers by level.
+type MarkerCounts struct {
+	Expect int `json:"expect"`
+	Like   int `json:"like"`
+	Love   int `json:"love"`
+}
+
+// RatingCounts counts the ratings of progress markers in outcome journals.
+type RatingCounts struct {
+	Low    int `json:"low"`
+	Medium int `json:"medium"`
+	High   int `json:"high"`
+}
+
+// ProjectStats are the aggregates of a project shown on dashboards. Budget
+// and Spent are in the project's currency, BudgetConsumed is Spent in percent
+// of Budget. The timeline fields are computed from TimelineFrom and
+// TimelineTo, which are RFC 3339 timestamps or empty. LatestRatings counts
+// the ratings in the latest submitted journal of every boundary partner,
+// Achieved is the share of them rated high, in percent.
+type ProjectStats struct {
+	ProjectId        string         `json:"project_id"`
+	ProjectName      string         `json:"project_name"`
+	BoundaryPartners int            `json:"boundary_partners"`
+	ProgressMarkers  int            `json:"progress_markers"`
+	MarkersByLevel   MarkerCounts   `json:"markers_by_level"`
+	Challenges       int            `json:"challenges"`
+	Strategies       int            `json:"strategies"`
+	Resources        int            `json:"resources"`
+	LatestRatings    RatingCounts   `json:"latest_ratings"`
+	Achieved         *float64       `json:"achieved"`
+	Currency         string         `json:"currency"`
+	Budget           Amount         `json:"budget"`
+	Spent            Amount         `json:"spent"`
+	BudgetConsumed   *float64       `json:"budget_consumed"`
+	TimelineFrom     string         `json:"timeline_from"`
+	TimelineTo       string         `json:"timeline_to"`
+	TimelineElapsed  *float64       `json:"timeline_elapsed"`
+	DaysRemaining    *int           `json:"days_remaining"`
+	Partners         []PartnerStats `json:"partners,omitempty"`
+}
+
+// PartnerStats are the aggregates of a boundary partner. Trend has the
+// ratings of each of its submitted journals, oldest first.
+type PartnerStats struct {
+	BoundaryPartnerId string         `json:"boundary_partner_id"`
+	PartnerName       string         `json:"partner_name"`
+	ProgressMarkers   int            `json:"progress_markers"`
+	MarkersByLevel    MarkerCounts   `json:"markers_by_level"`
+	Challenges        int            `json:"challenges"`
+	Strategies        int            `json:"strategies"`
+	Trend             []RatingPeriod `json:"trend"`
+}
+
+// RatingPeriod counts the ratings of a journal, with the share rated high in
+// percent.
+type RatingPeriod struct {
+	MonitoringDate string       `json:"monitoring_date"`
+	Ratings        RatingCounts `json:"ratings"`
+	Achieved       *float64     `json:"achieved"`
+}
+
+// OrganizationStats rolls up the stats of all projects of an organization.
+// Active projects are those whose timeline includes the current time. Budget
+// and Spent are converted into Currency at the current rates.
+type OrganizationStats struct {
+	Projects         int            `json:"projects"`
+	ActiveProjects   int            `json:"active_projects"`
+	BoundaryPartners int            `json:"boundary_partners"`
+	ProgressMarkers  int            `json:"progress_markers"`
+	MarkersByLevel   MarkerCounts   `json:"markers_by_level"`
+	Challenges       int            `json:"challenges"`
+	Strategies       int            `json:"strategies"`
+	Resources        int            `json:"resources"`
+	LatestRatings    RatingCounts   `json:"latest_ratings"`
+	Achieved         *float64       `json:"achieved"`
+	Currency         string         `json:"currency"`
+	Budget           Amount         `json:"budget"`
+	Spent            Amount         `json:"spent"`
+	ProjectStats     []ProjectStats `json:"project_stats"`
+}
+
+// BudgetLine is a part of a project's budget, optionally for a boundary
+// partner or a strategy. ProjectAmount is Amount in the project's currency at
+// the rate of the day the line was entered.
+type BudgetLine struct {
+	BudgetLineId      string    `json:"budget_line_id"`
+	ProjectId         string    `json:"project_id"`
+	Title             string    `json:"title"`
+	Description       string    `json:"description"`
+	Amount            Amount    `json:"amount"`
+	Currency          string    `json:"currency"`
+	ProjectAmount     Amount    `json:"project_amount"`
+	BoundaryPartnerId string    `json:"boundary_partner_id"`
+	StrategyId        string    `json:"strategy_id"`
+	TsCreated         time.Time `json:"ts_created"`
+}
+
+// Expenditure is money spent on a project, converted at the rate of the day
+// it was spent. An empty BudgetLineId leaves it unallocated. The receipt is a
+// resource of the project.
+type Expenditure struct {
+	ExpenditureId     string    `json:"expenditure_id"`
+	ProjectId         string    `json:"project_id"`
+	BudgetLineId      string    `json:"budget_line_id"`
+	Description       string    `json:"description"`
+	Amount            Amount    `json:"amount"`
+	Currency          string    `json:"currency"`
+	ProjectAmount     Amount    `json:"project_amount"`
+	SpentOn           string    `json:"spent_on"`
+	ReceiptResourceId string    `json:"receipt_resource_id"`
+	CreatedBy         string    `json:"created_by"`
+	TsCreated         time.Time `json:"ts_created"`
+}
+
+// BudgetReport compares the budget lines of a project with what was spent on
+// them, in the project's currency.
+type BudgetReport struct {
+	Currency    string             `json:"currency"`
+	Budget      Amount             `json:"budget"`
+	Spent       Amount             `json:"spent"`
+	Remaining   Amount             `json:"remaining"`
+	Consumed    *float64           `json:"consumed"`
+	Unallocated Amount             `json:"unallocated"`
+	Lines       []BudgetReportLine `json:"lines"`
+}
+
+type BudgetReportLine struct {
+	BudgetLineId      string   `json:"budget_line_id"`
+	Title             string   `json:"title"`
+	BoundaryPartnerId string   `json:"boundary_partner_id"`
+	StrategyId        string   `json:"strategy_id"`
+	Budget            Amount   `json:"budget"`
+	Spent             Amount   `json:"spent"`
+	Remaining         Amount   `json:"remaining"`
+	Consumed          *float64 `json:"consumed"`
+	Expenditures      int      `json:"expenditures"`
+}
+
+// Donor is an organization that funds projects through grants.
+type Donor struct {
+	DonorId      string `json:"donor_id"`
+	DonorName    string `json:"donor_name"`
+	ContactName  string `json:"contact_name"`
+	ContactEmail string `json:"contact_email"`
+	Website      string `json:"website"`
+	Notes        string `json:"notes"`
+}
+
+// Grant is the funding of a project by a donor. The period and the
+// deadlines are dates like 2006-01-02.
+type Grant struct {
+	GrantId               string          `json:"grant_id"`
+	ProjectId             string          `json:"project_id"`
+	DonorId               string          `json:"donor_id"`
+	DonorName             string          `json:"donor_name"`
+	Reference             string          `json:"reference"`
+	Amount                Amount          `json:"amount"`
+	Currency              string          `json:"currency"`
+	PeriodFrom            string          `json:"period_from"`
+	PeriodTo              string          `json:"period_to"`
+	ReportingRequirements string          `json:"reporting_requirements"`
+	Deadlines             []GrantDeadline `json:"deadlines"`
+}
+
+// GrantDeadline is a report a grant requires by a date.
+type GrantDeadline struct {
+	DueOn       string `json:"due_on"`
+	Description string `json:"description"`
+}
+
+// DonorProject is a project as its donor sees it, with the donor's grants.
+type DonorProject struct {
+	Project Project `json:"project"`
+	Grants  []Grant `json:"grants"`
+}
+
+// kinds of activities
+const (
+	ACTIVITY            = "activity"
+	ACTIVITY_MILESTONE  = "milestone"
+	ACTIVITY_MONITORING = "monitoring"
+)
+
+// activity statuses
+const (
+	STATUS_PLANNED     = "planned"
+	STATUS_IN_PROGRESS = "in_progress"
+	STATUS_DONE        = "done"
+	STATUS_CANCELLED   = "cancelled"
+)
+
+// Activity is work planned within a project's timeline, optionally for a
+// strategy or progress marker. Milestones and monitoring dates are single
+// days. The dates are like 2006-01-02, EndsOn is inclusive.
+type Activity struct {
+	ActivityId       string    `json:"activity_id"`
+	ProjectId        string    `json:"project_id"`
+	Kind             string    `json:"kind"`
+	Title            string    `json:"title"`
+	Description      string    `json:"description"`
+	StartsOn         string    `json:"starts_on"`
+	EndsOn           string    `json:"ends_on"`
+	Status           string    `json:"status"`
+	OwnerId          string    `json:"owner_id"`
+	OwnerName        string    `json:"owner_name"`
+	StrategyId       string    `json:"strategy_id"`
+	ProgressMarkerId string    `json:"progress_marker_id"`
+	TsCreated        time.Time `json:"ts_created"`
+}
+
+// Gantt is the schedule of a project for drawing a Gantt chart. Start and
+// End span the timeline, or the activities if the project has no timeline.
+type Gantt struct {
+	ProjectId   string      `json:"project_id"`
+	ProjectName string      `json:"project_name"`
+	Start       string      `json:"start"`
+	End         string      `json:"end"`
+	Items       []GanttItem `json:"items"`
+}
+
+// GanttItem is a bar of a Gantt chart, or a point if Milestone is set. End is
+// inclusive and Days counts the days from Start to End. Progress is 100 once
+// the activity is done and 0 before.
+type GanttItem struct {
+	Id               string `json:"id"`
+	Name             string `json:"name"`
+	Kind             string `json:"kind"`
+	Start            string `json:"start"`
+	End              string `json:"end"`
+	Days             int    `json:"days"`
+	Milestone        bool   `json:"milestone"`
+	Status           string `json:"status"`
+	Progress         int    `json:"progress"`
+	OwnerName        string `json:"owner_name"`
+	StrategyId       string `json:"strategy_id"`
+	ProgressMarkerId string `json:"progress_marker_id"`
+}
+
+// task priorities
+const (
+	PRIORITY_LOW    = "low"
+	PRIORITY_NORMAL = "normal"
+	PRIORITY_HIGH   = "high"
+	PRIORITY_URGENT = "urgent"
+)
+
+// task statuses, besides STATUS_IN_PROGRESS, STATUS_DONE and STATUS_CANCELLED
+const (
+	STATUS_OPEN    = "open"
+	STATUS_BLOCKED = "blocked"
+)
+
+// Task is follow-up work on exactly one of a progress marker, a challenge or
+// a strategy. DueOn is a date like 2006-01-02 or empty.
+type Task struct {
+	TaskId           string    `json:"task_id"`
+	ProjectId        string    `json:"project_id"`
+	ProjectName      string    `json:"project_name"`
+	ProgressMarkerId string    `json:"progress_marker_id"`
+	ChallengeId      string    `json:"challenge_id"`
+	StrategyId       string    `json:"strategy_id"`
+	Title            string    `json:"title"`
+	Description      string    `json:"description"`
+	AssigneeId       string    `json:"assignee_id"`
+	AssigneeName     string    `json:"assignee_name"`
+	DueOn            string    `json:"due_on"`
+	Priority         string    `json:"priority"`
+	Status           string    `json:"status"`
+	Comments         int       `json:"comments"`
+	CreatedBy        string    `json:"created_by"`
+	TsCreated        time.Time `json:"ts_created"`
+	TsUpdated        time.Time `json:"ts_updated"`
+}
+
+type TaskComment struct {
+	CommentId  string    `json:"comment_id"`
+	TaskId     string    `json:"task_id"`
+	AuthorId   string    `json:"author_id"`
+	AuthorName string    `json:"author_name"`
+	Body       string    `json:"body"`
+	TsCreated  time.Time `json:"ts_created"`
+}
+
+// what comments can be on
+const (
+	ENTITY_PROJECT           = "project"
+	ENTITY_BOUNDARY_PARTNER  = "boundary_partner"
+	ENTITY_OUTCOME_STATEMENT = "outcome_statement"
+	ENTITY_PROGRESS_MARKER   = "progress_marker"
+	ENTITY_CHALLENGE         = "challenge"
+	ENTITY_STRATEGY          = "strategy"
+)
+
+// Comment is a comment on a project or one of its parts. EntityId is the ID
+// of the project, the boundary partner for its outcome statement, or the
+// part itself. A reply has the comment that starts its thread as ParentId;
+// only threads are resolved. Mentions holds the IDs of the users mentioned
+// in the body as @[Name](user_id).
+type Comment struct {
+	CommentId  string         `json:"comment_id"`
+	ProjectId  string         `json:"project_id"`
+	EntityType string         `json:"entity_type"`
+	EntityId   string         `json:"entity_id"`
+	ParentId   string         `json:"parent_id"`
+	AuthorId   string         `json:"author_id"`
+	AuthorName string         `json:"author_name"`
+	Body       string         `json:"body"`
+	Mentions   pq.StringArray `json:"mentions"`
+	Resolved   bool           `json:"resolved"`
+	ResolvedBy string         `json:"resolved_by"`
+	TsResolved *time.Time     `json:"ts_resolved"`
+	TsCreated  time.Time      `json:"ts_created"`
+	TsEdited   *time.Time     `json:"ts_edited"`
+	Replies    []Comment      `json:"replies,omitempty"`
+}
+
+// kinds of notifications
+const (
+	NOTIFY_TASK_ASSIGNED      = "task_assigned"
+	NOTIFY_ACTIVITY_ASSIGNED  = "activity_assigned"
+	NOTIFY_MENTION            = "mention"
+	NOTIFY_REPLY              = "reply"
+	NOTIFY_PROJECT_UPDATED    = "project_updated"
+	NOTIFY_MONITORING_DUE     = "monitoring_due"
+	NOTIFY_MONITORING_OVERDUE = "monitoring_overdue"
+	NOTIFY_TIMELINE_ENDING    = "timeline_ending"
+	NOTIFY_JOURNAL_OVERDUE    = "journal_overdue"
+)
+
+// when a notification is mailed
+const (
+	EMAIL_NEVER     = "never"
+	EMAIL_IMMEDIATE = "immediate"
+	EMAIL_DAILY     = "daily"
+)
+
+// Notification tells a user about something another user did or that is
+// due. Link is the API path of what it is about. InApp and Email record how
+// the user wanted to be told when it was created.
+type Notification struct {
+	NotificationId string     `json:"notification_id"`
+	UserId         string     `json:"user_id"`
+	Kind           string     `json:"kind"`
+	ProjectId      string     `json:"project_id"`
+	ActorId        string     `json:"actor_id"`
+	ActorName      string     `json:"actor_name"`
+	Title          string     `json:"title"`
+	Body           string     `json:"body"`
+	Link           string     `json:"link"`
+	Read           bool       `json:"read"`
+	TsRead         *time.Time `json:"ts_read"`
+	TsCreated      time.Time  `json:"ts_created"`
+	InApp          bool       `json:"-"`
+	Email          string     `json:"-"`
+}
+
+// Inbox is a page of a user's notifications, newest first.
+type Inbox struct {
+	Unread        int            `json:"unread"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// NotificationPreference is how a user wants to be told about a kind of
+// notification: in the inbox or not, and by email never, immediately or in
+// the daily digest.
+type NotificationPreference struct {
+	Kind  string `json:"kind"`
+	InApp bool   `json:"in_app"`
+	Email string `json:"email"`
+}
+
+// job run statuses
+const (
+	JOB_PENDING = "pending"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+// Job is a run of a background job. A run that fails is pending again
+// until it has made MaxAttempts attempts.
+type Job struct {
+	JobId       string     `json:"job_id"`
+	Name        string     `json:"name"`
+	RunAt       time.Time  `json:"run_at"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	LastError   string     `json:"last_error"`
+	TsStarted   *time.Time `json:"ts_started"`
+	TsFinished  *time.Time `json:"ts_finished"`
+}
+
+// events posted to webhooks
+const (
+	EVENT_PROJECT_UPDATED   = "project.updated"
+	EVENT_PARTNER_CREATED   = "partner.created"
+	EVENT_MARKERS_REORDERED = "progress_marker.reordered"
+	EVENT_JOURNAL_SUBMITTED = "journal.submitted"
+	EVENT_RESOURCE_UPLOADED = "resource.uploaded"
+)
+
+// Webhook subscribes another system to events of the organization, which
+// are posted to Url with a signature made with Secret. The secret is only
+// shown when the webhook is created.
+type Webhook struct {
+	WebhookId   string         `json:"webhook_id"`
+	Url         string         `json:"url"`
+	Description string         `json:"description"`
+	Events      pq.StringArray `json:"events"`
+	Active      bool           `json:"active"`
+	Secret      string         `json:"secret,omitempty"`
+	CreatedBy   string         `json:"created_by"`
+	TsCreated   time.Time      `json:"ts_created"`
+}
+
+// webhook delivery statuses
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+// WebhookDelivery is an event posted, or still to be posted, to a webhook,
+// with the outcome of the last attempt. A redelivery posts the payload of
+// an earlier delivery again.
+type WebhookDelivery struct {
+	DeliveryId     string          `json:"delivery_id"`
+	WebhookId      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	ResponseStatus int             `json:"response_status"`
+	ResponseBody   string          `json:"response_body"`
+	LastError      string          `json:"last_error"`
+	RedeliveryOf   string          `json:"redelivery_of"`
+	TsDelivered    *time.Time      `json:"ts_delivered"`
+	TsCreated      time.Time       `json:"ts_created"`
+}
+
+// kinds of entities found by a search
+const (
+	SEARCH_PROJECT   = "project"
+	SEARCH_PARTNER   = "boundary_partner"
+	SEARCH_MARKER    = "progress_marker"
+	SEARCH_CHALLENGE = "challenge"
+	SEARCH_STRATEGY  = "strategy"
+	SEARCH_JOURNAL   = "outcome_journal"
+)
+
+// SearchQuery is a full text search. The query is written like in a web
+// search engine: words, "quoted phrases", or and -excluded words.
+type SearchQuery struct {
+	Query string
+	// Types limits the search to kinds of entities, all when empty.
+	Types []string
+	// ProjectIds limits the search to projects, all of the tenant's when
+	// nil.
+	ProjectIds []string
+	Limit      int
+	Offset     int
+}
+
+// SearchResult is an entity found by a search, with the IDs of the parts of
+// the project it belongs to. Title and Snippet are HTML, with the matched
+// words in <mark> elements. The title of an outcome journal is its
+// description of change, the snippet comes from the rest of its narrative.
+type SearchResult struct {
+	Type              string  `json:"type"`
+	Id                string  `json:"id"`
+	ProjectId         string  `json:"project_id"`
+	ProjectName       string  `json:"project_name"`
+	BoundaryPartnerId string  `json:"boundary_partner_id,omitempty"`
+	ProgressMarkerId  string  `json:"progress_marker_id,omitempty"`
+	Title             string  `json:"title"`
+	Snippet           string  `json:"snippet"`
+	Rank              float64 `json:"rank"`
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Amount is an amount of money with four decimal places, kept as an integer
+// so that sums and comparisons are exact. It is written to JSON as a number
+// with its exact digits and read from a JSON number or string, never through
+// a float64.
+type Amount int64
+
+// number of units of an Amount in one unit of money
+const AMOUNT_SCALE = 10000
+
+// largest amount accepted from clients
+const MAX_AMOUNT = Amount(1e13 * AMOUNT_SCALE)
+
+var errAmountSyntax = errors.New("must be a decimal number with at most 4 decimal places")
+
+// ParseAmount reads an amount like "-1234.5". Exponents, thousands separators
+// and more than four decimal places are rejected.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	if whole == "" && fraction == "" || len(fraction) > 4 || len(whole) > 14 {
+		return 0, errAmountSyntax
+	}
+	for _, part := range []string{whole, fraction} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, errAmountSyntax
+			}
+		}
+	}
+	units, err := strconv.ParseInt("0"+whole+(fraction + "0000")[:4], 10, 64)
+	if err != nil {
+		return 0, errAmountSyntax
+	}
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// String formats an amount with at least two decimal places, like "1234.50".
+func (a Amount) String() string {
+	sign := ""
+	units := int64(a)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	fraction := strings.TrimRight(fmt.Sprintf("%04d", units%AMOUNT_SCALE), "0")
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	return fmt.Sprintf("%s%d.%s", sign, units/AMOUNT_SCALE, fraction)
+}
+
+// decimals returns the number of decimal places an amount needs.
+func (a Amount) decimals() int {
+	n := 4
+	for units := int64(a); n > 0 && units%10 == 0; units /= 10 {
+		n--
+	}
+	return n
+}
+
+// Round rounds an amount to the given number of decimal places, halves away
+// from zero.
+func (a Amount) Round(decimals int) Amount {
+	if decimals >= 4 {
+		return a
+	}
+	step := int64(1)
+	for i := decimals; i < 4; i++ {
+		step *= 10
+	}
+	units := int64(a)
+	if units < 0 {
+		return -Amount((-units + step/2) / step * step)
+	}
+	return Amount((units + step/2) / step * step)
+}
+
+// Percent returns a as a percentage of total, rounded to one decimal place,
+// halves away from zero, or nil if total is zero.
+func (a Amount) Percent(total Amount) *float64 {
+	if total == 0 {
+		return nil
+	}
+	ratio := new(big.Rat).SetFrac(big.NewInt(int64(a)), big.NewInt(int64(total)))
+	tenths, _ := new(big.Rat).SetInt(roundRat(ratio.Mul(ratio, big.NewRat(1000, 1)))).Float64()
+	percent := tenths / 10
+	return &percent
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return fmt.Errorf("amount %s %v", data, err)
+	}
+	*a = parsed
+	return nil
+}
+
+// Scan reads a NUMERIC column.
+func (a *Amount) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case []byte:
+		*a, err = ParseAmount(string(v))
+	case string:
+		*a, err = ParseAmount(v)
+	case int64:
+		*a = Amount(v * AMOUNT_SCALE)
+	default:
+		err = fmt.Errorf("can not scan %T into an Amount", src)
+	}
+	return err
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// convert converts an amount at a rate and rounds it to the given number of
+// decimal places, halves away from zero.
+func convert(a Amount, rate *big.Rat, decimals int) (Amount, error) {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	v := new(big.Rat).Mul(big.NewRat(int64(a), AMOUNT_SCALE), rate)
+	v.Mul(v, new(big.Rat).SetInt(scale))
+	q := roundRat(v)
+	units := q.Mul(q, new(big.Int).Quo(big.NewInt(AMOUNT_SCALE), scale))
+	if !units.IsInt64() || units.CmpAbs(big.NewInt(int64(MAX_AMOUNT))) > 0 {
+		return 0, errors.New("the converted amount is too large")
+	}
+	return Amount(units.Int64()), nil
+}
+
+// roundRat rounds a rational number to an integer, halves away from zero.
+func roundRat(v *big.Rat) *big.Int {
+	q, r := new(big.Int).QuoRem(v.Num(), v.Denom(), new(big.Int))
+	if r.Abs(r).Lsh(r, 1).Cmp(v.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(v.Sign())))
+	}
+	return q
+}
+
+// currencies maps the ISO 4217 codes of current currencies to their number
+// of decimal places.
+var currencies = map[string]int{}
+
+func init() {
+	codes := map[int]string{
+		0: "BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX UYI VND VUV XAF XOF XPF",
+		2: "AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BMD BND BOB BOV BRL BSD BTN BWP BYN BZD " +
+			"CAD CDF CHE CHF CHW CNY COP COU CRC CUC CUP CVE CZK DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS " +
+			"GIP GMD GTQ GYD HKD HNL HTG HUF IDR ILS INR IRR JMD KES KGS KHR KPW KYD KZT LAK LBP LKR LRD LSL MAD " +
+			"MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN NAD NGN NIO NOK NPR NZD PAB PEN PGK PHP PKR " +
+			"PLN QAR RON RSD RUB SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS TMT TOP TRY " +
+			"TTD TWD TZS UAH USD USN UYU UZS VED VES WST XCD YER ZAR ZMW ZWL",
+		3: "BHD IQD JOD KWD LYD OMR TND",
+		4: "CLF UYW",
+	}
+	for decimals, list := range codes {
+		for _, code := range strings.Fields(list) {
+			currencies[code] = decimals
+		}
+	}
+}
+
+func isCurrency(code string) bool {
+	_, ok := currencies[code]
+	return ok
+}
+
+// ErrNoRate is returned by a RateProvider that has no rate for a pair of
+// currencies.
+var ErrNoRate = errors.New("no exchange rate")
+
+// RateProvider supplies exchange rates for converting amounts into the
+// currency of a project.
+type RateProvider interface {
+	// Rate returns how many units of to one unit of from was worth on the
+	// given day.
+	Rate(from, to string, on time.Time) (*big.Rat, error)
+}
+
+func newRateProvider(config CurrencyConfig) RateProvider {
+	rates := staticRates{base: config.Base, rates: make(map[string]*big.Rat)}
+	for code, rate := range config.Rates {
+		rates.rates[code], _ = new(big.Rat).SetString(rate)
+	}
+	return rates
+}
+
+// staticRates converts through a fixed table of rates against a base
+// currency, whatever the day.
+type staticRates struct {
+	base  string
+	rates map[string]*big.Rat
+}
+
+func (s staticRates) Rate(from, to string, on time.Time) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	perBase := func(code string) *big.Rat {
+		if code == s.base {
+			return big.NewRat(1, 1)
+		}
+		return s.rates[code]
+	}
+	fromRate, toRate := perBase(from), perBase(to)
+	if fromRate == nil || toRate == nil || fromRate.Sign() <= 0 {
+		return nil, ErrNoRate
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+// validRates checks the static rate table of the config.
+func validRates(config CurrencyConfig) error {
+	codes := make([]string, 0, len(config.Rates))
+	for code := range config.Rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		rate, ok := new(big.Rat).SetString(config.Rates[code])
+		if !isCurrency(code) || !ok || rate.Sign() <= 0 {
+			return fmt.Errorf("currency.rates: %s must be an ISO 4217 code with a positive decimal rate", code)
+		}
+	}
+	return nil
+}
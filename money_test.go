@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want Amount
+		err  bool
+	}{
+		{"0", 0, false},
+		{"12", 120000, false},
+		{"12.5", 125000, false},
+		{"0.0001", 1, false},
+		{".5", 5000, false},
+		{"7.", 70000, false},
+		{" +3.25 ", 32500, false},
+		{"-0.01", -100, false},
+		{"99999999999999.9999", 999999999999999999, false},
+		{"", 0, true},
+		{".", 0, true},
+		{"-", 0, true},
+		{"1.00001", 0, true},
+		{"100000000000000", 0, true},
+		{"1,5", 0, true},
+		{"1e3", 0, true},
+		{"--1", 0, true},
+		{"1.-5", 0, true},
+	} {
+		got, err := ParseAmount(test.in)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("ParseAmount(%q) = %d, %v", test.in, got, err)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for _, test := range []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0.00"},
+		{125000, "12.50"},
+		{1, "0.0001"},
+		{12340, "1.234"},
+		{-100, "-0.01"},
+	} {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("%d: got %s, want %s", int64(test.in), got, test.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	for _, test := range []struct {
+		amount   Amount
+		rate     *big.Rat
+		decimals int
+		want     Amount
+		err      bool
+	}{
+		{1000000, big.NewRat(1, 1), 2, 1000000, false},
+		{1000000, big.NewRat(11, 10), 2, 1100000, false},
+		// 1.00 / 3 is 0.3333...
+		{10000, big.NewRat(1, 3), 2, 3300, false},
+		// 0.05 * 0.5 is 0.025, a half that rounds away from zero
+		{500, big.NewRat(1, 2), 2, 300, false},
+		{-500, big.NewRat(1, 2), 2, -300, false},
+		{10000, big.NewRat(2, 3), 0, 10000, false},
+		{10000, big.NewRat(2, 3), 4, 6667, false},
+		{MAX_AMOUNT - 1, big.NewRat(2, 1), 2, 0, true},
+	} {
+		got, err := convert(test.amount, test.rate, test.decimals)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("convert(%s, %s, %d) = %s, %v", test.amount, test.rate, test.decimals, got, err)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	for _, test := range []struct {
+		a, total Amount
+		want     float64
+	}{
+		{0, 100, 0},
+		{50, 100, 50},
+		{1, 3, 33.3},
+		{2, 3, 66.7},
+		{150, 100, 150},
+		// 1/16 is 6.25%, 1/2000 is 0.05%, halves round away from zero
+		{1, 16, 6.3},
+		{1, 2000, 0.1},
+		{-1, 16, -6.3},
+		{-1, 2000, -0.1},
+		{1, -2000, -0.1},
+	} {
+		got := test.a.Percent(test.total)
+		if got == nil || *got != test.want {
+			t.Errorf("%d of %d: got %v, want %v", int64(test.a), int64(test.total), got, test.want)
+		}
+	}
+	if got := Amount(1).Percent(0); got != nil {
+		t.Errorf("of zero: got %v", *got)
+	}
+}
+
+func TestStaticRates(t *testing.T) {
+	rates := newRateProvider(CurrencyConfig{Base: "EUR", Rates: map[string]string{"USD": "1.25", "KES": "125"}})
+	for _, test := range []struct {
+		from, to string
+		want     *big.Rat
+	}{
+		{"EUR", "EUR", big.NewRat(1, 1)},
+		{"XOF", "XOF", big.NewRat(1, 1)},
+		{"EUR", "USD", big.NewRat(5, 4)},
+		{"USD", "EUR", big.NewRat(4, 5)},
+		{"USD", "KES", big.NewRat(100, 1)},
+		{"EUR", "XOF", nil},
+	} {
+		got, err := rates.Rate(test.from, test.to, time.Now())
+		if test.want == nil {
+			if err != ErrNoRate {
+				t.Errorf("%s to %s: %v %v", test.from, test.to, got, err)
+			}
+			continue
+		}
+		if err != nil || got.Cmp(test.want) != 0 {
+			t.Errorf("%s to %s: got %v %v, want %v", test.from, test.to, got, err, test.want)
+		}
+	}
+}
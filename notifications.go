@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// Event is something a user did that other users are told about, or a
+// reminder without an actor. Recipients other than the actor get a
+// notification of the event's kind, as their preferences say. Link is the
+// API path of what it is about.
+type Event struct {
+	Kind       string
+	ProjectId  string
+	ActorId    string
+	Recipients []string
+	Title      string
+	Body       string
+	Link       string
+}
+
+// notificationKinds lists the kinds of notifications with how users are
+// told about them unless they say otherwise.
+var notificationKinds = []NotificationPreference{
+	{NOTIFY_TASK_ASSIGNED, true, EMAIL_IMMEDIATE},
+	{NOTIFY_ACTIVITY_ASSIGNED, true, EMAIL_DAILY},
+	{NOTIFY_MENTION, true, EMAIL_IMMEDIATE},
+	{NOTIFY_REPLY, true, EMAIL_DAILY},
+	{NOTIFY_PROJECT_UPDATED, true, EMAIL_DAILY},
+	{NOTIFY_MONITORING_DUE, true, EMAIL_IMMEDIATE},
+	{NOTIFY_MONITORING_OVERDUE, true, EMAIL_DAILY},
+	{NOTIFY_TIMELINE_ENDING, true, EMAIL_DAILY},
+	{NOTIFY_JOURNAL_OVERDUE, true, EMAIL_DAILY},
+}
+
+// notifications older than this that should have been mailed immediately
+// are taken to have failed and go out with the digest
+const MAIL_RETRY_AFTER = 10 * time.Minute
+
+// maximum number of notifications returned by GET /notifications
+const MAX_INBOX = 100
+
+// Notifier turns events into notifications and mails them.
+type Notifier struct {
+	store   Store
+	mailer  Mailer
+	baseUrl string
+}
+
+func NewNotifier(store Store, mailer Mailer, baseUrl string) *Notifier {
+	return &Notifier{store, mailer, strings.TrimSuffix(baseUrl, "/")}
+}
+
+// preferences returns how a user wants to be told about each kind of
+// notification.
+func (n *Notifier) preferences(t Tenant, userId string) ([]NotificationPreference, error) {
+	stored, err := n.store.GetNotificationPreferences(t, userId)
+	if err != nil {
+		return nil, err
+	}
+	preferences := make([]NotificationPreference, len(notificationKinds))
+	copy(preferences, notificationKinds)
+	for i := range preferences {
+		for _, p := range stored {
+			if p.Kind == preferences[i].Kind {
+				preferences[i] = p
+			}
+		}
+	}
+	return preferences, nil
+}
+
+func (n *Notifier) preference(t Tenant, userId, kind string) (NotificationPreference, error) {
+	preferences, err := n.preferences(t, userId)
+	if err != nil {
+		return NotificationPreference{}, err
+	}
+	for _, p := range preferences {
+		if p.Kind == kind {
+			return p, nil
+		}
+	}
+	return NotificationPreference{}, fmt.Errorf("unknown notification kind %q", kind)
+}
+
+// Publish notifies the recipients of an event. Members of other
+// organizations and grant officers are skipped. Failures are logged rather
+// than returned, since the change the event is about has been made.
+func (n *Notifier) Publish(t Tenant, e Event) {
+	seen := map[string]bool{e.ActorId: true, "": true}
+	for _, userId := range e.Recipients {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+		user, err := n.store.GetUser(userId)
+		if err != nil || user.OrganizationId != t.OrganizationId || user.DonorId != "" {
+			continue
+		}
+		p, err := n.preference(t, userId, e.Kind)
+		if err != nil {
+			errorf("notify %s: %v", userId, err)
+			continue
+		}
+		if !p.InApp && p.Email == EMAIL_NEVER {
+			continue
+		}
+		notification := Notification{
+			UserId:    userId,
+			Kind:      e.Kind,
+			ProjectId: e.ProjectId,
+			ActorId:   e.ActorId,
+			Title:     e.Title,
+			Body:      e.Body,
+			Link:      e.Link,
+			InApp:     p.InApp,
+			Email:     p.Email,
+		}
+		notification.NotificationId, err = n.store.AddNotification(t, notification)
+		if err != nil {
+			errorf("notify %s: %v", userId, err)
+			continue
+		}
+		if p.Email == EMAIL_IMMEDIATE {
+			go n.mailNow(t, notification)
+		}
+	}
+}
+
+// mailNow mails a notification on its own. If that fails, it goes out with
+// the next digest.
+func (n *Notifier) mailNow(t Tenant, notification Notification) {
+	email, err := n.store.GetUserEmail(notification.UserId)
+	if err == nil {
+		err = n.mailer.Send(Mail{email, notification.Title, n.mailBody(notification)})
+	}
+	if err == nil {
+		err = n.store.SetNotificationsMailed(t, []string{notification.NotificationId})
+	}
+	if err != nil {
+		warnf("mail notification %s: %v", notification.NotificationId, err)
+	}
+}
+
+func (n *Notifier) mailBody(notification Notification) string {
+	body := notification.Body
+	if notification.Link != "" && n.baseUrl != "" {
+		body += "\n\n" + n.baseUrl + notification.Link
+	}
+	return body
+}
+
+// SendDigests mails every user of every organization the notifications
+// still to be mailed in one message.
+func (n *Notifier) SendDigests(now time.Time) error {
+	organizationIds, err := n.store.GetOrganizationIds()
+	if err != nil {
+		return err
+	}
+	for _, organizationId := range organizationIds {
+		t := Tenant{organizationId}
+		pending, err := n.store.GetUnmailedNotifications(t)
+		if err != nil {
+			return err
+		}
+		var batch []Notification
+		for i, notification := range pending {
+			// immediate mail still being sent is left alone
+			if notification.Email == EMAIL_DAILY || now.Sub(notification.TsCreated) > MAIL_RETRY_AFTER {
+				batch = append(batch, notification)
+			}
+			if i == len(pending)-1 || pending[i+1].UserId != notification.UserId {
+				n.mailDigest(t, batch)
+				batch = nil
+			}
+		}
+	}
+	return nil
+}
+
+// mailDigest mails the notifications of a user in one message.
+func (n *Notifier) mailDigest(t Tenant, notifications []Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	userId := notifications[0].UserId
+	email, err := n.store.GetUserEmail(userId)
+	if err != nil {
+		warnf("mail digest to %s: %v", userId, err)
+		return
+	}
+	var body strings.Builder
+	ids := []string{}
+	for _, notification := range notifications {
+		fmt.Fprintf(&body, "* %s\n\n", notification.Title)
+		for _, line := range strings.Split(n.mailBody(notification), "\n") {
+			if line != "" {
+				body.WriteString("  " + line)
+			}
+			body.WriteString("\n")
+		}
+		body.WriteString("\n")
+		ids = append(ids, notification.NotificationId)
+	}
+	subject := "1 new notification"
+	if len(notifications) > 1 {
+		subject = strconv.Itoa(len(notifications)) + " new notifications"
+	}
+	if err = n.mailer.Send(Mail{email, subject, body.String()}); err != nil {
+		warnf("mail digest to %s: %v", userId, err)
+		return
+	}
+	if err = n.store.SetNotificationsMailed(t, ids); err != nil {
+		warnf("mail digest to %s: %v", userId, err)
+	}
+}
+
+// nextTimeOfDay returns the first time after now at the time of day at, in
+// UTC.
+func nextTimeOfDay(now time.Time, at string) time.Time {
+	clock, _ := time.Parse("15:04", at)
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// notify publishes an event caused by the user of the request.
+func (s *Server) notify(r *http.Request, e Event) {
+	user := context.Get(r, USER).(User)
+	e.ActorId = user.UserId
+	s.notifier.Publish(tenantOf(r), e)
+}
+
+// getNotifications returns the caller's newest notifications, only the
+// unread ones if the unread query parameter is true.
+func (s *Server) getNotifications(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	unreadOnly, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	notifications, err := s.store.GetNotifications(tenantOf(r), user.UserId, unreadOnly, MAX_INBOX)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	unread, err := s.store.CountUnreadNotifications(tenantOf(r), user.UserId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{Inbox{unread, notifications}, "success"})
+}
+
+func (s *Server) markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	err := s.store.MarkNotificationsRead(tenantOf(r), user.UserId, mux.Vars(r)["notificationId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) markAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	err := s.store.MarkNotificationsRead(tenantOf(r), user.UserId, "")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) getNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	preferences, err := s.notifier.preferences(tenantOf(r), user.UserId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{preferences, "success"})
+}
+
+// updateNotificationPreferences sets the caller's preferences for the kinds
+// given, as a list like the one getNotificationPreferences returns.
+func (s *Server) updateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	var input []NotificationPreference
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return
+	}
+	kinds := []string{}
+	for _, p := range notificationKinds {
+		kinds = append(kinds, p.Kind)
+	}
+	var errs FieldErrors
+	for i, p := range input {
+		errs = nestErrors(errs, fmt.Sprintf("[%d].", i), validate(
+			oneOf("kind", p.Kind, kinds...),
+			oneOf("email", p.Email, EMAIL_NEVER, EMAIL_IMMEDIATE, EMAIL_DAILY),
+		))
+	}
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	for _, p := range input {
+		if err := s.store.SetNotificationPreference(tenantOf(r), user.UserId, p); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+	s.getNotificationPreferences(w, r)
+}
+
+// projectUpdated tells the team of a project and the webhooks subscribed to
+// project.updated that a part of it changed.
+func (s *Server) projectUpdated(r *http.Request, projectId, part string) {
+	project, err := s.store.GetProject(tenantOf(r), projectId)
+	if err != nil {
+		errorf("notify project %s: %v", projectId, err)
+		return
+	}
+	s.publish(r, EVENT_PROJECT_UPDATED, map[string]interface{}{"project": project, "changed": part})
+	team, err := s.store.GetProjectTeam(tenantOf(r), projectId)
+	if err != nil {
+		errorf("notify project %s: %v", projectId, err)
+		return
+	}
+	user := context.Get(r, USER).(User)
+	s.notify(r, Event{
+		Kind:       NOTIFY_PROJECT_UPDATED,
+		ProjectId:  projectId,
+		Recipients: team,
+		Title:      fmt.Sprintf("The %s of %s changed", part, project.ProjectName),
+		Body:       fmt.Sprintf("%s changed the %s of %s.", user.FullName, part, project.ProjectName),
+		Link:       "/projects/" + projectId + "/dashboard",
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNextTimeOfDay(t *testing.T) {
+	tests := []struct {
+		now  string
+		at   string
+		want string
+	}{
+		{"2017-06-01T06:00:00Z", "07:30", "2017-06-01T07:30:00Z"},
+		{"2017-06-01T07:30:00Z", "07:30", "2017-06-02T07:30:00Z"},
+		{"2017-06-01T23:59:00Z", "07:30", "2017-06-02T07:30:00Z"},
+		{"2017-06-30T08:00:00Z", "00:00", "2017-07-01T00:00:00Z"},
+		// the time of day is in UTC whatever the zone of now
+		{"2017-06-01T09:00:00+02:00", "07:30", "2017-06-01T07:30:00Z"},
+	}
+	for _, test := range tests {
+		now, err := time.Parse(time.RFC3339, test.now)
+		check(t, err)
+		if got := nextTimeOfDay(now, test.at).Format(time.RFC3339); got != test.want {
+			t.Errorf("%s at %s: %s", test.now, test.at, got)
+		}
+	}
+}
+
+// inbox returns the caller's inbox.
+func (ts *testServer) inbox(key, query string) (int, []interface{}) {
+	ts.t.Helper()
+	code, out := ts.do(key, "GET", "/notifications"+query, "", "")
+	if code != http.StatusOK {
+		ts.t.Fatal(code, out)
+	}
+	inbox := out["data"].(map[string]interface{})
+	return int(inbox["unread"].(float64)), inbox["notifications"].([]interface{})
+}
+
+// TestNotificationInbox assigns a task and discusses it, and checks who is
+// told about what in the inbox.
+func TestNotificationInbox(t *testing.T) {
+	ts := newTestServer(t)
+	admin, member := ts.login("ada@a.org"), ts.login("ann@a.org")
+	projectId, _, challengeId, _ := addTestMarker(t, ts.store)
+
+	if code, out := ts.do(member, "POST", "/notifications/preferences", "application/json", `[{"kind":"digest","in_app":true,"email":"daily"}]`); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+	// Ann does not want to hear about replies
+	code, out := ts.do(member, "POST", "/notifications/preferences", "application/json", `[{"kind":"reply","in_app":false,"email":"never"}]`)
+	if code != http.StatusOK || len(out["data"].([]interface{})) != len(notificationKinds) {
+		t.Fatal(code, out)
+	}
+
+	code, out = ts.do(admin, "POST", "/projects/"+projectId+"/tasks", "application/json",
+		`{"title":"Arrange a bus","challenge_id":"`+challengeId+`","assignee_id":"`+MEMBER_A+`"}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	unread, notifications := ts.inbox(member, "")
+	if unread != 1 || len(notifications) != 1 {
+		t.Fatal(unread, notifications)
+	}
+	assigned := notifications[0].(map[string]interface{})
+	if assigned["kind"] != NOTIFY_TASK_ASSIGNED || assigned["actor_id"] != ADMIN_A || assigned["actor_name"] != "Ada" ||
+		assigned["project_id"] != projectId || assigned["read"] != false {
+		t.Fatal(assigned)
+	}
+
+	// the actor is not told about their own mention, nor Ann about a reply
+	code, out = ts.do(member, "POST", "/projects/"+projectId+"/comments", "application/json",
+		`{"entity_type":"project","body":"@[Ada](`+ADMIN_A+`) @[Ann](`+MEMBER_A+`) which bus?"}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	threadId := out["data"].(map[string]interface{})["comment_id"].(string)
+	code, out = ts.do(admin, "POST", "/projects/"+projectId+"/comments", "application/json",
+		`{"entity_type":"project","parent_id":"`+threadId+`","body":"The school bus"}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	if unread, notifications = ts.inbox(member, ""); unread != 1 || len(notifications) != 1 {
+		t.Fatal(unread, notifications)
+	}
+	unread, notifications = ts.inbox(admin, "")
+	if unread != 1 || len(notifications) != 1 || notifications[0].(map[string]interface{})["kind"] != NOTIFY_MENTION {
+		t.Fatal(unread, notifications)
+	}
+
+	// a notification is only marked read by its user
+	assignedPath := "/notifications/" + assigned["notification_id"].(string) + "/read"
+	if code, out = ts.do(admin, "POST", assignedPath, "", ""); code != http.StatusNotFound {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(member, "POST", assignedPath, "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	if unread, notifications = ts.inbox(member, "?unread=true"); unread != 0 || len(notifications) != 0 {
+		t.Fatal(unread, notifications)
+	}
+	if unread, notifications = ts.inbox(member, ""); len(notifications) != 1 || notifications[0].(map[string]interface{})["read"] != true {
+		t.Fatal(unread, notifications)
+	}
+	if code, out = ts.do(admin, "POST", "/notifications/read", "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	if unread, _ = ts.inbox(admin, ""); unread != 0 {
+		t.Fatal(unread)
+	}
+}
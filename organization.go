@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+)
+
+func (s *Server) getOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := s.store.GetOrganization(tenantOf(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{org, "success"})
+}
+
+// updateOrganization sets the branding of the organization's reports.
+func (s *Server) updateOrganization(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	var input struct {
+		BrandColor string `json:"brand_color"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return
+	}
+	color := strings.ToLower(strings.TrimSpace(input.BrandColor))
+	if errs := validate(hexColor("brand_color", color)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	if err := s.store.SetBrandColor(tenantOf(r), color); err != nil {
+		respondError(w, err)
+		return
+	}
+	s.getOrganization(w, r)
+}
+
+// updateOrganizationLogo sets the logo shown on the reports of every project.
+func (s *Server) updateOrganizationLogo(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	logo, unpin, ok := s.receiveLogo(w, r, "organization_logo", "logo of organization "+user.OrganizationId)
+	if !ok {
+		return
+	}
+	old, err := s.store.SetOrganizationLogo(tenantOf(r), logo)
+	unpin()
+	if err != nil {
+		s.releaseLogo(tenantOf(r), logo)
+		respondError(w, err)
+		return
+	}
+	s.releaseLogo(tenantOf(r), old)
+
+	JSON(w, http.StatusOK, Response{organizationLogo(*logo), "success"})
+}
+
+func (s *Server) resetOrganizationLogo(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	old, err := s.store.SetOrganizationLogo(tenantOf(r), nil)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.releaseLogo(tenantOf(r), old)
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) getOrganizationLogo(w http.ResponseWriter, r *http.Request) {
+	org, err := s.store.GetOrganization(tenantOf(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if org.Logo == nil {
+		JSON(w, http.StatusNotFound, Response{nil, "organization has no logo"})
+		return
+	}
+	s.serveLogo(w, r, org.Logo)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPDFColor(t *testing.T) {
+	for in, want := range map[string]string{
+		"#ff8000": "1.000 0.502 0.000",
+		"#000000": "0.000 0.000 0.000",
+		"":        pdfColor(DEFAULT_BRAND_COLOR),
+		"#FF8000": pdfColor(DEFAULT_BRAND_COLOR),
+		"red":     pdfColor(DEFAULT_BRAND_COLOR),
+	} {
+		if got := pdfColor(in); got != want {
+			t.Errorf("%q: got %s", in, got)
+		}
+	}
+}
+
+// TestOrganizationBranding sets the color and the logo of organization A and
+// finds them on its reports.
+func TestOrganizationBranding(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	get := func(key, path string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.srv.URL+path, nil)
+		check(t, err)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		check(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		check(t, err)
+		return resp, string(body)
+	}
+
+	if code, out := ts.do(key, "POST", "/organization", "application/json", `{"brand_color":"red"}`); code != 400 {
+		t.Fatal(code, out)
+	}
+	code, out := ts.do(key, "POST", "/organization", "application/json", `{"brand_color":" #FF8000 "}`)
+	if code != 200 || out["data"].(map[string]interface{})["brand_color"] != "#ff8000" {
+		t.Fatal(code, out)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	var logo bytes.Buffer
+	check(t, png.Encode(&logo, img))
+	if code, out := ts.upload(key, "/organization/logo", "organization_logo", "logo.png", "not an image"); code != 400 {
+		t.Fatal(code, out)
+	}
+	if code, out := ts.upload(key, "/organization/logo", "organization_logo", "logo.png", logo.String()); code != 200 {
+		t.Fatal(code, out)
+	}
+	if resp, _ := get(key, "/organization/logo/original"); resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	if resp, _ := get(ts.login("bo@b.org"), "/organization/logo/original"); resp.StatusCode != 404 {
+		t.Fatal("logo of organization A served to B:", resp.Status)
+	}
+
+	projectId, err := ts.store.AddProject(Tenant{ORG_A}, Project{ProjectName: "Radio"})
+	check(t, err)
+	resp, body := get(key, "/projects/"+projectId+"/report?format=html")
+	if resp.StatusCode != 200 || !strings.Contains(body, "solid #ff8000") || !strings.Contains(body, `<img src="data:image/png;base64,`) {
+		t.Fatal(resp.Status, body)
+	}
+	resp, body = get(key, "/projects/"+projectId+"/report?format=pdf")
+	if resp.StatusCode != 200 || !strings.Contains(body, "/OrgLogo ") || strings.Contains(body, "/Logo ") {
+		t.Fatal(resp.Status, body)
+	}
+
+	if code, out := ts.do(key, "DELETE", "/organization/logo", "", ""); code != 200 {
+		t.Fatal(code, out)
+	}
+	if resp, _ := get(key, "/organization/logo/original"); resp.StatusCode != 404 {
+		t.Fatal(resp.Status)
+	}
+	if _, body = get(key, "/projects/"+projectId+"/report?format=pdf"); strings.Contains(body, "/XObject") {
+		t.Fatal("removed logo still on the report")
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The PDF report is laid out from a small line based markup produced by the
+// report template:
+//
+//	# Title, ## Heading, ### Subheading
+//	- list item
+//	  - nested list item
+//	---                  a horizontal rule
+//	!logo                the project logo, if there is one
+//	!orglogo             the organization's logo, if there is one
+//	!pagebreak           start a new page
+//	\text                text starting with one of the markers above
+//
+// Other lines are paragraph text, consecutive lines are joined and blank
+// lines end a paragraph. Section headings and rules are set in the
+// organization's color. Text is set in the standard Helvetica fonts, which
+// every PDF reader has, so no font files are needed. They only cover
+// Windows-1252; other characters are set as "?" and reported by
+// unsupportedRunes, the HTML report shows them.
+
+// A4 in points and the page margin
+const (
+	PDF_PAGE_WIDTH  = 595.0
+	PDF_PAGE_HEIGHT = 842.0
+	PDF_MARGIN      = 56.0
+)
+
+// height of the logos on the report
+const PDF_LOGO_HEIGHT = 64.0
+
+// images the markup can place by their marker, and the names of their
+// XObjects, in the order their objects are written
+var pdfImageNames = []string{"Logo", "OrgLogo"}
+
+var pdfImageMarkers = map[string]string{"!logo": "Logo", "!orglogo": "OrgLogo"}
+
+type pdfFont struct {
+	name   string
+	widths *[95]int
+}
+
+var (
+	pdfRegular = pdfFont{"F1", &helveticaWidths}
+	pdfBold    = pdfFont{"F2", &helveticaBoldWidths}
+)
+
+// widths of the printable ASCII characters in 1/1000 of the font size, from
+// the Adobe font metrics of the standard fonts
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// characters of Windows-1252 that are not at their Unicode code point
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// winAnsiByte returns the code of a character in the standard fonts.
+func winAnsiByte(r rune) (byte, bool) {
+	if b, special := winAnsiSpecials[r]; special {
+		return b, true
+	}
+	switch {
+	case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+		return byte(r), true
+	case r == '\t':
+		return ' ', true
+	}
+	return 0, false
+}
+
+// winAnsi encodes text for the standard fonts, characters they lack become "?".
+func winAnsi(text string) []byte {
+	encoded := make([]byte, 0, len(text))
+	for _, r := range text {
+		b, ok := winAnsiByte(r)
+		if !ok {
+			b = '?'
+		}
+		encoded = append(encoded, b)
+	}
+	return encoded
+}
+
+// unsupportedRunes returns the characters of text that winAnsi can not
+// encode, each once, in the order they first appear. Line breaks are not
+// counted.
+func unsupportedRunes(text string) []rune {
+	var unsupported []rune
+	seen := make(map[rune]bool)
+	for _, r := range text {
+		if _, ok := winAnsiByte(r); ok || r == '\n' || r == '\r' || seen[r] {
+			continue
+		}
+		seen[r] = true
+		unsupported = append(unsupported, r)
+	}
+	return unsupported
+}
+
+// width returns the width of encoded text in points.
+func (f pdfFont) width(encoded []byte, size float64) float64 {
+	total := 0
+	for _, b := range encoded {
+		if b >= 0x20 && b < 0x7f {
+			total += f.widths[b-0x20]
+		} else {
+			// accented letters are about as wide as an average lower case letter
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// pdfImage is an image XObject, stored as JPEG or as zlib compressed RGB
+// with an optional soft mask for the alpha channel.
+type pdfImage struct {
+	width, height int
+	filter        string
+	colorSpace    string
+	data          []byte
+	alpha         []byte
+}
+
+// newPDFImage prepares a PNG or JPEG file for embedding. JPEG files
+// are embedded as they are.
+func newPDFImage(data []byte) (*pdfImage, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		img := &pdfImage{width: config.Width, height: config.Height, filter: "DCTDecode", data: data, colorSpace: "DeviceRGB"}
+		if config.ColorModel == color.GrayModel {
+			img.colorSpace = "DeviceGray"
+		}
+		return img, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := decoded.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := decoded.At(x, y).RGBA()
+			// un-premultiply, the soft mask applies the alpha
+			if a > 0 && a < 0xffff {
+				r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+			}
+			rgb = append(rgb, byte(r>>8), byte(g>>8), byte(b>>8))
+			alpha = append(alpha, byte(a>>8))
+			opaque = opaque && a == 0xffff
+		}
+	}
+	img := &pdfImage{width: bounds.Dx(), height: bounds.Dy(), filter: "FlateDecode", colorSpace: "DeviceRGB", data: deflate(rgb)}
+	if !opaque {
+		img.alpha = deflate(alpha)
+	}
+	return img, nil
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// pdfLayout lays out the report markup on pages.
+type pdfLayout struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	// y is the baseline position of the next line, from the bottom of the page
+	y      float64
+	images map[string]*pdfImage
+	// color is the brand color as PDF RGB operands
+	color string
+	// paragraph collects the lines of the current paragraph
+	paragraph []string
+}
+
+// renderPDF turns report markup into a PDF document. images holds the
+// logos by their name in pdfImageNames, color is a #rrggbb color.
+func renderPDF(markup, title string, images map[string]*pdfImage, color string, created time.Time) []byte {
+	l := &pdfLayout{images: images, color: pdfColor(color)}
+	l.newPage()
+	for _, line := range strings.Split(markup, "\n") {
+		l.line(strings.TrimRight(line, " \t\r"))
+	}
+	l.flush()
+	return l.document(title, created)
+}
+
+func (l *pdfLayout) newPage() {
+	l.page = &bytes.Buffer{}
+	l.pages = append(l.pages, l.page)
+	l.y = PDF_PAGE_HEIGHT - PDF_MARGIN
+}
+
+// space moves down by height, starting a new page if it does not fit.
+func (l *pdfLayout) space(height float64) {
+	if l.y-height < PDF_MARGIN {
+		l.newPage()
+	}
+	l.y -= height
+}
+
+func (l *pdfLayout) line(line string) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "":
+		l.flush()
+	case strings.HasPrefix(trimmed, "\\"):
+		l.paragraph = append(l.paragraph, trimmed[1:])
+	case strings.HasPrefix(trimmed, "### "):
+		l.flush()
+		l.heading(trimmed[4:], 12, 8, "")
+	case strings.HasPrefix(trimmed, "## "):
+		l.flush()
+		l.heading(trimmed[3:], 15, 12, l.color)
+	case strings.HasPrefix(trimmed, "# "):
+		l.flush()
+		l.heading(trimmed[2:], 20, 16, "")
+	case strings.HasPrefix(trimmed, "- "):
+		l.flush()
+		indent := 14.0
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			indent = 28
+		}
+		l.text(pdfRegular, 10.5, indent, "•", trimmed[2:], "")
+	case trimmed == "---":
+		l.flush()
+		l.space(10)
+		fmt.Fprintf(l.page, "%s RG 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", l.color, PDF_MARGIN, l.y+4, PDF_PAGE_WIDTH-PDF_MARGIN, l.y+4)
+	case trimmed == "!pagebreak":
+		l.flush()
+		l.newPage()
+	case pdfImageMarkers[trimmed] != "":
+		l.flush()
+		l.drawImage(pdfImageMarkers[trimmed])
+	default:
+		l.paragraph = append(l.paragraph, trimmed)
+	}
+}
+
+// flush sets the collected paragraph.
+func (l *pdfLayout) flush() {
+	if len(l.paragraph) == 0 {
+		return
+	}
+	l.text(pdfRegular, 10.5, 0, "", strings.Join(l.paragraph, " "), "")
+	l.paragraph = nil
+	l.space(6)
+}
+
+// heading sets a heading in the given color, or in black if it is empty.
+func (l *pdfLayout) heading(text string, size, before float64, color string) {
+	l.space(before)
+	// keep a heading together with at least one line of what follows
+	if l.y-size*1.3-14 < PDF_MARGIN {
+		l.newPage()
+	}
+	l.text(pdfBold, size, 0, "", text, color)
+	l.space(2)
+}
+
+// text sets wrapped text. A bullet is set in front of the first line and the
+// text is indented by indent points.
+func (l *pdfLayout) text(font pdfFont, size, indent float64, bullet, text, color string) {
+	x := PDF_MARGIN + indent
+	maxWidth := PDF_PAGE_WIDTH - PDF_MARGIN - x
+	leading := size * 1.3
+	for i, line := range wrap(font, size, maxWidth, text) {
+		l.space(leading)
+		if i == 0 && bullet != "" {
+			l.show(font, size, x-10, bullet, color)
+		}
+		l.show(font, size, x, line, color)
+	}
+}
+
+func (l *pdfLayout) show(font pdfFont, size, x float64, text, color string) {
+	if color != "" {
+		fmt.Fprintf(l.page, "q %s rg BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET Q\n", color, font.name, size, x, l.y, pdfString(winAnsi(text)))
+		return
+	}
+	fmt.Fprintf(l.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font.name, size, x, l.y, pdfString(winAnsi(text)))
+}
+
+// wrap breaks text into lines that fit into width. A word too long for a
+// line is broken where it overflows.
+func wrap(font pdfFont, size, width float64, text string) []string {
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if font.width(winAnsi(candidate), size) <= width {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+		}
+		for font.width(winAnsi(word), size) > width {
+			runes := []rune(word)
+			n := len(runes) - 1
+			for n > 1 && font.width(winAnsi(string(runes[:n])), size) > width {
+				n--
+			}
+			lines = append(lines, string(runes[:n]))
+			word = string(runes[n:])
+		}
+		current = word
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// drawImage places one of the images by its name in pdfImageNames.
+func (l *pdfLayout) drawImage(name string) {
+	img := l.images[name]
+	if img == nil {
+		return
+	}
+	height := PDF_LOGO_HEIGHT
+	width := height * float64(img.width) / float64(img.height)
+	if max := PDF_PAGE_WIDTH - 2*PDF_MARGIN; width > max {
+		width, height = max, max*float64(img.height)/float64(img.width)
+	}
+	l.space(height)
+	fmt.Fprintf(l.page, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", width, height, PDF_MARGIN, l.y, name)
+	l.space(8)
+}
+
+// pdfColor returns a #rrggbb color as the operands of the PDF color
+// operators, or the default brand color if it is not one.
+func pdfColor(color string) string {
+	rgb, err := strconv.ParseUint(strings.TrimPrefix(color, "#"), 16, 32)
+	if err != nil || !colorPattern.MatchString(color) {
+		return pdfColor(DEFAULT_BRAND_COLOR)
+	}
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(rgb>>16)/255, float64(rgb>>8&0xff)/255, float64(rgb&0xff)/255)
+}
+
+// pdfString escapes text for a PDF string literal.
+func pdfString(text []byte) string {
+	var b strings.Builder
+	for _, c := range text {
+		if c == '(' || c == ')' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// document writes the pages as a PDF file with a cross-reference table.
+func (l *pdfLayout) document(title string, created time.Time) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	// objects are numbered from 1 in the order they are written
+	object := func(format string, args ...interface{}) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&out, format, args...)
+		out.WriteString("\nendobj\n")
+	}
+	stream := func(dict string, data []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s /Length %d >>\nstream\n", len(offsets), dict, len(data))
+		out.Write(data)
+		out.WriteString("\nendstream\nendobj\n")
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3 and 4 fonts, 5 info, an image and its mask
+	// for each of pdfImageNames, then a page and its content for every page
+	firstPage := 6 + 2*len(pdfImageNames)
+	kids := make([]string, len(l.pages))
+	for i := range l.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(l.pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Title (%s) /Producer (Lucid) /CreationDate (D:%s) >>",
+		pdfString(winAnsi(title)), created.UTC().Format("20060102150405Z"))
+
+	var xobjects []string
+	for _, name := range pdfImageNames {
+		img := l.images[name]
+		if img == nil {
+			object("null")
+			object("null")
+			continue
+		}
+		xobjects = append(xobjects, fmt.Sprintf("/%s %d 0 R", name, len(offsets)+1))
+		mask := ""
+		if img.alpha != nil {
+			mask = fmt.Sprintf(" /SMask %d 0 R", len(offsets)+2)
+		}
+		stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s%s",
+			img.width, img.height, img.colorSpace, img.filter, mask), img.data)
+		if img.alpha != nil {
+			stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+				img.width, img.height), img.alpha)
+		} else {
+			object("null")
+		}
+	}
+	resources := "<< /Font << /F1 3 0 R /F2 4 0 R >> >>"
+	if len(xobjects) > 0 {
+		resources = "<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << " + strings.Join(xobjects, " ") + " >> >>"
+	}
+
+	for i, page := range l.pages {
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R >>",
+			PDF_PAGE_WIDTH, PDF_PAGE_HEIGHT, resources, firstPage+2*i+1)
+		stream("/Filter /FlateDecode", deflate(page.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// jobs returns the background jobs of the server. Reminders are recorded as
+// sent, so running a job again, after a failure or in the next hour, does
+// not repeat them.
+func (s *Server) jobs() []jobSpec {
+	return []jobSpec{
+		{name: "monitoring_reminders", next: every(time.Hour), run: s.remindMonitoring},
+		{name: "overdue_monitoring", next: every(time.Hour), run: s.flagOverdueMonitoring},
+		{name: "overdue_journals", next: every(time.Hour), run: s.flagOverdueJournals},
+		{name: "timeline_reminders", next: every(time.Hour), run: s.remindTimelines},
+		{name: "notification_digest", next: daily(s.config.Notifications.DigestTime), run: s.notifier.SendDigests},
+		{name: "webhook_deliveries", next: every(time.Minute), run: s.retryWebhooks},
+		{name: "rescan_pending", next: every(15 * time.Minute), run: s.rescanPending},
+		{name: "purge", next: every(time.Hour), run: s.purge},
+		{name: "purge_sessions", next: every(10 * time.Minute), run: s.purgeSessions, local: true},
+	}
+}
+
+// forEachOrganization calls fn for every organization and returns the first
+// error, after trying all of them.
+func (s *Server) forEachOrganization(fn func(t Tenant) error) error {
+	organizationIds, err := s.store.GetOrganizationIds()
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, organizationId := range organizationIds {
+		if err := fn(Tenant{organizationId}); err != nil && first == nil {
+			first = fmt.Errorf("organization %s: %v", organizationId, err)
+		}
+	}
+	return first
+}
+
+// remind publishes an event as a reminder, unless the reminder with the
+// given key has been sent before.
+func (s *Server) remind(t Tenant, key string, e Event) error {
+	sent, err := s.store.MarkReminderSent(t, key)
+	if err != nil || !sent {
+		return err
+	}
+	s.notifier.Publish(t, e)
+	return nil
+}
+
+// projectNames returns the names of the tenant's projects by ID.
+func (s *Server) projectNames(t Tenant) (map[string]string, error) {
+	projects, err := s.store.GetProjects(t)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, p := range projects {
+		names[p.ProjectId] = p.ProjectName
+	}
+	return names, nil
+}
+
+// activityRecipients returns who is told about an activity: its owner, or
+// the admins if it has none.
+func (s *Server) activityRecipients(t Tenant, a Activity) ([]string, error) {
+	if a.OwnerId != "" {
+		return []string{a.OwnerId}, nil
+	}
+	return s.store.GetAdminIds(t)
+}
+
+// remindMonitoring reminds the owners of the monitoring dates coming up in
+// the next reminder_days days, once per date.
+func (s *Server) remindMonitoring(now time.Time) error {
+	today := now.UTC().Format("2006-01-02")
+	until := now.UTC().AddDate(0, 0, s.config.Jobs.ReminderDays).Format("2006-01-02")
+	return s.forEachOrganization(func(t Tenant) error {
+		due, err := s.store.GetDueActivities(t, ACTIVITY_MONITORING, today, until)
+		if err != nil {
+			return err
+		}
+		names, err := s.projectNames(t)
+		if err != nil {
+			return err
+		}
+		for _, a := range due {
+			recipients, err := s.activityRecipients(t, a)
+			if err != nil {
+				return err
+			}
+			err = s.remind(t, "monitoring_due:"+a.ActivityId+":"+a.StartsOn, Event{
+				Kind:       NOTIFY_MONITORING_DUE,
+				ProjectId:  a.ProjectId,
+				Recipients: recipients,
+				Title:      fmt.Sprintf("Monitoring due on %s: %s", a.StartsOn, a.Title),
+				Body: fmt.Sprintf("%s of %s is due on %s. Record the changes observed in the boundary partners "+
+					"and the progress of the strategies since the last monitoring.", a.Title, names[a.ProjectId], a.StartsOn),
+				Link: "/projects/" + a.ProjectId + "/activities/" + a.ActivityId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// flagOverdueMonitoring tells the owners of monitoring dates that have passed
+// without being marked done, once per date. The monitoring date is where the
+// outcome journals of the period are recorded.
+func (s *Server) flagOverdueMonitoring(now time.Time) error {
+	yesterday := now.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	return s.forEachOrganization(func(t Tenant) error {
+		overdue, err := s.store.GetDueActivities(t, ACTIVITY_MONITORING, "", yesterday)
+		if err != nil {
+			return err
+		}
+		names, err := s.projectNames(t)
+		if err != nil {
+			return err
+		}
+		for _, a := range overdue {
+			recipients, err := s.activityRecipients(t, a)
+			if err != nil {
+				return err
+			}
+			err = s.remind(t, "monitoring_overdue:"+a.ActivityId+":"+a.StartsOn, Event{
+				Kind:       NOTIFY_MONITORING_OVERDUE,
+				ProjectId:  a.ProjectId,
+				Recipients: recipients,
+				Title:      "Monitoring overdue: " + a.Title,
+				Body: fmt.Sprintf("%s of %s was due on %s and has not been marked done.",
+					a.Title, names[a.ProjectId], a.StartsOn),
+				Link: "/projects/" + a.ProjectId + "/activities/" + a.ActivityId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// flagOverdueJournals tells whoever started an outcome journal that is still
+// a draft after its monitoring date, or the admins if that is not known,
+// once per journal and date.
+func (s *Server) flagOverdueJournals(now time.Time) error {
+	today := now.UTC().Format("2006-01-02")
+	return s.forEachOrganization(func(t Tenant) error {
+		overdue, err := s.store.GetOverdueJournals(t, today)
+		if err != nil {
+			return err
+		}
+		names, err := s.projectNames(t)
+		if err != nil {
+			return err
+		}
+		for _, j := range overdue {
+			recipients := []string{j.CreatedBy}
+			if j.CreatedBy == "" {
+				if recipients, err = s.store.GetAdminIds(t); err != nil {
+					return err
+				}
+			}
+			err = s.remind(t, "journal_overdue:"+j.JournalId+":"+j.MonitoringDate, Event{
+				Kind:       NOTIFY_JOURNAL_OVERDUE,
+				ProjectId:  j.ProjectId,
+				Recipients: recipients,
+				Title:      fmt.Sprintf("Outcome journal overdue: %s, %s", j.PartnerName, j.MonitoringDate),
+				Body: fmt.Sprintf("The outcome journal of %s in %s for the monitoring on %s has not been submitted.",
+					j.PartnerName, names[j.ProjectId], j.MonitoringDate),
+				Link: "/projects/" + j.ProjectId + "/journals/" + j.JournalId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// remindTimelines tells the team and the admins of the projects whose
+// timeline ends in the next timeline_warning_days days, once per end date.
+func (s *Server) remindTimelines(now time.Time) error {
+	today := now.UTC().Format("2006-01-02")
+	until := now.UTC().AddDate(0, 0, s.config.Jobs.TimelineWarningDays).Format("2006-01-02")
+	return s.forEachOrganization(func(t Tenant) error {
+		projects, err := s.store.GetProjects(t)
+		if err != nil {
+			return err
+		}
+		admins, err := s.store.GetAdminIds(t)
+		if err != nil {
+			return err
+		}
+		for _, p := range projects {
+			ends := timelineDay(p.TimelineTo)
+			if ends == "" || ends < today || ends > until {
+				continue
+			}
+			team, err := s.store.GetProjectTeam(t, p.ProjectId)
+			if err != nil {
+				return err
+			}
+			days := daysBetween(today, ends)
+			when := fmt.Sprintf("in %d days", days)
+			switch days {
+			case 0:
+				when = "today"
+			case 1:
+				when = "tomorrow"
+			}
+			err = s.remind(t, "timeline_ending:"+p.ProjectId+":"+ends, Event{
+				Kind:       NOTIFY_TIMELINE_ENDING,
+				ProjectId:  p.ProjectId,
+				Recipients: append(team, admins...),
+				Title:      fmt.Sprintf("%s ends on %s", p.ProjectName, ends),
+				Body:       fmt.Sprintf("The timeline of %s ends %s, on %s.", p.ProjectName, when, ends),
+				Link:       "/projects/" + p.ProjectId + "/dashboard",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// daysBetween returns the number of days from one day like 2006-01-02 to
+// another.
+func daysBetween(from, to string) int {
+	start, _ := time.Parse("2006-01-02", from)
+	end, _ := time.Parse("2006-01-02", to)
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// purge deletes the notifications and job runs older than the retention.
+func (s *Server) purge(now time.Time) error {
+	before := now.Add(-time.Duration(s.config.Jobs.Retention))
+	err := s.forEachOrganization(func(t Tenant) error {
+		purged, err := s.store.PurgeNotifications(t, before)
+		if purged > 0 {
+			infof("purged %d notifications of organization %s", purged, t.OrganizationId)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	purged, err := s.store.PurgeJobs(before)
+	if purged > 0 {
+		infof("purged %d job runs", purged)
+	}
+	return err
+}
+
+func (s *Server) purgeSessions(now time.Time) error {
+	if purged := s.sessions.Purge(); purged > 0 {
+		debugf("purged %d expired sessions", purged)
+	}
+	return nil
+}
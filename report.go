@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// report formats
+const (
+	REPORT_HTML = "html"
+	REPORT_PDF  = "pdf"
+)
+
+// largest custom report template accepted
+const MAX_TEMPLATE_SIZE = 256 << 10
+
+// largest logo read into a report
+const MAX_REPORT_LOGO_SIZE = 4 << 20
+
+// color of the headings and rules of the reports of organizations that have
+// not chosen one
+const DEFAULT_BRAND_COLOR = "#2b6a8e"
+
+// characters the PDF fonts lack that are named in the report's Warning header
+const MAX_REPORTED_RUNES = 20
+
+// built-in report templates, see pdf.go for the markup of the PDF template
+//
+//go:embed templates/report.html templates/report.pdf.txt
+var reportTemplateFiles embed.FS
+
+var defaultReportTemplates = map[string]string{
+	REPORT_HTML: "templates/report.html",
+	REPORT_PDF:  "templates/report.pdf.txt",
+}
+
+// names of the progress marker levels
+var markerLevels = map[int]string{
+	MARKER_EXPECT: "Expect to see",
+	MARKER_LIKE:   "Like to see",
+	MARKER_LOVE:   "Love to see",
+}
+
+// reportData is what report templates are executed with.
+type reportData struct {
+	Organization Organization
+	Project      ExportedProject
+	// Logo is the project logo as a data URL, empty if there is none.
+	Logo htmltemplate.URL
+	// OrganizationLogo is the organization's logo as a data URL, empty if
+	// there is none.
+	OrganizationLogo htmltemplate.URL
+	// BrandColor is the organization's color as #rrggbb.
+	BrandColor  htmltemplate.CSS
+	Partners    []reportPartner
+	Resources   []ExportedResource
+	GeneratedAt time.Time
+}
+
+type reportPartner struct {
+	*BoundaryPartner
+	// Levels has the progress markers grouped by level, levels without
+	// markers are left out.
+	Levels []reportLevel
+}
+
+type reportLevel struct {
+	Level   int
+	Name    string
+	Markers []*ProgressMarker
+}
+
+func newReportData(org Organization, doc ProjectExport) reportData {
+	data := reportData{
+		Organization: org,
+		Project:      doc.Project,
+		BrandColor:   DEFAULT_BRAND_COLOR,
+		Partners:     []reportPartner{},
+		Resources:    doc.Resources,
+		GeneratedAt:  time.Now().UTC(),
+	}
+	// the color is validated when it is set
+	if colorPattern.MatchString(org.BrandColor) {
+		data.BrandColor = htmltemplate.CSS(org.BrandColor)
+	}
+	for _, bp := range doc.BoundaryPartners {
+		partner := reportPartner{BoundaryPartner: bp}
+		for level := MARKER_EXPECT; level <= MARKER_LOVE; level++ {
+			var markers []*ProgressMarker
+			for _, pm := range bp.ProgressMarkers {
+				if pm.Type == level {
+					markers = append(markers, pm)
+				}
+			}
+			if len(markers) > 0 {
+				partner.Levels = append(partner.Levels, reportLevel{level, markerLevels[level], markers})
+			}
+		}
+		data.Partners = append(data.Partners, partner)
+	}
+	return data
+}
+
+// sampleReport is a report with every part filled in, custom templates are
+// tried on it before they are saved.
+func sampleReport() reportData {
+	marker := &ProgressMarker{
+		Title:      "Marker",
+		Type:       MARKER_EXPECT,
+		Challenges: []*Challenge{{ChallengeName: "Challenge"}},
+		Strategies: []*Strategy{{StrategyName: "Strategy"}},
+	}
+	return newReportData(Organization{OrganizationName: "Organization"}, ProjectExport{
+		Project: ExportedProject{
+			ProjectName:  "Project",
+			Description:  "Description",
+			Budget:       1000 * AMOUNT_SCALE,
+			Currency:     "USD",
+			Donor:        "Donor",
+			Vision:       "Vision",
+			Mission:      "Mission",
+			TimelineFrom: "2020-01-01T00:00:00Z",
+			TimelineTo:   "2021-01-01T00:00:00Z",
+		},
+		BoundaryPartners: []*BoundaryPartner{{PartnerName: "Partner", OutcomeStatement: "Outcome", ProgressMarkers: []*ProgressMarker{marker}}},
+		Resources:        []ExportedResource{{ResourceType: RESOURCE_LINK, ResourceUrl: "https://example.org", Title: "Link"}},
+	})
+}
+
+// reportFuncs are the functions available to report templates. text and line
+// prepare text for the output format.
+func reportFuncs(format string) template.FuncMap {
+	funcs := template.FuncMap{
+		"date":  reportDate,
+		"money": formatMoney,
+		"text":  func(s string) string { return s },
+		"line":  func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	}
+	if format == REPORT_PDF {
+		funcs["text"] = pdfText
+	}
+	return funcs
+}
+
+// reportDate formats a time or an RFC 3339 timestamp as a date.
+func reportDate(value interface{}) string {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string:
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return v
+		}
+		t = parsed
+	default:
+		return ""
+	}
+	return t.Format("2 January 2006")
+}
+
+// formatMoney formats an amount with two decimals and thousands separators.
+func formatMoney(amount Amount) string {
+	s := amount.Round(2).String()
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, fraction := s[:len(s)-3], s[len(s)-3:]
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	return sign + whole + fraction
+}
+
+// pdfText escapes the lines of text so that none is read as PDF markup.
+// Blank lines are kept as paragraph breaks.
+func pdfText(s string) string {
+	lines := strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = "\\" + strings.TrimSpace(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reportRenderer executes a report template, html/template escapes the HTML
+// report and text/template leaves the PDF markup alone.
+type reportRenderer interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// parseReportTemplate parses a report template and tries it on the sample
+// report, so a template that parses but fails to execute is caught as well.
+func parseReportTemplate(format, body string) (reportRenderer, error) {
+	var tmpl reportRenderer
+	var err error
+	if format == REPORT_HTML {
+		tmpl, err = htmltemplate.New("report").Funcs(htmltemplate.FuncMap(reportFuncs(format))).Parse(body)
+	} else {
+		tmpl, err = template.New("report").Funcs(reportFuncs(format)).Parse(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = tmpl.Execute(ioutil.Discard, sampleReport()); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// reportTemplate returns the organization's template for a format or the
+// built-in one.
+func (s *Server) reportTemplate(t Tenant, format string) (ReportTemplate, error) {
+	body, err := s.store.GetReportTemplate(t, format)
+	if err == nil {
+		return ReportTemplate{format, body, true}, nil
+	}
+	if err != ErrNotFound {
+		return ReportTemplate{}, err
+	}
+	data, err := reportTemplateFiles.ReadFile(defaultReportTemplates[format])
+	if err != nil {
+		return ReportTemplate{}, err
+	}
+	return ReportTemplate{format, string(data), false}, nil
+}
+
+// reportFormat checks a report format, writing the error response if it is
+// not a known one.
+func reportFormat(w http.ResponseWriter, format string) bool {
+	if errs := validate(oneOf("format", format, REPORT_HTML, REPORT_PDF)); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return false
+	}
+	return true
+}
+
+// getProjectReport renders the intentional design of a project as an HTML
+// page or a PDF document, ?format=html or ?format=pdf (the default).
+func (s *Server) getProjectReport(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+	format := r.FormValue("format")
+	if format == "" {
+		format = REPORT_PDF
+	}
+	if !reportFormat(w, format) {
+		return
+	}
+
+	doc, err := s.store.ExportProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	org, err := s.store.GetOrganization(tenantOf(r))
+	if err != nil && err != ErrNotFound {
+		respondError(w, err)
+		return
+	}
+	rt, err := s.reportTemplate(tenantOf(r), format)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	tmpl, err := parseReportTemplate(format, rt.Body)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, "the report template is broken: " + err.Error()})
+		return
+	}
+
+	data := newReportData(org, doc)
+	images := make(map[string]*pdfImage)
+	// embed returns a logo as a data URL and adds it to the images of the
+	// PDF report under name
+	embed := func(name string, logo *ProjectLogo) htmltemplate.URL {
+		content, contentType := s.reportLogo(logo)
+		if content == nil {
+			return ""
+		}
+		if format == REPORT_PDF {
+			img, err := newPDFImage(content)
+			if err != nil {
+				// SVG logos can only be shown in the HTML report
+				return ""
+			}
+			images[name] = img
+		}
+		return htmltemplate.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(content))
+	}
+	data.Logo = embed("Logo", doc.Project.StoredLogo)
+	data.OrganizationLogo = embed("OrgLogo", org.Logo)
+
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, data); err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, "rendering the report: " + err.Error()})
+		return
+	}
+
+	name := sanitizeFilename(doc.Project.ProjectName)
+	if name == "" {
+		name = "report"
+	}
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "private, no-cache")
+	if format == REPORT_HTML {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name + ".html"}))
+		// custom templates may style the report, but not run scripts or load anything
+		h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:")
+		out.WriteTo(w)
+		return
+	}
+	h.Set("Content-Type", "application/pdf")
+	h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name + ".pdf"}))
+	if unsupported := unsupportedRunes(doc.Project.ProjectName + out.String()); len(unsupported) > 0 {
+		h.Set("Warning", unsupportedWarning(unsupported))
+	}
+	w.Write(renderPDF(out.String(), doc.Project.ProjectName, images, string(data.BrandColor), data.GeneratedAt))
+}
+
+// unsupportedWarning is a Warning header value naming the characters a PDF
+// report could not show, as code points since header values are ASCII.
+func unsupportedWarning(unsupported []rune) string {
+	var codes []string
+	for i, r := range unsupported {
+		if i == MAX_REPORTED_RUNES {
+			codes = append(codes, "and more")
+			break
+		}
+		codes = append(codes, fmt.Sprintf("U+%04X", r))
+	}
+	return `299 lucid "the PDF fonts lack ` + strings.Join(codes, " ") + `, they were replaced by ?; the HTML report shows them"`
+}
+
+// reportLogo reads the medium variant of a logo. A logo that can't be read
+// is left out of the report rather than failing it.
+func (s *Server) reportLogo(logo *ProjectLogo) ([]byte, string) {
+	if logo == nil {
+		return nil, ""
+	}
+	blob, err := s.blobs.Get(logo.MediumKey)
+	if err != nil {
+		warnf("reading logo %s: %v", logo.MediumKey, err)
+		return nil, ""
+	}
+	defer blob.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(blob, MAX_REPORT_LOGO_SIZE))
+	if err != nil {
+		warnf("reading logo %s: %v", logo.MediumKey, err)
+		return nil, ""
+	}
+	return data, logo.ContentType
+}
+
+func (s *Server) getReportTemplate(w http.ResponseWriter, r *http.Request) {
+	format := mux.Vars(r)["format"]
+	if !reportFormat(w, format) {
+		return
+	}
+	rt, err := s.reportTemplate(tenantOf(r), format)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{rt, "success"})
+}
+
+// setReportTemplate replaces the built-in template of a report format for the
+// organization.
+func (s *Server) setReportTemplate(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	format := mux.Vars(r)["format"]
+	if !reportFormat(w, format) {
+		return
+	}
+
+	var input ReportTemplate
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_TEMPLATE_SIZE+4096))
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return
+	}
+	errs := validate(
+		required("body", input.Body),
+		maxLength("body", input.Body, MAX_TEMPLATE_SIZE),
+	)
+	if errs == nil {
+		if _, err := parseReportTemplate(format, input.Body); err != nil {
+			errs = FieldErrors{"body": err.Error()}
+		}
+	}
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+
+	err := s.store.SetReportTemplate(tenantOf(r), user.UserId, format, input.Body)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{ReportTemplate{format, input.Body, true}, "success"})
+}
+
+// resetReportTemplate goes back to the built-in template of a format.
+func (s *Server) resetReportTemplate(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	format := mux.Vars(r)["format"]
+	if !reportFormat(w, format) {
+		return
+	}
+	err := s.store.DeleteReportTemplate(tenantOf(r), format)
+	if err != nil && err != ErrNotFound {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
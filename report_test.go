@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWinAnsi(t *testing.T) {
+	if got := string(winAnsi("Café – Œuvre\t€5 Łódź")); got != "Caf\xe9 \x96 \x8cuvre \x805 ?\xf3d?" {
+		t.Errorf("%q", got)
+	}
+	if got := string(unsupportedRunes("Łódź\nŁuków 北京")); got != "Łź北京" {
+		t.Errorf("%q", got)
+	}
+}
+
+// TestReportUnsupportedCharacters asks for the PDF report of a project whose
+// name the standard fonts can not show, which is answered with a warning.
+func TestReportUnsupportedCharacters(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	report := func(name string) *http.Response {
+		t.Helper()
+		projectId, err := ts.store.AddProject(Tenant{ORG_A}, Project{ProjectName: name})
+		check(t, err)
+		req, err := http.NewRequest("GET", ts.srv.URL+"/projects/"+projectId+"/report?format=pdf", nil)
+		check(t, err)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		check(t, err)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/pdf" {
+			t.Fatal(resp.Status, resp.Header)
+		}
+		return resp
+	}
+	if warning := report("Café Radio").Header.Get("Warning"); warning != "" {
+		t.Error(warning)
+	}
+	want := `299 lucid "the PDF fonts lack U+0141 U+017A, they were replaced by ?; the HTML report shows them"`
+	if warning := report("Radio Łódź").Header.Get("Warning"); warning != want {
+		t.Error(warning)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// scan states of an uploaded file; only clean files are visible
+const (
+	SCAN_PENDING     = "pending"
+	SCAN_CLEAN       = "clean"
+	SCAN_QUARANTINED = "quarantined"
+)
+
+type ScanResult struct {
+	Infected bool
+	// Signature names what the scanner found in an infected file.
+	Signature string
+}
+
+// PendingScan is a stored file version the virus scanner could not check
+// on upload.
+type PendingScan struct {
+	ProjectId     string
+	ResourceId    string
+	VersionNumber int
+	ResourceName  string
+	StorageKey    string
+}
+
+// Scanner checks uploaded files for malware.
+type Scanner interface {
+	Scan(r io.Reader) (ScanResult, error)
+}
+
+// noScanner accepts every file.
+type noScanner struct{}
+
+func (noScanner) Scan(r io.Reader) (ScanResult, error) {
+	return ScanResult{}, nil
+}
+
+// clamAVScanner streams files to clamd with the INSTREAM command.
+type clamAVScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func NewClamAVScanner(address string, timeout time.Duration) *clamAVScanner {
+	if strings.HasPrefix(address, "unix:") {
+		return &clamAVScanner{"unix", strings.TrimPrefix(address, "unix:"), timeout}
+	}
+	return &clamAVScanner{"tcp", strings.TrimPrefix(address, "tcp:"), timeout}
+}
+
+func newScanner(config UploadConfig) Scanner {
+	if config.Scanner == "clamav" {
+		return NewClamAVScanner(config.ClamAVAddress, time.Duration(config.ScanTimeout))
+	}
+	return noScanner{}
+}
+
+func (c *clamAVScanner) Scan(r io.Reader) (ScanResult, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+	// the stream is sent in chunks, each prefixed with its length as a
+	// 4 byte big endian integer and terminated by an empty chunk
+	chunk := make([]byte, 4+32*1024)
+	for {
+		n, readErr := r.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err = conn.Write(chunk[:4+n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := ioutil.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil {
+		return ScanResult{}, err
+	}
+	// "stream: OK" or "stream: <signature> FOUND"
+	answer := strings.TrimPrefix(strings.TrimRight(string(reply), "\x00\n"), "stream: ")
+	switch {
+	case answer == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(answer, " FOUND"):
+		return ScanResult{true, strings.TrimSuffix(answer, " FOUND")}, nil
+	}
+	return ScanResult{}, fmt.Errorf("clamd: %s", answer)
+}
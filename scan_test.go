@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// testScanner flags files containing "EICAR", or fails while down is set.
+type testScanner struct {
+	down bool
+}
+
+func (s *testScanner) Scan(r io.Reader) (ScanResult, error) {
+	if s.down {
+		return ScanResult{}, errors.New("clamd is not running")
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	if strings.Contains(string(content), "EICAR") {
+		return ScanResult{true, "Eicar-Test-Signature"}, nil
+	}
+	return ScanResult{}, nil
+}
+
+// TestRescanPending uploads files while the virus scanner is down and scans
+// them again once it is back.
+func TestRescanPending(t *testing.T) {
+	ts := newTestServer(t)
+	scanner := &testScanner{down: true}
+	ts.scanner = scanner
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+
+	upload := func(name, content string) {
+		t.Helper()
+		if code, out := ts.upload(key, "/projects/"+projectId+"/resource_uploadfile", "resource_file", name, content); code != 202 {
+			t.Fatal(code, out)
+		}
+	}
+	upload("notes.txt", "meeting notes")
+	upload("virus.txt", "EICAR test file")
+	pending, err := ts.store.GetQuarantinedResources(a, projectId)
+	check(t, err)
+	if len(pending) != 2 || pending[0].ScanStatus != SCAN_PENDING {
+		t.Fatalf("%+v", pending)
+	}
+
+	rescan := func(key string) map[string]interface{} {
+		t.Helper()
+		code, out := ts.do(key, "POST", "/resources/rescan", "", "")
+		if code != 200 {
+			t.Fatal(code, out)
+		}
+		return out["data"].(map[string]interface{})
+	}
+	if counts := rescan(key); counts["pending"] != 2.0 || counts["clean"] != 0.0 {
+		t.Fatal(counts)
+	}
+
+	scanner.down = false
+	// organization B has nothing pending
+	if counts := rescan(ts.login("bo@b.org")); counts["pending"] != 0.0 || counts["clean"] != 0.0 {
+		t.Fatal(counts)
+	}
+	if counts := rescan(key); counts["pending"] != 0.0 || counts["clean"] != 1.0 || counts["quarantined"] != 1.0 {
+		t.Fatal(counts)
+	}
+	resources, err := ts.store.GetExternalResources(a, projectId)
+	check(t, err)
+	if len(resources) != 1 || resources[0].ResourceName != "notes.txt" {
+		t.Fatalf("%+v", resources)
+	}
+	quarantined, err := ts.store.GetQuarantinedResources(a, projectId)
+	check(t, err)
+	if len(quarantined) != 1 || quarantined[0].ResourceName != "virus.txt" || quarantined[0].ScanStatus != SCAN_QUARANTINED {
+		t.Fatalf("%+v", quarantined)
+	}
+
+	// a new version uploaded while the scanner was down becomes current
+	// once it is scanned
+	scanner.down = true
+	code, out := ts.upload(key, "/projects/"+projectId+"/resources/"+resources[0].ResourceId+"/versions", "resource_file", "notes.txt", "final notes")
+	if code != 202 {
+		t.Fatal(code, out)
+	}
+	scanner.down = false
+	if counts := rescan(key); counts["clean"] != 1.0 {
+		t.Fatal(counts)
+	}
+	notes, err := ts.store.GetExternalResource(a, projectId, resources[0].ResourceId)
+	check(t, err)
+	if notes.Version != 2 || notes.Size != int64(len("final notes")) {
+		t.Fatalf("%+v", notes)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+)
+
+// kinds of entities searched, in the order results of equal rank are listed
+var searchTypes = []string{SEARCH_PROJECT, SEARCH_PARTNER, SEARCH_MARKER, SEARCH_CHALLENGE, SEARCH_STRATEGY, SEARCH_JOURNAL}
+
+// the store encloses matched words in these, they become <mark> elements
+// once the text is escaped
+const (
+	HIGHLIGHT_START = "\x01"
+	HIGHLIGHT_STOP  = "\x02"
+)
+
+// results on a page of search results, unless limit asks for another number
+// up to MAX_SEARCH_PAGE_SIZE
+const (
+	SEARCH_PAGE_SIZE     = 20
+	MAX_SEARCH_PAGE_SIZE = 100
+)
+
+// how far into the results a search can page
+const MAX_SEARCH_OFFSET = 10000
+
+// maximum length of a search query
+const MAX_QUERY_LENGTH = 200
+
+// SearchPage is a page of search results and the number of results on all
+// pages.
+type SearchPage struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+	Results []SearchResult `json:"results"`
+}
+
+// highlightHTML escapes text from the store and turns its highlights into
+// <mark> elements.
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.Replace(text, HIGHLIGHT_START, "<mark>", -1)
+	return strings.Replace(text, HIGHLIGHT_STOP, "</mark>", -1)
+}
+
+// intParam returns an integer query parameter, or def if it is missing.
+func intParam(r *http.Request, name string, def int) (int, FieldErrors) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, FieldErrors{name: "must be a number"}
+	}
+	return n, nil
+}
+
+// search finds the projects, boundary partners, progress markers, challenges,
+// strategies and outcome journals of the organization whose text matches q,
+// best first. type
+// limits the search to a comma-separated list of kinds, limit and offset
+// page through the results. Grant officers only find the projects their
+// donor funds, by name and description, as that is all their view shows.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	query := SearchQuery{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if value := r.URL.Query().Get("type"); value != "" {
+		query.Types = strings.Split(value, ",")
+	}
+	limit, limitErrs := intParam(r, "limit", SEARCH_PAGE_SIZE)
+	offset, offsetErrs := intParam(r, "offset", 0)
+	rules := []rule{required("q", query.Query), maxLength("q", query.Query, MAX_QUERY_LENGTH)}
+	if limitErrs == nil {
+		rules = append(rules, intRange("limit", limit, 1, MAX_SEARCH_PAGE_SIZE))
+	}
+	if offsetErrs == nil {
+		rules = append(rules, intRange("offset", offset, 0, MAX_SEARCH_OFFSET))
+	}
+	for _, kind := range query.Types {
+		rules = append(rules, oneOf("type", kind, searchTypes...))
+	}
+	errs := nestErrors(nestErrors(validate(rules...), "", limitErrs), "", offsetErrs)
+	if errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	query.Limit, query.Offset = limit, offset
+
+	page := SearchPage{Query: query.Query, Limit: query.Limit, Offset: query.Offset, Results: []SearchResult{}}
+	if user.DonorId != "" {
+		if len(query.Types) > 0 && validate(oneOf("type", SEARCH_PROJECT, query.Types...)) != nil {
+			JSON(w, http.StatusOK, Response{page, "success"})
+			return
+		}
+		funded, err := s.store.GetDonorProjects(tenantOf(r), user.DonorId)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		query.Types = []string{SEARCH_PROJECT}
+		query.ProjectIds = []string{}
+		for _, p := range funded {
+			query.ProjectIds = append(query.ProjectIds, p.Project.ProjectId)
+		}
+	}
+
+	results, total, err := s.store.Search(tenantOf(r), query)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	for i := range results {
+		results[i].Title = highlightHTML(results[i].Title)
+		results[i].Snippet = highlightHTML(results[i].Snippet)
+	}
+	page.Total, page.Results = total, results
+	JSON(w, http.StatusOK, Response{page, "success"})
+}
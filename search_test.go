@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain", "plain"},
+		{"the " + HIGHLIGHT_START + "radio" + HIGHLIGHT_STOP + " show", "the <mark>radio</mark> show"},
+		{`<script>alert("x")</script>`, `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;`},
+		{"<b>" + HIGHLIGHT_START + "water & soil" + HIGHLIGHT_STOP + "</b>", "&lt;b&gt;<mark>water &amp; soil</mark>&lt;/b&gt;"},
+		// marks in the text are not the store's highlights
+		{"<mark>radio</mark>", "&lt;mark&gt;radio&lt;/mark&gt;"},
+	}
+	for _, test := range tests {
+		if got := highlightHTML(test.text); got != test.want {
+			t.Errorf("%q: %q", test.text, got)
+		}
+	}
+}
+
+// TestSearch searches the projects of organization A and their parts as an
+// admin and as a grant officer, who only finds the projects their donor
+// funds.
+func TestSearch(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.login("ada@a.org")
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectId, markerId, challengeId, strategyId := addTestMarker(t, ts.store)
+	project, err := ts.store.GetProject(a, projectId)
+	check(t, err)
+	check(t, ts.store.SetProjectField(a, projectId, "project_name", "Farmers' radio"))
+	check(t, ts.store.SetProjectField(a, projectId, "description", "Weekly <b>farmers</b> show"))
+	partnerId := project.BoundaryPartnerIds[0]
+	journalId, err := ts.store.AddJournal(a, ADMIN_A, OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId,
+		MonitoringDate: "2017-06-30", DescriptionOfChange: "The farmers meet every month.",
+		Lessons: "Transport to the meetings matters more than the agenda."})
+	check(t, err)
+	otherProject, err := ts.store.AddProject(a, Project{ProjectName: "Farmers' markets"})
+	check(t, err)
+	projectB, err := ts.store.AddProject(b, Project{ProjectName: "Farmers B"})
+	check(t, err)
+	_, err = ts.store.AddBoundaryPartner(b, projectB, BoundaryPartner{PartnerName: "Farmers of B"})
+	check(t, err)
+
+	search := func(key, query string) (int, map[string]interface{}) {
+		t.Helper()
+		code, out := ts.do(key, "GET", "/search?"+query, "", "")
+		data, _ := out["data"].(map[string]interface{})
+		return code, data
+	}
+	found := func(page map[string]interface{}) map[string]map[string]interface{} {
+		t.Helper()
+		results := make(map[string]map[string]interface{})
+		for _, r := range page["results"].([]interface{}) {
+			result := r.(map[string]interface{})
+			results[result["id"].(string)] = result
+		}
+		return results
+	}
+
+	for _, test := range []struct {
+		name, query, field string
+	}{
+		{"no query", "q=+", "q"},
+		{"long query", "q=" + strings.Repeat("x", MAX_QUERY_LENGTH+1), "q"},
+		{"no limit", "q=x&limit=0", "limit"},
+		{"large limit", "q=x&limit=101", "limit"},
+		{"limit not a number", "q=x&limit=ten", "limit"},
+		{"negative offset", "q=x&offset=-1", "offset"},
+		{"unknown type", "q=x&type=project,journal", "type"},
+	} {
+		if code, errs := search(admin, test.query); code != http.StatusBadRequest || errs[test.field] == nil {
+			t.Errorf("%s: %d %v", test.name, code, errs)
+		}
+	}
+
+	// the parts of both projects of organization A, none of B
+	code, page := search(admin, "q=farmers")
+	results := found(page)
+	if code != http.StatusOK || page["total"] != 4.0 || len(results) != 4 || results[projectId] == nil ||
+		results[otherProject] == nil || results[partnerId] == nil || results[journalId] == nil {
+		t.Fatal(code, page)
+	}
+	if r := results[projectId]; r["type"] != SEARCH_PROJECT || r["title"] != "<mark>Farmers</mark>&#39; radio" ||
+		r["snippet"] != "Weekly &lt;b&gt;<mark>farmers</mark>&lt;/b&gt; show" {
+		t.Fatal(r)
+	}
+	if r := results[partnerId]; r["type"] != SEARCH_PARTNER || r["project_id"] != projectId || r["boundary_partner_id"] != partnerId {
+		t.Fatal(r)
+	}
+
+	// journals are found by their whole narrative
+	code, page = search(admin, "q=transport&type=outcome_journal")
+	results = found(page)
+	if r := results[journalId]; code != http.StatusOK || len(results) != 1 || r["type"] != SEARCH_JOURNAL ||
+		r["boundary_partner_id"] != partnerId || !strings.Contains(r["snippet"].(string), "<mark>Transport</mark>") {
+		t.Fatal(code, page)
+	}
+	code, page = search(admin, "q=transport")
+	results = found(page)
+	if code != http.StatusOK || len(results) != 2 || results[strategyId]["progress_marker_id"] != markerId || results[journalId] == nil {
+		t.Fatal(code, page)
+	}
+	if code, page = search(admin, "q=distance&type=challenge"); code != http.StatusOK || found(page)[challengeId] == nil {
+		t.Fatal(code, page)
+	}
+
+	// excluded words, kinds and pages
+	if code, page = search(admin, "q="+url.QueryEscape("farmers -radio -month")); code != http.StatusOK || page["total"] != 2.0 {
+		t.Fatal(code, page)
+	}
+	if code, page = search(admin, "q=farmers&type=project,boundary_partner"); code != http.StatusOK || page["total"] != 3.0 {
+		t.Fatal(code, page)
+	}
+	code, page = search(admin, "q=farmers&limit=3&offset=3")
+	if code != http.StatusOK || page["total"] != 4.0 || len(page["results"].([]interface{})) != 1 {
+		t.Fatal(code, page)
+	}
+
+	// a grant officer finds the funded project by its name and description
+	donorId, err := ts.store.AddDonor(a, Donor{DonorName: "Fund"})
+	check(t, err)
+	_, err = ts.store.AddGrant(a, ADMIN_A, Grant{ProjectId: projectId, DonorId: donorId, Currency: "EUR", Deadlines: []GrantDeadline{}})
+	check(t, err)
+	check(t, ts.store.SetGrantOfficer(a, MEMBER_A, donorId))
+	officer := ts.login("ann@a.org")
+	code, page = search(officer, "q=farmers")
+	if results = found(page); code != http.StatusOK || page["total"] != 1.0 || results[projectId] == nil {
+		t.Fatal(code, page)
+	}
+	if code, page = search(officer, "q=transport&type=outcome_journal"); code != http.StatusOK || page["total"] != 0.0 {
+		t.Fatal(code, page)
+	}
+}
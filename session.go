@@ -1,25 +1,67 @@
 package main
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
+
+type session struct {
+	userId   string
+	created  time.Time
+	lastSeen time.Time
+}
 
 type SessionStorage struct {
 	*sync.RWMutex
-	data map[string]string
+	data    map[string]*session
+	ttl     time.Duration
+	idleTTL time.Duration
 }
 
-func NewSessionStorage() *SessionStorage {
-	return &SessionStorage{&sync.RWMutex{}, make(map[string]string)}
+// NewSessionStorage keeps API keys for at most ttl after login and expires
+// keys that have not been used for idleTTL.
+func NewSessionStorage(ttl, idleTTL time.Duration) *SessionStorage {
+	return &SessionStorage{&sync.RWMutex{}, make(map[string]*session), ttl, idleTTL}
+}
+
+func (s *SessionStorage) expired(sess *session, now time.Time) bool {
+	return now.Sub(sess.created) > s.ttl || now.Sub(sess.lastSeen) > s.idleTTL
 }
 
 func (s *SessionStorage) Get(apiKey string) (string, bool) {
-	s.RLock()
-	defer s.RUnlock()
-	id, ok := s.data[apiKey]
-	return id, ok
+	s.Lock()
+	defer s.Unlock()
+	sess, ok := s.data[apiKey]
+	if !ok {
+		return "", false
+	}
+	now := time.Now()
+	if s.expired(sess, now) {
+		delete(s.data, apiKey)
+		return "", false
+	}
+	sess.lastSeen = now
+	return sess.userId, true
 }
 
 func (s *SessionStorage) Set(apiKey, userId string) {
 	s.Lock()
 	defer s.Unlock()
-	s.data[apiKey] = userId
+	now := time.Now()
+	s.data[apiKey] = &session{userId, now, now}
+}
+
+// Purge removes expired sessions and returns how many were removed.
+func (s *SessionStorage) Purge() int {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	purged := 0
+	for apiKey, sess := range s.data {
+		if s.expired(sess, now) {
+			delete(s.data, apiKey)
+			purged++
+		}
+	}
+	return purged
 }
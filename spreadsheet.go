@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// spreadsheet formats
+const (
+	SPREADSHEET_CSV  = "csv"
+	SPREADSHEET_XLSX = "xlsx"
+)
+
+// sheets of a spreadsheet export, named as in ?sheet= and in row errors
+const (
+	SHEET_MARKERS = "progress_markers"
+	SHEET_ACTIONS = "strategies_challenges"
+)
+
+// sheetTitles are the names of the sheets in an XLSX workbook.
+var sheetTitles = map[string]string{
+	SHEET_MARKERS: "Progress markers",
+	SHEET_ACTIONS: "Strategies and challenges",
+}
+
+// columns of the sheets, an import finds them by name in the header row. The
+// monitoring date and rating of the partner's latest submitted journal are
+// only exported, an import ignores them.
+var (
+	markerColumns = []string{"partner_id", "partner_name", "progress_marker_id", "order_number", "level", "title", "monitoring_date", "rating"}
+	actionColumns = []string{"partner_name", "progress_marker_id", "progress_marker_title", "kind", "id", "name"}
+)
+
+// levelNames are the values of the level column, an import also accepts the
+// numbers and the names used in reports.
+var levelNames = map[int]string{
+	MARKER_EXPECT: "expect",
+	MARKER_LIKE:   "like",
+	MARKER_LOVE:   "love",
+}
+
+// utf8BOM starts CSV exports, without it Excel reads them in the local code page.
+const utf8BOM = "\ufeff"
+
+// latestJournals returns the latest submitted journal of every boundary
+// partner of a project export.
+func latestJournals(doc ProjectExport) map[string]*ExportedJournal {
+	latest := make(map[string]*ExportedJournal)
+	for _, j := range doc.Journals {
+		if j.Status != JOURNAL_SUBMITTED {
+			continue
+		}
+		if current := latest[j.BoundaryPartnerId]; current == nil || j.MonitoringDate > current.MonitoringDate {
+			latest[j.BoundaryPartnerId] = j
+		}
+	}
+	return latest
+}
+
+// spreadsheetOf returns the sheets of a project export.
+func spreadsheetOf(doc ProjectExport) map[string]sheet {
+	markers := sheet{Name: sheetTitles[SHEET_MARKERS], Rows: [][]string{markerColumns}}
+	actions := sheet{Name: sheetTitles[SHEET_ACTIONS], Rows: [][]string{actionColumns}}
+	latest := latestJournals(doc)
+	for _, bp := range doc.BoundaryPartners {
+		for _, pm := range bp.ProgressMarkers {
+			var date, rating string
+			if j := latest[bp.BoundaryPartnerId]; j != nil {
+				date = j.MonitoringDate
+				for _, r := range j.Ratings {
+					if r.ProgressMarkerId == pm.ProgressMarkerId {
+						rating = r.Rating
+					}
+				}
+			}
+			markers.Rows = append(markers.Rows, []string{
+				bp.BoundaryPartnerId, bp.PartnerName, pm.ProgressMarkerId,
+				strconv.Itoa(pm.OrderNumber), levelNames[pm.Type], pm.Title, date, rating,
+			})
+			for _, strat := range pm.Strategies {
+				actions.Rows = append(actions.Rows, []string{
+					bp.PartnerName, pm.ProgressMarkerId, pm.Title, KIND_STRATEGY, strat.StrategyId, strat.StrategyName,
+				})
+			}
+			for _, c := range pm.Challenges {
+				actions.Rows = append(actions.Rows, []string{
+					bp.PartnerName, pm.ProgressMarkerId, pm.Title, KIND_CHALLENGE, c.ChallengeId, c.ChallengeName,
+				})
+			}
+		}
+	}
+	return map[string]sheet{SHEET_MARKERS: markers, SHEET_ACTIONS: actions}
+}
+
+// exportSpreadsheet downloads the progress markers, strategies and challenges
+// of a project as an XLSX workbook, or with ?format=csv one of its sheets as
+// CSV. The sheets double as the template for importSpreadsheet.
+func (s *Server) exportSpreadsheet(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = SPREADSHEET_XLSX
+	}
+	sheetName := r.FormValue("sheet")
+	if sheetName == "" {
+		sheetName = SHEET_MARKERS
+	}
+	if errs := validate(
+		oneOf("format", format, SPREADSHEET_XLSX, SPREADSHEET_CSV),
+		oneOf("sheet", sheetName, SHEET_MARKERS, SHEET_ACTIONS),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+
+	doc, err := s.store.ExportProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	sheets := spreadsheetOf(doc)
+
+	name := sanitizeFilename(doc.Project.ProjectName)
+	if name == "" {
+		name = "project"
+	}
+	if format == SPREADSHEET_CSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "-" + sheetName + ".csv"}))
+		io.WriteString(w, utf8BOM)
+		writer := csv.NewWriter(w)
+		for _, row := range sheets[sheetName].Rows {
+			writer.Write(csvEscape(row))
+		}
+		writer.Flush()
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = writeXLSX(&buf, []sheet{sheets[SHEET_MARKERS], sheets[SHEET_ACTIONS]}); err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", XLSX_CONTENT_TYPE)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".xlsx"}))
+	w.Write(buf.Bytes())
+}
+
+// csvEscape keeps spreadsheet applications from running cells as formulas by
+// quoting the ones that start like one.
+func csvEscape(row []string) []string {
+	escaped := make([]string, len(row))
+	for i, value := range row {
+		if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+			value = "'" + value
+		}
+		escaped[i] = value
+	}
+	return escaped
+}
+
+// csvUnescape removes the quote csvEscape put in front of the values of an
+// export. Only values the export of the project escaped are unescaped, a
+// quote someone typed in front of "=", "+", "-" or "@" is kept.
+func csvUnescape(exported map[string]sheet, sheets []sheet) {
+	escaped := make(map[string]bool)
+	for _, sh := range exported {
+		for _, row := range sh.Rows {
+			for i, value := range csvEscape(row) {
+				if value != row[i] {
+					escaped[value] = true
+				}
+			}
+		}
+	}
+	for _, sh := range sheets {
+		for _, row := range sh.Rows {
+			for i, value := range row {
+				if escaped[strings.TrimSpace(value)] {
+					row[i] = strings.TrimSpace(value)[1:]
+				}
+			}
+		}
+	}
+}
+
+// readSpreadsheet reads an uploaded CSV or XLSX file. The format is taken
+// from the file name, or from the content if the name has no known extension.
+func readSpreadsheet(file multipart.File, header *multipart.FileHeader) ([]sheet, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+	if format != SPREADSHEET_CSV && format != SPREADSHEET_XLSX {
+		magic := make([]byte, 4)
+		n, _ := io.ReadFull(file, magic)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		format = SPREADSHEET_CSV
+		if bytes.Equal(magic[:n], []byte("PK\x03\x04")) {
+			format = SPREADSHEET_XLSX
+		}
+	}
+	if format == SPREADSHEET_XLSX {
+		return readXLSX(file, header.Size)
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	sh := sheet{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("is not a valid CSV file: %v", err)
+		}
+		if len(sh.Rows) == MAX_SHEET_ROWS {
+			return nil, errTooManyRows
+		}
+		if len(row) > MAX_SHEET_COLUMNS {
+			row = row[:MAX_SHEET_COLUMNS]
+		}
+		if len(sh.Rows) == 0 && len(row) > 0 {
+			row[0] = strings.TrimPrefix(row[0], utf8BOM)
+		}
+		sh.Rows = append(sh.Rows, row)
+	}
+	return []sheet{sh}, nil
+}
+
+// importSpreadsheet creates and updates progress markers, strategies and
+// challenges from a spreadsheet in the format of exportSpreadsheet, sent as
+// the "spreadsheet" field of a multipart form. Rows with an ID update that
+// item, rows without one create it. With ?dry_run=true the changes are only
+// listed. Nothing is changed if any row has an error.
+func (s *Server) importSpreadsheet(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			JSON(w, http.StatusBadRequest, Response{FieldErrors{"dry_run": "must be true or false"}, "validation failed"})
+			return
+		}
+	}
+
+	file, header, ok := s.receiveFile(w, r, "spreadsheet")
+	if !ok {
+		return
+	}
+	defer file.Close()
+	sheets, err := readSpreadsheet(file, header)
+	if err != nil {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"spreadsheet": err.Error()}, "validation failed"})
+		return
+	}
+
+	doc, err := s.store.ExportProject(tenantOf(r), projectId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	csvUnescape(spreadsheetOf(doc), sheets)
+	result := planSpreadsheetImport(doc, sheets)
+	result.DryRun = dryRun
+	if len(result.Errors) > 0 {
+		JSON(w, http.StatusBadRequest, Response{result, "the spreadsheet has errors, nothing was changed"})
+		return
+	}
+	if dryRun {
+		JSON(w, http.StatusOK, Response{result, "dry run, nothing was changed"})
+		return
+	}
+
+	err = s.store.ApplyMarkerChanges(tenantOf(r), projectId, result.Changes)
+	if err == ErrNotFound {
+		// something the spreadsheet refers to was deleted since it was checked
+		JSON(w, http.StatusConflict, Response{nil, "the project changed during the import, nothing was changed"})
+		return
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{result, "spreadsheet imported"})
+}
+
+// sheetRows reads the data rows of a sheet by the column names in its header
+// row. Names are matched case-insensitively, unknown columns are ignored.
+type sheetRows struct {
+	name    string
+	columns map[string]int
+	rows    [][]string
+}
+
+func newSheetRows(name string, sh sheet) sheetRows {
+	rows := sheetRows{name: name, columns: make(map[string]int)}
+	if len(sh.Rows) == 0 {
+		return rows
+	}
+	for i, column := range sh.Rows[0] {
+		column = strings.Replace(strings.ToLower(strings.TrimSpace(column)), " ", "_", -1)
+		if _, exists := rows.columns[column]; !exists {
+			rows.columns[column] = i
+		}
+	}
+	rows.rows = sh.Rows[1:]
+	return rows
+}
+
+func (rows sheetRows) has(column string) bool {
+	_, ok := rows.columns[column]
+	return ok
+}
+
+// cell returns the trimmed value of a column in a data row.
+func (rows sheetRows) cell(row []string, column string) string {
+	i, ok := rows.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func blankRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// findSheets picks the sheets of an import by their name, or for a CSV file
+// without names by their columns.
+func findSheets(sheets []sheet) (markers, actions *sheetRows) {
+	for _, sh := range sheets {
+		name := strings.ToLower(strings.TrimSpace(sh.Name))
+		rows := newSheetRows("", sh)
+		switch {
+		case markers == nil && (name == SHEET_MARKERS || name == strings.ToLower(sheetTitles[SHEET_MARKERS]) ||
+			name == "" && rows.has("level")):
+			rows.name = SHEET_MARKERS
+			markers = &rows
+		case actions == nil && (name == SHEET_ACTIONS || name == strings.ToLower(sheetTitles[SHEET_ACTIONS]) ||
+			name == "" && rows.has("kind")):
+			rows.name = SHEET_ACTIONS
+			actions = &rows
+		}
+	}
+	return markers, actions
+}
+
+// parseLevel reads the level of a progress marker from its name, its number
+// or its name in reports.
+func parseLevel(value string) (int, bool) {
+	value = strings.ToLower(value)
+	for level, name := range levelNames {
+		if value == name || value == strconv.Itoa(level) || value == strings.ToLower(markerLevels[level]) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// markerRef is a progress marker an imported row can refer to, either an
+// existing one or one created by the import.
+type markerRef struct {
+	id        string
+	partnerId string
+	title     string
+	// index of the creating change, -1 for existing markers
+	change int
+}
+
+// planSpreadsheetImport works out the changes an import makes to a project,
+// or the errors that keep it from being imported.
+func planSpreadsheetImport(doc ProjectExport, sheets []sheet) SpreadsheetImport {
+	result := SpreadsheetImport{Changes: []MarkerChange{}, Errors: []RowError{}}
+	fail := func(sheetName string, row int, column, message string) {
+		result.Errors = append(result.Errors, RowError{sheetName, row, column, message})
+	}
+
+	partners := make(map[string]*BoundaryPartner)
+	partnersByName := make(map[string][]string)
+	markers := make(map[string]*markerRef)
+	challenges := make(map[string]*Challenge)
+	strategies := make(map[string]*Strategy)
+	for _, bp := range doc.BoundaryPartners {
+		partners[bp.BoundaryPartnerId] = bp
+		name := strings.ToLower(bp.PartnerName)
+		partnersByName[name] = append(partnersByName[name], bp.BoundaryPartnerId)
+		for _, pm := range bp.ProgressMarkers {
+			markers[pm.ProgressMarkerId] = &markerRef{pm.ProgressMarkerId, bp.BoundaryPartnerId, pm.Title, -1}
+			for _, c := range pm.Challenges {
+				challenges[c.ChallengeId] = c
+			}
+			for _, strat := range pm.Strategies {
+				strategies[strat.StrategyId] = strat
+			}
+		}
+	}
+	// lookupPartner finds a partner by ID or else by name and reports the
+	// column that failed.
+	lookupPartner := func(id, name string) (string, string, string) {
+		if id != "" {
+			if _, ok := partners[id]; !ok {
+				return "", "partner_id", "is not a boundary partner of the project"
+			}
+			return id, "", ""
+		}
+		switch ids := partnersByName[strings.ToLower(name)]; {
+		case name == "":
+			return "", "partner_name", "must not be empty"
+		case len(ids) == 0:
+			return "", "partner_name", "is not the name of a boundary partner of the project"
+		case len(ids) > 1:
+			return "", "partner_name", "is the name of more than one boundary partner, give the partner_id"
+		default:
+			return ids[0], "", ""
+		}
+	}
+
+	markerSheet, actionSheet := findSheets(sheets)
+	if markerSheet == nil && actionSheet == nil {
+		fail("", 1, "", "no sheet of progress markers or of strategies and challenges found")
+		return result
+	}
+
+	seen := make(map[string]int)
+	duplicate := func(sheetName string, row int, column, id string) bool {
+		if first, ok := seen[id]; ok {
+			fail(sheetName, row, column, fmt.Sprintf("is already used in row %d", first))
+			return true
+		}
+		seen[id] = row
+		return false
+	}
+
+	var created []*markerRef
+	if markerSheet != nil {
+		rows := markerSheet
+		if !rows.has("title") || !rows.has("level") || !rows.has("partner_id") && !rows.has("partner_name") {
+			fail(rows.name, 1, "", "the header must name the title, level and partner_id or partner_name columns")
+		}
+		for i, row := range rows.rows {
+			number := i + 2
+			if blankRow(row) {
+				continue
+			}
+			errorCount := len(result.Errors)
+			pm := ProgressMarker{Title: rows.cell(row, "title")}
+			level, ok := parseLevel(rows.cell(row, "level"))
+			if !ok {
+				fail(rows.name, number, "level", "must be one of expect, like, love")
+			}
+			pm.Type = level
+			for field, message := range pm.Validate() {
+				if field == "title" {
+					fail(rows.name, number, "title", message)
+				}
+			}
+			partnerId, column, message := lookupPartner(rows.cell(row, "partner_id"), rows.cell(row, "partner_name"))
+			markerId := rows.cell(row, "progress_marker_id")
+			var existing *markerRef
+			if markerId != "" {
+				partnerColumn := "partner_name"
+				if rows.cell(row, "partner_id") != "" {
+					partnerColumn = "partner_id"
+				}
+				// the partner is only checked if it is found, it may have been renamed
+				existing = markers[markerId]
+				switch {
+				case existing == nil:
+					fail(rows.name, number, "progress_marker_id", "is not a progress marker of the project")
+				case duplicate(rows.name, number, "progress_marker_id", markerId):
+				case column == "" && partnerId != existing.partnerId:
+					fail(rows.name, number, partnerColumn, "is not the partner of the progress marker, markers can not be moved")
+				}
+			} else if column != "" {
+				fail(rows.name, number, column, message)
+			}
+			if len(result.Errors) > errorCount {
+				continue
+			}
+
+			change := MarkerChange{Sheet: rows.name, Row: number, Kind: KIND_MARKER, Name: pm.Title, Type: pm.Type, NewMarker: -1}
+			if existing == nil {
+				change.Action = CHANGE_CREATE
+				change.BoundaryPartnerId = partnerId
+				created = append(created, &markerRef{"", partnerId, pm.Title, len(result.Changes)})
+			} else {
+				change.Id = existing.id
+				change.BoundaryPartnerId = existing.partnerId
+				change.Action = CHANGE_UNCHANGED
+				old := partners[existing.partnerId]
+				for _, m := range old.ProgressMarkers {
+					if m.ProgressMarkerId == existing.id && (m.Title != pm.Title || m.Type != pm.Type) {
+						change.Action = CHANGE_UPDATE
+					}
+				}
+				// later rows find the marker by its new title
+				existing.title = pm.Title
+			}
+			result.Changes = append(result.Changes, change)
+		}
+	}
+
+	if actionSheet != nil {
+		rows := actionSheet
+		if !rows.has("kind") || !rows.has("name") {
+			fail(rows.name, 1, "", "the header must name the kind and name columns")
+		}
+		// lookupMarker finds a marker of a partner by title among the existing
+		// and the created ones.
+		lookupMarker := func(partnerName, title string) (*markerRef, string, string) {
+			if partnerName == "" {
+				return nil, "partner_name", "must not be empty without a progress_marker_id"
+			}
+			if title == "" {
+				return nil, "progress_marker_title", "must not be empty without a progress_marker_id"
+			}
+			var found []*markerRef
+			candidates := make([]*markerRef, 0, len(markers)+len(created))
+			for _, ref := range markers {
+				candidates = append(candidates, ref)
+			}
+			for _, ref := range append(candidates, created...) {
+				if strings.EqualFold(ref.title, title) && strings.EqualFold(partners[ref.partnerId].PartnerName, partnerName) {
+					found = append(found, ref)
+				}
+			}
+			switch {
+			case len(found) == 0:
+				return nil, "progress_marker_title", "is not the title of a progress marker of the partner"
+			case len(found) > 1:
+				return nil, "progress_marker_title", "is the title of more than one progress marker of the partner, give the progress_marker_id"
+			}
+			return found[0], "", ""
+		}
+
+		for i, row := range rows.rows {
+			number := i + 2
+			if blankRow(row) {
+				continue
+			}
+			errorCount := len(result.Errors)
+			kind := strings.ToLower(rows.cell(row, "kind"))
+			name := rows.cell(row, "name")
+			if errs := validate(oneOf("kind", kind, KIND_STRATEGY, KIND_CHALLENGE)); errs != nil {
+				fail(rows.name, number, "kind", errs["kind"])
+			}
+			var errs FieldErrors
+			if kind == KIND_CHALLENGE {
+				errs = (&Challenge{ChallengeName: name}).Validate()
+			} else {
+				errs = (&Strategy{StrategyName: name}).Validate()
+			}
+			for _, message := range errs {
+				fail(rows.name, number, "name", message)
+			}
+
+			// the marker is required for new items, for existing ones it is
+			// only checked if given
+			var marker *markerRef
+			markerId := rows.cell(row, "progress_marker_id")
+			partnerName, title := rows.cell(row, "partner_name"), rows.cell(row, "progress_marker_title")
+			id := rows.cell(row, "id")
+			if markerId != "" {
+				if marker = markers[markerId]; marker == nil {
+					fail(rows.name, number, "progress_marker_id", "is not a progress marker of the project")
+				}
+			} else if id == "" || partnerName != "" || title != "" {
+				var column, message string
+				if marker, column, message = lookupMarker(partnerName, title); marker == nil {
+					fail(rows.name, number, column, message)
+				}
+			}
+
+			change := MarkerChange{Sheet: rows.name, Row: number, Kind: kind, Id: id, Name: name, NewMarker: -1}
+			if id == "" {
+				change.Action = CHANGE_CREATE
+			} else {
+				oldMarkerId, oldName := "", ""
+				if c, ok := challenges[id]; ok && kind == KIND_CHALLENGE {
+					oldMarkerId, oldName = c.ProgressMarkerId, c.ChallengeName
+				} else if strat, ok := strategies[id]; ok && kind == KIND_STRATEGY {
+					oldMarkerId, oldName = strat.ProgressMarkerId, strat.StrategyName
+				}
+				switch {
+				case kind != KIND_CHALLENGE && kind != KIND_STRATEGY:
+					// reported with the kind
+				case oldMarkerId == "":
+					fail(rows.name, number, "id", "is not a "+kind+" of the project")
+				case duplicate(rows.name, number, "id", id):
+				case marker != nil && marker.id != oldMarkerId:
+					fail(rows.name, number, "progress_marker_id", "is not the progress marker of the "+kind+", "+kind+"s can not be moved")
+				default:
+					marker = markers[oldMarkerId]
+				}
+				change.Action = CHANGE_UPDATE
+				if name == oldName {
+					change.Action = CHANGE_UNCHANGED
+				}
+			}
+			if len(result.Errors) > errorCount {
+				continue
+			}
+			change.BoundaryPartnerId = marker.partnerId
+			change.ProgressMarkerId = marker.id
+			change.NewMarker = marker.change
+			result.Changes = append(result.Changes, change)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		result.Changes = []MarkerChange{}
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestImportEscapedCSV imports the CSV export of progress markers whose
+// titles start like formulas, which leaves them unchanged, and a title
+// someone quoted themselves, which keeps its quote.
+func TestImportEscapedCSV(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	partnerId, err := ts.store.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "-Listeners"})
+	check(t, err)
+	_, err = ts.store.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: "=SUM(A1:A2)", Type: MARKER_EXPECT})
+	check(t, err)
+	_, err = ts.store.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: "@home", Type: MARKER_LIKE})
+	check(t, err)
+
+	req, err := http.NewRequest("GET", ts.srv.URL+"/projects/"+projectId+"/spreadsheet?format=csv", nil)
+	check(t, err)
+	req.Header.Set("X-Api-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	check(t, err)
+	export, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	check(t, err)
+	if !strings.Contains(string(export), "'=SUM(A1:A2)") || !strings.Contains(string(export), "'-Listeners") {
+		t.Fatal(string(export))
+	}
+
+	changes := func(csv string) []interface{} {
+		t.Helper()
+		code, out := ts.upload(key, "/projects/"+projectId+"/spreadsheet", "spreadsheet", "markers.csv", csv)
+		if code != 200 {
+			t.Fatal(code, out)
+		}
+		return out["data"].(map[string]interface{})["changes"].([]interface{})
+	}
+	for _, change := range changes(string(export)) {
+		if action := change.(map[string]interface{})["action"]; action != CHANGE_UNCHANGED {
+			t.Errorf("%v", change)
+		}
+	}
+
+	created := changes("partner_name,level,title\n'-Listeners,love,'=typed\n")
+	if len(created) != 1 || created[0].(map[string]interface{})["name"] != "'=typed" {
+		t.Fatal(created)
+	}
+	doc, err := ts.store.ExportProject(a, projectId)
+	check(t, err)
+	var titles []string
+	for _, pm := range doc.BoundaryPartners[0].ProgressMarkers {
+		titles = append(titles, pm.Title)
+	}
+	if !equalStrings(titles, []string{"=SUM(A1:A2)", "@home", "'=typed"}) {
+		t.Fatal(titles)
+	}
+}
+
+// TestSpreadsheetRatings exports the ratings of the latest submitted journal
+// of every partner next to its progress markers.
+func TestSpreadsheetRatings(t *testing.T) {
+	doc := ProjectExport{
+		BoundaryPartners: []*BoundaryPartner{
+			{BoundaryPartnerId: "bp1", PartnerName: "Councils", ProgressMarkers: []*ProgressMarker{
+				{ProgressMarkerId: "pm1", Title: "listen", Type: MARKER_EXPECT},
+				{ProgressMarkerId: "pm2", Title: "fund", Type: MARKER_LIKE},
+			}},
+			{BoundaryPartnerId: "bp2", PartnerName: "Farmers", ProgressMarkers: []*ProgressMarker{
+				{ProgressMarkerId: "pm3", Title: "plant", Type: MARKER_EXPECT},
+			}},
+		},
+		Journals: []*ExportedJournal{
+			{BoundaryPartnerId: "bp1", MonitoringDate: "2017-09-30", Status: JOURNAL_DRAFT,
+				Ratings: []JournalRating{{"pm1", RATING_LOW}, {"pm2", RATING_LOW}}},
+			{BoundaryPartnerId: "bp1", MonitoringDate: "2017-03-31", Status: JOURNAL_SUBMITTED,
+				Ratings: []JournalRating{{"pm1", RATING_LOW}, {"pm2", RATING_MEDIUM}}},
+			{BoundaryPartnerId: "bp1", MonitoringDate: "2017-06-30", Status: JOURNAL_SUBMITTED,
+				Ratings: []JournalRating{{"pm1", RATING_HIGH}}},
+			{BoundaryPartnerId: "bp2", MonitoringDate: "2017-06-30", Status: JOURNAL_DRAFT,
+				Ratings: []JournalRating{{"pm3", RATING_HIGH}}},
+		},
+	}
+	rows := spreadsheetOf(doc)[SHEET_MARKERS].Rows
+	if len(rows) != 4 {
+		t.Fatal(rows)
+	}
+	for i, want := range [][2]string{{"2017-06-30", RATING_HIGH}, {"2017-06-30", ""}, {"", ""}} {
+		row := rows[i+1]
+		if got := [2]string{row[6], row[7]}; got != want {
+			t.Errorf("%s: got %v, want %v", row[5], got, want)
+		}
+	}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Store is the data access layer used by the HTTP handlers. Every method that
+// touches project data takes the Tenant it acts for, so an implementation can
+// not be asked for rows without an organization to scope them to.
+type Store interface {
+	ProjectStore
+	PartnerStore
+	MarkerStore
+	ResourceStore
+	JournalStore
+	TransferStore
+	BudgetStore
+	DonorStore
+	ActivityStore
+	TaskStore
+	CommentStore
+	NotificationStore
+	WebhookStore
+	SearchStore
+	JobStore
+	StatsStore
+	OrganizationStore
+	UserStore
+}
+
+type ProjectStore interface {
+	GetProjects(t Tenant) ([]Project, error)
+	GetProject(t Tenant, projectId string) (Project, error)
+	AddProject(t Tenant, p Project) (string, error)
+	// SetProjectField sets a single column of a project, a nil value resets it.
+	SetProjectField(t Tenant, projectId, column string, value interface{}) error
+	// SetProjectTimeline sets both ends of the timeline, nil values reset them.
+	SetProjectTimeline(t Tenant, projectId string, from, to interface{}) error
+	// SetProjectCurrency changes the currency of a project. It fails with
+	// FieldErrors once the project has budget lines or expenditures, whose
+	// converted amounts would no longer match.
+	SetProjectCurrency(t Tenant, projectId, currency string) error
+	// SetProjectLogo points the logo at stored blobs, a nil logo resets it.
+	// The previous logo, if any, is returned so the caller can delete its blobs.
+	SetProjectLogo(t Tenant, projectId string, logo *ProjectLogo) (*ProjectLogo, error)
+	DeleteProject(t Tenant, projectId string) error
+	// CheckOwnership returns the field of the first route ID in vars that does
+	// not belong to the tenant's project, or an empty string if all of them do.
+	CheckOwnership(t Tenant, vars map[string]string) (string, error)
+}
+
+type PartnerStore interface {
+	AddBoundaryPartner(t Tenant, projectId string, bp BoundaryPartner) (string, error)
+	// GetBoundaryPartner loads a boundary partner with its progress markers and
+	// their challenges and strategies.
+	GetBoundaryPartner(t Tenant, projectId, partnerId string) (BoundaryPartner, error)
+	// SetPartnerField sets a single column of a boundary partner, a nil value resets it.
+	SetPartnerField(t Tenant, projectId, partnerId, column string, value interface{}) error
+	DeleteBoundaryPartner(t Tenant, projectId, partnerId string) error
+}
+
+type MarkerStore interface {
+	// AddProgressMarker appends a progress marker to the end of the partner's order.
+	AddProgressMarker(t Tenant, projectId, partnerId string, pm ProgressMarker) (string, error)
+	// UpdateProgressMarker updates a progress marker and moves it to
+	// pm.OrderNumber, shifting the markers in between.
+	UpdateProgressMarker(t Tenant, projectId, markerId string, pm ProgressMarker) error
+	// DeleteProgressMarker deletes a progress marker and closes the gap in the order.
+	DeleteProgressMarker(t Tenant, projectId, markerId string) error
+	AddChallenge(t Tenant, projectId, markerId string, c Challenge) (string, error)
+	UpdateChallenge(t Tenant, projectId, challengeId string, c Challenge) error
+	DeleteChallenge(t Tenant, projectId, challengeId string) error
+	AddStrategy(t Tenant, projectId, markerId string, s Strategy) (string, error)
+	UpdateStrategy(t Tenant, projectId, strategyId string, s Strategy) error
+	DeleteStrategy(t Tenant, projectId, strategyId string) error
+	// ApplyMarkerChanges makes the changes of a spreadsheet import in a single
+	// transaction and fills in the IDs of the created items. New progress
+	// markers are appended to their partner's order, unchanged rows are
+	// skipped.
+	ApplyMarkerChanges(t Tenant, projectId string, changes []MarkerChange) error
+}
+
+// ResourceStore hides resources whose file has not been scanned clean from
+// everything but GetQuarantinedResources.
+type ResourceStore interface {
+	GetExternalResources(t Tenant, projectId string) ([]ExternalResources, error)
+	GetExternalResource(t Tenant, projectId, resourceId string) (ExternalResources, error)
+	// GetQuarantinedResources returns the pending and quarantined resources.
+	GetQuarantinedResources(t Tenant, projectId string) ([]ExternalResources, error)
+	// GetPendingScans returns the file versions of the tenant that are
+	// waiting for the virus scanner, oldest first.
+	GetPendingScans(t Tenant) ([]PendingScan, error)
+	// SetScanStatus records the result of scanning a pending version again.
+	// A clean version becomes the current one unless a newer version already
+	// is, a quarantined first version keeps its resource hidden. It returns
+	// ErrNotFound if the version is no longer pending.
+	SetScanStatus(t Tenant, resourceId string, number int, status string) error
+	AddExternalResource(t Tenant, userId string, exr ExternalResources) (string, error)
+	// DeleteExternalResource deletes a resource and returns it so the caller
+	// can remove the stored file.
+	DeleteExternalResource(t Tenant, projectId, resourceId string) (ExternalResources, error)
+	// AddResourceVersion adds a version to a file resource and returns its
+	// number. A clean version becomes the current one.
+	AddResourceVersion(t Tenant, projectId, resourceId, userId string, v ResourceVersion) (int, error)
+	// GetResourceVersions returns the versions of a resource, newest first.
+	GetResourceVersions(t Tenant, projectId, resourceId string) ([]ResourceVersion, error)
+	GetResourceVersion(t Tenant, projectId, resourceId string, number int) (ResourceVersion, error)
+	// PinBlob keeps DeleteUnusedBlob from deleting a blob until unpin is
+	// called, or until the given time if it never is. Uploads pin a blob
+	// before they put it and unpin it once it is referenced.
+	PinBlob(t Tenant, key string, until time.Time) (unpin func(), err error)
+	// DeleteUnusedBlob calls del if no resource, resource version, logo or
+	// pin of the tenant refers to the blob with the given key. The blob is
+	// locked meanwhile, so it can neither be pinned nor referenced between
+	// the check and the deletion.
+	DeleteUnusedBlob(t Tenant, key string, del func() error) error
+	// StorageUsage returns the bytes used by the tenant's resource files and
+	// the organization's own quota, or -1 if it uses the configured default.
+	StorageUsage(t Tenant) (used int64, quota int64, err error)
+}
+
+type JournalStore interface {
+	// GetJournals returns the outcome journals of a project with their
+	// ratings, newest monitoring date first. A non-empty partnerId returns
+	// only the journals of that boundary partner.
+	GetJournals(t Tenant, projectId, partnerId string) ([]OutcomeJournal, error)
+	GetJournal(t Tenant, projectId, journalId string) (OutcomeJournal, error)
+	// AddJournal adds a draft journal. A boundary partner has at most one
+	// journal per monitoring date, and every rated progress marker has to
+	// be one of the partner's.
+	AddJournal(t Tenant, userId string, j OutcomeJournal) (string, error)
+	// UpdateJournal replaces the monitoring date, narrative and ratings of
+	// a draft journal. Submitted journals can not be changed.
+	UpdateJournal(t Tenant, projectId, journalId string, j OutcomeJournal) error
+	// SubmitJournal marks a draft journal as submitted by the user.
+	SubmitJournal(t Tenant, userId, projectId, journalId string) error
+	DeleteJournal(t Tenant, projectId, journalId string) error
+	// GetOverdueJournals returns the draft journals of all projects of the
+	// tenant with a monitoring date before the given day, oldest first.
+	GetOverdueJournals(t Tenant, before string) ([]OverdueJournal, error)
+}
+
+// failures of the journal rules, shared by the Store implementations
+var (
+	errJournalPartner   = FieldErrors{"boundary_partner_id": "not found"}
+	errJournalDate      = FieldErrors{"monitoring_date": "the boundary partner already has a journal for this date"}
+	errJournalMarkers   = FieldErrors{"ratings": "progress markers must belong to the boundary partner"}
+	errJournalSubmitted = FieldErrors{"status": "submitted journals can not be changed"}
+)
+
+// TransferStore copies whole projects out of and into the store.
+type TransferStore interface {
+	// ExportProject reads a project with its partners, markers, journals and
+	// clean resources in one snapshot. The stored files are referenced by the
+	// StorageKey fields.
+	ExportProject(t Tenant, projectId string) (ProjectExport, error)
+	// ImportProject creates a project from an export in a single
+	// transaction. Everything gets a new ID and the references between the
+	// parts are remapped. Progress markers are ordered as they are listed.
+	ImportProject(t Tenant, userId string, doc ProjectExport) (string, error)
+}
+
+// BudgetStore holds the budget lines and expenditures of projects. Amounts
+// are converted into the project's currency by the caller. Adding or updating
+// fails with FieldErrors if a referenced partner, strategy, budget line or
+// receipt is not part of the project.
+type BudgetStore interface {
+	GetBudgetLines(t Tenant, projectId string) ([]BudgetLine, error)
+	GetBudgetLine(t Tenant, projectId, lineId string) (BudgetLine, error)
+	AddBudgetLine(t Tenant, userId string, line BudgetLine) (string, error)
+	UpdateBudgetLine(t Tenant, projectId, lineId string, line BudgetLine) error
+	// DeleteBudgetLine deletes a budget line, its expenditures become
+	// unallocated.
+	DeleteBudgetLine(t Tenant, projectId, lineId string) error
+	// GetExpenditures returns the expenditures of a project, latest first.
+	GetExpenditures(t Tenant, projectId string) ([]Expenditure, error)
+	GetExpenditure(t Tenant, projectId, expenditureId string) (Expenditure, error)
+	AddExpenditure(t Tenant, userId string, e Expenditure) (string, error)
+	UpdateExpenditure(t Tenant, projectId, expenditureId string, e Expenditure) error
+	DeleteExpenditure(t Tenant, projectId, expenditureId string) error
+	// GetBudgetReport sums the budget lines and expenditures of a project.
+	GetBudgetReport(t Tenant, projectId string) (BudgetReport, error)
+}
+
+// DonorStore holds the donors of organizations and their grants to projects.
+// Donors are matched by name without regard to case, a name that is taken
+// fails with FieldErrors.
+type DonorStore interface {
+	GetDonors(t Tenant) ([]Donor, error)
+	GetDonor(t Tenant, donorId string) (Donor, error)
+	AddDonor(t Tenant, d Donor) (string, error)
+	UpdateDonor(t Tenant, donorId string, d Donor) error
+	// DeleteDonor fails with FieldErrors while the donor has grants or
+	// grant officers.
+	DeleteDonor(t Tenant, donorId string) error
+	// GetGrants returns the grants of a project in the order they were added.
+	GetGrants(t Tenant, projectId string) ([]Grant, error)
+	GetGrant(t Tenant, projectId, grantId string) (Grant, error)
+	// AddGrant and UpdateGrant fail with FieldErrors if the donor is not one
+	// of the organization's. The deadlines are replaced as a whole.
+	AddGrant(t Tenant, userId string, g Grant) (string, error)
+	UpdateGrant(t Tenant, projectId, grantId string, g Grant) error
+	DeleteGrant(t Tenant, projectId, grantId string) error
+	// SetProjectDonor registers a grant without amount from the named donor,
+	// unless the project has a grant from it already. ResetProjectDonor
+	// deletes the grants of the project that have nothing but a donor. Both
+	// stand in for the donor column projects used to have.
+	SetProjectDonor(t Tenant, projectId, donor string) error
+	ResetProjectDonor(t Tenant, projectId string) error
+	// GetDonorProjects returns the projects a donor has grants for, with
+	// only the grants of that donor.
+	GetDonorProjects(t Tenant, donorId string) ([]DonorProject, error)
+	GetGrantOfficers(t Tenant, donorId string) ([]User, error)
+	// SetGrantOfficer makes a user who is not an admin a grant officer of a
+	// donor, or an ordinary member again if donorId is empty.
+	SetGrantOfficer(t Tenant, userId, donorId string) error
+}
+
+// ActivityStore holds the activities, milestones and monitoring dates of
+// projects. That they lie within the project's timeline is checked by the
+// caller. Adding or updating fails with FieldErrors if the strategy or
+// progress marker is not part of the project, or the owner is not a member
+// of the organization.
+type ActivityStore interface {
+	// GetActivities returns the activities of a project by start date.
+	GetActivities(t Tenant, projectId string) ([]Activity, error)
+	GetActivity(t Tenant, projectId, activityId string) (Activity, error)
+	AddActivity(t Tenant, userId string, a Activity) (string, error)
+	UpdateActivity(t Tenant, projectId, activityId string, a Activity) error
+	DeleteActivity(t Tenant, projectId, activityId string) error
+	// GetCalendarKey returns the key the project's calendar feed link is
+	// made with, or ErrNotFound if it has none.
+	GetCalendarKey(t Tenant, projectId string) (string, error)
+	// SetCalendarKey replaces the key of the calendar feed link, which
+	// revokes the links made with the previous one. An empty key revokes
+	// them without making a new one.
+	SetCalendarKey(t Tenant, userId, projectId, key string) error
+	// GetDueActivities returns the activities of a kind in all projects of
+	// the tenant that are neither done nor cancelled and start between the
+	// days from and to, by start date. An empty from has no lower bound.
+	GetDueActivities(t Tenant, kind, from, to string) ([]Activity, error)
+}
+
+// TaskStore holds the tasks on progress markers, challenges and strategies
+// and their comments. Tasks are deleted with what they are on. Adding or
+// updating fails with FieldErrors if that is not part of the project or the
+// assignee is not a member of the organization.
+type TaskStore interface {
+	// GetTasks returns the tasks of a project in the order they were added.
+	GetTasks(t Tenant, projectId string) ([]Task, error)
+	GetTask(t Tenant, projectId, taskId string) (Task, error)
+	AddTask(t Tenant, userId string, task Task) (string, error)
+	// UpdateTask updates everything but the status of a task.
+	UpdateTask(t Tenant, projectId, taskId string, task Task) error
+	// SetTaskStatus changes the status of a task if it still is from, and
+	// fails with FieldErrors otherwise.
+	SetTaskStatus(t Tenant, projectId, taskId, from, to string) error
+	DeleteTask(t Tenant, projectId, taskId string) error
+	// GetAssignedTasks returns the open tasks assigned to a user in all
+	// projects of the tenant, by due date.
+	GetAssignedTasks(t Tenant, userId string) ([]Task, error)
+	// GetOverdueTasks returns the open tasks of all projects of the tenant
+	// that were due before the given day, by due date.
+	GetOverdueTasks(t Tenant, today string) ([]Task, error)
+	// GetTaskComments returns the comments of a task, oldest first.
+	GetTaskComments(t Tenant, projectId, taskId string) ([]TaskComment, error)
+	AddTaskComment(t Tenant, userId, projectId, taskId, body string) (string, error)
+}
+
+// CommentStore holds the comments on projects and their parts. Comments are
+// deleted with what they are on, and replies with the comment they reply to.
+type CommentStore interface {
+	// GetComments returns the comments on an entity of a project, or on the
+	// whole project if entityType is empty, oldest first.
+	GetComments(t Tenant, projectId, entityType, entityId string) ([]Comment, error)
+	GetComment(t Tenant, projectId, commentId string) (Comment, error)
+	// AddComment fails with FieldErrors if the entity is not part of the
+	// project, the parent does not start a thread on the same entity, or a
+	// mentioned user is not a member of the organization.
+	AddComment(t Tenant, userId string, c Comment) (string, error)
+	// UpdateComment replaces the body and mentions of a comment.
+	UpdateComment(t Tenant, projectId, commentId, body string, mentions []string) error
+	DeleteComment(t Tenant, projectId, commentId string) error
+	// SetCommentResolved resolves a thread, or reopens it if userId is empty.
+	SetCommentResolved(t Tenant, projectId, commentId, userId string) error
+}
+
+// NotificationStore holds the notifications of users and how they want to
+// be told.
+type NotificationStore interface {
+	AddNotification(t Tenant, n Notification) (string, error)
+	// GetNotifications returns the newest inbox notifications of a user,
+	// at most limit of them.
+	GetNotifications(t Tenant, userId string, unreadOnly bool, limit int) ([]Notification, error)
+	CountUnreadNotifications(t Tenant, userId string) (int, error)
+	// MarkNotificationsRead marks a notification of a user as read, or all
+	// of them if notificationId is empty.
+	MarkNotificationsRead(t Tenant, userId, notificationId string) error
+	// GetNotificationPreferences returns the preferences a user has set;
+	// kinds without one use defaultPreferences.
+	GetNotificationPreferences(t Tenant, userId string) ([]NotificationPreference, error)
+	SetNotificationPreference(t Tenant, userId string, p NotificationPreference) error
+	// GetUnmailedNotifications returns the notifications of the tenant
+	// still to be mailed, by user and then oldest first.
+	GetUnmailedNotifications(t Tenant) ([]Notification, error)
+	SetNotificationsMailed(t Tenant, notificationIds []string) error
+	// GetProjectTeam returns the users responsible for work in a project:
+	// the owners of its activities and the assignees of its open tasks.
+	GetProjectTeam(t Tenant, projectId string) ([]string, error)
+	// MarkReminderSent records that the reminder with the given key was
+	// sent. It returns false if it had been sent before.
+	MarkReminderSent(t Tenant, key string) (bool, error)
+	// PurgeNotifications deletes the notifications created before the given
+	// time that have been read and mailed, or were never to be, and the
+	// reminders sent before it. It returns the number of notifications
+	// deleted.
+	PurgeNotifications(t Tenant, before time.Time) (int, error)
+}
+
+// WebhookStore holds the webhooks of organizations and the deliveries of
+// events to them. Webhooks are returned with their secrets, deliveries are
+// deleted with their webhook.
+type WebhookStore interface {
+	GetWebhooks(t Tenant) ([]Webhook, error)
+	GetWebhook(t Tenant, webhookId string) (Webhook, error)
+	AddWebhook(t Tenant, userId string, wh Webhook) (string, error)
+	// UpdateWebhook changes everything but the secret of a webhook.
+	UpdateWebhook(t Tenant, webhookId string, wh Webhook) error
+	DeleteWebhook(t Tenant, webhookId string) error
+	// GetSubscribedWebhooks returns the active webhooks of the tenant that
+	// subscribe to an event.
+	GetSubscribedWebhooks(t Tenant, event string) ([]Webhook, error)
+	// AddDelivery adds a pending delivery, first attempted at
+	// d.NextAttemptAt.
+	AddDelivery(t Tenant, d WebhookDelivery) (string, error)
+	// GetDeliveries returns the newest deliveries to a webhook, at most
+	// limit of them.
+	GetDeliveries(t Tenant, webhookId string, limit int) ([]WebhookDelivery, error)
+	GetDelivery(t Tenant, webhookId, deliveryId string) (WebhookDelivery, error)
+	// GetDueDeliveries returns the pending deliveries of the tenant to
+	// active webhooks whose next attempt is due at now.
+	GetDueDeliveries(t Tenant, now time.Time) ([]WebhookDelivery, error)
+	// ClaimDelivery moves the next attempt of a due pending delivery to
+	// until, so that no one else attempts it meanwhile. It returns false
+	// if the delivery is not pending or not due.
+	ClaimDelivery(t Tenant, deliveryId string, now, until time.Time) (bool, error)
+	// SetDeliveryResult records the outcome of an attempt: the status,
+	// attempts, next attempt, response, error and delivery time of d.
+	SetDeliveryResult(t Tenant, d WebhookDelivery) error
+}
+
+// SearchStore finds the parts of the tenant's projects by their text. In the
+// title and snippet of a result the matched words are enclosed in
+// HIGHLIGHT_START and HIGHLIGHT_STOP, the text is not escaped.
+type SearchStore interface {
+	// Search returns a page of the results, best first, and the number of
+	// results on all pages.
+	Search(t Tenant, q SearchQuery) ([]SearchResult, int, error)
+}
+
+// JobStore holds the runs of background jobs, which are shared by all
+// instances of the server and belong to no tenant.
+type JobStore interface {
+	// ScheduleJob adds a pending run of a job at runAt, unless the job has
+	// a pending or running one already.
+	ScheduleJob(name string, runAt time.Time, maxAttempts int) error
+	// GetDueJobs returns the pending runs due at now, oldest first.
+	GetDueJobs(now time.Time) ([]Job, error)
+	// LockJob takes the lock of a job, which is held until unlock is
+	// called. ok is false if another instance holds it.
+	LockJob(name string) (unlock func(), ok bool, err error)
+	// StartJob marks a pending run as running and counts the attempt. It
+	// returns ErrNotFound if the run is no longer pending.
+	StartJob(jobId string) (Job, error)
+	// FinishJob records the outcome of a running run. If runErr is not nil
+	// and attempts are left, the run is pending again at retryAt.
+	FinishJob(jobId string, runErr error, retryAt time.Time) error
+	// RequeueStaleJobs makes the runs started before the given time that
+	// are still running pending again, or failed if no attempts are left,
+	// as their instance has gone away.
+	RequeueStaleJobs(before time.Time) error
+	// PurgeJobs deletes the finished runs older than the given time and
+	// returns how many there were.
+	PurgeJobs(before time.Time) (int, error)
+}
+
+// StatsStore aggregates project data for dashboards.
+type StatsStore interface {
+	// GetProjectStats returns the stats of a project with a breakdown by
+	// boundary partner.
+	GetProjectStats(t Tenant, projectId string) (ProjectStats, error)
+	// GetOrganizationStats returns the stats of every project of the
+	// tenant, without the breakdown by partner.
+	GetOrganizationStats(t Tenant) ([]ProjectStats, error)
+}
+
+// OrganizationStore holds the settings of the tenant's own organization.
+type OrganizationStore interface {
+	GetOrganization(t Tenant) (Organization, error)
+	// SetOrganizationLogo points the organization's logo at stored blobs, a
+	// nil logo resets it. The previous logo, if any, is returned.
+	SetOrganizationLogo(t Tenant, logo *ProjectLogo) (*ProjectLogo, error)
+	// SetBrandColor sets the color of the organization's reports, an empty
+	// color resets it.
+	SetBrandColor(t Tenant, color string) error
+	// GetReportTemplate returns the organization's template for a report
+	// format, or ErrNotFound if it uses the built-in one.
+	GetReportTemplate(t Tenant, format string) (string, error)
+	SetReportTemplate(t Tenant, userId, format, body string) error
+	DeleteReportTemplate(t Tenant, format string) error
+}
+
+type UserStore interface {
+	GetUser(userId string) (User, error)
+	GetUserEmail(userId string) (string, error)
+	// GetOrganizationIds returns the IDs of all organizations, for work done
+	// on behalf of each of them outside of requests.
+	GetOrganizationIds() ([]string, error)
+	// GetAdminIds returns the IDs of the admins of the tenant's organization.
+	GetAdminIds(t Tenant) ([]string, error)
+	// GetLogin returns the ID and password hash of the user with the given email.
+	GetLogin(email string) (string, []byte, error)
+}
+
+// resourceUrl returns the URL clients fetch a resource from. Stored files are
+// served by the content endpoint, other resources keep their external URL.
+func resourceUrl(projectId, resourceId, url, storageKey string) string {
+	if storageKey == "" {
+		return url
+	}
+	return "/projects/" + projectId + "/resources/" + resourceId + "/content"
+}
+
+// projectLogo fills in the URLs of a stored logo, or returns nil if the
+// project has none. The URLs change with the content, so clients can cache
+// a logo for as long as its URL stays the same.
+func projectLogo(projectId string, logo ProjectLogo) *ProjectLogo {
+	return logoUrls("/projects/"+projectId+"/logo/", logo)
+}
+
+// organizationLogo fills in the URLs of the organization's logo, or returns
+// nil if it has none.
+func organizationLogo(logo ProjectLogo) *ProjectLogo {
+	return logoUrls("/organization/logo/", logo)
+}
+
+func logoUrls(prefix string, logo ProjectLogo) *ProjectLogo {
+	if logo.OriginalKey == "" {
+		return nil
+	}
+	url := func(variant, key string) string {
+		return prefix + variant + "?v=" + blobSHA256(key)[:16]
+	}
+	logo.Original = url("original", logo.OriginalKey)
+	logo.Medium = url("medium", logo.MediumKey)
+	logo.Thumbnail = url("thumbnail", logo.ThumbnailKey)
+	return &logo
+}
+
+// key returns the blob key of a logo variant.
+func (l *ProjectLogo) key(variant string) string {
+	switch variant {
+	case "original":
+		return l.OriginalKey
+	case "medium":
+		return l.MediumKey
+	case "thumbnail":
+		return l.ThumbnailKey
+	}
+	return ""
+}
+
+// versionOf returns the file of a new resource as its first version.
+func versionOf(exr ExternalResources) ResourceVersion {
+	return ResourceVersion{
+		ResourceName: exr.ResourceName,
+		Size:         exr.Size,
+		SHA256:       exr.SHA256,
+		ContentType:  exr.ContentType,
+		ScanStatus:   scanStatus(exr),
+		StorageKey:   exr.StorageKey,
+	}
+}
+
+// exportedResource returns a resource for an export. Only links keep their
+// URL, the URL of a stored file is only valid in its own project.
+func exportedResource(exr ExternalResources) ExportedResource {
+	r := ExportedResource{
+		ResourceId:        exr.ResourceId,
+		ResourceType:      exr.ResourceType,
+		ResourceName:      exr.ResourceName,
+		Title:             exr.Title,
+		Description:       exr.Description,
+		Tags:              exr.Tags,
+		FaviconUrl:        exr.FaviconUrl,
+		BoundaryPartnerId: exr.BoundaryPartnerId,
+		ProgressMarkerId:  exr.ProgressMarkerId,
+		JournalId:         exr.JournalId,
+		Size:              exr.Size,
+		SHA256:            exr.SHA256,
+		ContentType:       exr.ContentType,
+		StorageKey:        exr.StorageKey,
+	}
+	if exr.ResourceType == RESOURCE_LINK {
+		r.ResourceUrl = exr.ResourceUrl
+	}
+	return r
+}
+
+// exportedJournal returns a journal for an export.
+func exportedJournal(j OutcomeJournal) *ExportedJournal {
+	return &ExportedJournal{
+		JournalId:           j.JournalId,
+		BoundaryPartnerId:   j.BoundaryPartnerId,
+		MonitoringDate:      j.MonitoringDate,
+		DescriptionOfChange: j.DescriptionOfChange,
+		ContributingFactors: j.ContributingFactors,
+		SourcesOfEvidence:   j.SourcesOfEvidence,
+		UnanticipatedChange: j.UnanticipatedChange,
+		Lessons:             j.Lessons,
+		Status:              j.Status,
+		SubmittedAt:         j.SubmittedAt,
+		Ratings:             j.Ratings,
+	}
+}
+
+// journal returns an imported journal as a journal of the project, with the
+// IDs of the export. A submitted journal keeps its status; if the export
+// does not say when it was submitted, it counts as submitted now.
+func (j ExportedJournal) journal(projectId string) OutcomeJournal {
+	journal := OutcomeJournal{
+		ProjectId:           projectId,
+		BoundaryPartnerId:   j.BoundaryPartnerId,
+		MonitoringDate:      j.MonitoringDate,
+		DescriptionOfChange: j.DescriptionOfChange,
+		ContributingFactors: j.ContributingFactors,
+		SourcesOfEvidence:   j.SourcesOfEvidence,
+		UnanticipatedChange: j.UnanticipatedChange,
+		Lessons:             j.Lessons,
+		Status:              j.Status,
+		Ratings:             append([]JournalRating{}, j.Ratings...),
+	}
+	if j.Status == JOURNAL_SUBMITTED {
+		journal.SubmittedAt = j.SubmittedAt
+		if journal.SubmittedAt == nil {
+			now := time.Now()
+			journal.SubmittedAt = &now
+		}
+	}
+	return journal
+}
+
+// resource returns an imported resource as a resource of the project. The
+// file details are only kept if the file was imported as well.
+func (r ExportedResource) resource(projectId string) ExternalResources {
+	exr := ExternalResources{
+		ProjectId:         projectId,
+		ResourceType:      r.ResourceType,
+		ResourceUrl:       r.ResourceUrl,
+		ResourceName:      r.ResourceName,
+		Title:             r.Title,
+		Description:       r.Description,
+		Tags:              normalizeTags(r.Tags),
+		FaviconUrl:        r.FaviconUrl,
+		BoundaryPartnerId: r.BoundaryPartnerId,
+		ProgressMarkerId:  r.ProgressMarkerId,
+		JournalId:         r.JournalId,
+		ScanStatus:        r.ScanStatus,
+		StorageKey:        r.StorageKey,
+	}
+	if r.StorageKey != "" {
+		exr.Size = r.Size
+		exr.SHA256 = r.SHA256
+		exr.ContentType = r.ContentType
+	}
+	return exr
+}
+
+// scanStatus returns the scan state a new resource is stored with. Links and
+// files from before scanning was added have nothing to scan.
+func scanStatus(exr ExternalResources) string {
+	if exr.ScanStatus == "" {
+		return SCAN_CLEAN
+	}
+	return exr.ScanStatus
+}
+
+// columns of projects that may be updated or reset through SetProjectField
+var projectColumns = map[string]bool{
+	"project_name": true,
+	"description":  true,
+	"budget":       true,
+	"mission":      true,
+	"vision":       true,
+}
+
+// columns of boundary_partners that may be updated or reset through SetPartnerField
+var partnerColumns = map[string]bool{
+	"partner_name":      true,
+	"outcome_statement": true,
+}
+
+// ownershipOrder lists the nested route variables in the order they are
+// checked by CheckOwnership, with the field reported when one does not match.
+var ownershipOrder = []struct {
+	routeVar string
+	field    string
+}{
+	{"partnerId", "boundary_partner_id"},
+	{"progressMarkerId", "progress_marker_id"},
+	{"challengeId", "challenge_id"},
+	{"strategyId", "strategy_id"},
+	{"resourceId", "resource_id"},
+	{"journalId", "journal_id"},
+	{"budgetLineId", "budget_line_id"},
+	{"expenditureId", "expenditure_id"},
+	{"grantId", "grant_id"},
+	{"activityId", "activity_id"},
+	{"taskId", "task_id"},
+	{"commentId", "comment_id"},
+}
+
+// commentEntities maps the parts of a project comments can be on to the
+// route variable their ownership is checked with. Comments on the project
+// itself need no check beyond the project's.
+var commentEntities = map[string]string{
+	ENTITY_BOUNDARY_PARTNER:  "partnerId",
+	ENTITY_OUTCOME_STATEMENT: "partnerId",
+	ENTITY_PROGRESS_MARKER:   "progressMarkerId",
+	ENTITY_CHALLENGE:         "challengeId",
+	ENTITY_STRATEGY:          "strategyId",
+}
+
+var (
+	_ Store = (*pgStore)(nil)
+	_ Store = (*memStore)(nil)
+)
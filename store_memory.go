@@ -0,0 +1,3550 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
+)
+
+// memStore implements Store in memory. It mirrors the behaviour of pgStore,
+// including tenant scoping, cascading deletes and progress marker ordering,
+// so handlers can be exercised without a database.
+type memStore struct {
+	*sync.RWMutex
+	seq        int
+	users      map[string]*memUser
+	projects   map[string]*memProject
+	partners   map[string]*memPartner
+	markers    map[string]*memMarker
+	challenges map[string]*memChallenge
+	strategies map[string]*memStrategy
+	resources  map[string]*memResource
+	journals   map[string]*memJournal
+	// budget lines and expenditures
+	budgetLines  map[string]*memBudgetLine
+	expenditures map[string]*memExpenditure
+	// donors of all organizations and their grants
+	donors map[string]*memDonor
+	grants map[string]*memGrant
+	// pinned blobs and the lock held while a blob is pinned or deleted
+	pins     map[string]*memPin
+	blobLock *sync.Mutex
+	quotas   map[string]int64
+	orgs     map[string]Organization
+	// report templates by organization and format
+	templates map[[2]string]string
+	// activities, milestones and monitoring dates
+	activities map[string]*memActivity
+	// the keys of calendar feed links by project
+	calendarKeys map[string]string
+	// tasks and their comments
+	tasks        map[string]*memTask
+	taskComments map[string]*memTaskComment
+	// comments on projects and their parts
+	comments map[string]*memComment
+	// notifications of all users and their preferences by user ID and kind
+	inbox       map[string]*memNotification
+	preferences map[[2]string]NotificationPreference
+	// webhooks of all organizations and the deliveries to them
+	webhooks   map[string]*memWebhook
+	deliveries map[string]*memDelivery
+	// reminders sent by organization and key
+	reminders map[[2]string]time.Time
+	// runs of background jobs and the names of the jobs locked
+	jobs     map[string]*memJob
+	jobLocks map[string]bool
+}
+
+type memUser struct {
+	User
+	email          string
+	hashedPassword []byte
+}
+
+type memProject struct {
+	Project
+	logo           ProjectLogo
+	organizationId string
+	seq            int
+	// the timeline as set, Project holds it formatted
+	timelineFrom, timelineTo time.Time
+}
+
+type memPartner struct {
+	BoundaryPartner
+	seq int
+}
+
+type memMarker struct {
+	ProgressMarker
+	seq int
+}
+
+type memChallenge struct {
+	Challenge
+	seq int
+}
+
+type memStrategy struct {
+	Strategy
+	seq int
+}
+
+type memResource struct {
+	ExternalResources
+	createdBy string
+	seq       int
+	versions  []ResourceVersion
+}
+
+type memJournal struct {
+	OutcomeJournal
+	createdBy string
+	seq       int
+}
+
+type memBudgetLine struct {
+	BudgetLine
+	createdBy string
+	seq       int
+}
+
+type memExpenditure struct {
+	Expenditure
+	seq int
+}
+
+type memDonor struct {
+	Donor
+	organizationId string
+}
+
+type memGrant struct {
+	Grant
+	seq int
+}
+
+type memActivity struct {
+	Activity
+	createdBy string
+	seq       int
+}
+
+type memTask struct {
+	Task
+	seq int
+}
+
+type memTaskComment struct {
+	TaskComment
+	seq int
+}
+
+type memComment struct {
+	Comment
+	seq int
+}
+
+type memNotification struct {
+	Notification
+	organizationId string
+	mailed         bool
+	seq            int
+}
+
+type memWebhook struct {
+	Webhook
+	organizationId string
+}
+
+type memDelivery struct {
+	WebhookDelivery
+	organizationId string
+	seq            int
+}
+
+type memJob struct {
+	Job
+	seq int
+}
+
+type memPin struct {
+	key            string
+	organizationId string
+	until          time.Time
+}
+
+// NewMemoryStore returns an empty Store that keeps everything in memory. It
+// has no users until they are added with AddUser.
+func NewMemoryStore() Store {
+	return &memStore{
+		RWMutex:      &sync.RWMutex{},
+		users:        make(map[string]*memUser),
+		projects:     make(map[string]*memProject),
+		partners:     make(map[string]*memPartner),
+		markers:      make(map[string]*memMarker),
+		challenges:   make(map[string]*memChallenge),
+		strategies:   make(map[string]*memStrategy),
+		resources:    make(map[string]*memResource),
+		journals:     make(map[string]*memJournal),
+		budgetLines:  make(map[string]*memBudgetLine),
+		expenditures: make(map[string]*memExpenditure),
+		donors:       make(map[string]*memDonor),
+		grants:       make(map[string]*memGrant),
+		activities:   make(map[string]*memActivity),
+		calendarKeys: make(map[string]string),
+		pins:         make(map[string]*memPin),
+		blobLock:     &sync.Mutex{},
+		tasks:        make(map[string]*memTask),
+		taskComments: make(map[string]*memTaskComment),
+		comments:     make(map[string]*memComment),
+		inbox:        make(map[string]*memNotification),
+		preferences:  make(map[[2]string]NotificationPreference),
+		webhooks:     make(map[string]*memWebhook),
+		deliveries:   make(map[string]*memDelivery),
+		reminders:    make(map[[2]string]time.Time),
+		jobs:         make(map[string]*memJob),
+		jobLocks:     make(map[string]bool),
+		quotas:       make(map[string]int64),
+		orgs:         make(map[string]Organization),
+		templates:    make(map[[2]string]string),
+	}
+}
+
+// next returns an increasing sequence number that stands in for ts_created.
+func (s *memStore) next() int {
+	s.seq++
+	return s.seq
+}
+
+// AddUser registers a user that can log in with the given email and password hash.
+func (s *memStore) AddUser(user User, email string, hashedPassword []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.users[user.UserId] = &memUser{user, email, hashedPassword}
+}
+
+// AddOrganization registers an organization.
+func (s *memStore) AddOrganization(org Organization) {
+	s.Lock()
+	defer s.Unlock()
+	s.orgs[org.OrganizationId] = org
+}
+
+func (s *memStore) GetOrganization(t Tenant) (Organization, error) {
+	s.RLock()
+	defer s.RUnlock()
+	org, ok := s.orgs[t.OrganizationId]
+	if !ok {
+		return Organization{OrganizationId: t.OrganizationId}, ErrNotFound
+	}
+	if org.Logo != nil {
+		org.Logo = organizationLogo(*org.Logo)
+	}
+	return org, nil
+}
+
+func (s *memStore) SetOrganizationLogo(t Tenant, logo *ProjectLogo) (*ProjectLogo, error) {
+	s.Lock()
+	defer s.Unlock()
+	org, ok := s.orgs[t.OrganizationId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	old := org.Logo
+	org.Logo = nil
+	if logo != nil {
+		stored := *logo
+		org.Logo = &stored
+	}
+	s.orgs[t.OrganizationId] = org
+	if old == nil {
+		return nil, nil
+	}
+	return organizationLogo(*old), nil
+}
+
+func (s *memStore) SetBrandColor(t Tenant, color string) error {
+	s.Lock()
+	defer s.Unlock()
+	org, ok := s.orgs[t.OrganizationId]
+	if !ok {
+		return ErrNotFound
+	}
+	org.BrandColor = color
+	s.orgs[t.OrganizationId] = org
+	return nil
+}
+
+func (s *memStore) GetReportTemplate(t Tenant, format string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	body, ok := s.templates[[2]string{t.OrganizationId, format}]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return body, nil
+}
+
+func (s *memStore) SetReportTemplate(t Tenant, userId, format, body string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.templates[[2]string{t.OrganizationId, format}] = body
+	return nil
+}
+
+func (s *memStore) DeleteReportTemplate(t Tenant, format string) error {
+	s.Lock()
+	defer s.Unlock()
+	key := [2]string{t.OrganizationId, format}
+	if _, ok := s.templates[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.templates, key)
+	return nil
+}
+
+func (s *memStore) GetUser(userId string) (User, error) {
+	s.RLock()
+	defer s.RUnlock()
+	u, ok := s.users[userId]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u.User, nil
+}
+
+func (s *memStore) GetUserEmail(userId string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	u, ok := s.users[userId]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return u.email, nil
+}
+
+// GetOrganizationIds returns the organizations that were registered or have
+// users.
+func (s *memStore) GetOrganizationIds() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	seen := make(map[string]bool)
+	organizationIds := []string{}
+	for id := range s.orgs {
+		seen[id] = true
+		organizationIds = append(organizationIds, id)
+	}
+	for _, u := range s.users {
+		if !seen[u.OrganizationId] {
+			seen[u.OrganizationId] = true
+			organizationIds = append(organizationIds, u.OrganizationId)
+		}
+	}
+	sort.Strings(organizationIds)
+	return organizationIds, nil
+}
+
+func (s *memStore) GetAdminIds(t Tenant) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	adminIds := []string{}
+	for _, u := range s.users {
+		if u.OrganizationId == t.OrganizationId && u.IsAdmin {
+			adminIds = append(adminIds, u.UserId)
+		}
+	}
+	sort.Strings(adminIds)
+	return adminIds, nil
+}
+
+func (s *memStore) GetLogin(email string) (string, []byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, u := range s.users {
+		if strings.ToLower(u.email) == strings.ToLower(email) {
+			return u.UserId, u.hashedPassword, nil
+		}
+	}
+	return "", nil, ErrNotFound
+}
+
+// project returns the tenant's project or nil.
+func (s *memStore) project(t Tenant, projectId string) *memProject {
+	p, ok := s.projects[projectId]
+	if !ok || p.organizationId != t.OrganizationId {
+		return nil
+	}
+	return p
+}
+
+// partner returns the boundary partner of the tenant's project or nil.
+func (s *memStore) partner(t Tenant, projectId, partnerId string) *memPartner {
+	bp, ok := s.partners[partnerId]
+	if !ok || bp.ProjectId != projectId || s.project(t, projectId) == nil {
+		return nil
+	}
+	return bp
+}
+
+// marker returns the progress marker of the tenant's project or nil.
+func (s *memStore) marker(t Tenant, projectId, markerId string) *memMarker {
+	pm, ok := s.markers[markerId]
+	if !ok || s.partner(t, projectId, pm.BoundaryPartnerId) == nil {
+		return nil
+	}
+	return pm
+}
+
+func (s *memStore) GetProjects(t Tenant) ([]Project, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var owned []*memProject
+	for _, p := range s.projects {
+		if p.organizationId == t.OrganizationId {
+			owned = append(owned, p)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].seq < owned[j].seq })
+
+	projects := []Project{}
+	for _, mp := range owned {
+		projects = append(projects, s.projectView(mp))
+	}
+	return projects, nil
+}
+
+func (s *memStore) GetProject(t Tenant, projectId string) (Project, error) {
+	s.RLock()
+	defer s.RUnlock()
+	p := s.project(t, projectId)
+	if p == nil {
+		return Project{}, ErrNotFound
+	}
+	return s.projectView(p), nil
+}
+
+// projectView fills in the partner and resource lists of a stored project.
+func (s *memStore) projectView(mp *memProject) Project {
+	p := mp.Project
+	p.Logo = projectLogo(p.ProjectId, mp.logo)
+	p.Budget = s.projectBudget(mp)
+	p.Donor = s.projectDonors(mp.ProjectId)
+	p.Comments = s.countComments(ENTITY_PROJECT, mp.ProjectId)
+	p.BoundaryPartnerIds, p.BoundaryPartnerNames = nil, nil
+	p.ResourceIds, p.ResourceUrls = nil, nil
+	for _, bp := range s.sortedPartners(p.ProjectId) {
+		p.BoundaryPartnerIds = append(p.BoundaryPartnerIds, bp.BoundaryPartnerId)
+		p.BoundaryPartnerNames = append(p.BoundaryPartnerNames, bp.PartnerName)
+	}
+	for _, exr := range s.sortedResources(p.ProjectId, true) {
+		p.ResourceIds = append(p.ResourceIds, exr.ResourceId)
+		p.ResourceUrls = append(p.ResourceUrls, resourceUrl(exr.ProjectId, exr.ResourceId, exr.ResourceUrl, exr.StorageKey))
+	}
+	return p
+}
+
+func (s *memStore) sortedPartners(projectId string) []*memPartner {
+	var partners []*memPartner
+	for _, bp := range s.partners {
+		if bp.ProjectId == projectId {
+			partners = append(partners, bp)
+		}
+	}
+	sort.Slice(partners, func(i, j int) bool { return partners[i].seq < partners[j].seq })
+	return partners
+}
+
+// projectBudget returns the sum of the budget lines of a project, or the
+// budget set on it if it has none.
+func (s *memStore) projectBudget(mp *memProject) Amount {
+	lines := s.sortedBudgetLines(mp.ProjectId)
+	if len(lines) == 0 {
+		return mp.Budget
+	}
+	var budget Amount
+	for _, line := range lines {
+		budget += line.ProjectAmount
+	}
+	return budget
+}
+
+// sortedResources returns the resources of a project that were scanned clean,
+// or those that were not.
+func (s *memStore) sortedResources(projectId string, clean bool) []*memResource {
+	var resources []*memResource
+	for _, exr := range s.resources {
+		if exr.ProjectId == projectId && (exr.ScanStatus == SCAN_CLEAN) == clean {
+			resources = append(resources, exr)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].seq < resources[j].seq })
+	return resources
+}
+
+func (s *memStore) AddProject(t Tenant, p Project) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	projectId := uuid.NewV4().String()
+	stored := Project{
+		ProjectId:   projectId,
+		ProjectName: p.ProjectName,
+		Description: p.Description,
+		Budget:      p.Budget,
+		Currency:    p.Currency,
+		Vision:      p.Vision,
+		Mission:     p.Mission,
+	}
+	s.projects[projectId] = &memProject{Project: stored, organizationId: t.OrganizationId, seq: s.next()}
+	// a donor given by name is registered with a grant without amount
+	if donor := strings.TrimSpace(p.Donor); donor != "" {
+		s.insertGrant(t, Grant{ProjectId: projectId, DonorName: donor, Currency: p.Currency})
+	}
+	return projectId, nil
+}
+
+func (s *memStore) SetProjectField(t Tenant, projectId, column string, value interface{}) error {
+	if !projectColumns[column] {
+		return fmt.Errorf("unknown project column %q", column)
+	}
+	s.Lock()
+	defer s.Unlock()
+	p := s.project(t, projectId)
+	if p == nil {
+		return ErrNotFound
+	}
+	switch column {
+	case "project_name":
+		p.ProjectName = memString(value)
+	case "description":
+		p.Description = memString(value)
+	case "budget":
+		p.Budget, _ = value.(Amount)
+	case "mission":
+		p.Mission = memString(value)
+	case "vision":
+		p.Vision = memString(value)
+	}
+	return nil
+}
+
+func (s *memStore) SetProjectTimeline(t Tenant, projectId string, from, to interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	p := s.project(t, projectId)
+	if p == nil {
+		return ErrNotFound
+	}
+	p.TimelineFrom = memTimestamp(from)
+	p.TimelineTo = memTimestamp(to)
+	p.timelineFrom, _ = from.(time.Time)
+	p.timelineTo, _ = to.(time.Time)
+	return nil
+}
+
+func (s *memStore) SetProjectLogo(t Tenant, projectId string, logo *ProjectLogo) (*ProjectLogo, error) {
+	s.Lock()
+	defer s.Unlock()
+	p := s.project(t, projectId)
+	if p == nil {
+		return nil, ErrNotFound
+	}
+	old := p.logo
+	p.logo = ProjectLogo{}
+	if logo != nil {
+		p.logo = *logo
+	}
+	return projectLogo(projectId, old), nil
+}
+
+func (s *memStore) SetProjectCurrency(t Tenant, projectId, currency string) error {
+	s.Lock()
+	defer s.Unlock()
+	p := s.project(t, projectId)
+	if p == nil {
+		return ErrNotFound
+	}
+	if currency == p.Currency {
+		return nil
+	}
+	if len(s.sortedBudgetLines(projectId)) > 0 || len(s.sortedExpenditures(projectId)) > 0 {
+		return FieldErrors{"project_currency": "can not change once the project has budget lines or expenditures"}
+	}
+	p.Currency = currency
+	return nil
+}
+
+func (s *memStore) DeleteProject(t Tenant, projectId string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	for _, bp := range s.partners {
+		if bp.ProjectId == projectId {
+			s.deletePartner(bp.BoundaryPartnerId)
+		}
+	}
+	for id, exr := range s.resources {
+		if exr.ProjectId == projectId {
+			delete(s.resources, id)
+		}
+	}
+	for id, line := range s.budgetLines {
+		if line.ProjectId == projectId {
+			delete(s.budgetLines, id)
+		}
+	}
+	for id, e := range s.expenditures {
+		if e.ProjectId == projectId {
+			delete(s.expenditures, id)
+		}
+	}
+	for id, g := range s.grants {
+		if g.ProjectId == projectId {
+			delete(s.grants, id)
+		}
+	}
+	for id, a := range s.activities {
+		if a.ProjectId == projectId {
+			delete(s.activities, id)
+		}
+	}
+	delete(s.calendarKeys, projectId)
+	s.deleteTasks(func(task *memTask) bool { return task.ProjectId == projectId })
+	s.deleteComments(func(c *memComment) bool { return c.ProjectId == projectId })
+	for id, n := range s.inbox {
+		if n.ProjectId == projectId {
+			delete(s.inbox, id)
+		}
+	}
+	delete(s.projects, projectId)
+	return nil
+}
+
+func (s *memStore) CheckOwnership(t Tenant, vars map[string]string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.checkOwnership(t, vars), nil
+}
+
+// checkOwnership returns the field of the first route variable that is not
+// part of the project, or "" if they all are.
+func (s *memStore) checkOwnership(t Tenant, vars map[string]string) string {
+	projectId := vars["projectId"]
+	if s.project(t, projectId) == nil {
+		return "project_id"
+	}
+	partnerId, scopedByPartner := vars["partnerId"]
+	for _, nested := range ownershipOrder {
+		id, exists := vars[nested.routeVar]
+		if !exists {
+			continue
+		}
+		owningPartner := ""
+		switch nested.routeVar {
+		case "partnerId":
+			if s.partner(t, projectId, id) == nil {
+				return nested.field
+			}
+			continue
+		case "progressMarkerId":
+			if pm := s.marker(t, projectId, id); pm != nil {
+				owningPartner = pm.BoundaryPartnerId
+			}
+		case "challengeId":
+			if c, ok := s.challenges[id]; ok {
+				if pm := s.marker(t, projectId, c.ProgressMarkerId); pm != nil {
+					owningPartner = pm.BoundaryPartnerId
+				}
+			}
+		case "strategyId":
+			if strat, ok := s.strategies[id]; ok {
+				if pm := s.marker(t, projectId, strat.ProgressMarkerId); pm != nil {
+					owningPartner = pm.BoundaryPartnerId
+				}
+			}
+		case "resourceId":
+			if exr, ok := s.resources[id]; !ok || exr.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		case "budgetLineId":
+			if line, ok := s.budgetLines[id]; !ok || line.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		case "expenditureId":
+			if e, ok := s.expenditures[id]; !ok || e.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		case "journalId":
+			if j, ok := s.journals[id]; ok && j.ProjectId == projectId {
+				owningPartner = j.BoundaryPartnerId
+			}
+		case "grantId":
+			if g, ok := s.grants[id]; !ok || g.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		case "activityId":
+			if a, ok := s.activities[id]; !ok || a.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		case "taskId":
+			if task, ok := s.tasks[id]; !ok || task.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		case "commentId":
+			if c, ok := s.comments[id]; !ok || c.ProjectId != projectId {
+				return nested.field
+			}
+			continue
+		}
+		if owningPartner == "" || (scopedByPartner && owningPartner != partnerId) {
+			return nested.field
+		}
+	}
+	return ""
+}
+
+func (s *memStore) AddBoundaryPartner(t Tenant, projectId string, bp BoundaryPartner) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, projectId) == nil {
+		return "", ErrNotFound
+	}
+	partnerId := uuid.NewV4().String()
+	stored := BoundaryPartner{BoundaryPartnerId: partnerId, ProjectId: projectId, PartnerName: bp.PartnerName}
+	s.partners[partnerId] = &memPartner{stored, s.next()}
+	return partnerId, nil
+}
+
+func (s *memStore) GetBoundaryPartner(t Tenant, projectId, partnerId string) (BoundaryPartner, error) {
+	s.RLock()
+	defer s.RUnlock()
+	mp := s.partner(t, projectId, partnerId)
+	if mp == nil {
+		return BoundaryPartner{}, ErrNotFound
+	}
+	return s.partnerView(mp), nil
+}
+
+// partnerView returns a stored partner with its progress markers and their
+// challenges and strategies.
+func (s *memStore) partnerView(mp *memPartner) BoundaryPartner {
+	bp := mp.BoundaryPartner
+	bp.ProgressMarkers = nil
+	bp.Comments = s.countComments(ENTITY_BOUNDARY_PARTNER, bp.BoundaryPartnerId)
+	bp.OutcomeStatementComments = s.countComments(ENTITY_OUTCOME_STATEMENT, bp.BoundaryPartnerId)
+	for _, m := range s.sortedMarkers(bp.BoundaryPartnerId) {
+		pm := m.ProgressMarker
+		pm.Challenges, pm.Strategies = nil, nil
+		pm.Comments = s.countComments(ENTITY_PROGRESS_MARKER, pm.ProgressMarkerId)
+		var challenges []*memChallenge
+		for _, c := range s.challenges {
+			if c.ProgressMarkerId == pm.ProgressMarkerId {
+				challenges = append(challenges, c)
+			}
+		}
+		sort.Slice(challenges, func(i, j int) bool { return challenges[i].seq < challenges[j].seq })
+		for _, c := range challenges {
+			ch := c.Challenge
+			ch.Comments = s.countComments(ENTITY_CHALLENGE, ch.ChallengeId)
+			pm.Challenges = append(pm.Challenges, &ch)
+		}
+		var strategies []*memStrategy
+		for _, strat := range s.strategies {
+			if strat.ProgressMarkerId == pm.ProgressMarkerId {
+				strategies = append(strategies, strat)
+			}
+		}
+		sort.Slice(strategies, func(i, j int) bool { return strategies[i].seq < strategies[j].seq })
+		for _, strat := range strategies {
+			st := strat.Strategy
+			st.Comments = s.countComments(ENTITY_STRATEGY, st.StrategyId)
+			pm.Strategies = append(pm.Strategies, &st)
+		}
+		bp.ProgressMarkers = append(bp.ProgressMarkers, &pm)
+	}
+	return bp
+}
+
+// sortedMarkers returns the progress markers of a partner by order number.
+func (s *memStore) sortedMarkers(partnerId string) []*memMarker {
+	var markers []*memMarker
+	for _, pm := range s.markers {
+		if pm.BoundaryPartnerId == partnerId {
+			markers = append(markers, pm)
+		}
+	}
+	sort.Slice(markers, func(i, j int) bool {
+		if markers[i].OrderNumber != markers[j].OrderNumber {
+			return markers[i].OrderNumber < markers[j].OrderNumber
+		}
+		return markers[i].seq < markers[j].seq
+	})
+	return markers
+}
+
+func (s *memStore) SetPartnerField(t Tenant, projectId, partnerId, column string, value interface{}) error {
+	if !partnerColumns[column] {
+		return fmt.Errorf("unknown boundary partner column %q", column)
+	}
+	s.Lock()
+	defer s.Unlock()
+	bp := s.partner(t, projectId, partnerId)
+	if bp == nil {
+		return ErrNotFound
+	}
+	switch column {
+	case "partner_name":
+		bp.PartnerName = memString(value)
+	case "outcome_statement":
+		bp.OutcomeStatement = memString(value)
+	}
+	return nil
+}
+
+func (s *memStore) DeleteBoundaryPartner(t Tenant, projectId, partnerId string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.partner(t, projectId, partnerId) == nil {
+		return ErrNotFound
+	}
+	s.deletePartner(partnerId)
+	return nil
+}
+
+// deletePartner removes a partner and cascades to its progress markers.
+func (s *memStore) deletePartner(partnerId string) {
+	for _, pm := range s.markers {
+		if pm.BoundaryPartnerId == partnerId {
+			s.deleteMarker(pm.ProgressMarkerId)
+		}
+	}
+	for _, exr := range s.resources {
+		if exr.BoundaryPartnerId == partnerId {
+			exr.BoundaryPartnerId = ""
+		}
+	}
+	for id, j := range s.journals {
+		if j.BoundaryPartnerId == partnerId {
+			s.deleteJournal(id)
+		}
+	}
+	for _, line := range s.budgetLines {
+		if line.BoundaryPartnerId == partnerId {
+			line.BoundaryPartnerId = ""
+		}
+	}
+	s.deleteComments(func(c *memComment) bool {
+		return c.EntityId == partnerId && (c.EntityType == ENTITY_BOUNDARY_PARTNER || c.EntityType == ENTITY_OUTCOME_STATEMENT)
+	})
+	delete(s.partners, partnerId)
+}
+
+// deleteMarker removes a progress marker and cascades to its challenges and strategies.
+func (s *memStore) deleteMarker(markerId string) {
+	for id, c := range s.challenges {
+		if c.ProgressMarkerId == markerId {
+			s.deleteChallenge(id)
+		}
+	}
+	for id, strat := range s.strategies {
+		if strat.ProgressMarkerId == markerId {
+			s.deleteStrategy(id)
+		}
+	}
+	for _, exr := range s.resources {
+		if exr.ProgressMarkerId == markerId {
+			exr.ProgressMarkerId = ""
+		}
+	}
+	for _, j := range s.journals {
+		ratings := []JournalRating{}
+		for _, r := range j.Ratings {
+			if r.ProgressMarkerId != markerId {
+				ratings = append(ratings, r)
+			}
+		}
+		j.Ratings = ratings
+	}
+	for _, a := range s.activities {
+		if a.ProgressMarkerId == markerId {
+			a.ProgressMarkerId = ""
+		}
+	}
+	s.deleteTasks(func(task *memTask) bool { return task.ProgressMarkerId == markerId })
+	s.deleteComments(func(c *memComment) bool { return c.EntityType == ENTITY_PROGRESS_MARKER && c.EntityId == markerId })
+	delete(s.markers, markerId)
+}
+
+func (s *memStore) AddProgressMarker(t Tenant, projectId, partnerId string, pm ProgressMarker) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.partner(t, projectId, partnerId) == nil {
+		return "", ErrNotFound
+	}
+	markerId := uuid.NewV4().String()
+	stored := ProgressMarker{
+		ProgressMarkerId:  markerId,
+		BoundaryPartnerId: partnerId,
+		Title:             pm.Title,
+		Type:              pm.Type,
+		OrderNumber:       len(s.sortedMarkers(partnerId)) + 1,
+	}
+	s.markers[markerId] = &memMarker{stored, s.next()}
+	return markerId, nil
+}
+
+func (s *memStore) UpdateProgressMarker(t Tenant, projectId, markerId string, pm ProgressMarker) error {
+	s.Lock()
+	defer s.Unlock()
+	stored := s.marker(t, projectId, markerId)
+	if stored == nil {
+		return ErrNotFound
+	}
+	siblings := s.sortedMarkers(stored.BoundaryPartnerId)
+	if errs := validate(intRange("order_number", pm.OrderNumber, 1, len(siblings))); errs != nil {
+		return errs
+	}
+	oldOrderNumber := stored.OrderNumber
+	for _, other := range siblings {
+		if oldOrderNumber > pm.OrderNumber && other.OrderNumber >= pm.OrderNumber && other.OrderNumber <= oldOrderNumber-1 {
+			other.OrderNumber++
+		} else if oldOrderNumber < pm.OrderNumber && other.OrderNumber >= oldOrderNumber+1 && other.OrderNumber <= pm.OrderNumber {
+			other.OrderNumber--
+		}
+	}
+	stored.Title = pm.Title
+	stored.Type = pm.Type
+	stored.OrderNumber = pm.OrderNumber
+	return nil
+}
+
+func (s *memStore) DeleteProgressMarker(t Tenant, projectId, markerId string) error {
+	s.Lock()
+	defer s.Unlock()
+	stored := s.marker(t, projectId, markerId)
+	if stored == nil {
+		return ErrNotFound
+	}
+	s.deleteMarker(markerId)
+	for _, other := range s.markers {
+		if other.BoundaryPartnerId == stored.BoundaryPartnerId && other.OrderNumber > stored.OrderNumber {
+			other.OrderNumber--
+		}
+	}
+	return nil
+}
+
+func (s *memStore) AddChallenge(t Tenant, projectId, markerId string, c Challenge) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.marker(t, projectId, markerId) == nil {
+		return "", ErrNotFound
+	}
+	challengeId := uuid.NewV4().String()
+	stored := Challenge{ChallengeId: challengeId, ProgressMarkerId: markerId, ChallengeName: c.ChallengeName}
+	s.challenges[challengeId] = &memChallenge{stored, s.next()}
+	return challengeId, nil
+}
+
+func (s *memStore) UpdateChallenge(t Tenant, projectId, challengeId string, c Challenge) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.challenges[challengeId]
+	if !ok || s.marker(t, projectId, stored.ProgressMarkerId) == nil {
+		return ErrNotFound
+	}
+	stored.ChallengeName = c.ChallengeName
+	return nil
+}
+
+func (s *memStore) DeleteChallenge(t Tenant, projectId, challengeId string) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.challenges[challengeId]
+	if !ok || s.marker(t, projectId, stored.ProgressMarkerId) == nil {
+		return ErrNotFound
+	}
+	s.deleteChallenge(challengeId)
+	return nil
+}
+
+// deleteChallenge removes a challenge and cascades to its tasks and comments.
+func (s *memStore) deleteChallenge(challengeId string) {
+	s.deleteTasks(func(task *memTask) bool { return task.ChallengeId == challengeId })
+	s.deleteComments(func(c *memComment) bool { return c.EntityType == ENTITY_CHALLENGE && c.EntityId == challengeId })
+	delete(s.challenges, challengeId)
+}
+
+func (s *memStore) AddStrategy(t Tenant, projectId, markerId string, strat Strategy) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.marker(t, projectId, markerId) == nil {
+		return "", ErrNotFound
+	}
+	strategyId := uuid.NewV4().String()
+	stored := Strategy{StrategyId: strategyId, ProgressMarkerId: markerId, StrategyName: strat.StrategyName}
+	s.strategies[strategyId] = &memStrategy{stored, s.next()}
+	return strategyId, nil
+}
+
+func (s *memStore) UpdateStrategy(t Tenant, projectId, strategyId string, strat Strategy) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.strategies[strategyId]
+	if !ok || s.marker(t, projectId, stored.ProgressMarkerId) == nil {
+		return ErrNotFound
+	}
+	stored.StrategyName = strat.StrategyName
+	return nil
+}
+
+func (s *memStore) DeleteStrategy(t Tenant, projectId, strategyId string) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.strategies[strategyId]
+	if !ok || s.marker(t, projectId, stored.ProgressMarkerId) == nil {
+		return ErrNotFound
+	}
+	s.deleteStrategy(strategyId)
+	return nil
+}
+
+// deleteStrategy removes a strategy, takes it off the budget lines and
+// activities for it and cascades to its tasks and comments.
+func (s *memStore) deleteStrategy(strategyId string) {
+	for _, line := range s.budgetLines {
+		if line.StrategyId == strategyId {
+			line.StrategyId = ""
+		}
+	}
+	for _, a := range s.activities {
+		if a.StrategyId == strategyId {
+			a.StrategyId = ""
+		}
+	}
+	s.deleteTasks(func(task *memTask) bool { return task.StrategyId == strategyId })
+	s.deleteComments(func(c *memComment) bool { return c.EntityType == ENTITY_STRATEGY && c.EntityId == strategyId })
+	delete(s.strategies, strategyId)
+}
+
+func (s *memStore) ApplyMarkerChanges(t Tenant, projectId string, changes []MarkerChange) error {
+	s.Lock()
+	defer s.Unlock()
+	// check every change first, nothing is changed if one of them fails
+	for _, c := range changes {
+		found := true
+		switch {
+		case c.Action == CHANGE_UNCHANGED:
+		case c.Kind == KIND_MARKER && c.Action == CHANGE_CREATE:
+			found = s.partner(t, projectId, c.BoundaryPartnerId) != nil
+		case c.Kind == KIND_MARKER:
+			found = s.marker(t, projectId, c.Id) != nil
+		case c.Action == CHANGE_CREATE:
+			found = c.NewMarker >= 0 || s.marker(t, projectId, c.ProgressMarkerId) != nil
+		case c.Kind == KIND_CHALLENGE:
+			stored, ok := s.challenges[c.Id]
+			found = ok && s.marker(t, projectId, stored.ProgressMarkerId) != nil
+		case c.Kind == KIND_STRATEGY:
+			stored, ok := s.strategies[c.Id]
+			found = ok && s.marker(t, projectId, stored.ProgressMarkerId) != nil
+		}
+		if !found {
+			return ErrNotFound
+		}
+	}
+
+	for i := range changes {
+		c := &changes[i]
+		if c.Action == CHANGE_UNCHANGED {
+			continue
+		}
+		if c.NewMarker >= 0 {
+			c.ProgressMarkerId = changes[c.NewMarker].Id
+		}
+		if c.Action == CHANGE_CREATE {
+			c.Id = uuid.NewV4().String()
+		}
+		switch {
+		case c.Kind == KIND_MARKER && c.Action == CHANGE_CREATE:
+			stored := ProgressMarker{
+				ProgressMarkerId:  c.Id,
+				BoundaryPartnerId: c.BoundaryPartnerId,
+				Title:             c.Name,
+				Type:              c.Type,
+				OrderNumber:       len(s.sortedMarkers(c.BoundaryPartnerId)) + 1,
+			}
+			s.markers[c.Id] = &memMarker{stored, s.next()}
+		case c.Kind == KIND_MARKER:
+			s.markers[c.Id].Title = c.Name
+			s.markers[c.Id].Type = c.Type
+		case c.Kind == KIND_CHALLENGE && c.Action == CHANGE_CREATE:
+			stored := Challenge{ChallengeId: c.Id, ProgressMarkerId: c.ProgressMarkerId, ChallengeName: c.Name}
+			s.challenges[c.Id] = &memChallenge{stored, s.next()}
+		case c.Kind == KIND_CHALLENGE:
+			s.challenges[c.Id].ChallengeName = c.Name
+		case c.Kind == KIND_STRATEGY && c.Action == CHANGE_CREATE:
+			stored := Strategy{StrategyId: c.Id, ProgressMarkerId: c.ProgressMarkerId, StrategyName: c.Name}
+			s.strategies[c.Id] = &memStrategy{stored, s.next()}
+		case c.Kind == KIND_STRATEGY:
+			s.strategies[c.Id].StrategyName = c.Name
+		}
+	}
+	return nil
+}
+
+func (s *memStore) GetExternalResources(t Tenant, projectId string) ([]ExternalResources, error) {
+	s.RLock()
+	defer s.RUnlock()
+	resources := []ExternalResources{}
+	if s.project(t, projectId) == nil {
+		return resources, nil
+	}
+	for _, exr := range s.sortedResources(projectId, true) {
+		resources = append(resources, exr.view())
+	}
+	return resources, nil
+}
+
+func (s *memStore) GetQuarantinedResources(t Tenant, projectId string) ([]ExternalResources, error) {
+	s.RLock()
+	defer s.RUnlock()
+	resources := []ExternalResources{}
+	if s.project(t, projectId) == nil {
+		return resources, nil
+	}
+	for _, exr := range s.sortedResources(projectId, false) {
+		resources = append(resources, exr.view())
+	}
+	return resources, nil
+}
+
+func (s *memStore) GetPendingScans(t Tenant) ([]PendingScan, error) {
+	s.RLock()
+	defer s.RUnlock()
+	type pending struct {
+		PendingScan
+		created time.Time
+		seq     int
+	}
+	var found []pending
+	for _, exr := range s.resources {
+		if s.project(t, exr.ProjectId) == nil {
+			continue
+		}
+		for _, v := range exr.versions {
+			if v.ScanStatus == SCAN_PENDING {
+				found = append(found, pending{PendingScan{exr.ProjectId, exr.ResourceId, v.VersionNumber, v.ResourceName, v.StorageKey}, v.TsCreated, exr.seq})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if !a.created.Equal(b.created) {
+			return a.created.Before(b.created)
+		}
+		if a.seq != b.seq {
+			return a.seq < b.seq
+		}
+		return a.VersionNumber < b.VersionNumber
+	})
+	scans := []PendingScan{}
+	for _, p := range found {
+		scans = append(scans, p.PendingScan)
+	}
+	return scans, nil
+}
+
+func (s *memStore) SetScanStatus(t Tenant, resourceId string, number int, status string) error {
+	s.Lock()
+	defer s.Unlock()
+	exr, ok := s.resources[resourceId]
+	if !ok || s.project(t, exr.ProjectId) == nil || number < 1 || number > len(exr.versions) {
+		return ErrNotFound
+	}
+	v := &exr.versions[number-1]
+	if v.ScanStatus != SCAN_PENDING {
+		return ErrNotFound
+	}
+	v.ScanStatus = status
+	switch {
+	case status == SCAN_CLEAN && (exr.ScanStatus != SCAN_CLEAN || exr.Version < number):
+		exr.ResourceName = v.ResourceName
+		exr.StorageKey = v.StorageKey
+		exr.Size = v.Size
+		exr.SHA256 = v.SHA256
+		exr.ContentType = v.ContentType
+		exr.ScanStatus = SCAN_CLEAN
+		exr.Version = number
+	case status == SCAN_QUARANTINED && exr.ScanStatus == SCAN_PENDING && exr.Version == number:
+		exr.ScanStatus = SCAN_QUARANTINED
+	}
+	return nil
+}
+
+func (s *memStore) GetExternalResource(t Tenant, projectId, resourceId string) (ExternalResources, error) {
+	s.RLock()
+	defer s.RUnlock()
+	exr, ok := s.resources[resourceId]
+	if !ok || exr.ProjectId != projectId || exr.ScanStatus != SCAN_CLEAN || s.project(t, projectId) == nil {
+		return ExternalResources{}, ErrNotFound
+	}
+	return exr.view(), nil
+}
+
+// view returns the resource as pgStore would, with the URL it is fetched from.
+func (exr *memResource) view() ExternalResources {
+	v := exr.ExternalResources
+	v.ResourceUrl = resourceUrl(v.ProjectId, v.ResourceId, v.ResourceUrl, v.StorageKey)
+	return v
+}
+
+func (s *memStore) AddExternalResource(t Tenant, userId string, exr ExternalResources) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, exr.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	return s.insertResource(userId, exr), nil
+}
+
+// insertResource adds a resource to an existing project. A stored file
+// becomes the first version of the resource.
+func (s *memStore) insertResource(userId string, exr ExternalResources) string {
+	resourceId := uuid.NewV1().String()
+	exr.ResourceId = resourceId
+	exr.Tags = normalizeTags(exr.Tags)
+	exr.ScanStatus = scanStatus(exr)
+	stored := &memResource{exr, userId, s.next(), nil}
+	if exr.StorageKey != "" {
+		stored.Version = 1
+		stored.addVersion(userId, versionOf(exr))
+	}
+	s.resources[resourceId] = stored
+	return resourceId
+}
+
+func (exr *memResource) addVersion(userId string, v ResourceVersion) int {
+	v.ResourceId = exr.ResourceId
+	v.VersionNumber = len(exr.versions) + 1
+	v.CreatedBy = userId
+	v.TsCreated = time.Now()
+	exr.versions = append(exr.versions, v)
+	return v.VersionNumber
+}
+
+func (s *memStore) AddResourceVersion(t Tenant, projectId, resourceId, userId string, v ResourceVersion) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	exr, ok := s.resources[resourceId]
+	if !ok || exr.ProjectId != projectId || s.project(t, projectId) == nil {
+		return 0, ErrNotFound
+	}
+	if exr.ResourceType != RESOURCE_FILE {
+		return 0, FieldErrors{"resource_id": "only files have versions"}
+	}
+	number := exr.addVersion(userId, v)
+	if v.ScanStatus == SCAN_CLEAN {
+		exr.ResourceName = v.ResourceName
+		exr.StorageKey = v.StorageKey
+		exr.Size = v.Size
+		exr.SHA256 = v.SHA256
+		exr.ContentType = v.ContentType
+		exr.ScanStatus = v.ScanStatus
+		exr.Version = number
+	}
+	return number, nil
+}
+
+func (s *memStore) GetResourceVersions(t Tenant, projectId, resourceId string) ([]ResourceVersion, error) {
+	s.RLock()
+	defer s.RUnlock()
+	versions := []ResourceVersion{}
+	exr, ok := s.resources[resourceId]
+	if !ok || exr.ProjectId != projectId || s.project(t, projectId) == nil {
+		return versions, nil
+	}
+	for i := len(exr.versions) - 1; i >= 0; i-- {
+		versions = append(versions, s.versionView(exr, exr.versions[i]))
+	}
+	return versions, nil
+}
+
+func (s *memStore) GetResourceVersion(t Tenant, projectId, resourceId string, number int) (ResourceVersion, error) {
+	s.RLock()
+	defer s.RUnlock()
+	exr, ok := s.resources[resourceId]
+	if !ok || exr.ProjectId != projectId || s.project(t, projectId) == nil || number < 1 || number > len(exr.versions) {
+		return ResourceVersion{}, ErrNotFound
+	}
+	return s.versionView(exr, exr.versions[number-1]), nil
+}
+
+// versionView adds the uploader's name and the current flag to a version.
+func (s *memStore) versionView(exr *memResource, v ResourceVersion) ResourceVersion {
+	if u, ok := s.users[v.CreatedBy]; ok {
+		v.CreatedByName = u.FullName
+	}
+	v.Current = v.VersionNumber == exr.Version
+	return v
+}
+
+func (s *memStore) DeleteExternalResource(t Tenant, projectId, resourceId string) (ExternalResources, error) {
+	s.Lock()
+	defer s.Unlock()
+	exr, ok := s.resources[resourceId]
+	if !ok || exr.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ExternalResources{}, ErrNotFound
+	}
+	for _, e := range s.expenditures {
+		if e.ReceiptResourceId == resourceId {
+			e.ReceiptResourceId = ""
+		}
+	}
+	delete(s.resources, resourceId)
+	return exr.ExternalResources, nil
+}
+
+func (s *memStore) StorageUsage(t Tenant) (int64, int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	sizes := make(map[string]int64)
+	for _, exr := range s.resources {
+		if s.project(t, exr.ProjectId) == nil {
+			continue
+		}
+		if exr.StorageKey != "" {
+			sizes[exr.StorageKey] = exr.Size
+		}
+		for _, v := range exr.versions {
+			sizes[v.StorageKey] = v.Size
+		}
+	}
+	var used int64
+	for _, size := range sizes {
+		used += size
+	}
+	quota, ok := s.quotas[t.OrganizationId]
+	if !ok {
+		quota = -1
+	}
+	return used, quota, nil
+}
+
+// SetStorageQuota overrides the storage quota of an organization.
+func (s *memStore) SetStorageQuota(organizationId string, quota int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.quotas[organizationId] = quota
+}
+
+func (s *memStore) PinBlob(t Tenant, key string, until time.Time) (func(), error) {
+	s.blobLock.Lock()
+	defer s.blobLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	pinId := uuid.NewV4().String()
+	s.pins[pinId] = &memPin{key, t.OrganizationId, until}
+	unpin := func() {
+		s.Lock()
+		defer s.Unlock()
+		delete(s.pins, pinId)
+	}
+	return unpin, nil
+}
+
+func (s *memStore) DeleteUnusedBlob(t Tenant, key string, del func() error) error {
+	s.blobLock.Lock()
+	defer s.blobLock.Unlock()
+	s.RLock()
+	inUse := s.blobInUse(t, key)
+	s.RUnlock()
+	if inUse {
+		return nil
+	}
+	return del()
+}
+
+// blobInUse reports whether a resource, resource version, logo or pin of the
+// tenant refers to a blob.
+func (s *memStore) blobInUse(t Tenant, key string) bool {
+	for _, pin := range s.pins {
+		if pin.key == key && pin.organizationId == t.OrganizationId && pin.until.After(time.Now()) {
+			return true
+		}
+	}
+	for _, exr := range s.resources {
+		if s.project(t, exr.ProjectId) == nil {
+			continue
+		}
+		if exr.StorageKey == key {
+			return true
+		}
+		for _, v := range exr.versions {
+			if v.StorageKey == key {
+				return true
+			}
+		}
+	}
+	for _, p := range s.projects {
+		if p.organizationId == t.OrganizationId && (p.logo.OriginalKey == key || p.logo.MediumKey == key || p.logo.ThumbnailKey == key) {
+			return true
+		}
+	}
+	if logo := s.orgs[t.OrganizationId].Logo; logo != nil {
+		return logo.OriginalKey == key || logo.MediumKey == key || logo.ThumbnailKey == key
+	}
+	return false
+}
+
+func (s *memStore) GetJournals(t Tenant, projectId, partnerId string) ([]OutcomeJournal, error) {
+	s.RLock()
+	defer s.RUnlock()
+	journals := []OutcomeJournal{}
+	if s.project(t, projectId) == nil {
+		return journals, nil
+	}
+	for _, j := range s.sortedJournals(projectId, partnerId) {
+		journals = append(journals, s.journalView(j))
+	}
+	return journals, nil
+}
+
+// sortedJournals returns the journals of a project, or of one of its
+// partners, newest monitoring date first.
+func (s *memStore) sortedJournals(projectId, partnerId string) []*memJournal {
+	var journals []*memJournal
+	for _, j := range s.journals {
+		if j.ProjectId == projectId && (partnerId == "" || j.BoundaryPartnerId == partnerId) {
+			journals = append(journals, j)
+		}
+	}
+	sort.Slice(journals, func(i, k int) bool {
+		if journals[i].MonitoringDate != journals[k].MonitoringDate {
+			return journals[i].MonitoringDate > journals[k].MonitoringDate
+		}
+		return journals[i].seq < journals[k].seq
+	})
+	return journals
+}
+
+func (s *memStore) GetJournal(t Tenant, projectId, journalId string) (OutcomeJournal, error) {
+	s.RLock()
+	defer s.RUnlock()
+	j, ok := s.journals[journalId]
+	if !ok || j.ProjectId != projectId || s.project(t, projectId) == nil {
+		return OutcomeJournal{}, ErrNotFound
+	}
+	return s.journalView(j), nil
+}
+
+// journalView returns a journal as pgStore would, with its ratings in the
+// order of the progress markers.
+func (s *memStore) journalView(j *memJournal) OutcomeJournal {
+	v := j.OutcomeJournal
+	v.Ratings = append([]JournalRating{}, j.Ratings...)
+	sort.SliceStable(v.Ratings, func(i, k int) bool {
+		return s.markers[v.Ratings[i].ProgressMarkerId].OrderNumber < s.markers[v.Ratings[k].ProgressMarkerId].OrderNumber
+	})
+	return v
+}
+
+// checkJournal is the memStore version of the pgStore function.
+func (s *memStore) checkJournal(t Tenant, projectId, journalId string, j OutcomeJournal) error {
+	if s.partner(t, projectId, j.BoundaryPartnerId) == nil {
+		return errJournalPartner
+	}
+	for id, other := range s.journals {
+		if id != journalId && other.BoundaryPartnerId == j.BoundaryPartnerId && other.MonitoringDate == j.MonitoringDate {
+			return errJournalDate
+		}
+	}
+	for _, r := range j.Ratings {
+		if pm, ok := s.markers[r.ProgressMarkerId]; !ok || pm.BoundaryPartnerId != j.BoundaryPartnerId {
+			return errJournalMarkers
+		}
+	}
+	return nil
+}
+
+func (s *memStore) AddJournal(t Tenant, userId string, j OutcomeJournal) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, j.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	if err := s.checkJournal(t, j.ProjectId, "", j); err != nil {
+		return "", err
+	}
+	j.JournalId = uuid.NewV4().String()
+	j.Status = JOURNAL_DRAFT
+	j.SubmittedAt, j.SubmittedBy = nil, ""
+	j.Ratings = append([]JournalRating{}, j.Ratings...)
+	s.journals[j.JournalId] = &memJournal{j, userId, s.next()}
+	return j.JournalId, nil
+}
+
+// draftJournal returns a journal of the tenant's project and fails unless it
+// is a draft.
+func (s *memStore) draftJournal(t Tenant, projectId, journalId string) (*memJournal, error) {
+	j, ok := s.journals[journalId]
+	if !ok || j.ProjectId != projectId || s.project(t, projectId) == nil {
+		return nil, ErrNotFound
+	}
+	if j.Status != JOURNAL_DRAFT {
+		return nil, errJournalSubmitted
+	}
+	return j, nil
+}
+
+func (s *memStore) UpdateJournal(t Tenant, projectId, journalId string, j OutcomeJournal) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, err := s.draftJournal(t, projectId, journalId)
+	if err != nil {
+		return err
+	}
+	j.BoundaryPartnerId = stored.BoundaryPartnerId
+	if err = s.checkJournal(t, projectId, journalId, j); err != nil {
+		return err
+	}
+	stored.MonitoringDate = j.MonitoringDate
+	stored.DescriptionOfChange = j.DescriptionOfChange
+	stored.ContributingFactors = j.ContributingFactors
+	stored.SourcesOfEvidence = j.SourcesOfEvidence
+	stored.UnanticipatedChange = j.UnanticipatedChange
+	stored.Lessons = j.Lessons
+	stored.Ratings = append([]JournalRating{}, j.Ratings...)
+	return nil
+}
+
+func (s *memStore) SubmitJournal(t Tenant, userId, projectId, journalId string) error {
+	s.Lock()
+	defer s.Unlock()
+	j, err := s.draftJournal(t, projectId, journalId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	j.Status, j.SubmittedAt, j.SubmittedBy = JOURNAL_SUBMITTED, &now, userId
+	return nil
+}
+
+func (s *memStore) DeleteJournal(t Tenant, projectId, journalId string) error {
+	s.Lock()
+	defer s.Unlock()
+	j, ok := s.journals[journalId]
+	if !ok || j.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	s.deleteJournal(journalId)
+	return nil
+}
+
+func (s *memStore) GetOverdueJournals(t Tenant, before string) ([]OverdueJournal, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var sorted []*memJournal
+	for _, j := range s.journals {
+		if j.Status == JOURNAL_DRAFT && j.MonitoringDate < before && s.project(t, j.ProjectId) != nil {
+			sorted = append(sorted, j)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].MonitoringDate != sorted[j].MonitoringDate {
+			return sorted[i].MonitoringDate < sorted[j].MonitoringDate
+		}
+		return sorted[i].seq < sorted[j].seq
+	})
+	journals := []OverdueJournal{}
+	for _, j := range sorted {
+		journals = append(journals, OverdueJournal{
+			JournalId:         j.JournalId,
+			ProjectId:         j.ProjectId,
+			BoundaryPartnerId: j.BoundaryPartnerId,
+			PartnerName:       s.partners[j.BoundaryPartnerId].PartnerName,
+			MonitoringDate:    j.MonitoringDate,
+			CreatedBy:         j.createdBy,
+		})
+	}
+	return journals, nil
+}
+
+// deleteJournal removes a journal and detaches the resources attached to it.
+func (s *memStore) deleteJournal(journalId string) {
+	for _, exr := range s.resources {
+		if exr.JournalId == journalId {
+			exr.JournalId = ""
+		}
+	}
+	delete(s.journals, journalId)
+}
+
+func (s *memStore) ExportProject(t Tenant, projectId string) (ProjectExport, error) {
+	s.RLock()
+	defer s.RUnlock()
+	mp := s.project(t, projectId)
+	if mp == nil {
+		return ProjectExport{}, ErrNotFound
+	}
+	doc := ProjectExport{
+		Project: ExportedProject{
+			ProjectName:  mp.ProjectName,
+			Description:  mp.Description,
+			Budget:       s.projectBudget(mp),
+			Currency:     mp.Currency,
+			Donor:        s.projectDonors(projectId),
+			Vision:       mp.Vision,
+			Mission:      mp.Mission,
+			TimelineFrom: memRFC3339(mp.timelineFrom),
+			TimelineTo:   memRFC3339(mp.timelineTo),
+			StoredLogo:   projectLogo(projectId, mp.logo),
+		},
+		BoundaryPartners: []*BoundaryPartner{},
+		Journals:         []*ExportedJournal{},
+		Resources:        []ExportedResource{},
+	}
+	for _, partner := range s.sortedPartners(projectId) {
+		bp := s.partnerView(partner)
+		doc.BoundaryPartners = append(doc.BoundaryPartners, &bp)
+	}
+	for _, j := range s.sortedJournals(projectId, "") {
+		doc.Journals = append(doc.Journals, exportedJournal(s.journalView(j)))
+	}
+	for _, exr := range s.sortedResources(projectId, true) {
+		doc.Resources = append(doc.Resources, exportedResource(exr.view()))
+	}
+	for _, g := range s.sortedGrants(projectId) {
+		doc.Grants = append(doc.Grants, s.grantView(g))
+	}
+	return doc, nil
+}
+
+func (s *memStore) ImportProject(t Tenant, userId string, doc ProjectExport) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	p := doc.Project
+	projectId := uuid.NewV4().String()
+	stored := &memProject{
+		Project: Project{
+			ProjectId:   projectId,
+			ProjectName: p.ProjectName,
+			Description: p.Description,
+			Budget:      p.Budget,
+			Currency:    p.Currency,
+			Vision:      p.Vision,
+			Mission:     p.Mission,
+		},
+		organizationId: t.OrganizationId,
+		seq:            s.next(),
+	}
+	if p.TimelineFrom != "" {
+		stored.timelineFrom, _ = time.Parse(time.RFC3339, p.TimelineFrom)
+		stored.TimelineFrom = memTimestamp(stored.timelineFrom)
+	}
+	if p.TimelineTo != "" {
+		stored.timelineTo, _ = time.Parse(time.RFC3339, p.TimelineTo)
+		stored.TimelineTo = memTimestamp(stored.timelineTo)
+	}
+	if p.StoredLogo != nil {
+		stored.logo = *p.StoredLogo
+	}
+	s.projects[projectId] = stored
+
+	// new IDs by the ID in the export
+	ids := make(map[string]string)
+	for _, bp := range doc.BoundaryPartners {
+		partnerId := uuid.NewV4().String()
+		ids[bp.BoundaryPartnerId] = partnerId
+		partner := BoundaryPartner{BoundaryPartnerId: partnerId, ProjectId: projectId, PartnerName: bp.PartnerName, OutcomeStatement: bp.OutcomeStatement}
+		s.partners[partnerId] = &memPartner{partner, s.next()}
+		for i, pm := range bp.ProgressMarkers {
+			markerId := uuid.NewV4().String()
+			ids[pm.ProgressMarkerId] = markerId
+			marker := ProgressMarker{ProgressMarkerId: markerId, BoundaryPartnerId: partnerId, Title: pm.Title, Type: pm.Type, OrderNumber: i + 1}
+			s.markers[markerId] = &memMarker{marker, s.next()}
+			for _, c := range pm.Challenges {
+				challengeId := uuid.NewV4().String()
+				s.challenges[challengeId] = &memChallenge{Challenge{ChallengeId: challengeId, ProgressMarkerId: markerId, ChallengeName: c.ChallengeName}, s.next()}
+			}
+			for _, strat := range pm.Strategies {
+				strategyId := uuid.NewV4().String()
+				s.strategies[strategyId] = &memStrategy{Strategy{StrategyId: strategyId, ProgressMarkerId: markerId, StrategyName: strat.StrategyName}, s.next()}
+			}
+		}
+	}
+
+	for _, exported := range doc.Journals {
+		j := exported.journal(projectId)
+		j.JournalId = uuid.NewV4().String()
+		ids[exported.JournalId] = j.JournalId
+		j.BoundaryPartnerId = ids[j.BoundaryPartnerId]
+		for i := range j.Ratings {
+			j.Ratings[i].ProgressMarkerId = ids[j.Ratings[i].ProgressMarkerId]
+		}
+		s.journals[j.JournalId] = &memJournal{j, userId, s.next()}
+	}
+
+	for _, r := range doc.Resources {
+		exr := r.resource(projectId)
+		exr.BoundaryPartnerId = ids[r.BoundaryPartnerId]
+		exr.ProgressMarkerId = ids[r.ProgressMarkerId]
+		exr.JournalId = ids[r.JournalId]
+		s.insertResource(userId, exr)
+	}
+	for _, g := range importedGrants(doc) {
+		g.ProjectId = projectId
+		s.insertGrant(t, g)
+	}
+	return projectId, nil
+}
+
+func (s *memStore) sortedBudgetLines(projectId string) []*memBudgetLine {
+	var lines []*memBudgetLine
+	for _, line := range s.budgetLines {
+		if line.ProjectId == projectId {
+			lines = append(lines, line)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].seq < lines[j].seq })
+	return lines
+}
+
+// sortedExpenditures returns the expenditures of a project, latest first.
+func (s *memStore) sortedExpenditures(projectId string) []*memExpenditure {
+	var expenditures []*memExpenditure
+	for _, e := range s.expenditures {
+		if e.ProjectId == projectId {
+			expenditures = append(expenditures, e)
+		}
+	}
+	sort.Slice(expenditures, func(i, j int) bool {
+		if expenditures[i].SpentOn != expenditures[j].SpentOn {
+			return expenditures[i].SpentOn > expenditures[j].SpentOn
+		}
+		return expenditures[i].seq > expenditures[j].seq
+	})
+	return expenditures
+}
+
+// checkReferences returns FieldErrors for the non-empty references that are
+// not part of the project, like the pgStore function of the same name.
+func (s *memStore) checkReferences(t Tenant, projectId string, refs ...reference) error {
+	var errs FieldErrors
+	for _, ref := range refs {
+		if ref.id == "" {
+			continue
+		}
+		if s.checkOwnership(t, map[string]string{"projectId": projectId, ref.routeVar: ref.id}) != "" {
+			errs = nestErrors(errs, "", FieldErrors{ref.field: "must be part of the project"})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func (s *memStore) GetBudgetLines(t Tenant, projectId string) ([]BudgetLine, error) {
+	s.RLock()
+	defer s.RUnlock()
+	lines := []BudgetLine{}
+	if s.project(t, projectId) == nil {
+		return lines, nil
+	}
+	for _, line := range s.sortedBudgetLines(projectId) {
+		lines = append(lines, line.BudgetLine)
+	}
+	return lines, nil
+}
+
+func (s *memStore) GetBudgetLine(t Tenant, projectId, lineId string) (BudgetLine, error) {
+	s.RLock()
+	defer s.RUnlock()
+	line, ok := s.budgetLines[lineId]
+	if !ok || line.ProjectId != projectId || s.project(t, projectId) == nil {
+		return BudgetLine{}, ErrNotFound
+	}
+	return line.BudgetLine, nil
+}
+
+func (s *memStore) AddBudgetLine(t Tenant, userId string, line BudgetLine) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, line.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	err := s.checkReferences(t, line.ProjectId,
+		reference{"partnerId", "boundary_partner_id", line.BoundaryPartnerId},
+		reference{"strategyId", "strategy_id", line.StrategyId})
+	if err != nil {
+		return "", err
+	}
+	line.BudgetLineId = uuid.NewV4().String()
+	line.TsCreated = time.Now()
+	s.budgetLines[line.BudgetLineId] = &memBudgetLine{line, userId, s.next()}
+	return line.BudgetLineId, nil
+}
+
+func (s *memStore) UpdateBudgetLine(t Tenant, projectId, lineId string, line BudgetLine) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.budgetLines[lineId]
+	if !ok || stored.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	err := s.checkReferences(t, projectId,
+		reference{"partnerId", "boundary_partner_id", line.BoundaryPartnerId},
+		reference{"strategyId", "strategy_id", line.StrategyId})
+	if err != nil {
+		return err
+	}
+	line.BudgetLineId, line.ProjectId, line.TsCreated = lineId, projectId, stored.TsCreated
+	stored.BudgetLine = line
+	return nil
+}
+
+func (s *memStore) DeleteBudgetLine(t Tenant, projectId, lineId string) error {
+	s.Lock()
+	defer s.Unlock()
+	line, ok := s.budgetLines[lineId]
+	if !ok || line.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	for _, e := range s.expenditures {
+		if e.BudgetLineId == lineId {
+			e.BudgetLineId = ""
+		}
+	}
+	delete(s.budgetLines, lineId)
+	return nil
+}
+
+func (s *memStore) GetExpenditures(t Tenant, projectId string) ([]Expenditure, error) {
+	s.RLock()
+	defer s.RUnlock()
+	expenditures := []Expenditure{}
+	if s.project(t, projectId) == nil {
+		return expenditures, nil
+	}
+	for _, e := range s.sortedExpenditures(projectId) {
+		expenditures = append(expenditures, e.Expenditure)
+	}
+	return expenditures, nil
+}
+
+func (s *memStore) GetExpenditure(t Tenant, projectId, expenditureId string) (Expenditure, error) {
+	s.RLock()
+	defer s.RUnlock()
+	e, ok := s.expenditures[expenditureId]
+	if !ok || e.ProjectId != projectId || s.project(t, projectId) == nil {
+		return Expenditure{}, ErrNotFound
+	}
+	return e.Expenditure, nil
+}
+
+func (s *memStore) AddExpenditure(t Tenant, userId string, e Expenditure) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, e.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	err := s.checkReferences(t, e.ProjectId,
+		reference{"budgetLineId", "budget_line_id", e.BudgetLineId},
+		reference{"resourceId", "receipt_resource_id", e.ReceiptResourceId})
+	if err != nil {
+		return "", err
+	}
+	e.ExpenditureId = uuid.NewV4().String()
+	e.CreatedBy = userId
+	e.TsCreated = time.Now()
+	s.expenditures[e.ExpenditureId] = &memExpenditure{e, s.next()}
+	return e.ExpenditureId, nil
+}
+
+func (s *memStore) UpdateExpenditure(t Tenant, projectId, expenditureId string, e Expenditure) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.expenditures[expenditureId]
+	if !ok || stored.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	err := s.checkReferences(t, projectId,
+		reference{"budgetLineId", "budget_line_id", e.BudgetLineId},
+		reference{"resourceId", "receipt_resource_id", e.ReceiptResourceId})
+	if err != nil {
+		return err
+	}
+	e.ExpenditureId, e.ProjectId, e.CreatedBy, e.TsCreated = expenditureId, projectId, stored.CreatedBy, stored.TsCreated
+	stored.Expenditure = e
+	return nil
+}
+
+func (s *memStore) DeleteExpenditure(t Tenant, projectId, expenditureId string) error {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.expenditures[expenditureId]
+	if !ok || e.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	delete(s.expenditures, expenditureId)
+	return nil
+}
+
+func (s *memStore) GetBudgetReport(t Tenant, projectId string) (BudgetReport, error) {
+	s.RLock()
+	defer s.RUnlock()
+	mp := s.project(t, projectId)
+	if mp == nil {
+		return BudgetReport{}, ErrNotFound
+	}
+	report := BudgetReport{Currency: mp.Currency, Budget: s.projectBudget(mp), Lines: []BudgetReportLine{}}
+	index := make(map[string]int)
+	for _, line := range s.sortedBudgetLines(projectId) {
+		index[line.BudgetLineId] = len(report.Lines)
+		report.Lines = append(report.Lines, BudgetReportLine{
+			BudgetLineId:      line.BudgetLineId,
+			Title:             line.Title,
+			BoundaryPartnerId: line.BoundaryPartnerId,
+			StrategyId:        line.StrategyId,
+			Budget:            line.ProjectAmount,
+		})
+	}
+	for _, e := range s.sortedExpenditures(projectId) {
+		i, ok := index[e.BudgetLineId]
+		if !ok {
+			report.Unallocated += e.ProjectAmount
+			continue
+		}
+		report.Lines[i].Spent += e.ProjectAmount
+		report.Lines[i].Expenditures++
+	}
+	report.total()
+	return report, nil
+}
+
+// projectDonors joins the names of the donors of a project's grants.
+func (s *memStore) projectDonors(projectId string) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, g := range s.sortedGrants(projectId) {
+		if !seen[g.DonorId] {
+			seen[g.DonorId] = true
+			names = append(names, s.donors[g.DonorId].DonorName)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *memStore) sortedGrants(projectId string) []*memGrant {
+	var grants []*memGrant
+	for _, g := range s.grants {
+		if g.ProjectId == projectId {
+			grants = append(grants, g)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].seq < grants[j].seq })
+	return grants
+}
+
+// grantView fills in the donor name of a stored grant.
+func (s *memStore) grantView(g *memGrant) Grant {
+	grant := g.Grant
+	grant.DonorName = s.donors[g.DonorId].DonorName
+	grant.Deadlines = append([]GrantDeadline{}, g.Deadlines...)
+	return grant
+}
+
+// donor returns the tenant's donor with the given ID, or nil.
+func (s *memStore) donor(t Tenant, donorId string) *memDonor {
+	d, ok := s.donors[donorId]
+	if !ok || d.organizationId != t.OrganizationId {
+		return nil
+	}
+	return d
+}
+
+// donorNamed returns the tenant's donor with the given name regardless of
+// case, or nil.
+func (s *memStore) donorNamed(t Tenant, name string) *memDonor {
+	for _, d := range s.donors {
+		if d.organizationId == t.OrganizationId && strings.EqualFold(d.DonorName, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+// insertGrant adds a grant like the pgStore function of the same name.
+func (s *memStore) insertGrant(t Tenant, g Grant) (string, error) {
+	if g.DonorId == "" {
+		d := s.donorNamed(t, g.DonorName)
+		if d == nil {
+			d = &memDonor{Donor{DonorId: uuid.NewV4().String(), DonorName: g.DonorName}, t.OrganizationId}
+			s.donors[d.DonorId] = d
+		}
+		g.DonorId = d.DonorId
+	} else if s.donor(t, g.DonorId) == nil {
+		return "", FieldErrors{"donor_id": "must be a donor of the organization"}
+	}
+	g.GrantId = uuid.NewV4().String()
+	g.DonorName = ""
+	g.Deadlines = append([]GrantDeadline{}, g.Deadlines...)
+	s.grants[g.GrantId] = &memGrant{g, s.next()}
+	return g.GrantId, nil
+}
+
+func (s *memStore) SetProjectDonor(t Tenant, projectId, donor string) error {
+	s.Lock()
+	defer s.Unlock()
+	mp := s.project(t, projectId)
+	if mp == nil {
+		return ErrNotFound
+	}
+	for _, g := range s.grants {
+		if g.ProjectId == projectId && strings.EqualFold(s.donors[g.DonorId].DonorName, donor) {
+			return nil
+		}
+	}
+	_, err := s.insertGrant(t, Grant{ProjectId: projectId, DonorName: donor, Currency: mp.Currency})
+	return err
+}
+
+func (s *memStore) ResetProjectDonor(t Tenant, projectId string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	for id, g := range s.grants {
+		if g.ProjectId == projectId && g.Amount == 0 && g.Reference == "" && g.PeriodFrom == "" && g.PeriodTo == "" &&
+			g.ReportingRequirements == "" && len(g.Deadlines) == 0 {
+			delete(s.grants, id)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) GetDonors(t Tenant) ([]Donor, error) {
+	s.RLock()
+	defer s.RUnlock()
+	donors := []Donor{}
+	for _, d := range s.donors {
+		if d.organizationId == t.OrganizationId {
+			donors = append(donors, d.Donor)
+		}
+	}
+	sort.Slice(donors, func(i, j int) bool {
+		return strings.ToLower(donors[i].DonorName) < strings.ToLower(donors[j].DonorName)
+	})
+	return donors, nil
+}
+
+func (s *memStore) GetDonor(t Tenant, donorId string) (Donor, error) {
+	s.RLock()
+	defer s.RUnlock()
+	d := s.donor(t, donorId)
+	if d == nil {
+		return Donor{}, ErrNotFound
+	}
+	return d.Donor, nil
+}
+
+func (s *memStore) AddDonor(t Tenant, d Donor) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.donorNamed(t, d.DonorName) != nil {
+		return "", FieldErrors{"donor_name": "is the name of another donor"}
+	}
+	d.DonorId = uuid.NewV4().String()
+	s.donors[d.DonorId] = &memDonor{d, t.OrganizationId}
+	return d.DonorId, nil
+}
+
+func (s *memStore) UpdateDonor(t Tenant, donorId string, d Donor) error {
+	s.Lock()
+	defer s.Unlock()
+	stored := s.donor(t, donorId)
+	if stored == nil {
+		return ErrNotFound
+	}
+	if other := s.donorNamed(t, d.DonorName); other != nil && other != stored {
+		return FieldErrors{"donor_name": "is the name of another donor"}
+	}
+	d.DonorId = donorId
+	stored.Donor = d
+	return nil
+}
+
+func (s *memStore) DeleteDonor(t Tenant, donorId string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.donor(t, donorId) == nil {
+		return ErrNotFound
+	}
+	for _, g := range s.grants {
+		if g.DonorId == donorId {
+			return FieldErrors{"donor_id": "can not be deleted while it has grants or grant officers"}
+		}
+	}
+	for _, u := range s.users {
+		if u.DonorId == donorId {
+			return FieldErrors{"donor_id": "can not be deleted while it has grants or grant officers"}
+		}
+	}
+	delete(s.donors, donorId)
+	return nil
+}
+
+func (s *memStore) GetGrants(t Tenant, projectId string) ([]Grant, error) {
+	s.RLock()
+	defer s.RUnlock()
+	grants := []Grant{}
+	if s.project(t, projectId) == nil {
+		return grants, nil
+	}
+	for _, g := range s.sortedGrants(projectId) {
+		grants = append(grants, s.grantView(g))
+	}
+	return grants, nil
+}
+
+func (s *memStore) GetGrant(t Tenant, projectId, grantId string) (Grant, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g, ok := s.grants[grantId]
+	if !ok || g.ProjectId != projectId || s.project(t, projectId) == nil {
+		return Grant{}, ErrNotFound
+	}
+	return s.grantView(g), nil
+}
+
+func (s *memStore) AddGrant(t Tenant, userId string, g Grant) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, g.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	return s.insertGrant(t, g)
+}
+
+func (s *memStore) UpdateGrant(t Tenant, projectId, grantId string, g Grant) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.grants[grantId]
+	if !ok || stored.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if s.donor(t, g.DonorId) == nil {
+		return FieldErrors{"donor_id": "must be a donor of the organization"}
+	}
+	g.GrantId, g.ProjectId, g.DonorName = grantId, projectId, ""
+	g.Deadlines = append([]GrantDeadline{}, g.Deadlines...)
+	stored.Grant = g
+	return nil
+}
+
+func (s *memStore) DeleteGrant(t Tenant, projectId, grantId string) error {
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.grants[grantId]
+	if !ok || g.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	delete(s.grants, grantId)
+	return nil
+}
+
+func (s *memStore) GetDonorProjects(t Tenant, donorId string) ([]DonorProject, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.donor(t, donorId) == nil {
+		return nil, ErrNotFound
+	}
+	var owned []*memProject
+	for _, p := range s.projects {
+		if p.organizationId == t.OrganizationId {
+			owned = append(owned, p)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].seq < owned[j].seq })
+
+	donorProjects := []DonorProject{}
+	for _, mp := range owned {
+		var grants []Grant
+		for _, g := range s.sortedGrants(mp.ProjectId) {
+			if g.DonorId == donorId {
+				grants = append(grants, s.grantView(g))
+			}
+		}
+		if grants != nil {
+			donorProjects = append(donorProjects, DonorProject{s.projectView(mp), grants})
+		}
+	}
+	return donorProjects, nil
+}
+
+func (s *memStore) GetGrantOfficers(t Tenant, donorId string) ([]User, error) {
+	s.RLock()
+	defer s.RUnlock()
+	officers := []User{}
+	for _, u := range s.users {
+		if u.OrganizationId == t.OrganizationId && u.DonorId == donorId && donorId != "" {
+			officers = append(officers, u.User)
+		}
+	}
+	sort.Slice(officers, func(i, j int) bool { return officers[i].FullName < officers[j].FullName })
+	return officers, nil
+}
+
+func (s *memStore) SetGrantOfficer(t Tenant, userId, donorId string) error {
+	s.Lock()
+	defer s.Unlock()
+	u, ok := s.users[userId]
+	if !ok || u.OrganizationId != t.OrganizationId {
+		return ErrNotFound
+	}
+	if u.IsAdmin {
+		return FieldErrors{"user_id": "an admin can not be a grant officer"}
+	}
+	if donorId != "" && s.donor(t, donorId) == nil {
+		return FieldErrors{"donor_id": "must be a donor of the organization"}
+	}
+	u.DonorId = donorId
+	return nil
+}
+
+// checkActivity checks the references of an activity, like the pgStore
+// function of the same name.
+func (s *memStore) checkActivity(t Tenant, projectId string, a Activity) error {
+	err := s.checkReferences(t, projectId,
+		reference{"strategyId", "strategy_id", a.StrategyId},
+		reference{"progressMarkerId", "progress_marker_id", a.ProgressMarkerId})
+	if err != nil || a.OwnerId == "" {
+		return err
+	}
+	if !s.isMember(t, a.OwnerId) {
+		return FieldErrors{"owner_id": "must be a member of the organization"}
+	}
+	return nil
+}
+
+// isMember reports whether a user is a member of the tenant's organization
+// and not a grant officer.
+func (s *memStore) isMember(t Tenant, userId string) bool {
+	u, ok := s.users[userId]
+	return ok && u.OrganizationId == t.OrganizationId && u.DonorId == ""
+}
+
+// activityView fills in the name of the owner of an activity.
+func (s *memStore) activityView(a *memActivity) Activity {
+	view := a.Activity
+	view.OwnerName = ""
+	if u, ok := s.users[a.OwnerId]; ok {
+		view.OwnerName = u.FullName
+	}
+	return view
+}
+
+// findActivities returns the matching activities of the tenant's projects by
+// start date.
+func (s *memStore) findActivities(t Tenant, match func(a *memActivity) bool) []Activity {
+	var sorted []*memActivity
+	for _, a := range s.activities {
+		if s.project(t, a.ProjectId) != nil && match(a) {
+			sorted = append(sorted, a)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].StartsOn != sorted[j].StartsOn {
+			return sorted[i].StartsOn < sorted[j].StartsOn
+		}
+		if sorted[i].EndsOn != sorted[j].EndsOn {
+			return sorted[i].EndsOn < sorted[j].EndsOn
+		}
+		return sorted[i].seq < sorted[j].seq
+	})
+	activities := []Activity{}
+	for _, a := range sorted {
+		activities = append(activities, s.activityView(a))
+	}
+	return activities
+}
+
+func (s *memStore) GetActivities(t Tenant, projectId string) ([]Activity, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findActivities(t, func(a *memActivity) bool { return a.ProjectId == projectId }), nil
+}
+
+func (s *memStore) GetActivity(t Tenant, projectId, activityId string) (Activity, error) {
+	s.RLock()
+	defer s.RUnlock()
+	a, ok := s.activities[activityId]
+	if !ok || a.ProjectId != projectId || s.project(t, projectId) == nil {
+		return Activity{}, ErrNotFound
+	}
+	return s.activityView(a), nil
+}
+
+func (s *memStore) AddActivity(t Tenant, userId string, a Activity) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, a.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	if err := s.checkActivity(t, a.ProjectId, a); err != nil {
+		return "", err
+	}
+	a.ActivityId = uuid.NewV4().String()
+	a.TsCreated = time.Now()
+	s.activities[a.ActivityId] = &memActivity{a, userId, s.next()}
+	return a.ActivityId, nil
+}
+
+func (s *memStore) UpdateActivity(t Tenant, projectId, activityId string, a Activity) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.activities[activityId]
+	if !ok || stored.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if err := s.checkActivity(t, projectId, a); err != nil {
+		return err
+	}
+	a.ActivityId, a.ProjectId, a.TsCreated = activityId, projectId, stored.TsCreated
+	stored.Activity = a
+	return nil
+}
+
+func (s *memStore) DeleteActivity(t Tenant, projectId, activityId string) error {
+	s.Lock()
+	defer s.Unlock()
+	a, ok := s.activities[activityId]
+	if !ok || a.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	delete(s.activities, activityId)
+	return nil
+}
+
+func (s *memStore) GetCalendarKey(t Tenant, projectId string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	key, ok := s.calendarKeys[projectId]
+	if !ok || s.project(t, projectId) == nil {
+		return "", ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *memStore) SetCalendarKey(t Tenant, userId, projectId, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if key == "" {
+		delete(s.calendarKeys, projectId)
+		return nil
+	}
+	s.calendarKeys[projectId] = key
+	return nil
+}
+
+func (s *memStore) GetDueActivities(t Tenant, kind, from, to string) ([]Activity, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findActivities(t, func(a *memActivity) bool {
+		return a.Kind == kind && a.Status != STATUS_DONE && a.Status != STATUS_CANCELLED &&
+			a.StartsOn >= from && a.StartsOn <= to
+	}), nil
+}
+
+// deleteTasks removes the matching tasks with their comments.
+func (s *memStore) deleteTasks(match func(task *memTask) bool) {
+	for id, task := range s.tasks {
+		if !match(task) {
+			continue
+		}
+		for commentId, c := range s.taskComments {
+			if c.TaskId == id {
+				delete(s.taskComments, commentId)
+			}
+		}
+		delete(s.tasks, id)
+	}
+}
+
+// checkTask checks what a task is on and who it is assigned to, like the
+// pgStore function of the same name.
+func (s *memStore) checkTask(t Tenant, projectId string, task Task) error {
+	err := s.checkReferences(t, projectId,
+		reference{"progressMarkerId", "progress_marker_id", task.ProgressMarkerId},
+		reference{"challengeId", "challenge_id", task.ChallengeId},
+		reference{"strategyId", "strategy_id", task.StrategyId})
+	if err != nil || task.AssigneeId == "" {
+		return err
+	}
+	if !s.isMember(t, task.AssigneeId) {
+		return FieldErrors{"assignee_id": "must be a member of the organization"}
+	}
+	return nil
+}
+
+// taskView fills in the project, the assignee's name and the number of
+// comments of a task.
+func (s *memStore) taskView(task *memTask) Task {
+	view := task.Task
+	view.ProjectName = s.projects[task.ProjectId].ProjectName
+	view.AssigneeName = ""
+	if u, ok := s.users[task.AssigneeId]; ok {
+		view.AssigneeName = u.FullName
+	}
+	view.Comments = 0
+	for _, c := range s.taskComments {
+		if c.TaskId == task.TaskId {
+			view.Comments++
+		}
+	}
+	return view
+}
+
+// findTasks returns the matching tasks of the tenant's projects, ordered
+// like the pgStore queries.
+func (s *memStore) findTasks(t Tenant, match func(task *memTask) bool, byDueDate bool) []Task {
+	var found []*memTask
+	for _, task := range s.tasks {
+		if s.project(t, task.ProjectId) != nil && match(task) {
+			found = append(found, task)
+		}
+	}
+	rank := map[string]int{PRIORITY_URGENT: 0, PRIORITY_HIGH: 1, PRIORITY_NORMAL: 2, PRIORITY_LOW: 3}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if byDueDate {
+			if a.DueOn != b.DueOn {
+				return b.DueOn == "" || (a.DueOn != "" && a.DueOn < b.DueOn)
+			}
+			if rank[a.Priority] != rank[b.Priority] {
+				return rank[a.Priority] < rank[b.Priority]
+			}
+		}
+		return a.seq < b.seq
+	})
+	tasks := []Task{}
+	for _, task := range found {
+		tasks = append(tasks, s.taskView(task))
+	}
+	return tasks
+}
+
+func isOpenTask(task *memTask) bool {
+	return task.Status != STATUS_DONE && task.Status != STATUS_CANCELLED
+}
+
+func (s *memStore) GetTasks(t Tenant, projectId string) ([]Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findTasks(t, func(task *memTask) bool { return task.ProjectId == projectId }, false), nil
+}
+
+func (s *memStore) GetTask(t Tenant, projectId, taskId string) (Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+	task, ok := s.tasks[taskId]
+	if !ok || task.ProjectId != projectId || s.project(t, projectId) == nil {
+		return Task{}, ErrNotFound
+	}
+	return s.taskView(task), nil
+}
+
+func (s *memStore) AddTask(t Tenant, userId string, task Task) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, task.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	if err := s.checkTask(t, task.ProjectId, task); err != nil {
+		return "", err
+	}
+	task.TaskId = uuid.NewV4().String()
+	task.CreatedBy = userId
+	task.TsCreated = time.Now()
+	task.TsUpdated = task.TsCreated
+	s.tasks[task.TaskId] = &memTask{task, s.next()}
+	return task.TaskId, nil
+}
+
+func (s *memStore) UpdateTask(t Tenant, projectId, taskId string, task Task) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.tasks[taskId]
+	if !ok || stored.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if err := s.checkTask(t, projectId, task); err != nil {
+		return err
+	}
+	task.TaskId, task.ProjectId, task.Status = taskId, projectId, stored.Status
+	task.CreatedBy, task.TsCreated, task.TsUpdated = stored.CreatedBy, stored.TsCreated, time.Now()
+	stored.Task = task
+	return nil
+}
+
+func (s *memStore) SetTaskStatus(t Tenant, projectId, taskId, from, to string) error {
+	s.Lock()
+	defer s.Unlock()
+	task, ok := s.tasks[taskId]
+	if !ok || task.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if task.Status != from {
+		return FieldErrors{"status": "the task is " + task.Status + " by now"}
+	}
+	task.Status, task.TsUpdated = to, time.Now()
+	return nil
+}
+
+func (s *memStore) DeleteTask(t Tenant, projectId, taskId string) error {
+	s.Lock()
+	defer s.Unlock()
+	task, ok := s.tasks[taskId]
+	if !ok || task.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	s.deleteTasks(func(task *memTask) bool { return task.TaskId == taskId })
+	return nil
+}
+
+func (s *memStore) GetAssignedTasks(t Tenant, userId string) ([]Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findTasks(t, func(task *memTask) bool {
+		return task.AssigneeId == userId && isOpenTask(task)
+	}, true), nil
+}
+
+func (s *memStore) GetOverdueTasks(t Tenant, today string) ([]Task, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findTasks(t, func(task *memTask) bool {
+		return task.DueOn != "" && task.DueOn < today && isOpenTask(task)
+	}, true), nil
+}
+
+func (s *memStore) GetTaskComments(t Tenant, projectId, taskId string) ([]TaskComment, error) {
+	s.RLock()
+	defer s.RUnlock()
+	comments := []TaskComment{}
+	task, ok := s.tasks[taskId]
+	if !ok || task.ProjectId != projectId || s.project(t, projectId) == nil {
+		return comments, nil
+	}
+	var sorted []*memTaskComment
+	for _, c := range s.taskComments {
+		if c.TaskId == taskId {
+			sorted = append(sorted, c)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+	for _, c := range sorted {
+		comment := c.TaskComment
+		if u, ok := s.users[c.AuthorId]; ok {
+			comment.AuthorName = u.FullName
+		}
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+func (s *memStore) AddTaskComment(t Tenant, userId, projectId, taskId, body string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	task, ok := s.tasks[taskId]
+	if !ok || task.ProjectId != projectId || s.project(t, projectId) == nil {
+		return "", ErrNotFound
+	}
+	c := TaskComment{
+		CommentId: uuid.NewV4().String(),
+		TaskId:    taskId,
+		AuthorId:  userId,
+		Body:      body,
+		TsCreated: time.Now(),
+	}
+	s.taskComments[c.CommentId] = &memTaskComment{c, s.next()}
+	return c.CommentId, nil
+}
+
+// deleteComments removes the matching comments with their replies.
+func (s *memStore) deleteComments(match func(c *memComment) bool) {
+	for id, c := range s.comments {
+		if match(c) {
+			delete(s.comments, id)
+		}
+	}
+	for id, c := range s.comments {
+		if _, ok := s.comments[c.ParentId]; c.ParentId != "" && !ok {
+			delete(s.comments, id)
+		}
+	}
+}
+
+// countComments counts the comments on an entity, replies included.
+func (s *memStore) countComments(entityType, entityId string) int {
+	count := 0
+	for _, c := range s.comments {
+		if c.EntityType == entityType && c.EntityId == entityId {
+			count++
+		}
+	}
+	return count
+}
+
+// checkComment checks what a comment is on, the comment it replies to and
+// the users it mentions, like the pgStore function of the same name.
+func (s *memStore) checkComment(t Tenant, c Comment) error {
+	if routeVar, ok := commentEntities[c.EntityType]; ok {
+		if err := s.checkReferences(t, c.ProjectId, reference{routeVar, "entity_id", c.EntityId}); err != nil {
+			return err
+		}
+	} else if c.EntityId != c.ProjectId {
+		return FieldErrors{"entity_id": "must be the project"}
+	}
+	if c.ParentId != "" {
+		parent, ok := s.comments[c.ParentId]
+		if !ok || parent.ProjectId != c.ProjectId || parent.ParentId != "" ||
+			parent.EntityType != c.EntityType || parent.EntityId != c.EntityId {
+			return FieldErrors{"parent_id": "must be a comment that starts a thread on the same entity"}
+		}
+	}
+	return s.checkMentions(t, c.Mentions)
+}
+
+func (s *memStore) checkMentions(t Tenant, mentions []string) error {
+	for _, userId := range mentions {
+		if !s.isMember(t, userId) {
+			return FieldErrors{"mentions": "must be a member of the organization"}
+		}
+	}
+	return nil
+}
+
+// commentView fills in the author of a comment and whether it is resolved.
+func (s *memStore) commentView(c *memComment) Comment {
+	view := c.Comment
+	view.AuthorName = ""
+	if u, ok := s.users[c.AuthorId]; ok {
+		view.AuthorName = u.FullName
+	}
+	view.Resolved = c.TsResolved != nil
+	return view
+}
+
+// sortedMentions returns the mentioned users in the order pgStore returns
+// them.
+func sortedMentions(mentions []string) pq.StringArray {
+	sorted := pq.StringArray{}
+	sorted = append(sorted, mentions...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (s *memStore) GetComments(t Tenant, projectId, entityType, entityId string) ([]Comment, error) {
+	s.RLock()
+	defer s.RUnlock()
+	comments := []Comment{}
+	if s.project(t, projectId) == nil {
+		return comments, nil
+	}
+	var sorted []*memComment
+	for _, c := range s.comments {
+		if c.ProjectId == projectId && (entityType == "" || c.EntityType == entityType && c.EntityId == entityId) {
+			sorted = append(sorted, c)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+	for _, c := range sorted {
+		comments = append(comments, s.commentView(c))
+	}
+	return comments, nil
+}
+
+func (s *memStore) GetComment(t Tenant, projectId, commentId string) (Comment, error) {
+	s.RLock()
+	defer s.RUnlock()
+	c, ok := s.comments[commentId]
+	if !ok || c.ProjectId != projectId || s.project(t, projectId) == nil {
+		return Comment{}, ErrNotFound
+	}
+	return s.commentView(c), nil
+}
+
+func (s *memStore) AddComment(t Tenant, userId string, c Comment) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.project(t, c.ProjectId) == nil {
+		return "", ErrNotFound
+	}
+	if err := s.checkComment(t, c); err != nil {
+		return "", err
+	}
+	stored := Comment{
+		CommentId:  uuid.NewV4().String(),
+		ProjectId:  c.ProjectId,
+		EntityType: c.EntityType,
+		EntityId:   c.EntityId,
+		ParentId:   c.ParentId,
+		AuthorId:   userId,
+		Body:       c.Body,
+		Mentions:   sortedMentions(c.Mentions),
+		TsCreated:  time.Now(),
+	}
+	s.comments[stored.CommentId] = &memComment{stored, s.next()}
+	return stored.CommentId, nil
+}
+
+func (s *memStore) UpdateComment(t Tenant, projectId, commentId, body string, mentions []string) error {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.comments[commentId]
+	if !ok || c.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if err := s.checkMentions(t, mentions); err != nil {
+		return err
+	}
+	now := time.Now()
+	c.Body, c.Mentions, c.TsEdited = body, sortedMentions(mentions), &now
+	return nil
+}
+
+func (s *memStore) DeleteComment(t Tenant, projectId, commentId string) error {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.comments[commentId]
+	if !ok || c.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	s.deleteComments(func(c *memComment) bool { return c.CommentId == commentId })
+	return nil
+}
+
+func (s *memStore) SetCommentResolved(t Tenant, projectId, commentId, userId string) error {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.comments[commentId]
+	if !ok || c.ProjectId != projectId || s.project(t, projectId) == nil {
+		return ErrNotFound
+	}
+	if userId == "" {
+		c.ResolvedBy, c.TsResolved = "", nil
+		return nil
+	}
+	now := time.Now()
+	c.ResolvedBy, c.TsResolved = userId, &now
+	return nil
+}
+
+// notificationView fills in the name of the user who caused a notification.
+func (s *memStore) notificationView(n *memNotification) Notification {
+	view := n.Notification
+	view.ActorName = ""
+	if u, ok := s.users[n.ActorId]; ok {
+		view.ActorName = u.FullName
+	}
+	view.Read = n.TsRead != nil
+	return view
+}
+
+func (s *memStore) AddNotification(t Tenant, n Notification) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	n.NotificationId = uuid.NewV4().String()
+	n.TsCreated = time.Now()
+	n.Read, n.TsRead = false, nil
+	s.inbox[n.NotificationId] = &memNotification{n, t.OrganizationId, false, s.next()}
+	return n.NotificationId, nil
+}
+
+func (s *memStore) GetNotifications(t Tenant, userId string, unreadOnly bool, limit int) ([]Notification, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var sorted []*memNotification
+	for _, n := range s.inbox {
+		if n.organizationId == t.OrganizationId && n.UserId == userId && n.InApp && (!unreadOnly || n.TsRead == nil) {
+			sorted = append(sorted, n)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq > sorted[j].seq })
+	notifications := []Notification{}
+	for _, n := range sorted {
+		if len(notifications) == limit {
+			break
+		}
+		notifications = append(notifications, s.notificationView(n))
+	}
+	return notifications, nil
+}
+
+func (s *memStore) CountUnreadNotifications(t Tenant, userId string) (int, error) {
+	s.RLock()
+	defer s.RUnlock()
+	count := 0
+	for _, n := range s.inbox {
+		if n.organizationId == t.OrganizationId && n.UserId == userId && n.InApp && n.TsRead == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memStore) MarkNotificationsRead(t Tenant, userId, notificationId string) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if notificationId == "" {
+		for _, n := range s.inbox {
+			if n.organizationId == t.OrganizationId && n.UserId == userId && n.InApp && n.TsRead == nil {
+				n.TsRead = &now
+			}
+		}
+		return nil
+	}
+	n, ok := s.inbox[notificationId]
+	if !ok || n.organizationId != t.OrganizationId || n.UserId != userId || !n.InApp {
+		return ErrNotFound
+	}
+	if n.TsRead == nil {
+		n.TsRead = &now
+	}
+	return nil
+}
+
+func (s *memStore) GetNotificationPreferences(t Tenant, userId string) ([]NotificationPreference, error) {
+	s.RLock()
+	defer s.RUnlock()
+	preferences := []NotificationPreference{}
+	if u, ok := s.users[userId]; !ok || u.OrganizationId != t.OrganizationId {
+		return preferences, nil
+	}
+	for key, p := range s.preferences {
+		if key[0] == userId {
+			preferences = append(preferences, p)
+		}
+	}
+	sort.Slice(preferences, func(i, j int) bool { return preferences[i].Kind < preferences[j].Kind })
+	return preferences, nil
+}
+
+func (s *memStore) SetNotificationPreference(t Tenant, userId string, p NotificationPreference) error {
+	s.Lock()
+	defer s.Unlock()
+	if u, ok := s.users[userId]; !ok || u.OrganizationId != t.OrganizationId {
+		return ErrNotFound
+	}
+	s.preferences[[2]string{userId, p.Kind}] = p
+	return nil
+}
+
+func (s *memStore) GetUnmailedNotifications(t Tenant) ([]Notification, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var sorted []*memNotification
+	for _, n := range s.inbox {
+		if n.organizationId == t.OrganizationId && n.Email != EMAIL_NEVER && !n.mailed {
+			sorted = append(sorted, n)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].UserId != sorted[j].UserId {
+			return sorted[i].UserId < sorted[j].UserId
+		}
+		return sorted[i].seq < sorted[j].seq
+	})
+	notifications := []Notification{}
+	for _, n := range sorted {
+		notifications = append(notifications, s.notificationView(n))
+	}
+	return notifications, nil
+}
+
+func (s *memStore) SetNotificationsMailed(t Tenant, notificationIds []string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, id := range notificationIds {
+		if n, ok := s.inbox[id]; ok && n.organizationId == t.OrganizationId {
+			n.mailed = true
+		}
+	}
+	return nil
+}
+
+func (s *memStore) GetProjectTeam(t Tenant, projectId string) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	team := []string{}
+	if s.project(t, projectId) == nil {
+		return team, nil
+	}
+	seen := make(map[string]bool)
+	add := func(userId string) {
+		if userId != "" && !seen[userId] {
+			seen[userId] = true
+			team = append(team, userId)
+		}
+	}
+	for _, a := range s.activities {
+		if a.ProjectId == projectId {
+			add(a.OwnerId)
+		}
+	}
+	for _, task := range s.tasks {
+		if task.ProjectId == projectId && isOpenTask(task) {
+			add(task.AssigneeId)
+		}
+	}
+	sort.Strings(team)
+	return team, nil
+}
+
+func (s *memStore) MarkReminderSent(t Tenant, key string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	k := [2]string{t.OrganizationId, key}
+	if _, ok := s.reminders[k]; ok {
+		return false, nil
+	}
+	s.reminders[k] = time.Now()
+	return true, nil
+}
+
+func (s *memStore) PurgeNotifications(t Tenant, before time.Time) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	purged := 0
+	for id, n := range s.inbox {
+		if n.organizationId == t.OrganizationId && n.TsCreated.Before(before) &&
+			(n.TsRead != nil || !n.InApp) && (n.mailed || n.Email == EMAIL_NEVER) {
+			delete(s.inbox, id)
+			purged++
+		}
+	}
+	for k, sent := range s.reminders {
+		if k[0] == t.OrganizationId && sent.Before(before) {
+			delete(s.reminders, k)
+		}
+	}
+	return purged, nil
+}
+
+func (s *memStore) findWebhooks(t Tenant, match func(wh *memWebhook) bool) []Webhook {
+	var sorted []*memWebhook
+	for _, wh := range s.webhooks {
+		if wh.organizationId == t.OrganizationId && match(wh) {
+			sorted = append(sorted, wh)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].TsCreated.Before(sorted[j].TsCreated) })
+	webhooks := []Webhook{}
+	for _, wh := range sorted {
+		view := wh.Webhook
+		view.Events = append(pq.StringArray{}, wh.Events...)
+		webhooks = append(webhooks, view)
+	}
+	return webhooks
+}
+
+func (s *memStore) GetWebhooks(t Tenant) ([]Webhook, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findWebhooks(t, func(*memWebhook) bool { return true }), nil
+}
+
+func (s *memStore) GetWebhook(t Tenant, webhookId string) (Webhook, error) {
+	s.RLock()
+	defer s.RUnlock()
+	webhooks := s.findWebhooks(t, func(wh *memWebhook) bool { return wh.WebhookId == webhookId })
+	if len(webhooks) == 0 {
+		return Webhook{}, ErrNotFound
+	}
+	return webhooks[0], nil
+}
+
+func (s *memStore) AddWebhook(t Tenant, userId string, wh Webhook) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	wh.WebhookId = uuid.NewV4().String()
+	wh.CreatedBy = userId
+	wh.TsCreated = time.Now()
+	wh.Events = append(pq.StringArray{}, wh.Events...)
+	s.webhooks[wh.WebhookId] = &memWebhook{wh, t.OrganizationId}
+	return wh.WebhookId, nil
+}
+
+func (s *memStore) UpdateWebhook(t Tenant, webhookId string, wh Webhook) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.webhooks[webhookId]
+	if !ok || stored.organizationId != t.OrganizationId {
+		return ErrNotFound
+	}
+	stored.Url, stored.Description, stored.Active = wh.Url, wh.Description, wh.Active
+	stored.Events = append(pq.StringArray{}, wh.Events...)
+	return nil
+}
+
+func (s *memStore) DeleteWebhook(t Tenant, webhookId string) error {
+	s.Lock()
+	defer s.Unlock()
+	wh, ok := s.webhooks[webhookId]
+	if !ok || wh.organizationId != t.OrganizationId {
+		return ErrNotFound
+	}
+	delete(s.webhooks, webhookId)
+	for id, d := range s.deliveries {
+		if d.WebhookId == webhookId {
+			delete(s.deliveries, id)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) GetSubscribedWebhooks(t Tenant, event string) ([]Webhook, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.findWebhooks(t, func(wh *memWebhook) bool {
+		if !wh.Active {
+			return false
+		}
+		for _, e := range wh.Events {
+			if e == event {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (s *memStore) AddDelivery(t Tenant, d WebhookDelivery) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	wh, ok := s.webhooks[d.WebhookId]
+	if !ok || wh.organizationId != t.OrganizationId {
+		return "", ErrNotFound
+	}
+	if _, ok := s.deliveries[d.RedeliveryOf]; !ok {
+		d.RedeliveryOf = ""
+	}
+	d.DeliveryId = uuid.NewV4().String()
+	d.Status, d.Attempts = DELIVERY_PENDING, 0
+	d.ResponseStatus, d.ResponseBody, d.LastError, d.TsDelivered = 0, "", "", nil
+	d.TsCreated = time.Now()
+	s.deliveries[d.DeliveryId] = &memDelivery{d, t.OrganizationId, s.next()}
+	return d.DeliveryId, nil
+}
+
+// findDeliveries returns the matching deliveries of the tenant, oldest first.
+func (s *memStore) findDeliveries(t Tenant, match func(d *memDelivery) bool) []WebhookDelivery {
+	var sorted []*memDelivery
+	for _, d := range s.deliveries {
+		if d.organizationId == t.OrganizationId && match(d) {
+			sorted = append(sorted, d)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+	deliveries := []WebhookDelivery{}
+	for _, d := range sorted {
+		deliveries = append(deliveries, d.WebhookDelivery)
+	}
+	return deliveries
+}
+
+func (s *memStore) GetDeliveries(t Tenant, webhookId string, limit int) ([]WebhookDelivery, error) {
+	s.RLock()
+	defer s.RUnlock()
+	all := s.findDeliveries(t, func(d *memDelivery) bool { return d.WebhookId == webhookId })
+	deliveries := []WebhookDelivery{}
+	for i := len(all) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		deliveries = append(deliveries, all[i])
+	}
+	return deliveries, nil
+}
+
+func (s *memStore) GetDelivery(t Tenant, webhookId, deliveryId string) (WebhookDelivery, error) {
+	s.RLock()
+	defer s.RUnlock()
+	d, ok := s.deliveries[deliveryId]
+	if !ok || d.organizationId != t.OrganizationId || d.WebhookId != webhookId {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	return d.WebhookDelivery, nil
+}
+
+func (s *memStore) GetDueDeliveries(t Tenant, now time.Time) ([]WebhookDelivery, error) {
+	s.RLock()
+	defer s.RUnlock()
+	deliveries := s.findDeliveries(t, func(d *memDelivery) bool {
+		wh := s.webhooks[d.WebhookId]
+		return d.Status == DELIVERY_PENDING && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) && wh != nil && wh.Active
+	})
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].NextAttemptAt.Before(*deliveries[j].NextAttemptAt) })
+	return deliveries, nil
+}
+
+func (s *memStore) ClaimDelivery(t Tenant, deliveryId string, now, until time.Time) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	d, ok := s.deliveries[deliveryId]
+	if !ok || d.organizationId != t.OrganizationId || d.Status != DELIVERY_PENDING ||
+		d.NextAttemptAt == nil || d.NextAttemptAt.After(now) {
+		return false, nil
+	}
+	d.NextAttemptAt = &until
+	return true, nil
+}
+
+func (s *memStore) SetDeliveryResult(t Tenant, result WebhookDelivery) error {
+	s.Lock()
+	defer s.Unlock()
+	d, ok := s.deliveries[result.DeliveryId]
+	if !ok || d.organizationId != t.OrganizationId {
+		return ErrNotFound
+	}
+	d.Status, d.Attempts, d.NextAttemptAt = result.Status, result.Attempts, result.NextAttemptAt
+	d.ResponseStatus, d.ResponseBody, d.LastError = result.ResponseStatus, result.ResponseBody, result.LastError
+	d.TsDelivered = result.TsDelivered
+	return nil
+}
+
+// Search matches the words of the query against the beginnings of the words
+// of each entity, ignoring case. An entity matches when all the words are
+// found and none of those excluded with -, a word in the title ranks twice as
+// high as one in the text under it. Phrases are taken as their words.
+func (s *memStore) Search(t Tenant, q SearchQuery) ([]SearchResult, int, error) {
+	s.RLock()
+	defer s.RUnlock()
+	terms, excluded := searchTerms(q.Query)
+	wanted := make(map[string]bool)
+	for _, kind := range q.Types {
+		wanted[kind] = true
+	}
+	inScope := make(map[string]*memProject)
+	for _, p := range s.projects {
+		if p.organizationId != t.OrganizationId {
+			continue
+		}
+		if q.ProjectIds != nil {
+			found := false
+			for _, projectId := range q.ProjectIds {
+				found = found || projectId == p.ProjectId
+			}
+			if !found {
+				continue
+			}
+		}
+		inScope[p.ProjectId] = p
+	}
+
+	var hits []SearchResult
+	add := func(kind, id, projectId, partnerId, markerId, title, body string) {
+		p, ok := inScope[projectId]
+		if !ok || (len(wanted) > 0 && !wanted[kind]) || len(terms) == 0 {
+			return
+		}
+		for _, term := range excluded {
+			if matchesTerm(title, term) || matchesTerm(body, term) {
+				return
+			}
+		}
+		inTitle, inBody := 0, 0
+		for _, term := range terms {
+			switch {
+			case matchesTerm(title, term):
+				inTitle++
+			case matchesTerm(body, term):
+				inBody++
+			default:
+				return
+			}
+		}
+		hits = append(hits, SearchResult{
+			Type:              kind,
+			Id:                id,
+			ProjectId:         projectId,
+			ProjectName:       p.ProjectName,
+			BoundaryPartnerId: partnerId,
+			ProgressMarkerId:  markerId,
+			Title:             highlightTerms(title, terms),
+			Snippet:           highlightTerms(body, terms),
+			Rank:              float64(2*inTitle+inBody) / float64(2*len(terms)),
+		})
+	}
+	for _, p := range s.projects {
+		add(SEARCH_PROJECT, p.ProjectId, p.ProjectId, "", "", p.ProjectName, p.Description)
+	}
+	for _, bp := range s.partners {
+		add(SEARCH_PARTNER, bp.BoundaryPartnerId, bp.ProjectId, bp.BoundaryPartnerId, "", bp.PartnerName, bp.OutcomeStatement)
+	}
+	for _, pm := range s.markers {
+		if bp, ok := s.partners[pm.BoundaryPartnerId]; ok {
+			add(SEARCH_MARKER, pm.ProgressMarkerId, bp.ProjectId, bp.BoundaryPartnerId, pm.ProgressMarkerId, pm.Title, "")
+		}
+	}
+	markerOf := func(markerId string) (*memMarker, *memPartner) {
+		pm, ok := s.markers[markerId]
+		if !ok {
+			return nil, nil
+		}
+		bp, ok := s.partners[pm.BoundaryPartnerId]
+		if !ok {
+			return nil, nil
+		}
+		return pm, bp
+	}
+	for _, c := range s.challenges {
+		if pm, bp := markerOf(c.ProgressMarkerId); pm != nil {
+			add(SEARCH_CHALLENGE, c.ChallengeId, bp.ProjectId, bp.BoundaryPartnerId, pm.ProgressMarkerId, c.ChallengeName, "")
+		}
+	}
+	for _, st := range s.strategies {
+		if pm, bp := markerOf(st.ProgressMarkerId); pm != nil {
+			add(SEARCH_STRATEGY, st.StrategyId, bp.ProjectId, bp.BoundaryPartnerId, pm.ProgressMarkerId, st.StrategyName, "")
+		}
+	}
+	for _, j := range s.journals {
+		narrative := strings.TrimSpace(strings.Join([]string{j.ContributingFactors, j.SourcesOfEvidence, j.UnanticipatedChange,
+			j.Lessons}, " "))
+		add(SEARCH_JOURNAL, j.JournalId, j.ProjectId, j.BoundaryPartnerId, "", j.DescriptionOfChange, narrative)
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		if hits[i].Title != hits[j].Title {
+			return hits[i].Title < hits[j].Title
+		}
+		return hits[i].Id < hits[j].Id
+	})
+	results := []SearchResult{}
+	for i := q.Offset; i < len(hits) && i < q.Offset+q.Limit; i++ {
+		results = append(results, hits[i])
+	}
+	return results, len(hits), nil
+}
+
+// searchTerms returns the lower case words of a query and those excluded
+// with -, leaving out the other operators of web search syntax.
+func searchTerms(query string) (terms, excluded []string) {
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		switch {
+		case field == "or":
+		case strings.HasPrefix(field, "-"):
+			excluded = append(excluded, strings.FieldsFunc(field, notWordRune)...)
+		default:
+			terms = append(terms, strings.FieldsFunc(field, notWordRune)...)
+		}
+	}
+	return terms, excluded
+}
+
+func notWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// matchesTerm tells if a word of text begins with term, ignoring case.
+func matchesTerm(text, term string) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), notWordRune) {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightTerms encloses the words of text that begin with one of the
+// terms in HIGHLIGHT_START and HIGHLIGHT_STOP.
+func highlightTerms(text string, terms []string) string {
+	var b strings.Builder
+	word := []rune{}
+	flush := func() {
+		lower := strings.ToLower(string(word))
+		matched := false
+		for _, term := range terms {
+			matched = matched || (lower != "" && strings.HasPrefix(lower, term))
+		}
+		if matched {
+			b.WriteString(HIGHLIGHT_START + string(word) + HIGHLIGHT_STOP)
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range text {
+		if notWordRune(r) {
+			flush()
+			b.WriteRune(r)
+		} else {
+			word = append(word, r)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+func (s *memStore) ScheduleJob(name string, runAt time.Time, maxAttempts int) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, j := range s.jobs {
+		if j.Name == name && (j.Status == JOB_PENDING || j.Status == JOB_RUNNING) {
+			return nil
+		}
+	}
+	job := Job{JobId: uuid.NewV4().String(), Name: name, RunAt: runAt, Status: JOB_PENDING, MaxAttempts: maxAttempts}
+	s.jobs[job.JobId] = &memJob{job, s.next()}
+	return nil
+}
+
+func (s *memStore) GetDueJobs(now time.Time) ([]Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var sorted []*memJob
+	for _, j := range s.jobs {
+		if j.Status == JOB_PENDING && !j.RunAt.After(now) {
+			sorted = append(sorted, j)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].RunAt.Equal(sorted[j].RunAt) {
+			return sorted[i].RunAt.Before(sorted[j].RunAt)
+		}
+		return sorted[i].seq < sorted[j].seq
+	})
+	jobs := []Job{}
+	for _, j := range sorted {
+		jobs = append(jobs, j.Job)
+	}
+	return jobs, nil
+}
+
+func (s *memStore) LockJob(name string) (func(), bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.jobLocks[name] {
+		return nil, false, nil
+	}
+	s.jobLocks[name] = true
+	unlock := func() {
+		s.Lock()
+		defer s.Unlock()
+		delete(s.jobLocks, name)
+	}
+	return unlock, true, nil
+}
+
+func (s *memStore) StartJob(jobId string) (Job, error) {
+	s.Lock()
+	defer s.Unlock()
+	j, ok := s.jobs[jobId]
+	if !ok || j.Status != JOB_PENDING {
+		return Job{}, ErrNotFound
+	}
+	now := time.Now()
+	j.Status, j.Attempts, j.TsStarted, j.TsFinished = JOB_RUNNING, j.Attempts+1, &now, nil
+	return j.Job, nil
+}
+
+func (s *memStore) FinishJob(jobId string, runErr error, retryAt time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	j, ok := s.jobs[jobId]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	j.TsFinished = &now
+	switch {
+	case runErr == nil:
+		j.Status, j.LastError = JOB_DONE, ""
+	case j.Attempts < j.MaxAttempts:
+		j.Status, j.LastError, j.RunAt = JOB_PENDING, runErr.Error(), retryAt
+	default:
+		j.Status, j.LastError = JOB_FAILED, runErr.Error()
+	}
+	return nil
+}
+
+func (s *memStore) RequeueStaleJobs(before time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, j := range s.jobs {
+		if j.Status != JOB_RUNNING || !j.TsStarted.Before(before) {
+			continue
+		}
+		j.Status, j.LastError, j.TsFinished = JOB_FAILED, "abandoned while running", &now
+		if j.Attempts < j.MaxAttempts {
+			j.Status = JOB_PENDING
+		}
+	}
+	return nil
+}
+
+func (s *memStore) PurgeJobs(before time.Time) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	purged := 0
+	for id, j := range s.jobs {
+		if (j.Status == JOB_DONE || j.Status == JOB_FAILED) && j.TsFinished.Before(before) {
+			delete(s.jobs, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *memStore) GetProjectStats(t Tenant, projectId string) (ProjectStats, error) {
+	s.RLock()
+	defer s.RUnlock()
+	mp := s.project(t, projectId)
+	if mp == nil {
+		return ProjectStats{}, ErrNotFound
+	}
+	st := s.projectStats(mp)
+	st.Partners = []PartnerStats{}
+	for _, bp := range s.sortedPartners(projectId) {
+		ps := PartnerStats{BoundaryPartnerId: bp.BoundaryPartnerId, PartnerName: bp.PartnerName, Trend: []RatingPeriod{}}
+		for _, pm := range s.sortedMarkers(bp.BoundaryPartnerId) {
+			ps.ProgressMarkers++
+			ps.MarkersByLevel.add(pm.Type)
+			ps.Challenges += s.countChallenges(pm.ProgressMarkerId)
+			ps.Strategies += s.countStrategies(pm.ProgressMarkerId)
+		}
+		journals := s.submittedJournals(projectId, bp.BoundaryPartnerId)
+		for i := len(journals) - 1; i >= 0; i-- {
+			period := RatingPeriod{MonitoringDate: journals[i].MonitoringDate}
+			for _, r := range journals[i].Ratings {
+				period.Ratings.add(r.Rating)
+			}
+			ps.Trend = append(ps.Trend, period)
+		}
+		st.Partners = append(st.Partners, ps)
+	}
+	return st, nil
+}
+
+func (s *memStore) GetOrganizationStats(t Tenant) ([]ProjectStats, error) {
+	s.RLock()
+	defer s.RUnlock()
+	var owned []*memProject
+	for _, p := range s.projects {
+		if p.organizationId == t.OrganizationId {
+			owned = append(owned, p)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].seq < owned[j].seq })
+	projects := []ProjectStats{}
+	for _, mp := range owned {
+		projects = append(projects, s.projectStats(mp))
+	}
+	return projects, nil
+}
+
+func (s *memStore) projectStats(mp *memProject) ProjectStats {
+	st := ProjectStats{
+		ProjectId:    mp.ProjectId,
+		ProjectName:  mp.ProjectName,
+		Currency:     mp.Currency,
+		Budget:       s.projectBudget(mp),
+		TimelineFrom: memRFC3339(mp.timelineFrom),
+		TimelineTo:   memRFC3339(mp.timelineTo),
+		Resources:    len(s.sortedResources(mp.ProjectId, true)),
+	}
+	for _, bp := range s.sortedPartners(mp.ProjectId) {
+		st.BoundaryPartners++
+		for _, pm := range s.sortedMarkers(bp.BoundaryPartnerId) {
+			st.ProgressMarkers++
+			st.MarkersByLevel.add(pm.Type)
+			st.Challenges += s.countChallenges(pm.ProgressMarkerId)
+			st.Strategies += s.countStrategies(pm.ProgressMarkerId)
+		}
+		if journals := s.submittedJournals(mp.ProjectId, bp.BoundaryPartnerId); len(journals) > 0 {
+			for _, r := range journals[0].Ratings {
+				st.LatestRatings.add(r.Rating)
+			}
+		}
+	}
+	for _, e := range s.sortedExpenditures(mp.ProjectId) {
+		st.Spent += e.ProjectAmount
+	}
+	return st
+}
+
+// submittedJournals returns the submitted journals of a boundary partner,
+// newest monitoring date first.
+func (s *memStore) submittedJournals(projectId, partnerId string) []*memJournal {
+	var journals []*memJournal
+	for _, j := range s.sortedJournals(projectId, partnerId) {
+		if j.Status == JOURNAL_SUBMITTED {
+			journals = append(journals, j)
+		}
+	}
+	return journals
+}
+
+func (s *memStore) countChallenges(markerId string) int {
+	n := 0
+	for _, c := range s.challenges {
+		if c.ProgressMarkerId == markerId {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *memStore) countStrategies(markerId string) int {
+	n := 0
+	for _, strat := range s.strategies {
+		if strat.ProgressMarkerId == markerId {
+			n++
+		}
+	}
+	return n
+}
+
+// memString converts a column value to the string pgStore would return for it.
+func memString(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+// memTimestamp formats a timeline value like the to_char format used by pgStore.
+func memTimestamp(value interface{}) string {
+	t, ok := value.(time.Time)
+	if !ok {
+		return ""
+	}
+	return t.Format("2006-01-02 03:04:05 MST")
+}
+
+// memRFC3339 formats a timeline value like the export query of pgStore.
+func memRFC3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
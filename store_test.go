@@ -0,0 +1,831 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the organizations, admins and members every test store starts with
+const (
+	ORG_A    = "aaaaaaaa-0000-4000-8000-000000000001"
+	ORG_B    = "bbbbbbbb-0000-4000-8000-000000000002"
+	ADMIN_A  = "aaaaaaaa-0000-4000-8000-0000000000a1"
+	ADMIN_B  = "bbbbbbbb-0000-4000-8000-0000000000b1"
+	MEMBER_A = "aaaaaaaa-0000-4000-8000-0000000000a2"
+)
+
+// password of the test users
+const TEST_PASSWORD = "secret"
+
+var testUsers = []struct {
+	user  User
+	email string
+}{
+	{User{UserId: ADMIN_A, OrganizationId: ORG_A, FullName: "Ada", IsAdmin: true}, "ada@a.org"},
+	{User{UserId: ADMIN_B, OrganizationId: ORG_B, FullName: "Bo", IsAdmin: true}, "bo@b.org"},
+	{User{UserId: MEMBER_A, OrganizationId: ORG_A, FullName: "Ann"}, "ann@a.org"},
+}
+
+// newTestMemoryStore returns an in-memory store with the test users.
+func newTestMemoryStore(t *testing.T) Store {
+	hash, err := bcrypt.GenerateFromPassword([]byte(TEST_PASSWORD), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	mem := store.(*memStore)
+	mem.AddOrganization(Organization{OrganizationId: ORG_A, OrganizationName: "Org A"})
+	mem.AddOrganization(Organization{OrganizationId: ORG_B, OrganizationName: "Org B"})
+	for _, u := range testUsers {
+		mem.AddUser(u.user, u.email, hash)
+	}
+	return store
+}
+
+// newTestDatabase connects to the database in LUCID_TEST_DSN and empties its
+// public schema, or skips the test if it is not set.
+func newTestDatabase(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv("LUCID_TEST_DSN")
+	if dsn == "" {
+		t.Skip("LUCID_TEST_DSN is not set")
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err = db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestPostgresStore returns a store on the database in LUCID_TEST_DSN
+// with the test organizations and users, or skips the test if it is not
+// set. The public schema of that database is dropped and migrated afresh
+// for every test.
+func newTestPostgresStore(t *testing.T) Store {
+	db := newTestDatabase(t)
+	if _, err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(TEST_PASSWORD), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec("INSERT INTO organizations (organization_id, organization_name) VALUES ($1, 'Org A'), ($2, 'Org B')", ORG_A, ORG_B)
+	for _, u := range testUsers {
+		db.MustExec("INSERT INTO users (user_id, organization_id, email, password, full_name, is_admin, is_active) VALUES ($1, $2, $3, $4, $5, $6, TRUE)",
+			u.user.UserId, u.user.OrganizationId, u.email, string(hash), u.user.FullName, u.user.IsAdmin)
+	}
+	return NewPostgresStore(db)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newTestMemoryStore)
+}
+
+func TestPostgresStore(t *testing.T) {
+	testStore(t, newTestPostgresStore)
+}
+
+// testStore runs the conformance tests every Store implementation has to
+// pass against fresh stores from newStore.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"users", testStoreUsers},
+		{"projects", testStoreProjects},
+		{"markers", testStoreMarkers},
+		{"ownership", testStoreOwnership},
+		{"resources", testStoreResources},
+		{"scans", testStoreScans},
+		{"journals", testStoreJournals},
+		{"organization", testStoreOrganization},
+		{"stats", testStoreStats},
+		{"search", testStoreSearch},
+		{"jobs", testStoreJobs},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStore(t))
+		})
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreUsers(t *testing.T, s Store) {
+	userId, hash, err := s.GetLogin("ADA@a.org")
+	check(t, err)
+	if userId != ADMIN_A || bcrypt.CompareHashAndPassword(hash, []byte(TEST_PASSWORD)) != nil {
+		t.Fatalf("login of %s", userId)
+	}
+	if _, _, err = s.GetLogin("nobody@a.org"); err != ErrNotFound {
+		t.Fatalf("unknown email: %v", err)
+	}
+	user, err := s.GetUser(ADMIN_B)
+	check(t, err)
+	if user.OrganizationId != ORG_B || !user.IsAdmin || user.FullName != "Bo" {
+		t.Fatalf("%+v", user)
+	}
+}
+
+// projectNamed returns the tenant's project with the given ID, or nil.
+func projectNamed(t *testing.T, s Store, tenant Tenant, projectId string) *Project {
+	t.Helper()
+	projects, err := s.GetProjects(tenant)
+	check(t, err)
+	for i := range projects {
+		if projects[i].ProjectId == projectId {
+			return &projects[i]
+		}
+	}
+	return nil
+}
+
+func testStoreProjects(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectId, err := s.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	check(t, s.SetProjectField(a, projectId, "vision", "Heard"))
+
+	p := projectNamed(t, s, a, projectId)
+	if p == nil || p.ProjectName != "Radio" || p.Vision != "Heard" {
+		t.Fatalf("%+v", p)
+	}
+	if err = s.SetProjectField(b, projectId, "vision", "Theirs"); err != ErrNotFound {
+		t.Fatalf("other tenant updated the project: %v", err)
+	}
+	if err = s.DeleteProject(b, projectId); err != ErrNotFound {
+		t.Fatalf("other tenant deleted the project: %v", err)
+	}
+	projects, err := s.GetProjects(b)
+	check(t, err)
+	if len(projects) != 0 {
+		t.Fatal(projects)
+	}
+
+	check(t, s.SetProjectField(a, projectId, "vision", nil))
+	if p = projectNamed(t, s, a, projectId); p.Vision != "" {
+		t.Fatal(p.Vision)
+	}
+	check(t, s.DeleteProject(a, projectId))
+	if p = projectNamed(t, s, a, projectId); p != nil {
+		t.Fatalf("%+v", p)
+	}
+}
+
+// markerIds returns the IDs of a partner's progress markers in their order.
+func markerIds(t *testing.T, s Store, projectId, partnerId string) []string {
+	t.Helper()
+	bp, err := s.GetBoundaryPartner(Tenant{ORG_A}, projectId, partnerId)
+	check(t, err)
+	var ids []string
+	for _, pm := range bp.ProgressMarkers {
+		ids = append(ids, pm.ProgressMarkerId)
+	}
+	return ids
+}
+
+func testStoreMarkers(t *testing.T, s Store) {
+	a := Tenant{ORG_A}
+	projectId, err := s.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	partnerId, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	var ids []string
+	for _, title := range []string{"one", "two", "three"} {
+		id, err := s.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: title, Type: 1})
+		check(t, err)
+		ids = append(ids, id)
+	}
+	if got := markerIds(t, s, projectId, partnerId); !equalStrings(got, ids) {
+		t.Fatal(got)
+	}
+
+	bp, err := s.GetBoundaryPartner(a, projectId, partnerId)
+	check(t, err)
+	last := bp.ProgressMarkers[2]
+	check(t, s.UpdateProgressMarker(a, projectId, ids[2], ProgressMarker{Title: "first", Type: 2, OrderNumber: bp.ProgressMarkers[0].OrderNumber}))
+	if got := markerIds(t, s, projectId, partnerId); !equalStrings(got, []string{ids[2], ids[0], ids[1]}) {
+		t.Fatal(got)
+	}
+	check(t, s.DeleteProgressMarker(a, projectId, ids[0]))
+	bp, err = s.GetBoundaryPartner(a, projectId, partnerId)
+	check(t, err)
+	if len(bp.ProgressMarkers) != 2 || bp.ProgressMarkers[0].Title != "first" || bp.ProgressMarkers[0].Type != 2 ||
+		bp.ProgressMarkers[1].OrderNumber != bp.ProgressMarkers[0].OrderNumber+1 || last.OrderNumber != bp.ProgressMarkers[0].OrderNumber+2 {
+		t.Fatalf("%+v %+v", bp.ProgressMarkers[0], bp.ProgressMarkers[1])
+	}
+
+	challengeId, err := s.AddChallenge(a, projectId, ids[1], Challenge{ChallengeName: "Licences"})
+	check(t, err)
+	strategyId, err := s.AddStrategy(a, projectId, ids[1], Strategy{StrategyName: "Training"})
+	check(t, err)
+	check(t, s.UpdateChallenge(a, projectId, challengeId, Challenge{ChallengeName: "Slow licences"}))
+	bp, err = s.GetBoundaryPartner(a, projectId, partnerId)
+	check(t, err)
+	pm := bp.ProgressMarkers[1]
+	if len(pm.Challenges) != 1 || pm.Challenges[0].ChallengeName != "Slow licences" || len(pm.Strategies) != 1 || pm.Strategies[0].StrategyId != strategyId {
+		t.Fatalf("%+v", pm)
+	}
+	check(t, s.DeleteStrategy(a, projectId, strategyId))
+	if err = s.DeleteStrategy(a, projectId, strategyId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func testStoreOwnership(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectA, err := s.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	projectB, err := s.AddProject(b, Project{ProjectName: "B"})
+	check(t, err)
+	partnerB, err := s.AddBoundaryPartner(b, projectB, BoundaryPartner{PartnerName: "BP"})
+	check(t, err)
+	markerB, err := s.AddProgressMarker(b, projectB, partnerB, ProgressMarker{Title: "m", Type: 1})
+	check(t, err)
+
+	tests := []struct {
+		tenant Tenant
+		vars   map[string]string
+		field  string
+	}{
+		{a, map[string]string{"projectId": projectA}, ""},
+		{a, map[string]string{"projectId": projectB}, "project_id"},
+		{a, map[string]string{"projectId": "not-a-uuid"}, "project_id"},
+		{a, map[string]string{"projectId": projectA, "partnerId": partnerB}, "boundary_partner_id"},
+		{a, map[string]string{"projectId": projectA, "progressMarkerId": markerB}, "progress_marker_id"},
+		{b, map[string]string{"projectId": projectB, "partnerId": partnerB, "progressMarkerId": markerB}, ""},
+	}
+	for _, test := range tests {
+		field, err := s.CheckOwnership(test.tenant, test.vars)
+		check(t, err)
+		if field != test.field {
+			t.Errorf("%v: got %q, want %q", test.vars, field, test.field)
+		}
+	}
+
+	if _, err = s.AddBoundaryPartner(a, projectB, BoundaryPartner{PartnerName: "x"}); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if err = s.UpdateProgressMarker(a, projectA, markerB, ProgressMarker{Title: "x", Type: 1, OrderNumber: 1}); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if err = s.DeleteProgressMarker(a, projectA, markerB); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func testStoreResources(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectId, err := s.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	resourceId, err := s.AddExternalResource(a, ADMIN_A, ExternalResources{ProjectId: projectId, ResourceName: "report.pdf",
+		ResourceUrl: "./temp_file/report.pdf", StorageKey: "ab/abc"})
+	check(t, err)
+	if _, err = s.AddExternalResource(b, ADMIN_B, ExternalResources{ProjectId: projectId, ResourceName: "x"}); err != ErrNotFound {
+		t.Fatal(err)
+	}
+
+	resources, err := s.GetExternalResources(a, projectId)
+	check(t, err)
+	if len(resources) != 1 || resources[0].ResourceId != resourceId || resources[0].ResourceName != "report.pdf" {
+		t.Fatalf("%+v", resources)
+	}
+	_, err = s.SetProjectLogo(a, projectId, &ProjectLogo{OriginalKey: "cd/cde", MediumKey: "cd/cdf", ThumbnailKey: "cd/cdg",
+		ContentType: "image/png", Width: 64, Height: 32})
+	check(t, err)
+
+	// deleted reports whether DeleteUnusedBlob deletes a blob
+	deleted := func(tenant Tenant, key string) bool {
+		t.Helper()
+		called := false
+		check(t, s.DeleteUnusedBlob(tenant, key, func() error {
+			called = true
+			return nil
+		}))
+		return called
+	}
+	for key, want := range map[string]bool{"ab/abc": false, "cd/cde": false, "cd/cdg": false, "ff/fff": true} {
+		if got := deleted(a, key); got != want {
+			t.Errorf("%s deleted: %v", key, got)
+		}
+	}
+	if !deleted(b, "ab/abc") {
+		t.Error("blob in use by the other tenant")
+	}
+	unpin, err := s.PinBlob(a, "ff/fff", time.Now().Add(time.Hour))
+	check(t, err)
+	if deleted(a, "ff/fff") {
+		t.Error("pinned blob deleted")
+	}
+	if !deleted(b, "ff/fff") {
+		t.Error("blob pinned by the other tenant")
+	}
+	unpin()
+	if !deleted(a, "ff/fff") {
+		t.Error("unpinned blob kept")
+	}
+	_, err = s.PinBlob(a, "ff/fff", time.Now().Add(-time.Second))
+	check(t, err)
+	if !deleted(a, "ff/fff") {
+		t.Error("lapsed pin kept the blob")
+	}
+
+	if _, err = s.DeleteExternalResource(b, projectId, resourceId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	removed, err := s.DeleteExternalResource(a, projectId, resourceId)
+	check(t, err)
+	if removed.ResourceUrl != "./temp_file/report.pdf" || removed.StorageKey != "ab/abc" {
+		t.Fatalf("%+v", removed)
+	}
+	if resources, _ = s.GetExternalResources(a, projectId); len(resources) != 0 {
+		t.Fatalf("%+v", resources)
+	}
+	if !deleted(a, "ab/abc") {
+		t.Error("blob of a deleted resource kept")
+	}
+}
+
+func testStoreJournals(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectId, err := s.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	partnerId, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	otherPartner, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Farmers"})
+	check(t, err)
+	var markers []string
+	for _, title := range []string{"listen", "fund"} {
+		id, err := s.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: title, Type: MARKER_EXPECT})
+		check(t, err)
+		markers = append(markers, id)
+	}
+	foreignMarker, err := s.AddProgressMarker(a, projectId, otherPartner, ProgressMarker{Title: "plant", Type: MARKER_EXPECT})
+	check(t, err)
+
+	journal := OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId, MonitoringDate: "2017-03-31",
+		DescriptionOfChange: "The council listens.",
+		Ratings:             []JournalRating{{markers[1], RATING_LOW}, {markers[0], RATING_HIGH}}}
+	journalId, err := s.AddJournal(a, ADMIN_A, journal)
+	check(t, err)
+	later := journal
+	later.MonitoringDate, later.Ratings = "2017-06-30", nil
+	laterId, err := s.AddJournal(a, ADMIN_A, later)
+	check(t, err)
+
+	for _, test := range []struct {
+		name   string
+		change func(j *OutcomeJournal)
+		err    error
+	}{
+		{"same date", func(j *OutcomeJournal) {}, errJournalDate},
+		{"marker of another partner", func(j *OutcomeJournal) {
+			j.MonitoringDate, j.Ratings = "2017-09-30", []JournalRating{{foreignMarker, RATING_LOW}}
+		}, errJournalMarkers},
+		{"partner of another project", func(j *OutcomeJournal) {
+			j.MonitoringDate, j.BoundaryPartnerId = "2017-09-30", ADMIN_A
+		}, errJournalPartner},
+	} {
+		invalid := journal
+		test.change(&invalid)
+		if _, err = s.AddJournal(a, ADMIN_A, invalid); err == nil || err.Error() != test.err.Error() || len(err.(FieldErrors)) != 1 {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+	if _, err = s.AddJournal(b, ADMIN_B, later); err != ErrNotFound {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetJournal(a, projectId, journalId)
+	check(t, err)
+	// ratings come in the order of the markers
+	if got.Status != JOURNAL_DRAFT || got.SubmittedAt != nil || got.DescriptionOfChange != "The council listens." ||
+		len(got.Ratings) != 2 || got.Ratings[0] != (JournalRating{markers[0], RATING_HIGH}) || got.Ratings[1].ProgressMarkerId != markers[1] {
+		t.Fatalf("%+v", got)
+	}
+	journals, err := s.GetJournals(a, projectId, "")
+	check(t, err)
+	if len(journals) != 2 || journals[0].JournalId != laterId || journals[1].JournalId != journalId || len(journals[0].Ratings) != 0 {
+		t.Fatalf("%+v", journals)
+	}
+	if journals, _ = s.GetJournals(a, projectId, otherPartner); len(journals) != 0 {
+		t.Fatalf("%+v", journals)
+	}
+	if journals, _ = s.GetJournals(b, projectId, ""); len(journals) != 0 {
+		t.Fatalf("other tenant sees %+v", journals)
+	}
+	if _, err = s.GetJournal(b, projectId, journalId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+
+	update := OutcomeJournal{MonitoringDate: "2017-06-30", Ratings: []JournalRating{{markers[0], RATING_MEDIUM}}}
+	if err = s.UpdateJournal(a, projectId, journalId, update); err == nil || err.Error() != errJournalDate.Error() {
+		t.Fatal(err)
+	}
+	update.MonitoringDate, update.Lessons = "2017-04-01", "Ask earlier."
+	if err = s.UpdateJournal(b, projectId, journalId, update); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	check(t, s.UpdateJournal(a, projectId, journalId, update))
+	got, err = s.GetJournal(a, projectId, journalId)
+	check(t, err)
+	if got.MonitoringDate != "2017-04-01" || got.Lessons != "Ask earlier." || got.DescriptionOfChange != "" ||
+		got.BoundaryPartnerId != partnerId || len(got.Ratings) != 1 || got.Ratings[0].Rating != RATING_MEDIUM {
+		t.Fatalf("%+v", got)
+	}
+
+	if err = s.SubmitJournal(b, ADMIN_B, projectId, journalId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	check(t, s.SubmitJournal(a, ADMIN_A, projectId, journalId))
+	got, err = s.GetJournal(a, projectId, journalId)
+	check(t, err)
+	if got.Status != JOURNAL_SUBMITTED || got.SubmittedAt == nil || got.SubmittedBy != ADMIN_A {
+		t.Fatalf("%+v", got)
+	}
+	if err = s.SubmitJournal(a, ADMIN_A, projectId, journalId); err == nil || err.Error() != errJournalSubmitted.Error() {
+		t.Fatal(err)
+	}
+	if err = s.UpdateJournal(a, projectId, journalId, update); err == nil || err.Error() != errJournalSubmitted.Error() {
+		t.Fatal(err)
+	}
+
+	// deleting a marker drops its ratings, deleting a journal detaches its resources
+	check(t, s.DeleteProgressMarker(a, projectId, markers[0]))
+	if got, _ = s.GetJournal(a, projectId, journalId); len(got.Ratings) != 0 {
+		t.Fatalf("%+v", got.Ratings)
+	}
+	resourceId, err := s.AddExternalResource(a, ADMIN_A, ExternalResources{ProjectId: projectId, ResourceType: RESOURCE_LINK,
+		ResourceUrl: "https://example.org", ResourceName: "evidence", JournalId: laterId})
+	check(t, err)
+	if resource, _ := s.GetExternalResource(a, projectId, resourceId); resource.JournalId != laterId {
+		t.Fatalf("%+v", resource)
+	}
+	if err = s.DeleteJournal(b, projectId, laterId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	check(t, s.DeleteJournal(a, projectId, laterId))
+	if resource, _ := s.GetExternalResource(a, projectId, resourceId); resource.ResourceId != resourceId || resource.JournalId != "" {
+		t.Fatalf("%+v", resource)
+	}
+
+	// deleting a partner deletes its journals
+	check(t, s.DeleteBoundaryPartner(a, projectId, partnerId))
+	if _, err = s.GetJournal(a, projectId, journalId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func testStoreStats(t *testing.T, s Store) {
+	a := Tenant{ORG_A}
+	projectId, err := s.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	councils, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	farmers, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Farmers"})
+	check(t, err)
+	var markers []string
+	for _, partnerId := range []string{councils, councils, farmers} {
+		id, err := s.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: "m", Type: MARKER_EXPECT})
+		check(t, err)
+		markers = append(markers, id)
+	}
+	journal := func(partnerId, date string, submit bool, ratings ...JournalRating) {
+		t.Helper()
+		id, err := s.AddJournal(a, ADMIN_A, OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId,
+			MonitoringDate: date, Ratings: ratings})
+		check(t, err)
+		if submit {
+			check(t, s.SubmitJournal(a, ADMIN_A, projectId, id))
+		}
+	}
+	journal(councils, "2017-06-30", true, JournalRating{markers[0], RATING_HIGH}, JournalRating{markers[1], RATING_MEDIUM})
+	journal(councils, "2017-03-31", true, JournalRating{markers[0], RATING_LOW}, JournalRating{markers[1], RATING_LOW})
+	journal(councils, "2017-09-30", false, JournalRating{markers[0], RATING_LOW})
+	journal(farmers, "2017-06-30", true, JournalRating{markers[2], RATING_HIGH})
+
+	st, err := s.GetProjectStats(a, projectId)
+	check(t, err)
+	st.setAchieved()
+	if st.LatestRatings != (RatingCounts{0, 1, 2}) || st.Achieved == nil || *st.Achieved != 66.7 {
+		t.Fatalf("%+v", st)
+	}
+	trend := st.Partners[0].Trend
+	if len(trend) != 2 || trend[0].MonitoringDate != "2017-03-31" || trend[0].Ratings != (RatingCounts{2, 0, 0}) ||
+		*trend[0].Achieved != 0 || trend[1].MonitoringDate != "2017-06-30" || *trend[1].Achieved != 50 {
+		t.Fatalf("%+v", trend)
+	}
+	if trend = st.Partners[1].Trend; len(trend) != 1 || *trend[0].Achieved != 100 {
+		t.Fatalf("%+v", trend)
+	}
+
+	projects, err := s.GetOrganizationStats(a)
+	check(t, err)
+	org, err := rollup(projects, time.Now(), st.Currency, staticRates{})
+	check(t, err)
+	if org.LatestRatings != st.LatestRatings || *org.Achieved != 66.7 {
+		t.Fatalf("%+v", org)
+	}
+	if _, err = s.GetProjectStats(Tenant{ORG_B}, projectId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func testStoreOrganization(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	key := func(c string) string { return blobKey(ORG_A, strings.Repeat(c, 64)) }
+	logo := &ProjectLogo{OriginalKey: key("a"), MediumKey: key("b"), ThumbnailKey: key("c"),
+		ContentType: "image/png", Width: 64, Height: 32}
+	old, err := s.SetOrganizationLogo(a, logo)
+	check(t, err)
+	if old != nil {
+		t.Fatalf("%+v", old)
+	}
+	check(t, s.SetBrandColor(a, "#112233"))
+
+	org, err := s.GetOrganization(a)
+	check(t, err)
+	if org.BrandColor != "#112233" || org.Logo == nil || org.Logo.OriginalKey != key("a") || org.Logo.Width != 64 || org.Logo.Original == "" {
+		t.Fatalf("%+v %+v", org, org.Logo)
+	}
+	other, err := s.GetOrganization(b)
+	check(t, err)
+	if other.Logo != nil || other.BrandColor != "" {
+		t.Fatalf("%+v", other)
+	}
+
+	deleted := func(tenant Tenant, key string) bool {
+		t.Helper()
+		called := false
+		check(t, s.DeleteUnusedBlob(tenant, key, func() error {
+			called = true
+			return nil
+		}))
+		return called
+	}
+	if deleted(a, key("b")) {
+		t.Error("blob of the organization logo deleted")
+	}
+
+	old, err = s.SetOrganizationLogo(a, nil)
+	check(t, err)
+	if old == nil || old.ThumbnailKey != key("c") {
+		t.Fatalf("%+v", old)
+	}
+	check(t, s.SetBrandColor(a, ""))
+	org, err = s.GetOrganization(a)
+	check(t, err)
+	if org.Logo != nil || org.BrandColor != "" {
+		t.Fatalf("%+v", org)
+	}
+	if !deleted(a, key("b")) {
+		t.Error("blob of a replaced organization logo kept")
+	}
+}
+
+func testStoreScans(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectId, err := s.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	add := func(status, key string) string {
+		t.Helper()
+		resourceId, err := s.AddExternalResource(a, ADMIN_A, ExternalResources{ProjectId: projectId, ResourceType: RESOURCE_FILE,
+			ResourceName: key + ".txt", Size: 1, SHA256: key, ContentType: "text/plain", ScanStatus: status, StorageKey: key})
+		check(t, err)
+		return resourceId
+	}
+	addVersion := func(resourceId, status, key string) {
+		t.Helper()
+		_, err := s.AddResourceVersion(a, projectId, resourceId, ADMIN_A, ResourceVersion{ResourceName: key + ".txt",
+			Size: 1, SHA256: key, ContentType: "text/plain", ScanStatus: status, StorageKey: key})
+		check(t, err)
+	}
+	pendingId := add(SCAN_PENDING, "aa/1")
+	infectedId := add(SCAN_PENDING, "aa/2")
+	olderId := add(SCAN_CLEAN, "aa/3")
+	addVersion(olderId, SCAN_PENDING, "aa/4")
+	addVersion(olderId, SCAN_CLEAN, "aa/5")
+	newerId := add(SCAN_CLEAN, "aa/6")
+	addVersion(newerId, SCAN_PENDING, "aa/7")
+
+	scans, err := s.GetPendingScans(a)
+	check(t, err)
+	var keys []string
+	for _, p := range scans {
+		keys = append(keys, p.StorageKey)
+	}
+	if !equalStrings(keys, []string{"aa/1", "aa/2", "aa/4", "aa/7"}) || scans[2].ResourceId != olderId ||
+		scans[2].VersionNumber != 2 || scans[2].ProjectId != projectId || scans[2].ResourceName != "aa/4.txt" {
+		t.Fatalf("%+v", scans)
+	}
+	if scans, err = s.GetPendingScans(b); err != nil || len(scans) != 0 {
+		t.Fatal(scans, err)
+	}
+	if err = s.SetScanStatus(b, pendingId, 1, SCAN_CLEAN); err != ErrNotFound {
+		t.Fatal(err)
+	}
+
+	check(t, s.SetScanStatus(a, pendingId, 1, SCAN_CLEAN))
+	check(t, s.SetScanStatus(a, infectedId, 1, SCAN_QUARANTINED))
+	check(t, s.SetScanStatus(a, olderId, 2, SCAN_CLEAN))
+	check(t, s.SetScanStatus(a, newerId, 2, SCAN_CLEAN))
+	if err = s.SetScanStatus(a, pendingId, 1, SCAN_QUARANTINED); err != ErrNotFound {
+		t.Fatal("rescanned a clean version", err)
+	}
+
+	current := func(resourceId string) (int, string) {
+		t.Helper()
+		exr, err := s.GetExternalResource(a, projectId, resourceId)
+		check(t, err)
+		return exr.Version, exr.StorageKey
+	}
+	if number, key := current(pendingId); number != 1 || key != "aa/1" {
+		t.Error("pending resource", number, key)
+	}
+	if number, key := current(olderId); number != 3 || key != "aa/5" {
+		t.Error("older version became current", number, key)
+	}
+	if number, key := current(newerId); number != 2 || key != "aa/7" {
+		t.Error("newer version", number, key)
+	}
+	if _, err = s.GetExternalResource(a, projectId, infectedId); err != ErrNotFound {
+		t.Fatal("quarantined resource visible", err)
+	}
+	quarantined, err := s.GetQuarantinedResources(a, projectId)
+	check(t, err)
+	if len(quarantined) != 1 || quarantined[0].ResourceId != infectedId || quarantined[0].ScanStatus != SCAN_QUARANTINED {
+		t.Fatalf("%+v", quarantined)
+	}
+	if scans, err = s.GetPendingScans(a); err != nil || len(scans) != 0 {
+		t.Fatal(scans, err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testStoreJobs(t *testing.T, s Store) {
+	now := time.Now().Truncate(time.Second)
+	check(t, s.ScheduleJob("purge", now, 2))
+	// a job has one pending run at a time
+	check(t, s.ScheduleJob("purge", now.Add(-time.Minute), 2))
+	check(t, s.ScheduleJob("digest", now.Add(time.Hour), 2))
+	due, err := s.GetDueJobs(now)
+	check(t, err)
+	if len(due) != 1 || due[0].Name != "purge" || !due[0].RunAt.Equal(now) || due[0].Status != JOB_PENDING {
+		t.Fatalf("%+v", due)
+	}
+
+	// the lock of a job is held until it is released
+	unlock, ok, err := s.LockJob("purge")
+	check(t, err)
+	if !ok {
+		t.Fatal("lock not taken")
+	}
+	if _, ok, err = s.LockJob("purge"); err != nil || ok {
+		t.Fatal("lock taken twice", err)
+	}
+	unlock()
+	unlock, ok, err = s.LockJob("purge")
+	if err != nil || !ok {
+		t.Fatal("lock not released", err)
+	}
+	unlock()
+
+	// a run is started once, and retried until it runs out of attempts
+	run, err := s.StartJob(due[0].JobId)
+	check(t, err)
+	if run.Status != JOB_RUNNING || run.Attempts != 1 || run.TsStarted == nil {
+		t.Fatalf("%+v", run)
+	}
+	if _, err = s.StartJob(run.JobId); err != ErrNotFound {
+		t.Fatal("started a running run", err)
+	}
+	retryAt := now.Add(time.Minute)
+	check(t, s.FinishJob(run.JobId, errors.New("disk full"), retryAt))
+	if due, err = s.GetDueJobs(now); err != nil || len(due) != 0 {
+		t.Fatal("retried before its time", due, err)
+	}
+	due, err = s.GetDueJobs(retryAt)
+	check(t, err)
+	if len(due) != 1 || due[0].JobId != run.JobId || due[0].LastError != "disk full" || due[0].Attempts != 1 {
+		t.Fatalf("%+v", due)
+	}
+	_, err = s.StartJob(run.JobId)
+	check(t, err)
+	check(t, s.FinishJob(run.JobId, errors.New("disk still full"), retryAt))
+	if due, err = s.GetDueJobs(retryAt); err != nil || len(due) != 0 {
+		t.Fatal("retried after its last attempt", due, err)
+	}
+	// a failed run makes way for the next one
+	check(t, s.ScheduleJob("purge", retryAt, 2))
+	due, err = s.GetDueJobs(retryAt)
+	check(t, err)
+	if len(due) != 1 || due[0].JobId == run.JobId {
+		t.Fatalf("%+v", due)
+	}
+
+	// a run left running by an instance that went away is pending again
+	stale, err := s.StartJob(due[0].JobId)
+	check(t, err)
+	check(t, s.RequeueStaleJobs(now.Add(-JOB_TIMEOUT)))
+	if due, err = s.GetDueJobs(retryAt); err != nil || len(due) != 0 {
+		t.Fatal("requeued a run still in time", due, err)
+	}
+	check(t, s.RequeueStaleJobs(time.Now().Add(time.Second)))
+	due, err = s.GetDueJobs(retryAt)
+	check(t, err)
+	if len(due) != 1 || due[0].JobId != stale.JobId || due[0].Attempts != 1 {
+		t.Fatalf("%+v", due)
+	}
+	_, err = s.StartJob(stale.JobId)
+	check(t, err)
+	check(t, s.FinishJob(stale.JobId, nil, retryAt))
+
+	// only finished runs are purged
+	purged, err := s.PurgeJobs(time.Now().Add(time.Second))
+	check(t, err)
+	if purged != 2 {
+		t.Fatal("purged", purged)
+	}
+	if due, err = s.GetDueJobs(now.Add(time.Hour)); err != nil || len(due) != 1 || due[0].Name != "digest" {
+		t.Fatal(due, err)
+	}
+}
+
+func testStoreSearch(t *testing.T, s Store) {
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	projectId, err := s.AddProject(a, Project{ProjectName: "Community radio", Description: "Weekly shows for farmers"})
+	check(t, err)
+	partnerId, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	journalId, err := s.AddJournal(a, ADMIN_A, OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId,
+		MonitoringDate: "2017-06-30", DescriptionOfChange: "Councils fund the radio.", Lessons: "Farmers call in."})
+	check(t, err)
+	otherId, err := s.AddProject(a, Project{ProjectName: "Farmers' markets"})
+	check(t, err)
+	_, err = s.AddProject(b, Project{ProjectName: "Farmers of B"})
+	check(t, err)
+
+	search := func(tenant Tenant, q SearchQuery) []string {
+		t.Helper()
+		results, total, err := s.Search(tenant, q)
+		check(t, err)
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.Type+":"+r.Id)
+		}
+		if total != len(results) {
+			t.Fatalf("%d results of %d", len(results), total)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	ids := search(a, SearchQuery{Query: "farmers", Limit: 10})
+	want := []string{SEARCH_JOURNAL + ":" + journalId, SEARCH_PROJECT + ":" + projectId, SEARCH_PROJECT + ":" + otherId}
+	sort.Strings(want)
+	if !equalStrings(ids, want) {
+		t.Fatal(ids)
+	}
+	// grant officers search the projects of their donor
+	ids = search(a, SearchQuery{Query: "farmers", Types: []string{SEARCH_PROJECT}, ProjectIds: []string{otherId}, Limit: 10})
+	if !equalStrings(ids, []string{SEARCH_PROJECT + ":" + otherId}) {
+		t.Fatal(ids)
+	}
+	if ids = search(a, SearchQuery{Query: "farmers", ProjectIds: []string{}, Limit: 10}); len(ids) != 0 {
+		t.Fatal(ids)
+	}
+	results, _, err := s.Search(a, SearchQuery{Query: "call", Types: []string{SEARCH_JOURNAL}, Limit: 10})
+	check(t, err)
+	if len(results) != 1 || results[0].BoundaryPartnerId != partnerId || results[0].ProjectName != "Community radio" ||
+		!strings.Contains(results[0].Snippet, HIGHLIGHT_START+"call"+HIGHLIGHT_STOP) {
+		t.Fatalf("%+v", results)
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
+)
+
+// events pushed to the streams of a project
+const (
+	STREAM_PARTNER_CREATED   = "partner.created"
+	STREAM_PARTNER_UPDATED   = "partner.updated"
+	STREAM_PARTNER_DELETED   = "partner.deleted"
+	STREAM_MARKER_CREATED    = "progress_marker.created"
+	STREAM_MARKER_UPDATED    = "progress_marker.updated"
+	STREAM_MARKER_DELETED    = "progress_marker.deleted"
+	STREAM_MARKERS_REORDERED = "progress_marker.reordered"
+	STREAM_CHALLENGE_CREATED = "challenge.created"
+	STREAM_CHALLENGE_UPDATED = "challenge.updated"
+	STREAM_CHALLENGE_DELETED = "challenge.deleted"
+	STREAM_STRATEGY_CREATED  = "strategy.created"
+	STREAM_STRATEGY_UPDATED  = "strategy.updated"
+	STREAM_STRATEGY_DELETED  = "strategy.deleted"
+	// who views the project changed
+	STREAM_PRESENCE = "presence"
+	// events may have been missed, the client should load the project again
+	STREAM_RESYNC = "resync"
+)
+
+// channel the instances of the server send each other stream messages on
+const STREAM_CHANNEL = "lucid_stream"
+
+// every heartbeat a stream gets a comment to keep proxies from closing it,
+// and the instances announce their viewers again. An instance that has not
+// announced them for STREAM_PRESENCE_TTL is taken to have gone away.
+const (
+	STREAM_HEARTBEAT    = 30 * time.Second
+	STREAM_PRESENCE_TTL = 3 * STREAM_HEARTBEAT
+)
+
+// events queued for a stream that is not read fast enough, it is closed
+// when more are waiting, and the client reconnects and loads the project again
+const STREAM_BUFFER = 64
+
+// NOTIFY payloads must be shorter than 8000 bytes, an event that would be
+// longer is sent without its data and other messages are not sent at all
+const MAX_NOTIFY_PAYLOAD = 7900
+
+// StreamEvent is a change to a project pushed to the clients viewing it.
+type StreamEvent struct {
+	Type       string      `json:"type"`
+	ProjectId  string      `json:"project_id"`
+	ActorId    string      `json:"actor_id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+	// Data was left out because it was too long to send between instances,
+	// the client loads the entity instead
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Viewer is a user viewing a project.
+type Viewer struct {
+	UserId   string `json:"user_id"`
+	FullName string `json:"full_name"`
+}
+
+// streamMessage is sent between the instances of the server: an event, or
+// the viewers of a project on the instance that sends it.
+type streamMessage struct {
+	Instance       string       `json:"instance"`
+	OrganizationId string       `json:"organization_id"`
+	ProjectId      string       `json:"project_id"`
+	Event          *StreamEvent `json:"event,omitempty"`
+	Viewers        []Viewer     `json:"viewers,omitempty"`
+}
+
+// StreamBus carries stream messages to every instance of the server.
+type StreamBus interface {
+	Publish(m streamMessage) error
+	// Subscribe delivers the messages of all instances, including the one
+	// that subscribes, to deliver. reset is called when messages may have
+	// been missed.
+	Subscribe(deliver func(streamMessage), reset func())
+}
+
+// localBus is the bus of a single instance.
+type localBus struct {
+	sync.RWMutex
+	deliver func(streamMessage)
+}
+
+func newLocalBus() *localBus {
+	return &localBus{}
+}
+
+func (b *localBus) Publish(m streamMessage) error {
+	b.RLock()
+	deliver := b.deliver
+	b.RUnlock()
+	if deliver != nil {
+		deliver(m)
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(deliver func(streamMessage), reset func()) {
+	b.Lock()
+	b.deliver = deliver
+	b.Unlock()
+}
+
+// pgBus sends stream messages with NOTIFY and receives them with LISTEN on
+// a connection of its own.
+type pgBus struct {
+	db  *sqlx.DB
+	dsn string
+}
+
+func NewPostgresBus(db *sqlx.DB, dsn string) *pgBus {
+	return &pgBus{db, dsn}
+}
+
+func (b *pgBus) Publish(m streamMessage) error {
+	payload, err := notifyPayload(m)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("SELECT pg_notify($1, $2)", STREAM_CHANNEL, string(payload))
+	return err
+}
+
+// notifyPayload encodes a stream message for NOTIFY. An event that is too
+// long is sent without its data, a message that is too long even then is
+// refused.
+func notifyPayload(m streamMessage) ([]byte, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MAX_NOTIFY_PAYLOAD && m.Event != nil {
+		event := *m.Event
+		event.Data, event.Truncated = nil, true
+		m.Event = &event
+		if payload, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+	if len(payload) > MAX_NOTIFY_PAYLOAD {
+		return nil, fmt.Errorf("stream message of %d bytes is too long to send", len(payload))
+	}
+	return payload, nil
+}
+
+// Subscribe listens on STREAM_CHANNEL. If LISTEN fails it is retried in the
+// background, and the streams resync once it succeeds, since they missed
+// the messages sent until then.
+func (b *pgBus) Subscribe(deliver func(streamMessage), reset func()) {
+	listener := pq.NewListener(b.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			warnf("stream listener: %v", err)
+		}
+	})
+	if err := listener.Listen(STREAM_CHANNEL); err != nil {
+		errorf("stream listener: %v, retrying", err)
+		go listenAgain(listener, reset)
+	}
+	go func() {
+		for n := range listener.Notify {
+			// the connection was lost and listens again
+			if n == nil {
+				reset()
+				continue
+			}
+			var m streamMessage
+			if err := json.Unmarshal([]byte(n.Extra), &m); err != nil {
+				warnf("stream listener: %v", err)
+				continue
+			}
+			deliver(m)
+		}
+	}()
+}
+
+// listenAgain retries LISTEN with backoff until it succeeds.
+func listenAgain(listener *pq.Listener, reset func()) {
+	for attempt := 1; ; attempt++ {
+		time.Sleep(backoff(attempt, time.Second, time.Minute))
+		err := listener.Listen(STREAM_CHANNEL)
+		if err == nil || err == pq.ErrChannelAlreadyOpen {
+			infof("stream listener: listening after %d retries", attempt)
+			reset()
+			return
+		}
+		errorf("stream listener: %v, retrying", err)
+	}
+}
+
+// streamFrame is an event of a server-sent event stream. A frame without a
+// name is a comment.
+type streamFrame struct {
+	name string
+	data []byte
+}
+
+type streamClient struct {
+	viewer Viewer
+	frames chan streamFrame
+	// closed when the client fell behind
+	lost     chan struct{}
+	loseOnce sync.Once
+}
+
+func (c *streamClient) send(f streamFrame) {
+	select {
+	case c.frames <- f:
+	default:
+		c.loseOnce.Do(func() { close(c.lost) })
+	}
+}
+
+type remoteViewers struct {
+	viewers []Viewer
+	seen    time.Time
+}
+
+// StreamHub pushes the events of projects to the clients viewing them on
+// this instance and keeps track of who views which project on all of them.
+// Projects are keyed by organization and project ID, so a stream never sees
+// another tenant's events.
+type StreamHub struct {
+	sync.Mutex
+	bus      StreamBus
+	instance string
+	clients  map[string]map[*streamClient]bool
+	// the viewers on other instances by project and instance
+	remote map[string]map[string]remoteViewers
+}
+
+func NewStreamHub(bus StreamBus) *StreamHub {
+	h := &StreamHub{
+		bus:      bus,
+		instance: uuid.NewV4().String(),
+		clients:  make(map[string]map[*streamClient]bool),
+		remote:   make(map[string]map[string]remoteViewers),
+	}
+	bus.Subscribe(h.deliver, h.reset)
+	return h
+}
+
+func streamKey(organizationId, projectId string) string {
+	return organizationId + "/" + projectId
+}
+
+// Publish sends an event to the streams of its project on all instances.
+func (h *StreamHub) Publish(t Tenant, e StreamEvent) {
+	err := h.bus.Publish(streamMessage{Instance: h.instance, OrganizationId: t.OrganizationId, ProjectId: e.ProjectId, Event: &e})
+	if err != nil {
+		errorf("stream %s: %v", e.Type, err)
+	}
+}
+
+// Join adds a stream of a project and announces its viewer.
+func (h *StreamHub) Join(t Tenant, projectId string, viewer Viewer) *streamClient {
+	c := &streamClient{viewer: viewer, frames: make(chan streamFrame, STREAM_BUFFER), lost: make(chan struct{})}
+	key := streamKey(t.OrganizationId, projectId)
+	h.Lock()
+	if h.clients[key] == nil {
+		h.clients[key] = make(map[*streamClient]bool)
+	}
+	h.clients[key][c] = true
+	h.Unlock()
+	h.announce(t.OrganizationId, projectId)
+	return c
+}
+
+// Leave removes a stream and announces that its viewer left.
+func (h *StreamHub) Leave(t Tenant, projectId string, c *streamClient) {
+	key := streamKey(t.OrganizationId, projectId)
+	h.Lock()
+	delete(h.clients[key], c)
+	if len(h.clients[key]) == 0 {
+		delete(h.clients, key)
+	}
+	h.Unlock()
+	h.announce(t.OrganizationId, projectId)
+}
+
+// announce sends the viewers of a project on this instance to all instances.
+func (h *StreamHub) announce(organizationId, projectId string) {
+	h.Lock()
+	viewers := []Viewer{}
+	for c := range h.clients[streamKey(organizationId, projectId)] {
+		viewers = append(viewers, c.viewer)
+	}
+	h.Unlock()
+	err := h.bus.Publish(streamMessage{Instance: h.instance, OrganizationId: organizationId, ProjectId: projectId, Viewers: viewers})
+	if err != nil {
+		errorf("stream presence: %v", err)
+	}
+}
+
+// deliver passes a message from the bus on to the streams of its project.
+func (h *StreamHub) deliver(m streamMessage) {
+	key := streamKey(m.OrganizationId, m.ProjectId)
+	h.Lock()
+	defer h.Unlock()
+	if m.Event != nil {
+		data, err := json.Marshal(m.Event)
+		if err != nil {
+			errorf("stream %s: %v", m.Event.Type, err)
+			return
+		}
+		for c := range h.clients[key] {
+			c.send(streamFrame{m.Event.Type, data})
+		}
+		return
+	}
+	if m.Instance != h.instance {
+		if len(m.Viewers) == 0 {
+			delete(h.remote[key], m.Instance)
+		} else {
+			if h.remote[key] == nil {
+				h.remote[key] = make(map[string]remoteViewers)
+			}
+			h.remote[key][m.Instance] = remoteViewers{m.Viewers, time.Now()}
+		}
+		if len(h.remote[key]) == 0 {
+			delete(h.remote, key)
+		}
+	}
+	h.sendPresence(key, m.ProjectId)
+}
+
+// sendPresence sends the viewers of a project on all instances to its
+// streams on this one. The caller holds the lock.
+func (h *StreamHub) sendPresence(key, projectId string) {
+	if len(h.clients[key]) == 0 {
+		return
+	}
+	byUser := make(map[string]Viewer)
+	for c := range h.clients[key] {
+		byUser[c.viewer.UserId] = c.viewer
+	}
+	for _, r := range h.remote[key] {
+		for _, v := range r.viewers {
+			byUser[v.UserId] = v
+		}
+	}
+	viewers := []Viewer{}
+	for _, v := range byUser {
+		viewers = append(viewers, v)
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		if viewers[i].FullName != viewers[j].FullName {
+			return viewers[i].FullName < viewers[j].FullName
+		}
+		return viewers[i].UserId < viewers[j].UserId
+	})
+	data, _ := json.Marshal(map[string]interface{}{"type": STREAM_PRESENCE, "project_id": projectId, "viewers": viewers})
+	for c := range h.clients[key] {
+		c.send(streamFrame{STREAM_PRESENCE, data})
+	}
+}
+
+// reset tells all streams to load their project again, since events may
+// have been missed, and announces the viewers again.
+func (h *StreamHub) reset() {
+	h.Lock()
+	h.remote = make(map[string]map[string]remoteViewers)
+	for _, clients := range h.clients {
+		for c := range clients {
+			c.send(streamFrame{STREAM_RESYNC, []byte(`{"type":"` + STREAM_RESYNC + `"}`)})
+		}
+	}
+	h.Unlock()
+	h.announceAll()
+}
+
+// announceAll announces the viewers of every project viewed on this instance.
+func (h *StreamHub) announceAll() {
+	h.Lock()
+	var keys []string
+	for key := range h.clients {
+		keys = append(keys, key)
+	}
+	h.Unlock()
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 2)
+		h.announce(parts[0], parts[1])
+	}
+}
+
+// Heartbeat pings the streams, announces the viewers on this instance again
+// and forgets the viewers of instances that stopped announcing theirs, every
+// STREAM_HEARTBEAT, forever.
+func (h *StreamHub) Heartbeat() {
+	for range time.Tick(STREAM_HEARTBEAT) {
+		h.expire(time.Now())
+		h.announceAll()
+	}
+}
+
+func (h *StreamHub) expire(now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	for key, instances := range h.remote {
+		expired := false
+		for instance, r := range instances {
+			if now.Sub(r.seen) > STREAM_PRESENCE_TTL {
+				delete(instances, instance)
+				expired = true
+			}
+		}
+		if len(instances) == 0 {
+			delete(h.remote, key)
+		}
+		if expired {
+			h.sendPresence(key, strings.SplitN(key, "/", 2)[1])
+		}
+	}
+	for _, clients := range h.clients {
+		for c := range clients {
+			c.send(streamFrame{})
+		}
+	}
+}
+
+// stream pushes a change to a project to the clients viewing it.
+func (s *Server) stream(r *http.Request, projectId, event string, data interface{}) {
+	user := context.Get(r, USER).(User)
+	s.streams.Publish(tenantOf(r), StreamEvent{
+		Type:       event,
+		ProjectId:  projectId,
+		ActorId:    user.UserId,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+}
+
+// getProjectStream streams the changes to the partners, progress markers,
+// challenges and strategies of a project, and who else views it, as
+// server-sent events until the client goes away. The API key is sent in the
+// X-Api-Key header like on every other route.
+func (s *Server) getProjectStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		JSON(w, http.StatusInternalServerError, Response{nil, "streaming is not supported"})
+		return
+	}
+	user := context.Get(r, USER).(User)
+	projectId := mux.Vars(r)["projectId"]
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	c := s.streams.Join(tenantOf(r), projectId, Viewer{user.UserId, user.FullName})
+	defer s.streams.Leave(tenantOf(r), projectId, c)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.lost:
+			return
+		case f := <-c.frames:
+			var err error
+			if f.name == "" {
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			} else {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", f.name, f.data)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
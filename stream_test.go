@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNotifyPayload sends a long event without its data and refuses a
+// message that is too long without it.
+func TestNotifyPayload(t *testing.T) {
+	m := streamMessage{OrganizationId: ORG_A, ProjectId: "p",
+		Event: &StreamEvent{Type: STREAM_PARTNER_UPDATED, ProjectId: "p", Data: strings.Repeat("x", 100)}}
+	payload, err := notifyPayload(m)
+	check(t, err)
+	if !strings.Contains(string(payload), strings.Repeat("x", 100)) || strings.Contains(string(payload), "truncated") {
+		t.Fatal(string(payload))
+	}
+
+	m.Event.Data = strings.Repeat("x", MAX_NOTIFY_PAYLOAD)
+	payload, err = notifyPayload(m)
+	check(t, err)
+	if len(payload) > MAX_NOTIFY_PAYLOAD || !strings.Contains(string(payload), `"truncated":true`) {
+		t.Fatal(len(payload), string(payload[:100]))
+	}
+	if m.Event.Data == nil {
+		t.Fatal("the event of the message was changed")
+	}
+
+	m.Event.Data, m.Event.ActorId = nil, strings.Repeat("x", MAX_NOTIFY_PAYLOAD)
+	if _, err = notifyPayload(m); err == nil {
+		t.Fatal("long event sent")
+	}
+	var viewers []Viewer
+	for i := 0; i < 200; i++ {
+		viewers = append(viewers, Viewer{UserId: ADMIN_A, FullName: "Ada Lovelace"})
+	}
+	if _, err = notifyPayload(streamMessage{OrganizationId: ORG_A, ProjectId: "p", Viewers: viewers}); err == nil {
+		t.Fatal("long presence sent")
+	}
+}
+
+// testStream reads the server-sent events of a project stream.
+type testStream struct {
+	t      *testing.T
+	resp   *http.Response
+	events chan [2]string
+}
+
+// openStream opens the stream of a project with an API key.
+func (ts *testServer) openStream(key, projectId string) *testStream {
+	ts.t.Helper()
+	req, err := http.NewRequest("GET", ts.srv.URL+"/projects/"+projectId+"/stream", nil)
+	check(ts.t, err)
+	req.Header.Set("X-Api-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	check(ts.t, err)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		ts.t.Fatal(resp.StatusCode, resp.Header)
+	}
+	st := &testStream{ts.t, resp, make(chan [2]string, STREAM_BUFFER)}
+	go func() {
+		defer close(st.events)
+		var name string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && name != "":
+				st.events <- [2]string{name, strings.TrimPrefix(line, "data: ")}
+				name = ""
+			}
+		}
+	}()
+	ts.t.Cleanup(func() { resp.Body.Close() })
+	return st
+}
+
+// next returns the next event of the stream and its decoded data.
+func (st *testStream) next() (string, map[string]interface{}) {
+	st.t.Helper()
+	select {
+	case e, ok := <-st.events:
+		if !ok {
+			st.t.Fatal("the stream was closed")
+		}
+		var data map[string]interface{}
+		check(st.t, json.Unmarshal([]byte(e[1]), &data))
+		return e[0], data
+	case <-time.After(5 * time.Second):
+		st.t.Fatal("timed out waiting for an event")
+	}
+	return "", nil
+}
+
+// viewers reads a presence event and returns the names in it.
+func (st *testStream) viewers() string {
+	st.t.Helper()
+	event, data := st.next()
+	if event != STREAM_PRESENCE {
+		st.t.Fatal(event, data)
+	}
+	var names []string
+	for _, v := range data["viewers"].([]interface{}) {
+		names = append(names, v.(map[string]interface{})["full_name"].(string))
+	}
+	return strings.Join(names, ",")
+}
+
+// TestProjectStream views a project as two users and checks that both see
+// who else views it and the changes one of them makes.
+func TestProjectStream(t *testing.T) {
+	ts := newTestServer(t)
+	admin, member := ts.login("ada@a.org"), ts.login("ann@a.org")
+	projectId, err := ts.store.AddProject(Tenant{ORG_A}, Project{ProjectName: "A"})
+	check(t, err)
+
+	if code, out := ts.do(ts.login("bo@b.org"), "GET", "/projects/"+projectId+"/stream", "", ""); code != http.StatusNotFound {
+		t.Fatal(code, out)
+	}
+
+	adas := ts.openStream(admin, projectId)
+	if names := adas.viewers(); names != "Ada" {
+		t.Fatal(names)
+	}
+	anns := ts.openStream(member, projectId)
+	if names := anns.viewers(); names != "Ada,Ann" {
+		t.Fatal(names)
+	}
+	if names := adas.viewers(); names != "Ada,Ann" {
+		t.Fatal(names)
+	}
+
+	if code, out := ts.do(admin, "POST", "/projects/"+projectId+"/add_boundary_partner", form, "partner_name=Farmers"); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	for _, st := range []*testStream{adas, anns} {
+		event, data := st.next()
+		partner, _ := data["data"].(map[string]interface{})
+		if event != STREAM_PARTNER_CREATED || data["actor_id"] != ADMIN_A || data["project_id"] != projectId ||
+			partner["partner_name"] != "Farmers" {
+			t.Fatal(event, data)
+		}
+	}
+
+	anns.resp.Body.Close()
+	if names := adas.viewers(); names != "Ada" {
+		t.Fatal(names)
+	}
+}
+
+// TestStreamHubTenants keeps the streams of a project ID apart per
+// organization.
+func TestStreamHubTenants(t *testing.T) {
+	hub := NewStreamHub(newLocalBus())
+	a, b := Tenant{ORG_A}, Tenant{ORG_B}
+	ca := hub.Join(a, "p", Viewer{ADMIN_A, "Ada"})
+	cb := hub.Join(b, "p", Viewer{ADMIN_B, "Bo"})
+	hub.Publish(a, StreamEvent{Type: STREAM_PARTNER_DELETED, ProjectId: "p"})
+
+	var names []string
+	for len(ca.frames) > 0 {
+		names = append(names, (<-ca.frames).name)
+	}
+	if strings.Join(names, ",") != STREAM_PRESENCE+","+STREAM_PARTNER_DELETED {
+		t.Fatal(names)
+	}
+	for len(cb.frames) > 0 {
+		if f := <-cb.frames; f.name != STREAM_PRESENCE || strings.Contains(string(f.data), "Ada") {
+			t.Fatal(f.name, string(f.data))
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// taskTransitions lists the statuses a task can move to from each status.
+// Done and cancelled tasks can only be reopened.
+var taskTransitions = map[string][]string{
+	STATUS_OPEN:        {STATUS_IN_PROGRESS, STATUS_BLOCKED, STATUS_DONE, STATUS_CANCELLED},
+	STATUS_IN_PROGRESS: {STATUS_OPEN, STATUS_BLOCKED, STATUS_DONE, STATUS_CANCELLED},
+	STATUS_BLOCKED:     {STATUS_OPEN, STATUS_IN_PROGRESS, STATUS_CANCELLED},
+	STATUS_DONE:        {STATUS_OPEN},
+	STATUS_CANCELLED:   {STATUS_OPEN},
+}
+
+// readTask decodes and validates a task. It responds itself if that fails.
+func readTask(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	var input Task
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Task{}, false
+	}
+	input.ProjectId = mux.Vars(r)["projectId"]
+	if input.Priority == "" {
+		input.Priority = PRIORITY_NORMAL
+	}
+	if errs := input.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Task{}, false
+	}
+	return input, true
+}
+
+func (s *Server) getTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := s.store.GetTasks(tenantOf(r), mux.Vars(r)["projectId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{tasks, "success"})
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	task, err := s.store.GetTask(tenantOf(r), vars["projectId"], vars["taskId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{task, "success"})
+}
+
+// addTask adds an open task.
+func (s *Server) addTask(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	input, ok := readTask(w, r)
+	if !ok {
+		return
+	}
+	input.Status = STATUS_OPEN
+	taskId, err := s.store.AddTask(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	task, err := s.store.GetTask(tenantOf(r), input.ProjectId, taskId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	s.taskAssigned(r, task, "")
+	JSON(w, http.StatusOK, Response{task, "success"})
+}
+
+func (s *Server) updateTask(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	taskId := mux.Vars(r)["taskId"]
+	input, ok := readTask(w, r)
+	if !ok {
+		return
+	}
+	previous, err := s.store.GetTask(tenantOf(r), input.ProjectId, taskId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	err = s.store.UpdateTask(tenantOf(r), input.ProjectId, taskId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	task, err := s.store.GetTask(tenantOf(r), input.ProjectId, taskId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.taskAssigned(r, task, previous.AssigneeId)
+
+	JSON(w, http.StatusOK, Response{task, "success"})
+}
+
+// taskAssigned tells the assignee of a task about it, unless the task was
+// assigned to them before.
+func (s *Server) taskAssigned(r *http.Request, task Task, previousAssignee string) {
+	if task.AssigneeId == "" || task.AssigneeId == previousAssignee {
+		return
+	}
+	user := context.Get(r, USER).(User)
+	s.notify(r, Event{
+		Kind:       NOTIFY_TASK_ASSIGNED,
+		ProjectId:  task.ProjectId,
+		Recipients: []string{task.AssigneeId},
+		Title:      "Task assigned: " + task.Title,
+		Body:       fmt.Sprintf("%s assigned you the task %q in %s.", user.FullName, task.Title, task.ProjectName),
+		Link:       "/projects/" + task.ProjectId + "/tasks/" + task.TaskId,
+	})
+}
+
+// updateTaskStatus moves a task along its workflow. Besides admins, the
+// assignee of a task may do so.
+func (s *Server) updateTaskStatus(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	vars := mux.Vars(r)
+	task, err := s.store.GetTask(tenantOf(r), vars["projectId"], vars["taskId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if user.IsAdmin == false && user.UserId != task.AssigneeId {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	status := r.FormValue("status")
+	if errs := validate(oneOf("status", status, taskTransitions[task.Status]...)); errs != nil {
+		errs["status"] = fmt.Sprintf("a task that is %s can only become %s", task.Status, strings.Join(taskTransitions[task.Status], ", "))
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+	err = s.store.SetTaskStatus(tenantOf(r), task.ProjectId, task.TaskId, task.Status, status)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	task, err = s.store.GetTask(tenantOf(r), task.ProjectId, task.TaskId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{task, "success"})
+}
+
+func (s *Server) deleteTask(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := s.store.DeleteTask(tenantOf(r), vars["projectId"], vars["taskId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+func (s *Server) getTaskComments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	comments, err := s.store.GetTaskComments(tenantOf(r), vars["projectId"], vars["taskId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{comments, "success"})
+}
+
+// addTaskComment lets any member of the organization comment on a task.
+func (s *Server) addTaskComment(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	vars := mux.Vars(r)
+	body := r.FormValue("body")
+	if errs := validate(
+		required("body", body),
+		maxLength("body", body, MAX_TEXT_LENGTH),
+	); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return
+	}
+
+	commentId, err := s.store.AddTaskComment(tenantOf(r), user.UserId, vars["projectId"], vars["taskId"], body)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{TaskComment{
+		CommentId:  commentId,
+		TaskId:     vars["taskId"],
+		AuthorId:   user.UserId,
+		AuthorName: user.FullName,
+		Body:       body,
+		TsCreated:  time.Now(),
+	}, "success"})
+}
+
+// getMyTasks returns the open tasks assigned to the caller.
+func (s *Server) getMyTasks(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	tasks, err := s.store.GetAssignedTasks(tenantOf(r), user.UserId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{tasks, "success"})
+}
+
+// getOverdueTasks returns the open tasks of the organization that were due
+// before today (UTC).
+func (s *Server) getOverdueTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := s.store.GetOverdueTasks(tenantOf(r), time.Now().UTC().Format("2006-01-02"))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{tasks, "success"})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// addTestMarker adds a project of organization A with a progress marker,
+// a challenge and a strategy and returns their IDs.
+func addTestMarker(t *testing.T, s Store) (projectId, markerId, challengeId, strategyId string) {
+	t.Helper()
+	a := Tenant{ORG_A}
+	projectId, err := s.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	partnerId, err := s.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Farmers"})
+	check(t, err)
+	markerId, err = s.AddProgressMarker(a, projectId, partnerId, ProgressMarker{Title: "Attend meetings", Type: MARKER_EXPECT})
+	check(t, err)
+	challengeId, err = s.AddChallenge(a, projectId, markerId, Challenge{ChallengeName: "Distance"})
+	check(t, err)
+	strategyId, err = s.AddStrategy(a, projectId, markerId, Strategy{StrategyName: "Transport"})
+	check(t, err)
+	return projectId, markerId, challengeId, strategyId
+}
+
+// TestTaskWorkflow walks a task through its statuses as the admin who
+// manages it and the member it is assigned to.
+func TestTaskWorkflow(t *testing.T) {
+	ts := newTestServer(t)
+	admin, member := ts.login("ada@a.org"), ts.login("ann@a.org")
+	projectId, markerId, challengeId, strategyId := addTestMarker(t, ts.store)
+	tasksPath := "/projects/" + projectId + "/tasks"
+
+	for _, test := range []struct {
+		name, body, field string
+	}{
+		{"nothing to be on", `{"title":"t"}`, "progress_marker_id"},
+		{"on two things", `{"title":"t","progress_marker_id":"` + markerId + `","strategy_id":"` + strategyId + `"}`, "progress_marker_id"},
+		{"no title", `{"challenge_id":"` + challengeId + `"}`, "title"},
+		{"bad due date", `{"title":"t","challenge_id":"` + challengeId + `","due_on":"15.06.2017"}`, "due_on"},
+		{"bad priority", `{"title":"t","challenge_id":"` + challengeId + `","priority":"asap"}`, "priority"},
+		{"assignee of another organization", `{"title":"t","challenge_id":"` + challengeId + `","assignee_id":"` + ADMIN_B + `"}`, "assignee_id"},
+	} {
+		code, out := ts.do(admin, "POST", tasksPath, "application/json", test.body)
+		if errs, ok := out["data"].(map[string]interface{}); code != http.StatusBadRequest || !ok || errs[test.field] == nil {
+			t.Errorf("%s: %d %v", test.name, code, out)
+		}
+	}
+
+	add := func(key, body string) (int, map[string]interface{}) {
+		t.Helper()
+		code, out := ts.do(key, "POST", tasksPath, "application/json", body)
+		task, _ := out["data"].(map[string]interface{})
+		return code, task
+	}
+	if code, task := add(member, `{"title":"t","challenge_id":"`+challengeId+`"}`); code != http.StatusForbidden {
+		t.Fatal("a member added a task", task)
+	}
+	code, task := add(admin, `{"title":"Arrange a bus","challenge_id":"`+challengeId+`","assignee_id":"`+MEMBER_A+`","due_on":"2017-06-15"}`)
+	if code != http.StatusOK || task["status"] != STATUS_OPEN || task["priority"] != PRIORITY_NORMAL ||
+		task["assignee_name"] != "Ann" || task["project_name"] != "A" {
+		t.Fatal(code, task)
+	}
+	taskPath := tasksPath + "/" + task["task_id"].(string)
+
+	// the assignee moves the task along, but only where the workflow allows
+	for _, step := range []struct {
+		key    string
+		status string
+		code   int
+	}{
+		{member, STATUS_IN_PROGRESS, http.StatusOK},
+		{member, STATUS_BLOCKED, http.StatusOK},
+		{member, STATUS_DONE, http.StatusBadRequest},
+		{member, STATUS_CANCELLED, http.StatusOK},
+		{member, STATUS_IN_PROGRESS, http.StatusBadRequest},
+		{admin, STATUS_OPEN, http.StatusOK},
+		{member, STATUS_DONE, http.StatusOK},
+		{member, STATUS_DONE, http.StatusBadRequest},
+		{member, "finished", http.StatusBadRequest},
+	} {
+		code, out := ts.do(step.key, "POST", taskPath+"/status", form, "status="+step.status)
+		if code != step.code {
+			t.Fatalf("to %s: %d %v", step.status, code, out)
+		}
+		if code == http.StatusOK && out["data"].(map[string]interface{})["status"] != step.status {
+			t.Fatalf("to %s: %v", step.status, out)
+		}
+	}
+
+	// a member can not move a task assigned to someone else, nor edit or
+	// delete one
+	code, other := add(admin, `{"title":"Ask the council","progress_marker_id":"`+markerId+`","priority":"urgent","due_on":"2017-06-01"}`)
+	if code != http.StatusOK {
+		t.Fatal(code, other)
+	}
+	otherPath := tasksPath + "/" + other["task_id"].(string)
+	if code, out := ts.do(member, "POST", otherPath+"/status", form, "status="+STATUS_DONE); code != http.StatusForbidden {
+		t.Fatal(code, out)
+	}
+	if code, out := ts.do(member, "POST", otherPath, "application/json", `{"title":"x","progress_marker_id":"`+markerId+`"}`); code != http.StatusForbidden {
+		t.Fatal(code, out)
+	}
+	if code, out := ts.do(member, "DELETE", otherPath, "", ""); code != http.StatusForbidden {
+		t.Fatal(code, out)
+	}
+
+	// editing keeps the status
+	code, out := ts.do(admin, "POST", taskPath, "application/json", `{"title":"Arrange two buses","strategy_id":"`+strategyId+`","priority":"high"}`)
+	if edited := out["data"].(map[string]interface{}); code != http.StatusOK || edited["status"] != STATUS_DONE ||
+		edited["strategy_id"] != strategyId || edited["challenge_id"] != "" || edited["assignee_id"] != "" {
+		t.Fatal(code, out)
+	}
+
+	// any member may comment
+	if code, out := ts.do(member, "POST", otherPath+"/comments", form, "body="); code != http.StatusBadRequest {
+		t.Fatal(code, out)
+	}
+	if code, out := ts.do(member, "POST", otherPath+"/comments", form, "body=The+council+meets+on+Friday"); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(admin, "GET", otherPath+"/comments", "", "")
+	comments := out["data"].([]interface{})
+	if code != http.StatusOK || len(comments) != 1 ||
+		comments[0].(map[string]interface{})["author_name"] != "Ann" {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.do(admin, "GET", otherPath, "", ""); out["data"].(map[string]interface{})["comments"] != 1.0 {
+		t.Fatal(code, out)
+	}
+}
+
+// TestTaskLists checks which open tasks the assigned and overdue lists
+// return and in which order.
+func TestTaskLists(t *testing.T) {
+	s := newTestMemoryStore(t)
+	a := Tenant{ORG_A}
+	projectId, markerId, challengeId, strategyId := addTestMarker(t, s)
+	today := time.Now().UTC()
+	day := func(days int) string { return today.AddDate(0, 0, days).Format("2006-01-02") }
+
+	add := func(title, assignee, dueOn, priority, status string) {
+		t.Helper()
+		_, err := s.AddTask(a, ADMIN_A, Task{ProjectId: projectId, StrategyId: strategyId, Title: title, AssigneeId: assignee,
+			DueOn: dueOn, Priority: priority, Status: status})
+		check(t, err)
+	}
+	add("no due date", MEMBER_A, "", PRIORITY_URGENT, STATUS_OPEN)
+	add("tomorrow", MEMBER_A, day(1), PRIORITY_LOW, STATUS_IN_PROGRESS)
+	add("yesterday, normal", MEMBER_A, day(-1), PRIORITY_NORMAL, STATUS_BLOCKED)
+	add("yesterday, urgent", MEMBER_A, day(-1), PRIORITY_URGENT, STATUS_OPEN)
+	add("last week, done", MEMBER_A, day(-7), PRIORITY_HIGH, STATUS_DONE)
+	add("last week, cancelled", ADMIN_A, day(-7), PRIORITY_HIGH, STATUS_CANCELLED)
+	add("last week, admin", ADMIN_A, day(-7), PRIORITY_LOW, STATUS_OPEN)
+	// a task of organization B is never listed
+	b := Tenant{ORG_B}
+	projectB, err := s.AddProject(b, Project{ProjectName: "B"})
+	check(t, err)
+	partnerB, err := s.AddBoundaryPartner(b, projectB, BoundaryPartner{PartnerName: "BP"})
+	check(t, err)
+	markerB, err := s.AddProgressMarker(b, projectB, partnerB, ProgressMarker{Title: "m", Type: MARKER_EXPECT})
+	check(t, err)
+	_, err = s.AddTask(b, ADMIN_B, Task{ProjectId: projectB, ProgressMarkerId: markerB, Title: "B", DueOn: day(-3),
+		Priority: PRIORITY_NORMAL, Status: STATUS_OPEN})
+	check(t, err)
+
+	titles := func(tasks []Task, err error) []string {
+		t.Helper()
+		check(t, err)
+		var titles []string
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return titles
+	}
+	mine := titles(s.GetAssignedTasks(a, MEMBER_A))
+	if want := []string{"yesterday, urgent", "yesterday, normal", "tomorrow", "no due date"}; !equalStrings(mine, want) {
+		t.Errorf("assigned: %q", mine)
+	}
+	overdue := titles(s.GetOverdueTasks(a, day(0)))
+	if want := []string{"last week, admin", "yesterday, urgent", "yesterday, normal"}; !equalStrings(overdue, want) {
+		t.Errorf("overdue: %q", overdue)
+	}
+
+	// a stale status is not overwritten
+	tasks, err := s.GetTasks(a, projectId)
+	check(t, err)
+	if err := s.SetTaskStatus(a, projectId, tasks[0].TaskId, STATUS_IN_PROGRESS, STATUS_DONE); err == nil {
+		t.Fatal("moved a task from a status it was not in")
+	}
+
+	// tasks go with what they are on
+	_, err = s.AddTask(a, ADMIN_A, Task{ProjectId: projectId, ChallengeId: challengeId, Title: "on the challenge",
+		Priority: PRIORITY_NORMAL, Status: STATUS_OPEN})
+	check(t, err)
+	check(t, s.DeleteProgressMarker(a, projectId, markerId))
+	if tasks := titles(s.GetTasks(a, projectId)); len(tasks) != 0 {
+		t.Fatalf("tasks outlived their progress marker: %q", tasks)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// Tenant is the organization on whose behalf a request is served. All
+// project data is read and written through Store methods taking a Tenant,
+// and those methods constrain every query by the tenant's organization_id.
+type Tenant struct {
+	OrganizationId string
+}
+
+func tenantOf(r *http.Request) Tenant {
+	user := context.Get(r, USER).(User)
+	return Tenant{user.OrganizationId}
+}
+
+// respondError maps a data access error to an HTTP response.
+func respondError(w http.ResponseWriter, err error) {
+	if errs, ok := err.(FieldErrors); ok {
+		JSON(w, http.StatusBadRequest, Response{errs, errs.Error()})
+		return
+	}
+	if err == ErrNotFound {
+		JSON(w, http.StatusNotFound, Response{nil, err.Error()})
+		return
+	}
+	JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+}
+
+// checkOwnership responds with 404 unless the project in the URL belongs to the
+// caller's organization and every nested ID in the URL belongs to that project.
+// A project of another organization is reported as missing rather than
+// forbidden so that IDs of other tenants cannot be probed.
+func (s *Server) checkOwnership(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		field, err := s.store.CheckOwnership(tenantOf(r), mux.Vars(r))
+		if err != nil {
+			JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+			return
+		}
+		if field != "" {
+			JSON(w, http.StatusNotFound, Response{FieldErrors{field: "not found"}, "not found"})
+			return
+		}
+		fn(w, r)
+	}
+}
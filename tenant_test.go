@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// addForeignProject fills a project of organization B with one of everything
+// and returns their IDs by the route variable that takes them.
+func addForeignProject(t *testing.T, s Store) map[string]string {
+	b := Tenant{ORG_B}
+	ids := make(map[string]string)
+	var err error
+	ids["projectId"], err = s.AddProject(b, Project{ProjectName: "B"})
+	check(t, err)
+	ids["partnerId"], err = s.AddBoundaryPartner(b, ids["projectId"], BoundaryPartner{PartnerName: "BP"})
+	check(t, err)
+	ids["progressMarkerId"], err = s.AddProgressMarker(b, ids["projectId"], ids["partnerId"], ProgressMarker{Title: "m", Type: MARKER_EXPECT})
+	check(t, err)
+	ids["challengeId"], err = s.AddChallenge(b, ids["projectId"], ids["progressMarkerId"], Challenge{ChallengeName: "c"})
+	check(t, err)
+	ids["strategyId"], err = s.AddStrategy(b, ids["projectId"], ids["progressMarkerId"], Strategy{StrategyName: "s"})
+	check(t, err)
+	ids["journalId"], err = s.AddJournal(b, ADMIN_B, OutcomeJournal{ProjectId: ids["projectId"], BoundaryPartnerId: ids["partnerId"],
+		MonitoringDate: "2017-06-30", Ratings: []JournalRating{{ids["progressMarkerId"], RATING_MEDIUM}}})
+	check(t, err)
+	fileKey := blobKey(ORG_B, strings.Repeat("a", 64))
+	ids["resourceId"], err = s.AddExternalResource(b, ADMIN_B, ExternalResources{ProjectId: ids["projectId"], ResourceType: RESOURCE_FILE,
+		ResourceName: "r.txt", Size: 1, SHA256: blobSHA256(fileKey), ContentType: "text/plain", StorageKey: fileKey, JournalId: ids["journalId"]})
+	check(t, err)
+	ids["budgetLineId"], err = s.AddBudgetLine(b, ADMIN_B, BudgetLine{ProjectId: ids["projectId"], Title: "Radio time",
+		Amount: 1000000, Currency: "EUR", ProjectAmount: 1000000, BoundaryPartnerId: ids["partnerId"]})
+	check(t, err)
+	ids["expenditureId"], err = s.AddExpenditure(b, ADMIN_B, Expenditure{ProjectId: ids["projectId"], BudgetLineId: ids["budgetLineId"],
+		Description: "Spot", Amount: 500000, Currency: "EUR", ProjectAmount: 500000, SpentOn: "2017-05-02", ReceiptResourceId: ids["resourceId"]})
+	check(t, err)
+	ids["donorId"], err = s.AddDonor(b, Donor{DonorName: "Fund B"})
+	check(t, err)
+	ids["grantId"], err = s.AddGrant(b, ADMIN_B, Grant{ProjectId: ids["projectId"], DonorId: ids["donorId"], Currency: "EUR",
+		Deadlines: []GrantDeadline{}})
+	check(t, err)
+	ids["activityId"], err = s.AddActivity(b, ADMIN_B, Activity{ProjectId: ids["projectId"], Kind: ACTIVITY_MILESTONE, Title: "Launch",
+		StartsOn: "2017-06-01", EndsOn: "2017-06-01", Status: STATUS_PLANNED, StrategyId: ids["strategyId"]})
+	check(t, err)
+	check(t, s.SetCalendarKey(b, ADMIN_B, ids["projectId"], "key-b"))
+	ids["taskId"], err = s.AddTask(b, ADMIN_B, Task{ProjectId: ids["projectId"], ChallengeId: ids["challengeId"], Title: "Follow up",
+		AssigneeId: ADMIN_B, DueOn: "2017-06-15", Priority: PRIORITY_HIGH, Status: STATUS_OPEN})
+	check(t, err)
+	_, err = s.AddTaskComment(b, ADMIN_B, ids["projectId"], ids["taskId"], "on it")
+	check(t, err)
+	ids["commentId"], err = s.AddComment(b, ADMIN_B, Comment{ProjectId: ids["projectId"], EntityType: ENTITY_PROGRESS_MARKER,
+		EntityId: ids["progressMarkerId"], Body: "@[Bo](" + ADMIN_B + ") please check", Mentions: []string{ADMIN_B}})
+	check(t, err)
+	ids["notificationId"], err = s.AddNotification(b, Notification{UserId: ADMIN_B, Kind: NOTIFY_MENTION, ProjectId: ids["projectId"],
+		Title: "Bo mentioned you", InApp: true, Email: EMAIL_DAILY})
+	check(t, err)
+	ids["webhookId"], err = s.AddWebhook(b, ADMIN_B, Webhook{Url: "https://b.example.org/hook", Events: []string{EVENT_PROJECT_UPDATED},
+		Active: true, Secret: "secret-b"})
+	check(t, err)
+	nextAttempt := time.Now().Add(time.Hour)
+	ids["deliveryId"], err = s.AddDelivery(b, WebhookDelivery{WebhookId: ids["webhookId"], Event: EVENT_PROJECT_UPDATED,
+		Payload: json.RawMessage(`{"event":"project.updated"}`), Status: DELIVERY_PENDING, NextAttemptAt: &nextAttempt})
+	check(t, err)
+	ids["reminderKey"] = "journal_overdue:" + ids["journalId"] + ":2017-06-30"
+	_, err = s.MarkReminderSent(b, ids["reminderKey"])
+	check(t, err)
+	ids["userId"] = ADMIN_B
+	logoKey := blobKey(ORG_B, strings.Repeat("b", 64))
+	_, err = s.SetProjectLogo(b, ids["projectId"], &ProjectLogo{OriginalKey: logoKey, MediumKey: logoKey, ThumbnailKey: logoKey,
+		ContentType: "image/png", Width: 1, Height: 1})
+	check(t, err)
+	return ids
+}
+
+// foreignRows names a table with a row of addForeignProject, the column that
+// finds it and the ID it has there.
+var foreignRows = []struct {
+	table, column, id string
+}{
+	{"projects", "project_id", "projectId"},
+	{"boundary_partners", "boundary_partner_id", "partnerId"},
+	{"progress_markers", "progress_marker_id", "progressMarkerId"},
+	{"challenges", "challenge_id", "challengeId"},
+	{"strategies", "strategy_id", "strategyId"},
+	{"external_resources", "resource_id", "resourceId"},
+	{"resource_versions", "resource_id", "resourceId"},
+	{"outcome_journals", "journal_id", "journalId"},
+	{"journal_ratings", "journal_id", "journalId"},
+	{"budget_lines", "budget_line_id", "budgetLineId"},
+	{"expenditures", "expenditure_id", "expenditureId"},
+	{"donors", "donor_id", "donorId"},
+	{"grants", "grant_id", "grantId"},
+	{"activities", "activity_id", "activityId"},
+	{"calendar_links", "project_id", "projectId"},
+	{"tasks", "task_id", "taskId"},
+	{"task_comments", "task_id", "taskId"},
+	{"comments", "comment_id", "commentId"},
+	{"comment_mentions", "comment_id", "commentId"},
+	{"notifications", "notification_id", "notificationId"},
+	{"sent_reminders", "reminder_key", "reminderKey"},
+	{"webhooks", "webhook_id", "webhookId"},
+	{"webhook_deliveries", "delivery_id", "deliveryId"},
+}
+
+// tenantSnapshot returns everything organization B has as JSON, to find out
+// whether a request changed any of it.
+func tenantSnapshot(t *testing.T, s Store, ids map[string]string) string {
+	t.Helper()
+	b := Tenant{ORG_B}
+	var snapshot []interface{}
+	add := func(v interface{}, err error) {
+		t.Helper()
+		check(t, err)
+		snapshot = append(snapshot, v)
+	}
+	add(s.GetProjects(b))
+	add(s.GetBoundaryPartner(b, ids["projectId"], ids["partnerId"]))
+	add(s.GetExternalResources(b, ids["projectId"]))
+	add(s.GetResourceVersions(b, ids["projectId"], ids["resourceId"]))
+	add(s.GetJournals(b, ids["projectId"], ""))
+	add(s.GetBudgetLines(b, ids["projectId"]))
+	add(s.GetExpenditures(b, ids["projectId"]))
+	add(s.GetDonors(b))
+	add(s.GetGrants(b, ids["projectId"]))
+	add(s.GetGrantOfficers(b, ids["donorId"]))
+	add(s.GetActivities(b, ids["projectId"]))
+	add(s.GetCalendarKey(b, ids["projectId"]))
+	add(s.GetTasks(b, ids["projectId"]))
+	add(s.GetTaskComments(b, ids["projectId"], ids["taskId"]))
+	add(s.GetComments(b, ids["projectId"], "", ""))
+	add(s.GetNotifications(b, ADMIN_B, false, 10))
+	add(s.GetWebhooks(b))
+	add(s.GetDeliveries(b, ids["webhookId"], 10))
+	add(s.GetUnmailedNotifications(b))
+	out, err := json.Marshal(snapshot)
+	check(t, err)
+	return string(out)
+}
+
+// unscopedRoutes are not authorized by an API key and so have no
+// organization of the caller to check against, with where their tokens are
+// tested instead.
+var unscopedRoutes = map[string]string{
+	"/shared/resources/{token}":     "signed share link, see TestSharedResource",
+	"/shared/calendars/{token}.ics": "signed calendar link, see TestCalendarLink",
+}
+
+// valueVariables are route variables that pick a value instead of a row,
+// with the value to use. A route with nothing but these is scoped by the
+// organization of the caller and not checked here.
+var valueVariables = map[string]string{
+	"version": "1",
+	"variant": "original",
+	"format":  REPORT_HTML,
+}
+
+var routeVariable = regexp.MustCompile(`{([^}:]+)(:[^}]+)?}`)
+
+type crossTenantRequest struct {
+	method, path string
+}
+
+// crossTenantRequests walks the routes of the router and returns a request
+// for every route that takes IDs, with all of them taken from organization
+// B, and another with the project of organization A and the nested IDs of
+// organization B. A route variable without an ID in ids or a value in
+// valueVariables fails the test, so a new route can not go unchecked unless
+// it is listed in unscopedRoutes.
+func crossTenantRequests(t *testing.T, router *mux.Router, projectA string, ids map[string]string) []crossTenantRequest {
+	var requests []crossTenantRequest
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{GET}
+		}
+		var names []string
+		foreign := make(map[string]string)
+		for _, match := range routeVariable.FindAllStringSubmatch(template, -1) {
+			if value, ok := valueVariables[match[1]]; ok {
+				foreign[match[1]] = value
+			} else {
+				names = append(names, match[1])
+			}
+		}
+		if len(names) == 0 || unscopedRoutes[template] != "" {
+			return nil
+		}
+		for _, name := range names {
+			id, ok := ids[name]
+			if !ok {
+				t.Errorf("%s: no ID of organization B for {%s}", template, name)
+				return nil
+			}
+			foreign[name] = id
+		}
+		variants := []map[string]string{foreign}
+		if _, ok := foreign["projectId"]; ok && len(foreign) > 1 {
+			nested := make(map[string]string)
+			for name, id := range foreign {
+				nested[name] = id
+			}
+			nested["projectId"] = projectA
+			variants = append(variants, nested)
+		}
+		for _, vars := range variants {
+			var pairs []string
+			for name, id := range vars {
+				pairs = append(pairs, name, id)
+			}
+			u, err := route.URLPath(pairs...)
+			if err != nil {
+				return err
+			}
+			for _, method := range methods {
+				requests = append(requests, crossTenantRequest{method, u.Path})
+			}
+		}
+		return nil
+	})
+	check(t, err)
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].path+requests[i].method < requests[j].path+requests[j].method
+	})
+	return requests
+}
+
+// TestCrossTenantRoutes sends the admin of organization A to every route
+// with the IDs of organization B. Each request has to be answered with 404
+// and leave organization B as it was.
+func TestCrossTenantRoutes(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) Store{
+		"memory":   newTestMemoryStore,
+		"postgres": newTestPostgresStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestServerOn(t, newStore(t))
+			key := ts.login("ada@a.org")
+			projectA, err := ts.store.AddProject(Tenant{ORG_A}, Project{ProjectName: "A"})
+			check(t, err)
+			ids := addForeignProject(t, ts.store)
+
+			before := tenantSnapshot(t, ts.store, ids)
+			for _, test := range crossTenantRequests(t, ts.Router(), projectA, ids) {
+				if code, out := ts.do(key, test.method, test.path, "", ""); code != http.StatusNotFound {
+					t.Errorf("%s %s: got %d %v", test.method, test.path, code, out)
+				}
+				if after := tenantSnapshot(t, ts.store, ids); after != before {
+					t.Fatalf("%s %s changed organization B:\n%s\n%s", test.method, test.path, before, after)
+				}
+			}
+		})
+	}
+}
+
+// sharedTables hold rows of every organization and have no row level
+// security.
+var sharedTables = map[string]bool{
+	"organizations":     true,
+	"users":             true, // read by login, before there is a tenant
+	"schema_migrations": true,
+	"jobs":              true, // runs of the background jobs, which go through every organization
+}
+
+// TestRowLevelSecurity checks that the policies are forced on every table
+// with tenant data, and then looks for the rows of organization B with
+// queries that do not filter by organization in transactions of
+// organization A.
+func TestRowLevelSecurity(t *testing.T) {
+	store := newTestPostgresStore(t)
+	pg := store.(*pgStore).db
+	_, err := store.AddProject(Tenant{ORG_A}, Project{ProjectName: "A"})
+	check(t, err)
+	ids := addForeignProject(t, store)
+
+	var tables []struct {
+		Name    string `db:"relname"`
+		Enabled bool   `db:"relrowsecurity"`
+		Forced  bool   `db:"relforcerowsecurity"`
+	}
+	check(t, pg.Select(&tables, `
+		SELECT relname, relrowsecurity, relforcerowsecurity FROM pg_class
+		WHERE relnamespace = 'public' :: REGNAMESPACE AND relkind = 'r'`))
+	for _, table := range tables {
+		if !sharedTables[table.Name] && !(table.Enabled && table.Forced) {
+			t.Errorf("%s: row level security is not forced", table.Name)
+		}
+	}
+
+	// superusers and roles with BYPASSRLS are exempt from the policies, so
+	// the queries run as a role that is not
+	var exempt bool
+	check(t, pg.Get(&exempt, "SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user"))
+	if exempt {
+		pg.MustExec("DO $$ BEGIN CREATE ROLE lucid_tenant; EXCEPTION WHEN duplicate_object THEN NULL; END $$")
+		pg.MustExec("GRANT USAGE ON SCHEMA public TO lucid_tenant")
+		pg.MustExec("GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO lucid_tenant")
+	}
+	asTenant := func(organizationId string, fn func(tx *sqlx.Tx)) {
+		t.Helper()
+		tx, err := pg.Beginx()
+		check(t, err)
+		defer tx.Rollback()
+		if exempt {
+			tx.MustExec("SET LOCAL ROLE lucid_tenant")
+		}
+		tx.MustExec("SELECT set_config('lucid.organization_id', $1, TRUE)", organizationId)
+		fn(tx)
+	}
+	count := func(tx *sqlx.Tx, query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		check(t, tx.Get(&n, query, args...))
+		return n
+	}
+	affected := func(tx *sqlx.Tx, query string, args ...interface{}) int64 {
+		t.Helper()
+		res, err := tx.Exec(query, args...)
+		check(t, err)
+		n, err := res.RowsAffected()
+		check(t, err)
+		return n
+	}
+
+	for _, row := range foreignRows {
+		table, column, id := row.table, row.column, ids[row.id]
+		where := " WHERE " + column + " = $1"
+		asTenant(ORG_B, func(tx *sqlx.Tx) {
+			if count(tx, "SELECT count(*) FROM "+table+where, id) != 1 {
+				t.Errorf("%s: organization B does not see its row", table)
+			}
+		})
+		asTenant(ORG_A, func(tx *sqlx.Tx) {
+			if count(tx, "SELECT count(*) FROM "+table+where, id) != 0 {
+				t.Errorf("%s: organization A sees a row of B", table)
+			}
+			if affected(tx, "UPDATE "+table+" SET "+column+" = "+column+where, id) != 0 {
+				t.Errorf("%s: organization A updated a row of B", table)
+			}
+			if affected(tx, "DELETE FROM "+table+where, id) != 0 {
+				t.Errorf("%s: organization A deleted a row of B", table)
+			}
+		})
+		asTenant("", func(tx *sqlx.Tx) {
+			if count(tx, "SELECT count(*) FROM "+table) != 0 {
+				t.Errorf("%s: rows visible without an organization", table)
+			}
+		})
+	}
+
+	asTenant(ORG_A, func(tx *sqlx.Tx) {
+		_, err := tx.Exec("INSERT INTO boundary_partners (boundary_partner_id, project_id, partner_name) VALUES ($1, $2, 'planted')",
+			"cccccccc-0000-4000-8000-00000000000c", ids["projectId"])
+		if err == nil || !strings.Contains(err.Error(), "row-level security") {
+			t.Errorf("organization A added a partner to a project of B: %v", err)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// form fields of an upload besides the file are read into memory up to
+// this size, larger files are spooled to disk by ParseMultipartForm
+const MULTIPART_MEMORY = 1 << 20
+
+// receiveFile parses a multipart upload and returns the file in field. The
+// request body is capped so that an oversized upload is rejected while it is
+// read instead of after it was written to disk. It writes the error response
+// and returns false if the upload is not acceptable.
+func (s *Server) receiveFile(w http.ResponseWriter, r *http.Request, field string) (multipart.File, *multipart.FileHeader, bool) {
+	maxSize := s.config.Uploads.MaxFileSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+MULTIPART_MEMORY)
+	if err := r.ParseMultipartForm(MULTIPART_MEMORY); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			JSON(w, http.StatusRequestEntityTooLarge, Response{FieldErrors{field: fileTooLarge(maxSize)}, "validation failed"})
+		} else {
+			JSON(w, http.StatusBadRequest, Response{nil, "request must be multipart/form-data"})
+		}
+		return nil, nil, false
+	}
+
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{field: "must be a file"}, "validation failed"})
+		return nil, nil, false
+	}
+	if header.Size > maxSize {
+		file.Close()
+		JSON(w, http.StatusRequestEntityTooLarge, Response{FieldErrors{field: fileTooLarge(maxSize)}, "validation failed"})
+		return nil, nil, false
+	}
+	return file, header, true
+}
+
+func fileTooLarge(maxSize int64) string {
+	return "must be at most " + formatBytes(maxSize)
+}
+
+// formatBytes formats a size for messages, like "10 MiB".
+func formatBytes(n int64) string {
+	units := []string{"bytes", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && n%1024 == 0 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return strconv.FormatInt(n, 10) + " " + units[i]
+}
+
+// sniffContentType detects the media type of a file from its first bytes and
+// rewinds it. The result has no parameters, "text/plain; charset=utf-8"
+// becomes "text/plain".
+func sniffContentType(file io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+func (s *Server) allowedType(contentType string) bool {
+	for _, allowed := range s.config.Uploads.AllowedTypes {
+		if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkQuota reports whether an organization can store size more bytes.
+func (s *Server) checkQuota(t Tenant, size int64) (bool, error) {
+	used, quota, err := s.store.StorageUsage(t)
+	if err != nil {
+		return false, err
+	}
+	if quota < 0 {
+		quota = s.config.Uploads.OrgQuota
+	}
+	return used+size <= quota, nil
+}
+
+// storedUpload is an uploaded file that passed the checks and was stored.
+// Its blob is pinned until unpin is called.
+type storedUpload struct {
+	BlobInfo
+	ContentType string
+	ScanStatus  string
+	unpin       func()
+}
+
+// storeUpload runs an uploaded file through the type allowlist, the quota
+// and the virus scanner and puts it in the blob store. It writes the error
+// response and returns false if the file is not accepted. The caller unpins
+// the blob once it is referenced, or before it releases it.
+func (s *Server) storeUpload(w http.ResponseWriter, t Tenant, file io.ReadSeeker, name string, size int64, field string) (storedUpload, bool) {
+	contentType, err := sniffContentType(file)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return storedUpload{}, false
+	}
+	if !s.allowedType(contentType) {
+		JSON(w, http.StatusUnsupportedMediaType, Response{FieldErrors{field: contentType + " files are not allowed"}, "validation failed"})
+		return storedUpload{}, false
+	}
+	ok, err := s.checkQuota(t, size)
+	if err != nil {
+		respondError(w, err)
+		return storedUpload{}, false
+	}
+	if !ok {
+		JSON(w, http.StatusRequestEntityTooLarge, Response{nil, "the organization's storage quota is used up"})
+		return storedUpload{}, false
+	}
+	status, err := s.scanFile(file, name)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return storedUpload{}, false
+	}
+
+	blob, unpin, err := s.putPinned(t, file)
+	if err != nil {
+		JSON(w, http.StatusInternalServerError, Response{nil, err.Error()})
+		return storedUpload{}, false
+	}
+	return storedUpload{blob, contentType, status, unpin}, true
+}
+
+// respondScanStatus answers an upload according to the scan result. Only a
+// clean file is usable right away.
+func respondScanStatus(w http.ResponseWriter, status string, data interface{}) {
+	switch status {
+	case SCAN_QUARANTINED:
+		JSON(w, http.StatusUnprocessableEntity, Response{data, "the file was quarantined by the virus scanner"})
+	case SCAN_PENDING:
+		JSON(w, http.StatusAccepted, Response{data, "the file is waiting for a virus scan"})
+	default:
+		JSON(w, http.StatusOK, Response{data, "success"})
+	}
+}
+
+// scanFile runs the virus scanner over a file and rewinds it. A file the
+// scanner could not check stays pending, so it is never shown unscanned.
+func (s *Server) scanFile(file io.ReadSeeker, name string) (string, error) {
+	result, err := s.scanner.Scan(file)
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return "", seekErr
+	}
+	if err != nil {
+		errorf("scanning %s: %v", name, err)
+		return SCAN_PENDING, nil
+	}
+	if result.Infected {
+		warnf("quarantined %s: %s", name, result.Signature)
+		return SCAN_QUARANTINED, nil
+	}
+	return SCAN_CLEAN, nil
+}
+
+// rescanPending runs the virus scanner again over the files it could not
+// check on upload.
+func (s *Server) rescanPending(now time.Time) error {
+	return s.forEachOrganization(func(t Tenant) error {
+		_, err := s.rescanTenant(t)
+		return err
+	})
+}
+
+// rescanTenant scans the pending files of a tenant and returns how many of
+// them ended up clean, quarantined or still pending. A file found clean
+// becomes visible and is published to the webhooks. The scan stops at the first file the scanner still can
+// not check, the others would most likely fail as well.
+func (s *Server) rescanTenant(t Tenant) (map[string]int, error) {
+	counts := map[string]int{SCAN_CLEAN: 0, SCAN_QUARANTINED: 0, SCAN_PENDING: 0}
+	pending, err := s.store.GetPendingScans(t)
+	if err != nil {
+		return counts, err
+	}
+	for i, p := range pending {
+		status, err := s.rescan(p)
+		if err == ErrNotFound {
+			warnf("rescanning version %d of resource %s: the stored file is missing", p.VersionNumber, p.ResourceId)
+			continue
+		}
+		if err != nil {
+			return counts, err
+		}
+		if status == SCAN_PENDING {
+			counts[SCAN_PENDING] += len(pending) - i
+			break
+		}
+		err = s.store.SetScanStatus(t, p.ResourceId, p.VersionNumber, status)
+		if err == ErrNotFound {
+			// deleted or scanned by another run meanwhile
+			continue
+		}
+		if err != nil {
+			return counts, err
+		}
+		counts[status]++
+		if status == SCAN_CLEAN {
+			s.publishScanned(t, p)
+		}
+	}
+	return counts, nil
+}
+
+// publishScanned publishes a version found clean by a rescan to the webhooks
+// as an upload of the resource, as if it had been clean on upload.
+func (s *Server) publishScanned(t Tenant, p PendingScan) {
+	resource, err := s.store.GetExternalResource(t, p.ProjectId, p.ResourceId)
+	if err != nil {
+		return
+	}
+	version, err := s.store.GetResourceVersion(t, p.ProjectId, p.ResourceId, p.VersionNumber)
+	if err != nil {
+		return
+	}
+	s.webhooks.Publish(t, EVENT_RESOURCE_UPLOADED, map[string]interface{}{"resource": resource, "version": version})
+}
+
+// rescan scans the stored file of a pending version.
+func (s *Server) rescan(p PendingScan) (string, error) {
+	blob, err := s.blobs.Get(p.StorageKey)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	return s.scanFile(blob, p.ResourceName)
+}
+
+// rescanResources lets an admin retry the pending files of the organization
+// right away, after the virus scanner is back.
+func (s *Server) rescanResources(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	counts, err := s.rescanTenant(tenantOf(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{counts, "success"})
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// maximum lengths of the VARCHAR/TEXT columns accepted from clients
+const (
+	MAX_NAME_LENGTH = 255
+	MAX_TEXT_LENGTH = 10000
+	MAX_URL_LENGTH  = 2048
+	MAX_TAG_LENGTH  = 50
+)
+
+// maximum number of tags on a resource
+const MAX_TAGS = 20
+
+// maximum number of reporting deadlines of a grant
+const MAX_GRANT_DEADLINES = 50
+
+// progress marker levels ("expect to see", "like to see", "love to see")
+const (
+	MARKER_EXPECT = 1
+	MARKER_LIKE   = 2
+	MARKER_LOVE   = 3
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// FieldErrors maps a JSON field name to a validation message for that field.
+type FieldErrors map[string]string
+
+// rule checks a single field and returns the field name and message if the check fails.
+type rule func() (field string, message string, ok bool)
+
+// validate runs all rules and collects the first failure of every field.
+// A nil result means the input is valid.
+func validate(rules ...rule) FieldErrors {
+	var errs FieldErrors
+	for _, check := range rules {
+		field, message, ok := check()
+		if ok {
+			continue
+		}
+		if errs == nil {
+			errs = FieldErrors{}
+		}
+		if _, exists := errs[field]; !exists {
+			errs[field] = message
+		}
+	}
+	return errs
+}
+
+func required(field, value string) rule {
+	return func() (string, string, bool) {
+		return field, "must not be empty", strings.TrimSpace(value) != ""
+	}
+}
+
+func maxLength(field, value string, max int) rule {
+	return func() (string, string, bool) {
+		return field, fmt.Sprintf("must be at most %d characters", max), utf8.RuneCountInString(value) <= max
+	}
+}
+
+func nonNegative(field string, value Amount) rule {
+	return func() (string, string, bool) {
+		return field, "must not be negative", value >= 0
+	}
+}
+
+func positive(field string, value Amount) rule {
+	return func() (string, string, bool) {
+		return field, "must be greater than zero", value > 0
+	}
+}
+
+func maxAmount(field string, value Amount) rule {
+	return func() (string, string, bool) {
+		return field, "must be less than " + MAX_AMOUNT.String(), value < MAX_AMOUNT && value > -MAX_AMOUNT
+	}
+}
+
+// currencyCode checks that a non-empty value is an ISO 4217 currency code.
+func currencyCode(field, value string) rule {
+	return func() (string, string, bool) {
+		return field, "must be an ISO 4217 currency code", value == "" || isCurrency(value)
+	}
+}
+
+// minorUnits checks that an amount has no more decimal places than its
+// currency. Unknown currencies are left to the currencyCode rule.
+func minorUnits(field string, value Amount, currency string) rule {
+	return func() (string, string, bool) {
+		decimals, ok := currencies[currency]
+		return field, fmt.Sprintf("must have at most %d decimal places in %s", decimals, currency), !ok || value.decimals() <= decimals
+	}
+}
+
+// dayOrder checks that the date in to does not precede the date in from.
+// Unparsable values are left to the date rule.
+func dayOrder(fromField, from, toField, to string) rule {
+	return func() (string, string, bool) {
+		tFrom, err1 := time.Parse("2006-01-02", from)
+		tTo, err2 := time.Parse("2006-01-02", to)
+		if err1 != nil || err2 != nil {
+			return toField, "", true
+		}
+		return toField, "must not be before " + fromField, !tTo.Before(tFrom)
+	}
+}
+
+// emailAddress checks that a non-empty value is a bare email address.
+func emailAddress(field, value string) rule {
+	return func() (string, string, bool) {
+		if value == "" {
+			return field, "", true
+		}
+		addr, err := mail.ParseAddress(value)
+		return field, "must be an email address", err == nil && addr.Address == value
+	}
+}
+
+func intRange(field string, value, min, max int) rule {
+	return func() (string, string, bool) {
+		return field, fmt.Sprintf("must be between %d and %d", min, max), value >= min && value <= max
+	}
+}
+
+// timestamp checks that a non-empty value is an RFC 3339 timestamp.
+func timestamp(field, value string) rule {
+	return func() (string, string, bool) {
+		if value == "" {
+			return field, "", true
+		}
+		_, err := time.Parse(time.RFC3339, value)
+		return field, "must be an RFC 3339 timestamp", err == nil
+	}
+}
+
+// dateOrder checks that the timestamp in to does not precede the timestamp in from.
+// Unparsable values are left to the timestamp rule.
+func dateOrder(fromField, from, toField, to string) rule {
+	return func() (string, string, bool) {
+		tFrom, err1 := time.Parse(time.RFC3339, from)
+		tTo, err2 := time.Parse(time.RFC3339, to)
+		if err1 != nil || err2 != nil {
+			return toField, "", true
+		}
+		return toField, "must not be before " + fromField, !tTo.Before(tFrom)
+	}
+}
+
+// date checks that a non-empty value is a date like 2006-01-02.
+func date(field, value string) rule {
+	return func() (string, string, bool) {
+		if value == "" {
+			return field, "", true
+		}
+		_, err := time.Parse("2006-01-02", value)
+		return field, "must be a date like 2006-01-02", err == nil
+	}
+}
+
+func oneOf(field, value string, allowed ...string) rule {
+	return func() (string, string, bool) {
+		for _, a := range allowed {
+			if value == a {
+				return field, "", true
+			}
+		}
+		return field, "must be one of " + strings.Join(allowed, ", "), false
+	}
+}
+
+// webURL checks that a non-empty value is an absolute http or https URL.
+func webURL(field, value string) rule {
+	return func() (string, string, bool) {
+		if value == "" {
+			return field, "", true
+		}
+		u, err := url.Parse(value)
+		ok := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		return field, "must be an http or https URL", ok
+	}
+}
+
+// hexColor checks that a non-empty value is a color in lower case #rrggbb
+// notation.
+func hexColor(field, value string) rule {
+	return func() (string, string, bool) {
+		return field, "must be a color like #2b6a8e", value == "" || colorPattern.MatchString(value)
+	}
+}
+
+func tagList(field string, tags []string) rule {
+	return func() (string, string, bool) {
+		if len(tags) > MAX_TAGS {
+			return field, fmt.Sprintf("must have at most %d tags", MAX_TAGS), false
+		}
+		for _, tag := range tags {
+			if utf8.RuneCountInString(tag) > MAX_TAG_LENGTH {
+				return field, fmt.Sprintf("tags must be at most %d characters", MAX_TAG_LENGTH), false
+			}
+		}
+		return field, "", true
+	}
+}
+
+// journalRatings checks that every rating names a progress marker once and
+// is one of the rating levels.
+func journalRatings(field string, ratings []JournalRating) rule {
+	return func() (string, string, bool) {
+		seen := make(map[string]bool)
+		for _, r := range ratings {
+			if !isUUID(r.ProgressMarkerId) {
+				return field, "progress_marker_id must be a UUID", false
+			}
+			if seen[r.ProgressMarkerId] {
+				return field, "must rate each progress marker at most once", false
+			}
+			seen[r.ProgressMarkerId] = true
+			if r.Rating != RATING_LOW && r.Rating != RATING_MEDIUM && r.Rating != RATING_HIGH {
+				return field, "rating must be one of low, medium, high", false
+			}
+		}
+		return field, "", true
+	}
+}
+
+// normalizeTags trims and lower-cases tags and drops empty and duplicate ones.
+func normalizeTags(tags []string) pq.StringArray {
+	normalized := pq.StringArray{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+func isUUID(value string) bool {
+	return uuidPattern.MatchString(value)
+}
+
+func (p *Project) Validate() FieldErrors {
+	return validate(
+		required("project_name", p.ProjectName),
+		maxLength("project_name", p.ProjectName, MAX_NAME_LENGTH),
+		maxLength("description", p.Description, MAX_TEXT_LENGTH),
+		nonNegative("budget", p.Budget),
+		maxAmount("budget", p.Budget),
+		currencyCode("currency", p.Currency),
+		maxLength("donor", p.Donor, MAX_NAME_LENGTH),
+		maxLength("vision", p.Vision, MAX_TEXT_LENGTH),
+		maxLength("mission", p.Mission, MAX_TEXT_LENGTH),
+		timestamp("timeline_from", p.TimelineFrom),
+		timestamp("timeline_to", p.TimelineTo),
+		dateOrder("timeline_from", p.TimelineFrom, "timeline_to", p.TimelineTo),
+	)
+}
+
+func (bp *BoundaryPartner) Validate() FieldErrors {
+	return validate(
+		required("partner_name", bp.PartnerName),
+		maxLength("partner_name", bp.PartnerName, MAX_NAME_LENGTH),
+		maxLength("outcome_statement", bp.OutcomeStatement, MAX_TEXT_LENGTH),
+	)
+}
+
+func (pm *ProgressMarker) Validate() FieldErrors {
+	return validate(
+		required("title", pm.Title),
+		maxLength("title", pm.Title, MAX_NAME_LENGTH),
+		intRange("type", pm.Type, MARKER_EXPECT, MARKER_LOVE),
+	)
+}
+
+func (c *Challenge) Validate() FieldErrors {
+	return validate(
+		required("challenge_name", c.ChallengeName),
+		maxLength("challenge_name", c.ChallengeName, MAX_NAME_LENGTH),
+	)
+}
+
+func (s *Strategy) Validate() FieldErrors {
+	return validate(
+		required("strategy_name", s.StrategyName),
+		maxLength("strategy_name", s.StrategyName, MAX_NAME_LENGTH),
+	)
+}
+
+func (exr *ExternalResources) Validate() FieldErrors {
+	rules := []rule{
+		oneOf("resource_type", exr.ResourceType, RESOURCE_FILE, RESOURCE_LINK),
+		maxLength("title", exr.Title, MAX_NAME_LENGTH),
+		maxLength("description", exr.Description, MAX_TEXT_LENGTH),
+		tagList("tags", exr.Tags),
+	}
+	if exr.ResourceType == RESOURCE_LINK {
+		rules = append(rules,
+			required("resource_url", exr.ResourceUrl),
+			maxLength("resource_url", exr.ResourceUrl, MAX_URL_LENGTH),
+			webURL("resource_url", exr.ResourceUrl),
+		)
+	}
+	return validate(rules...)
+}
+
+func (j *OutcomeJournal) Validate() FieldErrors {
+	return validate(
+		required("boundary_partner_id", j.BoundaryPartnerId),
+		required("monitoring_date", j.MonitoringDate),
+		date("monitoring_date", j.MonitoringDate),
+		maxLength("description_of_change", j.DescriptionOfChange, MAX_TEXT_LENGTH),
+		maxLength("contributing_factors", j.ContributingFactors, MAX_TEXT_LENGTH),
+		maxLength("sources_of_evidence", j.SourcesOfEvidence, MAX_TEXT_LENGTH),
+		maxLength("unanticipated_change", j.UnanticipatedChange, MAX_TEXT_LENGTH),
+		maxLength("lessons", j.Lessons, MAX_TEXT_LENGTH),
+		journalRatings("ratings", j.Ratings),
+	)
+}
+
+func (line *BudgetLine) Validate() FieldErrors {
+	return validate(
+		required("title", line.Title),
+		maxLength("title", line.Title, MAX_NAME_LENGTH),
+		maxLength("description", line.Description, MAX_TEXT_LENGTH),
+		nonNegative("amount", line.Amount),
+		maxAmount("amount", line.Amount),
+		required("currency", line.Currency),
+		currencyCode("currency", line.Currency),
+		minorUnits("amount", line.Amount, line.Currency),
+	)
+}
+
+func (e *Expenditure) Validate() FieldErrors {
+	return validate(
+		required("description", e.Description),
+		maxLength("description", e.Description, MAX_TEXT_LENGTH),
+		positive("amount", e.Amount),
+		maxAmount("amount", e.Amount),
+		required("currency", e.Currency),
+		currencyCode("currency", e.Currency),
+		minorUnits("amount", e.Amount, e.Currency),
+		required("spent_on", e.SpentOn),
+		date("spent_on", e.SpentOn),
+	)
+}
+
+func (d *Donor) Validate() FieldErrors {
+	return validate(
+		required("donor_name", d.DonorName),
+		maxLength("donor_name", d.DonorName, MAX_NAME_LENGTH),
+		maxLength("contact_name", d.ContactName, MAX_NAME_LENGTH),
+		maxLength("contact_email", d.ContactEmail, MAX_NAME_LENGTH),
+		emailAddress("contact_email", d.ContactEmail),
+		maxLength("website", d.Website, MAX_URL_LENGTH),
+		webURL("website", d.Website),
+		maxLength("notes", d.Notes, MAX_TEXT_LENGTH),
+	)
+}
+
+// Validate checks a grant apart from its donor, which is given by ID through
+// the API and by name in exports.
+func (g *Grant) Validate() FieldErrors {
+	errs := validate(
+		maxLength("reference", g.Reference, MAX_NAME_LENGTH),
+		nonNegative("amount", g.Amount),
+		maxAmount("amount", g.Amount),
+		required("currency", g.Currency),
+		currencyCode("currency", g.Currency),
+		minorUnits("amount", g.Amount, g.Currency),
+		date("period_from", g.PeriodFrom),
+		date("period_to", g.PeriodTo),
+		dayOrder("period_from", g.PeriodFrom, "period_to", g.PeriodTo),
+		maxLength("reporting_requirements", g.ReportingRequirements, MAX_TEXT_LENGTH),
+	)
+	if len(g.Deadlines) > MAX_GRANT_DEADLINES {
+		return nestErrors(errs, "", FieldErrors{"deadlines": fmt.Sprintf("must have at most %d deadlines", MAX_GRANT_DEADLINES)})
+	}
+	for i, deadline := range g.Deadlines {
+		errs = nestErrors(errs, fmt.Sprintf("deadlines[%d].", i), validate(
+			required("due_on", deadline.DueOn),
+			date("due_on", deadline.DueOn),
+			maxLength("description", deadline.Description, MAX_NAME_LENGTH),
+		))
+	}
+	return errs
+}
+
+func (a *Activity) Validate() FieldErrors {
+	rules := []rule{
+		oneOf("kind", a.Kind, ACTIVITY, ACTIVITY_MILESTONE, ACTIVITY_MONITORING),
+		required("title", a.Title),
+		maxLength("title", a.Title, MAX_NAME_LENGTH),
+		maxLength("description", a.Description, MAX_TEXT_LENGTH),
+		required("starts_on", a.StartsOn),
+		date("starts_on", a.StartsOn),
+		required("ends_on", a.EndsOn),
+		date("ends_on", a.EndsOn),
+		dayOrder("starts_on", a.StartsOn, "ends_on", a.EndsOn),
+		oneOf("status", a.Status, STATUS_PLANNED, STATUS_IN_PROGRESS, STATUS_DONE, STATUS_CANCELLED),
+	}
+	if a.Kind != ACTIVITY {
+		rules = append(rules, func() (string, string, bool) {
+			return "ends_on", "must be starts_on for a " + a.Kind, a.EndsOn == a.StartsOn
+		})
+	}
+	return validate(rules...)
+}
+
+// Validate checks a task apart from its status, which follows the workflow
+// of taskTransitions.
+func (task *Task) Validate() FieldErrors {
+	parents := 0
+	for _, id := range []string{task.ProgressMarkerId, task.ChallengeId, task.StrategyId} {
+		if id != "" {
+			parents++
+		}
+	}
+	return validate(
+		required("title", task.Title),
+		maxLength("title", task.Title, MAX_NAME_LENGTH),
+		maxLength("description", task.Description, MAX_TEXT_LENGTH),
+		date("due_on", task.DueOn),
+		oneOf("priority", task.Priority, PRIORITY_LOW, PRIORITY_NORMAL, PRIORITY_HIGH, PRIORITY_URGENT),
+		func() (string, string, bool) {
+			return "progress_marker_id", "exactly one of progress_marker_id, challenge_id and strategy_id must be set", parents == 1
+		},
+	)
+}
+
+func (c *Comment) Validate() FieldErrors {
+	return validate(
+		oneOf("entity_type", c.EntityType, ENTITY_PROJECT, ENTITY_BOUNDARY_PARTNER, ENTITY_OUTCOME_STATEMENT,
+			ENTITY_PROGRESS_MARKER, ENTITY_CHALLENGE, ENTITY_STRATEGY),
+		required("entity_id", c.EntityId),
+		required("body", c.Body),
+		maxLength("body", c.Body, MAX_TEXT_LENGTH),
+	)
+}
+
+func (wh *Webhook) Validate() FieldErrors {
+	return validate(
+		required("url", wh.Url),
+		maxLength("url", wh.Url, MAX_URL_LENGTH),
+		webURL("url", wh.Url),
+		maxLength("description", wh.Description, MAX_TEXT_LENGTH),
+		func() (string, string, bool) {
+			return "events", "must not be empty", len(wh.Events) > 0
+		},
+		func() (string, string, bool) {
+			for _, event := range wh.Events {
+				if _, _, ok := oneOf("events", event, webhookEvents...)(); !ok {
+					return "events", "must be some of " + strings.Join(webhookEvents, ", "), false
+				}
+			}
+			return "events", "", true
+		},
+	)
+}
+
+// withinTimeline checks that the dates of an activity lie within the
+// timeline of its project, if the project has one.
+func withinTimeline(a Activity, p Project) FieldErrors {
+	from, to := timelineDay(p.TimelineFrom), timelineDay(p.TimelineTo)
+	return validate(
+		func() (string, string, bool) {
+			return "starts_on", "must not be before the project timeline starts on " + from, from == "" || a.StartsOn >= from
+		},
+		func() (string, string, bool) {
+			return "ends_on", "must not be after the project timeline ends on " + to, to == "" || a.EndsOn <= to
+		},
+	)
+}
+
+// timelineDay returns the day an end of a project timeline falls on, or ""
+// if it is not set. The timeline is read back from the store as a timestamp
+// in the database's time zone and given by clients in RFC 3339, both of
+// which start with the date.
+func timelineDay(value string) string {
+	if len(value) < 10 {
+		return ""
+	}
+	if _, err := time.Parse("2006-01-02", value[:10]); err != nil {
+		return ""
+	}
+	return value[:10]
+}
+
+// Error lets validation failures detected inside a data access function be
+// returned as an error.
+func (e FieldErrors) Error() string {
+	return "validation failed"
+}
+
+// Validate checks an export before it is imported. Fields are named by their
+// path in the document, like "boundary_partners[0].partner_name".
+func (doc *ProjectExport) Validate() FieldErrors {
+	errs := validate(
+		oneOf("format", doc.Format, EXPORT_FORMAT),
+		intRange("version", doc.Version, 1, EXPORT_VERSION),
+	)
+	p := doc.Project
+	project := Project{
+		ProjectName:  p.ProjectName,
+		Description:  p.Description,
+		Budget:       p.Budget,
+		Currency:     p.Currency,
+		Donor:        p.Donor,
+		Vision:       p.Vision,
+		Mission:      p.Mission,
+		TimelineFrom: p.TimelineFrom,
+		TimelineTo:   p.TimelineTo,
+	}
+	errs = nestErrors(errs, "project.", project.Validate())
+
+	// the kind of every ID in the export, to check the references of journals
+	// and resources, and the partner of every progress marker
+	kinds := make(map[string]string)
+	markerPartners := make(map[string]string)
+	uniqueId := func(field, id, kind string) FieldErrors {
+		if id == "" {
+			return nil
+		}
+		if _, exists := kinds[id]; exists {
+			return FieldErrors{field: "must be unique in the export"}
+		}
+		kinds[id] = kind
+		return nil
+	}
+	for i, bp := range doc.BoundaryPartners {
+		prefix := fmt.Sprintf("boundary_partners[%d]", i)
+		if bp == nil {
+			errs = nestErrors(errs, "", FieldErrors{prefix: "must not be null"})
+			continue
+		}
+		errs = nestErrors(errs, prefix+".", bp.Validate())
+		errs = nestErrors(errs, prefix+".", uniqueId("boundary_partner_id", bp.BoundaryPartnerId, "partner"))
+		for j, pm := range bp.ProgressMarkers {
+			markerPrefix := fmt.Sprintf("%s.progress_markers[%d]", prefix, j)
+			if pm == nil {
+				errs = nestErrors(errs, "", FieldErrors{markerPrefix: "must not be null"})
+				continue
+			}
+			errs = nestErrors(errs, markerPrefix+".", pm.Validate())
+			errs = nestErrors(errs, markerPrefix+".", uniqueId("progress_marker_id", pm.ProgressMarkerId, "marker"))
+			markerPartners[pm.ProgressMarkerId] = bp.BoundaryPartnerId
+			for k, c := range pm.Challenges {
+				field := fmt.Sprintf("%s.challenges[%d]", markerPrefix, k)
+				if c == nil {
+					errs = nestErrors(errs, "", FieldErrors{field: "must not be null"})
+					continue
+				}
+				errs = nestErrors(errs, field+".", c.Validate())
+			}
+			for k, strat := range pm.Strategies {
+				field := fmt.Sprintf("%s.strategies[%d]", markerPrefix, k)
+				if strat == nil {
+					errs = nestErrors(errs, "", FieldErrors{field: "must not be null"})
+					continue
+				}
+				errs = nestErrors(errs, field+".", strat.Validate())
+			}
+		}
+	}
+
+	// partner and monitoring date of every journal, which are unique together
+	dates := make(map[[2]string]bool)
+	for i, j := range doc.Journals {
+		prefix := fmt.Sprintf("journals[%d]", i)
+		if j == nil {
+			errs = nestErrors(errs, "", FieldErrors{prefix: "must not be null"})
+			continue
+		}
+		journal := j.journal("")
+		errs = nestErrors(errs, prefix+".", journal.Validate())
+		errs = nestErrors(errs, prefix+".", validate(oneOf("status", j.Status, JOURNAL_DRAFT, JOURNAL_SUBMITTED)))
+		errs = nestErrors(errs, prefix+".", uniqueId("journal_id", j.JournalId, "journal"))
+		if j.BoundaryPartnerId != "" && kinds[j.BoundaryPartnerId] != "partner" {
+			errs = nestErrors(errs, prefix+".", FieldErrors{"boundary_partner_id": "must be a boundary partner of the export"})
+		}
+		date := [2]string{j.BoundaryPartnerId, j.MonitoringDate}
+		if dates[date] {
+			errs = nestErrors(errs, prefix+".", FieldErrors{"monitoring_date": "the boundary partner already has a journal for this date"})
+		}
+		dates[date] = true
+		for _, r := range j.Ratings {
+			if r.ProgressMarkerId != "" && markerPartners[r.ProgressMarkerId] != j.BoundaryPartnerId {
+				errs = nestErrors(errs, prefix+".", FieldErrors{"ratings": "progress markers must belong to the boundary partner"})
+			}
+		}
+	}
+
+	for i, r := range doc.Resources {
+		prefix := fmt.Sprintf("resources[%d].", i)
+		exr := r.resource("")
+		errs = nestErrors(errs, prefix, exr.Validate())
+		if r.BoundaryPartnerId != "" && kinds[r.BoundaryPartnerId] != "partner" {
+			errs = nestErrors(errs, prefix, FieldErrors{"boundary_partner_id": "must be a boundary partner of the export"})
+		}
+		if r.ProgressMarkerId != "" && kinds[r.ProgressMarkerId] != "marker" {
+			errs = nestErrors(errs, prefix, FieldErrors{"progress_marker_id": "must be a progress marker of the export"})
+		}
+		if r.JournalId != "" && kinds[r.JournalId] != "journal" {
+			errs = nestErrors(errs, prefix, FieldErrors{"journal_id": "must be a journal of the export"})
+		}
+	}
+
+	for i, g := range doc.Grants {
+		prefix := fmt.Sprintf("grants[%d].", i)
+		errs = nestErrors(errs, prefix, g.Validate())
+		errs = nestErrors(errs, prefix, validate(
+			required("donor_name", g.DonorName),
+			maxLength("donor_name", g.DonorName, MAX_NAME_LENGTH),
+		))
+	}
+	return errs
+}
+
+// nestErrors adds the errors of a part of a document to errs, with the
+// fields prefixed by the path of the part.
+func nestErrors(errs FieldErrors, prefix string, nested FieldErrors) FieldErrors {
+	for field, message := range nested {
+		if errs == nil {
+			errs = FieldErrors{}
+		}
+		errs[prefix+field] = message
+	}
+	return errs
+}
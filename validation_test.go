@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/context"
+)
+
+// TestRules runs every rule against values it accepts and values it refuses.
+func TestRules(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		rule    rule
+		message string
+	}{
+		{"required", required("f", "x"), ""},
+		{"required empty", required("f", ""), "must not be empty"},
+		{"required blank", required("f", " \t\n"), "must not be empty"},
+		{"maxLength", maxLength("f", "ééé", 3), ""},
+		{"maxLength long", maxLength("f", "abcd", 3), "must be at most 3 characters"},
+		{"nonNegative zero", nonNegative("f", 0), ""},
+		{"nonNegative", nonNegative("f", 125000), ""},
+		{"nonNegative negative", nonNegative("f", -100), "must not be negative"},
+		{"positive", positive("f", 1), ""},
+		{"positive zero", positive("f", 0), "must be greater than zero"},
+		{"maxAmount", maxAmount("f", MAX_AMOUNT-1), ""},
+		{"maxAmount negative", maxAmount("f", -MAX_AMOUNT+1), ""},
+		{"maxAmount large", maxAmount("f", MAX_AMOUNT), "must be less than 10000000000000.00"},
+		{"maxAmount small", maxAmount("f", -MAX_AMOUNT), "must be less than 10000000000000.00"},
+		{"currencyCode", currencyCode("f", "EUR"), ""},
+		{"currencyCode empty", currencyCode("f", ""), ""},
+		{"currencyCode lower", currencyCode("f", "eur"), "must be an ISO 4217 currency code"},
+		{"currencyCode unknown", currencyCode("f", "XYZ"), "must be an ISO 4217 currency code"},
+		{"minorUnits", minorUnits("f", 12300, "EUR"), ""},
+		{"minorUnits cents", minorUnits("f", 12345, "EUR"), "must have at most 2 decimal places in EUR"},
+		{"minorUnits none", minorUnits("f", 10000, "JPY"), ""},
+		{"minorUnits yen", minorUnits("f", 15000, "JPY"), "must have at most 0 decimal places in JPY"},
+		{"minorUnits unknown", minorUnits("f", 12345, "XYZ"), ""},
+		{"intRange min", intRange("f", 1, 1, 3), ""},
+		{"intRange max", intRange("f", 3, 1, 3), ""},
+		{"intRange below", intRange("f", 0, 1, 3), "must be between 1 and 3"},
+		{"intRange above", intRange("f", 4, 1, 3), "must be between 1 and 3"},
+		{"timestamp", timestamp("f", "2017-03-01T00:00:00Z"), ""},
+		{"timestamp offset", timestamp("f", "2017-03-01T10:00:00+02:00"), ""},
+		{"timestamp empty", timestamp("f", ""), ""},
+		{"timestamp date", timestamp("f", "2017-03-01"), "must be an RFC 3339 timestamp"},
+		{"timestamp garbage", timestamp("f", "March"), "must be an RFC 3339 timestamp"},
+		{"dateOrder", dateOrder("from", "2017-03-01T00:00:00Z", "f", "2017-04-01T00:00:00Z"), ""},
+		{"dateOrder same", dateOrder("from", "2017-03-01T00:00:00Z", "f", "2017-03-01T00:00:00Z"), ""},
+		{"dateOrder zones", dateOrder("from", "2017-03-01T10:00:00+02:00", "f", "2017-03-01T09:00:00Z"), ""},
+		{"dateOrder reversed", dateOrder("from", "2017-04-01T00:00:00Z", "f", "2017-03-01T00:00:00Z"), "must not be before from"},
+		{"dateOrder unparsable", dateOrder("from", "2017-04-01", "f", "2017-03-01T00:00:00Z"), ""},
+		{"dateOrder open", dateOrder("from", "2017-04-01T00:00:00Z", "f", ""), ""},
+		{"date", date("f", "2017-02-28"), ""},
+		{"date empty", date("f", ""), ""},
+		{"date invalid", date("f", "2017-02-29"), "must be a date like 2006-01-02"},
+		{"date timestamp", date("f", "2017-02-28T00:00:00Z"), "must be a date like 2006-01-02"},
+		{"oneOf", oneOf("f", "b", "a", "b"), ""},
+		{"oneOf other", oneOf("f", "c", "a", "b"), "must be one of a, b"},
+		{"oneOf case", oneOf("f", "A", "a", "b"), "must be one of a, b"},
+		{"oneOf empty", oneOf("f", "", "a", "b"), "must be one of a, b"},
+		{"webURL", webURL("f", "https://example.org/a?b=c"), ""},
+		{"webURL empty", webURL("f", ""), ""},
+		{"webURL scheme", webURL("f", "javascript:alert(1)"), "must be an http or https URL"},
+		{"webURL relative", webURL("f", "/reports"), "must be an http or https URL"},
+		{"tagList", tagList("f", []string{"a", "b"}), ""},
+		{"tagList many", tagList("f", make([]string, MAX_TAGS+1)), "must have at most 20 tags"},
+		{"tagList long", tagList("f", []string{strings.Repeat("t", MAX_TAG_LENGTH+1)}), "tags must be at most 50 characters"},
+		{"journalRatings", journalRatings("f", []JournalRating{{ORG_A, RATING_LOW}, {ORG_B, RATING_HIGH}}), ""},
+		{"journalRatings none", journalRatings("f", nil), ""},
+		{"journalRatings twice", journalRatings("f", []JournalRating{{ORG_A, RATING_LOW}, {ORG_A, RATING_HIGH}}),
+			"must rate each progress marker at most once"},
+		{"journalRatings level", journalRatings("f", []JournalRating{{ORG_A, "achieved"}}), "rating must be one of low, medium, high"},
+		{"journalRatings marker", journalRatings("f", []JournalRating{{"1", RATING_LOW}}), "progress_marker_id must be a UUID"},
+	} {
+		field, message, ok := test.rule()
+		if field != "f" || ok != (test.message == "") || (!ok && message != test.message) {
+			t.Errorf("%s: %s %q %v", test.name, field, message, ok)
+		}
+	}
+}
+
+// TestValidate keeps the first failure of every field.
+func TestValidate(t *testing.T) {
+	if errs := validate(required("a", "x"), nonNegative("b", 1)); errs != nil {
+		t.Fatal(errs)
+	}
+	errs := validate(required("a", ""), maxLength("a", "", -1), intRange("b", 0, 1, 3), required("c", "x"))
+	if len(errs) != 2 || errs["a"] != "must not be empty" || errs["b"] != "must be between 1 and 3" {
+		t.Fatal(errs)
+	}
+}
+
+func TestProjectValidate(t *testing.T) {
+	p := Project{ProjectName: "Radio", Budget: 100, TimelineFrom: "2017-01-01T00:00:00Z", TimelineTo: "2017-12-31T00:00:00Z"}
+	if errs := p.Validate(); errs != nil {
+		t.Fatal(errs)
+	}
+	p = Project{ProjectName: " ", Budget: -1, Donor: strings.Repeat("d", MAX_NAME_LENGTH+1),
+		TimelineFrom: "2017-12-31T00:00:00Z", TimelineTo: "2017-01-01T00:00:00Z"}
+	errs := p.Validate()
+	if len(errs) != 4 || errs["project_name"] == "" || errs["budget"] == "" || errs["donor"] == "" || errs["timeline_to"] == "" {
+		t.Fatal(errs)
+	}
+	if errs := (&ProgressMarker{Title: "Listen", Type: MARKER_LOVE + 1}).Validate(); len(errs) != 1 || errs["type"] == "" {
+		t.Fatal(errs)
+	}
+}
+
+// TestAddProjectFieldErrors posts an invalid project and gets the failures
+// of its fields back with a 400.
+func TestAddProjectFieldErrors(t *testing.T) {
+	r := httptest.NewRequest("POST", "/projects", strings.NewReader(`{"project_name":"","budget":-5}`))
+	context.Set(r, USER, User{IsAdmin: true})
+	defer context.Clear(r)
+	w := httptest.NewRecorder()
+	NewServer(testConfig(), NewMemoryStore(), testBlobs(t)).addProject(w, r)
+
+	var out struct {
+		Data    map[string]string
+		Message string
+	}
+	if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"project_name": "must not be empty", "budget": "must not be negative"}
+	if w.Code != http.StatusBadRequest || out.Message != "validation failed" || len(out.Data) != len(want) {
+		t.Fatal(w.Code, out)
+	}
+	for field, message := range want {
+		if out.Data[field] != message {
+			t.Errorf("%s: %q", field, out.Data[field])
+		}
+	}
+}
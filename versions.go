@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) getResourceVersions(w http.ResponseWriter, r *http.Request) {
+	projectId := mux.Vars(r)["projectId"]
+	resourceId := mux.Vars(r)["resourceId"]
+
+	versions, err := s.store.GetResourceVersions(tenantOf(r), projectId, resourceId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{versions, "success"})
+}
+
+// uploadResourceVersion replaces the file of a resource. The previous
+// versions are kept and stay downloadable.
+func (s *Server) uploadResourceVersion(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	projectId := mux.Vars(r)["projectId"]
+	resourceId := mux.Vars(r)["resourceId"]
+
+	file, handler, ok := s.receiveFile(w, r, "resource_file")
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	stored, ok := s.storeUpload(w, tenantOf(r), file, sanitizeFilename(handler.Filename), handler.Size, "resource_file")
+	if !ok {
+		return
+	}
+	s.addVersion(w, r, user, projectId, resourceId, uploadedVersion(sanitizeFilename(handler.Filename), stored), stored.unpin)
+}
+
+// uploadedVersion is the version of a resource made by an upload.
+func uploadedVersion(name string, stored storedUpload) ResourceVersion {
+	return ResourceVersion{
+		ResourceName: name,
+		Size:         stored.Size,
+		SHA256:       stored.SHA256,
+		ContentType:  stored.ContentType,
+		ScanStatus:   stored.ScanStatus,
+		StorageKey:   stored.Key,
+	}
+}
+
+// rollbackResource makes an older version current again by adding a copy of
+// it as the newest version, so the history is never rewritten.
+func (s *Server) rollbackResource(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+
+	projectId := mux.Vars(r)["projectId"]
+	resourceId := mux.Vars(r)["resourceId"]
+
+	v, ok := s.findVersion(w, r)
+	if !ok {
+		return
+	}
+	if v.ScanStatus != SCAN_CLEAN {
+		JSON(w, http.StatusUnprocessableEntity, Response{nil, "only clean versions can be restored"})
+		return
+	}
+	if v.Current {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"version": "is already the current version"}, "validation failed"})
+		return
+	}
+	// the blob stays referenced by the older version
+	s.addVersion(w, r, user, projectId, resourceId, v, func() {})
+}
+
+// addVersion stores a new version and responds with it. The blob is unpinned
+// once the version is added, and released again if it can't be. A clean
+// version is published to the webhooks as an upload of the resource.
+func (s *Server) addVersion(w http.ResponseWriter, r *http.Request, user User, projectId, resourceId string, v ResourceVersion, unpin func()) {
+	number, err := s.store.AddResourceVersion(tenantOf(r), projectId, resourceId, user.UserId, v)
+	unpin()
+	if err != nil {
+		s.releaseBlob(tenantOf(r), v.StorageKey)
+		respondError(w, err)
+		return
+	}
+	added, err := s.store.GetResourceVersion(tenantOf(r), projectId, resourceId, number)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if added.ScanStatus == SCAN_CLEAN {
+		if resource, err := s.store.GetExternalResource(tenantOf(r), projectId, resourceId); err == nil {
+			s.publish(r, EVENT_RESOURCE_UPLOADED, map[string]interface{}{"resource": resource, "version": added})
+		}
+	}
+
+	respondScanStatus(w, added.ScanStatus, added)
+}
+
+// getResourceVersionContent downloads an older version of a file. Versions
+// held back by the virus scanner are not served.
+func (s *Server) getResourceVersionContent(w http.ResponseWriter, r *http.Request) {
+	v, ok := s.findVersion(w, r)
+	if !ok {
+		return
+	}
+	if v.ScanStatus != SCAN_CLEAN {
+		respondError(w, ErrNotFound)
+		return
+	}
+
+	s.serveBlob(w, r, v.StorageKey, v.ResourceName, v.ContentType)
+}
+
+// findVersion loads the version named in the URL, writing the error response
+// if there is none.
+func (s *Server) findVersion(w http.ResponseWriter, r *http.Request) (ResourceVersion, bool) {
+	vars := mux.Vars(r)
+	number, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		JSON(w, http.StatusBadRequest, Response{FieldErrors{"version": "must be a number"}, "validation failed"})
+		return ResourceVersion{}, false
+	}
+	v, err := s.store.GetResourceVersion(tenantOf(r), vars["projectId"], vars["resourceId"], number)
+	if err != nil {
+		respondError(w, err)
+		return ResourceVersion{}, false
+	}
+	return v, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+// TestUploadSameName uploads a file twice under the same name, which adds a
+// version, and once more attached to a boundary partner, which does not.
+func TestUploadSameName(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	a := Tenant{ORG_A}
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "A"})
+	check(t, err)
+	partnerId, err := ts.store.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "BP"})
+	check(t, err)
+
+	path := "/projects/" + projectId + "/resource_uploadfile"
+	if code, out := ts.upload(key, path, "resource_file", "plan.txt", "first draft"); code != 200 {
+		t.Fatal(code, out)
+	}
+	code, out := ts.upload(key, path, "resource_file", "plan.txt", "second draft")
+	if code != 200 || out["data"].(map[string]interface{})["version_number"] != 2.0 {
+		t.Fatal(code, out)
+	}
+	if code, out = ts.upload(key, path+"?boundary_partner_id="+partnerId, "resource_file", "plan.txt", "partner plan"); code != 200 {
+		t.Fatal(code, out)
+	}
+
+	resources, err := ts.store.GetExternalResources(a, projectId)
+	check(t, err)
+	if len(resources) != 2 {
+		t.Fatalf("%+v", resources)
+	}
+	for _, res := range resources {
+		versions, err := ts.store.GetResourceVersions(a, projectId, res.ResourceId)
+		check(t, err)
+		switch res.BoundaryPartnerId {
+		case "":
+			if res.Version != 2 || res.Size != int64(len("second draft")) || len(versions) != 2 {
+				t.Errorf("%+v %+v", res, versions)
+			}
+		case partnerId:
+			if res.Version != 1 || len(versions) != 1 {
+				t.Errorf("%+v %+v", res, versions)
+			}
+		}
+	}
+}
+
+// TestRollbackResource restores the first version of a file as a new one and
+// keeps the history.
+func TestRollbackResource(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	projectId, exr := ts.uploadResource(key, "first draft")
+	versions := "/projects/" + projectId + "/resources/" + exr.ResourceId + "/versions"
+	if code, out := ts.upload(key, versions, "resource_file", "notes.txt", "second draft"); code != 200 {
+		t.Fatal(code, out)
+	}
+
+	if code, out := ts.do(key, "POST", versions+"/2/rollback", "", ""); code != 400 {
+		t.Fatal("rolled back to the current version", code, out)
+	}
+	code, out := ts.do(key, "POST", versions+"/1/rollback", "", "")
+	if code != 200 || out["data"].(map[string]interface{})["version_number"] != 3.0 {
+		t.Fatal(code, out)
+	}
+	if _, body := ts.fetch(key, exr.ResourceUrl, nil); body != "first draft" {
+		t.Fatal(body)
+	}
+	if _, body := ts.fetch(key, versions+"/2/content", nil); body != "second draft" {
+		t.Fatal(body)
+	}
+	code, out = ts.do(key, "GET", versions, "", "")
+	if list, _ := out["data"].([]interface{}); code != 200 || len(list) != 3 {
+		t.Fatal(code, out)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// events webhooks can subscribe to
+var webhookEvents = []string{
+	EVENT_PROJECT_UPDATED,
+	EVENT_PARTNER_CREATED,
+	EVENT_MARKERS_REORDERED,
+	EVENT_JOURNAL_SUBMITTED,
+	EVENT_RESOURCE_UPLOADED,
+}
+
+// delay before the first retry of a failed delivery, doubled for every
+// attempt after that up to WEBHOOK_MAX_RETRY_DELAY
+const (
+	WEBHOOK_RETRY_DELAY     = time.Minute
+	WEBHOOK_MAX_RETRY_DELAY = 6 * time.Hour
+)
+
+// only the start of a response is kept in the delivery log
+const MAX_RESPONSE_LOG = 1024
+
+// maximum number of deliveries returned by GET /webhooks/{webhookId}/deliveries
+const MAX_DELIVERIES = 100
+
+// WebhookPayload is the body posted to a webhook.
+type WebhookPayload struct {
+	Event          string      `json:"event"`
+	OrganizationId string      `json:"organization_id"`
+	OccurredAt     time.Time   `json:"occurred_at"`
+	Data           interface{} `json:"data"`
+}
+
+// WebhookSender posts events to the webhooks that subscribe to them. Every
+// request carries the time it was sent in X-Lucid-Timestamp, as Unix seconds,
+// and the signature of that time and its body in X-Lucid-Signature-256. A
+// delivery is attempted when it is queued and, if that fails, again by the
+// webhook_deliveries job with exponential backoff.
+type WebhookSender struct {
+	store       Store
+	client      *http.Client
+	timeout     time.Duration
+	maxAttempts int
+}
+
+func NewWebhookSender(store Store, config WebhooksConfig) *WebhookSender {
+	timeout := time.Duration(config.Timeout)
+	dialer := &net.Dialer{Timeout: timeout}
+	if !config.AllowPrivate {
+		dialer.Control = publicAddressesOnly
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+		Timeout: timeout,
+		// a redirect fails the attempt, receivers are configured with their
+		// final URL
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &WebhookSender{store, client, timeout, config.MaxAttempts}
+}
+
+// Publish queues an event for every active webhook of the tenant that
+// subscribes to it. Failures are logged rather than returned, since the
+// change the event is about has been made.
+func (ws *WebhookSender) Publish(t Tenant, event string, data interface{}) {
+	webhooks, err := ws.store.GetSubscribedWebhooks(t, event)
+	if err != nil {
+		errorf("webhooks %s: %v", event, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(WebhookPayload{event, t.OrganizationId, time.Now().UTC(), data})
+	if err != nil {
+		errorf("webhooks %s: %v", event, err)
+		return
+	}
+	for _, wh := range webhooks {
+		if _, err := ws.queue(t, wh, WebhookDelivery{WebhookId: wh.WebhookId, Event: event, Payload: payload}); err != nil {
+			errorf("webhook %s: %v", wh.WebhookId, err)
+		}
+	}
+}
+
+// queue adds a delivery and attempts it in the background.
+func (ws *WebhookSender) queue(t Tenant, wh Webhook, d WebhookDelivery) (string, error) {
+	now := time.Now()
+	d.NextAttemptAt = &now
+	deliveryId, err := ws.store.AddDelivery(t, d)
+	if err != nil {
+		return "", err
+	}
+	d.DeliveryId = deliveryId
+	go ws.attempt(t, wh, d, now)
+	return deliveryId, nil
+}
+
+// retryDue attempts the tenant's pending deliveries that are due at now.
+func (ws *WebhookSender) retryDue(t Tenant, now time.Time) error {
+	due, err := ws.store.GetDueDeliveries(t, now)
+	if err != nil {
+		return err
+	}
+	for _, d := range due {
+		wh, err := ws.store.GetWebhook(t, d.WebhookId)
+		if err != nil {
+			return err
+		}
+		ws.attempt(t, wh, d, now)
+	}
+	return nil
+}
+
+// attempt posts a delivery, unless it is no longer due because someone else
+// is attempting it, and records the outcome.
+func (ws *WebhookSender) attempt(t Tenant, wh Webhook, d WebhookDelivery, now time.Time) {
+	claimed, err := ws.store.ClaimDelivery(t, d.DeliveryId, now, now.Add(2*ws.timeout))
+	if err != nil {
+		errorf("webhook delivery %s: %v", d.DeliveryId, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	d.Attempts++
+	var postErr error
+	d.ResponseStatus, d.ResponseBody, postErr = ws.post(wh, d)
+	finished := time.Now()
+	switch {
+	case postErr == nil:
+		d.Status, d.NextAttemptAt, d.LastError, d.TsDelivered = DELIVERY_DELIVERED, nil, "", &finished
+	case d.Attempts < ws.maxAttempts:
+		next := finished.Add(backoff(d.Attempts, WEBHOOK_RETRY_DELAY, WEBHOOK_MAX_RETRY_DELAY))
+		d.Status, d.NextAttemptAt, d.LastError = DELIVERY_PENDING, &next, postErr.Error()
+	default:
+		d.Status, d.NextAttemptAt, d.LastError = DELIVERY_FAILED, nil, postErr.Error()
+	}
+	if postErr != nil {
+		warnf("webhook delivery %s: attempt %d of %d failed: %v", d.DeliveryId, d.Attempts, ws.maxAttempts, postErr)
+	}
+	if err = ws.store.SetDeliveryResult(t, d); err != nil {
+		errorf("webhook delivery %s: %v", d.DeliveryId, err)
+	}
+}
+
+// post sends a delivery to its webhook and returns the status and the start
+// of the body of the response. Anything but a 2xx response is an error.
+func (ws *WebhookSender) post(wh Webhook, d WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequest("POST", wh.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Lucid-Webhooks")
+	req.Header.Set("X-Lucid-Event", d.Event)
+	req.Header.Set("X-Lucid-Delivery", d.DeliveryId)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Lucid-Timestamp", timestamp)
+	req.Header.Set("X-Lucid-Signature-256", signWebhookPayload(wh.Secret, timestamp, d.Payload))
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_RESPONSE_LOG))
+	// the log is stored as text, which must be valid UTF-8 without NULs
+	logged := strings.Replace(strings.ToValidUTF8(string(body), "�"), "\x00", "", -1)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, logged, errors.New("receiver responded " + resp.Status)
+	}
+	return resp.StatusCode, logged, nil
+}
+
+// publish posts an event of the tenant of the request to its webhooks.
+func (s *Server) publish(r *http.Request, event string, data interface{}) {
+	s.webhooks.Publish(tenantOf(r), event, data)
+}
+
+// retryWebhooks attempts the due deliveries of every organization again.
+func (s *Server) retryWebhooks(now time.Time) error {
+	return s.forEachOrganization(func(t Tenant) error {
+		return s.webhooks.retryDue(t, now)
+	})
+}
+
+// readWebhook decodes and validates a webhook. A webhook is active unless
+// active is false. It responds itself if that fails.
+func readWebhook(w http.ResponseWriter, r *http.Request) (Webhook, bool) {
+	var input struct {
+		Url         string   `json:"url"`
+		Description string   `json:"description"`
+		Events      []string `json:"events"`
+		Active      *bool    `json:"active"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&input); err != nil {
+		JSON(w, http.StatusBadRequest, Response{nil, err.Error()})
+		return Webhook{}, false
+	}
+	wh := Webhook{
+		Url:         strings.TrimSpace(input.Url),
+		Description: input.Description,
+		Events:      pq.StringArray{},
+		Active:      input.Active == nil || *input.Active,
+	}
+	seen := make(map[string]bool)
+	for _, event := range input.Events {
+		if !seen[event] {
+			seen[event] = true
+			wh.Events = append(wh.Events, event)
+		}
+	}
+	if errs := wh.Validate(); errs != nil {
+		JSON(w, http.StatusBadRequest, Response{errs, "validation failed"})
+		return Webhook{}, false
+	}
+	return wh, true
+}
+
+// getWebhooks lists the organization's webhooks. Only admins can see and
+// change webhooks, their secrets are only shown when they are created.
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	webhooks, err := s.store.GetWebhooks(tenantOf(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	JSON(w, http.StatusOK, Response{webhooks, "success"})
+}
+
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	wh, err := s.store.GetWebhook(tenantOf(r), mux.Vars(r)["webhookId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	wh.Secret = ""
+	JSON(w, http.StatusOK, Response{wh, "success"})
+}
+
+// addWebhook creates a webhook with a new secret and responds with it,
+// including the secret.
+func (s *Server) addWebhook(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	input, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+	input.Secret = newWebhookSecret()
+	webhookId, err := s.store.AddWebhook(tenantOf(r), user.UserId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	wh, err := s.store.GetWebhook(tenantOf(r), webhookId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{wh, "success"})
+}
+
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	webhookId := mux.Vars(r)["webhookId"]
+	if _, err := s.store.GetWebhook(tenantOf(r), webhookId); err != nil {
+		respondError(w, err)
+		return
+	}
+	input, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.UpdateWebhook(tenantOf(r), webhookId, input)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	s.getWebhook(w, r)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	err := s.store.DeleteWebhook(tenantOf(r), mux.Vars(r)["webhookId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{nil, "success"})
+}
+
+// getWebhookDeliveries returns the newest deliveries to a webhook with the
+// outcome of their last attempts.
+func (s *Server) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	wh, err := s.store.GetWebhook(tenantOf(r), mux.Vars(r)["webhookId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	deliveries, err := s.store.GetDeliveries(tenantOf(r), wh.WebhookId, MAX_DELIVERIES)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	JSON(w, http.StatusOK, Response{deliveries, "success"})
+}
+
+// redeliverWebhook posts the payload of an earlier delivery again, as a new
+// delivery that is attempted at once.
+func (s *Server) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, USER).(User)
+	if user.IsAdmin == false {
+		JSON(w, http.StatusForbidden, Response{nil, "Permission denied"})
+		return
+	}
+	vars := mux.Vars(r)
+	wh, err := s.store.GetWebhook(tenantOf(r), vars["webhookId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	original, err := s.store.GetDelivery(tenantOf(r), wh.WebhookId, vars["deliveryId"])
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if !wh.Active {
+		JSON(w, http.StatusBadRequest, Response{nil, "The webhook is not active"})
+		return
+	}
+	deliveryId, err := s.webhooks.queue(tenantOf(r), wh, WebhookDelivery{
+		WebhookId:    wh.WebhookId,
+		Event:        original.Event,
+		Payload:      original.Payload,
+		RedeliveryOf: original.DeliveryId,
+	})
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	delivery, err := s.store.GetDelivery(tenantOf(r), wh.WebhookId, deliveryId)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	JSON(w, http.StatusOK, Response{delivery, "success"})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests posted to it and fails them while
+// failing is set.
+type webhookReceiver struct {
+	sync.Mutex
+	*httptest.Server
+	requests []*http.Request
+	bodies   [][]byte
+	failing  bool
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	rec := &webhookReceiver{failing: true}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		failing := rec.failing
+		rec.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("bad\x00\xff"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *webhookReceiver) count() int {
+	rec.Lock()
+	defer rec.Unlock()
+	return len(rec.requests)
+}
+
+// waitFor polls until cond holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+	}
+}
+
+// addTestWebhook creates a webhook through the API and returns its ID and
+// secret.
+func addTestWebhook(ts *testServer, key, url string) (string, string) {
+	code, out := ts.do(key, "POST", "/webhooks", "application/json", `{"url":"`+url+`","events":["project.updated"]}`)
+	if code != 200 {
+		ts.t.Fatal(code, out)
+	}
+	wh := out["data"].(map[string]interface{})
+	return wh["webhook_id"].(string), wh["secret"].(string)
+}
+
+// deliveries returns the delivery log of a webhook, newest first.
+func deliveries(ts *testServer, key, webhookId string) []WebhookDelivery {
+	req, err := http.NewRequest("GET", ts.srv.URL+"/webhooks/"+webhookId+"/deliveries", nil)
+	check(ts.t, err)
+	req.Header.Set("X-Api-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	check(ts.t, err)
+	defer resp.Body.Close()
+	var out struct{ Data []WebhookDelivery }
+	check(ts.t, json.NewDecoder(resp.Body).Decode(&out))
+	return out.Data
+}
+
+// TestWebhookDeliveries follows a delivery through its signed attempts, the
+// retries with backoff until it fails and a redelivery.
+func TestWebhookDeliveries(t *testing.T) {
+	ts := newTestServer(t)
+	ts.webhooks = NewWebhookSender(ts.store, WebhooksConfig{Timeout: Duration(2 * time.Second), MaxAttempts: 3, AllowPrivate: true})
+	key := ts.login("ada@a.org")
+	rec := newWebhookReceiver(t)
+	webhookId, secret := addTestWebhook(ts, key, rec.URL)
+
+	ts.webhooks.Publish(Tenant{ORG_A}, EVENT_PROJECT_UPDATED, map[string]string{"changed": "name"})
+	waitFor(t, "the first attempt", func() bool {
+		d := deliveries(ts, key, webhookId)
+		return len(d) == 1 && d[0].Attempts == 1
+	})
+
+	rec.Lock()
+	req, body := rec.requests[0], rec.bodies[0]
+	rec.Unlock()
+	timestamp := req.Header.Get("X-Lucid-Timestamp")
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("X-Lucid-Timestamp %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	if signature := req.Header.Get("X-Lucid-Signature-256"); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("X-Lucid-Signature-256 %q", signature)
+	}
+	var payload WebhookPayload
+	check(t, json.Unmarshal(body, &payload))
+	if req.Header.Get("X-Lucid-Event") != EVENT_PROJECT_UPDATED || payload.Event != EVENT_PROJECT_UPDATED || payload.OrganizationId != ORG_A {
+		t.Errorf("%v %s", req.Header, body)
+	}
+
+	d := deliveries(ts, key, webhookId)[0]
+	if d.Status != DELIVERY_PENDING || d.ResponseStatus != 500 || d.ResponseBody != "bad�" ||
+		d.LastError != "receiver responded 500 Internal Server Error" || req.Header.Get("X-Lucid-Delivery") != d.DeliveryId {
+		t.Fatalf("%+v", d)
+	}
+	// retried after a minute, then after two
+	if wait := time.Until(*d.NextAttemptAt); wait < 55*time.Second || wait > WEBHOOK_RETRY_DELAY {
+		t.Fatal(wait)
+	}
+	check(t, ts.retryWebhooks(time.Now().Add(30*time.Second)))
+	if rec.count() != 1 {
+		t.Fatal("retried early")
+	}
+	check(t, ts.retryWebhooks(time.Now().Add(WEBHOOK_RETRY_DELAY)))
+	d = deliveries(ts, key, webhookId)[0]
+	if rec.count() != 2 || d.Attempts != 2 || d.Status != DELIVERY_PENDING {
+		t.Fatalf("%d %+v", rec.count(), d)
+	}
+	if wait := time.Until(*d.NextAttemptAt); wait < 2*WEBHOOK_RETRY_DELAY-5*time.Second || wait > 2*WEBHOOK_RETRY_DELAY {
+		t.Fatal(wait)
+	}
+	check(t, ts.retryWebhooks(time.Now().Add(WEBHOOK_RETRY_DELAY)))
+	if rec.count() != 2 {
+		t.Fatal("retried early")
+	}
+	check(t, ts.retryWebhooks(time.Now().Add(2*WEBHOOK_RETRY_DELAY)))
+	d = deliveries(ts, key, webhookId)[0]
+	if rec.count() != 3 || d.Attempts != 3 || d.Status != DELIVERY_FAILED || d.NextAttemptAt != nil {
+		t.Fatalf("%d %+v", rec.count(), d)
+	}
+	check(t, ts.retryWebhooks(time.Now().Add(WEBHOOK_MAX_RETRY_DELAY)))
+	if rec.count() != 3 {
+		t.Fatal("failed delivery retried")
+	}
+
+	rec.Lock()
+	rec.failing = false
+	rec.Unlock()
+	code, out := ts.do(key, "POST", "/webhooks/"+webhookId+"/deliveries/"+d.DeliveryId+"/redeliver", "", "")
+	if code != 200 || out["data"].(map[string]interface{})["redelivery_of"] != d.DeliveryId {
+		t.Fatal(code, out)
+	}
+	waitFor(t, "the redelivery", func() bool {
+		d := deliveries(ts, key, webhookId)
+		return len(d) == 2 && d[0].Status == DELIVERY_DELIVERED
+	})
+	redelivered := deliveries(ts, key, webhookId)[0]
+	rec.Lock()
+	defer rec.Unlock()
+	if string(rec.bodies[3]) != string(body) || rec.requests[3].Header.Get("X-Lucid-Delivery") != redelivered.DeliveryId ||
+		redelivered.ResponseBody != "ok" || redelivered.TsDelivered == nil {
+		t.Fatalf("%s %+v", rec.bodies[3], redelivered)
+	}
+}
+
+// TestWebhookPrivateAddress posts to a receiver on the loopback address,
+// which is refused unless private addresses are allowed.
+func TestWebhookPrivateAddress(t *testing.T) {
+	ts := newTestServer(t)
+	key := ts.login("ada@a.org")
+	rec := newWebhookReceiver(t)
+	webhookId, _ := addTestWebhook(ts, key, rec.URL)
+
+	ts.webhooks.Publish(Tenant{ORG_A}, EVENT_PROJECT_UPDATED, nil)
+	waitFor(t, "the attempt", func() bool {
+		d := deliveries(ts, key, webhookId)
+		return len(d) == 1 && d[0].Attempts == 1
+	})
+	if d := deliveries(ts, key, webhookId)[0]; !strings.Contains(d.LastError, blockedAddress.Error()) || d.ResponseStatus != 0 {
+		t.Fatalf("%+v", d)
+	}
+	if rec.count() != 0 {
+		t.Fatal("posted to a private address")
+	}
+}
+
+// TestWebhookEvents subscribes to submitted journals and uploaded files and
+// checks that each is posted once it happens, including files that only
+// turn out clean when they are scanned again.
+func TestWebhookEvents(t *testing.T) {
+	ts := newTestServer(t)
+	ts.webhooks = NewWebhookSender(ts.store, WebhooksConfig{Timeout: Duration(2 * time.Second), MaxAttempts: 3, AllowPrivate: true})
+	scanner := &testScanner{}
+	ts.scanner = scanner
+	key := ts.login("ada@a.org")
+	rec := newWebhookReceiver(t)
+	rec.failing = false
+	a := Tenant{ORG_A}
+
+	code, out := ts.do(key, "POST", "/webhooks", "application/json", `{"url":"`+rec.URL+`","events":["journal.approved"]}`)
+	if code != http.StatusBadRequest || out["data"].(map[string]interface{})["events"] == nil {
+		t.Fatal(code, out)
+	}
+	code, out = ts.do(key, "POST", "/webhooks", "application/json",
+		`{"url":"`+rec.URL+`","events":["journal.submitted","resource.uploaded"]}`)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+
+	// received returns the event and data of the n-th request, waiting for it
+	received := func(n int) (string, map[string]interface{}) {
+		t.Helper()
+		waitFor(t, "request "+strconv.Itoa(n), func() bool { return rec.count() >= n })
+		rec.Lock()
+		defer rec.Unlock()
+		var payload struct {
+			Event string
+			Data  map[string]interface{}
+		}
+		check(t, json.Unmarshal(rec.bodies[n-1], &payload))
+		return payload.Event, payload.Data
+	}
+	resourceName := func(data map[string]interface{}) interface{} {
+		resource, _ := data["resource"].(map[string]interface{})
+		return resource["resource_name"]
+	}
+
+	projectId, err := ts.store.AddProject(a, Project{ProjectName: "Radio"})
+	check(t, err)
+	upload := func(name string) {
+		t.Helper()
+		if code, out := ts.upload(key, "/projects/"+projectId+"/resource_uploadfile", "resource_file", name, "notes"); code/100 != 2 {
+			t.Fatal(code, out)
+		}
+	}
+	upload("minutes.txt")
+	if event, data := received(1); event != EVENT_RESOURCE_UPLOADED || resourceName(data) != "minutes.txt" {
+		t.Fatal(event, data)
+	}
+
+	// a file held back by the scanner is posted once a rescan passes it
+	scanner.down = true
+	upload("agenda.txt")
+	scanner.down = false
+	check(t, ts.rescanPending(time.Now()))
+	event, data := received(2)
+	if version, _ := data["version"].(map[string]interface{}); event != EVENT_RESOURCE_UPLOADED ||
+		resourceName(data) != "agenda.txt" || version["scan_status"] != SCAN_CLEAN {
+		t.Fatal(event, data)
+	}
+
+	partnerId, err := ts.store.AddBoundaryPartner(a, projectId, BoundaryPartner{PartnerName: "Councils"})
+	check(t, err)
+	journalId, err := ts.store.AddJournal(a, ADMIN_A, OutcomeJournal{ProjectId: projectId, BoundaryPartnerId: partnerId,
+		MonitoringDate: "2017-06-30"})
+	check(t, err)
+	if code, out := ts.do(key, "POST", "/projects/"+projectId+"/journals/"+journalId+"/submit", "", ""); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	event, data = received(3)
+	if journal, _ := data["journal"].(map[string]interface{}); event != EVENT_JOURNAL_SUBMITTED ||
+		journal["journal_id"] != journalId || journal["status"] != JOURNAL_SUBMITTED {
+		t.Fatal(event, data)
+	}
+	if rec.count() != 3 {
+		t.Fatal("posted more than the three events", rec.count())
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const XLSX_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// largest part of an XLSX file read once uncompressed, so a small upload can
+// not expand into a large amount of memory
+const MAX_XLSX_PART_SIZE = 32 << 20
+
+// most rows and columns read from a sheet, the header included
+const (
+	MAX_SHEET_ROWS    = 5000
+	MAX_SHEET_COLUMNS = 50
+)
+
+// sheet is a table of cells read from or written to a spreadsheet.
+type sheet struct {
+	Name string
+	Rows [][]string
+}
+
+// writeXLSX writes the sheets as an XLSX workbook. The first row of every
+// sheet is set in bold. Cells holding integers are written as numbers, all
+// other cells as inline strings.
+func writeXLSX(w io.Writer, sheets []sheet) error {
+	zw := zip.NewWriter(w)
+	modified := time.Now().UTC()
+	add := func(name, content string) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, xml.Header+content)
+		return err
+	}
+
+	var types, entries, rels strings.Builder
+	for i, sh := range sheets {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&entries, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sh.Name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(sheets)+1)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + entries.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels.String() + `</Relationships>`},
+		{"xl/styles.xml", `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+	for _, part := range parts {
+		if err := add(part.name, part.content); err != nil {
+			return err
+		}
+	}
+	for i, sh := range sheets {
+		if err := add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), worksheetXML(sh)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// worksheetXML returns the worksheet part of a sheet, with the header row
+// frozen and the columns sized to their content.
+func worksheetXML(sh sheet) string {
+	var b strings.Builder
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+
+	var widths []int
+	for _, row := range sh.Rows {
+		for j, value := range row {
+			if j == len(widths) {
+				widths = append(widths, 8)
+			}
+			if n := utf8.RuneCountInString(value) + 2; n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+	if len(widths) > 0 {
+		b.WriteString(`<cols>`)
+		for j, width := range widths {
+			if width > 60 {
+				width = 60
+			}
+			fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, j+1, j+1, width)
+		}
+		b.WriteString(`</cols>`)
+	}
+
+	b.WriteString(`<sheetData>`)
+	for i, row := range sh.Rows {
+		style := ""
+		if i == 0 {
+			style = ` s="1"`
+		}
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, value := range row {
+			ref := columnName(j) + strconv.Itoa(i+1)
+			if value == "" {
+				continue
+			}
+			if isInteger(value) {
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%s</v></c>`, ref, style, value)
+			} else {
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(value))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// isInteger reports whether a cell is written as a number. Leading zeros are
+// kept by writing such cells as strings.
+func isInteger(value string) bool {
+	n, err := strconv.Atoi(value)
+	return err == nil && strconv.Itoa(n) == value && len(value) < 16
+}
+
+func xmlEscape(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		// characters XML 1.0 can not hold are dropped
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xfffe || r == 0xffff {
+			continue
+		}
+		xml.EscapeText(&b, []byte(string(r)))
+	}
+	return b.String()
+}
+
+// columnName returns the letters of a zero-based column index, like "AB".
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// columnIndex returns the zero-based column of a cell reference like "AB12",
+// or -1 if it has no column letters.
+func columnIndex(ref string) int {
+	index := 0
+	for i := 0; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		index = index*26 + int(ref[i]-'A') + 1
+		if index > MAX_SHEET_COLUMNS {
+			return MAX_SHEET_COLUMNS
+		}
+	}
+	return index - 1
+}
+
+var errTooManyRows = fmt.Errorf("must have at most %d rows in a sheet", MAX_SHEET_ROWS)
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		Id   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxText is the text of a shared or inline string, either plain or as
+// runs of rich text.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	text := t.T
+	for _, run := range t.Runs {
+		text += run.T
+	}
+	return text
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string   `xml:"r,attr"`
+			T      string   `xml:"t,attr"`
+			V      string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reads the sheets of an XLSX workbook as text. Numbers are
+// formatted without exponent, formulas are read as their cached value.
+func readXLSX(r io.ReaderAt, size int64) ([]sheet, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.New("is not a valid XLSX file")
+	}
+	parts := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		parts[f.Name] = f
+	}
+	decode := func(name string, v interface{}) error {
+		f, ok := parts[name]
+		if !ok {
+			return fmt.Errorf("is not a valid XLSX file, %s is missing", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(io.LimitReader(rc, MAX_XLSX_PART_SIZE+1))
+		if err != nil {
+			return fmt.Errorf("is not a valid XLSX file, %s can not be read", name)
+		}
+		if len(data) > MAX_XLSX_PART_SIZE {
+			return fmt.Errorf("is too large, %s is over %s", name, formatBytes(MAX_XLSX_PART_SIZE))
+		}
+		if err = xml.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("is not a valid XLSX file, %s can not be parsed", name)
+		}
+		return nil
+	}
+
+	var workbook xlsxWorkbook
+	if err = decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err = decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.Id] = target
+	}
+	var shared xlsxSharedStrings
+	if _, ok := parts["xl/sharedStrings.xml"]; ok {
+		if err = decode("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	var sheets []sheet
+	for _, entry := range workbook.Sheets {
+		var ws xlsxWorksheet
+		if err = decode(targets[entry.Id], &ws); err != nil {
+			return nil, err
+		}
+		sh := sheet{Name: entry.Name}
+		for _, row := range ws.Rows {
+			number := row.R
+			if number == 0 {
+				number = len(sh.Rows) + 1
+			}
+			if number > MAX_SHEET_ROWS {
+				return nil, errTooManyRows
+			}
+			for len(sh.Rows) < number {
+				sh.Rows = append(sh.Rows, nil)
+			}
+			var cells []string
+			for _, c := range row.Cells {
+				column := len(cells)
+				if c.R != "" {
+					column = columnIndex(c.R)
+				}
+				if column < 0 || column >= MAX_SHEET_COLUMNS {
+					continue
+				}
+				for len(cells) <= column {
+					cells = append(cells, "")
+				}
+				switch c.T {
+				case "s":
+					i, err := strconv.Atoi(c.V)
+					if err != nil || i < 0 || i >= len(shared.Items) {
+						return nil, errors.New("is not a valid XLSX file, a cell refers to a missing shared string")
+					}
+					cells[column] = shared.Items[i].String()
+				case "inlineStr":
+					cells[column] = c.Inline.String()
+				case "b":
+					cells[column] = map[string]string{"1": "TRUE", "0": "FALSE"}[c.V]
+				case "", "n":
+					if f, err := strconv.ParseFloat(c.V, 64); err == nil {
+						cells[column] = strconv.FormatFloat(f, 'f', -1, 64)
+					} else {
+						cells[column] = c.V
+					}
+				default:
+					cells[column] = c.V
+				}
+			}
+			sh.Rows[number-1] = cells
+		}
+		sheets = append(sheets, sh)
+	}
+	return sheets, nil
+}